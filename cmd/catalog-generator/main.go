@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/piligrim/pushkinlib/internal/catalog"
+	"github.com/piligrim/pushkinlib/internal/genres"
+	"github.com/piligrim/pushkinlib/internal/metadata"
 )
 
 func main() {
@@ -19,7 +21,16 @@ func main() {
 		catalogName    = flag.String("name", "generated_catalog", "Name of the catalog")
 		archivePrefix  = flag.String("prefix", "books", "Prefix for generated ZIP archives")
 		maxBooks       = flag.Int("max-books", 1000, "Maximum books per ZIP archive")
+		layout         = flag.String("layout", catalog.LayoutSize, "Archive layout: size (numbered archives), genre (per main genre) or author (per first letter of the author)")
 		includeFormats = flag.String("formats", ".fb2,.zip,.epub", "Comma-separated list of file formats to include")
+		dryRun         = flag.Bool("dry-run", false, "Scan and extract metadata without writing archives or INPX")
+		reportPath     = flag.String("report", "", "Write generation result as JSON to this file")
+		reference      = flag.Bool("reference", false, "Index existing ZIP archives in place and write only the INPX")
+		calibreMode    = flag.Bool("calibre", false, "Read the books from the metadata.db of the Calibre library in -books; the first of -formats a book has keeps its Calibre ID")
+		aliasesPath    = flag.String("genre-aliases", "", "CSV with alias and code columns mapping Calibre tags to genre codes, for -calibre")
+		strict         = flag.Bool("strict", false, "Skip FB2 files that are malformed, miss required description fields or use unknown genre codes")
+		validate       = flag.Bool("validate", false, "Only validate FB2 files and list problem files; exit code 1 if any")
+		genresPath     = flag.String("genres", "", "Genre CSV adding valid genre codes to the built-in FB2 genres for -strict and -validate")
 		help           = flag.Bool("help", false, "Show help message")
 	)
 
@@ -34,6 +45,9 @@ func main() {
 	if _, err := os.Stat(*booksDir); os.IsNotExist(err) {
 		log.Fatalf("Books directory does not exist: %s", *booksDir)
 	}
+	if !catalog.IsValidLayout(*layout) {
+		log.Fatalf("Unknown archive layout %q, want one of: %s", *layout, strings.Join(catalog.Layouts, ", "))
+	}
 
 	// Parse formats
 	formats := strings.Split(*includeFormats, ",")
@@ -44,6 +58,24 @@ func main() {
 		}
 	}
 
+	var genreCodes map[string]bool
+	if *strict || *validate {
+		list, err := genres.Load(*genresPath)
+		if err != nil {
+			log.Fatalf("Failed to load genre codes: %v", err)
+		}
+		genreCodes = genres.Codes(list)
+	}
+
+	var genreAliases map[string]string
+	if *aliasesPath != "" {
+		aliases, err := metadata.LoadGenreAliases(*aliasesPath)
+		if err != nil {
+			log.Fatalf("Failed to load genre aliases: %v", err)
+		}
+		genreAliases = aliases
+	}
+
 	// Create generator
 	generator := catalog.NewGenerator()
 
@@ -55,6 +87,17 @@ func main() {
 		ArchivePrefix:  *archivePrefix,
 		MaxBooksPerZip: *maxBooks,
 		IncludeFormats: formats,
+		Layout:         *layout,
+		DryRun:         *dryRun,
+		ReferenceMode:  *reference,
+		Strict:         *strict,
+		GenreCodes:     genreCodes,
+		Calibre:        *calibreMode,
+		GenreAliases:   genreAliases,
+	}
+
+	if *validate {
+		os.Exit(runValidate(generator, opts, *reportPath))
 	}
 
 	// Show configuration
@@ -64,7 +107,22 @@ func main() {
 	fmt.Printf("Catalog name: %s\n", opts.CatalogName)
 	fmt.Printf("Archive prefix: %s\n", opts.ArchivePrefix)
 	fmt.Printf("Max books per archive: %d\n", opts.MaxBooksPerZip)
+	if !opts.ReferenceMode {
+		fmt.Printf("Archive layout: %s\n", opts.Layout)
+	}
 	fmt.Printf("Include formats: %s\n", strings.Join(opts.IncludeFormats, ", "))
+	if opts.ReferenceMode {
+		fmt.Println("Mode: reference (existing archives are indexed in place)")
+	}
+	if opts.Calibre {
+		fmt.Println("Mode: Calibre (books are read from metadata.db)")
+	}
+	if opts.DryRun {
+		fmt.Println("Mode: dry run (no files will be written)")
+	}
+	if opts.Strict {
+		fmt.Println("Mode: strict (invalid FB2 files are skipped)")
+	}
 	fmt.Println()
 
 	// Generate catalog
@@ -73,14 +131,27 @@ func main() {
 		log.Fatalf("Failed to generate catalog: %v", err)
 	}
 
+	if *reportPath != "" {
+		if err := result.WriteReport(*reportPath); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		fmt.Printf("Report written to %s\n", *reportPath)
+	}
+
 	// Show results
 	fmt.Println("=== Generation Results ===")
 	fmt.Printf("Total books found: %d\n", result.TotalBooks)
 	fmt.Printf("Successfully processed: %d\n", result.ProcessedBooks)
 	fmt.Printf("Skipped (errors): %d\n", result.SkippedBooks)
-	fmt.Printf("Generated archives: %d\n", len(result.GeneratedZips))
+	if result.ReferencedZips != nil {
+		fmt.Printf("Referenced archives: %d\n", len(result.ReferencedZips))
+	} else {
+		fmt.Printf("Generated archives: %d\n", len(result.GeneratedZips))
+	}
 	fmt.Printf("Processing time: %v\n", result.ProcessingTime)
-	fmt.Printf("INPX file: %s\n", result.INPXPath)
+	if !result.DryRun {
+		fmt.Printf("INPX file: %s\n", result.INPXPath)
+	}
 	fmt.Println()
 
 	if len(result.GeneratedZips) > 0 {
@@ -91,6 +162,12 @@ func main() {
 		fmt.Println()
 	}
 
+	if result.DryRun {
+		printErrors(result.Errors)
+		fmt.Println("✅ Dry run completed, no files were written")
+		return
+	}
+
 	// Show collection info
 	fmt.Println("=== Collection Info ===")
 	fmt.Printf("Name: %s\n", result.CollectionInfo.Name)
@@ -100,24 +177,20 @@ func main() {
 	fmt.Println()
 
 	// Show errors if any
-	if len(result.Errors) > 0 {
-		fmt.Printf("=== Errors (%d) ===\n", len(result.Errors))
-		for i, err := range result.Errors {
-			if i < 10 { // Show only first 10 errors
-				fmt.Printf("  %d. %v\n", i+1, err)
-			}
-		}
-		if len(result.Errors) > 10 {
-			fmt.Printf("  ... and %d more errors\n", len(result.Errors)-10)
-		}
-		fmt.Println()
-	}
+	printErrors(result.Errors)
 
 	// Usage instructions
 	fmt.Println("=== Usage Instructions ===")
 	fmt.Printf("1. Copy the generated INPX file to your server:\n")
 	fmt.Printf("   cp %s /path/to/your/server/\n", result.INPXPath)
 	fmt.Println()
+	if opts.ReferenceMode {
+		fmt.Printf("2. Point BOOKS_DIR at the existing archives and update your .env file:\n")
+		fmt.Printf("   INPX_PATH=/path/to/%s\n", filepath.Base(result.INPXPath))
+		fmt.Printf("   BOOKS_DIR=%s\n", opts.BooksDir)
+		fmt.Println("\n✅ Catalog generation completed successfully!")
+		return
+	}
 	fmt.Printf("2. Copy the generated archives to your books directory:\n")
 	for _, zipPath := range result.GeneratedZips {
 		fmt.Printf("   cp %s /path/to/your/books/\n", zipPath)
@@ -139,6 +212,63 @@ func main() {
 	fmt.Println("\n✅ Catalog generation completed successfully!")
 }
 
+// printErrors shows the first per-file errors of a generation run
+func printErrors(errs []catalog.FileError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	fmt.Printf("=== Errors (%d) ===\n", len(errs))
+	for i, err := range errs {
+		if i < 10 { // Show only first 10 errors
+			fmt.Printf("  %d. %v\n", i+1, err)
+		}
+	}
+	if len(errs) > 10 {
+		fmt.Printf("  ... and %d more errors\n", len(errs)-10)
+	}
+	fmt.Println()
+}
+
+// runValidate validates the books and prints the problem files; it returns
+// the process exit code
+func runValidate(generator *catalog.Generator, opts catalog.GenerateOptions, reportPath string) int {
+	fmt.Println("=== FB2 Validation ===")
+	if len(opts.GenreCodes) == 0 {
+		fmt.Println("Genre codes are not checked (no genre list)")
+	}
+
+	report, err := generator.Validate(opts)
+	if err != nil {
+		log.Printf("Failed to validate books: %v", err)
+		return 2
+	}
+
+	if reportPath != "" {
+		if err := report.WriteReport(reportPath); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return 2
+		}
+		fmt.Printf("Report written to %s\n", reportPath)
+	}
+
+	lastPath := ""
+	for _, problem := range report.Problems {
+		if problem.Path != lastPath {
+			fmt.Println(problem.Path)
+			lastPath = problem.Path
+		}
+		fmt.Printf("  - %s\n", problem.Message)
+	}
+	fmt.Printf("Checked %d files in %v: %d with problems\n", report.CheckedFiles, report.ProcessingTime, report.InvalidFiles)
+
+	if report.InvalidFiles > 0 {
+		return 1
+	}
+	fmt.Println("✅ All files are valid")
+	return 0
+}
+
 func showHelp() {
 	fmt.Println("Catalog Generator - Creates INPX catalog from book files")
 	fmt.Println()
@@ -155,9 +285,21 @@ func showHelp() {
 	fmt.Println("  # Generate catalog with custom settings")
 	fmt.Println("  catalog-generator -books=/home/user/books -name=my_library -max-books=500")
 	fmt.Println()
+	fmt.Println("  # One set of archives per first letter of the author, as in librusec")
+	fmt.Println("  catalog-generator -layout=author")
+	fmt.Println()
 	fmt.Println("  # Include only FB2 files")
 	fmt.Println("  catalog-generator -formats=.fb2")
 	fmt.Println()
+	fmt.Println("  # Build only the INPX for books already packed in numbered ZIPs")
+	fmt.Println("  catalog-generator -books=/library/archives -reference -name=my_library")
+	fmt.Println()
+	fmt.Println("  # Check a library without writing files, save a JSON report")
+	fmt.Println("  catalog-generator -dry-run -report=result.json")
+	fmt.Println()
+	fmt.Println("  # List FB2 files with broken XML, missing fields or unknown genres")
+	fmt.Println("  catalog-generator -validate -report=validation.json")
+	fmt.Println()
 	fmt.Println("Supported formats:")
 	fmt.Println("  .fb2  - FictionBook 2.0 files")
 	fmt.Println("  .zip  - ZIP archives containing FB2 files")
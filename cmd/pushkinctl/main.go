@@ -0,0 +1,437 @@
+// Command pushkinctl manages a Pushkinlib server over its HTTP API, so that
+// headless servers can be administered over SSH without curl.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionCookie is the name of the session cookie set by the server
+const sessionCookie = "pushkinlib_session"
+
+// errUsage reports a command line that does not match any command
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	fs := flag.NewFlagSet("pushkinctl", flag.ExitOnError)
+	var (
+		serverURL = fs.String("url", envOrDefault("PUSHKINCTL_URL", "http://localhost:9090"), "Server URL (env PUSHKINCTL_URL)")
+		token     = fs.String("token", os.Getenv("PUSHKINCTL_TOKEN"), "Session token (env PUSHKINCTL_TOKEN); defaults to the one saved by login")
+		jsonOut   = fs.Bool("json", false, "Print API responses as JSON")
+	)
+	fs.Usage = func() { usage(fs) }
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		usage(fs)
+		os.Exit(2)
+	}
+	if *token == "" {
+		*token = readToken()
+	}
+
+	ctl := &ctl{client: newClient(*serverURL, *token), json: *jsonOut}
+	if err := ctl.run(fs.Arg(0), fs.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			usage(fs)
+			os.Exit(2)
+		}
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			err = fmt.Errorf("%w; run pushkinctl login", err)
+		}
+		fmt.Fprintf(os.Stderr, "pushkinctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintln(out, "Usage: pushkinctl [flags] command [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  login -user NAME [-password PASSWORD]   sign in and save the session token")
+	fmt.Fprintln(out, "  logout                                  end the session and forget the token")
+	fmt.Fprintln(out, "  reindex [-watch]                        start a reindex, optionally waiting for it")
+	fmt.Fprintln(out, "  status [-watch]                         show the state of the current or last reindex")
+	fmt.Fprintln(out, "  stats                                   show library statistics")
+	fmt.Fprintln(out, "  users                                   list users")
+	fmt.Fprintln(out, "  users add [-admin] [-name NAME] USER PASSWORD")
+	fmt.Fprintln(out, "  users delete USER")
+	fmt.Fprintln(out, "  users password USER PASSWORD")
+	fmt.Fprintln(out, "  users roles USER [ROLE...]              replace the roles of a user")
+	fmt.Fprintln(out, "  shelves                                 list your shelves")
+	fmt.Fprintln(out, "  shelves create NAME")
+	fmt.Fprintln(out, "  shelves delete SHELF")
+	fmt.Fprintln(out, "  shelves add SHELF BOOK")
+	fmt.Fprintln(out, "  shelves remove SHELF BOOK")
+	fmt.Fprintln(out, "  cache                                   show conversion cache statistics")
+	fmt.Fprintln(out, "  cache purge                             empty the conversion and query caches")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	fs.PrintDefaults()
+}
+
+// ctl runs the commands of pushkinctl
+type ctl struct {
+	client *client
+	json   bool
+}
+
+func (c *ctl) run(command string, args []string) error {
+	switch command {
+	case "login":
+		return c.login(args)
+	case "logout":
+		return c.logout()
+	case "reindex":
+		return c.reindex(args, true)
+	case "status":
+		return c.reindex(args, false)
+	case "stats":
+		return c.show("/api/v1/admin/stats")
+	case "users":
+		return c.users(args)
+	case "shelves":
+		return c.shelves(args)
+	case "cache":
+		return c.cache(args)
+	default:
+		return errUsage
+	}
+}
+
+// login signs in and saves the session token for later commands
+func (c *ctl) login(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	user := fs.String("user", "", "User name")
+	password := fs.String("password", os.Getenv("PUSHKINCTL_PASSWORD"), "Password (env PUSHKINCTL_PASSWORD); read from standard input when empty")
+	fs.Parse(args)
+	if *user == "" {
+		return fmt.Errorf("login: -user is required")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("login: failed to read password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	token, err := c.client.login(*user, *password)
+	if err != nil {
+		return err
+	}
+	path, err := saveToken(token)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Logged in as %s, token saved to %s\n", *user, path)
+	return nil
+}
+
+// logout ends the session and removes the saved token
+func (c *ctl) logout() error {
+	if err := c.client.do(http.MethodPost, "/api/v1/auth/logout", nil, nil); err != nil {
+		return err
+	}
+	if path, err := tokenPath(); err == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+// reindexStatus is the state of a reindex reported by the server
+type reindexStatus struct {
+	Running    bool                   `json:"running"`
+	JobID      string                 `json:"job_id,omitempty"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// reindex starts a reindex when start is set, or only reads its state,
+// and with -watch polls the state until the reindex ends
+func (c *ctl) reindex(args []string, start bool) error {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	watch := fs.Bool("watch", false, "Wait for the reindex to finish, printing its progress")
+	interval := fs.Duration("interval", 2*time.Second, "Polling interval for -watch")
+	fs.Parse(args)
+
+	var status reindexStatus
+	var err error
+	if start {
+		err = c.client.do(http.MethodPost, "/api/v1/admin/reindex/start", nil, &status)
+	} else {
+		err = c.client.do(http.MethodGet, "/api/v1/admin/reindex/status", nil, &status)
+	}
+	if err != nil {
+		return err
+	}
+
+	for *watch && status.Running {
+		if !c.json {
+			fmt.Printf("Reindex %s running for %s\n", status.JobID, time.Since(*status.StartedAt).Truncate(time.Second))
+		}
+		time.Sleep(*interval)
+		if err := c.client.do(http.MethodGet, "/api/v1/admin/reindex/status", nil, &status); err != nil {
+			return err
+		}
+	}
+
+	if c.json {
+		return printJSON(status)
+	}
+	switch {
+	case status.Running:
+		fmt.Printf("Reindex %s started at %s\n", status.JobID, status.StartedAt.Format(time.RFC3339))
+	case status.FinishedAt == nil:
+		fmt.Println("No reindex has run since the server started")
+	default:
+		fmt.Printf("Reindex %s finished at %s\n", status.JobID, status.FinishedAt.Format(time.RFC3339))
+		for _, key := range sortedKeys(status.Result) {
+			fmt.Printf("  %s: %v\n", key, status.Result[key])
+		}
+	}
+	if status.Error != "" {
+		return fmt.Errorf("reindex failed: %s", status.Error)
+	}
+	return nil
+}
+
+// userInfo is a user as listed by the server
+type userInfo struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	IsAdmin     bool   `json:"is_admin"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (c *ctl) users(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		var users []userInfo
+		if err := c.client.do(http.MethodGet, "/api/v1/admin/users", nil, &users); err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(users)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSER\tNAME\tADMIN\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\n", u.ID, u.Username, u.DisplayName, u.IsAdmin, u.CreatedAt)
+		}
+		return tw.Flush()
+	case "add":
+		fs := flag.NewFlagSet("users add", flag.ExitOnError)
+		admin := fs.Bool("admin", false, "Give the user admin rights")
+		name := fs.String("name", "", "Display name")
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			return errUsage
+		}
+		body := map[string]interface{}{
+			"username": fs.Arg(0), "password": fs.Arg(1), "display_name": *name, "is_admin": *admin,
+		}
+		return c.change(http.MethodPost, "/api/v1/admin/users", body)
+	case "delete", "password", "roles":
+		if len(args) < 2 || args[0] == "password" && len(args) != 3 || args[0] == "delete" && len(args) != 2 {
+			return errUsage
+		}
+		id, err := c.userID(args[1])
+		if err != nil {
+			return err
+		}
+		path := "/api/v1/admin/users/" + url.PathEscape(id)
+		switch args[0] {
+		case "delete":
+			return c.change(http.MethodDelete, path, nil)
+		case "password":
+			return c.change(http.MethodPut, path+"/password", map[string]string{"password": args[2]})
+		default:
+			return c.change(http.MethodPut, path+"/roles", map[string][]string{"roles": append([]string{}, args[2:]...)})
+		}
+	default:
+		return errUsage
+	}
+}
+
+// userID resolves a user name, or an ID, to the ID of the user
+func (c *ctl) userID(user string) (string, error) {
+	var users []userInfo
+	if err := c.client.do(http.MethodGet, "/api/v1/admin/users", nil, &users); err != nil {
+		return "", err
+	}
+	for _, u := range users {
+		if u.Username == user || u.ID == user {
+			return u.ID, nil
+		}
+	}
+	return "", fmt.Errorf("user %q not found", user)
+}
+
+// shelfInfo is a shelf as listed by the server
+type shelfInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	BookCount int       `json:"book_count"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *ctl) shelves(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		var resp struct {
+			Shelves []shelfInfo `json:"shelves"`
+		}
+		if err := c.client.do(http.MethodGet, "/api/v1/shelves", nil, &resp); err != nil {
+			return err
+		}
+		if c.json {
+			return printJSON(resp.Shelves)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tBOOKS\tUPDATED")
+		for _, s := range resp.Shelves {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", s.ID, s.Name, s.BookCount, s.UpdatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case args[0] == "create" && len(args) == 2:
+		return c.change(http.MethodPost, "/api/v1/shelves", map[string]string{"name": args[1]})
+	case args[0] == "delete" && len(args) == 2:
+		return c.change(http.MethodDelete, "/api/v1/shelves/"+url.PathEscape(args[1]), nil)
+	case args[0] == "add" && len(args) == 3:
+		return c.change(http.MethodPut, "/api/v1/shelves/"+url.PathEscape(args[1])+"/books/"+url.PathEscape(args[2]), nil)
+	case args[0] == "remove" && len(args) == 3:
+		return c.change(http.MethodDelete, "/api/v1/shelves/"+url.PathEscape(args[1])+"/books/"+url.PathEscape(args[2]), nil)
+	default:
+		return errUsage
+	}
+}
+
+func (c *ctl) cache(args []string) error {
+	switch {
+	case len(args) == 0:
+		return c.show("/api/v1/admin/cache")
+	case args[0] == "purge" && len(args) == 1:
+		// Every admin change drops the catalog query cache, so only the
+		// conversion cache may be missing
+		err := c.change(http.MethodDelete, "/api/v1/admin/cache", nil)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusServiceUnavailable {
+			fmt.Println("Query cache purged; the conversion cache is not configured")
+			return nil
+		}
+		return err
+	default:
+		return errUsage
+	}
+}
+
+// show prints the JSON response to a GET of path
+func (c *ctl) show(path string) error {
+	var resp interface{}
+	if err := c.client.do(http.MethodGet, path, nil, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+// change sends a request that changes the server and prints its response
+// with -json, or "OK"
+func (c *ctl) change(method, path string, body interface{}) error {
+	var resp interface{}
+	if err := c.client.do(method, path, body, &resp); err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(resp)
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// tokenPath is the file login saves the session token to
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pushkinctl", "token"), nil
+}
+
+// readToken returns the saved session token, or "" if there is none
+func readToken() string {
+	path, err := tokenPath()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveToken saves a session token readable only by the current user
+func saveToken(token string) (string, error) {
+	path, err := tokenPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to save token: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("failed to save token: %w", err)
+	}
+	return path, nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/catalog"
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/indexer"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runBootstrap implements `pushkinlib bootstrap`: given a directory of book
+// files it generates archives and an INPX in BOOKS_DIR, imports them into the
+// database and starts the server. On later runs the catalog is regenerated
+// only when the books directory changed after the INPX was written.
+func runBootstrap(args []string) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	var (
+		booksDir = fs.String("books", "", "Directory with book files (FB2, ZIP, EPUB); it is only read")
+		name     = fs.String("name", "library", "Catalog name; the INPX is written to BOOKS_DIR/<name>.inpx")
+		formats  = fs.String("formats", ".fb2,.zip,.epub", "Comma-separated list of file formats to include")
+		maxBooks = fs.Int("max-books", 1000, "Maximum books per ZIP archive")
+		force    = fs.Bool("force", false, "Regenerate the catalog even if the books directory is unchanged")
+	)
+	fs.Parse(args)
+
+	if *booksDir == "" {
+		fmt.Fprintln(os.Stderr, "bootstrap: -books is required")
+		fs.Usage()
+		return 2
+	}
+	if info, err := os.Stat(*booksDir); err != nil || !info.IsDir() {
+		log.Printf("Books directory does not exist: %s", *booksDir)
+		return 2
+	}
+
+	cfg := config.LoadConfig()
+	if overlaps(*booksDir, cfg.BooksDir) {
+		fmt.Fprintf(os.Stderr, "bootstrap: -books must not contain BOOKS_DIR (%s), where the archives are written\n", cfg.BooksDir)
+		return 2
+	}
+	cfg.INPXPath = filepath.Join(cfg.BooksDir, *name+".inpx")
+
+	stale, err := catalogStale(*booksDir, cfg.INPXPath)
+	if err != nil {
+		log.Printf("Bootstrap: %v", err)
+		return 1
+	}
+	if stale || *force {
+		if err := generateLibrary(*booksDir, cfg.BooksDir, *name, parseFormats(*formats), *maxBooks); err != nil {
+			log.Printf("Bootstrap: %v", err)
+			return 1
+		}
+		if err := importLibrary(cfg); err != nil {
+			log.Printf("Bootstrap: %v", err)
+			return 1
+		}
+	} else {
+		fmt.Printf("Catalog %s is up to date with %s\n", cfg.INPXPath, *booksDir)
+	}
+
+	runServer(cfg)
+	return 0
+}
+
+// overlaps reports whether dir is libraryDir or one of its parents, so that
+// scanning it would pick up the generated archives
+func overlaps(dir, libraryDir string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absLibrary, err := filepath.Abs(libraryDir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absLibrary)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// catalogStale reports whether the INPX is missing or older than any file or
+// directory under booksDir. Directory times cover deleted and renamed books.
+func catalogStale(booksDir, inpxPath string) (bool, error) {
+	inpx, err := os.Stat(inpxPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", inpxPath, err)
+	}
+
+	stale := false
+	err = filepath.WalkDir(booksDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(inpx.ModTime()) {
+			stale = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to scan %s: %w", booksDir, err)
+	}
+	return stale, nil
+}
+
+// generateLibrary replaces the archives and INPX of a previous bootstrap in
+// libraryDir with a catalog generated from booksDir
+func generateLibrary(booksDir, libraryDir, name string, formats []string, maxBooks int) error {
+	if err := removeLibrary(libraryDir, name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generating catalog %q from %s into %s\n", name, booksDir, libraryDir)
+	result, err := catalog.NewGenerator().Generate(catalog.GenerateOptions{
+		BooksDir:       booksDir,
+		OutputDir:      libraryDir,
+		CatalogName:    name,
+		ArchivePrefix:  name,
+		MaxBooksPerZip: maxBooks,
+		IncludeFormats: formats,
+	})
+	if err != nil {
+		return err
+	}
+	if result.ProcessedBooks == 0 {
+		return fmt.Errorf("no books found in %s", booksDir)
+	}
+	printErrors(result.Errors)
+	return nil
+}
+
+// removeLibrary removes the archives and INPX of the catalog name generated
+// into libraryDir, creating libraryDir if needed
+func removeLibrary(libraryDir, name string) error {
+	if err := os.MkdirAll(libraryDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", libraryDir, err)
+	}
+
+	entries, err := os.ReadDir(libraryDir)
+	if err != nil {
+		return err
+	}
+	// The INPX goes first so that an interrupted run is redone on the next start
+	paths := []string{filepath.Join(libraryDir, name+".inpx")}
+	for _, entry := range entries {
+		if !entry.IsDir() && isLibraryArchive(entry.Name(), name) {
+			paths = append(paths, filepath.Join(libraryDir, entry.Name()))
+		}
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// isLibraryArchive reports whether file is named like the archives generated
+// for the catalog name, <name>-000001.zip, so that the archives of a catalog
+// such as <name>-old are left alone
+func isLibraryArchive(file, name string) bool {
+	num, ok := strings.CutPrefix(file, name+"-")
+	if !ok {
+		return false
+	}
+	num, ok = strings.CutSuffix(num, ".zip")
+	if !ok || len(num) < 6 {
+		return false
+	}
+	for _, r := range num {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// importLibrary replaces the database contents with the generated INPX
+func importLibrary(cfg *config.Config) error {
+	db, err := storage.NewExclusiveDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	if err := applyFTSTokenizer(db, cfg); err != nil {
+		return err
+	}
+
+	repo := storage.NewRepository(db)
+	repo.SetSearchSuggestionsEnabled(cfg.SearchSuggestionsEnabled)
+	repo.SetSyncEnabled(cfg.SyncEnabled)
+	repo.SetDeferredFTS(cfg.ImportDeferredFTS)
+	repo.SetLanguageDetection(cfg.LanguageDetectionEnabled)
+	applyGenreMapping(repo, cfg)
+
+	result, err := indexer.ReindexFromINPX(repo, cfg.INPXPath)
+	if err != nil {
+		return fmt.Errorf("failed to import INPX: %w", err)
+	}
+	fmt.Printf("Imported %d books in %s\n", result.Imported, result.Duration.Truncate(time.Millisecond))
+	return nil
+}
+
+// parseFormats normalizes a comma-separated list of file extensions
+func parseFormats(list string) []string {
+	var formats []string
+	for _, format := range strings.Split(list, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if !strings.HasPrefix(format, ".") {
+			format = "." + format
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// printErrors shows the first files the generator could not read
+func printErrors(errs []catalog.FileError) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d files:\n", len(errs))
+	for i, err := range errs {
+		if i == 10 {
+			fmt.Printf("  ... and %d more\n", len(errs)-10)
+			break
+		}
+		fmt.Printf("  %v\n", err)
+	}
+}
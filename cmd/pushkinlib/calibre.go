@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/piligrim/pushkinlib/internal/calibre"
+	"github.com/piligrim/pushkinlib/internal/catalog"
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/metadata"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runImportCalibre implements `pushkinlib import-calibre`: it reads the
+// metadata.db of a Calibre library, packs the files of the books into
+// archives with an INPX in BOOKS_DIR, replaces the database contents with
+// them and stores the Calibre covers. Books keep their Calibre IDs.
+func runImportCalibre(args []string) int {
+	fs := flag.NewFlagSet("import-calibre", flag.ExitOnError)
+	var (
+		libraryDir = fs.String("library", "", "Calibre library directory, the one with metadata.db; it is only read")
+		name       = fs.String("name", "calibre", "Catalog name; the INPX is written to BOOKS_DIR/<name>.inpx")
+		formats    = fs.String("formats", ".fb2,.epub,.pdf,.djvu,.mobi,.azw3", "Comma-separated list of formats to import; the first a book has keeps its Calibre ID")
+		maxBooks   = fs.Int("max-books", 1000, "Maximum books per ZIP archive")
+	)
+	fs.Parse(args)
+
+	if *libraryDir == "" {
+		fmt.Fprintln(os.Stderr, "import-calibre: -library is required")
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(filepath.Join(*libraryDir, calibre.MetadataFile)); err != nil {
+		log.Printf("Not a Calibre library, %s is missing: %s", calibre.MetadataFile, *libraryDir)
+		return 2
+	}
+
+	cfg := config.LoadConfig()
+	if overlaps(*libraryDir, cfg.BooksDir) {
+		fmt.Fprintf(os.Stderr, "import-calibre: -library must not contain BOOKS_DIR (%s), where the archives are written\n", cfg.BooksDir)
+		return 2
+	}
+	cfg.INPXPath = filepath.Join(cfg.BooksDir, *name+".inpx")
+
+	var aliases map[string]string
+	if cfg.GenreAliasesPath != "" {
+		loaded, err := metadata.LoadGenreAliases(cfg.GenreAliasesPath)
+		if err != nil {
+			log.Printf("Warning: Calibre tags are not mapped to genres: %v", err)
+		}
+		aliases = loaded
+	}
+
+	result, err := generateCalibreLibrary(*libraryDir, cfg.BooksDir, *name, parseFormats(*formats), aliases, *maxBooks)
+	if err != nil {
+		log.Printf("Import: %v", err)
+		return 1
+	}
+	if err := importLibrary(cfg); err != nil {
+		log.Printf("Import: %v", err)
+		return 1
+	}
+	if err := importCalibreCovers(cfg, result.Covers); err != nil {
+		log.Printf("Import: %v", err)
+		return 1
+	}
+
+	fmt.Printf("Start the server with INPX_PATH=%s so that reindexing keeps this catalog\n", cfg.INPXPath)
+	return 0
+}
+
+// generateCalibreLibrary replaces the archives and INPX of a previous import
+// in booksDir with a catalog of the Calibre library, mapping its tags to
+// genre codes with aliases
+func generateCalibreLibrary(libraryDir, booksDir, name string, formats []string, aliases map[string]string, maxBooks int) (*catalog.GenerationResult, error) {
+	if err := removeLibrary(booksDir, name); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Generating catalog %q from Calibre library %s into %s\n", name, libraryDir, booksDir)
+	result, err := catalog.NewGenerator().Generate(catalog.GenerateOptions{
+		BooksDir:       libraryDir,
+		OutputDir:      booksDir,
+		CatalogName:    name,
+		ArchivePrefix:  name,
+		MaxBooksPerZip: maxBooks,
+		IncludeFormats: formats,
+		Calibre:        true,
+		GenreAliases:   aliases,
+	})
+	if err != nil {
+		return nil, err
+	}
+	printErrors(result.Errors)
+	if result.ProcessedBooks == 0 {
+		return nil, fmt.Errorf("no books with files in formats %v found in %s", formats, libraryDir)
+	}
+	return result, nil
+}
+
+// importCalibreCovers stores thumbnails of the Calibre covers of the
+// imported books. Covers that cannot be read are skipped; the cover job
+// may still find one in the book file.
+func importCalibreCovers(cfg *config.Config, paths map[string]string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	store, location, err := openCoverStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open cover store: %w", err)
+	}
+	db, err := storage.NewExclusiveDatabase(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	ids := make([]string, 0, len(paths))
+	for id := range paths {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	saved, failed := 0, 0
+	for _, id := range ids {
+		if err := importCover(store, repo, id, paths[id]); err != nil {
+			failed++
+			log.Printf("Import: cover of book %s: %v", id, err)
+			continue
+		}
+		saved++
+	}
+	fmt.Printf("Imported %d covers into %s (%d failed)\n", saved, location, failed)
+	return nil
+}
+
+// importCover stores a thumbnail of the image at path as the cover of a book
+func importCover(store *covers.Store, repo *storage.Repository, bookID, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	thumbnail, err := covers.MakeThumbnail(data)
+	if err != nil {
+		return err
+	}
+	if err := store.Save(bookID, thumbnail); err != nil {
+		return err
+	}
+	return repo.SetBookCover(bookID, covers.Hash(thumbnail))
+}
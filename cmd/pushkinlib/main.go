@@ -4,31 +4,67 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/piligrim/pushkinlib/internal/api"
 	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/blob"
 	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/convcache"
+	"github.com/piligrim/pushkinlib/internal/convert"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/daemon"
+	"github.com/piligrim/pushkinlib/internal/enrichment"
+	"github.com/piligrim/pushkinlib/internal/genres"
 	"github.com/piligrim/pushkinlib/internal/indexer"
+	"github.com/piligrim/pushkinlib/internal/metadata"
+	"github.com/piligrim/pushkinlib/internal/notifications"
 	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/sections"
 	"github.com/piligrim/pushkinlib/internal/storage"
+	"github.com/piligrim/pushkinlib/internal/upstream"
 )
 
 func main() {
-	cfg := config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "validate-opds" {
+		os.Exit(runValidateOPDS(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(runSync(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		os.Exit(runBootstrap(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-calibre" {
+		os.Exit(runImportCalibre(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-fts" {
+		os.Exit(runRebuildFTS(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		os.Exit(runSearch(os.Args[2:]))
+	}
 
+	runServer(config.LoadConfig())
+}
+
+// runServer imports the INPX into an empty database and serves the library
+// until interrupted.
+func runServer(cfg *config.Config) {
 	fmt.Printf("Pushkinlib starting...\n")
 	fmt.Printf("Port: %s\n", cfg.Port)
 	fmt.Printf("INPX Path: %s\n", cfg.INPXPath)
 	fmt.Printf("Database: %s\n", cfg.DatabasePath)
 
 	// Initialize database
-	db, err := storage.NewDatabase(cfg.DatabasePath)
+	db, err := openDatabase(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -36,6 +72,29 @@ func main() {
 
 	// Initialize repository
 	repo := storage.NewRepository(db)
+	repo.SetSearchSuggestionsEnabled(cfg.SearchSuggestionsEnabled)
+	repo.SetSearchFallback(cfg.SearchFallbackEnabled)
+	repo.SetSyncEnabled(cfg.SyncEnabled)
+	repo.SetDeferredFTS(cfg.ImportDeferredFTS)
+	applyGenreMapping(repo, cfg)
+	rankWeights, err := storage.ParseRankWeights(cfg.SearchRankWeights)
+	if err != nil {
+		log.Fatalf("Invalid SEARCH_RANK_WEIGHTS: %v", err)
+	}
+	repo.SetRankWeights(rankWeights)
+	repo.SetLanguageBoost(cfg.SearchLanguageBoost)
+	repo.SetLanguageDetection(cfg.LanguageDetectionEnabled)
+	repo.SetQueryCache(cfg.QueryCacheSize, time.Duration(cfg.QueryCacheTTLSeconds)*time.Second)
+
+	// A catalog given as a URL is downloaded before each import
+	var remoteINPX *indexer.RemoteINPX
+	if indexer.IsRemoteINPX(cfg.INPXPath) {
+		remoteINPX = &indexer.RemoteINPX{
+			URL:      cfg.INPXPath,
+			CacheDir: filepath.Join(cfg.CacheDir, "inpx"),
+			SHA256:   cfg.INPXSHA256,
+		}
+	}
 
 	// Check if database has data
 	searchResult, err := repo.SearchBooks(storage.BookFilter{Limit: 1})
@@ -43,12 +102,35 @@ func main() {
 		log.Fatalf("Failed to check database: %v", err)
 	}
 
-	if searchResult.Total == 0 {
+	if cfg.PIDFile != "" {
+		if err := daemon.WritePIDFile(cfg.PIDFile); err != nil {
+			log.Fatalf("Failed to start: %v", err)
+		}
+		defer daemon.RemovePIDFile(cfg.PIDFile)
+	}
+
+	importedAtStartup := false
+	if cfg.ReadOnly {
+		// Another instance imports the library and runs the background jobs
+		fmt.Printf("Read-only mode: database contains %d books\n", searchResult.Total)
+	} else if searchResult.Total == 0 {
+		importedAtStartup = true
 		fmt.Println("Database is empty, importing INPX data...")
-		result, err := indexer.ReindexFromINPX(repo, cfg.INPXPath)
+		inpxPath := cfg.INPXPath
+		if remoteINPX != nil {
+			if inpxPath, _, err = remoteINPX.Fetch(context.Background()); err != nil {
+				log.Fatalf("Failed to download INPX: %v", err)
+			}
+		}
+		result, err := indexer.ReindexFromINPX(repo, inpxPath)
 		if err != nil {
 			log.Fatalf("Failed to import INPX: %v", err)
 		}
+		if remoteINPX != nil {
+			if err := remoteINPX.MarkMerged(inpxPath); err != nil {
+				log.Printf("Failed to record the merged INPX: %v", err)
+			}
+		}
 		collectionName := "INPX"
 		if result.Collection != nil && result.Collection.Name != "" {
 			collectionName = result.Collection.Name
@@ -57,10 +139,47 @@ func main() {
 		parse := result.ParseDuration.Truncate(time.Millisecond)
 		clear := result.ClearDuration.Truncate(time.Millisecond)
 		insert := result.InsertDuration.Truncate(time.Millisecond)
+		fts := result.FTSDuration.Truncate(time.Millisecond)
 		fmt.Printf("Imported %d books from %s in %s\n", result.Imported, collectionName, total)
-		fmt.Printf("  parse=%s clear=%s insert=%s\n", parse, clear, insert)
+		fmt.Printf("  parse=%s clear=%s insert=%s fts=%s\n", parse, clear, insert, fts)
 	} else {
 		fmt.Printf("Database contains %d books\n", searchResult.Total)
+
+		// An import stopped before its deferred search index was built
+		go func() {
+			built, err := repo.BuildDeferredFTS()
+			if err != nil {
+				log.Printf("Failed to build search index: %v", err)
+			} else if built {
+				log.Printf("Search index: rebuilt from the catalog")
+			}
+		}()
+
+		// Databases imported before suggestions existed have no trigram index
+		if cfg.SearchSuggestionsEnabled {
+			if terms, err := repo.CountSearchTerms(); err == nil && terms == 0 {
+				go func() {
+					n, err := repo.RebuildSearchTerms()
+					if err != nil {
+						log.Printf("Failed to build search suggestions: %v", err)
+						return
+					}
+					log.Printf("Search suggestions: indexed %d words", n)
+				}()
+			}
+		}
+
+		// Catch up the change log with books imported while sync was off
+		if cfg.SyncEnabled {
+			go func() {
+				n, err := repo.RecordSyncChanges()
+				if err != nil {
+					log.Printf("Failed to record sync changes: %v", err)
+					return
+				}
+				log.Printf("Sync: recorded %d changes for mirrors", n)
+			}()
+		}
 	}
 
 	// Setup auth middleware
@@ -69,7 +188,9 @@ func main() {
 		fmt.Println("Authentication: enabled")
 
 		// Create admin user on startup if ADMIN_PASS is set
-		if cfg.AdminPass != "" {
+		if cfg.ReadOnly {
+			fmt.Println("Read-only mode: users and sessions are managed by the writable instance")
+		} else if cfg.AdminPass != "" {
 			count, err := repo.CountUsers()
 			if err != nil {
 				log.Fatalf("Failed to count users: %v", err)
@@ -87,8 +208,10 @@ func main() {
 		}
 
 		// Clean expired sessions on startup
-		if err := repo.DeleteExpiredSessions(); err != nil {
-			log.Printf("Warning: failed to clean expired sessions: %v", err)
+		if !cfg.ReadOnly {
+			if err := repo.DeleteExpiredSessions(); err != nil {
+				log.Printf("Warning: failed to clean expired sessions: %v", err)
+			}
 		}
 	} else {
 		fmt.Println("Authentication: disabled")
@@ -96,6 +219,29 @@ func main() {
 
 	// Setup API routes
 	handlers := api.NewHandlers(repo, cfg.BooksDir, cfg.INPXPath, authMw)
+	if remoteINPX != nil {
+		handlers.SetRemoteINPX(remoteINPX)
+	}
+	if err := handlers.SetLogLevel(cfg.LogLevel); err != nil {
+		log.Printf("Warning: LOG_LEVEL: %v", err)
+	}
+	if err := handlers.SetSizeCheck(cfg.ImportSizeCheck); err != nil {
+		log.Fatalf("Invalid IMPORT_SIZE_CHECK: %v", err)
+	}
+	if importedAtStartup {
+		handlers.StartSizeCheck()
+	}
+	handlers.SetDownloadTransliteration(cfg.DownloadTranslit)
+	if err := handlers.SetDownloadFilenameTemplate(cfg.DownloadFilename); err != nil {
+		log.Printf("Warning: DOWNLOAD_FILENAME: %v", err)
+	}
+	if err := handlers.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if cfg.DownloadQuotaCount > 0 || cfg.DownloadQuotaMB > 0 {
+		handlers.SetDownloadQuota(cfg.DownloadQuotaCount, int64(cfg.DownloadQuotaMB)<<20)
+		fmt.Printf("Download quota: %d books, %d MB a day (0 = no limit)\n", cfg.DownloadQuotaCount, cfg.DownloadQuotaMB)
+	}
 
 	// Configure TTS proxy if TTS_SERVER_URL is set
 	if cfg.TTSServerURL != "" {
@@ -103,22 +249,218 @@ func main() {
 		fmt.Printf("TTS server: %s\n", cfg.TTSServerURL)
 	}
 
-	router := api.SetupRoutes(handlers)
+	// Audit log of book downloads
+	if cfg.DownloadLogEnabled && cfg.ReadOnly {
+		fmt.Println("Download log: disabled in read-only mode")
+	} else if cfg.DownloadLogEnabled {
+		handlers.SetDownloadLog(time.Duration(cfg.DownloadLogRetentionDays)*24*time.Hour, cfg.DownloadLogMaxEntries)
+		fmt.Printf("Download log: enabled (%d days, at most %d entries)\n", cfg.DownloadLogRetentionDays, cfg.DownloadLogMaxEntries)
+	}
+
+	// Anonymized log of search queries
+	if cfg.SearchLogEnabled && cfg.ReadOnly {
+		fmt.Println("Search log: disabled in read-only mode")
+	} else if cfg.SearchLogEnabled {
+		repo.SetSearchLog(&storage.SearchLogSettings{
+			Retention:    time.Duration(cfg.SearchLogRetentionDays) * 24 * time.Hour,
+			MinSearchers: cfg.SearchLogMinSearchers,
+		})
+		fmt.Printf("Search log: enabled (%d days, queries of at least %d searchers shown)\n", cfg.SearchLogRetentionDays, cfg.SearchLogMinSearchers)
+	}
 
-	// Load genre translations for OPDS
-	genreNames, err := opds.LoadGenreNames(cfg.GenresCSVPath)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Detect an unmounted or unreadable books directory
+	handlers.StartBooksProbe(backgroundCtx, time.Duration(cfg.BooksProbeIntervalSeconds)*time.Second)
+
+	// Merge new versions of an INPX given by URL
+	if remoteINPX != nil && cfg.INPXRefreshHours > 0 && !cfg.ReadOnly {
+		interval := time.Duration(cfg.INPXRefreshHours) * time.Hour
+		handlers.StartINPXRefresh(backgroundCtx, interval)
+		fmt.Printf("INPX refresh: every %s from %s\n", interval, remoteINPX.URL)
+	}
+
+	// Periodic SQLite maintenance (WAL checkpoint, optimize, optional VACUUM)
+	if cfg.MaintenanceIntervalHours > 0 && !cfg.ReadOnly {
+		interval := time.Duration(cfg.MaintenanceIntervalHours) * time.Hour
+		handlers.StartMaintenanceScheduler(backgroundCtx, interval, cfg.MaintenanceVacuum)
+		fmt.Printf("Database maintenance: every %s (vacuum=%t)\n", interval, cfg.MaintenanceVacuum)
+	}
+
+	// Extract embedded FB2 covers in the background; resumes unchecked books
+	if cfg.CoversEnabled {
+		coverStore, location, err := openCoverStore(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure cover storage: %v", err)
+		}
+		handlers.SetCoverStore(coverStore)
+		if !cfg.ReadOnly {
+			handlers.StartCoverJob()
+		}
+		fmt.Printf("Cover extraction: enabled (%s)\n", location)
+	}
+
+	// Converted book files; the index is rebuilt from the stored files
+	convStore, convLocation, err := openConversionStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure conversion cache storage: %v", err)
+	}
+	convCache := convcache.New(convStore, int64(cfg.ConversionCacheMaxMB)<<20)
+	if err := convCache.Scan(backgroundCtx); err != nil {
+		log.Printf("Warning: conversion cache: %v", err)
+	}
+	handlers.SetConversionCache(convCache)
+	stats := convCache.Stats()
+	fmt.Printf("Conversion cache: %s (%d files, %d of %d MB)\n", convLocation, stats.Files, stats.Size>>20, cfg.ConversionCacheMaxMB)
+
+	// External converters (e.g. Calibre's ebook-convert) per format pair
+	var converters *convert.Registry
+	if cfg.Converters != "" {
+		rules, err := convert.ParseRules(cfg.Converters)
+		if err != nil {
+			log.Fatalf("Invalid CONVERTERS: %v", err)
+		}
+		converters = convert.NewRegistry(cfg.ConverterConcurrency)
+		for _, rule := range rules {
+			conv, err := convert.NewExternal(rule.Command, time.Duration(cfg.ConverterTimeoutSeconds)*time.Second)
+			if err != nil {
+				log.Fatalf("Invalid CONVERTERS: %v", err)
+			}
+			converters.Register(rule.From, rule.To, conv)
+			fmt.Printf("Converter: %s to %s via %s\n", rule.From, rule.To, conv.Args[0])
+		}
+		handlers.SetConverters(converters)
+
+		// Downloads wait for queued conversions; popular books are converted
+		// on idle workers ahead of time
+		if cfg.ConversionQueueSize > 0 {
+			queue := convert.NewQueue(cfg.ConverterConcurrency, cfg.ConversionQueueSize)
+			queue.Start(backgroundCtx)
+			handlers.SetConversionQueue(queue, time.Duration(cfg.ConversionWaitSeconds)*time.Second)
+			fmt.Printf("Conversion queue: %d workers, up to %d waiting\n", cfg.ConverterConcurrency, cfg.ConversionQueueSize)
+			if cfg.ConversionPrewarmBooks > 0 {
+				var formats []string
+				for _, format := range strings.Split(cfg.ConversionPrewarmFormats, ",") {
+					if format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), ".")); format != "" {
+						formats = append(formats, format)
+					}
+				}
+				handlers.StartConversionPrewarm(backgroundCtx, cfg.ConversionPrewarmBooks, formats)
+				fmt.Printf("Conversion pre-warm: %d popular books to %s\n", cfg.ConversionPrewarmBooks, strings.Join(formats, ", "))
+			}
+		}
+	}
+
+	// Optional author bios/portraits from Wikipedia, fetched in the background
+	var authorEnricher *enrichment.Service
+	if cfg.AuthorEnrichmentEnabled && cfg.ReadOnly {
+		fmt.Println("Author enrichment: disabled in read-only mode")
+	} else if cfg.AuthorEnrichmentEnabled {
+		authorEnricher = enrichment.NewService(repo, enrichment.Config{
+			Language:        cfg.AuthorEnrichmentLanguage,
+			RequestInterval: time.Duration(cfg.AuthorEnrichmentIntervalMs) * time.Millisecond,
+			CacheTTL:        time.Duration(cfg.AuthorEnrichmentCacheDays) * 24 * time.Hour,
+		})
+		authorEnricher.Start(backgroundCtx)
+		handlers.SetAuthorEnrichment(authorEnricher)
+		fmt.Printf("Author enrichment: enabled (%s.wikipedia.org)\n", cfg.AuthorEnrichmentLanguage)
+	}
+
+	// New books of saved searches sent by email and to webhooks after imports
+	notifyConfig := notifications.Config{
+		SMTP: notifications.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		},
+		MaxBooks:      cfg.NotifyMaxBooks,
+		MinInterval:   time.Duration(cfg.NotifyMinIntervalMinutes) * time.Minute,
+		RatePerMinute: cfg.NotifyRatePerMinute,
+		BaseURL:       publicBaseURL(cfg),
+	}
+	if notifyConfig.Webhooks, err = notifications.ParseWebhooks(cfg.NotifyWebhooks); err != nil {
+		log.Fatalf("Invalid NOTIFY_WEBHOOKS: %v", err)
+	}
+	if cfg.SMTPHost != "" && cfg.SMTPFrom == "" {
+		log.Fatalf("Invalid SMTP_FROM: required with SMTP_HOST")
+	}
+	if notifyConfig.Enabled() && cfg.ReadOnly {
+		fmt.Println("Notifications: disabled in read-only mode")
+	} else if notifyConfig.Enabled() {
+		notifier := notifications.New(repo, notifyConfig)
+		notifier.Start(backgroundCtx)
+		handlers.SetNotifier(notifier)
+		if importedAtStartup {
+			notifier.Trigger()
+		}
+		email := "off"
+		if notifyConfig.SMTP.Enabled() {
+			email = fmt.Sprintf("%s:%d", cfg.SMTPHost, cfg.SMTPPort)
+		}
+		fmt.Printf("Notifications: %d webhooks, email %s, at most %d messages a minute\n",
+			len(notifyConfig.Webhooks), email, cfg.NotifyRatePerMinute)
+	}
+
+	// Genre names: the built-in FB2 genres, overridden by GENRES_CSV_PATH
+	genreList, err := genres.Load(cfg.GenresCSVPath)
 	if err != nil {
 		log.Printf("Failed to load genre translations from %s: %v", cfg.GenresCSVPath, err)
+		genreList = genres.Default()
 	}
+	handlers.SetGenres(genreList)
+	genreNames := genres.Names(genreList)
+
+	handlers.SetPublicSite(publicBaseURL(cfg), cfg.CatalogTitle)
+	router := api.SetupRoutes(handlers)
 
 	// Setup OPDS routes
-	baseURL := strings.TrimSpace(cfg.PublicBaseURL)
-	if baseURL == "" {
-		baseURL = fmt.Sprintf("http://localhost:%s", cfg.Port)
-	}
-	baseURL = strings.TrimSuffix(baseURL, "/")
+	baseURL := publicBaseURL(cfg)
 	opdsHandler := opds.NewHandler(repo, baseURL, cfg.CatalogTitle, genreNames)
+	if authorEnricher != nil {
+		opdsHandler.SetAuthorInfoProvider(authorEnricher)
+	}
+	opdsHandler.SetOPDS2Enabled(cfg.OPDS2Enabled)
+	opdsHandler.SetLanguagesEnabled(cfg.OPDSLanguages)
+	opdsHandler.SetPageSize(cfg.PageSize)
+	opdsHandler.SetAnnotationLimit(cfg.OPDSAnnotationMaxLength)
+	if converters != nil {
+		opdsHandler.SetConversions(converters.Targets)
+	}
+
+	// Saved filters shown as extra sections of the root catalog
+	if cfg.OPDSSections != "" {
+		list, err := sections.Parse(cfg.OPDSSections)
+		if err != nil {
+			log.Fatalf("Invalid OPDS_SECTIONS: %v", err)
+		}
+		opdsHandler.SetSections(list)
+		handlers.SetSections(list)
+		fmt.Printf("Catalog sections: %d\n", len(list))
+	}
+
+	// External OPDS catalogs crawled into a federated search
+	if cfg.OPDSUpstreams != "" && cfg.ReadOnly {
+		fmt.Println("Upstream OPDS catalogs: disabled in read-only mode")
+	} else if cfg.OPDSUpstreams != "" {
+		sources, err := upstream.ParseSources(cfg.OPDSUpstreams, cfg.OPDSUpstreamProxy)
+		if err != nil {
+			log.Fatalf("Invalid OPDS_UPSTREAMS: %v", err)
+		}
+		upstreams := upstream.NewService(repo, sources, upstream.Config{MaxPages: cfg.OPDSUpstreamMaxPages})
+		if err := upstreams.Register(); err != nil {
+			log.Fatalf("Failed to register upstream catalogs: %v", err)
+		}
+		upstreams.Start(backgroundCtx, time.Duration(cfg.OPDSUpstreamRefreshHours)*time.Hour)
+		opdsHandler.SetUpstreamCatalogs(upstreams)
+		handlers.SetUpstreams(upstreams)
+		fmt.Printf("Upstream OPDS catalogs: %d (%s/opds/upstreams)\n", len(sources), baseURL)
+	}
+
 	api.SetupOPDSRoutes(router, opdsHandler, authMw)
+	handlers.SetOPDSValidation(opdsHandler)
 
 	// Setup HTTP server
 	server := &http.Server{
@@ -126,6 +468,11 @@ func main() {
 		Handler: router,
 	}
 
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+
 	// Start server in goroutine
 	go func() {
 		fmt.Printf("Starting HTTP server on port %s\n", cfg.Port)
@@ -133,19 +480,34 @@ func main() {
 		fmt.Printf("Web interface: %s/\n", baseURL)
 		fmt.Printf("API available at: %s/api/v1/books\n", baseURL)
 		fmt.Printf("OPDS catalog: %s/opds\n", baseURL)
+		if cfg.OPDS2Enabled {
+			fmt.Printf("OPDS 2.0 catalog: %s/opds/v2\n", baseURL)
+		}
 		fmt.Printf("Health check at: %s/health\n", baseURL)
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// The database answered and the port is bound: report readiness to
+	// systemd (Type=notify)
+	notify("READY=1\nSTATUS=Serving on port " + cfg.Port)
+
+	// Reload on SIGHUP, shut down gracefully on interrupt
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		notify("RELOADING=1")
+		reloadConfig(handlers, opdsHandler, authMw)
+		notify("READY=1")
+	}
 
 	fmt.Println("Shutting down server...")
+	notify("STOPPING=1")
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -157,3 +519,137 @@ func main() {
 
 	fmt.Println("Server stopped")
 }
+
+// openDatabase opens the database, without write access when READ_ONLY is
+// set. A writable database is locked against other writing instances.
+func openDatabase(cfg *config.Config) (*storage.Database, error) {
+	if cfg.ReadOnly {
+		return storage.NewReadOnlyDatabase(cfg.DatabasePath)
+	}
+	db, err := storage.NewExclusiveDatabase(cfg.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyFTSTokenizer(db, cfg); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// applyFTSTokenizer rebuilds the search indexes if FTS_TOKENIZER changed
+func applyFTSTokenizer(db *storage.Database, cfg *config.Config) error {
+	start := time.Now()
+	rebuilt, err := db.SetFTSTokenizer(cfg.FTSTokenizer)
+	if err != nil {
+		return fmt.Errorf("invalid FTS_TOKENIZER: %w", err)
+	}
+	if rebuilt {
+		fmt.Printf("Search index rebuilt with tokenizer %q in %s\n", cfg.FTSTokenizer, time.Since(start).Truncate(time.Millisecond))
+	}
+	return nil
+}
+
+// applyGenreMapping makes imports map genre codes to the built-in FB2
+// genres and those of GENRES_CSV_PATH, with the aliases of
+// GENRE_ALIASES_PATH
+func applyGenreMapping(repo *storage.Repository, cfg *config.Config) {
+	list, err := genres.Load(cfg.GenresCSVPath)
+	if err != nil {
+		log.Printf("Warning: genre codes are not normalized: %v", err)
+		return
+	}
+	codes := genres.Codes(list)
+	var aliases map[string]string
+	if cfg.GenreAliasesPath != "" {
+		if aliases, err = metadata.LoadGenreAliases(cfg.GenreAliasesPath); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	repo.SetGenreMapping(storage.NewGenreMapping(codes, aliases))
+}
+
+// openCoverStore creates the cover thumbnail store selected by
+// CACHE_STORAGE and describes where it keeps the covers
+func openCoverStore(cfg *config.Config) (*covers.Store, string, error) {
+	switch cfg.CacheStorage {
+	case "", "local":
+		dir := filepath.Join(cfg.CacheDir, "covers")
+		return covers.NewStore(dir), dir, nil
+	case "s3":
+		s3, err := openS3(cfg)
+		if err != nil {
+			return nil, "", err
+		}
+		return covers.NewBlobStore(blob.Sub(s3, "covers/")), fmt.Sprintf("s3://%s/%scovers", cfg.S3Bucket, cfg.S3Prefix), nil
+	default:
+		return nil, "", fmt.Errorf("unknown CACHE_STORAGE %q (want local or s3)", cfg.CacheStorage)
+	}
+}
+
+// openConversionStore creates the store of converted book files selected by
+// CACHE_STORAGE and describes where it keeps them
+func openConversionStore(cfg *config.Config) (blob.Store, string, error) {
+	switch cfg.CacheStorage {
+	case "", "local":
+		dir := filepath.Join(cfg.CacheDir, "converted")
+		return blob.NewLocal(dir), dir, nil
+	case "s3":
+		s3, err := openS3(cfg)
+		if err != nil {
+			return nil, "", err
+		}
+		return blob.Sub(s3, "converted/"), fmt.Sprintf("s3://%s/%sconverted", cfg.S3Bucket, cfg.S3Prefix), nil
+	default:
+		return nil, "", fmt.Errorf("unknown CACHE_STORAGE %q (want local or s3)", cfg.CacheStorage)
+	}
+}
+
+// openS3 connects to the S3 bucket of the S3_* settings
+func openS3(cfg *config.Config) (*blob.S3, error) {
+	return blob.NewS3(blob.S3Config{
+		Endpoint:  cfg.S3Endpoint,
+		Region:    cfg.S3Region,
+		Bucket:    cfg.S3Bucket,
+		AccessKey: cfg.S3AccessKeyID,
+		SecretKey: cfg.S3SecretAccessKey,
+		Prefix:    cfg.S3Prefix,
+		PathStyle: cfg.S3PathStyle,
+	})
+}
+
+// reloadConfig re-reads the configuration on SIGHUP and applies the settings
+// that can change while serving: LOG_LEVEL, PUBLIC_BASE_URL and PAGE_SIZE.
+// Other settings need a restart. Open connections are not interrupted.
+func reloadConfig(handlers *api.Handlers, opdsHandler *opds.Handler, authMw *auth.Middleware) {
+	cfg := config.LoadConfig()
+
+	if err := handlers.SetLogLevel(cfg.LogLevel); err != nil {
+		log.Printf("Reload: LOG_LEVEL: %v", err)
+	}
+	baseURL := publicBaseURL(cfg)
+	opdsHandler.SetBaseURL(baseURL)
+	handlers.SetPublicSite(baseURL, cfg.CatalogTitle)
+	if authMw.IsEnabled() {
+		api.UpdateOPDSAuthDocument(opdsHandler, authMw)
+	}
+	opdsHandler.SetPageSize(cfg.PageSize)
+
+	log.Printf("Configuration reloaded: LOG_LEVEL=%s PUBLIC_BASE_URL=%s PAGE_SIZE=%d", cfg.LogLevel, baseURL, cfg.PageSize)
+}
+
+// notify sends a state to systemd when run as a Type=notify service
+func notify(state string) {
+	if _, err := daemon.Notify(state); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// publicBaseURL returns the externally visible server URL without a trailing slash
+func publicBaseURL(cfg *config.Config) string {
+	baseURL := strings.TrimSpace(cfg.PublicBaseURL)
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%s", cfg.Port)
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
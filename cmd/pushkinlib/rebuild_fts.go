@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runRebuildFTS implements `pushkinlib rebuild-fts`: it builds the
+// full-text indexes again from the catalog with FTS_TOKENIZER. With -vacuum
+// it then returns the freed pages to the file system. It refuses to run
+// while a server has the database open.
+func runRebuildFTS(args []string) int {
+	fs := flag.NewFlagSet("rebuild-fts", flag.ExitOnError)
+	vacuum := fs.Bool("vacuum", false, "Run VACUUM after the rebuild to shrink the database file")
+	fs.Parse(args)
+
+	cfg := config.LoadConfig()
+	db, err := storage.NewExclusiveDatabase(cfg.DatabasePath)
+	if err != nil {
+		log.Printf("Failed to open database: %v", err)
+		return 2
+	}
+	defer db.Close()
+
+	start := time.Now()
+	if err := db.RebuildFTS(cfg.FTSTokenizer); err != nil {
+		log.Printf("Rebuild failed: %v", err)
+		return 1
+	}
+	fmt.Printf("Rebuilt full-text indexes in %s\n", time.Since(start).Truncate(time.Millisecond))
+
+	repo := storage.NewRepository(db)
+	result, err := repo.RunMaintenance(*vacuum)
+	if err != nil {
+		log.Printf("Maintenance failed: %v", err)
+		return 1
+	}
+	fmt.Printf("Database size: %d -> %d bytes (vacuum=%t)\n", result.DBSizeBefore, result.DBSizeAfter, result.Vacuumed)
+	return 0
+}
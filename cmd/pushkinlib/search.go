@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runSearch implements `pushkinlib search`: it searches the catalog, either
+// the local database or a running server given by -url, and prints the
+// books found as a table or, with -json, as the API response. The exit
+// code is 1 when the search fails.
+func runSearch(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var (
+		remoteURL = fs.String("url", "", "Search a running server at this URL (e.g. http://host:9090) instead of the local database")
+		user      = fs.String("user", "", "Basic Auth user for -url")
+		password  = fs.String("password", "", "Basic Auth password for -url")
+		author    = fs.String("author", "", "Only books of this author")
+		series    = fs.String("series", "", "Only books of this series")
+		genre     = fs.String("genre", "", "Only books of this genre code")
+		language  = fs.String("lang", "", "Only books in this language")
+		format    = fs.String("format", "", "Only books in this format")
+		sortBy    = fs.String("sort", "", "Sort by "+strings.Join(storage.SortFields, ", ")+" (default: relevance, or title without a query)")
+		sortOrder = fs.String("order", "", "Sort order: asc or desc")
+		limit     = fs.Int("limit", 20, "Maximum number of books to print")
+		offset    = fs.Int("offset", 0, "Number of books to skip")
+		jsonOut   = fs.Bool("json", false, "Print the result as JSON")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: pushkinlib search [flags] "query"`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !storage.IsValidSortField(*sortBy) {
+		fmt.Fprintf(os.Stderr, "search: -sort must be one of: %s\n", strings.Join(storage.SortFields, ", "))
+		return 2
+	}
+	filter := storage.BookFilter{
+		Query:     strings.Join(fs.Args(), " "),
+		SortBy:    *sortBy,
+		SortOrder: *sortOrder,
+		Limit:     *limit,
+		Offset:    *offset,
+	}
+	if *author != "" {
+		filter.Authors = []string{*author}
+	}
+	if *series != "" {
+		filter.Series = []string{*series}
+	}
+	if *genre != "" {
+		filter.Genres = []string{*genre}
+	}
+	if *language != "" {
+		filter.Languages = []string{*language}
+	}
+	if *format != "" {
+		filter.Formats = []string{*format}
+	}
+
+	var (
+		result *storage.BookList
+		err    error
+	)
+	if *remoteURL != "" {
+		client := &http.Client{Timeout: 30 * time.Second}
+		if *user != "" {
+			client.Transport = basicAuthTransport{user: *user, password: *password}
+		}
+		result, err = searchRemote(client, *remoteURL, filter)
+	} else {
+		result, err = searchLocal(filter)
+	}
+	if err != nil {
+		log.Printf("Search failed: %v", err)
+		return 1
+	}
+
+	if *jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		return 0
+	}
+	printBooks(os.Stdout, result)
+	return 0
+}
+
+// searchLocal searches the local database, opened read-only so that a
+// running server may keep it
+func searchLocal(filter storage.BookFilter) (*storage.BookList, error) {
+	cfg := config.LoadConfig()
+	db, err := storage.NewReadOnlyDatabase(cfg.DatabasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+	repo.SetSearchSuggestionsEnabled(cfg.SearchSuggestionsEnabled)
+	repo.SetSearchFallback(cfg.SearchFallbackEnabled)
+	rankWeights, err := storage.ParseRankWeights(cfg.SearchRankWeights)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SEARCH_RANK_WEIGHTS: %w", err)
+	}
+	repo.SetRankWeights(rankWeights)
+	repo.SetLanguageBoost(cfg.SearchLanguageBoost)
+	return repo.SearchBooks(filter)
+}
+
+// searchRemote searches a server through GET /api/v1/books
+func searchRemote(client *http.Client, baseURL string, filter storage.BookFilter) (*storage.BookList, error) {
+	query := url.Values{}
+	setParam := func(name, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	setParam("q", filter.Query)
+	setParam("sort_by", filter.SortBy)
+	setParam("sort_order", filter.SortOrder)
+	query.Set("limit", strconv.Itoa(filter.Limit))
+	query.Set("offset", strconv.Itoa(filter.Offset))
+	for name, values := range map[string][]string{
+		"authors": filter.Authors, "series": filter.Series, "genres": filter.Genres,
+		"languages": filter.Languages, "formats": filter.Formats,
+	} {
+		for _, value := range values {
+			query.Add(name, value)
+		}
+	}
+
+	resp, err := client.Get(strings.TrimRight(baseURL, "/") + "/api/v1/books?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var envelope struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, envelope.Error.Message)
+		}
+		return nil, errors.New(resp.Status)
+	}
+
+	var result storage.BookList
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &result, nil
+}
+
+// printBooks prints a search result as a table followed by the number of
+// books found and the notes of the search
+func printBooks(w io.Writer, result *storage.BookList) {
+	if len(result.Books) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tAUTHORS\tSERIES\tYEAR\tFORMAT")
+		for _, book := range result.Books {
+			authors := make([]string, len(book.Authors))
+			for i, author := range book.Authors {
+				authors[i] = author.Name
+			}
+			var series, year string
+			if book.Series != nil {
+				series = book.Series.Name
+				if book.SeriesNum > 0 {
+					series += " #" + strconv.Itoa(book.SeriesNum)
+				}
+			}
+			if book.Year > 0 {
+				year = strconv.Itoa(book.Year)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				book.ID, book.Title, strings.Join(authors, ", "), series, year, book.Format)
+		}
+		tw.Flush()
+	}
+
+	if result.Fallback {
+		fmt.Fprintf(w, "Nothing found, showing books for: %s\n", result.FallbackQuery)
+	}
+	if len(result.Books) == 0 {
+		fmt.Fprintf(w, "Found %d books\n", result.Total)
+	} else {
+		fmt.Fprintf(w, "Found %d books, showing %d-%d\n", result.Total, result.Offset+1, result.Offset+len(result.Books))
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "Note: %s\n", warning)
+	}
+	if len(result.Suggestions) > 0 {
+		fmt.Fprintf(w, "Did you mean: %s\n", strings.Join(result.Suggestions, ", "))
+	}
+}
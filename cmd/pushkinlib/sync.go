@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/mirror"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runSync implements `pushkinlib sync`: it pulls changed books and missing
+// archives from another instance into the local database and BOOKS_DIR.
+// With -interval it keeps syncing until interrupted.
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	var (
+		source   = fs.String("source", "", "Base URL of the instance to mirror (e.g. http://library:9090)")
+		user     = fs.String("user", "", "Admin user on the source, when it has AUTH_ENABLED=true")
+		password = fs.String("password", "", "Password for -user")
+		interval = fs.Duration("interval", 0, "Repeat the sync at this interval (e.g. 1h); 0 syncs once")
+	)
+	fs.Parse(args)
+
+	if *source == "" {
+		fmt.Fprintln(os.Stderr, "sync: -source is required")
+		fs.Usage()
+		return 2
+	}
+
+	cfg := config.LoadConfig()
+	db, err := storage.NewDatabase(cfg.DatabasePath)
+	if err != nil {
+		log.Printf("Failed to open database: %v", err)
+		return 2
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+	repo.SetSearchSuggestionsEnabled(cfg.SearchSuggestionsEnabled)
+	repo.SetSyncEnabled(cfg.SyncEnabled)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client := mirror.NewClient(*source, cfg.BooksDir, repo)
+	if *user != "" {
+		if err := client.Login(ctx, *user, *password); err != nil {
+			log.Printf("Sync: %v", err)
+			return 1
+		}
+	}
+
+	for {
+		start := time.Now()
+		result, err := client.Sync(ctx)
+		if result != nil {
+			fmt.Printf("Synced from %s: %d books updated, %d deleted, %d archives downloaded (cursor %d) in %s\n",
+				*source, result.Upserted, result.Deleted, result.Archives, result.Cursor, time.Since(start).Truncate(time.Millisecond))
+		}
+		if err != nil {
+			log.Printf("Sync failed: %v", err)
+			if *interval == 0 {
+				return 1
+			}
+		}
+		if *interval == 0 {
+			return 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(*interval):
+		}
+	}
+}
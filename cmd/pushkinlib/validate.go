@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/api"
+	"github.com/piligrim/pushkinlib/internal/config"
+	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// runValidateOPDS implements `pushkinlib validate-opds`: it crawls the OPDS
+// catalog, either served in-process from the local database or a running
+// server given by -url, and prints OPDS 1.2 violations. The exit code is 1
+// when errors are found.
+func runValidateOPDS(args []string) int {
+	fs := flag.NewFlagSet("validate-opds", flag.ExitOnError)
+	var (
+		remoteURL = fs.String("url", "", "Validate a running catalog at this URL (e.g. http://host:9090/opds) instead of the local database")
+		user      = fs.String("user", "", "Basic Auth user for -url")
+		password  = fs.String("password", "", "Basic Auth password for -url")
+		maxPages  = fs.Int("max-pages", 200, "Maximum number of feeds to fetch")
+		jsonOut   = fs.Bool("json", false, "Print the report as JSON")
+	)
+	fs.Parse(args)
+
+	var (
+		client   *http.Client
+		startURL string
+	)
+	if *remoteURL != "" {
+		client = &http.Client{Timeout: 30 * time.Second}
+		if *user != "" {
+			client.Transport = basicAuthTransport{user: *user, password: *password}
+		}
+		startURL = *remoteURL
+	} else {
+		cfg := config.LoadConfig()
+		db, err := storage.NewDatabase(cfg.DatabasePath)
+		if err != nil {
+			log.Printf("Failed to open database: %v", err)
+			return 2
+		}
+		defer db.Close()
+
+		genreNames, err := opds.LoadGenreNames(cfg.GenresCSVPath)
+		if err != nil {
+			log.Printf("Failed to load genre translations from %s: %v", cfg.GenresCSVPath, err)
+		}
+
+		baseURL := publicBaseURL(cfg)
+		handler := opds.NewHandler(storage.NewRepository(db), baseURL, cfg.CatalogTitle, genreNames)
+		handler.SetOPDS2Enabled(cfg.OPDS2Enabled)
+		handler.SetLanguagesEnabled(cfg.OPDSLanguages)
+		client = opds.HandlerClient(api.NewOPDSRouter(handler))
+		startURL = baseURL + "/opds"
+	}
+
+	report, err := opds.NewValidator(client, *maxPages).Validate(context.Background(), startURL)
+	if err != nil {
+		log.Printf("Validation failed: %v", err)
+		return 2
+	}
+
+	if *jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, v := range report.Violations {
+			fmt.Printf("%-7s [%s] %s\n        %s\n", strings.ToUpper(v.Severity), v.Rule, v.URL, v.Message)
+		}
+		fmt.Printf("Checked %d feeds from %s: %d errors, %d warnings\n", report.Pages, startURL, report.Errors, report.Warnings)
+		if report.Truncated {
+			fmt.Printf("Stopped after %d feeds; raise -max-pages to check the rest\n", report.Pages)
+		}
+	}
+
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// basicAuthTransport adds Basic Auth credentials to every request
+type basicAuthTransport struct {
+	user, password string
+}
+
+func (t basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.user, t.password)
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -7,6 +7,5 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.32
 	golang.org/x/crypto v0.49.0
 	golang.org/x/net v0.52.0
+	golang.org/x/text v0.35.0
 )
-
-require golang.org/x/text v0.35.0 // indirect
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// restrictions returns the genres and tags hidden from the user of the
+// request, or nil when everything is visible
+func (h *Handlers) restrictions(r *http.Request) (*storage.Restrictions, error) {
+	return h.repo.RestrictionsFor(auth.UserFromContext(r.Context()))
+}
+
+// requireBookAccess rejects requests for a restricted book {id}: anonymous
+// visitors are asked to sign in, signed-in users without the role get 403.
+// Books hidden by an admin are not found for anyone but admins.
+func (h *Handlers) requireBookAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := auth.UserFromContext(r.Context()); user != nil && user.IsAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bookID := chi.URLParam(r, "id")
+		bookHidden, err := h.repo.IsBookHidden(bookID)
+		if err != nil {
+			log.Printf("requireBookAccess: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		if bookHidden {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+			return
+		}
+
+		hidden, err := h.restrictions(r)
+		if err != nil {
+			log.Printf("requireBookAccess: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		if hidden == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		book, err := h.repo.GetBookByID(bookID)
+		if err != nil {
+			log.Printf("requireBookAccess: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		if book == nil || !hidden.Hides(book) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if hidden.Anonymous && h.authMw.IsEnabled() {
+			// Basic Auth lets e-readers follow download links from OPDS feeds
+			w.Header().Set("WWW-Authenticate", `Basic realm="Pushkinlib"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Sign in to access this book")
+			return
+		}
+		writeError(w, http.StatusForbidden, codeForbidden, "Access to this book is restricted")
+	})
+}
+
+// ListAccessRules returns the genres and tags restricted to signed-in users
+// or roles (admin only).
+// GET /api/v1/admin/access-rules
+func (h *Handlers) ListAccessRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.repo.ListAccessRules()
+	if err != nil {
+		log.Printf("ListAccessRules: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules}); err != nil {
+		log.Printf("ListAccessRules: failed to encode response: %v", err)
+	}
+}
+
+// SetAccessRule restricts a genre or tag (admin only). An empty role allows
+// every signed-in user; otherwise only users with the role see the books.
+// PUT /api/v1/admin/access-rules/{kind}/{name}
+func (h *Handlers) SetAccessRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+			return
+		}
+	}
+
+	rule := storage.AccessRule{Kind: chi.URLParam(r, "kind"), Name: chi.URLParam(r, "name"), Role: req.Role}
+	if err := h.repo.SetAccessRule(rule); err != nil {
+		if errors.Is(err, storage.ErrInvalidAccessRule) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Kind must be genre or tag, and name must not be empty")
+			return
+		}
+		log.Printf("SetAccessRule: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("SetAccessRule: failed to encode response: %v", err)
+	}
+}
+
+// DeleteAccessRule lifts the restriction of a genre or tag (admin only).
+// DELETE /api/v1/admin/access-rules/{kind}/{name}
+func (h *Handlers) DeleteAccessRule(w http.ResponseWriter, r *http.Request) {
+	deleted, err := h.repo.DeleteAccessRule(chi.URLParam(r, "kind"), chi.URLParam(r, "name"))
+	if err != nil {
+		log.Printf("DeleteAccessRule: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, codeNotFound, "Access rule not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteAccessRule: failed to encode response: %v", err)
+	}
+}
+
+// SetUserRoles replaces the roles of a user (admin only).
+// PUT /api/v1/admin/users/{id}/roles
+func (h *Handlers) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		log.Printf("SetUserRoles: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Пользователь не найден")
+		return
+	}
+
+	if err := h.repo.SetUserRoles(user.ID, req.Roles); err != nil {
+		log.Printf("SetUserRoles: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	roles, err := h.repo.GetUserRoles(user.ID)
+	if err != nil {
+		log.Printf("SetUserRoles: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"roles": roles}); err != nil {
+		log.Printf("SetUserRoles: failed to encode response: %v", err)
+	}
+}
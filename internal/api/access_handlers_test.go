@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// TestAccessRules restricts the genre of the test book to a role and checks
+// what anonymous visitors, signed-in users and role holders get.
+func TestAccessRules(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+
+	req := httptest.NewRequest("PUT", "/api/v1/admin/access-rules/genre/fiction", strings.NewReader(`{"role":"adult"}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("kind", "genre")
+	rctx.URLParams.Add("name", "fiction")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.SetAccessRule(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("SetAccessRule: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := h.repo.CreateUser("reader", "reader123", "", false); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	adult, err := h.repo.CreateUser("adult", "adult123", "", false)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := h.repo.SetUserRoles(adult.ID, []string{"adult"}); err != nil {
+		t.Fatalf("SetUserRoles: %v", err)
+	}
+
+	get := func(path, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	total := func(w *httptest.ResponseRecorder) int {
+		var resp struct {
+			Total int `json:"total"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode search response: %v", err)
+		}
+		return resp.Total
+	}
+
+	// Anonymous visitors neither find nor open the book
+	if n := total(get("/api/v1/books", "", "")); n != 0 {
+		t.Errorf("anonymous search total = %d, want 0", n)
+	}
+	w = get("/api/v1/books/test-001", "", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous book: got %d, want 401 with a Basic Auth challenge", w.Code)
+	}
+	if w = get("/download/test-001", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous download: got %d, want 401", w.Code)
+	}
+
+	// Signed-in users without the role are refused
+	if n := total(get("/api/v1/books", "reader", "reader123")); n != 0 {
+		t.Errorf("reader search total = %d, want 0", n)
+	}
+	if w = get("/api/v1/books/test-001", "reader", "reader123"); w.Code != http.StatusForbidden {
+		t.Errorf("reader book: got %d, want 403", w.Code)
+	}
+
+	// Role holders see the book
+	if n := total(get("/api/v1/books", "adult", "adult123")); n != 1 {
+		t.Errorf("adult search total = %d, want 1", n)
+	}
+	if w = get("/api/v1/books/test-001", "adult", "adult123"); w.Code != http.StatusOK {
+		t.Errorf("adult book: got %d, want 200", w.Code)
+	}
+
+	// Lifting the rule makes the book public again
+	req = httptest.NewRequest("DELETE", "/api/v1/admin/access-rules/genre/fiction", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w = httptest.NewRecorder()
+	h.DeleteAccessRule(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeleteAccessRule: expected 200, got %d", w.Code)
+	}
+	if w = get("/api/v1/books/test-001", "", ""); w.Code != http.StatusOK {
+		t.Errorf("book after delete: got %d, want 200", w.Code)
+	}
+}
+
+// TestSetAccessRule_InvalidKind rejects rules for anything but genres and tags.
+func TestSetAccessRule_InvalidKind(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("PUT", "/api/v1/admin/access-rules/author/x", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("kind", "author")
+	rctx.URLParams.Add("name", "x")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.SetAccessRule(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/indexer"
+	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// reindexStatus describes the current or most recent reindex run
+type reindexStatus struct {
+	Running    bool                   `json:"running"`
+	JobID      string                 `json:"job_id,omitempty"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (h *Handlers) setReindexStarted(job indexer.Job) {
+	now := time.Now()
+	h.statusMu.Lock()
+	h.reindexState = reindexStatus{Running: true, JobID: job.ID, StartedAt: &now}
+	h.statusMu.Unlock()
+}
+
+func (h *Handlers) setReindexFinished(result map[string]interface{}, err error) {
+	now := time.Now()
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	h.reindexState.Running = false
+	h.reindexState.FinishedAt = &now
+	h.reindexState.Result = result
+	if err != nil {
+		h.reindexState.Error = err.Error()
+	}
+}
+
+func (h *Handlers) currentReindexStatus() reindexStatus {
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	return h.reindexState
+}
+
+// StartReindex launches a reindex in the background (admin only).
+// POST /api/v1/admin/reindex/start
+func (h *Handlers) StartReindex(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Begin("reindex")
+	if err != nil {
+		writeBusy(w, err)
+		return
+	}
+
+	// Mark as running before responding so an immediate status poll sees it
+	h.setReindexStarted(job)
+	go func() {
+		defer h.jobs.End(job)
+		if _, err := h.runReindex(job); err != nil {
+			log.Printf("StartReindex: %v", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(h.currentReindexStatus()); err != nil {
+		log.Printf("StartReindex: failed to encode response: %v", err)
+	}
+}
+
+// GetReindexStatus returns the state of the current or last reindex (admin only).
+// GET /api/v1/admin/reindex/status
+func (h *Handlers) GetReindexStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.currentReindexStatus()); err != nil {
+		log.Printf("GetReindexStatus: failed to encode response: %v", err)
+	}
+}
+
+// GetStats returns library statistics (admin only).
+// GET /api/v1/admin/stats
+func (h *Handlers) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.repo.GetLibraryStats()
+	if err != nil {
+		log.Printf("GetStats: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("GetStats: failed to encode response: %v", err)
+	}
+}
+
+// ListAuthors returns a paginated list of authors, optionally filtered by name
+// (admin only, used for merging).
+// GET /api/v1/admin/authors
+func (h *Handlers) ListAuthors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := parseInt(query.Get("offset"), 0)
+
+	var authors []storage.Author
+	var total int
+	var err error
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		authors, err = h.repo.FindAuthors(q, limit)
+		total = len(authors)
+		offset = 0
+	} else {
+		authors, total, err = h.repo.ListAuthors(limit, offset)
+	}
+	if err != nil {
+		log.Printf("ListAuthors: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if authors == nil {
+		authors = []storage.Author{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"authors": authors,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	}); err != nil {
+		log.Printf("ListAuthors: failed to encode response: %v", err)
+	}
+}
+
+// MergeAuthors merges one author into another (admin only).
+// POST /api/v1/admin/authors/merge
+func (h *Handlers) MergeAuthors(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SourceID int `json:"source_id"`
+		TargetID int `json:"target_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.SourceID <= 0 || req.TargetID <= 0 || req.SourceID == req.TargetID {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "source_id and target_id must be different authors")
+		return
+	}
+
+	target, err := h.repo.MergeAuthors(req.SourceID, req.TargetID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthorNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+			return
+		}
+		log.Printf("MergeAuthors: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	h.recordSyncChanges()
+	h.forgetAuthor(req.SourceID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(target); err != nil {
+		log.Printf("MergeAuthors: failed to encode response: %v", err)
+	}
+}
+
+// ListAuthorAliases returns all author aliases (admin only).
+// GET /api/v1/admin/authors/aliases
+func (h *Handlers) ListAuthorAliases(w http.ResponseWriter, r *http.Request) {
+	aliases, err := h.repo.ListAuthorAliases()
+	if err != nil {
+		log.Printf("ListAuthorAliases: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"aliases": aliases}); err != nil {
+		log.Printf("ListAuthorAliases: failed to encode response: %v", err)
+	}
+}
+
+// SetAuthorAlias links an author as an alias (pseudonym) of another; unlike
+// a merge both records stay (admin only).
+// POST /api/v1/admin/authors/aliases
+func (h *Handlers) SetAuthorAlias(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AliasID  int `json:"alias_id"`
+		AuthorID int `json:"author_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.AliasID <= 0 || req.AuthorID <= 0 || req.AliasID == req.AuthorID {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "alias_id and author_id must be different authors")
+		return
+	}
+
+	alias, err := h.repo.SetAuthorAlias(req.AliasID, req.AuthorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrAuthorNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+		case errors.Is(err, storage.ErrInvalidAlias):
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		default:
+			log.Printf("SetAuthorAlias: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(alias); err != nil {
+		log.Printf("SetAuthorAlias: failed to encode response: %v", err)
+	}
+}
+
+// DeleteAuthorAlias unlinks an alias from its canonical author (admin only).
+// DELETE /api/v1/admin/authors/aliases/{id}
+func (h *Handlers) DeleteAuthorAlias(w http.ResponseWriter, r *http.Request) {
+	aliasID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid author ID")
+		return
+	}
+
+	deleted, err := h.repo.DeleteAuthorAlias(aliasID)
+	if err != nil {
+		log.Printf("DeleteAuthorAlias: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, codeNotFound, "Author is not an alias")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteAuthorAlias: failed to encode response: %v", err)
+	}
+}
+
+// SplitAuthor moves books of an author that belong to a namesake to a
+// separate author, e.g. "Николай Иванов (историк)" (admin only).
+// POST /api/v1/admin/authors/split
+func (h *Handlers) SplitAuthor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AuthorID int      `json:"author_id"`
+		BookIDs  []string `json:"book_ids"`
+		Name     string   `json:"name"`
+		SourceID string   `json:"source_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.AuthorID <= 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "author_id is required")
+		return
+	}
+
+	author, err := h.repo.SplitAuthor(req.AuthorID, req.BookIDs, req.Name, req.SourceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrAuthorNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+		case errors.Is(err, storage.ErrInvalidSplit):
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		default:
+			log.Printf("SplitAuthor: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}
+		return
+	}
+	h.recordSyncChanges(req.BookIDs...)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(author); err != nil {
+		log.Printf("SplitAuthor: failed to encode response: %v", err)
+	}
+}
+
+// SetAuthorAmbiguous marks an author name as possibly shared by several
+// people, which OPDS author feeds then point out (admin only).
+// PUT /api/v1/admin/authors/{id}/ambiguous
+func (h *Handlers) SetAuthorAmbiguous(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid author ID")
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+			return
+		}
+	}
+
+	if err := h.repo.SetAuthorAmbiguous(authorID, req.Note); err != nil {
+		if errors.Is(err, storage.ErrAuthorNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+			return
+		}
+		log.Printf("SetAuthorAmbiguous: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("SetAuthorAmbiguous: failed to encode response: %v", err)
+	}
+}
+
+// ClearAuthorAmbiguous removes the mark set by SetAuthorAmbiguous (admin only).
+// DELETE /api/v1/admin/authors/{id}/ambiguous
+func (h *Handlers) ClearAuthorAmbiguous(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid author ID")
+		return
+	}
+
+	cleared, err := h.repo.ClearAuthorAmbiguous(authorID)
+	if err != nil {
+		log.Printf("ClearAuthorAmbiguous: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !cleared {
+		writeError(w, http.StatusNotFound, codeNotFound, "Author is not marked as ambiguous")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("ClearAuthorAmbiguous: failed to encode response: %v", err)
+	}
+}
+
+// ListImportErrors returns INP lines skipped during the last reindex (admin only).
+// GET /api/v1/reindex/errors
+func (h *Handlers) ListImportErrors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := parseInt(query.Get("offset"), 0)
+
+	errs, total, err := h.repo.ListImportErrors(limit, offset)
+	if err != nil {
+		log.Printf("ListImportErrors: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if errs == nil {
+		errs = []storage.ImportError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": errs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}); err != nil {
+		log.Printf("ListImportErrors: failed to encode response: %v", err)
+	}
+}
+
+// ListUnmappedGenres returns the genre codes the last reindex could not map
+// to known genres, with their numbers of books (admin only).
+// GET /api/v1/reindex/genres
+func (h *Handlers) ListUnmappedGenres(w http.ResponseWriter, r *http.Request) {
+	genres, err := h.repo.ListUnmappedGenres()
+	if err != nil {
+		log.Printf("ListUnmappedGenres: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if genres == nil {
+		genres = []storage.FacetCount{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"genres": genres,
+		"total":  len(genres),
+	}); err != nil {
+		log.Printf("ListUnmappedGenres: failed to encode response: %v", err)
+	}
+}
+
+// SetOPDSValidation enables the OPDS validator endpoint for the catalog
+// served by opdsHandler.
+func (h *Handlers) SetOPDSValidation(opdsHandler *opds.Handler) {
+	h.opdsRouter = NewOPDSRouter(opdsHandler)
+	h.opdsHandler = opdsHandler
+}
+
+// ValidateOPDS crawls the OPDS catalog in-process and reports OPDS 1.2
+// violations (admin only). max_pages bounds the number of feeds fetched.
+// GET /api/v1/admin/opds/validate
+func (h *Handlers) ValidateOPDS(w http.ResponseWriter, r *http.Request) {
+	if h.opdsRouter == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "OPDS validation is not configured")
+		return
+	}
+
+	maxPages := parseInt(r.URL.Query().Get("max_pages"), 0)
+	validator := opds.NewValidator(opds.HandlerClient(h.opdsRouter), maxPages)
+	report, err := validator.Validate(r.Context(), h.opdsHandler.BaseURL()+"/opds")
+	if err != nil {
+		log.Printf("ValidateOPDS: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("ValidateOPDS: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// archiveStatus is an archive of the catalog as found on disk
+type archiveStatus struct {
+	storage.Archive
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// archiveEntry is a file inside an archive and the book stored in it
+type archiveEntry struct {
+	Name           string    `json:"name"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressed_size"`
+	Modified       time.Time `json:"modified"`
+	BookID         string    `json:"book_id,omitempty"`
+}
+
+// missingArchiveBook is a book of an archive that has no file for it
+type missingArchiveBook struct {
+	storage.ArchiveBook
+	Expected []string `json:"expected"`
+}
+
+// ListArchives lists every archive the catalog refers to, with the number
+// of its books and whether the file is in the books directory (admin only).
+// GET /api/v1/admin/archives
+func (h *Handlers) ListArchives(w http.ResponseWriter, r *http.Request) {
+	archives, err := h.repo.ListArchives()
+	if err != nil {
+		log.Printf("ListArchives: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	statuses := make([]archiveStatus, 0, len(archives))
+	for _, archive := range archives {
+		status := archiveStatus{Archive: archive}
+		path, err := h.archiveFilePath(archive.ArchivePath)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(path); err == nil {
+				status.Exists, status.Size = true, info.Size()
+			}
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"archives": statuses}); err != nil {
+		log.Printf("ListArchives: failed to encode response: %v", err)
+	}
+}
+
+// ArchiveEntries lists the files of an archive, read from its ZIP central
+// directory, next to the books the catalog expects in it. Books without a
+// matching file are listed under missing; they are what downloads report
+// as "Book file not found in archive" (admin only). The path is relative
+// to the library, as in the INPX.
+// GET /api/v1/admin/archives/{path}/entries
+func (h *Handlers) ArchiveEntries(w http.ResponseWriter, r *http.Request) {
+	archiveName, ok := strings.CutSuffix(strings.Trim(chi.URLParam(r, "*"), "/"), "/entries")
+	if !ok || archiveName == "" {
+		writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+		return
+	}
+	if h.booksUnavailable(w) {
+		return
+	}
+
+	path, err := h.archiveFilePath(archiveName)
+	if err != nil {
+		if errors.Is(err, errInvalidArchivePath) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid archive path")
+			return
+		}
+		log.Printf("ArchiveEntries: archive=%s error: %v", archiveName, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to open archive")
+		return
+	}
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Archive not found")
+			return
+		}
+		log.Printf("ArchiveEntries: archive=%s error: %v", archiveName, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to open archive: "+err.Error())
+		return
+	}
+	defer archive.Close()
+
+	books, err := h.repo.ListArchiveBooks(archiveName)
+	if err != nil {
+		log.Printf("ArchiveEntries: archive=%s error: %v", archiveName, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	entries := make([]archiveEntry, 0, len(archive.File))
+	index := make(map[string]int, len(archive.File))
+	for i, file := range archive.File {
+		index[strings.ToLower(file.Name)] = i
+		entries = append(entries, archiveEntry{
+			Name:           file.Name,
+			Size:           file.UncompressedSize64,
+			CompressedSize: file.CompressedSize64,
+			Modified:       file.Modified,
+		})
+	}
+
+	missing := []missingArchiveBook{}
+	for _, book := range books {
+		names := bookFileNames(book.ID, book.Format)
+		found := false
+		for _, name := range names {
+			if i, ok := index[strings.ToLower(name)]; ok {
+				entries[i].BookID, found = book.ID, true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, missingArchiveBook{ArchiveBook: book, Expected: names})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"archive_path": archiveName,
+		"books":        len(books),
+		"entries":      entries,
+		"missing":      missing,
+	}); err != nil {
+		log.Printf("ArchiveEntries: failed to encode response: %v", err)
+	}
+}
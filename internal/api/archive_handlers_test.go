@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// TestArchives verifies the archive listing and the entries of an archive,
+// including a book its archive lacks.
+func TestArchives(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "test-002", Title: "Lost Book", Authors: []string{"Test Author"}, ArchivePath: "test-archive", Format: "fb2", Date: time.Now()},
+		{ID: "gone-001", Title: "Gone Book", Authors: []string{"Test Author"}, ArchivePath: "gone.zip", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ListArchives(w, httptest.NewRequest("GET", "/api/v1/admin/archives", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list struct {
+		Archives []archiveStatus `json:"archives"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Archives) != 2 ||
+		list.Archives[0].ArchivePath != "gone.zip" || list.Archives[0].Exists ||
+		list.Archives[1].ArchivePath != "test-archive" || !list.Archives[1].Exists || list.Archives[1].Books != 2 || list.Archives[1].Size == 0 {
+		t.Errorf("unexpected archives: %+v", list.Archives)
+	}
+
+	entries := func(path string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("*", path)
+		req := httptest.NewRequest("GET", "/api/v1/admin/archives/"+path, nil)
+		w := httptest.NewRecorder()
+		h.ArchiveEntries(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	w = entries("test-archive/entries")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result struct {
+		Entries []archiveEntry       `json:"entries"`
+		Missing []missingArchiveBook `json:"missing"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Name != "test-001.fb2" || result.Entries[0].BookID != "test-001" {
+		t.Errorf("unexpected entries: %+v", result.Entries)
+	}
+	if len(result.Missing) != 1 || result.Missing[0].ID != "test-002" || result.Missing[0].Expected[0] != "test-002.fb2" {
+		t.Errorf("unexpected missing books: %+v", result.Missing)
+	}
+
+	if w := entries("gone.zip/entries"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing archive, got %d", w.Code)
+	}
+	if w := entries("../secret/entries"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a path outside the library, got %d", w.Code)
+	}
+	if w := entries("test-archive"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without /entries, got %d", w.Code)
+	}
+}
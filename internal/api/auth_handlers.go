@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -29,7 +31,7 @@ func (h *Handlers) GetAuthInfo(w http.ResponseWriter, r *http.Request) {
 // POST /api/v1/auth/login
 func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	if !h.authMw.IsEnabled() {
-		http.Error(w, "Authentication is not enabled", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
 		return
 	}
 
@@ -38,30 +40,30 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if req.Username == "" || req.Password == "" {
-		http.Error(w, "Username and password are required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Username and password are required")
 		return
 	}
 
 	user, err := h.repo.AuthenticateUser(req.Username, req.Password)
 	if err != nil {
 		log.Printf("Login: authentication error for user %s: %v", req.Username, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 	if user == nil {
-		http.Error(w, "Неверное имя пользователя или пароль", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Неверное имя пользователя или пароль")
 		return
 	}
 
 	session, err := h.repo.CreateSession(user.ID, sessionDuration)
 	if err != nil {
 		log.Printf("Login: failed to create session for user %s: %v", user.Username, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -92,13 +94,12 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 // POST /api/v1/auth/logout
 func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
 	if !h.authMw.IsEnabled() {
-		http.Error(w, "Authentication is not enabled", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
 		return
 	}
 
-	cookie, err := r.Cookie(h.authMw.CookieName())
-	if err == nil && cookie.Value != "" {
-		if err := h.repo.DeleteSession(cookie.Value); err != nil {
+	if token := h.authMw.SessionToken(r); token != "" {
+		if err := h.repo.DeleteSession(token); err != nil {
 			log.Printf("Logout: failed to delete session: %v", err)
 		}
 	}
@@ -125,7 +126,7 @@ func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := h.repo.ListUsers()
 	if err != nil {
 		log.Printf("ListUsers: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -163,16 +164,16 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 		IsAdmin     bool   `json:"is_admin"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if req.Username == "" || req.Password == "" {
-		http.Error(w, "Имя пользователя и пароль обязательны", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Имя пользователя и пароль обязательны")
 		return
 	}
 	if len(req.Password) < 6 {
-		http.Error(w, "Пароль должен быть не менее 6 символов", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Пароль должен быть не менее 6 символов")
 		return
 	}
 
@@ -180,11 +181,11 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 	existing, err := h.repo.GetUserByUsername(req.Username)
 	if err != nil {
 		log.Printf("CreateUser: check existing user: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 	if existing != nil {
-		http.Error(w, "Пользователь с таким именем уже существует", http.StatusConflict)
+		writeError(w, http.StatusConflict, codeConflict, "Пользователь с таким именем уже существует")
 		return
 	}
 
@@ -196,7 +197,7 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 	user, err := h.repo.CreateUser(req.Username, req.Password, displayName, req.IsAdmin)
 	if err != nil {
 		log.Printf("CreateUser: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -218,24 +219,24 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User ID is required")
 		return
 	}
 
 	// Prevent self-deletion
 	currentUser := auth.UserFromContext(r.Context())
 	if currentUser != nil && currentUser.ID == userID {
-		http.Error(w, "Нельзя удалить самого себя", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Нельзя удалить самого себя")
 		return
 	}
 
 	if err := h.repo.DeleteUser(userID); err != nil {
 		if err.Error() == "user not found" {
-			http.Error(w, "Пользователь не найден", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, codeNotFound, "Пользователь не найден")
 			return
 		}
 		log.Printf("DeleteUser: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -250,7 +251,7 @@ func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User ID is required")
 		return
 	}
 
@@ -258,21 +259,21 @@ func (h *Handlers) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
 		return
 	}
 	if len(req.Password) < 6 {
-		http.Error(w, "Пароль должен быть не менее 6 символов", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Пароль должен быть не менее 6 символов")
 		return
 	}
 
 	if err := h.repo.UpdateUserPassword(userID, req.Password); err != nil {
 		if err.Error() == "user not found" {
-			http.Error(w, "Пользователь не найден", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, codeNotFound, "Пользователь не найден")
 			return
 		}
 		log.Printf("UpdateUserPassword: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
 		return
 	}
 
@@ -282,17 +283,59 @@ func (h *Handlers) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UpdateUserEmail sets the address a user's saved searches may mail new
+// books to from {"email": "..."}; an empty address removes it (admin only).
+// PUT /api/v1/admin/users/{id}/email
+func (h *Handlers) UpdateUserEmail(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "User ID is required")
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if req.Email = strings.TrimSpace(req.Email); req.Email != "" {
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid email address")
+			return
+		}
+		req.Email = addr.Address
+	}
+
+	if err := h.repo.SetUserEmail(userID, req.Email); err != nil {
+		if err.Error() == "user not found" {
+			writeError(w, http.StatusNotFound, codeNotFound, "Пользователь не найден")
+			return
+		}
+		log.Printf("UpdateUserEmail: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("UpdateUserEmail: failed to encode response: %v", err)
+	}
+}
+
 // GetMe returns the currently authenticated user's info.
 // GET /api/v1/auth/me
 func (h *Handlers) GetMe(w http.ResponseWriter, r *http.Request) {
 	if !h.authMw.IsEnabled() {
-		http.Error(w, "Authentication is not enabled", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
 		return
 	}
 
 	user := auth.UserFromContext(r.Context())
 	if user == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -301,6 +344,7 @@ func (h *Handlers) GetMe(w http.ResponseWriter, r *http.Request) {
 		"id":           user.ID,
 		"username":     user.Username,
 		"display_name": user.DisplayName,
+		"email":        user.Email,
 		"is_admin":     user.IsAdmin,
 	}); err != nil {
 		log.Printf("GetMe: failed to encode response: %v", err)
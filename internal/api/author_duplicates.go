@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// authorDuplicatesReport is the current or most recent search for
+// duplicate authors
+type authorDuplicatesReport struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+
+	duplicates []storage.AuthorDuplicate
+}
+
+// StartAuthorDuplicatesJob looks for likely duplicate authors in the
+// background, replacing the previous report when done. It returns false if
+// the search is already running.
+func (h *Handlers) StartAuthorDuplicatesJob() bool {
+	h.duplicatesMu.Lock()
+	defer h.duplicatesMu.Unlock()
+	if h.duplicates.Running {
+		return false
+	}
+	// The previous suggestions stay listed until the new ones are ready
+	now := time.Now()
+	h.duplicates = authorDuplicatesReport{Running: true, StartedAt: &now, duplicates: h.duplicates.duplicates}
+
+	go func() {
+		duplicates, err := h.repo.FindAuthorDuplicates()
+		finished := time.Now()
+
+		h.duplicatesMu.Lock()
+		defer h.duplicatesMu.Unlock()
+		h.duplicates.Running = false
+		h.duplicates.FinishedAt = &finished
+		if err != nil {
+			log.Printf("Author duplicates: %v", err)
+			h.duplicates.Error = err.Error()
+			return
+		}
+		h.duplicates.Error = ""
+		h.duplicates.duplicates = duplicates
+		log.Printf("Author duplicates: found %d likely duplicates in %s", len(duplicates), finished.Sub(now).Truncate(time.Millisecond))
+	}()
+	return true
+}
+
+// forgetAuthor drops the suggestions of an author that no longer exists
+func (h *Handlers) forgetAuthor(authorID int) {
+	h.duplicatesMu.Lock()
+	defer h.duplicatesMu.Unlock()
+	kept := h.duplicates.duplicates[:0:0]
+	for _, duplicate := range h.duplicates.duplicates {
+		if duplicate.Source.ID != authorID && duplicate.Target.ID != authorID {
+			kept = append(kept, duplicate)
+		}
+	}
+	h.duplicates.duplicates = kept
+}
+
+// ListAuthorDuplicates returns the pairs of authors that likely name one
+// person, surest first, as found after the last reindex (admin only). Each
+// pair can be passed to the merge endpoint as source_id and target_id. The
+// first request starts the search if it has not run yet.
+// GET /api/v1/admin/author-duplicates?reason=...&limit=N&offset=N
+func (h *Handlers) ListAuthorDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	reason := query.Get("reason")
+	switch reason {
+	case "", storage.DuplicateSameName, storage.DuplicateTransliteration, storage.DuplicateSimilar:
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			"reason must be same_name, transliteration or similar")
+		return
+	}
+	limit := parseInt(query.Get("limit"), 50)
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := max(parseInt(query.Get("offset"), 0), 0)
+
+	h.duplicatesMu.Lock()
+	started := h.duplicates.StartedAt != nil
+	h.duplicatesMu.Unlock()
+	if !started {
+		h.StartAuthorDuplicatesJob()
+	}
+
+	h.duplicatesMu.Lock()
+	report := h.duplicates
+	h.duplicatesMu.Unlock()
+
+	counts := map[string]int{
+		storage.DuplicateSameName:        0,
+		storage.DuplicateTransliteration: 0,
+		storage.DuplicateSimilar:         0,
+	}
+	matching := []storage.AuthorDuplicate{}
+	for _, duplicate := range report.duplicates {
+		counts[duplicate.Reason]++
+		if reason == "" || duplicate.Reason == reason {
+			matching = append(matching, duplicate)
+		}
+	}
+	total := len(matching)
+	page := matching[min(offset, total):min(offset+limit, total)]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":        report,
+		"duplicates": page,
+		"counts":     counts,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	}); err != nil {
+		log.Printf("ListAuthorDuplicates: failed to encode response: %v", err)
+	}
+}
+
+// StartAuthorDuplicates searches for duplicate authors again (admin only).
+// POST /api/v1/admin/author-duplicates/start
+func (h *Handlers) StartAuthorDuplicates(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusAccepted
+	if !h.StartAuthorDuplicatesJob() {
+		status = http.StatusConflict
+	}
+
+	h.duplicatesMu.Lock()
+	report := h.duplicates
+	h.duplicatesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("StartAuthorDuplicates: failed to encode response: %v", err)
+	}
+}
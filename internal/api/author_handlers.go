@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/enrichment"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// authorPortraitTimeout bounds how long a request waits for a portrait.
+const authorPortraitTimeout = 10 * time.Second
+
+// SetAuthorEnrichment enables author bio/portrait lookups.
+func (h *Handlers) SetAuthorEnrichment(service *enrichment.Service) {
+	h.enricher = service
+}
+
+// GetAuthor returns an author with book count, including books under the
+// author's other names, those names, namesakes it may be confused with and,
+// when enrichment is enabled, a biography and portrait. Only info fetched
+// before is shown; missing info is fetched in the background.
+// GET /api/v1/authors/{id}
+func (h *Handlers) GetAuthor(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid author ID")
+		return
+	}
+
+	author, err := h.repo.GetAuthorByID(authorID)
+	if err != nil {
+		log.Printf("GetAuthor: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if author == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+		return
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("GetAuthor: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	books, err := h.repo.SearchBooks(storage.BookFilter{Authors: []string{author.Name}, Limit: 1, Hidden: hidden})
+	if err != nil {
+		log.Printf("GetAuthor: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	names, err := h.repo.AuthorNames(author.Name)
+	if err != nil {
+		log.Printf("GetAuthor: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	disambiguation, err := h.repo.GetAuthorDisambiguation(author)
+	if err != nil {
+		log.Printf("GetAuthor: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	var info *storage.AuthorInfo
+	if cached := h.enricher.Cached(author.Name); cached != nil {
+		// Clients load the portrait through this server
+		info = cached
+		if info.PhotoURL != "" {
+			info.PhotoURL = fmt.Sprintf("/api/v1/authors/%d/portrait", author.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             author.ID,
+		"name":           author.Name,
+		"source_id":      author.SourceID,
+		"book_count":     books.Total,
+		"aliases":        names[1:],
+		"disambiguation": disambiguation,
+		"info":           info,
+	}); err != nil {
+		log.Printf("GetAuthor: failed to encode response: %v", err)
+	}
+}
+
+// GetAuthorPortrait serves the portrait of an author found by enrichment.
+// The server downloads it, so clients do not contact Wikimedia.
+// GET /api/v1/authors/{id}/portrait
+func (h *Handlers) GetAuthorPortrait(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid author ID")
+		return
+	}
+	if h.enricher == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Portrait not found")
+		return
+	}
+	author, err := h.repo.GetAuthorByID(authorID)
+	if err != nil {
+		log.Printf("GetAuthorPortrait: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if author == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), authorPortraitTimeout)
+	defer cancel()
+	data, contentType, err := h.enricher.Portrait(ctx, author.Name)
+	if err != nil {
+		log.Printf("GetAuthorPortrait: author_id=%d: %v", author.ID, err)
+		writeError(w, http.StatusBadGateway, codeUpstreamFailed, "Failed to load the portrait")
+		return
+	}
+	if data == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Portrait not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("GetAuthorPortrait: failed to write response: %v", err)
+	}
+}
+
+// ListTopAuthors returns the authors with the most books, most first, in
+// the language given, or in all languages. The list holds at most
+// storage.TopAuthorsLimit authors and is ranked at import.
+// GET /api/v1/authors/top?language=ru&limit=30&offset=0
+func (h *Handlers) ListTopAuthors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := parseInt(query.Get("offset"), 0)
+
+	authors, total, err := h.repo.ListTopAuthors(query.Get("language"), limit, offset)
+	if err != nil {
+		log.Printf("ListTopAuthors: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"authors": authors,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	}); err != nil {
+		log.Printf("ListTopAuthors: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+//go:embed templates/book.html
+var bookPageFS embed.FS
+
+var bookPageTemplate = template.Must(template.ParseFS(bookPageFS, "templates/book.html"))
+
+// maxPageDescription bounds the meta description taken from the annotation
+const maxPageDescription = 300
+
+// publicSite describes the public address and name of the library
+type publicSite struct {
+	baseURL string
+	title   string
+}
+
+// bookPage is the data of the book page template
+type bookPage struct {
+	Book        *storage.Book
+	SiteTitle   string
+	URL         string
+	CoverURL    string
+	DownloadURL string
+	AuthorNames string
+	Description string
+	Lang        string
+	Size        string
+}
+
+// SetPublicSite sets the externally visible base URL and the library name
+// used in book pages. It may be called again, e.g. on configuration reload.
+func (h *Handlers) SetPublicSite(baseURL, title string) {
+	h.site.Store(&publicSite{baseURL: strings.TrimSuffix(baseURL, "/"), title: title})
+}
+
+// BookPage renders a static HTML page of a book with schema.org microdata,
+// so that books can be shared and indexed without the SPA.
+// GET /books/{id}
+func (h *Handlers) BookPage(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+
+	book, err := h.repo.GetBookByID(bookID)
+	if err != nil {
+		log.Printf("BookPage: book_id=%s: %v", bookID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if book == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	site := h.site.Load()
+	if site == nil {
+		site = &publicSite{title: "Pushkinlib"}
+	}
+
+	page := bookPage{
+		Book:        book,
+		SiteTitle:   site.title,
+		DownloadURL: "/download/" + book.ID,
+		Description: pageDescription(book.Annotation),
+		Lang:        book.Language,
+		Size:        formatSize(book.FileSize),
+	}
+	if site.baseURL != "" {
+		page.URL = site.baseURL + "/books/" + book.ID
+	}
+	if book.HasCover {
+		page.CoverURL = covers.URL(site.baseURL, book.ID, book.CoverHash)
+	}
+	names := make([]string, len(book.Authors))
+	for i, author := range book.Authors {
+		names[i] = author.Name
+	}
+	page.AuthorNames = strings.Join(names, ", ")
+	if page.Lang == "" {
+		page.Lang = "ru"
+	}
+
+	// Render first so that a template error is not sent as a half page
+	var buf bytes.Buffer
+	if err := bookPageTemplate.Execute(&buf, page); err != nil {
+		log.Printf("BookPage: book_id=%s: failed to render page: %v", bookID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// pageDescription shortens an annotation to a single-line meta description
+func pageDescription(annotation string) string {
+	text := []rune(strings.Join(strings.Fields(annotation), " "))
+	if len(text) <= maxPageDescription {
+		return string(text)
+	}
+	return strings.TrimSpace(string(text[:maxPageDescription])) + "…"
+}
+
+// formatSize formats a file size for people
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.0f КБ", float64(bytes)/(1<<10))
+	default:
+		return fmt.Sprintf("%d Б", bytes)
+	}
+}
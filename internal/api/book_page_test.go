@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBookPage verifies the static book page carries metadata and
+// schema.org microdata.
+func TestBookPage(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetPublicSite("https://lib.example/", "Моя библиотека")
+
+	w := httptest.NewRecorder()
+	h.BookPage(w, withBookID(httptest.NewRequest("GET", "/books/test-001", nil), "test-001"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected HTML, got %s", ct)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`<title>Test Book Title — Test Author | Моя библиотека</title>`,
+		`<link rel="canonical" href="https://lib.example/books/test-001">`,
+		`itemtype="https://schema.org/Book"`,
+		`<span itemprop="name">Test Author</span>`,
+		`<span itemprop="datePublished">2024</span>`,
+		`<div class="annotation" itemprop="description">Test annotation text</div>`,
+		`href="/download/test-001"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %s:\n%s", want, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.BookPage(w, withBookID(httptest.NewRequest("GET", "/books/missing", nil), "missing"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// booksProbeTimeout bounds a probe; a hung NFS mount blocks file calls
+// instead of failing them
+const booksProbeTimeout = 10 * time.Second
+
+// booksStatus is the result of the last probe of the books directory
+type booksStatus struct {
+	Available bool      `json:"available"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// StartBooksProbe checks now and then every interval that the books
+// directory is readable and not empty, as an unmounted share is. While it
+// is not, /health reports degraded and requests that read book files get
+// 503; browsing and search keep working from the database.
+func (h *Handlers) StartBooksProbe(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	h.booksProbeInterval = interval
+	h.ProbeBooks()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.ProbeBooks()
+			}
+		}
+	}()
+}
+
+// ProbeBooks checks the books directory and records the result. State
+// changes are logged.
+func (h *Handlers) ProbeBooks() booksStatus {
+	// A previous probe stuck on a hung mount must not pile up goroutines
+	if !h.booksProbing.CompareAndSwap(false, true) {
+		return h.markBooks(errors.New("books directory does not respond"))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer h.booksProbing.Store(false)
+		done <- probeBooksDir(h.booksDir)
+	}()
+
+	select {
+	case err := <-done:
+		return h.markBooks(err)
+	case <-time.After(booksProbeTimeout):
+		return h.markBooks(fmt.Errorf("books directory did not respond within %s", booksProbeTimeout))
+	}
+}
+
+func (h *Handlers) markBooks(err error) booksStatus {
+	status := booksStatus{Available: err == nil, CheckedAt: time.Now().UTC()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	previous := h.books.Swap(&status)
+	switch {
+	case !status.Available && (previous == nil || previous.Available || previous.Error != status.Error):
+		log.Printf("Books directory %s unavailable: %s", h.booksDir, status.Error)
+	case status.Available && previous != nil && !previous.Available:
+		log.Printf("Books directory available again")
+	}
+	return status
+}
+
+// probeBooksDir reports why dir cannot serve books, or nil. The reason is
+// shown by /health without signing in, so it leaves out the path.
+func probeBooksDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open books directory: %w", withoutPath(err))
+	}
+	defer f.Close()
+
+	if _, err := f.Readdirnames(1); err != nil {
+		if err == io.EOF {
+			return errors.New("books directory is empty; is the share mounted?")
+		}
+		return fmt.Errorf("cannot read books directory: %w", withoutPath(err))
+	}
+	return nil
+}
+
+// withoutPath drops the file name from a file system error
+func withoutPath(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// booksUnavailable answers 503 when the last probe failed. Handlers that
+// read book files call it before touching the books directory.
+func (h *Handlers) booksUnavailable(w http.ResponseWriter) bool {
+	status := h.books.Load()
+	if status == nil || status.Available {
+		return false
+	}
+	if h.booksProbeInterval > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.booksProbeInterval/time.Second)))
+	}
+	writeError(w, http.StatusServiceUnavailable, codeUnavailable,
+		"Book files are temporarily unavailable ("+status.Error+"); browsing and search still work")
+	return true
+}
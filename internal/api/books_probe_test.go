@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBooksProbe verifies an empty books directory, as left by an unmounted
+// share, degrades downloads but not search, and is reported by a health
+// check that stays 200.
+func TestBooksProbe(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	if status := h.ProbeBooks(); status.Available || status.Error == "" {
+		t.Fatalf("expected an empty directory to be unavailable, got %+v", status)
+	}
+
+	w := httptest.NewRecorder()
+	h.HealthCheck(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("health: expected 200, got %d", w.Code)
+	}
+	var health struct {
+		Status string      `json:"status"`
+		Books  booksStatus `json:"books"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil {
+		t.Fatalf("failed to decode health response: %v", err)
+	}
+	if health.Status != "degraded" || health.Books.Available {
+		t.Errorf("unexpected health: %+v", health)
+	}
+	if strings.Contains(health.Books.Error, h.booksDir) {
+		t.Errorf("expected the books path not to be shown, got %q", health.Books.Error)
+	}
+
+	w = httptest.NewRecorder()
+	h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001", nil), "test-001"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("download: expected 503, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.SearchBooks(w, httptest.NewRequest("GET", "/api/v1/books?q=Test", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("search: expected 200, got %d", w.Code)
+	}
+
+	// The share comes back
+	writeTestArchive(t, h.booksDir)
+	if status := h.ProbeBooks(); !status.Available {
+		t.Fatalf("expected the directory to be available, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	h.HealthCheck(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("health: expected 200, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001", nil), "test-001"))
+	if w.Code != http.StatusOK {
+		t.Errorf("download: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
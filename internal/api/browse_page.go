@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+//go:embed templates/browse.html
+var browsePageFS embed.FS
+
+var browsePageTemplate = template.Must(template.ParseFS(browsePageFS, "templates/browse.html"))
+
+// browsePageSize is the number of books on a page of the HTML catalog
+const browsePageSize = 30
+
+// browsePage is the data of the catalog page template
+type browsePage struct {
+	SiteTitle   string
+	Query       string
+	Error       string
+	Books       []browseEntry
+	Total       int
+	Page        int
+	Pages       int
+	PrevURL     string
+	NextURL     string
+	Suggestions []browseLink
+}
+
+// browseEntry is a book on a catalog page
+type browseEntry struct {
+	Book        *storage.Book
+	AuthorNames string
+	Size        string
+	PageURL     string
+	DownloadURL string
+}
+
+// browseLink is a link with its text
+type browseLink struct {
+	Text string
+	URL  string
+}
+
+// BrowsePage renders the catalog as plain HTML without JavaScript, for
+// e-ink and other simple browsers: a search box, the books found or the
+// newest books, and pages of 30 books. It searches like the API and OPDS.
+// GET /browse?q=...&page=N
+func (h *Handlers) BrowsePage(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	pageNum := parseInt(r.URL.Query().Get("page"), 1)
+	if pageNum < 1 {
+		pageNum = 1
+	}
+
+	site := h.site.Load()
+	if site == nil {
+		site = &publicSite{title: "Pushkinlib"}
+	}
+	page := browsePage{SiteTitle: site.title, Query: query, Page: pageNum}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("BrowsePage: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	filter := storage.BookFilter{
+		Query:  query,
+		Limit:  browsePageSize,
+		Offset: (pageNum - 1) * browsePageSize,
+		Hidden: hidden,
+	}
+	if query == "" {
+		filter.SortBy, filter.SortOrder = "date_added", "desc"
+	}
+
+	status := http.StatusOK
+	result, err := h.repo.SearchBooks(filter)
+	switch {
+	case errors.Is(err, storage.ErrInvalidQuery):
+		status = http.StatusBadRequest
+		page.Error = err.Error()
+	case err != nil:
+		log.Printf("BrowsePage: q=%q: %v", query, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	default:
+		page.Total = result.Total
+		page.Pages = (result.Total + browsePageSize - 1) / browsePageSize
+		for i := range result.Books {
+			book := &result.Books[i]
+			names := make([]string, len(book.Authors))
+			for j, author := range book.Authors {
+				names[j] = author.Name
+			}
+			page.Books = append(page.Books, browseEntry{
+				Book:        book,
+				AuthorNames: strings.Join(names, ", "),
+				Size:        formatSize(book.FileSize),
+				PageURL:     "/books/" + url.PathEscape(book.ID),
+				DownloadURL: "/download/" + url.PathEscape(book.ID),
+			})
+		}
+		if pageNum > 1 {
+			page.PrevURL = browseURL(query, pageNum-1)
+		}
+		if result.HasMore {
+			page.NextURL = browseURL(query, pageNum+1)
+		}
+		for _, suggestion := range result.Suggestions {
+			page.Suggestions = append(page.Suggestions, browseLink{Text: suggestion, URL: browseURL(suggestion, 1)})
+		}
+	}
+
+	// Render first so that a template error is not sent as a half page
+	var buf bytes.Buffer
+	if err := browsePageTemplate.Execute(&buf, page); err != nil {
+		log.Printf("BrowsePage: failed to render page: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// browseURL returns the address of a catalog page
+func browseURL(query string, page int) string {
+	values := url.Values{}
+	if query != "" {
+		values.Set("q", query)
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	if len(values) == 0 {
+		return "/browse"
+	}
+	return "/browse?" + values.Encode()
+}
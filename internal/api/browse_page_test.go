@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBrowsePage verifies the HTML catalog lists books with download links
+// and searches without JavaScript.
+func TestBrowsePage(t *testing.T) {
+	h := setupTestHandlers(t)
+	router := SetupRoutes(h)
+
+	for _, tc := range []struct {
+		path     string
+		want     int
+		contains []string
+		excludes []string
+	}{
+		{"/browse", http.StatusOK, []string{
+			`<form action="/browse" method="get"`,
+			`Новые поступления`,
+			`<a class="title" href="/books/test-001">Test Book Title</a>`,
+			`<a href="/download/test-001" rel="nofollow">`,
+		}, []string{"<script"}},
+		{"/browse?q=Test", http.StatusOK, []string{
+			`value="Test"`,
+			`Найдено книг: 1`,
+			`href="/books/test-001"`,
+		}, []string{`rel="next"`, `rel="prev"`}},
+		{"/browse?q=nonexistentbook", http.StatusOK, []string{`Ничего не найдено.`}, []string{`href="/books/test-001"`}},
+		{"/browse?q=Test&page=2", http.StatusOK, []string{`<a href="/browse?q=Test" rel="prev">`}, []string{`href="/books/test-001"`}},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.path, tc.want, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%s: expected HTML, got %s", tc.path, ct)
+		}
+		body := w.Body.String()
+		for _, want := range tc.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: page does not contain %s:\n%s", tc.path, want, body)
+			}
+		}
+		for _, unwanted := range tc.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: page contains %s", tc.path, unwanted)
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/convcache"
+)
+
+// SetConversionCache configures the cache of converted book files.
+func (h *Handlers) SetConversionCache(cache *convcache.Cache) {
+	h.convCache = cache
+}
+
+// GetCacheStats returns the size, limit and hit statistics of the
+// converted-file cache (admin only).
+// GET /api/v1/admin/cache
+func (h *Handlers) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.convCache == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The conversion cache is not configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.convCache.Stats()); err != nil {
+		log.Printf("GetCacheStats: failed to encode response: %v", err)
+	}
+}
+
+// PurgeCache deletes every converted file from the cache (admin only).
+// DELETE /api/v1/admin/cache
+func (h *Handlers) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if h.convCache == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The conversion cache is not configured")
+		return
+	}
+
+	files, size, err := h.convCache.Purge(r.Context())
+	if err != nil {
+		log.Printf("PurgeCache: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	log.Printf("PurgeCache: removed %d files, %d bytes", files, size)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": files,
+		"freed": size,
+	}); err != nil {
+		log.Printf("PurgeCache: failed to encode response: %v", err)
+	}
+}
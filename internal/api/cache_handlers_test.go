@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/blob"
+	"github.com/piligrim/pushkinlib/internal/convcache"
+)
+
+// TestConversionCacheEndpoints checks the stats and purge endpoints.
+func TestConversionCacheEndpoints(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.GetCacheStats(w, httptest.NewRequest("GET", "/api/v1/admin/cache", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a cache, got %d", w.Code)
+	}
+
+	cache := convcache.New(blob.NewLocal(t.TempDir()), 1<<20)
+	h.SetConversionCache(cache)
+	if err := cache.Put(context.Background(), convcache.Key(".epub", "book"), []byte("epub")); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	h.GetCacheStats(w, httptest.NewRequest("GET", "/api/v1/admin/cache", nil))
+	var stats convcache.Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if stats.Files != 1 || stats.Size != 4 || stats.MaxSize != 1<<20 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	h.PurgeCache(w, httptest.NewRequest("DELETE", "/api/v1/admin/cache", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := cache.Stats(); stats.Files != 0 || stats.Size != 0 {
+		t.Errorf("expected an empty cache after purge, got %+v", stats)
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// Verification outcomes reported by VerifyBook
+const (
+	checksumComputed = "computed" // no checksum was stored; it is now
+	checksumOK       = "ok"       // file matches the stored checksum
+	checksumMismatch = "mismatch" // file differs from the stored checksum
+	checksumUpdated  = "updated"  // file differed and the new checksum was stored
+)
+
+// verifyResult is the response of VerifyBook
+type verifyResult struct {
+	BookID       string `json:"book_id"`
+	Status       string `json:"status"`
+	Match        bool   `json:"match"`
+	SHA256       string `json:"sha256"`
+	StoredSHA256 string `json:"stored_sha256,omitempty"`
+	Size         int64  `json:"size"`
+}
+
+// checksumWriter hashes everything written through it
+type checksumWriter struct {
+	hash hash.Hash
+	size int64
+}
+
+func newChecksumWriter() *checksumWriter {
+	return &checksumWriter{hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// sum returns the hex SHA-256 of the data written so far
+func (c *checksumWriter) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// hashBookFile computes the SHA-256 and size of a book file in its archive.
+func (h *Handlers) hashBookFile(book *storage.Book) (string, int64, error) {
+	rc, cleanup, err := h.openBookFromArchive(book)
+	if err != nil {
+		return "", 0, err
+	}
+	defer cleanup()
+
+	cw := newChecksumWriter()
+	if _, err := io.Copy(cw, rc); err != nil {
+		return "", 0, err
+	}
+	return cw.sum(), cw.size, nil
+}
+
+// VerifyBook recomputes the SHA-256 of a book file and compares it with the
+// stored checksum. A missing checksum is stored; a mismatch is only stored
+// with ?update=true (admin only).
+// POST /api/v1/books/{id}/verify
+func (h *Handlers) VerifyBook(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	book, err := h.repo.GetBookByID(bookID)
+	if err != nil {
+		log.Printf("VerifyBook: book_id=%s database error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if book == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		return
+	}
+	if h.booksUnavailable(w) {
+		return
+	}
+
+	sum, size, err := h.hashBookFile(book)
+	if err != nil {
+		log.Printf("VerifyBook: book_id=%s: %v", bookID, err)
+		if errors.Is(err, fs.ErrNotExist) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book archive not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to read book file")
+		return
+	}
+
+	result := verifyResult{BookID: book.ID, SHA256: sum, StoredSHA256: book.SHA256, Size: size}
+	save := false
+	switch {
+	case book.SHA256 == "":
+		result.Status, result.Match, save = checksumComputed, true, true
+	case book.SHA256 == sum:
+		result.Status, result.Match = checksumOK, true
+	case r.URL.Query().Get("update") == "true":
+		result.Status, save = checksumUpdated, true
+	default:
+		result.Status = checksumMismatch
+	}
+
+	if save {
+		if err := h.repo.SaveBookChecksum(book.ID, sum, size); err != nil {
+			log.Printf("VerifyBook: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("VerifyBook: failed to encode response: %v", err)
+	}
+}
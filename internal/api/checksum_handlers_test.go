@@ -0,0 +1,109 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func withBookID(req *http.Request, bookID string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", bookID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func verifyBook(t *testing.T, h *Handlers, target string) verifyResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.VerifyBook(w, withBookID(httptest.NewRequest("POST", target, nil), "test-001"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result verifyResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return result
+}
+
+// TestDownloadBook_StoresChecksum verifies the first download records the
+// file checksum and exposes it on the book.
+func TestDownloadBook_StoresChecksum(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	w := httptest.NewRecorder()
+	h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001", nil), "test-001"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	digest := sha256.Sum256(w.Body.Bytes())
+	want := hex.EncodeToString(digest[:])
+
+	book, err := h.repo.GetBookByID("test-001")
+	if err != nil || book == nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+	if book.SHA256 != want {
+		t.Errorf("expected checksum %s, got %q", want, book.SHA256)
+	}
+}
+
+// TestVerifyBook checks computing, confirming, detecting and accepting changes.
+func TestVerifyBook(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	first := verifyBook(t, h, "/api/v1/books/test-001/verify")
+	if first.Status != checksumComputed || !first.Match || first.SHA256 == "" || first.Size == 0 {
+		t.Fatalf("unexpected first verification: %+v", first)
+	}
+
+	if again := verifyBook(t, h, "/api/v1/books/test-001/verify"); again.Status != checksumOK || again.SHA256 != first.SHA256 {
+		t.Errorf("expected ok, got %+v", again)
+	}
+
+	// Replace the book file inside the archive
+	f, err := os.Create(filepath.Join(h.booksDir, "test-archive.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	entry, _ := zw.Create("test-001.fb2")
+	entry.Write([]byte("<FictionBook/>"))
+	zw.Close()
+	f.Close()
+
+	changed := verifyBook(t, h, "/api/v1/books/test-001/verify")
+	if changed.Status != checksumMismatch || changed.Match || changed.StoredSHA256 != first.SHA256 {
+		t.Errorf("expected mismatch, got %+v", changed)
+	}
+
+	updated := verifyBook(t, h, "/api/v1/books/test-001/verify?update=true")
+	if updated.Status != checksumUpdated {
+		t.Errorf("expected updated, got %+v", updated)
+	}
+	if sum, _ := h.repo.GetBookChecksum("test-001"); sum == nil || sum.SHA256 != changed.SHA256 {
+		t.Errorf("expected stored checksum %s, got %+v", changed.SHA256, sum)
+	}
+}
+
+// TestVerifyBook_MissingArchive verifies a missing archive is a 404.
+func TestVerifyBook_MissingArchive(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.VerifyBook(w, withBookID(httptest.NewRequest("POST", "/api/v1/books/test-001/verify", nil), "test-001"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
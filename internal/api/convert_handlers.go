@@ -0,0 +1,335 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/piligrim/pushkinlib/internal/convcache"
+	"github.com/piligrim/pushkinlib/internal/convert"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// SetConverters enables downloads in other formats than the stored one
+// (/download/{id}?format=epub) through the converters of registry. It must
+// be called before requests are served.
+func (h *Handlers) SetConverters(registry *convert.Registry) {
+	h.converters = registry
+}
+
+// canConvert reports whether books of format from can be downloaded as to
+func (h *Handlers) canConvert(from, to string) bool {
+	if h.converters == nil {
+		return false
+	}
+	_, ok := h.converters.Lookup(from, to)
+	return ok
+}
+
+// conversionRetryAfter is the Retry-After, in seconds, of downloads whose
+// conversion is still underway
+const conversionRetryAfter = 5
+
+// Pre-warming converts the books downloaded most often in the last
+// prewarmWindow, every prewarmInterval
+const (
+	prewarmWindow   = 30 * 24 * time.Hour
+	prewarmInterval = 24 * time.Hour
+)
+
+// SetConversionQueue runs the conversions of downloads as jobs of queue,
+// which must be started, and keeps their results in the conversion cache.
+// A download waits up to wait for its conversion; slower ones are answered
+// 202 Accepted with Retry-After and the job status URL, and the converted
+// file is served from the cache once the job is done. It needs the
+// conversion cache and must be called before requests are served.
+func (h *Handlers) SetConversionQueue(queue *convert.Queue, wait time.Duration) {
+	h.convQueue = queue
+	h.convWait = wait
+}
+
+// conversionKey returns the conversion cache key of a book file converted
+// to format to by conv
+func conversionKey(data []byte, to string, conv convert.Converter) string {
+	sum := sha256.Sum256(data)
+	return convcache.Key("."+to, hex.EncodeToString(sum[:]), to, conv.Version())
+}
+
+// conversionJobID names the job converting the file of a cache key: the
+// key's hash, without its directory and extension
+func conversionJobID(key string) string {
+	return strings.TrimSuffix(path.Base(key), path.Ext(key))
+}
+
+// conversionJob returns the job converting a book file to format to and
+// storing the result in the cache under key
+func (h *Handlers) conversionJob(book *storage.Book, data []byte, key, from, to string) (convert.Job, convert.Task) {
+	job := convert.Job{ID: conversionJobID(key), BookID: book.ID, From: from, To: to}
+	return job, func(ctx context.Context) error {
+		log.Printf("Conversion: converting book_id=%s from %s to %s", book.ID, from, to)
+		out, err := h.converters.Convert(ctx, bytes.NewReader(data), from, to)
+		if err != nil {
+			return err
+		}
+		return h.convCache.Put(ctx, key, out)
+	}
+}
+
+// cachedConversion returns the converted file of key from the cache, or nil
+func (h *Handlers) cachedConversion(r *http.Request, key string) []byte {
+	rc, _, err := h.convCache.Get(r.Context(), key)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	cached, err := io.ReadAll(rc)
+	if err != nil {
+		return nil
+	}
+	return cached
+}
+
+// convertBook returns the book file read from src in format to. Results are
+// kept in the conversion cache, when configured, under the checksum of the
+// source and the converter version. With a conversion queue the returned
+// job is set instead while the conversion is still underway or has failed.
+func (h *Handlers) convertBook(r *http.Request, book *storage.Book, src io.Reader, from, to string) ([]byte, *convert.Job, error) {
+	conv, ok := h.converters.Lookup(from, to)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s to %s", convert.ErrUnsupported, from, to)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read book file: %w", err)
+	}
+
+	var key string
+	if h.convCache != nil {
+		key = conversionKey(data, to, conv)
+		if cached := h.cachedConversion(r, key); cached != nil {
+			return cached, nil, nil
+		}
+	}
+
+	if key != "" && h.convQueue != nil {
+		job, err := h.convQueue.Submit(h.conversionJob(book, data, key, from, to))
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), h.convWait)
+		job, _ = h.convQueue.Wait(ctx, job.ID)
+		cancel()
+		if job.State != convert.JobDone {
+			return nil, &job, nil
+		}
+		if cached := h.cachedConversion(r, key); cached != nil {
+			return cached, nil, nil
+		}
+		// Too large for the cache, or evicted already: convert it here
+	}
+
+	log.Printf("Download: converting book_id=%s from %s to %s", book.ID, from, to)
+	out, err := h.converters.Convert(r.Context(), bytes.NewReader(data), from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != "" {
+		if err := h.convCache.Put(r.Context(), key, out); err != nil {
+			log.Printf("Download: book_id=%s failed to cache %s: %v", book.ID, to, err)
+		}
+	}
+	return out, nil, nil
+}
+
+// downloadConverted serves the book file read from src converted from one
+// format to another
+func (h *Handlers) downloadConverted(w http.ResponseWriter, r *http.Request, book *storage.Book, src io.Reader, from, to, packaging string) {
+	data, job, err := h.convertBook(r, book, src, from, to)
+	if errors.Is(err, convert.ErrQueueFull) {
+		h.releaseDownload(r)
+		w.Header().Set("Retry-After", strconv.Itoa(conversionRetryAfter))
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Too many conversions are waiting, try again later")
+		return
+	}
+	if job != nil && job.State == convert.JobFailed {
+		err = errors.New(job.Error)
+	} else if job != nil {
+		h.releaseDownload(r)
+		writeConversionPending(w, job)
+		return
+	}
+	if err != nil {
+		log.Printf("Download: book_id=%s failed to convert %s to %s: %v", book.ID, from, to, err)
+		if r.Context().Err() != nil {
+			return
+		}
+		writeError(w, http.StatusBadGateway, codeInternal, "Failed to convert book")
+		return
+	}
+
+	filename := downloadFilename(h.filenameTemplate, book, to)
+	translit := h.transliterateDownload(r)
+	var n int64
+	if packaging == packagingZip {
+		log.Printf("Download: serving book_id=%s as %s.zip (converted from %s)", book.ID, filename, from)
+		w.Header().Set("Content-Disposition", contentDisposition(filename+".zip", translit))
+		w.Header().Set("Content-Type", zippedContentType(to))
+		entryName := filename
+		if translit {
+			entryName = transliterate(filename)
+		}
+		n, err = writeZipped(w, entryName, time.Now(), bytes.NewReader(data))
+	} else {
+		log.Printf("Download: serving book_id=%s as %s (converted from %s)", book.ID, filename, from)
+		w.Header().Set("Content-Disposition", contentDisposition(filename, translit))
+		w.Header().Set("Content-Type", getContentType(to))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		var written int
+		written, err = w.Write(data)
+		n = int64(written)
+	}
+	h.recordDownload(r, book, n, err == nil)
+}
+
+// writeConversionPending answers 202 Accepted to a download whose
+// conversion is underway; the client retries the download after
+// Retry-After or polls the job at Location.
+func writeConversionPending(w http.ResponseWriter, job *convert.Job) {
+	statusURL := "/api/v1/conversions/" + job.ID
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", statusURL)
+	w.Header().Set("Retry-After", strconv.Itoa(conversionRetryAfter))
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":         job,
+		"status_url":  statusURL,
+		"retry_after": conversionRetryAfter,
+	}); err != nil {
+		log.Printf("Download: failed to encode response: %v", err)
+	}
+}
+
+// GetConversion returns the state of a conversion job, as linked from a
+// 202 Accepted download. Jobs are kept for a while after they finish.
+// GET /api/v1/conversions/{id}
+func (h *Handlers) GetConversion(w http.ResponseWriter, r *http.Request) {
+	if h.convQueue == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The conversion queue is not configured")
+		return
+	}
+	job, ok := h.convQueue.Status(chi.URLParam(r, "id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Conversion not found")
+		return
+	}
+	if job.State == convert.JobQueued || job.State == convert.JobRunning {
+		w.Header().Set("Retry-After", strconv.Itoa(conversionRetryAfter))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		log.Printf("GetConversion: failed to encode response: %v", err)
+	}
+}
+
+// GetConversionStats returns the workers and job counts of the conversion
+// queue (admin only).
+// GET /api/v1/admin/conversions
+func (h *Handlers) GetConversionStats(w http.ResponseWriter, r *http.Request) {
+	if h.convQueue == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The conversion queue is not configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.convQueue.Stats()); err != nil {
+		log.Printf("GetConversionStats: failed to encode response: %v", err)
+	}
+}
+
+// StartConversionPrewarm converts the books downloaded most often to
+// formats ahead of time, count books at a time, now and then daily, on
+// idle workers of the conversion queue. It stops with ctx.
+func (h *Handlers) StartConversionPrewarm(ctx context.Context, count int, formats []string) {
+	go func() {
+		ticker := time.NewTicker(prewarmInterval)
+		defer ticker.Stop()
+		for {
+			h.prewarmConversions(ctx, count, formats)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// prewarmConversions queues the conversions of the popular books missing
+// from the cache and returns once the last one is handed to a worker
+func (h *Handlers) prewarmConversions(ctx context.Context, count int, formats []string) {
+	ids, err := h.repo.PopularBooks(time.Now().Add(-prewarmWindow), count)
+	if err != nil {
+		log.Printf("Conversion pre-warm: %v", err)
+		return
+	}
+	queued := 0
+	for _, id := range ids {
+		book, err := h.repo.GetBookByID(id)
+		if err != nil || book == nil {
+			continue
+		}
+		from := strings.ToLower(book.Format)
+		if from == "" {
+			from = "fb2"
+		}
+		var data []byte
+		for _, to := range formats {
+			conv, ok := h.converters.Lookup(from, to)
+			if !ok || to == from {
+				continue
+			}
+			if data == nil {
+				if data, err = h.readBookFile(book); err != nil {
+					log.Printf("Conversion pre-warm: book_id=%s: %v", book.ID, err)
+					break
+				}
+			}
+			key := conversionKey(data, to, conv)
+			if h.convCache.Contains(key) {
+				continue
+			}
+			job, task := h.conversionJob(book, data, key, from, to)
+			if err := h.convQueue.Prewarm(ctx, job, task); err != nil {
+				return
+			}
+			queued++
+		}
+	}
+	if queued > 0 {
+		log.Printf("Conversion pre-warm: queued %d conversions of %d popular books", queued, len(ids))
+	}
+}
+
+// readBookFile reads a book file from its archive
+func (h *Handlers) readBookFile(book *storage.Book) ([]byte, error) {
+	rc, cleanup, err := h.openBookFromArchive(book)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/blob"
+	"github.com/piligrim/pushkinlib/internal/convcache"
+	"github.com/piligrim/pushkinlib/internal/convert"
+)
+
+// TestDownloadBook_Convert converts a book with a shell script standing in
+// for ebook-convert and checks the second download comes from the cache.
+func TestDownloadBook_Convert(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "convert.sh")
+	body := "#!/bin/sh\necho run >> " + calls + "\nprintf epub > \"$2\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	registry := convert.NewRegistry(1)
+	registry.Register("fb2", "epub", &convert.External{Args: []string{script, convert.InputPlaceholder, convert.OutputPlaceholder}})
+	h.SetConverters(registry)
+	h.SetConversionCache(convcache.New(blob.NewLocal(t.TempDir()), 1<<20))
+
+	download := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001"+query, nil), "test-001"))
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := download("?format=epub")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w.Body.String() != "epub" {
+			t.Errorf("unexpected body %q", w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/epub+zip" {
+			t.Errorf("unexpected Content-Type %q", ct)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, ".epub") {
+			t.Errorf("unexpected Content-Disposition %q", cd)
+		}
+	}
+	runs, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("converter was not run: %v", err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("converter ran %d times, want 1", n)
+	}
+
+	if w := download("?format=pdf"); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported format: expected 400, got %d", w.Code)
+	}
+	// The stored format needs no converter
+	if w := download("?format=fb2"); w.Code != http.StatusOK {
+		t.Errorf("stored format: expected 200, got %d", w.Code)
+	}
+}
+
+// TestDownloadBook_ConversionQueue checks a download whose conversion takes
+// longer than the wait is answered 202 with the job's status URL and served
+// from the cache once the job is done.
+func TestDownloadBook_ConversionQueue(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	dir := t.TempDir()
+	gate := filepath.Join(dir, "gate")
+	script := filepath.Join(dir, "convert.sh")
+	body := "#!/bin/sh\nwhile [ ! -f " + gate + " ]; do sleep 0.05; done\nprintf epub > \"$2\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	registry := convert.NewRegistry(1)
+	registry.Register("fb2", "epub", &convert.External{Args: []string{script, convert.InputPlaceholder, convert.OutputPlaceholder}})
+	h.SetConverters(registry)
+	h.SetConversionCache(convcache.New(blob.NewLocal(t.TempDir()), 1<<20))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := convert.NewQueue(1, 10)
+	queue.Start(ctx)
+	h.SetConversionQueue(queue, 0)
+
+	download := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001?format=epub", nil), "test-001"))
+		return w
+	}
+
+	w := download()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("202 without Retry-After")
+	}
+	location := w.Header().Get("Location")
+	id := strings.TrimPrefix(location, "/api/v1/conversions/")
+	if id == location || id == "" {
+		t.Fatalf("unexpected Location %q", location)
+	}
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req := httptest.NewRequest("GET", location, nil)
+	w = httptest.NewRecorder()
+	h.GetConversion(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+	var job convert.Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("invalid status: %v", err)
+	}
+	if w.Code != http.StatusOK || job.BookID != "test-001" || job.To != "epub" ||
+		(job.State != convert.JobQueued && job.State != convert.JobRunning) {
+		t.Fatalf("unexpected status %d: %+v", w.Code, job)
+	}
+
+	if err := os.WriteFile(gate, nil, 0o644); err != nil {
+		t.Fatalf("failed to open the gate: %v", err)
+	}
+	if job, _ := queue.Wait(ctx, id); job.State != convert.JobDone {
+		t.Fatalf("conversion did not finish: %+v", job)
+	}
+	if w := download(); w.Code != http.StatusOK || w.Body.String() != "epub" {
+		t.Errorf("expected the converted book, got %d: %s", w.Code, w.Body.String())
+	}
+}
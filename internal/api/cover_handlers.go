@@ -0,0 +1,397 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/blob"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/reader"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// coverBatchSize is the number of books fetched per cover job iteration.
+const coverBatchSize = 200
+
+// coverJobStatus describes the current or most recent cover extraction run
+type coverJobStatus struct {
+	Running    bool       `json:"running"`
+	Processed  int        `json:"processed"`
+	Found      int        `json:"found"`
+	Failed     int        `json:"failed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// SetCoverStore configures where cover thumbnails are cached.
+func (h *Handlers) SetCoverStore(store *covers.Store) {
+	h.covers = store
+}
+
+// StartCoverJob extracts covers for all unchecked books in the background.
+// It returns false if the job is already running or covers are not configured.
+func (h *Handlers) StartCoverJob() bool {
+	if h.covers == nil {
+		return false
+	}
+
+	h.coverMu.Lock()
+	defer h.coverMu.Unlock()
+	if h.coverState.Running {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	now := time.Now()
+	h.coverState = coverJobStatus{Running: true, StartedAt: &now}
+	h.coverCancel = cancel
+	h.coverDone = done
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		h.runCoverJob(ctx)
+
+		finished := time.Now()
+		h.coverMu.Lock()
+		h.coverState.Running = false
+		h.coverState.FinishedAt = &finished
+		state := h.coverState
+		h.coverMu.Unlock()
+		log.Printf("Covers: checked %d books, found %d covers, %d failed", state.Processed, state.Found, state.Failed)
+	}()
+
+	return true
+}
+
+// stopCoverJob cancels a running cover job and waits for it to exit.
+func (h *Handlers) stopCoverJob() {
+	h.coverMu.Lock()
+	cancel, done := h.coverCancel, h.coverDone
+	h.coverMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (h *Handlers) currentCoverStatus() coverJobStatus {
+	h.coverMu.Lock()
+	defer h.coverMu.Unlock()
+	return h.coverState
+}
+
+// runCoverJob walks unchecked books archive by archive, saving a thumbnail
+// and recording has_cover for each one. Books are committed one at a time
+// and cached queries are dropped after every batch, so OPDS feeds pick up
+// covers while the job is still running.
+func (h *Handlers) runCoverJob(ctx context.Context) {
+	var (
+		archive     *zip.ReadCloser
+		archivePath string
+		archiveErr  error
+		lastArchive string
+		lastID      string
+	)
+	defer func() {
+		if archive != nil {
+			archive.Close()
+		}
+		h.repo.InvalidateQueryCache()
+	}()
+
+	h.hashStoredCovers(ctx)
+
+	for ctx.Err() == nil {
+		books, err := h.repo.ListBooksPendingCover(lastArchive, lastID, coverBatchSize)
+		if err != nil {
+			log.Printf("Covers: %v", err)
+			return
+		}
+		if len(books) == 0 {
+			return
+		}
+		if lastID != "" {
+			h.repo.InvalidateQueryCache()
+		}
+
+		for i := range books {
+			if ctx.Err() != nil {
+				return
+			}
+			book := &books[i]
+			lastArchive, lastID = book.ArchivePath, book.ID
+
+			path, err := h.bookArchivePath(book)
+			if err == nil && path != archivePath {
+				if archive != nil {
+					archive.Close()
+					archive = nil
+				}
+				archivePath = path
+				archive, archiveErr = zip.OpenReader(path)
+				if archiveErr != nil {
+					log.Printf("Covers: %v", archiveErr)
+				}
+			}
+			if err == nil {
+				err = archiveErr
+			}
+
+			if err != nil {
+				// Leave the book unchecked so the next run retries it
+				h.recordCoverResult(false, true)
+				continue
+			}
+
+			hash, err := h.extractBookCover(&archive.Reader, book)
+			if err != nil {
+				// The failure may be passing, so the book stays unchecked
+				log.Printf("Covers: book_id=%s: %v", book.ID, err)
+				h.recordCoverResult(false, true)
+				continue
+			}
+			if err := h.repo.SetBookCover(book.ID, hash); err != nil {
+				log.Printf("Covers: %v", err)
+				return
+			}
+			h.recordCoverResult(hash != "", false)
+		}
+	}
+}
+
+// hashStoredCovers records the content hash of thumbnails saved before
+// hashes were kept. Thumbnails missing from the store are extracted again.
+func (h *Handlers) hashStoredCovers(ctx context.Context) {
+	for ctx.Err() == nil {
+		ids, err := h.repo.ListCoversWithoutHash(coverBatchSize)
+		if err != nil {
+			log.Printf("Covers: %v", err)
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return
+			}
+			thumbnail, err := h.readCover(ctx, id)
+			if errors.Is(err, blob.ErrNotFound) {
+				err = h.repo.ClearBookCover(id)
+			} else if err == nil {
+				err = h.repo.SetBookCover(id, covers.Hash(thumbnail))
+			}
+			if err != nil {
+				// Stop rather than retry the same batch forever
+				log.Printf("Covers: book_id=%s: %v", id, err)
+				return
+			}
+		}
+	}
+}
+
+// readCover returns the stored thumbnail of a book, or blob.ErrNotFound
+func (h *Handlers) readCover(ctx context.Context, bookID string) ([]byte, error) {
+	rc, _, err := h.covers.Open(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (h *Handlers) recordCoverResult(found, failed bool) {
+	h.coverMu.Lock()
+	defer h.coverMu.Unlock()
+	h.coverState.Processed++
+	if found {
+		h.coverState.Found++
+	}
+	if failed {
+		h.coverState.Failed++
+	}
+}
+
+// extractBookCover saves a thumbnail of the book's embedded cover, if any,
+// and returns its content hash ("" if the book has no cover).
+func (h *Handlers) extractBookCover(archive *zip.Reader, book *storage.Book) (string, error) {
+	file, err := findBookFile(archive, book)
+	if err != nil {
+		return "", err
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("open file in archive: %w", err)
+	}
+	defer rc.Close()
+
+	data, _, err := reader.ExtractCover(rc)
+	if err != nil || data == nil {
+		return "", err
+	}
+
+	thumbnail, err := covers.MakeThumbnail(data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.covers.Save(book.ID, thumbnail); err != nil {
+		return "", err
+	}
+	return covers.Hash(thumbnail), nil
+}
+
+// GetBookCover serves the cached cover thumbnail of a book.
+// GET /api/v1/books/{id}/cover
+func (h *Handlers) GetBookCover(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	if bookID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
+		return
+	}
+	if h.covers == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Cover not found")
+		return
+	}
+
+	rc, info, err := h.covers.Open(r.Context(), bookID)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Cover not found")
+			return
+		}
+		log.Printf("GetBookCover: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", info.ModTime, rc)
+}
+
+// GetCover serves a cover thumbnail under its content hash, so that clients
+// may cache it forever. A stale hash redirects to the current cover. The
+// optional ?w= parameter scales the thumbnail down to one of covers.Widths.
+// GET /covers/{id}/{hash}.jpg
+func (h *Handlers) GetCover(w http.ResponseWriter, r *http.Request) {
+	bookID, hash := chi.URLParam(r, "id"), chi.URLParam(r, "hash")
+
+	width := 0
+	if param := r.URL.Query().Get("w"); param != "" {
+		var err error
+		if width, err = strconv.Atoi(param); err != nil || !covers.AllowedWidth(width) {
+			widths := make([]string, len(covers.Widths))
+			for i, allowed := range covers.Widths {
+				widths[i] = strconv.Itoa(allowed)
+			}
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid width, expected one of: "+strings.Join(widths, ", "))
+			return
+		}
+	}
+	if h.covers == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Cover not found")
+		return
+	}
+
+	current, err := h.repo.GetCoverHash(bookID)
+	if err != nil {
+		log.Printf("GetCover: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if current == "" {
+		writeError(w, http.StatusNotFound, codeNotFound, "Cover not found")
+		return
+	}
+	if hash != current {
+		target := covers.URL("", bookID, current)
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	thumbnail, err := h.readCover(r.Context(), bookID)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Cover not found")
+			return
+		}
+		log.Printf("GetCover: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	etag := current
+	if width > 0 {
+		if thumbnail, err = covers.Resize(thumbnail, width); err != nil {
+			log.Printf("GetCover: book_id=%s error: %v", bookID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+			return
+		}
+		etag += "-" + strconv.Itoa(width)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(thumbnail))
+}
+
+// StartCovers launches cover extraction for unchecked books (admin only).
+// POST /api/v1/admin/covers/start
+func (h *Handlers) StartCovers(w http.ResponseWriter, r *http.Request) {
+	if h.covers == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Cover cache is not configured")
+		return
+	}
+
+	status := http.StatusAccepted
+	if !h.StartCoverJob() {
+		status = http.StatusConflict
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(h.currentCoverStatus()); err != nil {
+		log.Printf("StartCovers: failed to encode response: %v", err)
+	}
+}
+
+// GetCoverStatus returns cover extraction progress (admin only).
+// GET /api/v1/admin/covers/status
+func (h *Handlers) GetCoverStatus(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.repo.GetCoverStats()
+	if err != nil {
+		log.Printf("GetCoverStatus: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":   h.currentCoverStatus(),
+		"stats": stats,
+	}); err != nil {
+		log.Printf("GetCoverStatus: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// writeTestArchive stores an FB2 with an embedded cover for book test-001.
+func writeTestArchive(t *testing.T, booksDir string) {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 60))); err != nil {
+		t.Fatalf("failed to encode cover: %v", err)
+	}
+
+	fb2 := `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+ <description><title-info><coverpage><image l:href="#cover.png"/></coverpage></title-info></description>
+ <body><section><p>Text</p></section></body>
+ <binary id="cover.png" content-type="image/png">` + base64.StdEncoding.EncodeToString(img.Bytes()) + `</binary>
+</FictionBook>`
+
+	f, err := os.Create(filepath.Join(booksDir, "test-archive.zip"))
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("test-001.fb2")
+	if err != nil {
+		t.Fatalf("failed to add archive entry: %v", err)
+	}
+	if _, err := w.Write([]byte(fb2)); err != nil {
+		t.Fatalf("failed to write archive entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+}
+
+func waitForCoverJob(t *testing.T, h *Handlers) coverJobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := h.currentCoverStatus(); !status.Running {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("cover job did not finish")
+	return coverJobStatus{}
+}
+
+// TestCoverJob verifies covers are extracted, flagged and served.
+func TestCoverJob(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	h.SetCoverStore(covers.NewStore(t.TempDir()))
+
+	if !h.StartCoverJob() {
+		t.Fatal("expected cover job to start")
+	}
+	status := waitForCoverJob(t, h)
+	if status.Processed != 1 || status.Found != 1 || status.Failed != 0 {
+		t.Fatalf("unexpected job status: %+v", status)
+	}
+
+	book, err := h.repo.GetBookByID("test-001")
+	if err != nil || book == nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+	if !book.HasCover {
+		t.Error("expected book to be marked has_cover")
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/books/test-001/cover", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "test-001")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	h.GetBookCover(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %s", ct)
+	}
+
+	// Checked books are not processed again
+	if !h.StartCoverJob() {
+		t.Fatal("expected cover job to start again")
+	}
+	if status := waitForCoverJob(t, h); status.Processed != 0 {
+		t.Errorf("expected no books to process, got %+v", status)
+	}
+}
+
+// TestCoverJob_QueryCache verifies cached book lists show covers found by
+// the job.
+func TestCoverJob_QueryCache(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	h.SetCoverStore(covers.NewStore(t.TempDir()))
+	h.repo.SetQueryCache(100, time.Hour)
+
+	list, err := h.repo.SearchBooks(storage.BookFilter{Limit: 10})
+	if err != nil || len(list.Books) != 1 || list.Books[0].HasCover {
+		t.Fatalf("expected one book without cover, got %+v, %v", list, err)
+	}
+
+	h.StartCoverJob()
+	waitForCoverJob(t, h)
+
+	list, err = h.repo.SearchBooks(storage.BookFilter{Limit: 10})
+	if err != nil || len(list.Books) != 1 || !list.Books[0].HasCover {
+		t.Errorf("expected cached list to show the cover, got %+v, %v", list, err)
+	}
+}
+
+// TestCoverJob_MissingArchive verifies unreadable archives are retried later.
+func TestCoverJob_MissingArchive(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetCoverStore(covers.NewStore(t.TempDir()))
+
+	h.StartCoverJob()
+	if status := waitForCoverJob(t, h); status.Failed != 1 {
+		t.Fatalf("expected one failed book, got %+v", status)
+	}
+
+	stats, err := h.repo.GetCoverStats()
+	if err != nil {
+		t.Fatalf("GetCoverStats failed: %v", err)
+	}
+	if stats.Checked != 0 {
+		t.Errorf("expected book to stay unchecked, got %+v", stats)
+	}
+
+	writeTestArchive(t, h.booksDir)
+	h.StartCoverJob()
+	if status := waitForCoverJob(t, h); status.Found != 1 {
+		t.Errorf("expected cover to be found on retry, got %+v", status)
+	}
+}
+
+// TestCoverJob_FailedExtraction verifies books whose cover cannot be
+// extracted are retried later instead of being marked without a cover.
+func TestCoverJob_FailedExtraction(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeCorruptArchive(t, h.booksDir)
+	h.SetCoverStore(covers.NewStore(t.TempDir()))
+
+	h.StartCoverJob()
+	if status := waitForCoverJob(t, h); status.Failed != 1 || status.Found != 0 {
+		t.Fatalf("expected one failed book, got %+v", status)
+	}
+	if stats, err := h.repo.GetCoverStats(); err != nil || stats.Checked != 0 {
+		t.Errorf("expected book to stay unchecked, got %+v, %v", stats, err)
+	}
+
+	writeTestArchive(t, h.booksDir)
+	h.StartCoverJob()
+	if status := waitForCoverJob(t, h); status.Found != 1 {
+		t.Errorf("expected cover to be found on retry, got %+v", status)
+	}
+}
+
+// TestGetBookCover_NotFound verifies 404 for books without a cached cover.
+func TestGetBookCover_NotFound(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetCoverStore(covers.NewStore(t.TempDir()))
+
+	req := httptest.NewRequest("GET", "/api/v1/books/test-001/cover", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "test-001")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	h.GetBookCover(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
+
+// TestGetCover verifies hashed cover URLs are cacheable forever, stale
+// hashes redirect and only the allowed widths are rendered.
+func TestGetCover(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	store := covers.NewStore(t.TempDir())
+	h.SetCoverStore(store)
+	router := SetupRoutes(h)
+
+	h.StartCoverJob()
+	waitForCoverJob(t, h)
+	book, err := h.repo.GetBookByID("test-001")
+	if err != nil || book == nil || book.CoverHash == "" {
+		t.Fatalf("expected a cover hash, got %+v, %v", book, err)
+	}
+	coverURL := covers.URL("", "test-001", book.CoverHash)
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(coverURL)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Errorf("unexpected Cache-Control %q", cc)
+	}
+	if w := get(coverURL, "If-None-Match", w.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want 304", w.Code)
+	}
+
+	// The thumbnail is 40 pixels wide, narrower than any allowed width
+	if w := get(coverURL + "?w=100"); w.Code != http.StatusOK {
+		t.Errorf("w=100: got %d, want 200", w.Code)
+	}
+	for _, width := range []string{"50", "abc", "1000"} {
+		if w := get(coverURL + "?w=" + width); w.Code != http.StatusBadRequest {
+			t.Errorf("w=%s: got %d, want 400", width, w.Code)
+		}
+	}
+
+	// A new cover gets a new hash; the old URL redirects to it
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 400, 600))); err != nil {
+		t.Fatalf("failed to encode cover: %v", err)
+	}
+	thumbnail, err := covers.MakeThumbnail(img.Bytes())
+	if err != nil {
+		t.Fatalf("MakeThumbnail failed: %v", err)
+	}
+	if err := store.Save("test-001", thumbnail); err != nil {
+		t.Fatalf("failed to save cover: %v", err)
+	}
+	if err := h.repo.SetBookCover("test-001", covers.Hash(thumbnail)); err != nil {
+		t.Fatalf("SetBookCover failed: %v", err)
+	}
+	w = get(coverURL + "?w=200")
+	newURL := covers.URL("", "test-001", covers.Hash(thumbnail)) + "?w=200"
+	if w.Code != http.StatusFound || w.Header().Get("Location") != newURL {
+		t.Fatalf("stale hash: got %d to %q, want 302 to %q", w.Code, w.Header().Get("Location"), newURL)
+	}
+	w = get(newURL)
+	resized, _, err := image.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatalf("failed to decode resized cover: %v", err)
+	}
+	if resized.Width != 200 || resized.Height != 300 {
+		t.Errorf("resized cover is %dx%d, want 200x300", resized.Width, resized.Height)
+	}
+
+	if w := get("/covers/missing/" + book.CoverHash + ".jpg"); w.Code != http.StatusNotFound {
+		t.Errorf("missing book: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestDownloadLog verifies downloads are recorded and can be filtered and
+// exported as CSV.
+func TestDownloadLog(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetDownloadLog(24*time.Hour, 100)
+	writeTestArchive(t, h.booksDir)
+
+	req := httptest.NewRequest("GET", "/download/test-001", nil)
+	req.RemoteAddr = "192.0.2.7:51234"
+	req.Header.Set("User-Agent", "KOReader/2024.04")
+	w := httptest.NewRecorder()
+	h.DownloadBook(w, withBookID(req, "test-001"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	list := func(query string) (records []storage.DownloadRecord, total int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ListDownloads(w, httptest.NewRequest("GET", "/api/v1/admin/downloads"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Downloads []storage.DownloadRecord `json:"downloads"`
+			Total     int                      `json:"total"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.Downloads, resp.Total
+	}
+
+	records, total := list("")
+	if total != 1 || len(records) != 1 {
+		t.Fatalf("expected 1 download, got %d", total)
+	}
+	rec := records[0]
+	if rec.BookID != "test-001" || rec.Title != "Test Book Title" || rec.IP != "192.0.2.7" ||
+		rec.UserAgent != "KOReader/2024.04" || rec.Bytes != int64(w.Body.Len()) || !rec.Complete {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	for query, want := range map[string]int{
+		"?ip=192.0.2.7":                    1,
+		"?ip=192.0.2.8":                    0,
+		"?book_id=other":                   0,
+		"?from=" + today:                   1,
+		"?from=" + tomorrow:                0,
+		"?to=" + today:                     0,
+		"?user=admin":                      0,
+		"?book_id=test-001&to=" + tomorrow: 1,
+	} {
+		if _, total := list(query); total != want {
+			t.Errorf("%s: expected %d downloads, got %d", query, want, total)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ListDownloads(w, httptest.NewRequest("GET", "/api/v1/admin/downloads?format=csv", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected CSV, got %s", ct)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[0][0] != "time" || rows[1][1] != "test-001" || rows[1][5] != "192.0.2.7" {
+		t.Errorf("unexpected CSV: %v", rows)
+	}
+
+	for value, want := range map[string]string{
+		"=HYPERLINK(\"http://example.com\")": "'=HYPERLINK(\"http://example.com\")",
+		"+1":                                 "'+1",
+		"-2+3":                               "'-2+3",
+		"@SUM(A1)":                           "'@SUM(A1)",
+		"KOReader 2024.04-1":                 "KOReader 2024.04-1",
+		"":                                   "",
+	} {
+		if got := csvCell(value); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ListDownloads(w, httptest.NewRequest("GET", "/api/v1/admin/downloads?from=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid from: expected 400, got %d", w.Code)
+	}
+}
+
+// TestPruneDownloads verifies the log is rotated by age and size.
+func TestPruneDownloads(t *testing.T) {
+	h := setupTestHandlers(t)
+	now := time.Now()
+	for i, age := range []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		rec := storage.DownloadRecord{Time: now.Add(-age), BookID: "test-001", Bytes: int64(i)}
+		if err := h.repo.LogDownload(rec); err != nil {
+			t.Fatalf("LogDownload: %v", err)
+		}
+	}
+
+	n, err := h.repo.PruneDownloads(now.Add(-24*time.Hour), 2)
+	if err != nil {
+		t.Fatalf("PruneDownloads: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 deleted entries, got %d", n)
+	}
+	records, total, err := h.repo.ListDownloads(storage.DownloadFilter{})
+	if err != nil {
+		t.Fatalf("ListDownloads: %v", err)
+	}
+	if total != 2 || records[0].Bytes != 3 || records[1].Bytes != 2 {
+		t.Errorf("expected the 2 newest entries, got %+v", records)
+	}
+}
+
+// TestDownloadBook_ZipPackaging checks ?packaging=zip wraps the book in a
+// single-entry ZIP.
+func TestDownloadBook_ZipPackaging(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	plain := httptest.NewRecorder()
+	h.DownloadBook(plain, withBookID(httptest.NewRequest("GET", "/download/test-001", nil), "test-001"))
+	if plain.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", plain.Code, plain.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001?packaging=zip", nil), "test-001"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/fb2+zip" {
+		t.Errorf("expected application/fb2+zip, got %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="Test Book Title.fb2.zip"` {
+		t.Errorf("unexpected Content-Disposition %s", cd)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("response is not a ZIP: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "Test Book Title.fb2" {
+		t.Fatalf("unexpected entries %+v", zr.File)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, plain.Body.Bytes()) {
+		t.Error("zipped book differs from the plain download")
+	}
+
+	bad := httptest.NewRecorder()
+	h.DownloadBook(bad, withBookID(httptest.NewRequest("GET", "/download/test-001?packaging=rar", nil), "test-001"))
+	if bad.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown packaging, got %d", bad.Code)
+	}
+}
+
+// TestDownloadBook_ArchiveInSubfolder serves books of archives in
+// subfolders of the library, whichever separator the INPX used, and still
+// refuses archive paths leading out of it.
+func TestDownloadBook_ArchiveInSubfolder(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	dir := filepath.Join(h.booksDir, "fb2-000001-000500")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create subfolder: %v", err)
+	}
+	if err := os.Rename(filepath.Join(h.booksDir, "test-archive.zip"), filepath.Join(dir, "part1.zip")); err != nil {
+		t.Fatalf("failed to move archive: %v", err)
+	}
+
+	books := []inpx.Book{
+		{ID: "test-001", Title: "Slash", ArchivePath: "fb2-000001-000500/part1", Format: "fb2", Date: time.Now()},
+		{ID: "escape", Title: "Escape", ArchivePath: "../fb2-000001-000500/part1", Format: "fb2", Date: time.Now()},
+	}
+	if err := h.repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	download := func(id string) int {
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/"+id, nil), id))
+		return w.Code
+	}
+	if code := download("test-001"); code != http.StatusOK {
+		t.Errorf("slash-separated archive: got %d, want 200", code)
+	}
+	if code := download("escape"); code != http.StatusBadRequest {
+		t.Errorf("archive outside the library: got %d, want 400", code)
+	}
+
+	// Databases imported before archive paths were cleaned keep backslashes
+	books[0].ArchivePath = `fb2-000001-000500\part1`
+	if err := h.repo.InsertBooks(books[:1]); err != nil {
+		t.Fatalf("failed to update book: %v", err)
+	}
+	if code := download("test-001"); code != http.StatusOK {
+		t.Errorf("backslash-separated archive: got %d, want 200", code)
+	}
+}
+
+// TestDownloadBook_NotModified verifies downloads carry the time of the
+// archive entry and honor If-Modified-Since.
+func TestDownloadBook_NotModified(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetDownloadLog(24*time.Hour, 100)
+	writeTestArchive(t, h.booksDir)
+
+	download := func(since string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/download/test-001", nil)
+		if since != "" {
+			req.Header.Set("If-Modified-Since", since)
+		}
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(req, "test-001"))
+		return w
+	}
+
+	w := download("")
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if w.Code != http.StatusOK || err != nil {
+		t.Fatalf("expected 200 with Last-Modified, got %d %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+
+	if w := download(lastModified.Format(http.TimeFormat)); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected an empty 304 for an unchanged book, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if w := download(lastModified.Add(-time.Hour).Format(http.TimeFormat)); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("expected 200 for a changed book, got %d", w.Code)
+	}
+	if w := download("not a date"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for an invalid If-Modified-Since, got %d", w.Code)
+	}
+
+	downloads, total, err := h.repo.ListDownloads(storage.DownloadFilter{Limit: 10})
+	if err != nil || total != 3 {
+		t.Errorf("expected 3 recorded downloads without the 304, got %d (%v, %v)", total, downloads, err)
+	}
+}
+
+// TestDownloadQuota_Proxies verifies anonymous quotas go by the address a
+// request comes from, unless it comes from a trusted proxy.
+func TestDownloadQuota_Proxies(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetDownloadQuota(1, 0)
+	writeTestArchive(t, h.booksDir)
+	handler := keepSocketAddr(middleware.RealIP(http.HandlerFunc(h.DownloadBook)))
+	download := func(addr, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/download/test-001", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, withBookID(req, "test-001"))
+		return w.Code
+	}
+
+	if code := download("192.0.2.7:5000", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := download("192.0.2.7:5000", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a forged X-Forwarded-For not to get a new quota, got %d", code)
+	}
+
+	if err := h.SetTrustedProxies("10.0.0.1, 192.0.2.0/24"); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+	if code := download("192.0.2.7:5000", "198.51.100.3"); code != http.StatusOK {
+		t.Errorf("expected a client behind a trusted proxy to have its own quota, got %d", code)
+	}
+	if code := download("192.0.2.8:5000", "198.51.100.3"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the same client behind another trusted proxy to share its quota, got %d", code)
+	}
+	if err := h.SetTrustedProxies("proxy"); err == nil {
+		t.Error("expected an invalid proxy address to be rejected")
+	}
+}
+
+// writeCorruptArchive replaces the archive of test-001 with one whose entry
+// uses an unknown compression method, so that it cannot be opened
+func writeCorruptArchive(t *testing.T, booksDir string) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(booksDir, "test-archive.zip"))
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "test-001.fb2", Method: 99, CompressedSize64: 4, UncompressedSize64: 4})
+	if err != nil {
+		t.Fatalf("failed to add archive entry: %v", err)
+	}
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatalf("failed to write archive entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+}
+
+// TestDownloadQuota verifies the daily quota is counted per address,
+// answered with 429 once used up and shown by /api/v1/me.
+func TestDownloadQuota(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetDownloadQuota(2, 0)
+	writeTestArchive(t, h.booksDir)
+
+	download := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/download/test-001", nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(req, "test-001"))
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := download("192.0.2.7:5000"); w.Code != http.StatusOK {
+			t.Fatalf("download %d: expected 200, got %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	w := download("192.0.2.7:5001")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "quota_exceeded") ||
+		w.Header().Get("Retry-After") == "" {
+		t.Fatalf("third download: expected 429 quota_exceeded with Retry-After, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := download("192.0.2.8:5000"); w.Code != http.StatusOK {
+		t.Errorf("download from another address: expected 200, got %d", w.Code)
+	}
+
+	// A book that cannot be read is not counted
+	writeCorruptArchive(t, h.booksDir)
+	for i := 0; i < 3; i++ {
+		if w := download("192.0.2.9:5000"); w.Code != http.StatusInternalServerError {
+			t.Fatalf("corrupt download %d: expected 500, got %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	if status := h.quota.status("ip:192.0.2.9"); status.Downloads != 0 {
+		t.Errorf("expected failed downloads not to use the quota, got %d", status.Downloads)
+	}
+	writeTestArchive(t, h.booksDir)
+
+	req := httptest.NewRequest("GET", "/api/v1/me", nil)
+	req.RemoteAddr = "192.0.2.7:5002"
+	w = httptest.NewRecorder()
+	h.GetVisitor(w, req)
+	var me struct {
+		Authenticated bool `json:"authenticated"`
+		Quota         *struct {
+			MaxDownloads       int   `json:"max_downloads"`
+			Downloads          int   `json:"downloads"`
+			RemainingDownloads *int  `json:"remaining_downloads"`
+			Bytes              int64 `json:"bytes"`
+			RemainingBytes     *int  `json:"remaining_bytes"`
+		} `json:"download_quota"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&me); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if me.Authenticated || me.Quota == nil || me.Quota.MaxDownloads != 2 || me.Quota.Downloads != 2 ||
+		me.Quota.RemainingDownloads == nil || *me.Quota.RemainingDownloads != 0 || me.Quota.Bytes == 0 ||
+		me.Quota.RemainingBytes != nil {
+		t.Errorf("unexpected visitor: %+v", me)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/indexer"
+)
+
+// Error codes returned in the "code" field of API errors. Clients should
+// branch on the code; messages are for humans and may change.
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeInvalidBody      = "invalid_body"
+	codeInvalidFilter    = "invalid_filter"
+	codeInvalidQuery     = "invalid_query"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeRateLimited      = "rate_limited"
+	codeQuotaExceeded    = "quota_exceeded"
+	codeReindexRunning   = "reindex_in_progress"
+	codeUnavailable      = "service_unavailable"
+	codeReadOnly         = "read_only"
+	codeUpstreamFailed   = "upstream_failed"
+	codeConversionFailed = "conversion_failed"
+	codeInternal         = "internal_error"
+)
+
+// apiError is the JSON body returned for all API errors:
+// {"error": {"code": "not_found", "message": "Book not found"}}
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// JobID names the running job that caused a reindex_in_progress error
+	JobID string `json:"job_id,omitempty"`
+}
+
+// writeError writes a JSON error envelope with a machine-readable code.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(apiError{Error: apiErrorBody{Code: code, Message: message}}); err != nil {
+		log.Printf("writeError: failed to encode response: %v", err)
+	}
+}
+
+// writeBusy answers 409 Conflict to a request refused by the reindex guard,
+// naming the job that holds it.
+func writeBusy(w http.ResponseWriter, err error) {
+	body := apiErrorBody{Code: codeReindexRunning, Message: "Reindex is already in progress"}
+	var busy *indexer.BusyError
+	if errors.As(err, &busy) {
+		body.Message = busy.Error()
+		body.JobID = busy.Job.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(apiError{Error: body}); err != nil {
+		log.Printf("writeBusy: failed to encode response: %v", err)
+	}
+}
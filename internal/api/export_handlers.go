@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// ExportINPX streams the current catalog as an INPX file, so that curation
+// done here can be used by other INPX readers (admin only). Duplicate books
+// with identical files are left out.
+// GET /api/v1/admin/export/inpx
+func (h *Handlers) ExportINPX(w http.ResponseWriter, r *http.Request) {
+	title := "Pushkinlib"
+	if site := h.site.Load(); site != nil && site.title != "" {
+		title = site.title
+	}
+	date := time.Now().Format("2006-01-02")
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"pushkinlib-%s.inpx\"", date))
+
+	writer := inpx.NewWriter(w)
+	duplicates, err := h.repo.EachExportBook(writer.Add)
+	if err == nil {
+		err = writer.Close(inpx.CollectionInfo{
+			Name:        fmt.Sprintf("%s - %s", title, date),
+			Version:     date,
+			Description: fmt.Sprintf("Exported catalog of %d books", writer.Count()),
+		})
+	}
+	if err != nil {
+		// Headers are sent already; the truncated file is the only signal
+		log.Printf("ExportINPX: export failed: %v", err)
+		return
+	}
+	log.Printf("ExportINPX: exported %d books, skipped %d duplicates", writer.Count(), duplicates)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// ListFeatured returns the books picked by admins for the front page, in
+// their order. Books hidden from the viewer are left out.
+// GET /api/v1/featured
+func (h *Handlers) ListFeatured(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("ListFeatured: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	result, err := h.repo.SearchBooks(storage.BookFilter{
+		Featured: true,
+		Limit:    limit,
+		Offset:   parseInt(query.Get("offset"), 0),
+		SortBy:   "featured",
+		Hidden:   hidden,
+
+		WithAnnotations: true,
+	})
+	if err != nil {
+		log.Printf("ListFeatured: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("ListFeatured: failed to encode response: %v", err)
+	}
+}
+
+// SetFeatured replaces the featured books with the given list, in order
+// (admin only). An empty list clears the section.
+// PUT /api/v1/admin/featured
+func (h *Handlers) SetFeatured(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BookIDs []string `json:"book_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := h.repo.SetFeaturedBooks(req.BookIDs); err != nil {
+		if errors.Is(err, storage.ErrBookNotFound) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		log.Printf("SetFeatured: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("SetFeatured: failed to encode response: %v", err)
+	}
+}
+
+// FeatureBook adds a book to the featured books or moves it (admin only).
+// The optional body {"position": 1} places it, counting from 1; by default
+// the book goes last.
+// PUT /api/v1/admin/featured/{id}
+func (h *Handlers) FeatureBook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+
+	bookID := chi.URLParam(r, "id")
+	if err := h.repo.FeatureBook(bookID, req.Position); err != nil {
+		if errors.Is(err, storage.ErrBookNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+			return
+		}
+		log.Printf("FeatureBook: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("FeatureBook: failed to encode response: %v", err)
+	}
+}
+
+// UnfeatureBook removes a book from the featured books (admin only).
+// DELETE /api/v1/admin/featured/{id}
+func (h *Handlers) UnfeatureBook(w http.ResponseWriter, r *http.Request) {
+	removed, err := h.repo.UnfeatureBook(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("UnfeatureBook: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book is not featured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("UnfeatureBook: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultFilenameTemplate names downloads after the book title
+const DefaultFilenameTemplate = "{title}.{ext}"
+
+// filenameFields are the placeholders of a download file name template
+var filenameFields = map[string]func(book *storage.Book) string{
+	"title": func(book *storage.Book) string { return book.Title },
+	"author": func(book *storage.Book) string {
+		if len(book.Authors) == 0 {
+			return ""
+		}
+		return book.Authors[0].Name
+	},
+	"authors": func(book *storage.Book) string {
+		names := make([]string, len(book.Authors))
+		for i, author := range book.Authors {
+			names[i] = author.Name
+		}
+		return strings.Join(names, ", ")
+	},
+	"series": func(book *storage.Book) string {
+		if book.Series == nil {
+			return ""
+		}
+		return book.Series.Name
+	},
+	"series_num": func(book *storage.Book) string {
+		if book.Series == nil || book.SeriesNum <= 0 {
+			return ""
+		}
+		return strconv.Itoa(book.SeriesNum)
+	},
+	"year": func(book *storage.Book) string {
+		if book.Year <= 0 {
+			return ""
+		}
+		return strconv.Itoa(book.Year)
+	},
+	"lang": func(book *storage.Book) string { return book.Language },
+	"id":   func(book *storage.Book) string { return book.ID },
+}
+
+// validateFilenameTemplate checks that a template uses known placeholders
+// only and closes its braces
+func validateFilenameTemplate(tmpl string) error {
+	rest := tmpl
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			return nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return fmt.Errorf("unclosed { in %q", tmpl)
+		}
+		name := rest[start+1 : start+end]
+		if _, ok := filenameFields[name]; !ok && name != "ext" {
+			return fmt.Errorf("unknown placeholder {%s} in %q", name, tmpl)
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+// downloadFilename names the download of book with extension ext after a
+// template such as "{author} - {series} {series_num} - {title}.{ext}".
+// Values are sanitized, separators left dangling by empty values are
+// dropped and the name is cut to 100 characters. The
+// extension is always added, so ".{ext}" at the end is optional.
+func downloadFilename(tmpl string, book *storage.Book, ext string) string {
+	tmpl = strings.TrimSuffix(tmpl, ".{ext}")
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		end := strings.IndexByte(tmpl[max(start, 0):], '}')
+		if start < 0 || end < 0 {
+			sb.WriteString(tmpl)
+			break
+		}
+		sb.WriteString(tmpl[:start])
+		name := tmpl[start+1 : start+end]
+		if field, ok := filenameFields[name]; ok {
+			sb.WriteString(field(book))
+		} else if name == "ext" {
+			sb.WriteString(ext)
+		}
+		tmpl = tmpl[start+end+1:]
+	}
+
+	// sanitizeFilename also cuts the name, which may leave a separator
+	name := tidyFilename(sanitizeFilename(sb.String()))
+	if name == "" {
+		name = sanitizeFilename(book.ID)
+	}
+	return name + "." + ext
+}
+
+// tidyFilename collapses runs of spaces, drops separators repeated where a
+// value was empty, as in "Author -  - Title", and trims separators from the
+// ends and dots from the start, which would hide the file
+func tidyFilename(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	for _, sep := range []string{" - ", ", ", " _ "} {
+		double := sep + strings.TrimLeft(sep, " ")
+		for strings.Contains(name, double) {
+			name = strings.ReplaceAll(name, double, sep)
+		}
+	}
+	return strings.TrimLeft(strings.Trim(name, " -,"), ".")
+}
+
+// translitTable transliterates Cyrillic letters following the BGN/PCGN
+// romanization (GOST 7.79 system B for Ukrainian and Belarusian letters),
+// without diacritics so the result is plain ASCII
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// transliterate converts s to printable ASCII: Cyrillic is romanized,
+// diacritics are dropped and other characters become "_".
+func transliterate(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFC.String(s) {
+		lower := unicode.ToLower(r)
+		if latin, ok := translitTable[lower]; ok {
+			if lower != r && latin != "" {
+				// Capitalize the first letter only: "Щ" → "Shch"
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			sb.WriteString(latin)
+			continue
+		}
+		if r < unicode.MaxASCII && unicode.IsPrint(r) {
+			sb.WriteRune(r)
+			continue
+		}
+		// "é" → "e": keep the base letter of decomposable characters
+		base := []rune(norm.NFD.String(string(r)))[0]
+		if base < unicode.MaxASCII && unicode.IsPrint(base) {
+			sb.WriteRune(base)
+		} else if unicode.IsSpace(r) {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// contentDisposition returns an attachment Content-Disposition header for
+// filename. filename holds an ASCII fallback and filename* (RFC 5987) the
+// UTF-8 name for clients that support it; with asciiOnly set, the
+// transliterated name is sent alone, for readers that mangle UTF-8.
+func contentDisposition(filename string, asciiOnly bool) string {
+	ascii := strings.NewReplacer(`"`, "_", `\`, "_").Replace(transliterate(filename))
+	if asciiOnly || ascii == filename {
+		return fmt.Sprintf(`attachment; filename="%s"`, ascii)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, rfc5987Escape(filename))
+}
+
+// rfc5987Escape percent-encodes every byte of s but the RFC 5987 attr-chars
+func rfc5987Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < utf8.RuneSelf && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestTransliterate(t *testing.T) {
+	tests := map[string]string{
+		"Щука и Ёж":       "Shchuka i Yozh",
+		"Война и мир":     "Voyna i mir",
+		"ЩИТ":             "ShchIT",
+		"Подъезд":         "Podezd",
+		"Café Ґанок":      "Cafe Ganok",
+		"Test Book Title": "Test Book Title",
+		"Книга первая":    "Kniga pervaya",
+		"日本":              "__",
+	}
+	for in, want := range tests {
+		if got := transliterate(in); got != want {
+			t.Errorf("transliterate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	book := &storage.Book{
+		ID:        "42",
+		Title:     "Глава: начало?",
+		Authors:   []storage.Author{{Name: "Лукьяненко Сергей"}, {Name: "Васильев Владимир"}},
+		Series:    &storage.Series{Name: "Дозоры"},
+		SeriesNum: 2,
+		Year:      1999,
+	}
+	noSeries := &storage.Book{ID: "43", Title: "Рассказ", Authors: book.Authors}
+	long := &storage.Book{ID: "44", Title: strings.Repeat("Слово ", 30)}
+	longName := strings.Repeat("Слово ", 16) + "Слов.fb2"
+
+	tests := []struct {
+		tmpl string
+		book *storage.Book
+		want string
+	}{
+		{DefaultFilenameTemplate, book, "Глава_ начало_.fb2"},
+		{"{author} - {series} {series_num} - {title}.{ext}", book, "Лукьяненко Сергей - Дозоры 2 - Глава_ начало_.fb2"},
+		{"{author} - {series} {series_num} - {title}.{ext}", noSeries, "Лукьяненко Сергей - Рассказ.fb2"},
+		{"{series} {series_num} - {title}", noSeries, "Рассказ.fb2"},
+		{"{authors} ({year}) {title} [{id}]", book, "Лукьяненко Сергей, Васильев Владимир (1999) Глава_ начало_ [42].fb2"},
+		{"{title}", &storage.Book{ID: "45"}, "45.fb2"},
+		{"{title}", long, longName},
+	}
+	for _, tt := range tests {
+		if got := downloadFilename(tt.tmpl, tt.book, "fb2"); got != tt.want {
+			t.Errorf("downloadFilename(%q, %s) = %q, want %q", tt.tmpl, tt.book.ID, got, tt.want)
+		}
+	}
+
+	for tmpl, valid := range map[string]bool{
+		"{author} - {title}.{ext}": true,
+		"{title}":                  true,
+		"{publisher} - {title}":    false,
+		"{title":                   false,
+	} {
+		if err := validateFilenameTemplate(tmpl); (err == nil) != valid {
+			t.Errorf("validateFilenameTemplate(%q) = %v, want valid=%v", tmpl, err, valid)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename  string
+		asciiOnly bool
+		want      string
+	}{
+		{"Book.fb2", false, `attachment; filename="Book.fb2"`},
+		{"Ёж.fb2", false, `attachment; filename="Yozh.fb2"; filename*=UTF-8''%D0%81%D0%B6.fb2`},
+		{"Ёж.fb2", true, `attachment; filename="Yozh.fb2"`},
+		{"Я и ты: \"мы\".fb2", false, `attachment; filename="Ya i ty: _my_.fb2"; filename*=UTF-8''%D0%AF%20%D0%B8%20%D1%82%D1%8B%3A%20%22%D0%BC%D1%8B%22.fb2`},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition(tt.filename, tt.asciiOnly); got != tt.want {
+			t.Errorf("contentDisposition(%q, %v) = %s, want %s", tt.filename, tt.asciiOnly, got, tt.want)
+		}
+	}
+}
+
+// TestDownloadBook_TransliteratedName checks the setting and its per-request
+// override.
+func TestDownloadBook_TransliteratedName(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	download := func(target string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", target, nil), "test-001"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w.Header().Get("Content-Disposition")
+	}
+
+	if got := h.transliterateDownload(httptest.NewRequest("GET", "/download/test-001", nil)); got {
+		t.Error("expected transliteration off by default")
+	}
+	if got := download("/download/test-001"); got != `attachment; filename="Test Book Title.fb2"` {
+		t.Errorf("unexpected Content-Disposition %s", got)
+	}
+
+	h.SetDownloadTransliteration(true)
+	if !h.transliterateDownload(httptest.NewRequest("GET", "/download/test-001", nil)) {
+		t.Error("expected transliteration on")
+	}
+	if h.transliterateDownload(httptest.NewRequest("GET", "/download/test-001?translit=0", nil)) {
+		t.Error("expected translit=0 to override the setting")
+	}
+}
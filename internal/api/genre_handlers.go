@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/genres"
+)
+
+// SetGenres sets the genre list served by ListGenres, the built-in FB2
+// genres unless called. It must be called before requests are served.
+func (h *Handlers) SetGenres(list []genres.Genre) {
+	h.genreList = list
+}
+
+// ListGenres returns the genre codes with their Russian and English names
+// and groups, for showing genre codes by name.
+// GET /api/v1/genres
+func (h *Handlers) ListGenres(w http.ResponseWriter, r *http.Request) {
+	list := h.genreList
+	if list == nil {
+		list = genres.Default()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"genres": list}); err != nil {
+		log.Printf("ListGenres: failed to encode response: %v", err)
+	}
+}
@@ -2,43 +2,113 @@ package api
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/convcache"
+	"github.com/piligrim/pushkinlib/internal/convert"
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/enrichment"
+	"github.com/piligrim/pushkinlib/internal/genres"
+	"github.com/piligrim/pushkinlib/internal/httpstats"
 	"github.com/piligrim/pushkinlib/internal/indexer"
+	"github.com/piligrim/pushkinlib/internal/notifications"
+	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/sections"
 	"github.com/piligrim/pushkinlib/internal/storage"
+	"github.com/piligrim/pushkinlib/internal/upstream"
 )
 
 // Handlers contains all API handlers
 type Handlers struct {
-	repo      *storage.Repository
-	booksDir  string
-	inpxPath  string
-	tts       *TTSConfig
-	reindexMu sync.Mutex
-	authMw    *auth.Middleware
+	repo       *storage.Repository
+	booksDir   string
+	inpxPath   string
+	remoteINPX *indexer.RemoteINPX
+	tts        *TTSConfig
+	jobs       indexer.Guard
+	authMw     *auth.Middleware
+	enricher   *enrichment.Service
+	covers     *covers.Store
+	convCache  *convcache.Cache
+	converters *convert.Registry
+	convQueue  *convert.Queue
+	convWait   time.Duration
+	upstreams  *upstream.Service
+	notifier   *notifications.Notifier
+	genreList  []genres.Genre
+	sizeCheck  string
+	sections   []sections.Section
+
+	statusMu     sync.Mutex
+	reindexState reindexStatus
+
+	coverMu     sync.Mutex
+	coverState  coverJobStatus
+	coverCancel context.CancelFunc
+	coverDone   chan struct{}
+
+	duplicatesMu sync.Mutex
+	duplicates   authorDuplicatesReport
+
+	opdsRouter  http.Handler
+	opdsHandler *opds.Handler
+
+	accessLog        atomic.Bool
+	translitNames    atomic.Bool
+	filenameTemplate string
+
+	downloadLog    *downloadLogSettings
+	downloadPruned atomic.Int64
+	quota          *downloadQuota
+	trustedProxies []netip.Prefix
+
+	books              atomic.Pointer[booksStatus]
+	booksProbing       atomic.Bool
+	booksProbeInterval time.Duration
+
+	navStatsRunning atomic.Bool
+	navStatsPending atomic.Bool
+
+	site atomic.Pointer[publicSite]
+
+	httpStats *httpstats.Collector
 }
 
 // NewHandlers creates new API handlers
 func NewHandlers(repo *storage.Repository, booksDir, inpxPath string, authMw *auth.Middleware) *Handlers {
-	return &Handlers{
-		repo:     repo,
-		booksDir: booksDir,
-		inpxPath: inpxPath,
-		tts:      &TTSConfig{},
-		authMw:   authMw,
-	}
+	h := &Handlers{
+		repo:             repo,
+		booksDir:         booksDir,
+		inpxPath:         inpxPath,
+		tts:              &TTSConfig{},
+		authMw:           authMw,
+		httpStats:        httpstats.New(),
+		filenameTemplate: DefaultFilenameTemplate,
+	}
+	h.accessLog.Store(true)
+	return h
+}
+
+// SetRemoteINPX makes reindexing download the INPX from a URL first.
+func (h *Handlers) SetRemoteINPX(remote *indexer.RemoteINPX) {
+	h.remoteINPX = remote
 }
 
 // SetTTSConfig sets the TTS proxy configuration.
@@ -49,27 +119,120 @@ func (h *Handlers) SetTTSConfig(serverURL, apiKey string) {
 	}
 }
 
+// SetLogLevel applies LOG_LEVEL: "debug" and "info" log every request,
+// "warn" and "error" only errors. It is safe to call while requests are
+// served.
+func (h *Handlers) SetLogLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "debug", "info":
+		h.accessLog.Store(true)
+	case "warn", "warning", "error":
+		h.accessLog.Store(false)
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	return nil
+}
+
+// requestLogger logs requests with chi's logger unless the log level
+// silences them
+func (h *Handlers) requestLogger(next http.Handler) http.Handler {
+	logged := middleware.Logger(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.accessLog.Load() {
+			logged.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetDownloadTransliteration makes downloads name files in transliterated
+// ASCII only, for readers that cannot handle UTF-8 filenames. It is safe to
+// call while requests are served.
+func (h *Handlers) SetDownloadTransliteration(enabled bool) {
+	h.translitNames.Store(enabled)
+}
+
+// SetDownloadFilenameTemplate sets how downloaded files are named, e.g.
+// "{author} - {series} {series_num} - {title}.{ext}"; see downloadFilename.
+// A template with unknown placeholders is rejected. It must be called
+// before requests are served.
+func (h *Handlers) SetDownloadFilenameTemplate(tmpl string) error {
+	if err := validateFilenameTemplate(tmpl); err != nil {
+		return err
+	}
+	h.filenameTemplate = tmpl
+	return nil
+}
+
+// transliterateDownload reports whether a download names the file in ASCII
+// only: the translit query parameter ("1" or "0") overrides the setting.
+func (h *Handlers) transliterateDownload(r *http.Request) bool {
+	if v := r.URL.Query().Get("translit"); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			return enabled
+		}
+	}
+	return h.translitNames.Load()
+}
+
 // ReindexLibrary clears database and re-imports data from INPX
 func (h *Handlers) ReindexLibrary(w http.ResponseWriter, r *http.Request) {
-	if !h.reindexMu.TryLock() {
-		http.Error(w, "Reindex is already in progress", http.StatusServiceUnavailable)
+	job, err := h.jobs.Begin("reindex")
+	if err != nil {
+		writeBusy(w, err)
 		return
 	}
-	defer h.reindexMu.Unlock()
+	defer h.jobs.End(job)
 
-	result, err := indexer.ReindexFromINPX(h.repo, h.inpxPath)
+	response, err := h.runReindex(job)
 	if err != nil {
 		switch {
 		case errors.Is(err, indexer.ErrINPXPathEmpty):
-			http.Error(w, "INPX path is not configured", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeInternal, "INPX path is not configured")
 		case errors.Is(err, indexer.ErrINPXNotFound):
-			http.Error(w, err.Error(), http.StatusNotFound)
+			writeError(w, http.StatusNotFound, codeNotFound, err.Error())
 		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		}
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ReindexLibrary: failed to encode response: %v", err)
+	}
+}
+
+// runReindex performs the reindex and records its progress for status polling.
+// The caller must have begun job with h.jobs.
+func (h *Handlers) runReindex(job indexer.Job) (map[string]interface{}, error) {
+	h.setReindexStarted(job)
+
+	// The cover job writes to the database; pause it while books are replaced
+	h.stopCoverJob()
+
+	inpxPath := h.inpxPath
+	if h.remoteINPX != nil {
+		var err error
+		if inpxPath, _, err = h.remoteINPX.Fetch(context.Background()); err != nil {
+			h.setReindexFinished(nil, err)
+			return nil, err
+		}
+	}
+
+	result, err := indexer.ReindexFromINPX(h.repo, inpxPath)
+	if err != nil {
+		h.setReindexFinished(nil, err)
+		return nil, err
+	}
+	if h.remoteINPX != nil {
+		if err := h.remoteINPX.MarkMerged(inpxPath); err != nil {
+			log.Printf("Reindex: failed to record the merged INPX: %v", err)
+		}
+	}
+
 	collectionName := ""
 	collectionVersion := ""
 	if result.Collection != nil {
@@ -79,19 +242,33 @@ func (h *Handlers) ReindexLibrary(w http.ResponseWriter, r *http.Request) {
 
 	response := map[string]interface{}{
 		"status":             "ok",
+		"job_id":             job.ID,
 		"imported":           result.Imported,
+		"skipped":            result.Skipped,
+		"unmapped_genres":    result.UnmappedGenres,
+		"detected_languages": result.DetectedLanguages,
+		"author_merges":      result.AuthorMerges,
+		"author_splits":      result.AuthorSplits,
+		"overrides":          result.Overrides,
+		"search_terms":       result.SearchTerms,
+		"sync_changes":       result.SyncChanges,
 		"collection":         collectionName,
 		"version":            collectionVersion,
 		"duration_ms":        result.Duration.Milliseconds(),
 		"parse_duration_ms":  result.ParseDuration.Milliseconds(),
 		"clear_duration_ms":  result.ClearDuration.Milliseconds(),
 		"insert_duration_ms": result.InsertDuration.Milliseconds(),
+		"fts_duration_ms":    result.FTSDuration.Milliseconds(),
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("ReindexLibrary: failed to encode response: %v", err)
+	if check := h.sizeCheckAfterImport(); check != nil {
+		response["size_check"] = check
 	}
+
+	h.setReindexFinished(response, nil)
+	h.StartCoverJob()
+	h.StartAuthorDuplicatesJob()
+	h.notifyAfterImport()
+	return response, nil
 }
 
 // maxLimit is the maximum allowed page size to prevent excessive memory usage
@@ -106,14 +283,219 @@ func (h *Handlers) SearchBooks(w http.ResponseWriter, r *http.Request) {
 		limit = maxLimit
 	}
 
+	if !storage.IsValidSortField(query.Get("sort_by")) {
+		writeError(w, http.StatusBadRequest, codeInvalidFilter, fmt.Sprintf("Invalid sort_by, expected one of: %s", strings.Join(storage.SortFields, ", ")))
+		return
+	}
+
+	filter := parseBookFilter(query)
+	filter.Limit = limit
+	filter.Offset = parseInt(query.Get("offset"), 0)
+	filter.SortBy = query.Get("sort_by")
+	filter.SortOrder = query.Get("sort_order")
+	filter.WithAnnotations = true
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	filter.Hidden = hidden
+
+	result, err := h.repo.SearchBooks(filter)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// Further pages of a search are not searches of their own
+	if filter.Offset <= 0 {
+		h.logSearch(r, filter.Query, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("SearchBooks: failed to encode response: %v", err)
+	}
+}
+
+// logSearch adds a search to the search log when it is enabled. Searchers
+// are told apart by user, or by address if anonymous. A query that only a
+// relaxed retry found books for is logged as finding nothing.
+func (h *Handlers) logSearch(r *http.Request, query string, result *storage.BookList) {
+	results := result.Total
+	if result.Fallback {
+		results = 0
+	}
+	client := clientIP(r)
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		client = "user:" + user.ID
+	}
+	if err := h.repo.LogSearch(query, results, client); err != nil {
+		log.Printf("Search log: %v", err)
+	}
+}
+
+// ParseSearchQuery shows how the search interprets the q parameter: the
+// words searched in every field, those limited to one field by a prefix,
+// the full-text expression and warnings about prefixes taken as text.
+// GET /api/v1/search/parse
+func (h *Handlers) ParseSearchQuery(w http.ResponseWriter, r *http.Request) {
+	parsed, err := storage.ParseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(parsed); err != nil {
+		log.Printf("ParseSearchQuery: failed to encode response: %v", err)
+	}
+}
+
+// GetFacets returns the numbers of books matching a search per genre,
+// language, format and publication decade, so that filters can show counts.
+// It accepts the filter parameters of SearchBooks.
+// GET /api/v1/facets
+func (h *Handlers) GetFacets(w http.ResponseWriter, r *http.Request) {
+	filter := parseBookFilter(r.URL.Query())
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	filter.Hidden = hidden
+
+	facets, err := h.repo.CountBookFacets(filter)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(facets); err != nil {
+		log.Printf("GetFacets: failed to encode response: %v", err)
+	}
+}
+
+// GetYearStats returns the histogram of publication years of the books the
+// reader can see, by year and by decade, oldest first. Books without a
+// year are not counted.
+// GET /api/v1/stats/years?language=ru
+func (h *Handlers) GetYearStats(w http.ResponseWriter, r *http.Request) {
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("GetYearStats: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	years, err := h.repo.ListYearsInLanguage(r.URL.Query().Get("language"), hidden)
+	if err != nil {
+		log.Printf("GetYearStats: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if years == nil {
+		years = []storage.YearCount{}
+	}
+	decades := storage.GroupDecades(years)
+	if decades == nil {
+		decades = []storage.YearCount{}
+	}
+	total := 0
+	for _, yc := range years {
+		total += yc.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"years":   years,
+		"decades": decades,
+		"total":   total,
+	}); err != nil {
+		log.Printf("GetYearStats: failed to encode response: %v", err)
+	}
+}
+
+// GetSearchStats returns the queries searched most often in the last days
+// (7 by default, at most 365) and those whose latest search found nothing,
+// which point at books worth adding or at missing aliases. Queries made by
+// fewer people than SEARCH_LOG_MIN_SEARCHERS are only shown to admins.
+// GET /api/v1/stats/searches?days=7&limit=20
+func (h *Handlers) GetSearchStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := min(max(parseInt(query.Get("days"), 7), 1), 365)
+	limit := parseInt(query.Get("limit"), 20)
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	user := auth.UserFromContext(r.Context())
+	admin := user != nil && user.IsAdmin
+
+	stats, err := h.repo.GetSearchStats(time.Now().AddDate(0, 0, -days), limit, admin)
+	if err != nil {
+		log.Printf("GetSearchStats: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"searches":     stats.Searches,
+		"top":          stats.Top,
+		"zero_results": stats.ZeroResults,
+		"days":         days,
+		"limit":        limit,
+		"enabled":      h.repo.SearchLogEnabled(),
+	}); err != nil {
+		log.Printf("GetSearchStats: failed to encode response: %v", err)
+	}
+}
+
+// GetNavigationStats returns the sizes of the author, series and genre
+// lists and the number of authors and series by first letter, as counted
+// after the last import or recounted in the background after an edit of the
+// catalog. They are unavailable until first counted.
+// GET /api/v1/stats/navigation
+func (h *Handlers) GetNavigationStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.repo.GetNavigationStats()
+	if err != nil {
+		log.Printf("GetNavigationStats: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if stats == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Navigation counts are not computed yet")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("GetNavigationStats: failed to encode response: %v", err)
+	}
+}
+
+// parseBookFilter reads the query and filter parameters of a book search
+func parseBookFilter(query url.Values) storage.BookFilter {
 	filter := storage.BookFilter{
-		Query:     query.Get("q"),
-		Limit:     limit,
-		Offset:    parseInt(query.Get("offset"), 0),
-		SortBy:    query.Get("sort_by"),
-		SortOrder: query.Get("sort_order"),
-		YearFrom:  parseInt(query.Get("year_from"), 0),
-		YearTo:    parseInt(query.Get("year_to"), 0),
+		Query:    query.Get("q"),
+		YearFrom: parseInt(query.Get("year_from"), 0),
+		YearTo:   parseInt(query.Get("year_to"), 0),
+	}
+	if year := parseInt(query.Get("year"), 0); year > 0 {
+		filter.YearFrom, filter.YearTo = year, year
 	}
 
 	// Parse array parameters
@@ -132,85 +514,197 @@ func (h *Handlers) SearchBooks(w http.ResponseWriter, r *http.Request) {
 	if formats := query["formats"]; len(formats) > 0 {
 		filter.Formats = formats
 	}
+	if tags := query["tags"]; len(tags) > 0 {
+		filter.Tags = tags
+	}
+	filter.Featured, _ = strconv.ParseBool(query.Get("featured"))
+	filter.SeriesNumFrom = parseInt(query.Get("series_num_from"), 0)
+	filter.SeriesNumTo = parseInt(query.Get("series_num_to"), 0)
+	filter.FirstInSeries, _ = strconv.ParseBool(query.Get("first_in_series"))
+	return filter
+}
 
-	result, err := h.repo.SearchBooks(filter)
+// GetBookByID handles getting a single book by ID
+func (h *Handlers) GetBookByID(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	if bookID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
+		return
+	}
+
+	book, err := h.repo.GetBookByID(bookID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	if book == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		log.Printf("SearchBooks: failed to encode response: %v", err)
+	if err := json.NewEncoder(w).Encode(book); err != nil {
+		log.Printf("GetBookByID: failed to encode response: %v", err)
 	}
 }
 
-// GetBookByID handles getting a single book by ID
-func (h *Handlers) GetBookByID(w http.ResponseWriter, r *http.Request) {
+// maxAuthorBooks limits the other books of the authors in GetBookDetails
+const maxAuthorBooks = 50
+
+// GetBookDetails returns everything the detail page of a book shows in one
+// response: the book with its annotation, the books before and after it in
+// its series, other books of its authors (author_books, 10 by default) and
+// its genres with their numbers of books.
+// GET /api/v1/books/{id}/full?author_books=10
+func (h *Handlers) GetBookDetails(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
 		return
 	}
 
-	book, err := h.repo.GetBookByID(bookID)
+	authorBooks := parseInt(r.URL.Query().Get("author_books"), 10)
+	if authorBooks < 0 {
+		authorBooks = 0
+	}
+	if authorBooks > maxAuthorBooks {
+		authorBooks = maxAuthorBooks
+	}
+
+	hidden, err := h.restrictions(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
-	if book == nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+	details, err := h.repo.GetBookDetails(bookID, authorBooks, hidden)
+	if err != nil {
+		log.Printf("GetBookDetails: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	if details == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		log.Printf("GetBookDetails: failed to encode response: %v", err)
+	}
+}
+
+// UpdateBook applies a manual metadata correction to a book (admin only).
+// PATCH /api/v1/books/{id}
+func (h *Handlers) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	if bookID == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
+		return
+	}
+
+	var upd storage.BookUpdate
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if upd.Title != nil && strings.TrimSpace(*upd.Title) == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Title cannot be empty")
+		return
+	}
+	if upd.Rating != nil && (*upd.Rating < 0 || *upd.Rating > 5) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Rating must be between 0 and 5")
+		return
+	}
+	if upd.SeriesNum != nil && *upd.SeriesNum < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Series number cannot be negative")
+		return
+	}
+
+	book, err := h.repo.UpdateBook(bookID, upd)
+	if err != nil {
+		if errors.Is(err, storage.ErrBookNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+			return
+		}
+		log.Printf("UpdateBook: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
+	h.recordSyncChanges(bookID)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(book); err != nil {
-		log.Printf("GetBookByID: failed to encode response: %v", err)
+		log.Printf("UpdateBook: failed to encode response: %v", err)
 	}
 }
 
-// DownloadBook handles book download requests
+// DownloadBook handles book download requests. With ?packaging=zip the book
+// is wrapped in a single-entry ZIP on the fly (.fb2.zip); with ?format= it
+// is converted to another format first, see SetConverters. format=preferred
+// serves the format the user prefers, when the book is in it or converts
+// to it, and the stored format otherwise.
 func (h *Handlers) DownloadBook(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
+		return
+	}
+	packaging := strings.ToLower(r.URL.Query().Get("packaging"))
+	if packaging != "" && packaging != packagingZip {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "packaging must be zip")
 		return
 	}
+	target := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
 	log.Printf("Download: request book_id=%s", bookID)
 
 	// Get book info from database
 	book, err := h.repo.GetBookByID(bookID)
 	if err != nil {
 		log.Printf("Download: book_id=%s database error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
 	if book == nil {
 		log.Printf("Download: book_id=%s not found in database", bookID)
-		http.Error(w, "Book not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
 		return
 	}
-
-	// Build path to archive (INPX may store archive with or without .zip extension)
-	archiveName := book.ArchivePath
-	if archiveName == "" {
-		log.Printf("Download: book_id=%s has empty archive path", book.ID)
-		http.Error(w, "Book archive path is empty", http.StatusInternalServerError)
+	format := strings.ToLower(book.Format)
+	if format == "" {
+		format = "fb2"
+	}
+	if target == preferredFormat {
+		target, packaging = h.preferredDownload(r, format, packaging)
+	}
+	if target == format {
+		target = ""
+	}
+	if target != "" && !h.canConvert(format, target) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("conversion from %s to %s is not supported", format, target))
 		return
 	}
-	if !strings.HasSuffix(strings.ToLower(archiveName), ".zip") {
-		archiveName += ".zip"
+	if h.booksUnavailable(w) {
+		return
 	}
-	archivePath := filepath.Join(h.booksDir, archiveName)
 
-	// Validate that the resolved path is within booksDir to prevent path traversal
-	cleanArchivePath := filepath.Clean(archivePath)
-	cleanBooksDir := filepath.Clean(h.booksDir)
-	if !strings.HasPrefix(cleanArchivePath, cleanBooksDir+string(os.PathSeparator)) && cleanArchivePath != cleanBooksDir {
-		log.Printf("Download: book_id=%s path traversal attempt: %s", book.ID, archivePath)
-		http.Error(w, "Invalid archive path", http.StatusBadRequest)
+	archivePath, err := h.bookArchivePath(book)
+	if err != nil {
+		if errors.Is(err, errInvalidArchivePath) {
+			log.Printf("Download: book_id=%s path traversal attempt: %s", book.ID, book.ArchivePath)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid archive path")
+			return
+		}
+		if errors.Is(err, errEmptyArchivePath) {
+			log.Printf("Download: book_id=%s has empty archive path", book.ID)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Book archive path is empty")
+			return
+		}
+		log.Printf("Download: book_id=%s failed to resolve archive %s: %v", book.ID, book.ArchivePath, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to open archive")
 		return
 	}
 	log.Printf("Download: book_id=%s resolved archive path %s", book.ID, archivePath)
@@ -220,74 +714,114 @@ func (h *Handlers) DownloadBook(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Download: book_id=%s archive missing: %s", book.ID, archivePath)
-			http.Error(w, "Book archive not found", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, codeNotFound, "Book archive not found")
 			return
 		}
 		log.Printf("Download: book_id=%s failed to open archive %s: %v", book.ID, archivePath, err)
-		http.Error(w, "Failed to open archive", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to open archive")
 		return
 	}
 	defer archive.Close()
 
-	format := strings.ToLower(book.Format)
-	if format == "" {
-		format = "fb2"
-	}
-	expectedFileName := book.ID + "." + format
-
-	// Also try zero-padded filename (e.g., "000024.fb2" for book ID "24")
-	var paddedFileName string
-	if _, err := fmt.Sscanf(book.ID, "%d", new(int)); err == nil {
-		paddedFileName = fmt.Sprintf("%06s", book.ID) + "." + format
+	bookFile, err := findBookFile(&archive.Reader, book)
+	if err != nil {
+		log.Printf("Download: book_id=%s in archive %s: %v", book.ID, archivePath, err)
+		writeError(w, http.StatusNotFound, codeNotFound, "Book file not found in archive")
+		return
 	}
 
-	var bookFile *zip.File
-	for _, file := range archive.File {
-		if strings.EqualFold(file.Name, expectedFileName) {
-			bookFile = file
-			break
-		}
-		if paddedFileName != "" && strings.EqualFold(file.Name, paddedFileName) {
-			bookFile = file
-			break
-		}
+	// The file as stored changes only with its archive entry, so mirrors
+	// can skip unchanged books; converted copies depend on the converter
+	if target == "" && notModified(w, r, bookFile.Modified) {
+		log.Printf("Download: book_id=%s not modified since %s", book.ID, r.Header.Get("If-Modified-Since"))
+		return
 	}
 
-	if bookFile == nil {
-		log.Printf("Download: book_id=%s not found inside archive %s (expected %s)", book.ID, archivePath, expectedFileName)
-		http.Error(w, "Book file not found in archive", http.StatusNotFound)
+	if !h.reserveDownload(w, r) {
 		return
 	}
 
 	// Open book file
 	rc, err := bookFile.Open()
 	if err != nil {
-		http.Error(w, "Failed to open book file", http.StatusInternalServerError)
+		h.releaseDownload(r)
+		log.Printf("Download: book_id=%s failed to open %s in archive %s: %v", book.ID, bookFile.Name, archivePath, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to open book file")
 		return
 	}
 	defer rc.Close()
 
+	if target != "" {
+		h.downloadConverted(w, r, book, rc, format, target, packaging)
+		return
+	}
+
 	// Set headers for download
-	filename := fmt.Sprintf("%s.%s", sanitizeFilename(book.Title), format)
-	log.Printf("Download: serving book_id=%s as %s (archive entry %s) from archive %s", book.ID, filename, bookFile.Name, archivePath)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("Content-Type", getContentType(book.Format))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", bookFile.UncompressedSize64))
-
-	// Stream file to response
-	_, err = io.Copy(w, rc)
+	filename := downloadFilename(h.filenameTemplate, book, format)
+	translit := h.transliterateDownload(r)
+	if packaging == packagingZip {
+		log.Printf("Download: serving book_id=%s as %s.zip (archive entry %s) from archive %s", book.ID, filename, bookFile.Name, archivePath)
+		w.Header().Set("Content-Disposition", contentDisposition(filename+".zip", translit))
+		w.Header().Set("Content-Type", zippedContentType(format))
+	} else {
+		log.Printf("Download: serving book_id=%s as %s (archive entry %s) from archive %s", book.ID, filename, bookFile.Name, archivePath)
+		w.Header().Set("Content-Disposition", contentDisposition(filename, translit))
+		w.Header().Set("Content-Type", getContentType(book.Format))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", bookFile.UncompressedSize64))
+	}
+
+	// Stream file to response, computing the checksum of the book file on
+	// first download
+	var cw *checksumWriter
+	var src io.Reader = rc
+	if book.SHA256 == "" {
+		cw = newChecksumWriter()
+		src = io.TeeReader(rc, cw)
+	}
+	var n int64
+	if packaging == packagingZip {
+		// The entry is named like the download, so it stays readable
+		// once unpacked
+		entryName := filename
+		if translit {
+			entryName = transliterate(filename)
+		}
+		n, err = writeZipped(w, entryName, bookFile.Modified, src)
+	} else {
+		n, err = io.Copy(w, src)
+	}
+	h.recordDownload(r, book, n, err == nil)
 	if err != nil {
 		// Can't send error response after starting to stream
 		return
 	}
+	if cw != nil {
+		if err := h.repo.SaveBookChecksum(book.ID, cw.sum(), cw.size); err != nil {
+			log.Printf("Download: book_id=%s: %v", book.ID, err)
+		}
+	}
 }
 
-// HealthCheck handles health check requests
+// HealthCheck handles health check requests. Once the books directory is
+// probed, its state is included, and a failed probe reports the service as
+// degraded while still answering 200.
 func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{
+	response := map[string]interface{}{
 		"status":  "ok",
 		"service": "pushkinlib",
 	}
+	if h.repo.ReadOnly() {
+		response["read_only"] = true
+	}
+	// A missing books directory degrades downloads only: the catalog is
+	// still served, so the check stays 200 and orchestrators keep the
+	// container running
+	if books := h.books.Load(); books != nil {
+		response["books"] = books
+		if !books.Available {
+			response["status"] = "degraded"
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -295,6 +829,25 @@ func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// notModified sets Last-Modified to modified, the time of an archive entry,
+// and answers 304 Not Modified if the request's If-Modified-Since is not
+// earlier. A zero time sets nothing.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	// HTTP dates have a resolution of one second
+	modified = modified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
 // sanitizeFilename removes invalid characters from filename
 func sanitizeFilename(filename string) string {
 	// Replace invalid characters
@@ -336,6 +889,10 @@ func getContentType(format string) string {
 		return "application/epub+zip"
 	case "pdf":
 		return "application/pdf"
+	case "mobi":
+		return "application/x-mobipocket-ebook"
+	case "azw3":
+		return "application/vnd.amazon.ebook"
 	default:
 		return "application/octet-stream"
 	}
@@ -1,16 +1,24 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/enrichment"
 	"github.com/piligrim/pushkinlib/internal/inpx"
 	"github.com/piligrim/pushkinlib/internal/storage"
 )
@@ -124,6 +132,34 @@ func TestHealthCheck(t *testing.T) {
 	}
 }
 
+// TestSetLogLevel verifies request logging follows the log level.
+func TestSetLogLevel(t *testing.T) {
+	h := setupTestHandlers(t)
+	var buf bytes.Buffer
+	defaultLogger := middleware.DefaultLogger
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.New(&buf, "", 0), NoColor: true})
+	defer func() { middleware.DefaultLogger = defaultLogger }()
+
+	handler := h.requestLogger(http.HandlerFunc(h.HealthCheck))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if !strings.Contains(buf.String(), "/health") {
+		t.Errorf("expected requests to be logged by default, got %q", buf.String())
+	}
+
+	if err := h.SetLogLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if buf.Len() != 0 {
+		t.Errorf("expected no request log at warn level, got %q", buf.String())
+	}
+
+	if err := h.SetLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
 // TestGetBookByID verifies book retrieval returns valid JSON (#5).
 func TestGetBookByID(t *testing.T) {
 	h := setupTestHandlers(t)
@@ -155,6 +191,52 @@ func TestGetBookByID(t *testing.T) {
 	}
 }
 
+// TestGetBookDetails verifies the book, its series neighbours and the other
+// books of its author in one response.
+func TestGetBookDetails(t *testing.T) {
+	h := setupTestHandlers(t)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "test-002", Title: "Second", Authors: []string{"Test Author"}, Series: "Test Series", SeriesNum: 2, Genre: "fiction", Format: "fb2", Date: time.Now()},
+		{ID: "test-003", Title: "Other", Authors: []string{"Test Author"}, Genre: "fiction", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	details := func(id string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req := httptest.NewRequest("GET", "/api/v1/books/"+id+"/full", nil)
+		w := httptest.NewRecorder()
+		h.GetBookDetails(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	w := details("test-001")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result storage.BookDetails
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Book == nil || result.Book.Annotation != "Test annotation text" {
+		t.Errorf("unexpected book: %+v", result.Book)
+	}
+	if result.Previous != nil || result.Next == nil || result.Next.ID != "test-002" || result.SeriesTotal != 2 {
+		t.Errorf("unexpected series neighbours: %+v, %+v, %d", result.Previous, result.Next, result.SeriesTotal)
+	}
+	if len(result.AuthorBooks) != 2 {
+		t.Errorf("expected the 2 other books of the author, got %+v", result.AuthorBooks)
+	}
+	if len(result.Genres) != 1 || result.Genres[0].Name != "fiction" || result.Genres[0].BookCount != 3 {
+		t.Errorf("unexpected genres: %+v", result.Genres)
+	}
+
+	if w := details("nonexistent"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing book, got %d", w.Code)
+	}
+}
+
 // TestGetBookByID_NotFound verifies 404 for missing book.
 func TestGetBookByID_NotFound(t *testing.T) {
 	h := setupTestHandlers(t)
@@ -171,6 +253,48 @@ func TestGetBookByID_NotFound(t *testing.T) {
 	if w.Code != http.StatusNotFound {
 		t.Errorf("expected 404, got %d", w.Code)
 	}
+
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected JSON error envelope: %v", err)
+	}
+	if resp.Error.Code != codeNotFound {
+		t.Errorf("expected code %q, got %q", codeNotFound, resp.Error.Code)
+	}
+}
+
+// TestAPIRoutes_ErrorEnvelope verifies unknown endpoints and methods under
+// /api/v1 answer with the JSON error envelope.
+func TestAPIRoutes_ErrorEnvelope(t *testing.T) {
+	router := SetupRoutes(setupTestHandlers(t))
+
+	cases := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/api/v1/nope", http.StatusNotFound, codeNotFound},
+		{"DELETE", "/api/v1/books", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"GET", "/api/v1/books?sort_by=bogus", http.StatusBadRequest, codeInvalidFilter},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, w.Code)
+			continue
+		}
+		var resp apiError
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Errorf("%s %s: expected JSON error envelope: %v", tc.method, tc.path, err)
+			continue
+		}
+		if resp.Error.Code != tc.code {
+			t.Errorf("%s %s: expected code %q, got %q", tc.method, tc.path, tc.code, resp.Error.Code)
+		}
+	}
 }
 
 // TestDownloadBook_PathTraversal verifies path traversal protection (#13).
@@ -264,21 +388,521 @@ func TestDownloadBook_ArchiveNotFound(t *testing.T) {
 	}
 }
 
-// TestReindexLibrary_ConcurrentProtection verifies mutex prevents concurrent reindex (#9).
+// TestReindexLibrary_ConcurrentProtection verifies the guard prevents concurrent reindex (#9).
 func TestReindexLibrary_ConcurrentProtection(t *testing.T) {
 	h := setupTestHandlers(t)
 
-	// Lock the mutex manually to simulate an in-progress reindex
-	h.reindexMu.Lock()
+	// Hold the guard to simulate an in-progress reindex
+	job, err := h.jobs.Begin("reindex")
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	for _, handler := range []http.HandlerFunc{h.ReindexLibrary, h.StartReindex} {
+		req := httptest.NewRequest("POST", "/admin/reindex", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		var resp apiError
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode error: %v", err)
+		}
+		if w.Code != http.StatusConflict || resp.Error.Code != codeReindexRunning || resp.Error.JobID != job.ID {
+			t.Errorf("expected 409 naming job %s, got %d: %+v", job.ID, w.Code, resp.Error)
+		}
+	}
+
+	h.jobs.End(job)
+	if _, running := h.jobs.Current(); running {
+		t.Error("expected the guard to be released")
+	}
+}
+
+// TestUpdateBook verifies PATCH applies metadata corrections.
+func TestUpdateBook(t *testing.T) {
+	h := setupTestHandlers(t)
 
-	req := httptest.NewRequest("POST", "/admin/reindex", nil)
+	body := strings.NewReader(`{"title":"Corrected Title","rating":3}`)
+	req := httptest.NewRequest("PATCH", "/api/v1/books/test-001", body)
 	w := httptest.NewRecorder()
 
-	h.ReindexLibrary(w, req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "test-001")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	h.UpdateBook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var book storage.Book
+	if err := json.NewDecoder(w.Body).Decode(&book); err != nil {
+		t.Fatalf("failed to decode book: %v", err)
+	}
+	if book.Title != "Corrected Title" || book.Rating != 3 {
+		t.Errorf("unexpected book after update: %+v", book)
+	}
+	if book.Annotation != "Test annotation text" {
+		t.Errorf("annotation should be unchanged, got %q", book.Annotation)
+	}
+}
+
+// TestUpdateBook_Validation verifies invalid corrections are rejected.
+func TestUpdateBook_Validation(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	cases := map[string]struct {
+		id   string
+		body string
+		want int
+	}{
+		"bad rating":  {"test-001", `{"rating":9}`, http.StatusBadRequest},
+		"empty title": {"test-001", `{"title":"  "}`, http.StatusBadRequest},
+		"bad json":    {"test-001", `{`, http.StatusBadRequest},
+		"not found":   {"missing", `{"rating":1}`, http.StatusNotFound},
+	}
 
-	h.reindexMu.Unlock()
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/api/v1/books/"+tc.id, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
 
-	if w.Code != http.StatusServiceUnavailable {
-		t.Errorf("expected 503 when reindex is already running, got %d: %s", w.Code, w.Body.String())
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tc.id)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			h.UpdateBook(w, req)
+
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestTagHandlers verifies tag creation, assignment and tag filtering in search.
+func TestTagHandlers(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("POST", "/api/v1/admin/tags", strings.NewReader(`{"name":"классика"}`))
+	w := httptest.NewRecorder()
+	h.CreateTag(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var tag storage.Tag
+	if err := json.NewDecoder(w.Body).Decode(&tag); err != nil {
+		t.Fatalf("failed to decode tag: %v", err)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/admin/tags", strings.NewReader(`{"name":"классика"}`))
+	w = httptest.NewRecorder()
+	h.CreateTag(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for duplicate tag, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/admin/books/test-001/tags/1", nil)
+	w = httptest.NewRecorder()
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "test-001")
+	rctx.URLParams.Add("tagID", strconv.Itoa(tag.ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	h.AddBookTag(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/books?tags=классика", nil)
+	w = httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	var result storage.BookList
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Total != 1 || result.Books[0].ID != "test-001" {
+		t.Errorf("expected tagged book in results, got %+v", result.Books)
+	}
+}
+
+// TestAdminStatsAndReindexStatus verifies the admin panel endpoints.
+func TestAdminStatsAndReindexStatus(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/stats", nil)
+	w := httptest.NewRecorder()
+	h.GetStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var stats storage.LibraryStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.Books != 1 || stats.Authors != 1 || stats.Series != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// inpxPath is empty in tests, so the run fails and the error is recorded
+	job, err := h.jobs.Begin("reindex")
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := h.runReindex(job); err == nil {
+		t.Fatal("expected reindex without INPX path to fail")
+	}
+	h.jobs.End(job)
+
+	req = httptest.NewRequest("GET", "/api/v1/admin/reindex/status", nil)
+	w = httptest.NewRecorder()
+	h.GetReindexStatus(w, req)
+
+	var status reindexStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if status.Running || status.Error == "" || status.FinishedAt == nil {
+		t.Errorf("unexpected reindex status: %+v", status)
+	}
+}
+
+// TestMergeAuthors_Validation verifies merge request validation.
+func TestMergeAuthors_Validation(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	cases := map[string]struct {
+		body string
+		want int
+	}{
+		"same author": {`{"source_id":1,"target_id":1}`, http.StatusBadRequest},
+		"missing":     {`{"source_id":1,"target_id":999}`, http.StatusNotFound},
+		"bad json":    {`{`, http.StatusBadRequest},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/admin/authors/merge", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			h.MergeAuthors(w, req)
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestSetAuthorAlias_Validation verifies alias request validation.
+func TestSetAuthorAlias_Validation(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	cases := map[string]struct {
+		body string
+		want int
+	}{
+		"same author": {`{"alias_id":1,"author_id":1}`, http.StatusBadRequest},
+		"missing":     {`{"alias_id":1,"author_id":999}`, http.StatusNotFound},
+		"bad json":    {`{`, http.StatusBadRequest},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/admin/authors/aliases", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			h.SetAuthorAlias(w, req)
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d: %s", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/authors/aliases/1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.DeleteAuthorAlias(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("delete of a non-alias: expected 404, got %d", w.Code)
+	}
+}
+
+// TestSearchBooks_InvalidSort verifies unknown sort fields are rejected.
+func TestSearchBooks_InvalidSort(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/books?sort_by=bogus", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+// TestSearchBooks_InvalidQuery verifies unusable queries get a structured 400.
+func TestSearchBooks_InvalidQuery(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/books?q=%22%22%22", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if resp.Error.Code != "invalid_query" || resp.Error.Message == "" {
+		t.Errorf("unexpected error body: %+v", resp)
+	}
+}
+
+// TestGetFacets verifies facet counts follow the search parameters.
+func TestGetFacets(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/facets?q=Test&formats=epub", nil)
+	w := httptest.NewRecorder()
+	h.GetFacets(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var facets storage.BookFacets
+	if err := json.NewDecoder(w.Body).Decode(&facets); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// The format facet ignores formats=epub; the others are narrowed by it
+	if len(facets.Formats) != 1 || facets.Formats[0] != (storage.FacetCount{Value: "fb2", Count: 1}) {
+		t.Errorf("unexpected formats: %+v", facets.Formats)
+	}
+	if len(facets.Genres) != 0 || len(facets.Languages) != 0 || len(facets.Years) != 0 {
+		t.Errorf("expected other facets to be empty: %+v", facets)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/facets?year=2024", nil)
+	w = httptest.NewRecorder()
+	h.GetFacets(w, req)
+	if err := json.NewDecoder(w.Body).Decode(&facets); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(facets.Years) != 1 || facets.Years[0] != (storage.YearCount{Year: 2020, Count: 1}) {
+		t.Errorf("unexpected years: %+v", facets.Years)
+	}
+	if len(facets.Genres) != 1 || facets.Genres[0].Value != "fiction" {
+		t.Errorf("unexpected genres: %+v", facets.Genres)
+	}
+}
+
+// TestGetYearStats verifies the year histogram and its decades.
+func TestGetYearStats(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/stats/years", nil)
+	w := httptest.NewRecorder()
+	h.GetYearStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var stats struct {
+		Years   []storage.YearCount `json:"years"`
+		Decades []storage.YearCount `json:"decades"`
+		Total   int                 `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(stats.Years) != 1 || stats.Years[0] != (storage.YearCount{Year: 2024, Count: 1}) {
+		t.Errorf("unexpected years: %+v", stats.Years)
+	}
+	if len(stats.Decades) != 1 || stats.Decades[0] != (storage.YearCount{Year: 2020, Count: 1}) || stats.Total != 1 {
+		t.Errorf("unexpected decades %+v or total %d", stats.Decades, stats.Total)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/stats/years?language=xx", nil)
+	w = httptest.NewRecorder()
+	h.GetYearStats(w, req)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"decades":[],"total":0,"years":[]}` {
+		t.Errorf("unexpected empty histogram %s", body)
+	}
+}
+
+// TestSearchBooks_FTSOperators verifies FTS syntax in queries does not fail the request.
+func TestSearchBooks_FTSOperators(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/books?q="+url.QueryEscape(`"Test AND (`), nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestParseSearchQuery verifies the parse endpoint reports fields and
+// unknown prefixes.
+func TestParseSearchQuery(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/api/v1/search/parse?q="+url.QueryEscape("author:Test genre:x"), nil)
+	w := httptest.NewRecorder()
+	h.ParseSearchQuery(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var parsed storage.ParsedQuery
+	if err := json.NewDecoder(w.Body).Decode(&parsed); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(parsed.Authors) != 1 || parsed.Authors[0] != "test" || len(parsed.Warnings) != 1 || parsed.FTS == "" {
+		t.Errorf("unexpected parse result: %+v", parsed)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/search/parse?q=*", nil)
+	w = httptest.NewRecorder()
+	h.ParseSearchQuery(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid query, got %d", w.Code)
+	}
+}
+
+// TestRunMaintenance_ReindexInProgress verifies maintenance does not overlap a reindex.
+func TestRunMaintenance_ReindexInProgress(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	job, err := h.jobs.Begin("reindex")
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/api/v1/admin/maintenance", nil)
+	w := httptest.NewRecorder()
+	h.RunMaintenance(w, req)
+	h.jobs.End(job)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 during reindex, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/admin/maintenance", nil)
+	w = httptest.NewRecorder()
+	h.RunMaintenance(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result storage.MaintenanceResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if result.Vacuumed {
+		t.Error("vacuum should only run when requested")
+	}
+}
+
+// TestGetAuthor verifies author details with and without enrichment.
+func TestGetAuthor(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	authors, err := h.repo.FindAuthors("Test Author", 1)
+	if err != nil || len(authors) != 1 {
+		t.Fatalf("failed to find test author: %v", err)
+	}
+	authorID := strconv.Itoa(authors[0].ID)
+
+	get := func() map[string]interface{} {
+		req := httptest.NewRequest("GET", "/api/v1/authors/"+authorID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", authorID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+
+		h.GetAuthor(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	resp := get()
+	if resp["name"] != "Test Author" || resp["book_count"] != float64(1) {
+		t.Errorf("unexpected author response: %v", resp)
+	}
+	if resp["info"] != nil {
+		t.Errorf("expected no info with enrichment disabled, got %v", resp["info"])
+	}
+
+	var wikiRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/portrait.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png-data"))
+			return
+		}
+		atomic.AddInt32(&wikiRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"query":{"pages":[{"title":"Test Author","extract":"Test biography.","fullurl":"https://example.org/wiki/Test"}]}}`))
+	}))
+	defer server.Close()
+	h.SetAuthorEnrichment(enrichment.NewService(h.repo, enrichment.Config{APIURL: server.URL}))
+
+	// Missing info is only queued; the request does not wait for Wikipedia
+	resp = get()
+	if resp["info"] != nil {
+		t.Errorf("expected no info before the background fetch, got %v", resp["info"])
+	}
+	if n := atomic.LoadInt32(&wikiRequests); n != 0 {
+		t.Errorf("expected no request to Wikipedia, got %d", n)
+	}
+
+	if err := h.repo.SaveAuthorInfo(&storage.AuthorInfo{
+		AuthorName: "Test Author",
+		Found:      true,
+		Bio:        "Test biography.",
+		PhotoURL:   server.URL + "/portrait.png",
+		FetchedAt:  time.Now(),
+	}); err != nil {
+		t.Fatalf("failed to save author info: %v", err)
+	}
+
+	resp = get()
+	info, ok := resp["info"].(map[string]interface{})
+	if !ok || info["bio"] != "Test biography." {
+		t.Fatalf("expected enriched info, got %v", resp["info"])
+	}
+	portraitURL := "/api/v1/authors/" + authorID + "/portrait"
+	if info["photo_url"] != portraitURL {
+		t.Errorf("expected proxied portrait URL %q, got %v", portraitURL, info["photo_url"])
+	}
+
+	req := httptest.NewRequest("GET", portraitURL, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", authorID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	h.GetAuthorPortrait(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for portrait, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected image/png, got %q", ct)
+	}
+	if w.Body.String() != "png-data" {
+		t.Errorf("unexpected portrait body %q", w.Body.String())
 	}
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// ListHidden returns the books and archives hidden from the catalog (admin
+// only).
+// GET /api/v1/admin/hidden
+func (h *Handlers) ListHidden(w http.ResponseWriter, r *http.Request) {
+	books, err := h.repo.ListHiddenBooks()
+	if err != nil {
+		log.Printf("ListHidden: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	archives, err := h.repo.ListHiddenArchives()
+	if err != nil {
+		log.Printf("ListHidden: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"books": books, "archives": archives}); err != nil {
+		log.Printf("ListHidden: failed to encode response: %v", err)
+	}
+}
+
+// HideBook hides a book from the catalog, its feeds and downloads (admin
+// only). The book stays hidden after reindexing.
+// PUT /api/v1/admin/hidden/books/{id}
+func (h *Handlers) HideBook(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	if err := h.repo.HideBook(bookID); err != nil {
+		if errors.Is(err, storage.ErrBookNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+			return
+		}
+		log.Printf("HideBook: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("HideBook: failed to encode response: %v", err)
+	}
+}
+
+// UnhideBook returns a hidden book to the catalog (admin only).
+// DELETE /api/v1/admin/hidden/books/{id}
+func (h *Handlers) UnhideBook(w http.ResponseWriter, r *http.Request) {
+	unhidden, err := h.repo.UnhideBook(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("UnhideBook: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !unhidden {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book is not hidden")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("UnhideBook: failed to encode response: %v", err)
+	}
+}
+
+// HideArchive hides every book of an archive, including books added to it
+// by later imports (admin only). The path is relative to the library, as in
+// the INPX.
+// PUT /api/v1/admin/hidden/archives/{path}
+func (h *Handlers) HideArchive(w http.ResponseWriter, r *http.Request) {
+	archivePath := strings.Trim(chi.URLParam(r, "*"), "/")
+	if archivePath == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Archive path is required")
+		return
+	}
+
+	books, err := h.repo.HideArchive(archivePath)
+	if err != nil {
+		log.Printf("HideArchive: archive=%s error: %v", archivePath, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "books": books}); err != nil {
+		log.Printf("HideArchive: failed to encode response: %v", err)
+	}
+}
+
+// UnhideArchive returns the books of a hidden archive to the catalog (admin
+// only).
+// DELETE /api/v1/admin/hidden/archives/{path}
+func (h *Handlers) UnhideArchive(w http.ResponseWriter, r *http.Request) {
+	unhidden, err := h.repo.UnhideArchive(strings.Trim(chi.URLParam(r, "*"), "/"))
+	if err != nil {
+		log.Printf("UnhideArchive: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !unhidden {
+		writeError(w, http.StatusNotFound, codeNotFound, "Archive is not hidden")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("UnhideArchive: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHiddenBooks hides the test book and then its archive and checks the
+// book disappears from search and its pages for everyone but admins.
+func TestHiddenBooks(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	do := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if admin {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	total := func() int {
+		t.Helper()
+		var resp struct {
+			Total int `json:"total"`
+		}
+		if err := json.Unmarshal(do("GET", "/api/v1/books", false).Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode search response: %v", err)
+		}
+		return resp.Total
+	}
+	expectHidden := func(hidden bool) {
+		t.Helper()
+		want, wantTotal := http.StatusOK, 1
+		if hidden {
+			want, wantTotal = http.StatusNotFound, 0
+		}
+		if n := total(); n != wantTotal {
+			t.Errorf("search total = %d, want %d", n, wantTotal)
+		}
+		for _, path := range []string{"/api/v1/books/test-001", "/download/test-001", "/books/test-001"} {
+			if w := do("GET", path, false); w.Code != want {
+				t.Errorf("GET %s: got %d, want %d", path, w.Code, want)
+			}
+		}
+	}
+
+	expectHidden(false)
+
+	if w := do("PUT", "/api/v1/admin/hidden/books/test-001", true); w.Code != http.StatusOK {
+		t.Fatalf("HideBook: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	expectHidden(true)
+	if w := do("GET", "/api/v1/books/test-001", true); w.Code != http.StatusOK {
+		t.Errorf("admin book: got %d, want 200", w.Code)
+	}
+	if w := do("PUT", "/api/v1/admin/hidden/books/missing", true); w.Code != http.StatusNotFound {
+		t.Errorf("HideBook of a missing book: got %d, want 404", w.Code)
+	}
+
+	w := do("GET", "/api/v1/admin/hidden", true)
+	var list struct {
+		Books []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"books"`
+		Archives []struct {
+			ArchivePath string `json:"archive_path"`
+			Books       int    `json:"books"`
+		} `json:"archives"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to decode hidden list: %v", err)
+	}
+	if len(list.Books) != 1 || list.Books[0].ID != "test-001" || list.Books[0].Title != "Test Book Title" {
+		t.Errorf("unexpected hidden books %+v", list.Books)
+	}
+
+	if w := do("DELETE", "/api/v1/admin/hidden/books/test-001", true); w.Code != http.StatusOK {
+		t.Fatalf("UnhideBook: expected 200, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/v1/admin/hidden/books/test-001", true); w.Code != http.StatusNotFound {
+		t.Errorf("second UnhideBook: got %d, want 404", w.Code)
+	}
+	expectHidden(false)
+
+	// Whole archives
+	if w := do("PUT", "/api/v1/admin/hidden/archives/test-archive", true); w.Code != http.StatusOK {
+		t.Fatalf("HideArchive: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	expectHidden(true)
+	if err := json.Unmarshal(do("GET", "/api/v1/admin/hidden", true).Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to decode hidden list: %v", err)
+	}
+	if len(list.Archives) != 1 || list.Archives[0].ArchivePath != "test-archive" || list.Archives[0].Books != 1 {
+		t.Errorf("unexpected hidden archives %+v", list.Archives)
+	}
+	if w := do("DELETE", "/api/v1/admin/hidden/archives/test-archive", true); w.Code != http.StatusOK {
+		t.Fatalf("UnhideArchive: expected 200, got %d", w.Code)
+	}
+	expectHidden(false)
+}
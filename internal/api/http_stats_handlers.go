@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// GetHTTPStats returns request counts and p50/p95/p99 latencies per route
+// group (api, opds, downloads, other) and per route since startup or the
+// last reset (admin only).
+// GET /api/v1/stats/http
+func (h *Handlers) GetHTTPStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.httpStats.Snapshot()); err != nil {
+		log.Printf("GetHTTPStats: failed to encode response: %v", err)
+	}
+}
+
+// ResetHTTPStats drops the HTTP statistics collected so far (admin only).
+// DELETE /api/v1/stats/http
+func (h *Handlers) ResetHTTPStats(w http.ResponseWriter, r *http.Request) {
+	h.httpStats.Reset()
+	log.Printf("ResetHTTPStats: statistics reset")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("ResetHTTPStats: failed to encode response: %v", err)
+	}
+}
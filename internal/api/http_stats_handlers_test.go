@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/httpstats"
+)
+
+func TestHTTPStats(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	do := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if admin {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("GET", "/api/v1/books/test-001", false)
+	do("GET", "/api/v1/books/missing", false)
+	if w := do("GET", "/api/v1/stats/http", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous stats: got %d, want 401", w.Code)
+	}
+
+	w := do("GET", "/api/v1/stats/http", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetHTTPStats: expected 200, got %d", w.Code)
+	}
+	var snap httpstats.Snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	route := snap.Routes["GET /api/v1/books/{id}"]
+	if route.Requests != 2 || route.ClientErrors != 1 {
+		t.Errorf("unexpected book route series %+v", route)
+	}
+	if snap.Groups[httpstats.GroupAPI].Requests < 3 {
+		t.Errorf("unexpected api group series %+v", snap.Groups[httpstats.GroupAPI])
+	}
+
+	if w := do("DELETE", "/api/v1/stats/http", true); w.Code != http.StatusOK {
+		t.Fatalf("ResetHTTPStats: expected 200, got %d", w.Code)
+	}
+	snap = h.httpStats.Snapshot()
+	if _, ok := snap.Routes["GET /api/v1/books/{id}"]; ok {
+		t.Errorf("book route survived reset: %+v", snap.Routes)
+	}
+}
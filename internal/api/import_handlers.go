@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/indexer"
+)
+
+// maxImportSize bounds an uploaded INPX; full Flibusta catalogs are smaller
+const maxImportSize = 256 << 20
+
+// ImportINPX merges an uploaded INPX, e.g. a daily delta, into the catalog
+// without clearing it; books with known IDs are updated (admin only). The
+// file is sent as the "file" field of a multipart form.
+// POST /api/v1/import
+func (h *Handlers) ImportINPX(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeInvalidBody, "INPX file is too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Multipart field \"file\" with an INPX file is required")
+		return
+	}
+	defer file.Close()
+
+	// The INPX is a ZIP archive, which is read from a file
+	tmp, err := os.CreateTemp("", "pushkinlib-import-*.inpx")
+	if err != nil {
+		log.Printf("ImportINPX: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, file); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Failed to read the uploaded file")
+		return
+	}
+
+	job, err := h.jobs.Begin("import")
+	if err != nil {
+		writeBusy(w, err)
+		return
+	}
+	defer h.jobs.End(job)
+
+	// The cover job writes to the database; pause it while books are merged
+	h.stopCoverJob()
+	result, err := indexer.ImportDeltaINPX(h.repo, tmp.Name())
+	h.StartCoverJob()
+	h.StartAuthorDuplicatesJob()
+	if err != nil {
+		if errors.Is(err, indexer.ErrINPXInvalid) {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		log.Printf("ImportINPX: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	collectionName := ""
+	if result.Collection != nil {
+		collectionName = result.Collection.Name
+	}
+
+	response := map[string]interface{}{
+		"status":             "ok",
+		"added":              result.Added,
+		"updated":            result.Updated,
+		"removed":            result.Removed,
+		"skipped":            result.Skipped,
+		"unmapped_genres":    result.UnmappedGenres,
+		"detected_languages": result.DetectedLanguages,
+		"author_merges":      result.AuthorMerges,
+		"author_splits":      result.AuthorSplits,
+		"overrides":          result.Overrides,
+		"search_terms":       result.SearchTerms,
+		"sync_changes":       result.SyncChanges,
+		"collection":         collectionName,
+		"duration_ms":        result.Duration.Milliseconds(),
+	}
+	if check := h.sizeCheckAfterImport(); check != nil {
+		response["size_check"] = check
+	}
+	h.notifyAfterImport()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("ImportINPX: failed to encode response: %v", err)
+	}
+}
+
+// StartINPXRefresh checks the INPX given by URL for a new version every
+// interval until ctx is done. A changed catalog is merged into the existing
+// one with indexer.RefreshFromINPX, which keeps reading positions, shelves
+// and other references to books that remain. Checks that would overlap a
+// reindex are skipped.
+func (h *Handlers) StartINPXRefresh(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || h.remoteINPX == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.refreshINPX(ctx)
+			}
+		}
+	}()
+}
+
+// refreshINPX runs one check of StartINPXRefresh
+func (h *Handlers) refreshINPX(ctx context.Context) {
+	job, err := h.jobs.Begin("refresh")
+	if err != nil {
+		log.Printf("INPX refresh: skipped, %v", err)
+		return
+	}
+	defer h.jobs.End(job)
+
+	inpxPath, changed, err := h.remoteINPX.Fetch(ctx)
+	if err != nil {
+		log.Printf("INPX refresh: %v", err)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	// The cover job writes to the database; pause it while books are merged
+	h.stopCoverJob()
+	result, err := indexer.RefreshFromINPX(h.repo, inpxPath)
+	h.StartCoverJob()
+	h.StartAuthorDuplicatesJob()
+	if err != nil {
+		log.Printf("INPX refresh: %v", err)
+		return
+	}
+	if err := h.remoteINPX.MarkMerged(inpxPath); err != nil {
+		log.Printf("INPX refresh: failed to record the merged INPX: %v", err)
+	}
+	log.Printf("INPX refresh: added %d books, updated %d, removed %d in %s",
+		result.Added, result.Updated, result.Removed, result.Duration.Truncate(time.Millisecond))
+	h.sizeCheckAfterImport()
+	h.notifyAfterImport()
+}
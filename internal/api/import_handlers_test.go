@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// uploadRequest builds a multipart request carrying data as the "file" field
+func uploadRequest(t *testing.T, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "delta.inpx")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// TestImportINPX verifies a delta INPX adds new books and updates known ones
+// without clearing the catalog.
+func TestImportINPX(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	var delta bytes.Buffer
+	writer := inpx.NewWriter(&delta)
+	for _, book := range []inpx.Book{
+		{ID: "test-001", Title: "Updated Title", Authors: []string{"Test Author"}, Genre: "fiction", Language: "ru", Format: "fb2", ArchivePath: "test-archive", Date: time.Now()},
+		{ID: "new-001", Title: "New Book", Authors: []string{"New Author"}, Genre: "fiction", Language: "ru", Format: "fb2", ArchivePath: "delta-archive", Date: time.Now()},
+	} {
+		if err := writer.Add(book); err != nil {
+			t.Fatalf("failed to add book: %v", err)
+		}
+	}
+	if err := writer.Close(inpx.CollectionInfo{Name: "Delta - 2024-01-02", Version: "2024-01-02"}); err != nil {
+		t.Fatalf("failed to write INPX: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ImportINPX(w, uploadRequest(t, delta.Bytes()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Added   int `json:"added"`
+		Updated int `json:"updated"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Added != 1 || resp.Updated != 1 {
+		t.Errorf("expected 1 added and 1 updated, got %+v", resp)
+	}
+
+	book, err := h.repo.GetBookByID("test-001")
+	if err != nil || book == nil || book.Title != "Updated Title" {
+		t.Errorf("expected the known book to be updated, got %+v, %v", book, err)
+	}
+	if book, err := h.repo.GetBookByID("new-001"); err != nil || book == nil {
+		t.Errorf("expected the new book to be added, got %+v, %v", book, err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ImportINPX(w, uploadRequest(t, []byte("not a zip")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid INPX, got %d: %s", w.Code, w.Body.String())
+	}
+}
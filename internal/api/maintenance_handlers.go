@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RunMaintenance checkpoints the WAL, optimizes and optionally vacuums the database (admin only).
+// POST /api/v1/admin/maintenance?vacuum=true
+func (h *Handlers) RunMaintenance(w http.ResponseWriter, r *http.Request) {
+	vacuum := r.URL.Query().Get("vacuum") == "true"
+
+	// Maintenance and reindex both rewrite the database; never run them together
+	job, err := h.jobs.Begin("maintenance")
+	if err != nil {
+		writeBusy(w, err)
+		return
+	}
+	result, err := h.repo.RunMaintenance(vacuum)
+	h.jobs.End(job)
+
+	if err != nil {
+		log.Printf("RunMaintenance: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("RunMaintenance: failed to encode response: %v", err)
+	}
+}
+
+// StartMaintenanceScheduler runs database maintenance every interval until ctx is done.
+// Runs that would overlap a reindex are skipped.
+func (h *Handlers) StartMaintenanceScheduler(ctx context.Context, interval time.Duration, vacuum bool) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				job, err := h.jobs.Begin("maintenance")
+				if err != nil {
+					log.Printf("Maintenance: skipped, %v", err)
+					continue
+				}
+				result, err := h.repo.RunMaintenance(vacuum)
+				h.jobs.End(job)
+				if err != nil {
+					log.Printf("Maintenance: %v", err)
+					continue
+				}
+				log.Printf("Maintenance: reclaimed %d bytes in %dms (vacuum=%t)",
+					result.Reclaimed, result.DurationMs, result.Vacuumed)
+			}
+		}
+	}()
+}
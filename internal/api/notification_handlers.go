@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/notifications"
+)
+
+// SetNotifier enables notifications of new books for saved searches; they
+// are sent after every import.
+func (h *Handlers) SetNotifier(notifier *notifications.Notifier) {
+	h.notifier = notifier
+}
+
+// notifyAfterImport asks the notifier, if any, to send the books of the
+// import that match saved searches
+func (h *Handlers) notifyAfterImport() {
+	if h.notifier != nil {
+		h.notifier.Trigger()
+	}
+}
+
+// GetNotificationStatus returns the report of the last notification run,
+// null before the first (admin only).
+// GET /api/v1/admin/notifications
+func (h *Handlers) GetNotificationStatus(w http.ResponseWriter, r *http.Request) {
+	if h.notifier == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Notifications are not configured")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"last_run": h.notifier.LastReport(),
+	}); err != nil {
+		log.Printf("GetNotificationStatus: failed to encode response: %v", err)
+	}
+}
+
+// RunNotifications sends the new books of saved searches now and returns
+// the report (admin only). Returns 409 if notifications are being sent.
+// POST /api/v1/admin/notifications/run
+func (h *Handlers) RunNotifications(w http.ResponseWriter, r *http.Request) {
+	if h.notifier == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Notifications are not configured")
+		return
+	}
+
+	report, err := h.notifier.Run(r.Context())
+	if errors.Is(err, notifications.ErrBusy) {
+		writeError(w, http.StatusConflict, codeConflict, "Notifications are already being sent")
+		return
+	}
+	if err != nil {
+		log.Printf("RunNotifications: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("RunNotifications: failed to encode response: %v", err)
+	}
+}
@@ -1,34 +1,128 @@
 package api
 
 import (
+	"log"
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/piligrim/pushkinlib/internal/auth"
 	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
 // SetupOPDSRoutes configures OPDS routes with optional BasicAuth protection.
-// When auth is enabled, OPDS clients must authenticate via HTTP Basic Auth.
+// When auth is enabled, OPDS clients must authenticate via HTTP Basic Auth
+// and can discover it through the OPDS Authentication Document.
 func SetupOPDSRoutes(r chi.Router, opdsHandler *opds.Handler, authMw *auth.Middleware) {
+	if authMw.IsEnabled() {
+		opdsHandler.SetAuthEnabled(true)
+		UpdateOPDSAuthDocument(opdsHandler, authMw)
+	}
+
+	r.Route("/opds", func(r chi.Router) {
+		// Authentication Document must be reachable without credentials
+		r.Get("/auth.json", opdsHandler.AuthDocument)
+
+		// Shared shelves are read with the random token stored for the
+		// shelf instead, until the owner revokes it
+		r.Get("/shelves/{id}", opdsHandler.ShelfBooks)
+
+		r.Group(func(r chi.Router) {
+			// Apply BasicAuth middleware for OPDS clients (e-readers)
+			r.Use(authMw.RequireBasicAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
+			r.Use(opdsHandler.FormatPreference)
+			registerOPDSRoutes(r, opdsHandler)
+		})
+	})
+}
+
+// registerOPDSRoutes adds the catalog routes, relative to /opds.
+func registerOPDSRoutes(r chi.Router, opdsHandler *opds.Handler) {
+	registerCatalogRoutes(r, opdsHandler)
+
+	// The same catalog scoped to the books of one language
+	if opdsHandler.LanguagesEnabled() {
+		r.Route("/lang/{lang}", func(r chi.Router) {
+			r.Use(opdsHandler.LanguageScope)
+			registerCatalogRoutes(r, opdsHandler)
+		})
+	}
+
+	// External catalogs aggregated into this one
+	if opdsHandler.UpstreamsEnabled() {
+		r.Get("/upstreams", opdsHandler.Upstreams)
+		r.Get("/upstreams/search", opdsHandler.SearchUpstreams)
+		r.Get("/upstreams/{source}", opdsHandler.UpstreamBooks)
+		r.Get("/upstreams/{source}/download/{key}/{index}", opdsHandler.UpstreamDownload)
+	}
+
+	// OPDS 2.0 (JSON) catalog
+	if opdsHandler.OPDS2Enabled() {
+		r.Get("/v2", opdsHandler.Root2)
+		r.Get("/v2/search", opdsHandler.SearchBooks2)
+		r.Get("/v2/books/new", opdsHandler.NewBooks2)
+	}
+}
+
+// registerCatalogRoutes adds the Atom navigation and acquisition feeds
+func registerCatalogRoutes(r chi.Router, opdsHandler *opds.Handler) {
+	// Root catalog
+	r.Get("/", opdsHandler.Root)
+
+	// Search
+	r.Get("/search", opdsHandler.SearchBooks)
+	r.Get("/opensearch.xml", opdsHandler.OpenSearch)
+
+	// Navigation catalogs
+	r.Get("/authors", opdsHandler.Authors)
+	r.Get("/authors/top", opdsHandler.TopAuthors)
+	r.Get("/series", opdsHandler.Series)
+	r.Get("/genres", opdsHandler.Genres)
+	r.Get("/tags", opdsHandler.Tags)
+	r.Get("/years", opdsHandler.Years)
+	r.Get("/years/decade/{decade}", opdsHandler.YearsOfDecade)
+	r.Get("/years/decade/{decade}/books", opdsHandler.BooksOfDecade)
+
+	// Books
+	r.Get("/books/new", opdsHandler.NewBooks)
+	r.Get("/all", opdsHandler.AllBooks)
+	r.Get("/books/{id}", opdsHandler.BookEntry)
+	r.Get("/featured", opdsHandler.FeaturedBooks)
+	r.Get("/sections/{id}", opdsHandler.Section)
+	r.Get("/saved", opdsHandler.SavedSearches)
+	r.Get("/saved/{id}", opdsHandler.SavedSearchBooks)
+	r.Get("/series/first", opdsHandler.FirstInSeries)
+	r.Get("/authors/{id}", opdsHandler.BooksByAuthor)
+	r.Get("/authors/{id}/series", opdsHandler.AuthorSeries)
+	r.Get("/authors/{id}/series/{seriesID}", opdsHandler.AuthorBooksInSeries)
+	r.Get("/authors/{id}/alphabet", opdsHandler.AuthorBooksAlphabetical)
+	r.Get("/authors/{id}/new", opdsHandler.AuthorBooksByDate)
+	r.Get("/authors/{id}/noseries", opdsHandler.AuthorBooksWithoutSeries)
+	r.Get("/series/{id}", opdsHandler.BooksBySeries)
+	r.Get("/genres/{id}", opdsHandler.BooksByGenre)
+	r.Get("/tags/{id}", opdsHandler.BooksByTag)
+	r.Get("/years/{year}", opdsHandler.BooksByYear)
+}
+
+// NewOPDSRouter returns the OPDS catalog under /opds without authentication.
+// It is used to validate feeds in-process.
+func NewOPDSRouter(opdsHandler *opds.Handler) http.Handler {
+	r := chi.NewRouter()
 	r.Route("/opds", func(r chi.Router) {
-		// Apply BasicAuth middleware for OPDS clients (e-readers)
-		r.Use(authMw.RequireBasicAuth)
-
-		// Root catalog
-		r.Get("/", opdsHandler.Root)
-
-		// Search
-		r.Get("/search", opdsHandler.SearchBooks)
-		r.Get("/opensearch.xml", opdsHandler.OpenSearch)
-
-		// Navigation catalogs
-		r.Get("/authors", opdsHandler.Authors)
-		r.Get("/series", opdsHandler.Series)
-		r.Get("/genres", opdsHandler.Genres)
-
-		// Books
-		r.Get("/books/new", opdsHandler.NewBooks)
-		r.Get("/authors/{id}", opdsHandler.BooksByAuthor)
-		r.Get("/series/{id}", opdsHandler.BooksBySeries)
-		r.Get("/genres/{id}", opdsHandler.BooksByGenre)
+		registerOPDSRoutes(r, opdsHandler)
 	})
+	return r
+}
+
+// UpdateOPDSAuthDocument advertises the OPDS Authentication Document of
+// opdsHandler in Basic Auth challenges; call it again after the base URL
+// changes.
+func UpdateOPDSAuthDocument(opdsHandler *opds.Handler, authMw *auth.Middleware) {
+	doc, err := opdsHandler.AuthDocumentJSON()
+	if err != nil {
+		log.Printf("UpdateOPDSAuthDocument: failed to build authentication document: %v", err)
+		return
+	}
+	authMw.SetAuthDocument(opdsHandler.AuthDocumentURL(), doc)
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/opds"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestOPDSCatalogValidates crawls the whole catalog in-process and expects
+// no OPDS 1.2 violations.
+func TestOPDSCatalogValidates(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := storage.NewRepository(db)
+	var books []inpx.Book
+	for i := 0; i < 45; i++ {
+		books = append(books, inpx.Book{
+			ID:       "v-" + string(rune('a'+i/26)) + string(rune('a'+i%26)),
+			Title:    "Книга",
+			Authors:  []string{"Автор Один"},
+			Series:   "Серия",
+			Genre:    "prose",
+			Year:     2000,
+			Language: "ru",
+			Format:   "fb2",
+			FileSize: 100,
+			Date:     time.Now(),
+		})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	tag, err := repo.CreateTag("Подборка")
+	if err != nil {
+		t.Fatalf("failed to create tag: %v", err)
+	}
+	if err := repo.AddBookTag(books[0].ID, tag.ID); err != nil {
+		t.Fatalf("failed to tag book: %v", err)
+	}
+
+	handler := opds.NewHandler(repo, "http://library.test", "Test", nil)
+	validator := opds.NewValidator(opds.HandlerClient(NewOPDSRouter(handler)), 0)
+
+	report, err := validator.Validate(context.Background(), "http://library.test/opds")
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	for _, v := range report.Violations {
+		t.Errorf("%s [%s] %s: %s", v.Severity, v.Rule, v.URL, v.Message)
+	}
+	if report.Pages < 10 {
+		t.Errorf("expected the crawl to reach the whole catalog, visited %d pages", report.Pages)
+	}
+}
+
+// TestValidateOPDS_Endpoint checks the admin endpoint returns a JSON report
+// and 503 when validation is not configured.
+func TestValidateOPDS_Endpoint(t *testing.T) {
+	h := setupTestHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.ValidateOPDS(w, httptest.NewRequest("GET", "/api/v1/admin/opds/validate", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before configuration, got %d", w.Code)
+	}
+
+	h.SetOPDSValidation(opds.NewHandler(h.repo, "http://library.test", "Test", nil))
+	w = httptest.NewRecorder()
+	h.ValidateOPDS(w, httptest.NewRequest("GET", "/api/v1/admin/opds/validate?max_pages=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var report opds.ValidationReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.StartURL != "http://library.test/opds" || report.Pages != 5 || !report.Truncated {
+		t.Errorf("unexpected report: start %s, %d pages, truncated=%t", report.StartURL, report.Pages, report.Truncated)
+	}
+	if report.Errors != 0 {
+		t.Errorf("expected no errors, got %+v", report.Violations)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"archive/zip"
+	"io"
+	"time"
+)
+
+// packagingZip asks DownloadBook for the book wrapped in a single-entry ZIP,
+// the .fb2.zip many readers prefer
+const packagingZip = "zip"
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// zippedContentType returns the MIME type of a book of format packed in a ZIP
+func zippedContentType(format string) string {
+	if format == "fb2" {
+		return "application/fb2+zip"
+	}
+	return "application/zip"
+}
+
+// writeZipped streams src to w as a ZIP holding a single entry name. It
+// returns the number of bytes written to w.
+func writeZipped(w io.Writer, name string, modified time.Time, src io.Reader) (int64, error) {
+	cw := &countingWriter{w: w}
+	zw := zip.NewWriter(cw)
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	if _, err := io.Copy(entry, src); err != nil {
+		return cw.n, err
+	}
+	err = zw.Close()
+	return cw.n, err
+}
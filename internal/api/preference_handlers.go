@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// preferredFormat is the value of the format parameter of DownloadBook
+// that asks for the format the user or client prefers
+const preferredFormat = "preferred"
+
+// formatPreference returns the download formats preferred by the user of a
+// request and by the client using its API token. Errors are logged and
+// leave the preference empty.
+func (h *Handlers) formatPreference(r *http.Request) storage.FormatPreference {
+	tokenID := ""
+	if apiToken := auth.TokenFromContext(r.Context()); apiToken != nil {
+		tokenID = apiToken.ID
+	}
+	pref, err := h.repo.GetFormatPreference(auth.UserIDFromContext(r.Context()), tokenID)
+	if err != nil {
+		log.Printf("formatPreference: %v", err)
+	}
+	return pref
+}
+
+// preferredDownload returns the format a book stored as format is
+// downloaded in with format=preferred, and its packaging: the preferred
+// format if the book is in it or converts to it, else the stored format
+func (h *Handlers) preferredDownload(r *http.Request, format, packaging string) (string, string) {
+	preferred := h.formatPreference(r).Format()
+	target, zipped := strings.CutSuffix(preferred, ".zip")
+	if target == "" || (target != format && !h.canConvert(format, target)) {
+		return "", packaging
+	}
+	if zipped {
+		packaging = packagingZip
+	}
+	return target, packaging
+}
+
+// tokenOfUser reports whether id is an API token of the user
+func (h *Handlers) tokenOfUser(userID, id string) (bool, error) {
+	tokens, err := h.repo.ListAPITokens(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tokens {
+		if t.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// preferenceTokenID returns the API token whose preference a request reads
+// or sets: the token_id given, which must be a token of the user, or the
+// token the request is authenticated with. A request with an API token
+// only reaches its own preference. It writes the error response and
+// returns false if the token is not allowed.
+func (h *Handlers) preferenceTokenID(w http.ResponseWriter, r *http.Request, tokenID string) (string, bool) {
+	if apiToken := auth.TokenFromContext(r.Context()); apiToken != nil {
+		if tokenID != "" && tokenID != apiToken.ID {
+			writeError(w, http.StatusForbidden, codeForbidden, "An API token can only change its own preference")
+			return "", false
+		}
+		return apiToken.ID, true
+	}
+	if tokenID == "" {
+		return "", true
+	}
+	ok, err := h.tokenOfUser(auth.UserIDFromContext(r.Context()), tokenID)
+	if err != nil {
+		log.Printf("preferenceTokenID: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return "", false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "API token not found")
+		return "", false
+	}
+	return tokenID, true
+}
+
+// writeFormatPreference answers with the preferred download formats of the
+// user and a token
+func (h *Handlers) writeFormatPreference(w http.ResponseWriter, r *http.Request, tokenID, name string) {
+	pref, err := h.repo.GetFormatPreference(auth.UserIDFromContext(r.Context()), tokenID)
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"format": pref.Format(),
+		"user":   pref.User,
+		"token":  pref.Token,
+	}); err != nil {
+		log.Printf("%s: failed to encode response: %v", name, err)
+	}
+}
+
+// GetFormatPreference returns the download format the current user prefers
+// ("user"), the one preferred by the client of an API token ("token"), and
+// the one in effect ("format"). The token is the one of the request or,
+// for sessions, ?token_id=.
+// GET /api/v1/preferences/format
+func (h *Handlers) GetFormatPreference(w http.ResponseWriter, r *http.Request) {
+	tokenID, ok := h.preferenceTokenID(w, r, r.URL.Query().Get("token_id"))
+	if !ok {
+		return
+	}
+	h.writeFormatPreference(w, r, tokenID, "GetFormatPreference")
+}
+
+// SetFormatPreference sets the download format listed first in OPDS
+// acquisition links and used by /download/{id}?format=preferred, from
+// {"format": "epub"}; "fb2.zip" prefers zipped files and "" removes the
+// preference. It is stored for the user or, with "token_id" or when the
+// request uses an API token, for the client of that token.
+// PUT /api/v1/preferences/format
+func (h *Handlers) SetFormatPreference(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Format  string `json:"format"`
+		TokenID string `json:"token_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	format, valid := storage.NormalizeFormat(req.Format)
+	if format != "" && !valid {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "format must be a file extension such as epub or fb2.zip")
+		return
+	}
+	tokenID, ok := h.preferenceTokenID(w, r, req.TokenID)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.SetFormatPreference(auth.UserIDFromContext(r.Context()), tokenID, format); err != nil {
+		log.Printf("SetFormatPreference: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	h.writeFormatPreference(w, r, tokenID, "SetFormatPreference")
+}
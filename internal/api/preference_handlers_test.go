@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFormatPreference sets the preferred download format of a user with a
+// session and of a client with its API token, which overrides the user's.
+func TestFormatPreference(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	serve := func(method, path, body string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		prepare(req)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	withSession := func(req *http.Request) { req.AddCookie(cookie) }
+	decode := func(w *httptest.ResponseRecorder) map[string]string {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var pref map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &pref); err != nil {
+			t.Fatalf("failed to decode preference: %v", err)
+		}
+		return pref
+	}
+
+	if w := serve("PUT", "/api/v1/preferences/format", `{"format":"e/pub"}`, withSession); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid format: expected 400, got %d", w.Code)
+	}
+	if pref := decode(serve("PUT", "/api/v1/preferences/format", `{"format":" .EPUB"}`, withSession)); pref["user"] != "epub" || pref["format"] != "epub" {
+		t.Errorf("unexpected user preference %v", pref)
+	}
+
+	w := serve("POST", "/api/v1/auth/tokens", `{"name":"reader","scopes":["read","download"]}`, withSession)
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode token: %v", err)
+	}
+	withToken := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+created.Token) }
+
+	if pref := decode(serve("GET", "/api/v1/preferences/format", "", withToken)); pref["format"] != "epub" || pref["token"] != "" {
+		t.Errorf("expected the user preference for the token, got %v", pref)
+	}
+	if pref := decode(serve("PUT", "/api/v1/preferences/format", `{"format":"fb2.zip"}`, withToken)); pref["token"] != "fb2.zip" || pref["format"] != "fb2.zip" {
+		t.Errorf("unexpected token preference %v", pref)
+	}
+	if pref := decode(serve("GET", "/api/v1/preferences/format?token_id="+created.ID, "", withSession)); pref["user"] != "epub" || pref["token"] != "fb2.zip" {
+		t.Errorf("unexpected preferences of the token %v", pref)
+	}
+	if pref := decode(serve("GET", "/api/v1/preferences/format", "", withSession)); pref["format"] != "epub" {
+		t.Errorf("expected the session to keep the user preference, got %v", pref)
+	}
+
+	if w := serve("PUT", "/api/v1/preferences/format", `{"format":"pdf","token_id":"other"}`, withToken); w.Code != http.StatusForbidden {
+		t.Errorf("another token with a token: expected 403, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/v1/preferences/format?token_id=missing", "", withSession); w.Code != http.StatusNotFound {
+		t.Errorf("unknown token: expected 404, got %d", w.Code)
+	}
+	if pref := decode(serve("PUT", "/api/v1/preferences/format", `{"format":"","token_id":"`+created.ID+`"}`, withSession)); pref["token"] != "" || pref["format"] != "epub" {
+		t.Errorf("expected the token preference removed, got %v", pref)
+	}
+}
+
+// TestDownloadBook_Preferred downloads a book with format=preferred, which
+// zips it when fb2.zip is preferred and serves it as stored when it does
+// not convert to the preferred format.
+func TestDownloadBook_Preferred(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+
+	download := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001?format=preferred", nil), "test-001"))
+		return w
+	}
+
+	for format, want := range map[string]string{
+		"":        "application/x-fictionbook+xml",
+		"fb2.zip": "application/fb2+zip",
+		"epub":    "application/x-fictionbook+xml",
+	} {
+		if err := h.repo.SetFormatPreference("", "", format); err != nil {
+			t.Fatalf("SetFormatPreference failed: %v", err)
+		}
+		w := download()
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d: %s", format, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != want {
+			t.Errorf("%q: expected Content-Type %q, got %q", format, want, ct)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// importRoutes are the admin routes that import books. Imports recount the
+// navigation lists themselves when they finish.
+var importRoutes = map[string]bool{
+	"/api/v1/admin/reindex":       true,
+	"/api/v1/admin/reindex/start": true,
+	"/api/v1/import":              true,
+}
+
+// invalidateQueryCache drops cached catalog queries after admin requests
+// that may have changed the catalog, so that edits show up at once, and
+// recounts the navigation lists in the background
+func (h *Handlers) invalidateQueryCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.repo.InvalidateQueryCache()
+			if rctx := chi.RouteContext(r.Context()); rctx == nil || !importRoutes[rctx.RoutePattern()] {
+				h.refreshNavigationStats()
+			}
+		}
+	})
+}
+
+// refreshNavigationStats recounts the navigation lists off the request. The
+// previous counts are served until it is done; edits made meanwhile are
+// counted by one more run.
+func (h *Handlers) refreshNavigationStats() {
+	h.navStatsPending.Store(true)
+	if !h.navStatsRunning.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		for {
+			for h.navStatsPending.Swap(false) {
+				if _, err := h.repo.RefreshNavigationStats(); err != nil {
+					log.Printf("Navigation stats: %v", err)
+				}
+			}
+			h.navStatsRunning.Store(false)
+			// An edit between the last check and the store must not be lost
+			if !h.navStatsPending.Load() || !h.navStatsRunning.CompareAndSwap(false, true) {
+				return
+			}
+		}
+	}()
+}
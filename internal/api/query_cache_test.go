@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// TestInvalidateQueryCache_NavigationStats verifies admin edits recount the
+// navigation lists in the background while imports, which recount them
+// when done, leave the counts alone.
+func TestInvalidateQueryCache_NavigationStats(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	if _, err := h.repo.RefreshNavigationStats(); err != nil {
+		t.Fatalf("RefreshNavigationStats failed: %v", err)
+	}
+	authors := func() int {
+		t.Helper()
+		stats, err := h.repo.GetNavigationStats()
+		if err != nil || stats == nil {
+			t.Fatalf("expected navigation stats, got %v, %v", stats, err)
+		}
+		return stats.Authors
+	}
+	before := authors()
+	if err := h.repo.InsertBooks([]inpx.Book{{ID: "nav-1", Title: "Новая", Authors: []string{"Новый Автор"}, Format: "fb2", Date: time.Now()}}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	serve := func(method, path, body string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("POST", "/api/v1/import", "")
+	if h.navStatsRunning.Load() || authors() != before {
+		t.Errorf("expected an import request to leave the counts to the import")
+	}
+
+	serve("POST", "/api/v1/admin/tags", `{"name":"Новинки"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for h.navStatsRunning.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := authors(); got != before+1 {
+		t.Errorf("expected %d authors after the recount, got %d", before+1, got)
+	}
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/auth"
+)
+
+// downloadQuota limits the daily downloads of each user, or of each IP
+// address for anonymous visitors. Usage is kept in memory and starts over
+// at local midnight and on restart.
+type downloadQuota struct {
+	maxCount int   // books per day, 0 for no limit
+	maxBytes int64 // bytes per day, 0 for no limit
+
+	mu    sync.Mutex
+	day   string
+	usage map[string]*quotaUsage
+}
+
+// quotaUsage is what a user or an address has downloaded today
+type quotaUsage struct {
+	count int
+	bytes int64
+}
+
+// quotaStatus is the download quota of a visitor as shown by the API.
+// Remaining values are left out for limits that are not set.
+type quotaStatus struct {
+	MaxDownloads       int       `json:"max_downloads"`
+	Downloads          int       `json:"downloads"`
+	RemainingDownloads *int      `json:"remaining_downloads,omitempty"`
+	MaxBytes           int64     `json:"max_bytes"`
+	Bytes              int64     `json:"bytes"`
+	RemainingBytes     *int64    `json:"remaining_bytes,omitempty"`
+	ResetsAt           time.Time `json:"resets_at"`
+}
+
+// SetDownloadQuota limits the downloads of each user, or of each IP address
+// for anonymous visitors, to maxCount books and maxBytes bytes a day; zero
+// leaves a limit unset. Admins are not limited. It must be called before
+// requests are served.
+func (h *Handlers) SetDownloadQuota(maxCount int, maxBytes int64) {
+	if maxCount <= 0 && maxBytes <= 0 {
+		h.quota = nil
+		return
+	}
+	h.quota = &downloadQuota{maxCount: max(maxCount, 0), maxBytes: max(maxBytes, 0)}
+}
+
+// SetTrustedProxies makes anonymous download quotas go by the client
+// address in X-Forwarded-For or X-Real-IP for requests from these proxies,
+// a comma-separated list of addresses and CIDR ranges. Other requests are
+// counted by the address they come from, as any client can send those
+// headers. It must be called before requests are served.
+func (h *Handlers) SetTrustedProxies(list string) error {
+	var proxies []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("invalid proxy range %q: %w", item, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return fmt.Errorf("invalid proxy address %q: %w", item, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	h.trustedProxies = proxies
+	return nil
+}
+
+// socketAddrKey keeps the address a request came from in its context
+type socketAddrKey struct{}
+
+// keepSocketAddr remembers the address a request came from before
+// middleware.RealIP replaces it with one from the request headers
+func keepSocketAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), socketAddrKey{}, r.RemoteAddr)))
+	})
+}
+
+// quotaKey names whose quota a request uses: its user or, for anonymous
+// visitors, its address. Admins have none.
+func (h *Handlers) quotaKey(r *http.Request) string {
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		if user.IsAdmin {
+			return ""
+		}
+		return "user:" + user.ID
+	}
+	return "ip:" + h.quotaIP(r)
+}
+
+// quotaIP returns the address an anonymous visitor is counted by: the one
+// the request came from or, behind a trusted proxy, the client address it
+// reported
+func (h *Handlers) quotaIP(r *http.Request) string {
+	socket, _ := r.Context().Value(socketAddrKey{}).(string)
+	if socket == "" {
+		socket = r.RemoteAddr
+	}
+	host := socket
+	if splitHost, _, err := net.SplitHostPort(socket); err == nil {
+		host = splitHost
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		for _, proxy := range h.trustedProxies {
+			if proxy.Contains(addr.Unmap()) {
+				return clientIP(r)
+			}
+		}
+	}
+	return host
+}
+
+// startDay forgets the usage of previous days. q.mu must be held.
+func (q *downloadQuota) startDay(now time.Time) {
+	if day := now.Format("2006-01-02"); day != q.day {
+		q.day = day
+		q.usage = make(map[string]*quotaUsage)
+	}
+}
+
+// current returns the usage of key today. q.mu must be held.
+func (q *downloadQuota) current(key string, now time.Time) *quotaUsage {
+	q.startDay(now)
+	usage := q.usage[key]
+	if usage == nil {
+		usage = &quotaUsage{}
+		q.usage[key] = usage
+	}
+	return usage
+}
+
+// reserve counts a download of key unless the quota is used up, which it
+// reports as false
+func (q *downloadQuota) reserve(key string) (quotaStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	usage := q.current(key, now)
+	if (q.maxCount > 0 && usage.count >= q.maxCount) || (q.maxBytes > 0 && usage.bytes >= q.maxBytes) {
+		return q.statusOf(usage, now), false
+	}
+	usage.count++
+	return q.statusOf(usage, now), true
+}
+
+// release gives back a download of key counted by reserve but not served
+func (q *downloadQuota) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if usage := q.current(key, time.Now()); usage.count > 0 {
+		usage.count--
+	}
+}
+
+// addBytes adds the size of a served download to the usage of key
+func (q *downloadQuota) addBytes(key string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.current(key, time.Now()).bytes += n
+}
+
+// status returns the quota of key without starting to track it
+func (q *downloadQuota) status(key string) quotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.startDay(now)
+	usage := q.usage[key]
+	if usage == nil {
+		usage = &quotaUsage{}
+	}
+	return q.statusOf(usage, now)
+}
+
+// statusOf describes usage against the limits
+func (q *downloadQuota) statusOf(usage *quotaUsage, now time.Time) quotaStatus {
+	status := quotaStatus{
+		MaxDownloads: q.maxCount,
+		Downloads:    usage.count,
+		MaxBytes:     q.maxBytes,
+		Bytes:        usage.bytes,
+		ResetsAt:     time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()),
+	}
+	if q.maxCount > 0 {
+		remaining := max(q.maxCount-usage.count, 0)
+		status.RemainingDownloads = &remaining
+	}
+	if q.maxBytes > 0 {
+		remaining := max(q.maxBytes-usage.bytes, 0)
+		status.RemainingBytes = &remaining
+	}
+	return status
+}
+
+// reserveDownload counts a download against the quota of the request and
+// writes 429 if the quota is used up, returning false then
+func (h *Handlers) reserveDownload(w http.ResponseWriter, r *http.Request) bool {
+	if h.quota == nil {
+		return true
+	}
+	key := h.quotaKey(r)
+	if key == "" {
+		return true
+	}
+	status, ok := h.quota.reserve(key)
+	if ok {
+		return true
+	}
+
+	log.Printf("Download: quota of %s exceeded (%d books, %d bytes today)", key, status.Downloads, status.Bytes)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(status.ResetsAt).Seconds())+1))
+	limit := fmt.Sprintf("%d books", status.MaxDownloads)
+	if status.RemainingDownloads == nil || *status.RemainingDownloads > 0 {
+		limit = fmt.Sprintf("%d MB", status.MaxBytes>>20)
+	}
+	writeError(w, http.StatusTooManyRequests, codeQuotaExceeded,
+		fmt.Sprintf("Daily download quota of %s is used up; it resets at %s", limit, status.ResetsAt.Format(time.RFC3339)))
+	return false
+}
+
+// releaseDownload gives back the download counted by reserveDownload for a
+// request answered without the book
+func (h *Handlers) releaseDownload(r *http.Request) {
+	if h.quota == nil {
+		return
+	}
+	if key := h.quotaKey(r); key != "" {
+		h.quota.release(key)
+	}
+}
+
+// GetVisitor returns the current visitor, signed in or not, and their
+// download quota, which is null when their downloads are not limited.
+// GET /api/v1/me
+func (h *Handlers) GetVisitor(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{"authenticated": false, "download_quota": nil}
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		response["authenticated"] = true
+		response["username"] = user.Username
+	}
+	if h.quota != nil {
+		if key := h.quotaKey(r); key != "" {
+			response["download_quota"] = h.quota.status(key)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("GetVisitor: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import "net/http"
+
+// readOnlyAllowed lists the non-GET endpoints that do not write to the
+// database and keep working in read-only mode
+var readOnlyAllowed = map[string]bool{
+	"/api/v1/tts/speech": true,
+	// The conversion cache is local to each instance
+	"/api/v1/admin/cache": true,
+	// So are the HTTP statistics
+	"/api/v1/stats/http": true,
+}
+
+// rejectWrites answers 503 to requests that would change the library, user
+// data or sessions when the database is read-only. Another instance with
+// write access handles them.
+func (h *Handlers) rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.repo.ReadOnly() || readOnlyAllowed[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			writeError(w, http.StatusServiceUnavailable, codeReadOnly, "This server is read-only; changes are made on the primary instance")
+		}
+	})
+}
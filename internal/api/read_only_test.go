@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestReadOnlyMode verifies a read-only instance serves the library and
+// rejects changes with read_only.
+func TestReadOnlyMode(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	writer, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	book := inpx.Book{ID: "ro-1", Title: "Read Only Book", Authors: []string{"Author"}, Genre: "fiction",
+		Language: "ru", Format: "fb2", ArchivePath: "archive", FileNum: "1", Date: time.Now()}
+	if err := storage.NewRepository(writer).InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to insert book: %v", err)
+	}
+	writer.Close()
+
+	db, err := storage.NewReadOnlyDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("NewReadOnlyDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := storage.NewRepository(db)
+	router := SetupRoutes(NewHandlers(repo, t.TempDir(), "", auth.NewMiddleware(repo, false)))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/books?q=Read", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ro-1") {
+		t.Errorf("search: expected the book, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/admin/tags", strings.NewReader(`{"name":"new"}`)))
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if w.Code != http.StatusServiceUnavailable || resp.Error.Code != codeReadOnly {
+		t.Errorf("create tag: expected 503 read_only, got %d %s", w.Code, resp.Error.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if !strings.Contains(w.Body.String(), `"read_only":true`) {
+		t.Errorf("health: expected read_only, got %s", w.Body.String())
+	}
+
+	// Writes that bypass the HTTP guard still fail in SQLite
+	if _, err := repo.CreateTag("direct"); err == nil {
+		t.Error("expected a write to a read-only database to fail")
+	}
+}
@@ -8,8 +8,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -22,20 +20,9 @@ import (
 // openBookFromArchive locates and opens the FB2 file for a given book.
 // Returns the opened reader, a cleanup function, and any error.
 func (h *Handlers) openBookFromArchive(book *storage.Book) (io.ReadCloser, func(), error) {
-	archiveName := book.ArchivePath
-	if archiveName == "" {
-		return nil, nil, fmt.Errorf("book archive path is empty")
-	}
-	if !strings.HasSuffix(strings.ToLower(archiveName), ".zip") {
-		archiveName += ".zip"
-	}
-	archivePath := filepath.Join(h.booksDir, archiveName)
-
-	// Path traversal check
-	cleanArchivePath := filepath.Clean(archivePath)
-	cleanBooksDir := filepath.Clean(h.booksDir)
-	if !strings.HasPrefix(cleanArchivePath, cleanBooksDir+string(os.PathSeparator)) && cleanArchivePath != cleanBooksDir {
-		return nil, nil, fmt.Errorf("invalid archive path")
+	archivePath, err := h.bookArchivePath(book)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	archive, err := zip.OpenReader(archivePath)
@@ -43,33 +30,10 @@ func (h *Handlers) openBookFromArchive(book *storage.Book) (io.ReadCloser, func(
 		return nil, nil, fmt.Errorf("open archive %s: %w", archivePath, err)
 	}
 
-	format := strings.ToLower(book.Format)
-	if format == "" {
-		format = "fb2"
-	}
-	expectedFileName := book.ID + "." + format
-
-	// Also try zero-padded filename (e.g., "000024.fb2" for book ID "24")
-	var paddedFileName string
-	if _, err := fmt.Sscanf(book.ID, "%d", new(int)); err == nil {
-		paddedFileName = fmt.Sprintf("%06s", book.ID) + "." + format
-	}
-
-	var bookFile *zip.File
-	for _, file := range archive.File {
-		if strings.EqualFold(file.Name, expectedFileName) {
-			bookFile = file
-			break
-		}
-		if paddedFileName != "" && strings.EqualFold(file.Name, paddedFileName) {
-			bookFile = file
-			break
-		}
-	}
-
-	if bookFile == nil {
+	bookFile, err := findBookFile(&archive.Reader, book)
+	if err != nil {
 		archive.Close()
-		return nil, nil, fmt.Errorf("file %s not found in archive", expectedFileName)
+		return nil, nil, err
 	}
 
 	rc, err := bookFile.Open()
@@ -86,6 +50,55 @@ func (h *Handlers) openBookFromArchive(book *storage.Book) (io.ReadCloser, func(
 	return rc, cleanup, nil
 }
 
+// bookArchivePath returns the path of the ZIP archive holding a book,
+// rejecting paths that escape the books directory (see libraryPath). The
+// archive may be in a subfolder ("fb2-000001-000500/part1"); INPX files made
+// on Windows separate folders with backslashes.
+func (h *Handlers) bookArchivePath(book *storage.Book) (string, error) {
+	return h.archiveFilePath(book.ArchivePath)
+}
+
+// archiveFilePath resolves an archive path as the INPX names it; the .zip
+// extension may be left out
+func (h *Handlers) archiveFilePath(archiveName string) (string, error) {
+	if archiveName == "" {
+		return "", errEmptyArchivePath
+	}
+	if !strings.HasSuffix(strings.ToLower(archiveName), ".zip") {
+		archiveName += ".zip"
+	}
+	return libraryPath(h.booksDir, archiveName)
+}
+
+// findBookFile returns the archive entry for a book.
+func findBookFile(archive *zip.Reader, book *storage.Book) (*zip.File, error) {
+	names := bookFileNames(book.ID, book.Format)
+	for _, file := range archive.File {
+		for _, name := range names {
+			if strings.EqualFold(file.Name, name) {
+				return file, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("file %s not found in archive", names[0])
+}
+
+// bookFileNames returns the names a book's file may have in its archive:
+// the ID with the format as extension, and for numeric IDs also the ID
+// zero-padded to six digits (e.g., "000024.fb2" for book ID "24")
+func bookFileNames(id, format string) []string {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = "fb2"
+	}
+	names := []string{id + "." + format}
+	if _, err := fmt.Sscanf(id, "%d", new(int)); err == nil {
+		names = append(names, fmt.Sprintf("%06s", id)+"."+format)
+	}
+	return names
+}
+
 // parseBookFB2 fetches and parses a book's FB2 content.
 func (h *Handlers) parseBookFB2(book *storage.Book) (*reader.FB2Book, error) {
 	rc, cleanup, err := h.openBookFromArchive(book)
@@ -107,25 +120,28 @@ func (h *Handlers) parseBookFB2(book *storage.Book) (*reader.FB2Book, error) {
 func (h *Handlers) GetBookTOC(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
 		return
 	}
 
 	book, err := h.repo.GetBookByID(bookID)
 	if err != nil {
 		log.Printf("GetBookTOC: book_id=%s database error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	if book == nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		return
+	}
+	if h.booksUnavailable(w) {
 		return
 	}
 
 	fb2Book, err := h.parseBookFB2(book)
 	if err != nil {
 		log.Printf("GetBookTOC: book_id=%s parse error: %v", bookID, err)
-		http.Error(w, "Failed to parse book", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeConversionFailed, "Failed to parse book")
 		return
 	}
 
@@ -150,7 +166,7 @@ func (h *Handlers) GetBookTOC(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) GetBookContent(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
 		return
 	}
 
@@ -159,25 +175,28 @@ func (h *Handlers) GetBookContent(w http.ResponseWriter, r *http.Request) {
 	book, err := h.repo.GetBookByID(bookID)
 	if err != nil {
 		log.Printf("GetBookContent: book_id=%s database error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	if book == nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		return
+	}
+	if h.booksUnavailable(w) {
 		return
 	}
 
 	fb2Book, err := h.parseBookFB2(book)
 	if err != nil {
 		log.Printf("GetBookContent: book_id=%s parse error: %v", bookID, err)
-		http.Error(w, "Failed to parse book", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeConversionFailed, "Failed to parse book")
 		return
 	}
 
 	flat := reader.FlattenSections(fb2Book)
 
 	if sectionIdx < 0 || sectionIdx >= len(flat) {
-		http.Error(w, "Section index out of range", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Section index out of range")
 		return
 	}
 
@@ -209,25 +228,28 @@ func (h *Handlers) GetBookImage(w http.ResponseWriter, r *http.Request) {
 	imageName := chi.URLParam(r, "name")
 
 	if bookID == "" || imageName == "" {
-		http.Error(w, "Book ID and image name are required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID and image name are required")
 		return
 	}
 
 	book, err := h.repo.GetBookByID(bookID)
 	if err != nil {
 		log.Printf("GetBookImage: book_id=%s database error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 	if book == nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		return
+	}
+	if h.booksUnavailable(w) {
 		return
 	}
 
 	fb2Book, err := h.parseBookFB2(book)
 	if err != nil {
 		log.Printf("GetBookImage: book_id=%s parse error: %v", bookID, err)
-		http.Error(w, "Failed to parse book", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeConversionFailed, "Failed to parse book")
 		return
 	}
 
@@ -241,7 +263,7 @@ func (h *Handlers) GetBookImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if found == nil {
-		http.Error(w, "Image not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeNotFound, "Image not found")
 		return
 	}
 
@@ -249,7 +271,7 @@ func (h *Handlers) GetBookImage(w http.ResponseWriter, r *http.Request) {
 	data, err := base64.StdEncoding.DecodeString(found.Data)
 	if err != nil {
 		log.Printf("GetBookImage: book_id=%s image=%s decode error: %v", bookID, imageName, err)
-		http.Error(w, "Failed to decode image", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeConversionFailed, "Failed to decode image")
 		return
 	}
 
@@ -272,7 +294,7 @@ func (h *Handlers) GetBookImage(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) GetReadingPosition(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
 		return
 	}
 
@@ -280,7 +302,7 @@ func (h *Handlers) GetReadingPosition(w http.ResponseWriter, r *http.Request) {
 	pos, err := h.repo.GetReadingPosition(userID, bookID)
 	if err != nil {
 		log.Printf("GetReadingPosition: book_id=%s error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
@@ -303,13 +325,13 @@ func (h *Handlers) GetReadingPosition(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) SaveReadingPosition(w http.ResponseWriter, r *http.Request) {
 	bookID := chi.URLParam(r, "id")
 	if bookID == "" {
-		http.Error(w, "Book ID is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Book ID is required")
 		return
 	}
 
 	var pos storage.ReadingPosition
 	if err := json.NewDecoder(r.Body).Decode(&pos); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
 		return
 	}
 	pos.BookID = bookID
@@ -317,7 +339,7 @@ func (h *Handlers) SaveReadingPosition(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.repo.SaveReadingPosition(&pos); err != nil {
 		log.Printf("SaveReadingPosition: book_id=%s error: %v", bookID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
@@ -338,7 +360,7 @@ func (h *Handlers) GetReadingHistory(w http.ResponseWriter, r *http.Request) {
 	items, total, err := h.repo.GetReadingHistory(userID, status, limit, offset)
 	if err != nil {
 		log.Printf("GetReadingHistory: error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 		return
 	}
 
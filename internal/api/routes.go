@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
 // SetupRoutes configures all API routes
@@ -13,16 +14,19 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
+	r.Use(handlers.httpStats.Middleware)
+	r.Use(handlers.requestLogger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(keepSocketAddr)
 	r.Use(middleware.RealIP)
+	r.Use(handlers.rejectWrites)
 
 	// CORS for SPA
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
 
 			if r.Method == "OPTIONS" {
@@ -41,6 +45,13 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 
 	// API routes
 	r.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Endpoint not found")
+		})
+		r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		})
+
 		// Public auth endpoints
 		r.Get("/auth/info", handlers.GetAuthInfo)
 		r.Post("/auth/login", handlers.Login)
@@ -52,21 +63,92 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 			r.Get("/auth/me", handlers.GetMe)
 		})
 
-		// Public book endpoints (search, details, reader content, images, download)
-		r.Get("/books", handlers.SearchBooks)
-		r.Get("/books/{id}", handlers.GetBookByID)
-		r.Get("/books/{id}/toc", handlers.GetBookTOC)
-		r.Get("/books/{id}/content", handlers.GetBookContent)
-		r.Get("/books/{id}/image/{name}", handlers.GetBookImage)
+		// API tokens of the current user; a token cannot manage tokens
+		r.Group(func(r chi.Router) {
+			r.Use(authMw.RequireAuth)
+			r.Use(authMw.RequireSession)
+			r.Get("/auth/tokens", handlers.ListAPITokens)
+			r.Post("/auth/tokens", handlers.CreateAPIToken)
+			r.Delete("/auth/tokens/{id}", handlers.DeleteAPIToken)
+		})
+
+		// Public book endpoints (search, details, reader content, images, download).
+		// Books of restricted genres and tags are hidden from users without access.
+		r.Group(func(r chi.Router) {
+			r.Use(authMw.OptionalAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
+			r.Get("/me", handlers.GetVisitor)
+			r.Get("/books", handlers.SearchBooks)
+			r.Get("/search/parse", handlers.ParseSearchQuery)
+			r.Get("/facets", handlers.GetFacets)
+			r.Get("/stats/years", handlers.GetYearStats)
+			r.Get("/stats/navigation", handlers.GetNavigationStats)
+			r.Get("/stats/searches", handlers.GetSearchStats)
+			r.Get("/tags", handlers.ListTags)
+			r.Get("/genres", handlers.ListGenres)
+			r.Get("/featured", handlers.ListFeatured)
+			r.Get("/sections", handlers.ListSections)
+			r.Get("/sections/{id}/books", handlers.SectionBooks)
+			r.Get("/authors/top", handlers.ListTopAuthors)
+			r.Get("/authors/{id}", handlers.GetAuthor)
+			r.Get("/authors/{id}/portrait", handlers.GetAuthorPortrait)
+			r.Get("/conversions/{id}", handlers.GetConversion)
+
+			r.Group(func(r chi.Router) {
+				r.Use(handlers.requireBookAccess)
+				r.Get("/books/{id}", handlers.GetBookByID)
+				r.Get("/books/{id}/full", handlers.GetBookDetails)
+				r.Get("/books/{id}/toc", handlers.GetBookTOC)
+				r.Get("/books/{id}/content", handlers.GetBookContent)
+				r.Get("/books/{id}/image/{name}", handlers.GetBookImage)
+				r.Get("/books/{id}/cover", handlers.GetBookCover)
+			})
+		})
 
 		// Reading position and history — require auth when enabled
 		r.Group(func(r chi.Router) {
 			r.Use(authMw.RequireAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
 			r.Get("/books/{id}/position", handlers.GetReadingPosition)
 			r.Put("/books/{id}/position", handlers.SaveReadingPosition)
 			r.Get("/reading-history", handlers.GetReadingHistory)
 		})
 
+		// Shelves (reading lists) of the current user
+		r.Group(func(r chi.Router) {
+			r.Use(authMw.RequireAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
+			r.Get("/shelves", handlers.ListShelves)
+			r.Post("/shelves", handlers.CreateShelf)
+			r.Post("/shelves/import", handlers.ImportShelf)
+			r.Get("/shelves/{id}", handlers.GetShelf)
+			r.Delete("/shelves/{id}", handlers.DeleteShelf)
+			r.Get("/shelves/{id}/export", handlers.ExportShelf)
+			r.Get("/shelves/{id}/share", handlers.GetShelfShare)
+			r.Post("/shelves/{id}/share", handlers.ShareShelf)
+			r.Delete("/shelves/{id}/share", handlers.UnshareShelf)
+			r.Put("/shelves/{id}/books/{bookID}", handlers.AddShelfBook)
+			r.Delete("/shelves/{id}/books/{bookID}", handlers.RemoveShelfBook)
+		})
+
+		// Saved searches of the current user
+		r.Group(func(r chi.Router) {
+			r.Use(authMw.RequireAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
+			r.Get("/saved-searches", handlers.ListSavedSearches)
+			r.Post("/saved-searches", handlers.CreateSavedSearch)
+			r.Get("/saved-searches/{id}/books", handlers.SavedSearchBooks)
+			r.Delete("/saved-searches/{id}", handlers.DeleteSavedSearch)
+		})
+
+		// Download format preferences of the current user and their clients
+		r.Group(func(r chi.Router) {
+			r.Use(authMw.RequireAuth)
+			r.Use(authMw.RequireScope(storage.ScopeRead))
+			r.Get("/preferences/format", handlers.GetFormatPreference)
+			r.Put("/preferences/format", handlers.SetFormatPreference)
+		})
+
 		// TTS proxy endpoints (public — no auth needed)
 		r.Get("/tts/status", handlers.GetTTSStatus)
 		r.Get("/tts/voices", handlers.GetTTSVoices)
@@ -76,11 +158,71 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 		r.Group(func(r chi.Router) {
 			r.Use(authMw.RequireAuth)
 			r.Use(authMw.RequireAdmin)
+			r.Use(authMw.RequireScope(storage.ScopeAdmin))
+			r.Use(handlers.invalidateQueryCache)
 			r.Post("/admin/reindex", handlers.ReindexLibrary)
+			r.Post("/admin/reindex/start", handlers.StartReindex)
+			r.Get("/admin/reindex/status", handlers.GetReindexStatus)
+			r.Post("/import", handlers.ImportINPX)
+			r.Get("/reindex/errors", handlers.ListImportErrors)
+			r.Get("/reindex/genres", handlers.ListUnmappedGenres)
+			r.Get("/reindex/sizes", handlers.ListSizeMismatches)
+			r.Post("/admin/reindex/sizes", handlers.CheckFileSizes)
+			r.Get("/admin/stats", handlers.GetStats)
+			r.Get("/admin/export/inpx", handlers.ExportINPX)
+			r.Get("/admin/opds/validate", handlers.ValidateOPDS)
+			r.Get("/admin/upstreams", handlers.ListUpstreams)
+			r.Post("/admin/upstreams/crawl", handlers.CrawlUpstreams)
+			r.Get("/admin/notifications", handlers.GetNotificationStatus)
+			r.Post("/admin/notifications/run", handlers.RunNotifications)
+			r.Post("/admin/maintenance", handlers.RunMaintenance)
+			r.Post("/admin/covers/start", handlers.StartCovers)
+			r.Get("/admin/covers/status", handlers.GetCoverStatus)
+			r.Get("/admin/cache", handlers.GetCacheStats)
+			r.Delete("/admin/cache", handlers.PurgeCache)
+			r.Get("/admin/conversions", handlers.GetConversionStats)
+			r.Get("/stats/http", handlers.GetHTTPStats)
+			r.Delete("/stats/http", handlers.ResetHTTPStats)
+			r.Get("/admin/authors", handlers.ListAuthors)
+			r.Post("/admin/authors/merge", handlers.MergeAuthors)
+			r.Get("/admin/author-duplicates", handlers.ListAuthorDuplicates)
+			r.Post("/admin/author-duplicates/start", handlers.StartAuthorDuplicates)
+			r.Get("/admin/authors/aliases", handlers.ListAuthorAliases)
+			r.Post("/admin/authors/aliases", handlers.SetAuthorAlias)
+			r.Delete("/admin/authors/aliases/{id}", handlers.DeleteAuthorAlias)
+			r.Post("/admin/authors/split", handlers.SplitAuthor)
+			r.Put("/admin/authors/{id}/ambiguous", handlers.SetAuthorAmbiguous)
+			r.Delete("/admin/authors/{id}/ambiguous", handlers.ClearAuthorAmbiguous)
+			r.Patch("/books/{id}", handlers.UpdateBook)
+			r.Post("/books/{id}/verify", handlers.VerifyBook)
+			r.Get("/sync/changes", handlers.GetSyncChanges)
+			r.Get("/sync/archives", handlers.ListSyncArchives)
+			r.Get("/sync/archives/*", handlers.GetSyncArchive)
+			r.Post("/admin/tags", handlers.CreateTag)
+			r.Put("/admin/tags/{id}", handlers.RenameTag)
+			r.Delete("/admin/tags/{id}", handlers.DeleteTag)
+			r.Put("/admin/books/{id}/tags/{tagID}", handlers.AddBookTag)
+			r.Delete("/admin/books/{id}/tags/{tagID}", handlers.RemoveBookTag)
 			r.Get("/admin/users", handlers.ListUsers)
 			r.Post("/admin/users", handlers.CreateUser)
 			r.Delete("/admin/users/{id}", handlers.DeleteUser)
 			r.Put("/admin/users/{id}/password", handlers.UpdateUserPassword)
+			r.Put("/admin/users/{id}/email", handlers.UpdateUserEmail)
+			r.Put("/admin/users/{id}/roles", handlers.SetUserRoles)
+			r.Get("/admin/downloads", handlers.ListDownloads)
+			r.Get("/admin/access-rules", handlers.ListAccessRules)
+			r.Put("/admin/access-rules/{kind}/{name}", handlers.SetAccessRule)
+			r.Delete("/admin/access-rules/{kind}/{name}", handlers.DeleteAccessRule)
+			r.Get("/admin/hidden", handlers.ListHidden)
+			r.Put("/admin/hidden/books/{id}", handlers.HideBook)
+			r.Delete("/admin/hidden/books/{id}", handlers.UnhideBook)
+			r.Put("/admin/hidden/archives/*", handlers.HideArchive)
+			r.Delete("/admin/hidden/archives/*", handlers.UnhideArchive)
+			r.Get("/admin/archives", handlers.ListArchives)
+			r.Get("/admin/archives/*", handlers.ArchiveEntries)
+			r.Put("/admin/featured", handlers.SetFeatured)
+			r.Put("/admin/featured/{id}", handlers.FeatureBook)
+			r.Delete("/admin/featured/{id}", handlers.UnfeatureBook)
 		})
 	})
 
@@ -88,6 +230,7 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 	r.Group(func(r chi.Router) {
 		r.Use(authMw.RequireAuth)
 		r.Use(authMw.RequireAdmin)
+		r.Use(authMw.RequireScope(storage.ScopeAdmin))
 		r.Post("/admin/reindex", handlers.ReindexLibrary)
 	})
 
@@ -100,8 +243,24 @@ func SetupRoutes(handlers *Handlers) *chi.Mux {
 	fileServer := http.FileServer(http.Dir(staticDir))
 	r.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	// Admin panel SPA; the page itself is static, its API calls require admin role
+	serveAdmin := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(staticDir, "admin", "index.html"))
+	}
+	r.Get("/admin", serveAdmin)
+	r.Get("/admin/*", serveAdmin)
+
 	// Download routes (must be before wildcard route)
-	r.Get("/download/{id}", handlers.DownloadBook)
+	r.With(authMw.OptionalAuth, authMw.RequireScope(storage.ScopeDownload), handlers.requireBookAccess).Get("/download/{id}", handlers.DownloadBook)
+
+	// Cover thumbnails under their content hash, cacheable forever
+	r.With(authMw.OptionalAuth, authMw.RequireScope(storage.ScopeRead), handlers.requireBookAccess).Get("/covers/{id}/{hash}.jpg", handlers.GetCover)
+
+	// Static book pages for sharing and search engines
+	r.With(authMw.OptionalAuth, authMw.RequireScope(storage.ScopeRead), handlers.requireBookAccess).Get("/books/{id}", handlers.BookPage)
+
+	// Catalog without JavaScript for e-ink and other simple browsers
+	r.With(authMw.OptionalAuth, authMw.RequireScope(storage.ScopeRead)).Get("/browse", handlers.BrowsePage)
 
 	// Serve SPA (index.html for all non-API routes)
 	r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
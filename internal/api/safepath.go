@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors of bookArchivePath and libraryPath
+var (
+	errEmptyArchivePath   = errors.New("book archive path is empty")
+	errInvalidArchivePath = errors.New("invalid archive path")
+)
+
+// libraryPath resolves rel, a slash-separated path taken from INPX data or a
+// request, to a file inside the library root. Absolute paths, ".."
+// elements and symlinks leading out of root are rejected with
+// errInvalidArchivePath. A missing file is not an error: opening the
+// returned path reports it.
+func libraryPath(root, rel string) (string, error) {
+	rel = strings.ReplaceAll(rel, `\`, "/")
+	local := filepath.FromSlash(rel)
+	if rel == "" || strings.ContainsRune(rel, 0) || strings.HasPrefix(rel, "/") ||
+		filepath.IsAbs(local) || filepath.VolumeName(local) != "" {
+		return "", errInvalidArchivePath
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == ".." {
+			return "", errInvalidArchivePath
+		}
+	}
+	target := filepath.Join(root, local)
+
+	// The path is inside root by now, but a symlink on it may lead out
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return target, nil
+		}
+		return "", err
+	}
+	if !withinDir(realRoot, realTarget) {
+		return "", errInvalidArchivePath
+	}
+	return realTarget, nil
+}
+
+// withinDir reports whether path is dir or lies below it
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
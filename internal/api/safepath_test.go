@@ -0,0 +1,147 @@
+package api
+
+import (
+	"archive/zip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestLibraryPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "vol1"), filepath.Join(outside, "vol2")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "vol1", "a.zip"), filepath.Join(outside, "secret.zip"), filepath.Join(outside, "vol2", "b.zip")} {
+		if err := os.WriteFile(file, []byte("zip"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+	links := map[string]string{
+		"inside.zip": filepath.Join(root, "vol1", "a.zip"),
+		"escape.zip": filepath.Join(outside, "secret.zip"),
+		"vol2":       filepath.Join(outside, "vol2"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	allowed := map[string]string{
+		"vol1/a.zip":   filepath.Join(root, "vol1", "a.zip"),
+		`vol1\a.zip`:   filepath.Join(root, "vol1", "a.zip"),
+		"./vol1/a.zip": filepath.Join(root, "vol1", "a.zip"),
+		"inside.zip":   filepath.Join(root, "vol1", "a.zip"),
+		"missing.zip":  filepath.Join(root, "missing.zip"),
+	}
+	for rel, want := range allowed {
+		got, err := libraryPath(root, rel)
+		if err != nil {
+			t.Errorf("libraryPath(%q) failed: %v", rel, err)
+			continue
+		}
+		// The temporary directory itself may be behind a symlink
+		if wantReal, err := filepath.EvalSymlinks(want); err == nil {
+			want = wantReal
+		}
+		if got != want {
+			t.Errorf("libraryPath(%q) = %s, want %s", rel, got, want)
+		}
+	}
+
+	rejected := []string{
+		"",
+		"../secret.zip",
+		"../../etc/passwd",
+		`..\..\etc\passwd`,
+		"vol1/../../secret.zip",
+		"vol1/../a.zip",
+		"/etc/passwd",
+		`\etc\passwd`,
+		filepath.Join(outside, "secret.zip"),
+		"vol1/a.zip\x00.txt",
+		"escape.zip",
+		"vol2/b.zip",
+	}
+	for _, rel := range rejected {
+		if got, err := libraryPath(root, rel); !errors.Is(err, errInvalidArchivePath) {
+			t.Errorf("libraryPath(%q) = %s, %v; want errInvalidArchivePath", rel, got, err)
+		}
+	}
+}
+
+// TestDownloadBook_MaliciousINPX imports INPX records whose archive paths
+// point out of the library and checks none of them is served.
+func TestDownloadBook_MaliciousINPX(t *testing.T) {
+	h := setupTestHandlers(t)
+	outside := t.TempDir()
+
+	// A real archive outside the library, reachable through each trick
+	writeTestArchive(t, outside)
+	if err := os.Symlink(filepath.Join(outside, "test-archive.zip"), filepath.Join(h.booksDir, "link.zip")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(h.booksDir, "mnt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	escape, err := filepath.Rel(h.booksDir, filepath.Join(outside, "test-archive"))
+	if err != nil {
+		t.Fatalf("failed to build relative path: %v", err)
+	}
+
+	archives := map[string]string{
+		"relative":  escape,
+		"backslash": strings.ReplaceAll(escape, "/", `\`),
+		"absolute":  filepath.Join(outside, "test-archive"),
+		"nested":    "sub/../../" + escape,
+		"symlink":   "link",
+		"symlinked": "mnt/test-archive",
+	}
+
+	inpxPath := filepath.Join(t.TempDir(), "evil.inpx")
+	f, err := os.Create(inpxPath)
+	if err != nil {
+		t.Fatalf("failed to create INPX: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("evil.inp")
+	if err != nil {
+		t.Fatalf("failed to create INP entry: %v", err)
+	}
+	for id, archive := range archives {
+		// All records name the same file inside the archive
+		line := "Автор:\x04prose:\x04" + id + "\x04\x04\x04test-001\x04100\x04" + archive + "\x04\x04fb2\x042024-01-01\x04ru\x040\x04\n"
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write INP entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close INPX: %v", err)
+	}
+	f.Close()
+
+	books, _, err := inpx.NewParser().ParseINPX(inpxPath)
+	if err != nil {
+		t.Fatalf("failed to parse INPX: %v", err)
+	}
+	for _, book := range books {
+		if err := h.repo.InsertBooks([]inpx.Book{book}); err != nil {
+			t.Fatalf("failed to insert book: %v", err)
+		}
+		w := httptest.NewRecorder()
+		h.DownloadBook(w, withBookID(httptest.NewRequest("GET", "/download/test-001", nil), "test-001"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s archive %q: got %d, want 400", book.Title, book.ArchivePath, w.Code)
+		}
+	}
+}
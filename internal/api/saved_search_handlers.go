@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// userSavedSearch returns the saved search named in the URL if it belongs
+// to the current user. Otherwise it writes a 404 and returns nil.
+func (h *Handlers) userSavedSearch(w http.ResponseWriter, r *http.Request, op string) *storage.SavedSearch {
+	search, err := h.repo.GetSavedSearch(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("%s: %v", op, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return nil
+	}
+	if search == nil || search.UserID != auth.UserIDFromContext(r.Context()) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Saved search not found")
+		return nil
+	}
+	return search
+}
+
+// ListSavedSearches returns the saved searches of the current user. Those
+// with notify set carry the number of books added or changed since they
+// were last run in new_books.
+// GET /api/v1/saved-searches
+func (h *Handlers) ListSavedSearches(w http.ResponseWriter, r *http.Request) {
+	searches, err := h.repo.ListSavedSearches(auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("ListSavedSearches: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	hidden, err := h.restrictions(r)
+	if err == nil {
+		err = h.repo.CountNewBooks(searches, hidden)
+	}
+	if err != nil {
+		log.Printf("ListSavedSearches: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"searches": searches}); err != nil {
+		log.Printf("ListSavedSearches: failed to encode response: %v", err)
+	}
+}
+
+// CreateSavedSearch saves a book filter under a name from
+// {"name": "...", "filter": {...}, "notify": true, "email": "..."}. The
+// filter takes the fields of storage.BookFilter, as in "genres" or
+// "year_from"; the email receives the new books of a search with notify
+// and must be the address an admin set for the account.
+// POST /api/v1/saved-searches
+func (h *Handlers) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string             `json:"name"`
+		Filter storage.BookFilter `json:"filter"`
+		Notify bool               `json:"notify"`
+		Email  string             `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "name is required")
+		return
+	}
+	if !storage.IsValidSortField(req.Filter.SortBy) {
+		writeError(w, http.StatusBadRequest, codeInvalidFilter, fmt.Sprintf("Invalid sort_by, expected one of: %s", strings.Join(storage.SortFields, ", ")))
+		return
+	}
+
+	if req.Email = strings.TrimSpace(req.Email); req.Email != "" {
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid email address")
+			return
+		}
+		req.Email = addr.Address
+		// Mail only goes to the account's own address, not to anyone's
+		if user := auth.UserFromContext(r.Context()); user == nil || !strings.EqualFold(req.Email, user.Email) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Email must be the address of your account")
+			return
+		}
+	}
+
+	search, err := h.repo.CreateSavedSearch(storage.SavedSearch{
+		UserID: auth.UserIDFromContext(r.Context()),
+		Name:   req.Name,
+		Filter: req.Filter,
+		Notify: req.Notify,
+		Email:  req.Email,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+		log.Printf("CreateSavedSearch: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(search); err != nil {
+		log.Printf("CreateSavedSearch: failed to encode response: %v", err)
+	}
+}
+
+// SavedSearchBooks runs a saved search and returns a page of its books.
+// Running it from the first page resets its new books.
+// GET /api/v1/saved-searches/{id}/books?limit=30&offset=0
+func (h *Handlers) SavedSearchBooks(w http.ResponseWriter, r *http.Request) {
+	search := h.userSavedSearch(w, r, "SavedSearchBooks")
+	if search == nil {
+		return
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("SavedSearchBooks: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	filter := search.Filter
+	filter.Limit = limit
+	filter.Offset = parseInt(query.Get("offset"), 0)
+	filter.Hidden = hidden
+	filter.WithAnnotations = true
+
+	result, err := h.repo.SearchBooks(filter)
+	if err != nil {
+		log.Printf("SavedSearchBooks: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if filter.Offset <= 0 {
+		if err := h.repo.MarkSavedSearchSeen(search); err != nil {
+			log.Printf("SavedSearchBooks: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("SavedSearchBooks: failed to encode response: %v", err)
+	}
+}
+
+// DeleteSavedSearch deletes a saved search of the current user.
+// DELETE /api/v1/saved-searches/{id}
+func (h *Handlers) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	search := h.userSavedSearch(w, r, "DeleteSavedSearch")
+	if search == nil {
+		return
+	}
+	if _, err := h.repo.DeleteSavedSearch(search.ID); err != nil {
+		log.Printf("DeleteSavedSearch: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteSavedSearch: failed to encode response: %v", err)
+	}
+}
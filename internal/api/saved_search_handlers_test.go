@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestSavedSearches verifies a saved search can be created, listed, run
+// and deleted, and that invalid filters are rejected.
+func TestSavedSearches(t *testing.T) {
+	h := setupTestHandlers(t)
+	router := SetupRoutes(h)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	for body, want := range map[string]int{
+		`{"filter":{"genres":["fiction"]}}`:             http.StatusBadRequest,
+		`{"name":"Поиск","filter":{"query":"!!!"}}`:     http.StatusBadRequest,
+		`{"name":"Поиск","filter":{"sort_by":"price"}}`: http.StatusBadRequest,
+		// Without accounts there is no address to mail
+		`{"name":"Поиск","notify":true,"email":"reader@example.com"}`: http.StatusBadRequest,
+	} {
+		if w := serve("POST", "/api/v1/saved-searches", body); w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+
+	w := serve("POST", "/api/v1/saved-searches", `{"name":"Проза","filter":{"genres":["fiction"],"year_from":2000},"notify":true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var search storage.SavedSearch
+	if err := json.NewDecoder(w.Body).Decode(&search); err != nil {
+		t.Fatalf("failed to decode saved search: %v", err)
+	}
+
+	w = serve("GET", "/api/v1/saved-searches", "")
+	var listed struct {
+		Searches []storage.SavedSearch `json:"searches"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatalf("failed to decode saved searches: %v", err)
+	}
+	if len(listed.Searches) != 1 || listed.Searches[0].Name != "Проза" || !listed.Searches[0].Notify ||
+		listed.Searches[0].Filter.YearFrom != 2000 {
+		t.Errorf("unexpected saved searches %+v", listed.Searches)
+	}
+
+	w = serve("GET", "/api/v1/saved-searches/"+search.ID+"/books", "")
+	var books storage.BookList
+	if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
+		t.Fatalf("failed to decode books: %v", err)
+	}
+	if books.Total != 1 || books.Books[0].ID != "test-001" {
+		t.Errorf("expected the test book, got %+v", books)
+	}
+
+	if w := serve("DELETE", "/api/v1/saved-searches/"+search.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/v1/saved-searches/"+search.ID+"/books", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted saved search, got %d", w.Code)
+	}
+}
+
+// TestSavedSearches_Email checks that a saved search can only mail the
+// address an admin set for the account.
+func TestSavedSearches_Email(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	create := `{"name":"Проза","filter":{"genres":["fiction"]},"notify":true,"email":"Admin@Example.com"}`
+
+	if w := serve("POST", "/api/v1/saved-searches", create); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 before the account has an address, got %d", w.Code)
+	}
+	user, _ := h.repo.GetUserByUsername("admin")
+	if w := serve("PUT", "/api/v1/admin/users/"+user.ID+"/email", `{"email":"bad"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid address, got %d", w.Code)
+	}
+	if w := serve("PUT", "/api/v1/admin/users/nope/email", `{"email":"admin@example.com"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown user, got %d", w.Code)
+	}
+	if w := serve("PUT", "/api/v1/admin/users/"+user.ID+"/email", `{"email":"admin@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serve("POST", "/api/v1/saved-searches", create); w.Code != http.StatusCreated {
+		t.Errorf("expected 201 for the address of the account, got %d: %s", w.Code, w.Body.String())
+	}
+	other := `{"name":"Проза","filter":{"genres":["fiction"]},"notify":true,"email":"someone@example.com"}`
+	if w := serve("POST", "/api/v1/saved-searches", other); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for another address, got %d", w.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/sections"
+)
+
+// SetSections sets the sections configured by admins (OPDS_SECTIONS).
+func (h *Handlers) SetSections(list []sections.Section) {
+	h.sections = list
+}
+
+// ListSections returns the sections configured by admins, with their
+// filters, in the order of the root catalog.
+// GET /api/v1/sections
+func (h *Handlers) ListSections(w http.ResponseWriter, r *http.Request) {
+	list := h.sections
+	if list == nil {
+		list = []sections.Section{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"sections": list,
+	}); err != nil {
+		log.Printf("ListSections: failed to encode response: %v", err)
+	}
+}
+
+// SectionBooks returns the books of a configured section, in the order of
+// its filter. Books hidden from the viewer are left out.
+// GET /api/v1/sections/{id}/books?limit=30&offset=0
+func (h *Handlers) SectionBooks(w http.ResponseWriter, r *http.Request) {
+	section := sections.Find(h.sections, chi.URLParam(r, "id"))
+	if section == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Section not found")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("SectionBooks: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	filter := section.Filter
+	filter.Limit = limit
+	filter.Offset = parseInt(query.Get("offset"), 0)
+	filter.Hidden = hidden
+	filter.WithAnnotations = true
+
+	result, err := h.repo.SearchBooks(filter)
+	if err != nil {
+		log.Printf("SectionBooks: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("SectionBooks: failed to encode response: %v", err)
+	}
+}
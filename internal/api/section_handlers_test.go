@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/sections"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestSections verifies the configured sections are listed with their
+// filters and serve the books matching them.
+func TestSections(t *testing.T) {
+	h := setupTestHandlers(t)
+	list, err := sections.Parse("Проза|Художественная литература=id=prose&genres=fiction;Фантастика=genres=sf")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	h.SetSections(list)
+
+	w := httptest.NewRecorder()
+	h.ListSections(w, httptest.NewRequest("GET", "/api/v1/sections", nil))
+	var listed struct {
+		Sections []sections.Section `json:"sections"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(listed.Sections) != 2 || listed.Sections[0].ID != "prose" || listed.Sections[1].ID != "2" ||
+		listed.Sections[0].Filter.Genres[0] != "fiction" {
+		t.Errorf("unexpected sections %+v", listed.Sections)
+	}
+
+	books := func(id string) (int, storage.BookList) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/sections/"+id+"/books", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		w := httptest.NewRecorder()
+		h.SectionBooks(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		var result storage.BookList
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return w.Code, result
+	}
+
+	if _, result := books("prose"); result.Total != 1 || result.Books[0].ID != "test-001" {
+		t.Errorf("expected the test book in prose, got %+v", result)
+	}
+	if _, result := books("2"); result.Total != 0 {
+		t.Errorf("expected no science fiction, got %+v", result)
+	}
+	if code, _ := books("missing"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown section, got %d", code)
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// maxShelfImportSize bounds an uploaded shelf file
+const maxShelfImportSize = 8 << 20
+
+// userShelf returns the shelf named in the URL if it belongs to the
+// current user. Otherwise it writes a 404 and returns nil.
+func (h *Handlers) userShelf(w http.ResponseWriter, r *http.Request, op string) *storage.Shelf {
+	shelf, err := h.repo.GetShelf(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("%s: %v", op, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return nil
+	}
+	if shelf == nil || shelf.UserID != auth.UserIDFromContext(r.Context()) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Shelf not found")
+		return nil
+	}
+	return shelf
+}
+
+// ListShelves returns the shelves of the current user.
+// GET /api/v1/shelves
+func (h *Handlers) ListShelves(w http.ResponseWriter, r *http.Request) {
+	shelves, err := h.repo.ListShelves(auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("ListShelves: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"shelves": shelves}); err != nil {
+		log.Printf("ListShelves: failed to encode response: %v", err)
+	}
+}
+
+// CreateShelf creates an empty shelf from {"name": "..."}.
+// POST /api/v1/shelves
+func (h *Handlers) CreateShelf(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "name is required")
+		return
+	}
+
+	shelf, err := h.repo.CreateShelf(auth.UserIDFromContext(r.Context()), req.Name)
+	if err != nil {
+		log.Printf("CreateShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(shelf); err != nil {
+		log.Printf("CreateShelf: failed to encode response: %v", err)
+	}
+}
+
+// GetShelf returns a shelf with a page of its books in shelf order. Books
+// hidden from the viewer are left out.
+// GET /api/v1/shelves/{id}
+func (h *Handlers) GetShelf(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "GetShelf")
+	if shelf == nil {
+		return
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("GetShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	books, err := h.repo.SearchBooks(storage.BookFilter{
+		Shelf:  shelf.ID,
+		Limit:  limit,
+		Offset: parseInt(query.Get("offset"), 0),
+		SortBy: "shelf",
+		Hidden: hidden,
+
+		WithAnnotations: true,
+	})
+	if err != nil {
+		log.Printf("GetShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"shelf": shelf, "books": books}); err != nil {
+		log.Printf("GetShelf: failed to encode response: %v", err)
+	}
+}
+
+// DeleteShelf deletes a shelf of the current user.
+// DELETE /api/v1/shelves/{id}
+func (h *Handlers) DeleteShelf(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "DeleteShelf")
+	if shelf == nil {
+		return
+	}
+	if _, err := h.repo.DeleteShelf(shelf.ID); err != nil {
+		log.Printf("DeleteShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteShelf: failed to encode response: %v", err)
+	}
+}
+
+// AddShelfBook puts a book last on a shelf.
+// PUT /api/v1/shelves/{id}/books/{bookID}
+func (h *Handlers) AddShelfBook(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "AddShelfBook")
+	if shelf == nil {
+		return
+	}
+
+	bookID := chi.URLParam(r, "bookID")
+	if err := h.repo.AddShelfBook(shelf.ID, bookID); err != nil {
+		if errors.Is(err, storage.ErrBookNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+			return
+		}
+		log.Printf("AddShelfBook: book_id=%s error: %v", bookID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("AddShelfBook: failed to encode response: %v", err)
+	}
+}
+
+// RemoveShelfBook takes a book off a shelf.
+// DELETE /api/v1/shelves/{id}/books/{bookID}
+func (h *Handlers) RemoveShelfBook(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "RemoveShelfBook")
+	if shelf == nil {
+		return
+	}
+
+	removed, err := h.repo.RemoveShelfBook(shelf.ID, chi.URLParam(r, "bookID"))
+	if err != nil {
+		log.Printf("RemoveShelfBook: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, codeNotFound, "Book is not on the shelf")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("RemoveShelfBook: failed to encode response: %v", err)
+	}
+}
+
+// ExportShelf downloads a shelf as a portable JSON file, which
+// ImportShelf of this or another library reads back.
+// GET /api/v1/shelves/{id}/export
+func (h *Handlers) ExportShelf(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "ExportShelf")
+	if shelf == nil {
+		return
+	}
+
+	export, err := h.repo.ExportShelf(shelf.ID)
+	if err != nil {
+		log.Printf("ExportShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", contentDisposition(shelf.Name+".json", false))
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		log.Printf("ExportShelf: failed to encode response: %v", err)
+	}
+}
+
+// ShareShelf shares a shelf and returns its OPDS feed URL. Its random
+// token lets anyone with the link read the shelf without signing in, until
+// the share is revoked. A shared shelf keeps its link.
+// POST /api/v1/shelves/{id}/share
+func (h *Handlers) ShareShelf(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "ShareShelf")
+	if shelf == nil {
+		return
+	}
+	token, err := h.repo.ShelfToken(shelf.ID)
+	if err != nil {
+		log.Printf("ShareShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	h.writeShelfShare(w, shelf, token, "ShareShelf")
+}
+
+// GetShelfShare returns the OPDS feed URL of a shared shelf without
+// sharing it.
+// GET /api/v1/shelves/{id}/share
+func (h *Handlers) GetShelfShare(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "GetShelfShare")
+	if shelf == nil {
+		return
+	}
+	token, err := h.repo.SharedShelfToken(shelf.ID)
+	if err != nil {
+		log.Printf("GetShelfShare: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if token == "" {
+		writeError(w, http.StatusNotFound, codeNotFound, "Shelf is not shared")
+		return
+	}
+	h.writeShelfShare(w, shelf, token, "GetShelfShare")
+}
+
+// writeShelfShare answers with the OPDS feed URL of a shared shelf
+func (h *Handlers) writeShelfShare(w http.ResponseWriter, shelf *storage.Shelf, token, op string) {
+	var baseURL string
+	if site := h.site.Load(); site != nil {
+		baseURL = site.baseURL
+	}
+	feedURL := fmt.Sprintf("%s/opds/shelves/%s?token=%s",
+		baseURL, url.PathEscape(shelf.ID), url.QueryEscape(token))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"opds_url": feedURL}); err != nil {
+		log.Printf("%s: failed to encode response: %v", op, err)
+	}
+}
+
+// UnshareShelf revokes the shared link of a shelf; sharing it again gives a
+// new link.
+// DELETE /api/v1/shelves/{id}/share
+func (h *Handlers) UnshareShelf(w http.ResponseWriter, r *http.Request) {
+	shelf := h.userShelf(w, r, "UnshareShelf")
+	if shelf == nil {
+		return
+	}
+	if err := h.repo.RevokeShelfToken(shelf.ID); err != nil {
+		log.Printf("UnshareShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("UnshareShelf: failed to encode response: %v", err)
+	}
+}
+
+// ImportShelf creates a shelf of the current user from a file made by
+// ExportShelf or from an OPDS acquisition feed, such as a shared shelf.
+// The optional name query parameter overrides the name in the file. The
+// response lists the books this library does not have.
+// POST /api/v1/shelves/import
+func (h *Handlers) ImportShelf(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxShelfImportSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+
+	export, err := parseShelfImport(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+	if name := strings.TrimSpace(r.URL.Query().Get("name")); name != "" {
+		export.Name = name
+	}
+	if export.Name == "" {
+		export.Name = "Импорт"
+	}
+
+	result, err := h.repo.ImportShelf(auth.UserIDFromContext(r.Context()), export)
+	if err != nil {
+		log.Printf("ImportShelf: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("ImportShelf: failed to encode response: %v", err)
+	}
+}
+
+// shelfFeed is the part of an OPDS feed a shelf is imported from
+type shelfFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		ID      string   `xml:"id"`
+		Title   string   `xml:"title"`
+		Authors []string `xml:"author>name"`
+	} `xml:"entry"`
+}
+
+// parseShelfImport reads a shelf export (JSON) or an OPDS feed (XML)
+func parseShelfImport(data []byte) (*storage.ShelfExport, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		var feed shelfFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("invalid OPDS feed: %w", err)
+		}
+		export := &storage.ShelfExport{Name: feed.Title}
+		for _, entry := range feed.Entries {
+			// Entries of this catalog have IDs ending in /opds/books/{id}
+			var id string
+			if i := strings.LastIndex(entry.ID, "/opds/books/"); i >= 0 {
+				id = entry.ID[i+len("/opds/books/"):]
+			}
+			export.Books = append(export.Books, storage.ShelfExportBook{ID: id, Title: entry.Title, Authors: entry.Authors})
+		}
+		return export, nil
+	}
+
+	var export storage.ShelfExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid shelf file: %w", err)
+	}
+	if export.Format != storage.ShelfExportFormat {
+		return nil, fmt.Errorf("not a shelf file: format must be %q", storage.ShelfExportFormat)
+	}
+	return &export, nil
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestShelfExportImport verifies a shelf survives a round trip through its
+// JSON export and can be imported from an OPDS feed.
+func TestShelfExportImport(t *testing.T) {
+	h := setupTestHandlers(t)
+	h.SetPublicSite("http://library.example", "Test")
+	router := SetupRoutes(h)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := serve("POST", "/api/v1/shelves", `{"name":"Прочитать"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var shelf storage.Shelf
+	if err := json.NewDecoder(w.Body).Decode(&shelf); err != nil {
+		t.Fatalf("failed to decode shelf: %v", err)
+	}
+
+	if w := serve("PUT", "/api/v1/shelves/"+shelf.ID+"/books/test-001", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("PUT", "/api/v1/shelves/"+shelf.ID+"/books/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown book, got %d", w.Code)
+	}
+
+	w = serve("GET", "/api/v1/shelves/"+shelf.ID+"/export", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	exported := w.Body.String()
+	if !strings.Contains(exported, `"format": "pushkinlib-shelf"`) || !strings.Contains(exported, `"Test Author"`) {
+		t.Errorf("unexpected export %s", exported)
+	}
+
+	// An export of another library: the ID differs, title and author match
+	foreign := strings.Replace(exported, `"id": "test-001"`, `"id": "other-42"`, 1)
+	w = serve("POST", "/api/v1/shelves/import?name=Копия", foreign)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var imported storage.ShelfImport
+	if err := json.NewDecoder(w.Body).Decode(&imported); err != nil {
+		t.Fatalf("failed to decode import: %v", err)
+	}
+	if imported.Added != 1 || len(imported.Missing) != 0 || imported.Shelf.Name != "Копия" {
+		t.Errorf("unexpected import %+v", imported)
+	}
+
+	feed := `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Из OPDS</title>
+		<entry><id>http://other.example/opds/books/test-001</id><title>Test Book Title</title></entry>
+		<entry><id>urn:x</id><title>Нет такой</title><author><name>Никто</name></author></entry></feed>`
+	w = serve("POST", "/api/v1/shelves/import", feed)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	imported = storage.ShelfImport{}
+	if err := json.NewDecoder(w.Body).Decode(&imported); err != nil {
+		t.Fatalf("failed to decode import: %v", err)
+	}
+	if imported.Added != 1 || len(imported.Missing) != 1 || imported.Shelf.Name != "Из OPDS" {
+		t.Errorf("unexpected import %+v", imported)
+	}
+
+	if w := serve("POST", "/api/v1/shelves/import", `{"name":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a file without format, got %d", w.Code)
+	}
+
+	if w := serve("GET", "/api/v1/shelves/"+shelf.ID+"/share", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a shelf that is not shared, got %d: %s", w.Code, w.Body.String())
+	}
+	if token, _ := h.repo.SharedShelfToken(shelf.ID); token != "" {
+		t.Fatal("expected reading the link not to share the shelf")
+	}
+	w = serve("POST", "/api/v1/shelves/"+shelf.ID+"/share", "")
+	var share map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&share); err != nil {
+		t.Fatalf("failed to decode share: %v", err)
+	}
+	token, _ := h.repo.SharedShelfToken(shelf.ID)
+	want := "http://library.example/opds/shelves/" + shelf.ID + "?token=" + token
+	if token == "" || share["opds_url"] != want {
+		t.Errorf("opds_url = %q, want %q", share["opds_url"], want)
+	}
+	w = serve("GET", "/api/v1/shelves/"+shelf.ID+"/share", "")
+	share = nil
+	if err := json.NewDecoder(w.Body).Decode(&share); err != nil || share["opds_url"] != want {
+		t.Errorf("expected the shared link, got %v, %v", share, err)
+	}
+
+	if w := serve("DELETE", "/api/v1/shelves/"+shelf.ID+"/share", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for unsharing, got %d: %s", w.Code, w.Body.String())
+	}
+	if h.repo.ValidShelfToken(shelf.ID, token) {
+		t.Error("expected the shared link revoked")
+	}
+}
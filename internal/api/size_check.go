@@ -0,0 +1,219 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// Modes of the file size check run after imports, see SetSizeCheck
+const (
+	SizeCheckOff    = "off"
+	SizeCheckReport = "report"
+	SizeCheckFix    = "fix"
+)
+
+// SetSizeCheck makes imports compare the file sizes of the INPX with the
+// archive entries afterwards: "report" records mismatches, "fix" also
+// replaces the catalog sizes by the actual ones, and "off" or "" skips the
+// check. It must be called before requests are served.
+func (h *Handlers) SetSizeCheck(mode string) error {
+	switch mode {
+	case "", SizeCheckOff:
+		h.sizeCheck = ""
+	case SizeCheckReport, SizeCheckFix:
+		h.sizeCheck = mode
+	default:
+		return fmt.Errorf("unknown size check mode %q (want %s, %s or %s)", mode, SizeCheckOff, SizeCheckReport, SizeCheckFix)
+	}
+	return nil
+}
+
+// checkFileSizes compares the file size of every book with the size of its
+// entry in its archive, records the mismatches, and with fix corrects the
+// catalog. Books whose archive or entry is missing are counted as
+// unchecked; ArchiveEntries lists those. The caller must hold a job of
+// h.jobs.
+func (h *Handlers) checkFileSizes(fix bool) (storage.SizeCheck, error) {
+	start := time.Now()
+	var check storage.SizeCheck
+
+	archives, err := h.repo.ListArchives()
+	if err != nil {
+		return check, err
+	}
+	var mismatches []storage.SizeMismatch
+	for _, archive := range archives {
+		books, err := h.repo.ListArchiveBooks(archive.ArchivePath)
+		if err != nil {
+			return check, err
+		}
+		check.Archives++
+
+		entries, err := h.archiveEntrySizes(archive.ArchivePath)
+		if err != nil {
+			check.Unchecked += len(books)
+			continue
+		}
+		for _, book := range books {
+			name, size, ok := "", int64(0), false
+			for _, candidate := range bookFileNames(book.ID, book.Format) {
+				if size, ok = entries[strings.ToLower(candidate)]; ok {
+					name = candidate
+					break
+				}
+			}
+			if !ok {
+				check.Unchecked++
+				continue
+			}
+			check.Checked++
+			if size != book.FileSize {
+				mismatches = append(mismatches, storage.SizeMismatch{
+					BookID:      book.ID,
+					Title:       book.Title,
+					ArchivePath: archive.ArchivePath,
+					FileName:    name,
+					CatalogSize: book.FileSize,
+					ActualSize:  size,
+					Fixed:       fix,
+				})
+			}
+		}
+	}
+	check.Mismatches = len(mismatches)
+
+	if fix && len(mismatches) > 0 {
+		sizes := make(map[string]int64, len(mismatches))
+		for _, m := range mismatches {
+			sizes[m.BookID] = m.ActualSize
+		}
+		if err := h.repo.UpdateBookFileSizes(sizes); err != nil {
+			return check, err
+		}
+		check.Fixed = len(mismatches)
+		h.repo.InvalidateQueryCache()
+	}
+	if err := h.repo.ReplaceSizeMismatches(mismatches); err != nil {
+		return check, err
+	}
+	check.DurationMs = time.Since(start).Milliseconds()
+	return check, nil
+}
+
+// archiveEntrySizes returns the uncompressed sizes of the files of an
+// archive by lowercase name, read from its central directory
+func (h *Handlers) archiveEntrySizes(archiveName string) (map[string]int64, error) {
+	path, err := h.archiveFilePath(archiveName)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	sizes := make(map[string]int64, len(archive.File))
+	for _, file := range archive.File {
+		sizes[strings.ToLower(file.Name)] = int64(file.UncompressedSize64)
+	}
+	return sizes, nil
+}
+
+// sizeCheckAfterImport runs the check configured with SetSizeCheck, if
+// any, and returns its result for the import response. A failed check is
+// logged; the import stands. The caller must hold a job of h.jobs.
+func (h *Handlers) sizeCheckAfterImport() *storage.SizeCheck {
+	if h.sizeCheck == "" {
+		return nil
+	}
+	check, err := h.checkFileSizes(h.sizeCheck == SizeCheckFix)
+	if err != nil {
+		log.Printf("Size check: %v", err)
+		return nil
+	}
+	log.Printf("Size check: %d of %d books differ from the INPX, %d fixed, %d not found, in %dms",
+		check.Mismatches, check.Checked, check.Fixed, check.Unchecked, check.DurationMs)
+	return &check
+}
+
+// StartSizeCheck runs the check configured with SetSizeCheck in the
+// background, for a catalog imported before the handlers were created.
+func (h *Handlers) StartSizeCheck() {
+	if h.sizeCheck == "" {
+		return
+	}
+	go func() {
+		job, err := h.jobs.Begin("size-check")
+		if err != nil {
+			log.Printf("Size check: skipped, %v", err)
+			return
+		}
+		defer h.jobs.End(job)
+		h.sizeCheckAfterImport()
+	}()
+}
+
+// CheckFileSizes compares the file sizes of the catalog with the archive
+// entries now and records the mismatches; with fix=true it also corrects
+// the catalog (admin only).
+// POST /api/v1/admin/reindex/sizes?fix=true
+func (h *Handlers) CheckFileSizes(w http.ResponseWriter, r *http.Request) {
+	if h.booksUnavailable(w) {
+		return
+	}
+	job, err := h.jobs.Begin("size-check")
+	if err != nil {
+		writeBusy(w, err)
+		return
+	}
+	check, err := h.checkFileSizes(r.URL.Query().Get("fix") == "true")
+	h.jobs.End(job)
+	if err != nil {
+		log.Printf("CheckFileSizes: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(check); err != nil {
+		log.Printf("CheckFileSizes: failed to encode response: %v", err)
+	}
+}
+
+// ListSizeMismatches returns the books the last file size check found to
+// differ from the INPX (admin only).
+// GET /api/v1/reindex/sizes
+func (h *Handlers) ListSizeMismatches(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := parseInt(query.Get("offset"), 0)
+
+	mismatches, total, err := h.repo.ListSizeMismatches(limit, offset)
+	if err != nil {
+		log.Printf("ListSizeMismatches: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"mismatches": mismatches,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	}); err != nil {
+		log.Printf("ListSizeMismatches: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestCheckFileSizes verifies mismatching sizes are reported, and corrected
+// with fix=true, and that books without a file are left unchecked.
+func TestCheckFileSizes(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "test-002", Title: "Lost Book", Authors: []string{"Test Author"}, ArchivePath: "test-archive", Format: "fb2", Date: time.Now()},
+		{ID: "gone-001", Title: "Gone Book", Authors: []string{"Test Author"}, ArchivePath: "gone.zip", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	check := func(query string) storage.SizeCheck {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.CheckFileSizes(w, httptest.NewRequest("POST", "/api/v1/admin/reindex/sizes"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var result storage.SizeCheck
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return result
+	}
+
+	// test-001 is in the INPX as 12345 bytes
+	if got := check(""); got.Checked != 1 || got.Mismatches != 1 || got.Fixed != 0 || got.Unchecked != 2 {
+		t.Fatalf("unexpected check: %+v", got)
+	}
+	w := httptest.NewRecorder()
+	h.ListSizeMismatches(w, httptest.NewRequest("GET", "/api/v1/reindex/sizes", nil))
+	var report struct {
+		Mismatches []storage.SizeMismatch `json:"mismatches"`
+		Total      int                    `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if report.Total != 1 || report.Mismatches[0].BookID != "test-001" || report.Mismatches[0].FileName != "test-001.fb2" ||
+		report.Mismatches[0].CatalogSize != 12345 || report.Mismatches[0].ActualSize == 12345 || report.Mismatches[0].Fixed {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	actual := report.Mismatches[0].ActualSize
+
+	if got := check("?fix=true"); got.Mismatches != 1 || got.Fixed != 1 {
+		t.Fatalf("unexpected check: %+v", got)
+	}
+	book, err := h.repo.GetBookByID("test-001")
+	if err != nil || book.FileSize != actual {
+		t.Fatalf("expected the size fixed to %d, got %+v, %v", actual, book, err)
+	}
+	if got := check(""); got.Mismatches != 0 {
+		t.Errorf("expected no mismatches after the fix, got %+v", got)
+	}
+
+	if err := h.SetSizeCheck("sometimes"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
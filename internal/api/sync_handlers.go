@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// maxSyncLimit caps the number of changes returned per request
+const maxSyncLimit = 1000
+
+// syncChangesResponse is the response of GetSyncChanges. Cursor is the
+// since value for the next request.
+type syncChangesResponse struct {
+	Changes []storage.SyncChange `json:"changes"`
+	Cursor  int64                `json:"cursor"`
+	HasMore bool                 `json:"has_more"`
+}
+
+// syncArchive describes a book archive available to mirrors
+type syncArchive struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// recordSyncChanges logs changes of the given books for mirrors, or of the
+// whole library when no IDs are given. Failures are logged only: the next
+// scan picks the changes up.
+func (h *Handlers) recordSyncChanges(bookIDs ...string) {
+	if !h.repo.SyncEnabled() {
+		return
+	}
+	var err error
+	if len(bookIDs) == 0 {
+		_, err = h.repo.RecordSyncChanges()
+	} else {
+		_, err = h.repo.RecordBookSyncChanges(bookIDs...)
+	}
+	if err != nil {
+		log.Printf("Sync: failed to record changes: %v", err)
+	}
+}
+
+// GetSyncChanges returns library changes after the since cursor for
+// mirroring instances (admin only).
+// GET /api/v1/sync/changes
+func (h *Handlers) GetSyncChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.repo.SyncEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Sync is not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+	if query.Get("since") != "" && (err != nil || since < 0) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "since must be a non-negative integer")
+		return
+	}
+	limit := parseInt(query.Get("limit"), 500)
+	if limit <= 0 || limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	changes, err := h.repo.ListSyncChanges(since, limit+1)
+	if err != nil {
+		log.Printf("GetSyncChanges: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	response := syncChangesResponse{Changes: changes, Cursor: since}
+	if len(changes) > limit {
+		response.Changes, response.HasMore = changes[:limit], true
+	}
+	if response.Changes == nil {
+		response.Changes = []storage.SyncChange{}
+	}
+	if n := len(response.Changes); n > 0 {
+		response.Cursor = response.Changes[n-1].Seq
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("GetSyncChanges: failed to encode response: %v", err)
+	}
+}
+
+// ListSyncArchives lists the book archives a mirror can download, including
+// those in subfolders, by their path relative to the books directory
+// (admin only). Hidden files and folders are left out.
+// GET /api/v1/sync/archives
+func (h *Handlers) ListSyncArchives(w http.ResponseWriter, r *http.Request) {
+	if !h.repo.SyncEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Sync is not enabled")
+		return
+	}
+	if h.booksUnavailable(w) {
+		return
+	}
+
+	archives := []syncArchive{}
+	// The books directory itself may be a symlink, which WalkDir would not enter
+	root, err := filepath.EvalSymlinks(h.booksDir)
+	if err == nil {
+		err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if p == root {
+					return err
+				}
+				log.Printf("ListSyncArchives: %v", err)
+				return nil
+			}
+			if p == root {
+				return nil
+			}
+			if strings.HasPrefix(entry.Name(), ".") {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return nil
+			}
+			name := filepath.ToSlash(rel)
+			if !isSyncArchiveName(name) {
+				return nil
+			}
+			// Symlinks are followed only within the books directory
+			path, err := libraryPath(h.booksDir, name)
+			if err != nil {
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			archives = append(archives, syncArchive{Name: name, Size: info.Size(), ModTime: info.ModTime().UTC()})
+			return nil
+		})
+	}
+	if err != nil {
+		log.Printf("ListSyncArchives: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to read books directory")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"archives": archives}); err != nil {
+		log.Printf("ListSyncArchives: failed to encode response: %v", err)
+	}
+}
+
+// GetSyncArchive serves a book archive to a mirror; Range requests allow
+// resuming (admin only).
+// GET /api/v1/sync/archives/{path}
+func (h *Handlers) GetSyncArchive(w http.ResponseWriter, r *http.Request) {
+	if !h.repo.SyncEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Sync is not enabled")
+		return
+	}
+
+	name := chi.URLParam(r, "*")
+	if !isSyncArchiveName(name) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid archive name")
+		return
+	}
+	if h.booksUnavailable(w) {
+		return
+	}
+
+	path, err := libraryPath(h.booksDir, name)
+	if err != nil {
+		if errors.Is(err, errInvalidArchivePath) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid archive name")
+			return
+		}
+		log.Printf("GetSyncArchive: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Archive not found")
+			return
+		}
+		log.Printf("GetSyncArchive: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("GetSyncArchive: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// isSyncArchiveName accepts .zip paths relative to the books directory
+// whose elements are neither empty nor hidden, which also rules out ".."
+func isSyncArchiveName(name string) bool {
+	if name == "" || strings.ContainsRune(name, '\\') || !strings.EqualFold(filepath.Ext(name), ".zip") {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || strings.HasPrefix(elem, ".") {
+			return false
+		}
+	}
+	return true
+}
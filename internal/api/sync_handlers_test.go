@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func getSyncChanges(t *testing.T, router http.Handler, target string) syncChangesResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp syncChangesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// TestGetSyncChanges checks the change feed, its cursor and that edits made
+// through the API are recorded.
+func TestGetSyncChanges(t *testing.T) {
+	h := setupTestHandlers(t)
+	router := SetupRoutes(h)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/changes", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 while sync is disabled, got %d", w.Code)
+	}
+
+	h.repo.SetSyncEnabled(true)
+	if _, err := h.repo.RecordSyncChanges(); err != nil {
+		t.Fatalf("RecordSyncChanges failed: %v", err)
+	}
+
+	first := getSyncChanges(t, router, "/api/v1/sync/changes?since=0")
+	if len(first.Changes) != 1 || first.HasMore || first.Cursor != 1 {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	if change := first.Changes[0]; change.Op != storage.SyncOpUpsert || change.Book == nil || change.Book.Title != "Test Book Title" {
+		t.Errorf("unexpected change: %+v", change)
+	}
+
+	if empty := getSyncChanges(t, router, "/api/v1/sync/changes?since=1"); len(empty.Changes) != 0 || empty.Cursor != 1 {
+		t.Errorf("expected no further changes, got %+v", empty)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PATCH", "/api/v1/books/test-001", strings.NewReader(`{"title":"Renamed"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateBook failed: %d %s", w.Code, w.Body.String())
+	}
+	next := getSyncChanges(t, router, "/api/v1/sync/changes?since=1")
+	if len(next.Changes) != 1 || next.Changes[0].Book.Title != "Renamed" || next.Cursor != 2 {
+		t.Errorf("expected the edit to be recorded, got %+v", next)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/changes?since=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid since, got %d", w.Code)
+	}
+}
+
+// TestSyncArchives checks listing and downloading archives and rejects names
+// outside the books directory.
+func TestSyncArchives(t *testing.T) {
+	h := setupTestHandlers(t)
+	writeTestArchive(t, h.booksDir)
+	h.repo.SetSyncEnabled(true)
+	router := SetupRoutes(h)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/archives", nil))
+	var list struct {
+		Archives []syncArchive `json:"archives"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Archives) != 1 || list.Archives[0].Name != "test-archive.zip" || list.Archives[0].Size == 0 {
+		t.Fatalf("unexpected archives: %+v", list.Archives)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/archives/test-archive.zip", nil))
+	if w.Code != http.StatusOK || int64(w.Body.Len()) != list.Archives[0].Size {
+		t.Errorf("expected the archive, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	// Archives in subfolders are listed by their relative path
+	nested := filepath.Join(h.booksDir, "sub", "nested.zip")
+	if err := os.MkdirAll(filepath.Dir(nested), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(nested, []byte("zip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/archives", nil))
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Archives) != 2 || list.Archives[0].Name != "sub/nested.zip" {
+		t.Fatalf("expected the nested archive listed, got %+v", list.Archives)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/archives/sub/nested.zip", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "zip data" {
+		t.Errorf("expected the nested archive, got %d: %q", w.Code, w.Body.String())
+	}
+
+	for _, name := range []string{"..%2Ftest.db", ".hidden.zip", "notes.txt", "sub/../../test.zip", ".git/x.zip"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/sync/archives/"+name, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, w.Code)
+		}
+	}
+}
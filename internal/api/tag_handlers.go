@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// ListTags returns a paginated list of tags with book counts.
+// GET /api/v1/tags
+func (h *Handlers) ListTags(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := parseInt(query.Get("limit"), 30)
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := parseInt(query.Get("offset"), 0)
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		log.Printf("ListTags: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	tags, total, err := h.repo.ListVisibleTags(limit, offset, hidden)
+	if err != nil {
+		log.Printf("ListTags: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if tags == nil {
+		tags = []storage.Tag{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"tags":   tags,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	}); err != nil {
+		log.Printf("ListTags: failed to encode response: %v", err)
+	}
+}
+
+// CreateTag creates a new tag (admin only).
+// POST /api/v1/admin/tags
+func (h *Handlers) CreateTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Название тега обязательно")
+		return
+	}
+
+	tag, err := h.repo.CreateTag(req.Name)
+	if err != nil {
+		if errors.Is(err, storage.ErrTagExists) {
+			writeError(w, http.StatusConflict, codeConflict, "Тег с таким названием уже существует")
+			return
+		}
+		log.Printf("CreateTag: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tag); err != nil {
+		log.Printf("CreateTag: failed to encode response: %v", err)
+	}
+}
+
+// RenameTag changes a tag name (admin only).
+// PUT /api/v1/admin/tags/{id}
+func (h *Handlers) RenameTag(w http.ResponseWriter, r *http.Request) {
+	tagID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid tag ID")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Название тега обязательно")
+		return
+	}
+
+	if err := h.repo.RenameTag(tagID, req.Name); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrTagNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "Тег не найден")
+		case errors.Is(err, storage.ErrTagExists):
+			writeError(w, http.StatusConflict, codeConflict, "Тег с таким названием уже существует")
+		default:
+			log.Printf("RenameTag: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("RenameTag: failed to encode response: %v", err)
+	}
+}
+
+// DeleteTag deletes a tag and detaches it from all books (admin only).
+// DELETE /api/v1/admin/tags/{id}
+func (h *Handlers) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	tagID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid tag ID")
+		return
+	}
+
+	if err := h.repo.DeleteTag(tagID); err != nil {
+		if errors.Is(err, storage.ErrTagNotFound) {
+			writeError(w, http.StatusNotFound, codeNotFound, "Тег не найден")
+			return
+		}
+		log.Printf("DeleteTag: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteTag: failed to encode response: %v", err)
+	}
+}
+
+// AddBookTag attaches a tag to a book (admin only).
+// PUT /api/v1/admin/books/{id}/tags/{tagID}
+func (h *Handlers) AddBookTag(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	tagID, err := strconv.Atoi(chi.URLParam(r, "tagID"))
+	if bookID == "" || err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid book or tag ID")
+		return
+	}
+
+	if err := h.repo.AddBookTag(bookID, tagID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBookNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "Book not found")
+		case errors.Is(err, storage.ErrTagNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "Тег не найден")
+		default:
+			log.Printf("AddBookTag: book_id=%s tag_id=%d error: %v", bookID, tagID, err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("AddBookTag: failed to encode response: %v", err)
+	}
+}
+
+// RemoveBookTag detaches a tag from a book (admin only).
+// DELETE /api/v1/admin/books/{id}/tags/{tagID}
+func (h *Handlers) RemoveBookTag(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	tagID, err := strconv.Atoi(chi.URLParam(r, "tagID"))
+	if bookID == "" || err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid book or tag ID")
+		return
+	}
+
+	if err := h.repo.RemoveBookTag(bookID, tagID); err != nil {
+		log.Printf("RemoveBookTag: book_id=%s tag_id=%d error: %v", bookID, tagID, err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("RemoveBookTag: failed to encode response: %v", err)
+	}
+}
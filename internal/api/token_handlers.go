@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// maxTokenNameLength bounds the name of an API token
+const maxTokenNameLength = 100
+
+// ListAPITokens returns the API tokens of the current user. The tokens
+// themselves are not returned, only their first characters.
+// GET /api/v1/auth/tokens
+func (h *Handlers) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	if !h.authMw.IsEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
+		return
+	}
+
+	tokens, err := h.repo.ListAPITokens(auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("ListAPITokens: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens}); err != nil {
+		log.Printf("ListAPITokens: failed to encode response: %v", err)
+	}
+}
+
+// CreateAPIToken creates an API token of the current user from
+// {"name": "...", "scopes": ["read", "download"], "expires_in_days": 90}.
+// The response carries the token, which cannot be retrieved later.
+// POST /api/v1/auth/tokens
+func (h *Handlers) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	if !h.authMw.IsEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxTokenNameLength {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "name is required and must be at most 100 bytes")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			"scopes is required: any of "+strings.Join(storage.TokenScopes, ", "))
+		return
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !storage.IsValidTokenScope(scope) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				"Unknown scope "+scope+": scopes are "+strings.Join(storage.TokenScopes, ", "))
+			return
+		}
+		if scope == storage.ScopeAdmin && !user.IsAdmin {
+			writeError(w, http.StatusForbidden, codeForbidden, "Only admins can create tokens with the admin scope")
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "expires_in_days must not be negative")
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	record, token, err := h.repo.CreateAPIToken(user.ID, req.Name, scopes, expiresAt)
+	if err != nil {
+		log.Printf("CreateAPIToken: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(struct {
+		*storage.APIToken
+		Token string `json:"token"`
+	}{record, token}); err != nil {
+		log.Printf("CreateAPIToken: failed to encode response: %v", err)
+	}
+}
+
+// DeleteAPIToken revokes an API token of the current user.
+// DELETE /api/v1/auth/tokens/{id}
+func (h *Handlers) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	if !h.authMw.IsEnabled() {
+		writeError(w, http.StatusNotFound, codeNotFound, "Authentication is not enabled")
+		return
+	}
+
+	err := h.repo.DeleteAPIToken(auth.UserIDFromContext(r.Context()), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrAPITokenNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "API token not found")
+		return
+	}
+	if err != nil {
+		log.Printf("DeleteAPIToken: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); err != nil {
+		log.Printf("DeleteAPIToken: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAPITokens creates a read-only token with a session, uses it as a
+// bearer token and as a Basic Auth password, and revokes it.
+func TestAPITokens(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	cookie := loginAndGetCookie(t, h)
+
+	serve := func(method, path, body string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if prepare != nil {
+			prepare(req)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	withSession := func(req *http.Request) { req.AddCookie(cookie) }
+
+	w := serve("POST", "/api/v1/auth/tokens", `{"name":"bot","scopes":["read","read"],"expires_in_days":30}`, withSession)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create token: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID        string   `json:"id"`
+		Token     string   `json:"token"`
+		Prefix    string   `json:"prefix"`
+		Scopes    []string `json:"scopes"`
+		ExpiresAt string   `json:"expires_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode token: %v", err)
+	}
+	if !strings.HasPrefix(created.Token, "pkl_") || !strings.HasPrefix(created.Token, created.Prefix) ||
+		len(created.Scopes) != 1 || created.ExpiresAt == "" {
+		t.Fatalf("unexpected token: %+v", created)
+	}
+	withToken := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+created.Token) }
+
+	if w := serve("GET", "/api/v1/books", "", withToken); w.Code != http.StatusOK {
+		t.Errorf("search with a read token: expected 200, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/v1/admin/stats", "", withToken); w.Code != http.StatusForbidden ||
+		!strings.Contains(w.Body.String(), "insufficient_scope") {
+		t.Errorf("admin endpoint with a read token: expected 403 insufficient_scope, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/download/book-1", "", withToken); w.Code != http.StatusForbidden {
+		t.Errorf("download with a read token: expected 403, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/v1/admin/stats", "", withSession); w.Code != http.StatusOK {
+		t.Errorf("admin endpoint with a session: expected 200, got %d", w.Code)
+	}
+	if w := serve("POST", "/api/v1/auth/tokens", `{"name":"more","scopes":["admin"]}`, withToken); w.Code != http.StatusForbidden {
+		t.Errorf("create token with a token: expected 403, got %d", w.Code)
+	}
+
+	basic := func(req *http.Request) { req.SetBasicAuth("admin", created.Token) }
+	if w := serve("GET", "/api/v1/books", "", basic); w.Code != http.StatusOK {
+		t.Errorf("search with the token as password: expected 200, got %d", w.Code)
+	}
+
+	w = serve("GET", "/api/v1/auth/tokens", "", withSession)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Token) ||
+		!strings.Contains(w.Body.String(), created.Prefix) || !strings.Contains(w.Body.String(), "last_used_at") {
+		t.Errorf("list tokens: got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serve("DELETE", "/api/v1/auth/tokens/"+created.ID, "", withSession); w.Code != http.StatusOK {
+		t.Fatalf("delete token: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("DELETE", "/api/v1/auth/tokens/"+created.ID, "", withSession); w.Code != http.StatusNotFound {
+		t.Errorf("delete a revoked token: expected 404, got %d", w.Code)
+	}
+	if w := serve("GET", "/api/v1/shelves", "", withToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: expected 401, got %d", w.Code)
+	}
+}
+
+// TestCreateAPIToken_Invalid rejects unknown scopes and admin tokens of
+// users who are not admins.
+func TestCreateAPIToken_Invalid(t *testing.T) {
+	h, _ := setupAuthHandlers(t)
+	router := SetupRoutes(h)
+	if _, err := h.repo.CreateUser("reader", "reader123", "", false); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	for _, tc := range []struct {
+		user, body string
+		want       int
+	}{
+		{"admin", `{"name":"bot","scopes":["write"]}`, http.StatusBadRequest},
+		{"admin", `{"name":"bot","scopes":[]}`, http.StatusBadRequest},
+		{"admin", `{"name":"","scopes":["read"]}`, http.StatusBadRequest},
+		{"admin", `{"name":"bot","scopes":["read"],"expires_in_days":-1}`, http.StatusBadRequest},
+		{"reader", `{"name":"bot","scopes":["admin"]}`, http.StatusForbidden},
+		{"reader", `{"name":"bot","scopes":["read","download"]}`, http.StatusCreated},
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/auth/tokens", strings.NewReader(tc.body))
+		user, err := h.repo.GetUserByUsername(tc.user)
+		if err != nil || user == nil {
+			t.Fatalf("GetUserByUsername(%s) = %v, %v", tc.user, user, err)
+		}
+		session, err := h.repo.CreateSession(user.ID, sessionDuration)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.user, tc.body, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
@@ -74,14 +74,14 @@ func (h *Handlers) GetTTSStatus(w http.ResponseWriter, r *http.Request) {
 // GET /api/v1/tts/voices
 func (h *Handlers) GetTTSVoices(w http.ResponseWriter, r *http.Request) {
 	if !h.tts.TTSEnabled() {
-		http.Error(w, "TTS server not configured", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "TTS server not configured")
 		return
 	}
 
 	req, err := http.NewRequestWithContext(r.Context(), "GET", h.tts.ServerURL+"/v1/models", nil)
 	if err != nil {
 		log.Printf("GetTTSVoices: failed to create request: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal error")
 		return
 	}
 
@@ -92,7 +92,7 @@ func (h *Handlers) GetTTSVoices(w http.ResponseWriter, r *http.Request) {
 	resp, err := ttsHTTPClient.Do(req)
 	if err != nil {
 		log.Printf("GetTTSVoices: TTS server error: %v", err)
-		http.Error(w, "TTS server unavailable", http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, codeUpstreamFailed, "TTS server unavailable")
 		return
 	}
 	defer resp.Body.Close()
@@ -100,13 +100,13 @@ func (h *Handlers) GetTTSVoices(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20)) // 1MB max
 	if err != nil {
 		log.Printf("GetTTSVoices: failed to read response: %v", err)
-		http.Error(w, "Failed to read TTS response", http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, codeUpstreamFailed, "Failed to read TTS response")
 		return
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("GetTTSVoices: TTS returned %d: %s", resp.StatusCode, string(body))
-		http.Error(w, "TTS server error", resp.StatusCode)
+		writeError(w, resp.StatusCode, codeUpstreamFailed, "TTS server error")
 		return
 	}
 
@@ -118,25 +118,25 @@ func (h *Handlers) GetTTSVoices(w http.ResponseWriter, r *http.Request) {
 // POST /api/v1/tts/speech
 func (h *Handlers) SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	if !h.tts.TTSEnabled() {
-		http.Error(w, "TTS server not configured", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "TTS server not configured")
 		return
 	}
 
 	var ttsReq ttsRequest
 	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&ttsReq); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "Invalid request body")
 		return
 	}
 
 	if strings.TrimSpace(ttsReq.Input) == "" {
-		http.Error(w, "Input text is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Input text is required")
 		return
 	}
 
 	// Limit input length to prevent extremely long synthesis requests.
 	const maxInputLength = 5000
 	if len([]rune(ttsReq.Input)) > maxInputLength {
-		http.Error(w, fmt.Sprintf("Input text too long (%d chars, max %d)", len([]rune(ttsReq.Input)), maxInputLength), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Input text too long (%d chars, max %d)", len([]rune(ttsReq.Input)), maxInputLength))
 		return
 	}
 
@@ -164,7 +164,7 @@ func (h *Handlers) SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		log.Printf("SynthesizeSpeech: failed to marshal request: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal error")
 		return
 	}
 
@@ -178,7 +178,7 @@ func (h *Handlers) SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	req, err := http.NewRequestWithContext(ctx, "POST", h.tts.ServerURL+"/v1/audio/speech", bytes.NewReader(payload))
 	if err != nil {
 		log.Printf("SynthesizeSpeech: failed to create request: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal error")
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -189,7 +189,7 @@ func (h *Handlers) SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	resp, err := ttsHTTPClient.Do(req)
 	if err != nil {
 		log.Printf("SynthesizeSpeech: TTS server error: %v", err)
-		http.Error(w, "TTS server unavailable", http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, codeUpstreamFailed, "TTS server unavailable")
 		return
 	}
 	defer resp.Body.Close()
@@ -201,11 +201,11 @@ func (h *Handlers) SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 		// Forward specific error codes from TTS
 		switch resp.StatusCode {
 		case http.StatusTooManyRequests:
-			http.Error(w, "TTS rate limit exceeded", http.StatusTooManyRequests)
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "TTS rate limit exceeded")
 		case http.StatusBadRequest:
-			http.Error(w, "Invalid TTS request: "+string(body), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid TTS request: "+string(body))
 		default:
-			http.Error(w, "TTS synthesis failed", http.StatusBadGateway)
+			writeError(w, http.StatusBadGateway, codeUpstreamFailed, "TTS synthesis failed")
 		}
 		return
 	}
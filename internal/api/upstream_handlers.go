@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+	"github.com/piligrim/pushkinlib/internal/upstream"
+)
+
+// SetUpstreams enables the admin endpoints for aggregated OPDS catalogs.
+func (h *Handlers) SetUpstreams(service *upstream.Service) {
+	h.upstreams = service
+}
+
+// ListUpstreams returns the aggregated OPDS catalogs with their crawl
+// status (admin only).
+// GET /api/v1/admin/upstreams
+func (h *Handlers) ListUpstreams(w http.ResponseWriter, r *http.Request) {
+	if h.upstreams == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "No upstream catalogs are configured")
+		return
+	}
+
+	sources, err := h.repo.ListUpstreamSources()
+	if err != nil {
+		log.Printf("ListUpstreams: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+	if sources == nil {
+		sources = []storage.UpstreamSource{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"sources":  sources,
+		"crawling": h.upstreams.Crawling(),
+	}); err != nil {
+		log.Printf("ListUpstreams: failed to encode response: %v", err)
+	}
+}
+
+// CrawlUpstreams re-crawls all aggregated catalogs in the background
+// (admin only). Returns 409 if a crawl is already running.
+// POST /api/v1/admin/upstreams/crawl
+func (h *Handlers) CrawlUpstreams(w http.ResponseWriter, r *http.Request) {
+	if h.upstreams == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "No upstream catalogs are configured")
+		return
+	}
+	if !h.upstreams.CrawlAll(context.Background()) {
+		writeError(w, http.StatusConflict, codeConflict, "A crawl is already running")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "started"}); err != nil {
+		log.Printf("CrawlUpstreams: failed to encode response: %v", err)
+	}
+}
@@ -2,14 +2,21 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "auth_user"
+const (
+	userContextKey  contextKey = "auth_user"
+	tokenContextKey contextKey = "auth_api_token"
+)
 
 // Middleware provides authentication middleware that validates session cookies.
 // When auth is disabled, it passes requests through without checking.
@@ -17,6 +24,14 @@ type Middleware struct {
 	repo        *storage.Repository
 	authEnabled bool
 	cookieName  string
+
+	// OPDS Authentication Document returned with Basic Auth challenges
+	authDoc atomic.Pointer[authDocument]
+}
+
+type authDocument struct {
+	url  string
+	body []byte
 }
 
 // NewMiddleware creates a new auth middleware.
@@ -38,8 +53,78 @@ func (m *Middleware) CookieName() string {
 	return m.cookieName
 }
 
-// RequireAuth is middleware that requires a valid session when auth is enabled.
-// When auth is disabled, requests pass through with no user in context.
+// SessionToken returns the session token of a request: the value of the
+// session cookie, or else a bearer token in the Authorization header, as
+// sent by command-line clients such as pushkinctl.
+func (m *Middleware) SessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie(m.cookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// userFromToken returns the user of a session token or an API token, and
+// the API token record for the latter. The user is nil if the token is not
+// valid.
+func (m *Middleware) userFromToken(token string) (*storage.User, *storage.APIToken) {
+	if strings.HasPrefix(token, storage.APITokenPrefix) {
+		apiToken, err := m.repo.GetAPIToken(token)
+		if err != nil || apiToken == nil {
+			return nil, nil
+		}
+		user, err := m.repo.GetUserByID(apiToken.UserID)
+		if err != nil || user == nil {
+			return nil, nil
+		}
+		return user, apiToken
+	}
+
+	session, err := m.repo.GetSession(token)
+	if err != nil || session == nil {
+		return nil, nil
+	}
+	user, err := m.repo.GetUserByID(session.UserID)
+	if err != nil || user == nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// userFromBasicAuth returns the user of Basic Auth credentials. An API
+// token is accepted as the password of its user, for e-readers that know
+// no other scheme.
+func (m *Middleware) userFromBasicAuth(username, password string) (*storage.User, *storage.APIToken) {
+	if strings.HasPrefix(password, storage.APITokenPrefix) {
+		user, apiToken := m.userFromToken(password)
+		if user == nil || user.Username != username {
+			return nil, nil
+		}
+		return user, apiToken
+	}
+	user, err := m.repo.AuthenticateUser(username, password)
+	if err != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// withUser returns r with the authenticated user, and the API token used,
+// in its context
+func withUser(r *http.Request, user *storage.User, apiToken *storage.APIToken) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	if apiToken != nil {
+		ctx = context.WithValue(ctx, tokenContextKey, apiToken)
+	}
+	return r.WithContext(ctx)
+}
+
+// RequireAuth is middleware that requires a valid session or API token when
+// auth is enabled. When auth is disabled, requests pass through with no user
+// in context.
 func (m *Middleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !m.authEnabled {
@@ -47,32 +132,26 @@ func (m *Middleware) RequireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		cookie, err := r.Cookie(m.cookieName)
-		if err != nil || cookie.Value == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		session, err := m.repo.GetSession(cookie.Value)
-		if err != nil || session == nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		token := m.SessionToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 			return
 		}
 
-		user, err := m.repo.GetUserByID(session.UserID)
-		if err != nil || user == nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		user, apiToken := m.userFromToken(token)
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withUser(r, user, apiToken))
 	})
 }
 
-// OptionalAuth is middleware that extracts user from session if present,
-// but does not reject unauthenticated requests. Use this for endpoints
-// that work both with and without auth.
+// OptionalAuth is middleware that extracts user from session or API token
+// if present, but does not reject unauthenticated requests. Use this for
+// endpoints that work both with and without auth. Basic Auth credentials are
+// accepted too, so that e-readers can follow links from OPDS feeds.
 func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !m.authEnabled {
@@ -80,16 +159,16 @@ func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		cookie, err := r.Cookie(m.cookieName)
-		if err == nil && cookie.Value != "" {
-			session, err := m.repo.GetSession(cookie.Value)
-			if err == nil && session != nil {
-				user, err := m.repo.GetUserByID(session.UserID)
-				if err == nil && user != nil {
-					ctx := context.WithValue(r.Context(), userContextKey, user)
-					r = r.WithContext(ctx)
-				}
-			}
+		var user *storage.User
+		var apiToken *storage.APIToken
+		if token := m.SessionToken(r); token != "" {
+			user, apiToken = m.userFromToken(token)
+		}
+		if username, password, ok := r.BasicAuth(); user == nil && ok && username != "" && password != "" {
+			user, apiToken = m.userFromBasicAuth(username, password)
+		}
+		if user != nil {
+			r = withUser(r, user, apiToken)
 		}
 
 		next.ServeHTTP(w, r)
@@ -106,11 +185,11 @@ func (m *Middleware) RequireAdmin(next http.Handler) http.Handler {
 
 		user := UserFromContext(r.Context())
 		if user == nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 			return
 		}
 		if !user.IsAdmin {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, "forbidden", "Forbidden")
 			return
 		}
 
@@ -118,6 +197,34 @@ func (m *Middleware) RequireAdmin(next http.Handler) http.Handler {
 	})
 }
 
+// RequireScope is middleware that rejects requests authenticated with an
+// API token that lacks scope. Sessions and Basic Auth passwords carry every
+// scope.
+func (m *Middleware) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiToken := TokenFromContext(r.Context()); m.authEnabled && apiToken != nil && !apiToken.HasScope(scope) {
+				writeError(w, http.StatusForbidden, "insufficient_scope",
+					fmt.Sprintf("The API token lacks the %s scope", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession is middleware that rejects requests authenticated with an
+// API token, so that a token can neither create nor revoke tokens.
+func (m *Middleware) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.authEnabled && TokenFromContext(r.Context()) != nil {
+			writeError(w, http.StatusForbidden, "forbidden", "Sign in with a password to manage API tokens")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // UserFromContext extracts the authenticated user from the request context.
 // Returns nil if no user is authenticated (auth disabled or no session).
 func UserFromContext(ctx context.Context) *storage.User {
@@ -125,6 +232,13 @@ func UserFromContext(ctx context.Context) *storage.User {
 	return user
 }
 
+// TokenFromContext returns the API token a request was authenticated with,
+// or nil for sessions, Basic Auth passwords and anonymous requests.
+func TokenFromContext(ctx context.Context) *storage.APIToken {
+	apiToken, _ := ctx.Value(tokenContextKey).(*storage.APIToken)
+	return apiToken
+}
+
 // UserIDFromContext returns the user ID from context, or empty string if no user.
 // Empty string is the correct value for no-auth mode (matches DB convention).
 func UserIDFromContext(ctx context.Context) string {
@@ -135,9 +249,43 @@ func UserIDFromContext(ctx context.Context) string {
 	return user.ID
 }
 
+// writeError writes an error in the same JSON envelope as the API handlers.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]map[string]string{
+		"error": {"code": code, "message": message},
+	})
+}
+
+// SetAuthDocument configures the OPDS Authentication Document advertised
+// in 401 responses from RequireBasicAuth. It is safe to call while requests
+// are served.
+func (m *Middleware) SetAuthDocument(url string, body []byte) {
+	m.authDoc.Store(&authDocument{url: url, body: body})
+}
+
+// basicAuthChallenge writes a 401 response asking for Basic Auth credentials.
+// When an Authentication Document is configured, it is linked and returned as the body.
+func (m *Middleware) basicAuthChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Pushkinlib OPDS"`)
+	doc := m.authDoc.Load()
+	if doc == nil || doc.url == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="http://opds-spec.org/auth/document"; type="application/opds-authentication+json"`, doc.url))
+	w.Header().Set("Content-Type", "application/opds-authentication+json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(doc.body)
+}
+
 // RequireBasicAuth is middleware that requires HTTP Basic Auth when auth is enabled.
 // This is designed for OPDS clients (e-readers) that support Basic Auth but not cookies.
-// Credentials are validated against the users table (same bcrypt passwords).
+// Credentials are validated against the users table (same bcrypt passwords); an
+// API token is accepted as the password, or as a bearer token for scripts.
 // When auth is disabled, requests pass through without checking.
 func (m *Middleware) RequireBasicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -146,21 +294,29 @@ func (m *Middleware) RequireBasicAuth(next http.Handler) http.Handler {
 			return
 		}
 
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if token = strings.TrimSpace(token); strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(token, storage.APITokenPrefix) {
+			user, apiToken := m.userFromToken(token)
+			if user == nil {
+				m.basicAuthChallenge(w)
+				return
+			}
+			next.ServeHTTP(w, withUser(r, user, apiToken))
+			return
+		}
+
 		username, password, ok := r.BasicAuth()
 		if !ok || username == "" || password == "" {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Pushkinlib OPDS"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			m.basicAuthChallenge(w)
 			return
 		}
 
-		user, err := m.repo.AuthenticateUser(username, password)
-		if err != nil || user == nil {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Pushkinlib OPDS"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		user, apiToken := m.userFromBasicAuth(username, password)
+		if user == nil {
+			m.basicAuthChallenge(w)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, withUser(r, user, apiToken))
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -61,6 +62,12 @@ func TestRequireAuth_NoCookie(t *testing.T) {
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("expected 401, got %d", w.Code)
 	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error, got Content-Type %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), `"code":"unauthorized"`) {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
 }
 
 // TestRequireAuth_InvalidSession returns 401 for invalid session token.
@@ -120,6 +127,47 @@ func TestRequireAuth_ValidSession(t *testing.T) {
 	}
 }
 
+// TestRequireAuth_BearerToken accepts the session token in the
+// Authorization header, as command-line clients send it.
+func TestRequireAuth_BearerToken(t *testing.T) {
+	repo := setupTestRepo(t)
+	mw := NewMiddleware(repo, true)
+
+	user, err := repo.CreateUser("cliuser", "password123", "CLI User", true)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	session, err := repo.CreateSession(user.ID, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	var ctxUser *storage.User
+	handler := mw.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxUser = UserFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for header, want := range map[string]int{
+		"Bearer " + session.Token: http.StatusOK,
+		"bearer " + session.Token: http.StatusOK,
+		"Bearer wrong-token":      http.StatusUnauthorized,
+		"Basic " + session.Token:  http.StatusUnauthorized,
+	} {
+		ctxUser = nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%q: expected %d, got %d", header, want, w.Code)
+		}
+		if want == http.StatusOK && (ctxUser == nil || ctxUser.Username != "cliuser") {
+			t.Errorf("%q: user not found in context", header)
+		}
+	}
+}
+
 // TestOptionalAuth_NoSession passes through without user.
 func TestOptionalAuth_NoSession(t *testing.T) {
 	repo := setupTestRepo(t)
@@ -331,6 +379,55 @@ func TestRequireBasicAuth_ValidCredentials(t *testing.T) {
 	}
 }
 
+// TestRequireBasicAuth_APIToken accepts an API token as a bearer token or
+// as the password of its user, and RequireScope checks its scopes.
+func TestRequireBasicAuth_APIToken(t *testing.T) {
+	repo := setupTestRepo(t)
+	mw := NewMiddleware(repo, true)
+
+	user, err := repo.CreateUser("bot", "botpass123", "Bot", false)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := repo.CreateUser("other", "otherpass", "Other", false); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	_, token, err := repo.CreateAPIToken(user.ID, "script", []string{storage.ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("failed to create api token: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		prepare func(*http.Request)
+		scope   string
+		want    int
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, storage.ScopeRead, http.StatusOK},
+		{"password", func(r *http.Request) { r.SetBasicAuth("bot", token) }, storage.ScopeRead, http.StatusOK},
+		{"password of another user", func(r *http.Request) { r.SetBasicAuth("other", token) }, storage.ScopeRead, http.StatusUnauthorized},
+		{"unknown token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer pkl_unknown") }, storage.ScopeRead, http.StatusUnauthorized},
+		{"missing scope", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, storage.ScopeDownload, http.StatusForbidden},
+		{"password has every scope", func(r *http.Request) { r.SetBasicAuth("bot", "botpass123") }, storage.ScopeDownload, http.StatusOK},
+	} {
+		handler := mw.RequireBasicAuth(mw.RequireScope(tc.scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u := UserFromContext(r.Context()); u == nil || u.ID != user.ID {
+				t.Errorf("%s: unexpected user %+v", tc.name, u)
+			}
+			w.WriteHeader(http.StatusOK)
+		})))
+
+		req := httptest.NewRequest("GET", "/opds/", nil)
+		tc.prepare(req)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+}
+
 // TestRequireBasicAuth_UnknownUser returns 401.
 func TestRequireBasicAuth_UnknownUser(t *testing.T) {
 	repo := setupTestRepo(t)
@@ -349,3 +446,34 @@ func TestRequireBasicAuth_UnknownUser(t *testing.T) {
 		t.Errorf("expected 401, got %d", w.Code)
 	}
 }
+
+// TestRequireBasicAuth_AuthDocument returns the configured Authentication Document with 401.
+func TestRequireBasicAuth_AuthDocument(t *testing.T) {
+	repo := setupTestRepo(t)
+	mw := NewMiddleware(repo, true)
+	mw.SetAuthDocument("http://example.com/opds/auth.json", []byte(`{"id":"doc"}`))
+
+	handler := mw.RequireBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	req := httptest.NewRequest("GET", "/opds", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/opds-authentication+json" {
+		t.Errorf("expected authentication document content type, got %q", ct)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, "http://opds-spec.org/auth/document") {
+		t.Errorf("expected auth document link, got %q", link)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+	if w.Body.String() != `{"id":"doc"}` {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
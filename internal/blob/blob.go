@@ -0,0 +1,86 @@
+// Package blob stores cache artifacts, such as cover thumbnails, on local
+// disk or in S3-compatible object storage, so that containers can run
+// without persistent volumes.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for a missing key.
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored blob.
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Store keeps blobs under slash-separated keys such as "ab/abcdef.jpg".
+type Store interface {
+	// Get opens the blob stored under key, or returns ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	// Put stores data under key, replacing any existing blob.
+	Put(ctx context.Context, key string, data []byte) error
+	// Delete removes the blob; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Lister is implemented by stores that can enumerate their blobs.
+type Lister interface {
+	// List calls fn for every blob, in no particular order, until fn
+	// returns an error.
+	List(ctx context.Context, fn func(key string, info Info) error) error
+}
+
+// prefixLister is implemented by stores that can list the blobs under a
+// prefix without enumerating the others, such as S3.
+type prefixLister interface {
+	ListPrefix(ctx context.Context, prefix string, fn func(key string, info Info) error) error
+}
+
+// Sub returns a view of store whose keys are prefixed with prefix, e.g.
+// "covers/".
+func Sub(store Store, prefix string) Store {
+	return &subStore{store: store, prefix: prefix}
+}
+
+type subStore struct {
+	store  Store
+	prefix string
+}
+
+func (s *subStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	return s.store.Get(ctx, s.prefix+key)
+}
+
+func (s *subStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.store.Put(ctx, s.prefix+key, data)
+}
+
+func (s *subStore) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, s.prefix+key)
+}
+
+// List lists the blobs under the prefix, if the store can list.
+func (s *subStore) List(ctx context.Context, fn func(key string, info Info) error) error {
+	if lister, ok := s.store.(prefixLister); ok {
+		return lister.ListPrefix(ctx, s.prefix, func(key string, info Info) error {
+			return fn(strings.TrimPrefix(key, s.prefix), info)
+		})
+	}
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return errors.New("blob store cannot list")
+	}
+	return lister.List(ctx, func(key string, info Info) error {
+		if rest, ok := strings.CutPrefix(key, s.prefix); ok {
+			return fn(rest, info)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores blobs as files below a directory.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a store rooted at dir.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Path returns the file of key. Keys that would escape the directory are
+// rejected.
+func (l *Local) Path(key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(l.dir, rel), nil
+}
+
+// Get opens the file of key.
+func (l *Local) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	path, err := l.Path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, Info{}, ErrNotFound
+		}
+		return nil, Info{}, fmt.Errorf("open blob: %w", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, fmt.Errorf("stat blob: %w", err)
+	}
+	return f, Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Put writes the file of key through a temporary file, so that readers
+// never see a partial blob.
+func (l *Local) Put(ctx context.Context, key string, data []byte) error {
+	path, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write blob: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file of key.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete blob: %w", err)
+	}
+	return nil
+}
+
+// List walks the directory. Temporary files of unfinished writes are
+// skipped.
+func (l *Local) List(ctx context.Context, fn func(key string, info Info) error) error {
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), Info{Size: stat.Size(), ModTime: stat.ModTime()})
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list blobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds a single request to the object storage
+const s3Timeout = 30 * time.Second
+
+// S3Config configures an S3-compatible object storage (AWS S3, MinIO, ...).
+type S3Config struct {
+	// Endpoint is the base URL, e.g. https://s3.eu-central-1.amazonaws.com
+	// or http://minio:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every key, e.g. "pushkinlib/".
+	Prefix string
+	// PathStyle addresses the bucket as endpoint/bucket instead of
+	// bucket.endpoint; MinIO needs it.
+	PathStyle bool
+}
+
+// S3 stores blobs as objects of a bucket. Requests are signed with AWS
+// Signature Version 4.
+type S3 struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 creates a store for the bucket in cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 endpoint and bucket are required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3 access key and secret key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if !cfg.PathStyle {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+
+	return &S3{
+		cfg:    cfg,
+		base:   base,
+		client: &http.Client{Timeout: s3Timeout},
+		now:    time.Now,
+	}, nil
+}
+
+// Get downloads the object of key. Objects are read into memory: cached
+// artifacts are small and callers need to seek.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, Info{}, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, Info{}, s3Error("get", resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("read blob: %w", err)
+	}
+	info := Info{Size: int64(len(data))}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return nopCloser{bytes.NewReader(data)}, info, nil
+}
+
+// Put uploads data as the object of key.
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", resp)
+	}
+	return nil
+}
+
+// Delete removes the object of key.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("delete", resp)
+	}
+	return nil
+}
+
+// List lists the objects under Prefix.
+func (s *S3) List(ctx context.Context, fn func(key string, info Info) error) error {
+	return s.ListPrefix(ctx, "", fn)
+}
+
+// ListPrefix lists the objects whose keys start with prefix, a page of
+// ListObjectsV2 at a time.
+func (s *S3) ListPrefix(ctx context.Context, prefix string, fn func(key string, info Info) error) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+	for {
+		resp, err := s.send(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error("list", resp)
+		} else if err = xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+			err = fmt.Errorf("read S3 listing: %w", err)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			key, ok := strings.CutPrefix(object.Key, s.cfg.Prefix)
+			if !ok || strings.HasSuffix(key, "/") {
+				continue
+			}
+			if err := fn(key, Info{Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	return s.send(ctx, method, s.cfg.Prefix+key, nil, body)
+}
+
+// send signs and sends a request for the object at path within the
+// bucket; an empty path addresses the bucket itself
+func (s *S3) send(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.base
+	path = "/" + path
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket + path
+	}
+	u.Path = strings.TrimSuffix(s.base.Path, "/") + path
+	// S3 expects every segment strictly encoded; Go leaves e.g. "(" as is
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	u.RawPath = strings.Join(segments, "/")
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build S3 request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	signV4(req, body, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+func s3Error(op string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s failed: %s: %s", op, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// signV4 adds the AWS Signature Version 4 headers to req. Host and the
+// headers already set on req are signed.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,228 @@
+// Package calibre reads the book list of a Calibre library from its
+// metadata.db.
+package calibre
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MetadataFile is the name of the Calibre database in a library directory
+const MetadataFile = "metadata.db"
+
+// Book is a book of a Calibre library
+type Book struct {
+	ID    int
+	Title string
+	// Authors are named "Last First", as Calibre sorts them
+	Authors   []string
+	Series    string
+	SeriesNum int
+	Tags      []string
+	// Language is a two-letter code where one exists, e.g. "ru"
+	Language string
+	Year     int
+	Added    time.Time
+	// Comments is the description of the book, usually HTML
+	Comments string
+	// Formats maps lowercase formats such as "epub" to the book files
+	Formats map[string]string
+	// CoverPath is the cover image of the book, or "" if it has none
+	CoverPath string
+}
+
+// languageCodes maps the ISO 639-2 codes Calibre stores to the two-letter
+// codes of INPX catalogs
+var languageCodes = map[string]string{
+	"rus": "ru", "eng": "en", "ukr": "uk", "bel": "be", "deu": "de", "ger": "de",
+	"fra": "fr", "fre": "fr", "spa": "es", "ita": "it", "pol": "pl", "ces": "cs",
+	"cze": "cs", "bul": "bg", "srp": "sr", "por": "pt", "nld": "nl", "dut": "nl",
+	"swe": "sv", "fin": "fi", "jpn": "ja", "zho": "zh", "chi": "zh", "heb": "he",
+	"lat": "la", "kaz": "kk", "tat": "tt", "lit": "lt", "lav": "lv", "est": "et",
+}
+
+// ReadLibrary reads the books of the Calibre library in dir. The database
+// is opened read-only, so the library may stay in use by Calibre.
+func ReadLibrary(dir string) ([]Book, error) {
+	dbPath := filepath.Join(dir, MetadataFile)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("not a Calibre library: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_query_only=1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dbPath, err)
+	}
+	defer db.Close()
+
+	books, byID, err := readBooks(db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Linked values are read table by table rather than book by book
+	if err := eachRow(db, `SELECT l.book, a.name, a.sort FROM books_authors_link l
+		JOIN authors a ON a.id = l.author ORDER BY l.id`, func(book *libraryBook, values []string) {
+		book.Authors = append(book.Authors, authorName(values[0], values[1]))
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read authors: %w", err)
+	}
+	if err := eachRow(db, `SELECT l.book, s.name FROM books_series_link l
+		JOIN series s ON s.id = l.series`, func(book *libraryBook, values []string) {
+		book.Series = values[0]
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read series: %w", err)
+	}
+	if err := eachRow(db, `SELECT l.book, t.name FROM books_tags_link l
+		JOIN tags t ON t.id = l.tag ORDER BY l.id`, func(book *libraryBook, values []string) {
+		book.Tags = append(book.Tags, values[0])
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+	if err := eachRow(db, `SELECT l.book, lang.lang_code FROM books_languages_link l
+		JOIN languages lang ON lang.id = l.lang_code ORDER BY l.item_order DESC`, func(book *libraryBook, values []string) {
+		// The first language comes last and wins
+		book.Language = languageCode(values[0])
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read languages: %w", err)
+	}
+	if err := eachRow(db, `SELECT book, text FROM comments`, func(book *libraryBook, values []string) {
+		book.Comments = values[0]
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read comments: %w", err)
+	}
+	if err := eachRow(db, `SELECT book, format, name FROM data`, func(book *libraryBook, values []string) {
+		format := strings.ToLower(values[0])
+		book.Formats[format] = filepath.Join(book.dir, values[1]+"."+format)
+	}, byID); err != nil {
+		return nil, fmt.Errorf("failed to read formats: %w", err)
+	}
+
+	result := make([]Book, len(books))
+	for i, book := range books {
+		result[i] = book.Book
+	}
+	return result, nil
+}
+
+// libraryBook is a book with its directory in the library
+type libraryBook struct {
+	Book
+	dir string
+}
+
+// readBooks reads the books table
+func readBooks(db *sql.DB, dir string) ([]*libraryBook, map[int]*libraryBook, error) {
+	rows, err := db.Query(`SELECT id, title, path, has_cover, CAST(timestamp AS TEXT),
+		CAST(pubdate AS TEXT), series_index FROM books ORDER BY id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read books: %w", err)
+	}
+	defer rows.Close()
+
+	var books []*libraryBook
+	byID := make(map[int]*libraryBook)
+	for rows.Next() {
+		var (
+			book             libraryBook
+			path             string
+			hasCover         sql.NullBool
+			added, published sql.NullString
+			seriesIndex      sql.NullFloat64
+		)
+		if err := rows.Scan(&book.ID, &book.Title, &path, &hasCover, &added, &published, &seriesIndex); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan book: %w", err)
+		}
+		book.dir = filepath.Join(dir, filepath.FromSlash(path))
+		book.Formats = make(map[string]string)
+		book.Added = parseTime(added.String)
+		// Calibre stores 0101-01-01 for an unknown date
+		if year := parseTime(published.String).Year(); year >= 1000 {
+			book.Year = year
+		}
+		book.SeriesNum = int(seriesIndex.Float64)
+		if hasCover.Bool {
+			book.CoverPath = filepath.Join(book.dir, "cover.jpg")
+		}
+		books = append(books, &book)
+		byID[book.ID] = &book
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating books: %w", err)
+	}
+	return books, byID, nil
+}
+
+// eachRow calls fn with the book of each row, whose first column is the
+// book ID, and the other columns
+func eachRow(db *sql.DB, query string, fn func(*libraryBook, []string), books map[int]*libraryBook) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var bookID int
+	values := make([]sql.NullString, len(columns)-1)
+	dest := []interface{}{&bookID}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		book := books[bookID]
+		if book == nil {
+			continue
+		}
+		strs := make([]string, len(values))
+		for i, v := range values {
+			strs[i] = v.String
+		}
+		fn(book, strs)
+	}
+	return rows.Err()
+}
+
+// authorName turns the sort form of an author, "Last, First", into the
+// "Last First" of INPX catalogs
+func authorName(name, sort string) string {
+	if sort == "" {
+		return strings.TrimSpace(name)
+	}
+	return strings.Join(strings.Fields(strings.ReplaceAll(sort, ",", " ")), " ")
+}
+
+// languageCode returns the two-letter code of an ISO 639-2 language code
+func languageCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if short, ok := languageCodes[code]; ok {
+		return short
+	}
+	return code
+}
+
+// parseTime parses the timestamps of metadata.db, or returns the zero time
+func parseTime(s string) time.Time {
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02T15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,203 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/calibre"
+	"github.com/piligrim/pushkinlib/internal/metadata"
+)
+
+// calibreAnnotationLength caps annotations like the metadata extractor does
+const calibreAnnotationLength = 1000
+
+// generateCalibre builds a catalog from the metadata.db of a Calibre library
+// in BooksDir instead of reading the book files. Every file of a book in
+// IncludeFormats is cataloged; the first in order of preference keeps the
+// Calibre ID. The covers are listed in the result for the caller to import.
+func (g *Generator) generateCalibre(opts GenerateOptions, result *GenerationResult, startTime time.Time) (*GenerationResult, error) {
+	fmt.Printf("Reading Calibre library: %s\n", opts.BooksDir)
+	books, err := calibre.ReadLibrary(opts.BooksDir)
+	if err != nil {
+		return nil, err
+	}
+	result.TotalBooks = len(books)
+	fmt.Printf("Found %d books\n", result.TotalBooks)
+
+	var allMetadata []*metadata.BookMetadata
+	result.Covers = make(map[string]string)
+	for i := range books {
+		book := &books[i]
+		metas, err := calibreMetadata(book, opts.IncludeFormats, opts.GenreAliases)
+		if err != nil {
+			result.Errors = append(result.Errors, FileError{Path: fmt.Sprintf("%s (Calibre book %d)", book.Title, book.ID), Message: err.Error()})
+			result.SkippedBooks++
+			continue
+		}
+		for _, meta := range metas {
+			if book.CoverPath != "" {
+				result.Covers[meta.ID] = book.CoverPath
+			}
+			allMetadata = append(allMetadata, meta)
+		}
+		result.ProcessedBooks++
+	}
+
+	fmt.Printf("Successfully read %d books\n", result.ProcessedBooks)
+
+	if opts.DryRun || len(allMetadata) == 0 {
+		result.ProcessingTime = time.Since(startTime)
+		if opts.DryRun {
+			fmt.Println("Dry run: skipping archive and INPX generation")
+		}
+		return result, nil
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	removeStaleOutputs(opts.OutputDir)
+	outputs := &outputSet{}
+	defer outputs.abort()
+
+	fmt.Println("Creating book archives...")
+	zipPaths, err := g.createBookArchives(allMetadata, opts, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create book archives: %w", err)
+	}
+	result.GeneratedZips = zipPaths
+
+	fmt.Println("Generating INPX file...")
+	inpxPath, collectionInfo, err := g.generateINPX(allMetadata, opts, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate INPX: %w", err)
+	}
+	if err := outputs.commit(); err != nil {
+		return nil, err
+	}
+
+	result.INPXPath = inpxPath
+	result.CollectionInfo = collectionInfo
+	result.ProcessingTime = time.Since(startTime)
+
+	fmt.Printf("Catalog generation completed in %v\n", result.ProcessingTime)
+	fmt.Printf("Generated INPX: %s\n", inpxPath)
+	fmt.Printf("Generated %d archives\n", len(zipPaths))
+
+	return result, nil
+}
+
+// calibreMetadata converts a Calibre book to catalog metadata, one entry
+// per file in includeFormats. The file of the first format keeps the
+// Calibre ID, the others get "<id>-<format>". Tags are mapped to genre codes
+// with aliases, which are keyed by lowercase alias.
+func calibreMetadata(book *calibre.Book, includeFormats []string, aliases map[string]string) ([]*metadata.BookMetadata, error) {
+	type bookFile struct {
+		format string
+		info   os.FileInfo
+	}
+	var (
+		files   []bookFile
+		missing error
+	)
+	seen := make(map[string]bool, len(includeFormats))
+	for _, ext := range includeFormats {
+		format := strings.TrimPrefix(ext, ".")
+		path, ok := book.Formats[format]
+		if !ok || seen[format] {
+			continue
+		}
+		seen[format] = true
+		stat, err := os.Stat(path)
+		if err != nil {
+			missing = err
+			continue
+		}
+		files = append(files, bookFile{format: format, info: stat})
+	}
+	if len(files) == 0 {
+		if missing != nil {
+			return nil, fmt.Errorf("missing book file: %w", missing)
+		}
+		return nil, fmt.Errorf("no file in formats %s", strings.Join(includeFormats, ", "))
+	}
+
+	// Authors and genres are separated by commas and colons in INP lines,
+	// which cannot hold line breaks
+	authors := make([]string, 0, len(book.Authors))
+	for _, author := range book.Authors {
+		if author = inpValue(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	genres := calibreGenres(book.Tags, aliases)
+	annotation := inpText(metadata.AnnotationText(book.Comments))
+	if runes := []rune(annotation); len(runes) > calibreAnnotationLength {
+		annotation = string(runes[:calibreAnnotationLength]) + "..."
+	}
+
+	metas := make([]*metadata.BookMetadata, 0, len(files))
+	for i, file := range files {
+		id := strconv.Itoa(book.ID)
+		if i > 0 {
+			id += "-" + file.format
+		}
+		date := book.Added
+		if date.IsZero() {
+			date = file.info.ModTime()
+		}
+		path := book.Formats[file.format]
+		metas = append(metas, &metadata.BookMetadata{
+			ID:         id,
+			Title:      inpText(book.Title),
+			Authors:    authors,
+			Series:     inpText(book.Series),
+			SeriesNum:  book.SeriesNum,
+			Genres:     genres,
+			Year:       book.Year,
+			Language:   book.Language,
+			Annotation: annotation,
+			Date:       date,
+			FilePath:   path,
+			FileName:   filepath.Base(path),
+			FileSize:   file.info.Size(),
+			Format:     file.format,
+		})
+	}
+	return metas, nil
+}
+
+// calibreGenres maps Calibre tags to genre codes with aliases; tags without
+// an alias are kept as they are
+func calibreGenres(tags []string, aliases map[string]string) []string {
+	genres := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if code, ok := aliases[strings.ToLower(strings.TrimSpace(tag))]; ok {
+			tag = code
+		}
+		if tag = inpValue(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			genres = append(genres, tag)
+		}
+	}
+	if len(genres) == 0 {
+		genres = []string{"unknown"}
+	}
+	return genres
+}
+
+// inpText makes text safe for an INP field, collapsing white space
+func inpText(s string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, "\x04", " ")), " ")
+}
+
+// inpValue makes a name safe for a comma or colon separated INP field
+func inpValue(s string) string {
+	return inpText(strings.NewReplacer(",", " ", ":", " ").Replace(s))
+}
@@ -0,0 +1,168 @@
+package catalog
+
+import (
+	"archive/zip"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/calibre"
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// calibreSchema is the part of the Calibre metadata.db schema that is read
+const calibreSchema = `
+CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, path TEXT, has_cover BOOL DEFAULT 0,
+	timestamp TIMESTAMP, pubdate TIMESTAMP, series_index REAL DEFAULT 1.0);
+CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT, sort TEXT);
+CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER);
+CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER, series INTEGER);
+CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER, tag INTEGER);
+CREATE TABLE languages (id INTEGER PRIMARY KEY, lang_code TEXT);
+CREATE TABLE books_languages_link (id INTEGER PRIMARY KEY, book INTEGER, lang_code INTEGER, item_order INTEGER);
+CREATE TABLE comments (id INTEGER PRIMARY KEY, book INTEGER, text TEXT);
+CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT, name TEXT);
+
+INSERT INTO books VALUES
+	(7, 'Война и мир', 'Lev Tolstoi/Voina i mir (7)', 1, '2024-03-01 10:00:00.123456+00:00', '1869-01-01 00:00:00+00:00', 2.0),
+	(8, 'Только PDF', 'Lev Tolstoi/Tolko PDF (8)', 0, '2024-03-02 10:00:00+00:00', '0101-01-01 00:00:00+00:00', 1.0);
+INSERT INTO authors VALUES (1, 'Лев Толстой', 'Толстой, Лев'), (2, 'Иван Петров', 'Петров, Иван');
+INSERT INTO books_authors_link VALUES (1, 7, 1), (2, 7, 2), (3, 8, 1);
+INSERT INTO series VALUES (1, 'Эпопеи');
+INSERT INTO books_series_link VALUES (1, 7, 1);
+INSERT INTO tags VALUES (1, 'Классика'), (2, 'prose_history');
+INSERT INTO books_tags_link VALUES (1, 7, 1), (2, 7, 2);
+INSERT INTO languages VALUES (1, 'rus'), (2, 'eng');
+INSERT INTO books_languages_link VALUES (1, 7, 2, 1), (2, 7, 1, 0);
+INSERT INTO comments VALUES (1, 7, '<p>Первая часть.</p><p>Вторая часть.</p>');
+INSERT INTO data VALUES (1, 7, 'EPUB', 'Voina i mir - Lev Tolstoi'), (2, 7, 'FB2', 'Voina i mir - Lev Tolstoi'),
+	(3, 8, 'PDF', 'Tolko PDF - Lev Tolstoi');
+`
+
+// writeCalibreLibrary creates a Calibre library with a book in FB2 and EPUB
+// and a book only in PDF
+func writeCalibreLibrary(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"Lev Tolstoi/Voina i mir (7)/Voina i mir - Lev Tolstoi.fb2":  testFB2,
+		"Lev Tolstoi/Voina i mir (7)/Voina i mir - Lev Tolstoi.epub": "epub",
+		"Lev Tolstoi/Voina i mir (7)/cover.jpg":                      "jpeg",
+		"Lev Tolstoi/Tolko PDF (8)/Tolko PDF - Lev Tolstoi.pdf":      "pdf",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatalf("failed to create metadata.db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(calibreSchema); err != nil {
+		t.Fatalf("failed to fill metadata.db: %v", err)
+	}
+	return dir
+}
+
+// TestGenerate_Calibre verifies a Calibre library is cataloged with its
+// metadata, Calibre IDs, tags mapped to genres, every format and covers.
+func TestGenerate_Calibre(t *testing.T) {
+	libraryDir := writeCalibreLibrary(t)
+	outputDir := t.TempDir()
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:       libraryDir,
+		OutputDir:      outputDir,
+		CatalogName:    "calibre",
+		ArchivePrefix:  "calibre",
+		IncludeFormats: []string{".fb2", ".epub"},
+		Calibre:        true,
+		GenreAliases:   map[string]string{"классика": "prose_classic"},
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if result.TotalBooks != 2 || result.ProcessedBooks != 1 || result.SkippedBooks != 1 {
+		t.Fatalf("expected 2 books with 1 processed, got %d/%d/%d (errors: %v)",
+			result.TotalBooks, result.ProcessedBooks, result.SkippedBooks, result.Errors)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Path, "Только PDF") {
+		t.Errorf("expected the PDF-only book to be reported, got %v", result.Errors)
+	}
+	wantCover := filepath.Join(libraryDir, "Lev Tolstoi", "Voina i mir (7)", "cover.jpg")
+	if len(result.Covers) != 2 || result.Covers["7"] != wantCover || result.Covers["7-epub"] != wantCover {
+		t.Errorf("unexpected covers %v", result.Covers)
+	}
+
+	books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+	if err != nil {
+		t.Fatalf("failed to parse generated INPX: %v", err)
+	}
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books in INPX, got %d", len(books))
+	}
+	book := books[0]
+	if book.ID != "7" || book.FileNum != "7" || book.Format != "fb2" {
+		t.Errorf("expected Calibre ID and FB2 file, got id=%s file=%s format=%s", book.ID, book.FileNum, book.Format)
+	}
+	if epub := books[1]; epub.ID != "7-epub" || epub.Format != "epub" || epub.Title != book.Title {
+		t.Errorf("expected the EPUB file as book 7-epub, got id=%s format=%s title=%q", epub.ID, epub.Format, epub.Title)
+	}
+	if book.Title != "Война и мир" || strings.Join(book.Authors, "|") != "Толстой Лев|Петров Иван" {
+		t.Errorf("unexpected title %q or authors %v", book.Title, book.Authors)
+	}
+	if book.Series != "Эпопеи" || book.SeriesNum != 2 || book.Language != "ru" {
+		t.Errorf("unexpected series %q #%d or language %q", book.Series, book.SeriesNum, book.Language)
+	}
+	if book.Genre != "prose_classic,prose_history" {
+		t.Errorf("unexpected genres %q", book.Genre)
+	}
+	if book.Annotation != "Первая часть. Вторая часть." {
+		t.Errorf("unexpected annotation %q", book.Annotation)
+	}
+	if got := book.Date.Format("2006-01-02"); got != "2024-03-01" {
+		t.Errorf("expected the date added to Calibre, got %s", got)
+	}
+
+	archive, err := zip.OpenReader(filepath.Join(outputDir, book.ArchivePath+".zip"))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer archive.Close()
+	if len(archive.File) != 2 || archive.File[0].Name != "7.fb2" || archive.File[1].Name != "7-epub.epub" {
+		t.Errorf("expected the archive to hold 7.fb2 and 7-epub.epub, got %v", archive.File)
+	}
+}
+
+// TestCalibreMetadata_NoLanguage checks that books without a language in
+// Calibre get none and that unmapped tags are kept.
+func TestCalibreMetadata_NoLanguage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	if err := os.WriteFile(path, []byte("pdf"), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+	book := &calibre.Book{ID: 8, Title: "Только PDF", Tags: []string{"Фэнтези", "Fantasy"}, Formats: map[string]string{"pdf": path}}
+
+	metas, err := calibreMetadata(book, []string{".pdf"}, map[string]string{"fantasy": "sf_fantasy"})
+	if err != nil {
+		t.Fatalf("calibreMetadata failed: %v", err)
+	}
+	if len(metas) != 1 || metas[0].Language != "" {
+		t.Fatalf("expected one book without language, got %+v", metas)
+	}
+	if got := strings.Join(metas[0].Genres, ","); got != "Фэнтези,sf_fantasy" {
+		t.Errorf("unexpected genres %q", got)
+	}
+}
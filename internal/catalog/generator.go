@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/piligrim/pushkinlib/internal/inpx"
 	"github.com/piligrim/pushkinlib/internal/metadata"
 )
 
@@ -34,26 +35,59 @@ type GenerateOptions struct {
 	ArchivePrefix  string
 	MaxBooksPerZip int
 	IncludeFormats []string
+	// Layout chooses how books are split into archives: LayoutSize (the
+	// default), LayoutGenre or LayoutAuthor
+	Layout string
+	// DryRun scans and extracts metadata without writing archives or INPX
+	DryRun bool
+	// ReferenceMode indexes existing ZIP archives in place and writes only the INPX
+	ReferenceMode bool
+	// Strict skips FB2 files that fail validation; see metadata.ValidateFB2
+	Strict bool
+	// GenreCodes are the lowercase genre codes accepted in strict mode and
+	// by Validate; when empty, genre codes are not checked
+	GenreCodes map[string]bool
+	// Calibre reads the books from the metadata.db of the Calibre library in
+	// BooksDir; books keep their Calibre IDs
+	Calibre bool
+	// GenreAliases maps lowercase Calibre tags to genre codes, see
+	// metadata.LoadGenreAliases
+	GenreAliases map[string]string
 }
 
 // GenerationResult contains results of catalog generation
 type GenerationResult struct {
-	TotalBooks     int
-	ProcessedBooks int
-	SkippedBooks   int
-	GeneratedZips  []string
-	INPXPath       string
-	CollectionInfo CollectionInfo
-	ProcessingTime time.Duration
-	Errors         []error
+	DryRun         bool           `json:"dry_run"`
+	TotalBooks     int            `json:"total_books"`
+	ProcessedBooks int            `json:"processed_books"`
+	SkippedBooks   int            `json:"skipped_books"`
+	GeneratedZips  []string       `json:"generated_zips"`
+	ReferencedZips []string       `json:"referenced_zips,omitempty"`
+	INPXPath       string         `json:"inpx_path"`
+	CollectionInfo CollectionInfo `json:"collection_info"`
+	ProcessingTime time.Duration  `json:"-"`
+	Errors         []FileError    `json:"errors"`
+	// Covers maps book IDs to cover images, for Calibre libraries
+	Covers map[string]string `json:"-"`
 }
 
 // CollectionInfo represents collection metadata
 type CollectionInfo struct {
-	Name        string
-	Version     string
-	Description string
-	Date        string
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Date        string `json:"date"`
+}
+
+// FileError describes a book file that could not be processed
+type FileError struct {
+	Path    string `json:"path"`
+	Message string `json:"error"`
+}
+
+// Error implements the error interface
+func (e FileError) Error() string {
+	return fmt.Sprintf("failed to extract metadata from %s: %s", e.Path, e.Message)
 }
 
 // Generate creates INPX catalog from books directory
@@ -70,14 +104,34 @@ func (g *Generator) Generate(opts GenerateOptions) (*GenerationResult, error) {
 	if opts.ArchivePrefix == "" {
 		opts.ArchivePrefix = "books"
 	}
+	if opts.Layout == "" {
+		opts.Layout = LayoutSize
+	}
+	if !IsValidLayout(opts.Layout) {
+		return nil, fmt.Errorf("unknown archive layout %q (want one of %s)", opts.Layout, strings.Join(Layouts, ", "))
+	}
 
 	result := &GenerationResult{
+		DryRun:         opts.DryRun,
 		ProcessingTime: time.Since(startTime),
 	}
 
+	if opts.Strict {
+		g.extractor.SetStrict(opts.GenreCodes)
+	}
+
+	if opts.ReferenceMode {
+		return g.generateReference(opts, result, startTime)
+	}
+	if opts.Calibre {
+		return g.generateCalibre(opts, result, startTime)
+	}
+
 	// Create output directory
-	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	if !opts.DryRun {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
 	}
 
 	// Scan books directory
@@ -104,7 +158,7 @@ func (g *Generator) Generate(opts GenerateOptions) (*GenerationResult, error) {
 
 		meta, err := g.extractor.ExtractFromFile(filePath)
 		if err != nil {
-			result.Errors = append(result.Errors, fmt.Errorf("failed to extract metadata from %s: %w", filePath, err))
+			result.Errors = append(result.Errors, FileError{Path: filePath, Message: err.Error()})
 			result.SkippedBooks++
 			continue
 		}
@@ -115,9 +169,20 @@ func (g *Generator) Generate(opts GenerateOptions) (*GenerationResult, error) {
 
 	fmt.Printf("Successfully extracted metadata from %d books\n", result.ProcessedBooks)
 
+	if opts.DryRun {
+		result.ProcessingTime = time.Since(startTime)
+		fmt.Println("Dry run: skipping archive and INPX generation")
+		return result, nil
+	}
+
+	// Archives and INPX appear under their names only once all are written
+	removeStaleOutputs(opts.OutputDir)
+	outputs := &outputSet{}
+	defer outputs.abort()
+
 	// Create book archives
 	fmt.Println("Creating book archives...")
-	zipPaths, err := g.createBookArchives(allMetadata, opts)
+	zipPaths, err := g.createBookArchives(allMetadata, opts, outputs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create book archives: %w", err)
 	}
@@ -126,10 +191,13 @@ func (g *Generator) Generate(opts GenerateOptions) (*GenerationResult, error) {
 
 	// Generate INPX
 	fmt.Println("Generating INPX file...")
-	inpxPath, collectionInfo, err := g.generateINPX(allMetadata, opts)
+	inpxPath, collectionInfo, err := g.generateINPX(allMetadata, opts, outputs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate INPX: %w", err)
 	}
+	if err := outputs.commit(); err != nil {
+		return nil, err
+	}
 
 	result.INPXPath = inpxPath
 	result.CollectionInfo = collectionInfo
@@ -169,41 +237,52 @@ func (g *Generator) scanBooksDirectory(dir string, includeFormats []string) ([]s
 	return bookFiles, err
 }
 
-// createBookArchives creates ZIP archives with books
-func (g *Generator) createBookArchives(allMetadata []*metadata.BookMetadata, opts GenerateOptions) ([]string, error) {
+// createBookArchives creates ZIP archives with books, as files of outputs
+func (g *Generator) createBookArchives(allMetadata []*metadata.BookMetadata, opts GenerateOptions, outputs *outputSet) ([]string, error) {
 	var zipPaths []string
 
-	// Sort metadata by title for consistent ordering
-	sort.Slice(allMetadata, func(i, j int) bool {
+	// Sort metadata by archive group, then by title for consistent ordering
+	groups := make(map[*metadata.BookMetadata]string, len(allMetadata))
+	for _, meta := range allMetadata {
+		groups[meta] = archiveGroup(meta, opts.Layout)
+	}
+	sort.SliceStable(allMetadata, func(i, j int) bool {
+		if gi, gj := groups[allMetadata[i]], groups[allMetadata[j]]; gi != gj {
+			return gi < gj
+		}
 		return allMetadata[i].Title < allMetadata[j].Title
 	})
 
 	currentZip := 0
 	currentBooks := 0
+	currentGroup := ""
 
 	var currentZipWriter *zip.Writer
 	var currentZipFile *os.File
 	var currentZipPath string
 
 	for i, meta := range allMetadata {
-		// Start new archive if needed
+		// Start new archive if needed; numbering restarts in each group
+		if group := groups[meta]; group != currentGroup {
+			currentGroup, currentZip, currentBooks = group, 0, 0
+		}
 		if currentBooks == 0 || currentBooks >= opts.MaxBooksPerZip {
 			// Close previous archive
 			if currentZipWriter != nil {
 				if err := currentZipWriter.Close(); err != nil {
 					return nil, fmt.Errorf("failed to finalize zip archive %s: %w", currentZipPath, err)
 				}
-				if err := currentZipFile.Close(); err != nil {
+				if err := outputs.close(currentZipFile); err != nil {
 					return nil, fmt.Errorf("failed to close zip file %s: %w", currentZipPath, err)
 				}
 			}
 
 			// Create new archive
 			currentZip++
-			currentZipPath = filepath.Join(opts.OutputDir, fmt.Sprintf("%s-%06d.zip", opts.ArchivePrefix, currentZip))
+			currentZipPath = filepath.Join(opts.OutputDir, archiveName(opts.ArchivePrefix, currentGroup, currentZip))
 
 			var err error
-			currentZipFile, err = os.Create(currentZipPath)
+			currentZipFile, err = outputs.create(currentZipPath)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create zip file %s: %w", currentZipPath, err)
 			}
@@ -212,11 +291,14 @@ func (g *Generator) createBookArchives(allMetadata []*metadata.BookMetadata, opt
 			zipPaths = append(zipPaths, currentZipPath)
 			currentBooks = 0
 
-			fmt.Printf("Creating archive %d: %s\n", currentZip, filepath.Base(currentZipPath))
+			fmt.Printf("Creating archive %d: %s\n", len(zipPaths), filepath.Base(currentZipPath))
 		}
 
 		// Add book to archive
 		bookID := fmt.Sprintf("%06d", i+1)
+		if opts.Calibre {
+			bookID = meta.ID
+		}
 		fileName := bookID + "." + meta.Format
 
 		// Update metadata with archive info
@@ -237,7 +319,7 @@ func (g *Generator) createBookArchives(allMetadata []*metadata.BookMetadata, opt
 		if err := currentZipWriter.Close(); err != nil {
 			return nil, fmt.Errorf("failed to finalize zip archive %s: %w", currentZipPath, err)
 		}
-		if err := currentZipFile.Close(); err != nil {
+		if err := outputs.close(currentZipFile); err != nil {
 			return nil, fmt.Errorf("failed to close zip file %s: %w", currentZipPath, err)
 		}
 	}
@@ -269,8 +351,8 @@ func (g *Generator) addBookToZip(zipWriter *zip.Writer, meta *metadata.BookMetad
 	return nil
 }
 
-// generateINPX creates INPX file with all metadata
-func (g *Generator) generateINPX(allMetadata []*metadata.BookMetadata, opts GenerateOptions) (string, CollectionInfo, error) {
+// generateINPX creates INPX file with all metadata, as a file of outputs
+func (g *Generator) generateINPX(allMetadata []*metadata.BookMetadata, opts GenerateOptions, outputs *outputSet) (string, CollectionInfo, error) {
 	now := time.Now()
 	dateStr := now.Format("2006-01-02")
 
@@ -284,16 +366,10 @@ func (g *Generator) generateINPX(allMetadata []*metadata.BookMetadata, opts Gene
 	inpxPath := filepath.Join(opts.OutputDir, opts.CatalogName+".inpx")
 
 	// Create INPX zip file
-	inpxFile, err := os.Create(inpxPath)
+	inpxFile, err := outputs.create(inpxPath)
 	if err != nil {
 		return "", collectionInfo, fmt.Errorf("failed to create INPX file: %w", err)
 	}
-	closeINPX := true
-	defer func() {
-		if closeINPX {
-			inpxFile.Close()
-		}
-	}()
 
 	zipWriter := zip.NewWriter(inpxFile)
 
@@ -355,9 +431,8 @@ func (g *Generator) generateINPX(allMetadata []*metadata.BookMetadata, opts Gene
 		return "", collectionInfo, fmt.Errorf("failed to finalize INPX zip: %w", err)
 	}
 
-	// Close the underlying file explicitly to check for errors
-	closeINPX = false
-	if err := inpxFile.Close(); err != nil {
+	// Flush and close the underlying file explicitly to check for errors
+	if err := outputs.close(inpxFile); err != nil {
 		return "", collectionInfo, fmt.Errorf("failed to close INPX file: %w", err)
 	}
 
@@ -366,7 +441,7 @@ func (g *Generator) generateINPX(allMetadata []*metadata.BookMetadata, opts Gene
 
 // formatINPLine formats book metadata as INP line
 func (g *Generator) formatINPLine(meta *metadata.BookMetadata) string {
-	// AUTHOR\x04GENRE\x04TITLE\x04SERIES\x04SERIES_NUM\x04BOOK_ID\x04SIZE\x04ARCHIVE_PATH\x04FILE_NUM\x04FORMAT\x04DATE\x04LANG\x04RATING\x04ANNOTATION\x04
+	// AUTHOR\x04GENRE\x04TITLE\x04SERIES\x04SERIES_NUM\x04BOOK_ID\x04SIZE\x04ARCHIVE_PATH\x04FILE_NUM\x04FORMAT\x04DATE\x04LANG\x04RATING\x04ANNOTATION\x04[OTHER_SERIES\x04]
 
 	fields := []string{
 		strings.Join(meta.Authors, ","),      // AUTHOR
@@ -383,8 +458,11 @@ func (g *Generator) formatINPLine(meta *metadata.BookMetadata) string {
 		meta.Language,                        // LANG
 		"0",                                  // RATING (default)
 		meta.Annotation,                      // ANNOTATION
-		"",                                   // End marker
 	}
+	if len(meta.OtherSeries) > 0 {
+		fields = append(fields, inpx.FormatSeriesRefs(meta.OtherSeries)) // OTHER_SERIES
+	}
+	fields = append(fields, "") // End marker
 
 	return strings.Join(fields, "\x04")
 }
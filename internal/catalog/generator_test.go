@@ -0,0 +1,474 @@
+package catalog
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/metadata"
+)
+
+const testFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description>
+<title-info>
+<genre>sf</genre>
+<author><first-name>Иван</first-name><last-name>Иванов</last-name></author>
+<book-title>Тестовая книга</book-title>
+<lang>ru</lang>
+</title-info>
+</description>
+<body><section><p>Текст</p></section></body>
+</FictionBook>`
+
+// writeTestBooks creates a books directory with one valid and one broken FB2 file.
+func writeTestBooks(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "good.fb2"), []byte(testFB2), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.fb2"), []byte("<FictionBook>"), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+	return dir
+}
+
+// TestGenerate_DryRunWritesNothing verifies dry run only extracts metadata.
+func TestGenerate_DryRunWritesNothing(t *testing.T) {
+	booksDir := writeTestBooks(t)
+	outputDir := filepath.Join(t.TempDir(), "out")
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:    booksDir,
+		OutputDir:   outputDir,
+		CatalogName: "test",
+		DryRun:      true,
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if !result.DryRun {
+		t.Error("expected DryRun to be set in result")
+	}
+	if result.ProcessedBooks != 1 || result.SkippedBooks != 1 {
+		t.Errorf("expected 1 processed and 1 skipped, got %d and %d", result.ProcessedBooks, result.SkippedBooks)
+	}
+	if len(result.GeneratedZips) != 0 || result.INPXPath != "" {
+		t.Errorf("dry run should not produce files, got %v %q", result.GeneratedZips, result.INPXPath)
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("dry run should not create output directory, stat err = %v", err)
+	}
+}
+
+// TestWriteReport verifies the JSON report contains per-file errors.
+func TestWriteReport(t *testing.T) {
+	booksDir := writeTestBooks(t)
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir: booksDir,
+		DryRun:   true,
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "result.json")
+	if err := result.WriteReport(reportPath); err != nil {
+		t.Fatalf("WriteReport failed: %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+
+	var report struct {
+		DryRun           bool        `json:"dry_run"`
+		TotalBooks       int         `json:"total_books"`
+		Errors           []FileError `json:"errors"`
+		ProcessingTimeMs *int64      `json:"processing_time_ms"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+
+	if !report.DryRun || report.TotalBooks != 2 {
+		t.Errorf("unexpected report header: %+v", report)
+	}
+	if len(report.Errors) != 1 || filepath.Base(report.Errors[0].Path) != "broken.fb2" || report.Errors[0].Message == "" {
+		t.Errorf("unexpected report errors: %+v", report.Errors)
+	}
+	if report.ProcessingTimeMs == nil {
+		t.Error("expected processing_time_ms in report")
+	}
+}
+
+// TestGenerate_ReferenceModeKeepsArchives verifies reference mode indexes archives in place.
+func TestGenerate_ReferenceModeKeepsArchives(t *testing.T) {
+	booksDir := t.TempDir()
+	archivePath := filepath.Join(booksDir, "fb2-000100-000200.zip")
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("000150.fb2")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	if _, err := w.Write([]byte(testFB2)); err != nil {
+		t.Fatalf("failed to write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	f.Close()
+
+	outputDir := t.TempDir()
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:      booksDir,
+		OutputDir:     outputDir,
+		CatalogName:   "ref",
+		ReferenceMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	if result.ProcessedBooks != 1 {
+		t.Fatalf("expected 1 processed book, got %d (errors: %v)", result.ProcessedBooks, result.Errors)
+	}
+	if len(result.GeneratedZips) != 0 {
+		t.Errorf("reference mode should not create archives, got %v", result.GeneratedZips)
+	}
+
+	books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+	if err != nil {
+		t.Fatalf("failed to parse generated INPX: %v", err)
+	}
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book in INPX, got %d", len(books))
+	}
+	if books[0].ID != "000150" || books[0].ArchivePath != "fb2-000100-000200" {
+		t.Errorf("expected archive entry to be preserved, got id=%s archive=%s", books[0].ID, books[0].ArchivePath)
+	}
+	if books[0].Title != "Тестовая книга" {
+		t.Errorf("unexpected title %q", books[0].Title)
+	}
+}
+
+// TestGenerate_ReferenceModeSubfolders verifies archives in subfolders keep
+// their relative path in the INPX.
+func TestGenerate_ReferenceModeSubfolders(t *testing.T) {
+	booksDir := t.TempDir()
+	dir := filepath.Join(booksDir, "fb2-000001-000500")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create subfolder: %v", err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "part1.zip"))
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("000001.fb2")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	if _, err := w.Write([]byte(testFB2)); err != nil {
+		t.Fatalf("failed to write zip entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	f.Close()
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:      booksDir,
+		OutputDir:     t.TempDir(),
+		CatalogName:   "ref",
+		ReferenceMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+	if err != nil {
+		t.Fatalf("failed to parse generated INPX: %v", err)
+	}
+	if len(books) != 1 || books[0].ArchivePath != "fb2-000001-000500/part1" {
+		t.Fatalf("expected the archive path with its subfolder, got %+v", books)
+	}
+}
+
+const validFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description>
+<title-info>
+<genre>sf</genre>
+<author><first-name>Иван</first-name><last-name>Иванов</last-name></author>
+<book-title>Тестовая книга</book-title>
+<lang>ru</lang>
+</title-info>
+<document-info>
+<author><nickname>editor</nickname></author>
+<date value="2024-01-02">2 января 2024</date>
+<id>test-0001</id>
+<version>1.0</version>
+</document-info>
+</description>
+<body><section><p>Текст</p></section></body>
+</FictionBook>`
+
+// TestValidate verifies strict validation reports malformed XML, missing
+// description fields and unknown genre codes per file.
+func TestValidate(t *testing.T) {
+	booksDir := writeTestBooks(t)
+	if err := os.WriteFile(filepath.Join(booksDir, "valid.fb2"), []byte(validFB2), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+	unknownGenre := strings.Replace(validFB2, "<genre>sf</genre>", "<genre>space_opera_x</genre>", 1)
+	if err := os.WriteFile(filepath.Join(booksDir, "genre.fb2"), []byte(unknownGenre), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+
+	opts := GenerateOptions{BooksDir: booksDir, GenreCodes: map[string]bool{"sf": true}}
+	report, err := NewGenerator().Validate(opts)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if report.CheckedFiles != 4 || report.InvalidFiles != 3 {
+		t.Errorf("expected 4 checked and 3 invalid files, got %d and %d", report.CheckedFiles, report.InvalidFiles)
+	}
+
+	problems := make(map[string][]string)
+	for _, problem := range report.Problems {
+		name := filepath.Base(problem.Path)
+		problems[name] = append(problems[name], problem.Message)
+	}
+	if len(problems["valid.fb2"]) != 0 {
+		t.Errorf("valid file reported: %v", problems["valid.fb2"])
+	}
+	if p := problems["broken.fb2"]; len(p) != 1 || !strings.HasPrefix(p[0], "malformed XML") {
+		t.Errorf("broken file problems = %v", p)
+	}
+	if p := strings.Join(problems["good.fb2"], "\n"); !strings.Contains(p, "document-info: missing id") {
+		t.Errorf("expected missing document-info fields, got %v", p)
+	}
+	if p := problems["genre.fb2"]; len(p) != 1 || !strings.Contains(p[0], `unknown genre code "space_opera_x"`) {
+		t.Errorf("unknown genre problems = %v", p)
+	}
+
+	// Strict generation skips the invalid files
+	opts.DryRun, opts.Strict = true, true
+	result, err := NewGenerator().Generate(opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.ProcessedBooks != 1 || result.SkippedBooks != 3 {
+		t.Errorf("expected 1 processed and 3 skipped in strict mode, got %d and %d", result.ProcessedBooks, result.SkippedBooks)
+	}
+}
+
+// TestGenerate_MultipleSequences verifies every <sequence> of a book
+// reaches the INPX: the first as its series, the rest as further series.
+func TestGenerate_MultipleSequences(t *testing.T) {
+	booksDir := t.TempDir()
+	fb2 := strings.Replace(testFB2, "<lang>ru</lang>",
+		`<lang>ru</lang><sequence name="Мир Полудня" number="3"/><sequence name="Избранное"/>`, 1)
+	if err := os.WriteFile(filepath.Join(booksDir, "book.fb2"), []byte(fb2), 0644); err != nil {
+		t.Fatalf("failed to write book: %v", err)
+	}
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:    booksDir,
+		OutputDir:   t.TempDir(),
+		CatalogName: "series",
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+	if err != nil {
+		t.Fatalf("failed to parse generated INPX: %v", err)
+	}
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book in INPX, got %d", len(books))
+	}
+	book := books[0]
+	if book.Series != "Мир Полудня" || book.SeriesNum != 3 ||
+		len(book.OtherSeries) != 1 || book.OtherSeries[0] != (inpx.SeriesRef{Name: "Избранное"}) {
+		t.Errorf("unexpected series %q #%d, other %+v", book.Series, book.SeriesNum, book.OtherSeries)
+	}
+}
+
+// TestGenerate_Layouts verifies archives are split by genre or by the first
+// letter of the author and that the INPX points at them.
+func TestGenerate_Layouts(t *testing.T) {
+	booksDir := t.TempDir()
+	for name, fb2 := range map[string]string{
+		"a.fb2": testFB2,
+		"b.fb2": strings.Replace(testFB2, "<book-title>Тестовая книга", "<book-title>Другая книга", 1),
+		"c.fb2": strings.NewReplacer("<genre>sf</genre>", "<genre>prose_classic</genre>",
+			"<last-name>Иванов</last-name>", "<last-name>петров</last-name>").Replace(testFB2),
+	} {
+		if err := os.WriteFile(filepath.Join(booksDir, name), []byte(fb2), 0644); err != nil {
+			t.Fatalf("failed to write book: %v", err)
+		}
+	}
+
+	for layout, want := range map[string][]string{
+		LayoutSize:   {"books-000001", "books-000002"},
+		LayoutGenre:  {"books-prose_classic-000001", "books-sf-000001"},
+		LayoutAuthor: {"books-И-000001", "books-П-000001"},
+	} {
+		outputDir := t.TempDir()
+		result, err := NewGenerator().Generate(GenerateOptions{
+			BooksDir:       booksDir,
+			OutputDir:      outputDir,
+			CatalogName:    "layout",
+			MaxBooksPerZip: 2,
+			Layout:         layout,
+		})
+		if err != nil {
+			t.Fatalf("%s: Generate failed: %v", layout, err)
+		}
+
+		var zips []string
+		for _, path := range result.GeneratedZips {
+			zips = append(zips, strings.TrimSuffix(filepath.Base(path), ".zip"))
+		}
+		if strings.Join(zips, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: archives %v, want %v", layout, zips, want)
+		}
+
+		books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+		if err != nil {
+			t.Fatalf("%s: failed to parse generated INPX: %v", layout, err)
+		}
+		for _, book := range books {
+			if _, err := os.Stat(filepath.Join(outputDir, book.ArchivePath+".zip")); err != nil {
+				t.Errorf("%s: book %s points at a missing archive: %v", layout, book.ID, err)
+			}
+		}
+	}
+
+	if _, err := NewGenerator().Generate(GenerateOptions{BooksDir: booksDir, OutputDir: t.TempDir(), Layout: "decade"}); err == nil {
+		t.Error("expected an error for an unknown layout")
+	}
+}
+
+// TestGenerate_TemporaryOutputs verifies archives and the INPX only appear
+// under their names once complete, and that unfinished files are removed.
+func TestGenerate_TemporaryOutputs(t *testing.T) {
+	booksDir := writeTestBooks(t)
+	outputDir := t.TempDir()
+	stale := filepath.Join(outputDir, ".test.inpx.123"+tempSuffix)
+	if err := os.WriteFile(stale, []byte("partial"), 0644); err != nil {
+		t.Fatalf("failed to write stale file: %v", err)
+	}
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:    booksDir,
+		OutputDir:   outputDir,
+		CatalogName: "test",
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"books-000001.zip", "test.inpx"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("output files %v, want %v", names, want)
+	}
+	if info, err := os.Stat(result.INPXPath); err != nil || info.Mode().Perm()&0044 == 0 {
+		t.Errorf("INPX should be readable by others, stat = %v, %v", info, err)
+	}
+
+	// A book gone half way fails the run before anything is renamed
+	failedDir := t.TempDir()
+	books := []*metadata.BookMetadata{
+		{Title: "А", Format: "fb2", FilePath: filepath.Join(booksDir, "good.fb2")},
+		{Title: "Б", Format: "fb2", FilePath: filepath.Join(booksDir, "gone.fb2")},
+	}
+	outputs := &outputSet{}
+	if _, err := NewGenerator().createBookArchives(books, GenerateOptions{OutputDir: failedDir, ArchivePrefix: "books", MaxBooksPerZip: 1}, outputs); err == nil {
+		t.Fatal("expected createBookArchives to fail")
+	}
+	if entries, _ := os.ReadDir(failedDir); len(entries) != 2 {
+		t.Fatalf("expected 2 temporary archives, got %d", len(entries))
+	}
+	outputs.abort()
+	if entries, _ := os.ReadDir(failedDir); len(entries) != 0 {
+		t.Errorf("aborted run left %d files", len(entries))
+	}
+}
+
+// TestGenerate_ReferenceModeDuplicateIDs verifies an entry name found in two
+// archives is indexed once and reported as an error.
+func TestGenerate_ReferenceModeDuplicateIDs(t *testing.T) {
+	booksDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(booksDir, "other"), 0755); err != nil {
+		t.Fatalf("failed to create folder: %v", err)
+	}
+	for _, name := range []string{"a.zip", filepath.Join("other", "b.zip")} {
+		f, err := os.Create(filepath.Join(booksDir, name))
+		if err != nil {
+			t.Fatalf("failed to create archive: %v", err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("000150.fb2")
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(testFB2)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to close zip: %v", err)
+		}
+		f.Close()
+	}
+
+	result, err := NewGenerator().Generate(GenerateOptions{
+		BooksDir:      booksDir,
+		OutputDir:     t.TempDir(),
+		CatalogName:   "ref",
+		ReferenceMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.ProcessedBooks != 1 || result.SkippedBooks != 1 || len(result.Errors) != 1 {
+		t.Fatalf("expected one indexed and one rejected book, got processed=%d skipped=%d errors=%v",
+			result.ProcessedBooks, result.SkippedBooks, result.Errors)
+	}
+	if !strings.Contains(result.Errors[0].Message, "duplicate book ID 000150") {
+		t.Errorf("unexpected error %q", result.Errors[0].Message)
+	}
+
+	books, _, err := inpx.NewParser().ParseINPX(result.INPXPath)
+	if err != nil {
+		t.Fatalf("failed to parse generated INPX: %v", err)
+	}
+	if len(books) != 1 || books[0].ArchivePath != "a" {
+		t.Errorf("expected the book from the first archive only, got %+v", books)
+	}
+}
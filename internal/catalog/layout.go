@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/piligrim/pushkinlib/internal/metadata"
+)
+
+// Archive layouts of generated catalogs
+const (
+	// LayoutSize fills numbered archives of up to MaxBooksPerZip books
+	LayoutSize = "size"
+	// LayoutGenre keeps the books of each main genre in their own archives
+	LayoutGenre = "genre"
+	// LayoutAuthor keeps the books of authors whose names start with the
+	// same letter in their own archives, as in classic librusec layouts
+	LayoutAuthor = "author"
+)
+
+// Layouts lists the accepted values of GenerateOptions.Layout
+var Layouts = []string{LayoutSize, LayoutGenre, LayoutAuthor}
+
+// IsValidLayout reports whether layout is one of Layouts
+func IsValidLayout(layout string) bool {
+	return slices.Contains(Layouts, layout)
+}
+
+// miscGroup holds the books that have no genre or author to group by
+const miscGroup = "misc"
+
+// archiveGroup returns the group of archives a book goes to under layout:
+// its main genre code, the upper-case first letter of its first author, or
+// "" for LayoutSize
+func archiveGroup(meta *metadata.BookMetadata, layout string) string {
+	switch layout {
+	case LayoutGenre:
+		if len(meta.Genres) == 0 {
+			return miscGroup
+		}
+		// Genre codes go into file names, so keep only safe characters
+		code := strings.Map(func(r rune) rune {
+			if r == '_' || r == '-' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, meta.Genres[0])
+		if code == "" {
+			return miscGroup
+		}
+		return code
+	case LayoutAuthor:
+		if len(meta.Authors) == 0 {
+			return miscGroup
+		}
+		for _, r := range strings.TrimSpace(meta.Authors[0]) {
+			if unicode.IsLetter(r) {
+				return string(unicode.ToUpper(r))
+			}
+			break
+		}
+		return miscGroup
+	default:
+		return ""
+	}
+}
+
+// archiveName is the file name of the n-th archive of a group
+func archiveName(prefix, group string, n int) string {
+	if group == "" {
+		return fmt.Sprintf("%s-%06d.zip", prefix, n)
+	}
+	return fmt.Sprintf("%s-%s-%06d.zip", prefix, group, n)
+}
@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempSuffix ends the names archives and the INPX are written under until
+// the catalog is complete
+const tempSuffix = ".tmp"
+
+// outputFile is an archive or INPX file being written under a temporary
+// name next to its final path
+type outputFile struct {
+	file *os.File
+	path string
+}
+
+// outputSet writes the files of a catalog under temporary names and moves
+// them into place once all of them are complete, so that a run interrupted
+// half way leaves no partial archive or INPX that an import would trust.
+type outputSet struct {
+	files []*outputFile
+}
+
+// create creates a temporary file that commit renames to path. Its name
+// starts with a dot and ends with tempSuffix, so that it is neither listed
+// nor taken for an archive.
+func (s *outputSet) create(path string) (*os.File, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, &outputFile{file: file, path: path})
+	// Temporary files are private, the catalog is not
+	if err := file.Chmod(0o644); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// close flushes a file created by create to disk and closes it.
+func (s *outputSet) close(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// commit renames the files to their final paths in the order they were
+// created, so the INPX, created last, only appears after the archives it
+// lists, and then syncs their directories so the renames survive a crash.
+func (s *outputSet) commit() error {
+	dirs := map[string]bool{}
+	for _, f := range s.files {
+		if err := os.Rename(f.file.Name(), f.path); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", f.path, err)
+		}
+		dirs[filepath.Dir(f.path)] = true
+	}
+	s.files = nil
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// abort removes the temporary files not committed. It does nothing after
+// commit.
+func (s *outputSet) abort() {
+	for _, f := range s.files {
+		f.file.Close()
+		os.Remove(f.file.Name())
+	}
+	s.files = nil
+}
+
+// syncDir flushes the entries of a directory, such as renamed files, to
+// disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// removeStaleOutputs removes the temporary files of earlier runs that were
+// interrupted before commit
+func removeStaleOutputs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix) &&
+			(strings.Contains(name, ".zip.") || strings.Contains(name, ".inpx.")) {
+			if err := os.Remove(filepath.Join(dir, name)); err == nil {
+				fmt.Printf("Removed unfinished file of an earlier run: %s\n", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package catalog
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/metadata"
+)
+
+// generateReference builds an INPX for existing ZIP archives without repacking.
+// Archive names and entry names are kept as ARCHIVE_PATH and FILE_NUM so the
+// catalog points at the books where they already are. The entry name is also
+// the book ID, so an entry name seen in an earlier archive is reported as an
+// error and skipped.
+func (g *Generator) generateReference(opts GenerateOptions, result *GenerationResult, startTime time.Time) (*GenerationResult, error) {
+	fmt.Printf("Scanning archives in: %s\n", opts.BooksDir)
+	archives, err := g.scanBooksDirectory(opts.BooksDir, []string{".zip"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan books directory: %w", err)
+	}
+	sort.Strings(archives)
+
+	fmt.Printf("Found %d archives\n", len(archives))
+
+	var allMetadata []*metadata.BookMetadata
+	seen := make(map[string]string)
+	for _, archivePath := range archives {
+		archiveMeta, err := g.extractArchiveMetadata(archivePath, opts, result, seen)
+		if err != nil {
+			result.Errors = append(result.Errors, FileError{Path: archivePath, Message: err.Error()})
+			continue
+		}
+		allMetadata = append(allMetadata, archiveMeta...)
+		result.ReferencedZips = append(result.ReferencedZips, archivePath)
+	}
+
+	fmt.Printf("Successfully extracted metadata from %d books\n", result.ProcessedBooks)
+
+	if opts.DryRun {
+		result.ProcessingTime = time.Since(startTime)
+		fmt.Println("Dry run: skipping INPX generation")
+		return result, nil
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	removeStaleOutputs(opts.OutputDir)
+	outputs := &outputSet{}
+	defer outputs.abort()
+
+	fmt.Println("Generating INPX file...")
+	inpxPath, collectionInfo, err := g.generateINPX(allMetadata, opts, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate INPX: %w", err)
+	}
+	if err := outputs.commit(); err != nil {
+		return nil, err
+	}
+
+	result.INPXPath = inpxPath
+	result.CollectionInfo = collectionInfo
+	result.ProcessingTime = time.Since(startTime)
+
+	fmt.Printf("Catalog generation completed in %v\n", result.ProcessingTime)
+	fmt.Printf("Generated INPX: %s\n", inpxPath)
+
+	return result, nil
+}
+
+// extractArchiveMetadata extracts metadata from every supported entry of a ZIP
+// archive. seen maps the book IDs already indexed to where they were found.
+func (g *Generator) extractArchiveMetadata(archivePath string, opts GenerateOptions, result *GenerationResult, seen map[string]string) ([]*metadata.BookMetadata, error) {
+	relPath, err := filepath.Rel(opts.BooksDir, archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve archive path: %w", err)
+	}
+	archiveName := strings.TrimSuffix(filepath.ToSlash(relPath), filepath.Ext(relPath))
+
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer reader.Close()
+
+	var archiveMeta []*metadata.BookMetadata
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || !hasIncludedFormat(file.Name, opts.IncludeFormats) {
+			continue
+		}
+
+		result.TotalBooks++
+		entryPath := archivePath + "/" + file.Name
+
+		fileNum := strings.TrimSuffix(path.Base(file.Name), path.Ext(file.Name))
+		if first, ok := seen[fileNum]; ok {
+			result.Errors = append(result.Errors, FileError{
+				Path:    entryPath,
+				Message: fmt.Sprintf("duplicate book ID %s, already indexed from %s", fileNum, first),
+			})
+			result.SkippedBooks++
+			continue
+		}
+
+		meta, err := g.extractor.ExtractFromZipEntry(file)
+		if err != nil {
+			result.Errors = append(result.Errors, FileError{Path: entryPath, Message: err.Error()})
+			result.SkippedBooks++
+			continue
+		}
+
+		seen[fileNum] = entryPath
+		meta.ID = fileNum
+		meta.FileNum = fileNum
+		meta.ArchivePath = archiveName
+
+		archiveMeta = append(archiveMeta, meta)
+		result.ProcessedBooks++
+	}
+
+	return archiveMeta, nil
+}
+
+// hasIncludedFormat reports whether the file extension is in the format list
+func hasIncludedFormat(name string, includeFormats []string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, format := range includeFormats {
+		if ext == format {
+			return true
+		}
+	}
+	return false
+}
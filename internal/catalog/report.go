@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// MarshalJSON encodes the result with processing time in milliseconds
+func (r GenerationResult) MarshalJSON() ([]byte, error) {
+	type resultAlias GenerationResult
+	return json.Marshal(struct {
+		resultAlias
+		ProcessingTimeMs int64 `json:"processing_time_ms"`
+	}{
+		resultAlias:      resultAlias(r),
+		ProcessingTimeMs: r.ProcessingTime.Milliseconds(),
+	})
+}
+
+// WriteReport writes the generation result as JSON to the given path
+func (r *GenerationResult) WriteReport(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// WriteReport writes the validation report as JSON to the given path
+func (r *ValidationReport) WriteReport(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+
+	return nil
+}
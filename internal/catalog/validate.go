@@ -0,0 +1,64 @@
+package catalog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/metadata"
+)
+
+// ValidationReport lists the problems found in book files by Validate
+type ValidationReport struct {
+	CheckedFiles   int           `json:"checked_files"`
+	InvalidFiles   int           `json:"invalid_files"`
+	Problems       []FileError   `json:"problems"`
+	ProcessingTime time.Duration `json:"-"`
+}
+
+// Validate checks the FB2 files of the books directory, loose or inside ZIP
+// archives, without generating anything: XML must be well-formed, required
+// description fields present and genre codes among opts.GenreCodes. Files
+// that cannot be read are reported as problems too.
+func (g *Generator) Validate(opts GenerateOptions) (*ValidationReport, error) {
+	startTime := time.Now()
+
+	formats := opts.IncludeFormats
+	if len(formats) == 0 {
+		formats = []string{".fb2", ".zip"}
+	}
+	if opts.ReferenceMode {
+		formats = []string{".zip"}
+	}
+
+	fmt.Printf("Scanning books directory: %s\n", opts.BooksDir)
+	bookFiles, err := g.scanBooksDirectory(opts.BooksDir, formats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan books directory: %w", err)
+	}
+
+	validator := metadata.NewExtractor()
+	validator.SetStrict(opts.GenreCodes)
+
+	report := &ValidationReport{Problems: []FileError{}}
+	for i, filePath := range bookFiles {
+		if i%100 == 0 && i > 0 {
+			fmt.Printf("Validated %d/%d files...\n", i, len(bookFiles))
+		}
+
+		problems, err := validator.ValidateFile(filePath)
+		if err != nil {
+			problems = []string{err.Error()}
+		}
+		report.CheckedFiles++
+		if len(problems) == 0 {
+			continue
+		}
+		report.InvalidFiles++
+		for _, problem := range problems {
+			report.Problems = append(report.Problems, FileError{Path: filePath, Message: problem})
+		}
+	}
+
+	report.ProcessingTime = time.Since(startTime)
+	return report, nil
+}
@@ -1,63 +1,277 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
 )
 
 // Config holds application configuration
 type Config struct {
-	Port             string
-	BooksDir         string
-	INPXPath         string
+	Port       string
+	BooksDir   string
+	INPXPath   string
+	INPXSHA256 string
+	// INPXRefreshHours is how often an INPX given by URL is checked for a
+	// new version; 0 disables the check
+	INPXRefreshHours int
 	BasicAuthEnabled bool
 	BasicAuthUser    string
 	BasicAuthPass    string
 	CatalogTitle     string
 	OPDS2Enabled     bool
+	OPDSLanguages    bool
 	PageSize         int
 	LogLevel         string
 	CacheDir         string
 	DatabasePath     string
 	PublicBaseURL    string
 	GenresCSVPath    string
+	GenreAliasesPath string
 	TTSServerURL     string
 	TTSAPIKey        string
 	AuthEnabled      bool
 	SessionSecret    string
 	AdminUser        string
 	AdminPass        string
+
+	MaintenanceIntervalHours int
+	MaintenanceVacuum        bool
+
+	AuthorEnrichmentEnabled    bool
+	AuthorEnrichmentLanguage   string
+	AuthorEnrichmentIntervalMs int
+	AuthorEnrichmentCacheDays  int
+
+	CoversEnabled bool
+
+	ConversionCacheMaxMB int
+
+	Converters              string
+	ConverterTimeoutSeconds int
+	ConverterConcurrency    int
+
+	// ConversionQueueSize is how many conversions of downloads may wait for
+	// one of the ConverterConcurrency workers; 0 converts them inline.
+	// ConversionWaitSeconds is how long a download waits for its
+	// conversion before it is answered 202 Accepted.
+	ConversionQueueSize   int
+	ConversionWaitSeconds int
+	// ConversionPrewarmBooks of the most downloaded books are converted to
+	// ConversionPrewarmFormats ahead of time
+	ConversionPrewarmBooks   int
+	ConversionPrewarmFormats string
+
+	SearchSuggestionsEnabled bool
+	SearchFallbackEnabled    bool
+	FTSTokenizer             string
+	SearchRankWeights        string
+	// SearchLanguageBoost favors books in the script of the query in
+	// relevance ordering; LanguageDetectionEnabled guesses the language
+	// of imported books from their annotations
+	SearchLanguageBoost      float64
+	LanguageDetectionEnabled bool
+	// ImportDeferredFTS builds the search index after a full import
+	// instead of book by book
+	ImportDeferredFTS bool
+	// ImportSizeCheck compares the file sizes of the INPX with the archive
+	// entries after imports: off, report or fix
+	ImportSizeCheck string
+
+	SyncEnabled bool
+
+	OPDSAnnotationMaxLength int
+	// OPDSSections lists extra root sections, each a saved book filter:
+	// "Title|Description=filter;..."
+	OPDSSections string
+
+	OPDSUpstreams            string
+	OPDSUpstreamProxy        bool
+	OPDSUpstreamRefreshHours int
+	OPDSUpstreamMaxPages     int
+
+	PIDFile string
+
+	DownloadLogEnabled       bool
+	DownloadLogRetentionDays int
+	DownloadLogMaxEntries    int
+	DownloadTranslit         bool
+	// DownloadFilename is the template of download file names
+	DownloadFilename string
+	// DownloadQuotaCount and DownloadQuotaMB limit the books and megabytes
+	// each user, or each address of anonymous visitors, downloads a day
+	DownloadQuotaCount int
+	DownloadQuotaMB    int
+	// TrustedProxies lists the addresses and CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers anonymous download quotas
+	// trust
+	TrustedProxies string
+
+	// SearchLogEnabled logs search queries for the search statistics;
+	// queries made by fewer than SearchLogMinSearchers people are only
+	// shown to admins
+	SearchLogEnabled       bool
+	SearchLogRetentionDays int
+	SearchLogMinSearchers  int
+
+	// NotifyWebhooks are the URLs new books of saved searches are POSTed
+	// to; SMTP* is the mail server they are emailed through
+	NotifyWebhooks           string
+	NotifyMaxBooks           int
+	NotifyMinIntervalMinutes int
+	NotifyRatePerMinute      int
+	SMTPHost                 string
+	SMTPPort                 int
+	SMTPUsername             string
+	SMTPPassword             string
+	SMTPFrom                 string
+
+	BooksProbeIntervalSeconds int
+
+	ReadOnly bool
+
+	QueryCacheSize       int
+	QueryCacheTTLSeconds int
+
+	CacheStorage      string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3Prefix          string
+	S3PathStyle       bool
 }
 
-// LoadConfig loads configuration from environment variables
+// fileValues holds the settings read from CONFIG_FILE by the last
+// LoadConfig; they take precedence over the environment
+var fileValues map[string]string
+
+// LoadConfig loads configuration from environment variables and, when
+// CONFIG_FILE is set, from that file. The file is read again on every call,
+// so settings changed there can be reloaded without a restart.
 func LoadConfig() *Config {
+	fileValues = nil
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			log.Printf("Failed to read config file: %v", err)
+		}
+		fileValues = values
+	}
+
 	return &Config{
 		Port:             getEnvOrDefault("PORT", "9090"),
 		BooksDir:         getEnvOrDefault("BOOKS_DIR", "./books"),
 		INPXPath:         getEnvOrDefault("INPX_PATH", "./sample-data/flibusta_fb2_local.inpx"),
+		INPXSHA256:       getEnvOrDefault("INPX_SHA256", ""),
+		INPXRefreshHours: getEnvInt("INPX_REFRESH_HOURS", 0),
 		BasicAuthEnabled: getEnvBool("BASIC_AUTH_ENABLED", false),
 		BasicAuthUser:    getEnvOrDefault("BASIC_AUTH_USER", "reader"),
 		BasicAuthPass:    getEnvOrDefault("BASIC_AUTH_PASS", "secret"),
 		CatalogTitle:     getEnvOrDefault("CATALOG_TITLE", "Pushkinlib"),
 		OPDS2Enabled:     getEnvBool("OPDS2_ENABLED", false),
+		OPDSLanguages:    getEnvBool("OPDS_LANGUAGES", false),
 		PageSize:         getEnvInt("PAGE_SIZE", 30),
 		LogLevel:         getEnvOrDefault("LOG_LEVEL", "info"),
 		CacheDir:         getEnvOrDefault("CACHE_DIR", "./cache"),
 		DatabasePath:     getEnvOrDefault("DATABASE_PATH", "./cache/pushkinlib.db"),
 		PublicBaseURL:    getEnvOrDefault("PUBLIC_BASE_URL", ""),
-		GenresCSVPath:    getEnvOrDefault("GENRES_CSV_PATH", "./web/static/genres.csv"),
+		GenresCSVPath:    getEnvOrDefault("GENRES_CSV_PATH", ""),
+		GenreAliasesPath: getEnvOrDefault("GENRE_ALIASES_PATH", "./web/static/genre_aliases.csv"),
 		TTSServerURL:     getEnvOrDefault("TTS_SERVER_URL", ""),
 		TTSAPIKey:        getEnvOrDefault("TTS_API_KEY", ""),
 		AuthEnabled:      getEnvBool("AUTH_ENABLED", false),
 		SessionSecret:    getEnvOrDefault("SESSION_SECRET", "pushkinlib-default-secret-change-me"),
 		AdminUser:        getEnvOrDefault("ADMIN_USER", "admin"),
 		AdminPass:        getEnvOrDefault("ADMIN_PASS", ""),
+
+		MaintenanceIntervalHours: getEnvInt("MAINTENANCE_INTERVAL_HOURS", 0),
+		MaintenanceVacuum:        getEnvBool("MAINTENANCE_VACUUM", false),
+
+		AuthorEnrichmentEnabled:    getEnvBool("AUTHOR_ENRICHMENT_ENABLED", false),
+		AuthorEnrichmentLanguage:   getEnvOrDefault("AUTHOR_ENRICHMENT_LANGUAGE", "ru"),
+		AuthorEnrichmentIntervalMs: getEnvInt("AUTHOR_ENRICHMENT_INTERVAL_MS", 1000),
+		AuthorEnrichmentCacheDays:  getEnvInt("AUTHOR_ENRICHMENT_CACHE_DAYS", 30),
+
+		CoversEnabled: getEnvBool("COVERS_ENABLED", true),
+
+		ConversionCacheMaxMB: getEnvInt("CONVERSION_CACHE_MAX_MB", 1024),
+
+		Converters:              getEnvOrDefault("CONVERTERS", ""),
+		ConverterTimeoutSeconds: getEnvInt("CONVERTER_TIMEOUT_SECONDS", 120),
+		ConverterConcurrency:    getEnvInt("CONVERTER_CONCURRENCY", 2),
+
+		ConversionQueueSize:      getEnvInt("CONVERSION_QUEUE_SIZE", 100),
+		ConversionWaitSeconds:    getEnvInt("CONVERSION_WAIT_SECONDS", 15),
+		ConversionPrewarmBooks:   getEnvInt("CONVERSION_PREWARM_BOOKS", 0),
+		ConversionPrewarmFormats: getEnvOrDefault("CONVERSION_PREWARM_FORMATS", "epub"),
+
+		SearchSuggestionsEnabled: getEnvBool("SEARCH_SUGGESTIONS_ENABLED", true),
+		SearchFallbackEnabled:    getEnvBool("SEARCH_FALLBACK_ENABLED", true),
+		FTSTokenizer:             getEnvOrDefault("FTS_TOKENIZER", "unicode61 remove_diacritics 2"),
+		SearchRankWeights:        getEnvOrDefault("SEARCH_RANK_WEIGHTS", ""),
+		SearchLanguageBoost:      getEnvFloat("SEARCH_LANGUAGE_BOOST", 1.5),
+		LanguageDetectionEnabled: getEnvBool("LANGUAGE_DETECTION_ENABLED", true),
+		ImportDeferredFTS:        getEnvBool("IMPORT_DEFERRED_FTS", true),
+		ImportSizeCheck:          getEnvOrDefault("IMPORT_SIZE_CHECK", "off"),
+
+		SyncEnabled: getEnvBool("SYNC_ENABLED", false),
+
+		OPDSAnnotationMaxLength: getEnvInt("OPDS_ANNOTATION_MAX_LENGTH", 2000),
+		OPDSSections:            getEnvOrDefault("OPDS_SECTIONS", ""),
+
+		OPDSUpstreams:            getEnvOrDefault("OPDS_UPSTREAMS", ""),
+		OPDSUpstreamProxy:        getEnvBool("OPDS_UPSTREAM_PROXY", false),
+		OPDSUpstreamRefreshHours: getEnvInt("OPDS_UPSTREAM_REFRESH_HOURS", 24),
+		OPDSUpstreamMaxPages:     getEnvInt("OPDS_UPSTREAM_MAX_PAGES", 500),
+
+		PIDFile: getEnvOrDefault("PID_FILE", ""),
+
+		DownloadLogEnabled:       getEnvBool("DOWNLOAD_LOG_ENABLED", false),
+		DownloadLogRetentionDays: getEnvInt("DOWNLOAD_LOG_RETENTION_DAYS", 90),
+		DownloadLogMaxEntries:    getEnvInt("DOWNLOAD_LOG_MAX_ENTRIES", 1000000),
+		DownloadTranslit:         getEnvBool("DOWNLOAD_TRANSLIT", false),
+		DownloadFilename:         getEnvOrDefault("DOWNLOAD_FILENAME", "{title}.{ext}"),
+		DownloadQuotaCount:       getEnvInt("DOWNLOAD_QUOTA_COUNT", 0),
+		DownloadQuotaMB:          getEnvInt("DOWNLOAD_QUOTA_MB", 0),
+		TrustedProxies:           getEnvOrDefault("TRUSTED_PROXIES", ""),
+
+		SearchLogEnabled:       getEnvBool("SEARCH_LOG_ENABLED", false),
+		SearchLogRetentionDays: getEnvInt("SEARCH_LOG_RETENTION_DAYS", 30),
+		SearchLogMinSearchers:  getEnvInt("SEARCH_LOG_MIN_SEARCHERS", 3),
+
+		NotifyWebhooks:           getEnvOrDefault("NOTIFY_WEBHOOKS", ""),
+		NotifyMaxBooks:           getEnvInt("NOTIFY_MAX_BOOKS", 20),
+		NotifyMinIntervalMinutes: getEnvInt("NOTIFY_MIN_INTERVAL_MINUTES", 60),
+		NotifyRatePerMinute:      getEnvInt("NOTIFY_RATE_PER_MINUTE", 30),
+		SMTPHost:                 getEnvOrDefault("SMTP_HOST", ""),
+		SMTPPort:                 getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:             getEnvOrDefault("SMTP_USERNAME", ""),
+		SMTPPassword:             getEnvOrDefault("SMTP_PASSWORD", ""),
+		SMTPFrom:                 getEnvOrDefault("SMTP_FROM", ""),
+
+		BooksProbeIntervalSeconds: getEnvInt("BOOKS_PROBE_INTERVAL_SECONDS", 60),
+
+		ReadOnly: getEnvBool("READ_ONLY", false),
+
+		QueryCacheSize:       getEnvInt("QUERY_CACHE_SIZE", 1000),
+		QueryCacheTTLSeconds: getEnvInt("QUERY_CACHE_TTL_SECONDS", 60),
+
+		CacheStorage:      getEnvOrDefault("CACHE_STORAGE", "local"),
+		S3Endpoint:        getEnvOrDefault("S3_ENDPOINT", ""),
+		S3Region:          getEnvOrDefault("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnvOrDefault("S3_BUCKET", ""),
+		S3AccessKeyID:     getEnvOrDefault("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey: getEnvOrDefault("S3_SECRET_ACCESS_KEY", ""),
+		S3Prefix:          getEnvOrDefault("S3_PREFIX", ""),
+		S3PathStyle:       getEnvBool("S3_PATH_STYLE", true),
 	}
 }
 
 // getEnvOrDefault returns environment variable value or default
 func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
@@ -65,7 +279,7 @@ func getEnvOrDefault(key, defaultValue string) string {
 
 // getEnvBool returns environment variable as boolean or default
 func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		if parsed, err := strconv.ParseBool(value); err == nil {
 			return parsed
 		}
@@ -75,10 +289,28 @@ func getEnvBool(key string, defaultValue bool) bool {
 
 // getEnvInt returns environment variable as int or default
 func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		if parsed, err := strconv.Atoi(value); err == nil {
 			return parsed
 		}
 	}
 	return defaultValue
 }
+
+// getEnvFloat returns environment variable as float or default
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := lookupEnv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// lookupEnv returns a setting from the config file or the environment
+func lookupEnv(key string) string {
+	if value, ok := fileValues[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pushkinlib.env")
+	content := `# Reloaded on SIGHUP
+PAGE_SIZE=50
+export PUBLIC_BASE_URL="https://books.example.org"
+LOG_LEVEL='warn'
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PAGE_SIZE", "10")
+	t.Setenv("CATALOG_TITLE", "From env")
+
+	cfg := LoadConfig()
+	if cfg.PageSize != 50 {
+		t.Errorf("expected the file to override the environment, got PAGE_SIZE=%d", cfg.PageSize)
+	}
+	if cfg.PublicBaseURL != "https://books.example.org" || cfg.LogLevel != "warn" {
+		t.Errorf("unexpected quoted values: %q %q", cfg.PublicBaseURL, cfg.LogLevel)
+	}
+	if cfg.CatalogTitle != "From env" {
+		t.Errorf("expected settings missing from the file to come from the environment, got %q", cfg.CatalogTitle)
+	}
+
+	// Changes to the file are picked up by the next load
+	if err := os.WriteFile(path, []byte("PAGE_SIZE=20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := LoadConfig(); cfg.PageSize != 20 || cfg.LogLevel != "info" {
+		t.Errorf("expected reloaded values, got PAGE_SIZE=%d LOG_LEVEL=%q", cfg.PageSize, cfg.LogLevel)
+	}
+}
+
+func TestReadConfigFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.env")
+	if err := os.WriteFile(path, []byte("PAGE_SIZE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfigFile(path); err == nil {
+		t.Error("expected an error for a line without =")
+	}
+}
@@ -0,0 +1,250 @@
+// Package convcache caches converted book files (FB2 converted to EPUB and
+// the like) in a blob store. Files are addressed by the hash of what they
+// were made from, so a changed source or converter gets a new entry and the
+// stale one ages out. The cache is bounded: once it grows past its size
+// limit the least recently used files are deleted.
+package convcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/blob"
+)
+
+// Stats describes the cache contents and its use since startup.
+type Stats struct {
+	Files     int        `json:"files"`
+	Size      int64      `json:"size"`
+	MaxSize   int64      `json:"max_size"`
+	Hits      int64      `json:"hits"`
+	Misses    int64      `json:"misses"`
+	Evictions int64      `json:"evictions"`
+	ScannedAt *time.Time `json:"scanned_at,omitempty"`
+}
+
+// entry is a cached file in the LRU list
+type entry struct {
+	key  string
+	size int64
+}
+
+// Cache is a size-bounded LRU cache of files in a blob store.
+type Cache struct {
+	blobs   blob.Store
+	maxSize int64
+
+	mu        sync.Mutex
+	lru       *list.List // front is the most recently used
+	entries   map[string]*list.Element
+	size      int64
+	hits      int64
+	misses    int64
+	evictions int64
+	scannedAt time.Time
+}
+
+// New creates a cache in blobs holding at most maxSize bytes; 0 means no
+// limit. Call Scan to index files left by a previous run.
+func New(blobs blob.Store, maxSize int64) *Cache {
+	return &Cache{
+		blobs:   blobs,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Key returns the key of a file made from parts, e.g. the source checksum,
+// the target format and the converter version, with ext as its extension
+// (".epub").
+func Key(ext string, parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	name := hex.EncodeToString(sum[:])
+	return name[:2] + "/" + name + ext
+}
+
+// Scan rebuilds the index from the files in the store and trims the cache
+// to its size limit; files last written the longest ago are evicted first.
+// The store must be a blob.Lister.
+func (c *Cache) Scan(ctx context.Context) error {
+	lister, ok := c.blobs.(blob.Lister)
+	if !ok {
+		return errors.New("cache storage cannot list files")
+	}
+
+	type file struct {
+		key  string
+		info blob.Info
+	}
+	var files []file
+	if err := lister.List(ctx, func(key string, info blob.Info) error {
+		files = append(files, file{key: key, info: info})
+		return nil
+	}); err != nil {
+		return fmt.Errorf("scan cache: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime.After(files[j].info.ModTime)
+	})
+
+	c.mu.Lock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element, len(files))
+	c.size = 0
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushBack(&entry{key: f.key, size: f.info.Size})
+		c.size += f.info.Size
+	}
+	c.scannedAt = time.Now()
+	evicted := c.evictLocked()
+	c.mu.Unlock()
+
+	c.delete(ctx, evicted)
+	return nil
+}
+
+// Get opens the file of key and marks it used, or returns
+// blob.ErrNotFound.
+func (c *Cache) Get(ctx context.Context, key string) (io.ReadSeekCloser, blob.Info, error) {
+	rc, info, err := c.blobs.Get(ctx, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			c.misses++
+			// Removed behind the cache's back
+			if el, ok := c.entries[key]; ok {
+				c.removeLocked(el)
+			}
+		}
+		return nil, blob.Info{}, err
+	}
+	c.hits++
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+	} else {
+		// Written by another process sharing the store
+		c.entries[key] = c.lru.PushFront(&entry{key: key, size: info.Size})
+		c.size += info.Size
+	}
+	return rc, info, nil
+}
+
+// Contains reports whether the cache index holds key, without marking it
+// used or counting a hit.
+func (c *Cache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Put stores data under key and evicts the least recently used files over
+// the size limit. A file larger than the limit is not cached.
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	size := int64(len(data))
+	if c.maxSize > 0 && size > c.maxSize {
+		return nil
+	}
+	if err := c.blobs.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("write cached file: %w", err)
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, size: size})
+	c.size += size
+	evicted := c.evictLocked()
+	c.mu.Unlock()
+
+	c.delete(ctx, evicted)
+	return nil
+}
+
+// Purge deletes every cached file and returns how many files and bytes
+// were freed.
+func (c *Cache) Purge(ctx context.Context) (int, int64, error) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	size := c.size
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		if err := c.blobs.Delete(ctx, key); err != nil {
+			return 0, 0, fmt.Errorf("purge cache: %w", err)
+		}
+	}
+	return len(keys), size, nil
+}
+
+// Stats returns the cache statistics.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := Stats{
+		Files:     len(c.entries),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if !c.scannedAt.IsZero() {
+		scannedAt := c.scannedAt
+		stats.ScannedAt = &scannedAt
+	}
+	return stats
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// evictLocked drops the least recently used entries over the size limit
+// from the index and returns their keys, to be deleted without the lock
+func (c *Cache) evictLocked() []string {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	var evicted []string
+	for c.size > c.maxSize {
+		el := c.lru.Back()
+		if el == nil {
+			break
+		}
+		evicted = append(evicted, el.Value.(*entry).key)
+		c.removeLocked(el)
+		c.evictions++
+	}
+	return evicted
+}
+
+func (c *Cache) delete(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := c.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Conversion cache: failed to evict %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package convcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/blob"
+)
+
+func read(t *testing.T, c *Cache, key string) string {
+	t.Helper()
+	rc, _, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := New(blob.NewLocal(t.TempDir()), 10)
+
+	a, b, d := Key(".epub", "a"), Key(".epub", "b"), Key(".epub", "d")
+	if a == b || filepath.Ext(a) != ".epub" {
+		t.Fatalf("unexpected keys %s, %s", a, b)
+	}
+	for _, key := range []string{a, b} {
+		if err := c.Put(ctx, key, []byte("1234")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// a is now the most recently used, so b goes first
+	if got := read(t, c, a); got != "1234" {
+		t.Fatalf("Get = %q", got)
+	}
+	if err := c.Put(ctx, d, []byte("12345")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if _, _, err := c.Get(ctx, b); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	read(t, c, a)
+	read(t, c, d)
+
+	stats := c.Stats()
+	if stats.Files != 2 || stats.Size != 9 || stats.Evictions != 1 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Files over the limit are not cached at all
+	if err := c.Put(ctx, Key(".epub", "big"), make([]byte, 11)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if stats := c.Stats(); stats.Files != 2 {
+		t.Errorf("expected the oversized file to be skipped, got %+v", stats)
+	}
+}
+
+func TestCacheScanAndPurge(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := blob.NewLocal(dir)
+
+	old, recent := Key(".epub", "old"), Key(".epub", "recent")
+	for _, key := range []string{old, recent} {
+		if err := store.Put(ctx, key, []byte("123456")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, _ := store.Path(old)
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one file fits: the scan keeps the most recent
+	c := New(store, 8)
+	if err := c.Scan(ctx); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	stats := c.Stats()
+	if stats.Files != 1 || stats.Size != 6 || stats.ScannedAt == nil {
+		t.Fatalf("unexpected stats after scan %+v", stats)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the older file to be evicted, got %v", err)
+	}
+	read(t, c, recent)
+
+	files, size, err := c.Purge(ctx)
+	if err != nil || files != 1 || size != 6 {
+		t.Fatalf("Purge = %d, %d, %v", files, size, err)
+	}
+	if _, _, err := store.Get(ctx, recent); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("expected the purged file to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package convert turns book files into other formats. Converters are
+// registered per source and target format; External runs a command-line
+// tool such as Calibre's ebook-convert for pairs Go code cannot handle.
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupported is returned for format pairs without a converter.
+var ErrUnsupported = errors.New("conversion is not supported")
+
+// Converter turns a book file of one format into another.
+type Converter interface {
+	// Convert reads a file of format from and returns it in format to
+	Convert(ctx context.Context, src io.Reader, from, to string) ([]byte, error)
+	// Version identifies the converter and its settings, so that cached
+	// results of an older setup are not reused
+	Version() string
+}
+
+// pair is a source and target format
+type pair struct{ from, to string }
+
+// Registry holds the converters per format pair and bounds the number of
+// conversions running at once.
+type Registry struct {
+	mu         sync.RWMutex
+	converters map[pair]Converter
+	slots      chan struct{}
+}
+
+// NewRegistry creates an empty registry running at most concurrency
+// conversions at once; values below 1 allow one.
+func NewRegistry(concurrency int) *Registry {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Registry{
+		converters: make(map[pair]Converter),
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// Register makes c the converter from one format to another, replacing any
+// previous one. Formats are file extensions ("fb2", "epub").
+func (r *Registry) Register(from, to string, c Converter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.converters[pair{normalize(from), normalize(to)}] = c
+}
+
+// Lookup returns the converter between two formats.
+func (r *Registry) Lookup(from, to string) (Converter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.converters[pair{normalize(from), normalize(to)}]
+	return c, ok
+}
+
+// Targets returns the formats a file of format from converts to, sorted.
+func (r *Registry) Targets(from string) []string {
+	from = normalize(from)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var targets []string
+	for p := range r.converters {
+		if p.from == from {
+			targets = append(targets, p.to)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Convert converts src from one format to another, waiting for a free
+// slot first. It returns ErrUnsupported if no converter is registered.
+func (r *Registry) Convert(ctx context.Context, src io.Reader, from, to string) ([]byte, error) {
+	c, ok := r.Lookup(from, to)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s to %s", ErrUnsupported, from, to)
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.Convert(ctx, src, normalize(from), normalize(to))
+}
+
+func normalize(format string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+}
+
+// Rule is a converter command for one format pair, as configured.
+type Rule struct {
+	From    string
+	To      string
+	Command string
+}
+
+// ParseRules parses a semicolon-separated list of "pairs=command" items,
+// where pairs is a comma-separated list of "from:to" format pairs, e.g.
+// "fb2:epub,fb2:azw3=ebook-convert {input} {output}".
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pairs, command, ok := strings.Cut(item, "=")
+		command = strings.TrimSpace(command)
+		if !ok || command == "" {
+			return nil, fmt.Errorf("converter %q: expected from:to=command", item)
+		}
+		for _, p := range strings.Split(pairs, ",") {
+			from, to, ok := strings.Cut(p, ":")
+			from, to = normalize(from), normalize(to)
+			if !ok || from == "" || to == "" || from == to || strings.ContainsAny(from+to, `/\`) {
+				return nil, fmt.Errorf("converter %q: invalid format pair %q", item, strings.TrimSpace(p))
+			}
+			rules = append(rules, Rule{From: from, To: to, Command: command})
+		}
+	}
+	return rules, nil
+}
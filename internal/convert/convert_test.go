@@ -0,0 +1,107 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" fb2:epub, FB2:azw3 = ebook-convert {input} {output} ; pdf:epub=pdf2epub")
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	want := []Rule{
+		{From: "fb2", To: "epub", Command: "ebook-convert {input} {output}"},
+		{From: "fb2", To: "azw3", Command: "ebook-convert {input} {output}"},
+		{From: "pdf", To: "epub", Command: "pdf2epub"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{"fb2:epub", "fb2:epub=", "fb2=tool", "fb2:fb2=tool", "fb2:../x=tool"} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("ParseRules(%q): expected an error", spec)
+		}
+	}
+}
+
+// writeScript creates an executable shell script in dir
+func writeScript(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "tool.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return path
+}
+
+func TestExternal(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir := t.TempDir()
+	tmp := t.TempDir()
+
+	// The tool sees its input by extension and runs in its own directory
+	script := writeScript(t, dir, `case "$1" in *.fb2) ;; *) exit 3;; esac
+[ "$(pwd)" = "$HOME" ] || exit 4
+tr a-z A-Z < "$1" > "$2"`)
+	conv := &External{Args: []string{script, InputPlaceholder, OutputPlaceholder}, TempDir: tmp}
+
+	reg := NewRegistry(1)
+	reg.Register("FB2", "epub", conv)
+	out, err := reg.Convert(context.Background(), strings.NewReader("book"), "fb2", "EPUB")
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if string(out) != "BOOK" {
+		t.Errorf("got %q, want BOOK", out)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("conversion directories left behind: %v", entries)
+	}
+	if targets := reg.Targets("fb2"); len(targets) != 1 || targets[0] != "epub" {
+		t.Errorf("Targets = %v", targets)
+	}
+
+	if _, err := reg.Convert(context.Background(), strings.NewReader("book"), "fb2", "pdf"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	failing := &External{Args: []string{writeScript(t, t.TempDir(), "echo broken >&2; exit 1")}, TempDir: tmp}
+	if _, err := failing.Convert(context.Background(), strings.NewReader("book"), "fb2", "epub"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the tool's error output, got %v", err)
+	}
+
+	slow := &External{Args: []string{writeScript(t, t.TempDir(), "exec sleep 10")}, Timeout: 100 * time.Millisecond, TempDir: tmp}
+	start := time.Now()
+	if _, err := slow.Convert(context.Background(), strings.NewReader("book"), "fb2", "epub"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+}
+
+func TestRegistryConcurrency(t *testing.T) {
+	reg := NewRegistry(1)
+	reg.slots <- struct{}{} // a conversion is running
+	reg.Register("fb2", "epub", &External{Args: []string{"true"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := reg.Convert(ctx, strings.NewReader("book"), "fb2", "epub"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected to wait for a free slot, got %v", err)
+	}
+}
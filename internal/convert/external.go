@@ -0,0 +1,144 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Placeholders of an External command line
+const (
+	InputPlaceholder  = "{input}"
+	OutputPlaceholder = "{output}"
+)
+
+// DefaultTimeout bounds a conversion when External.Timeout is zero.
+const DefaultTimeout = 2 * time.Minute
+
+// maxStderr is how much of a failed tool's error output is reported
+const maxStderr = 2048
+
+// External converts books with a command-line tool, e.g.
+// "ebook-convert {input} {output}". Each run gets a private temporary
+// directory holding the input and output files, which is also the tool's
+// working, home and temp directory; it is removed afterwards.
+type External struct {
+	// Args is the command and its arguments. {input} and {output} are
+	// replaced with the file paths; without them the paths are appended.
+	Args []string
+	// Timeout bounds one run; the tool is killed when it expires
+	Timeout time.Duration
+	// TempDir is where run directories are created; empty means the
+	// system temp directory
+	TempDir string
+}
+
+// NewExternal parses a command line (split on spaces, without shell
+// quoting) and checks that the command exists.
+func NewExternal(command string, timeout time.Duration) (*External, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty converter command")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("converter command %q: %w", args[0], err)
+	}
+	return &External{Args: args, Timeout: timeout}, nil
+}
+
+// Version identifies the command line.
+func (e *External) Version() string {
+	return "external:" + strings.Join(e.Args, " ")
+}
+
+// Convert runs the tool on src and returns the file it wrote.
+func (e *External) Convert(ctx context.Context, src io.Reader, from, to string) ([]byte, error) {
+	dir, err := os.MkdirTemp(e.TempDir, "convert-")
+	if err != nil {
+		return nil, fmt.Errorf("create conversion directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The tool picks formats by file extension
+	input := filepath.Join(dir, "input."+from)
+	output := filepath.Join(dir, "output."+to)
+	if err := writeFile(input, src); err != nil {
+		return nil, err
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Args[0], e.args(input, output)...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"LANG=C.UTF-8",
+	}
+	cmd.WaitDelay = 5 * time.Second
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("converter %s timed out after %s", e.Args[0], timeout)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderr {
+			msg = "..." + msg[len(msg)-maxStderr:]
+		}
+		return nil, fmt.Errorf("converter %s failed: %w: %s", e.Args[0], err, msg)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("converter %s wrote no output: %w", e.Args[0], err)
+	}
+	return data, nil
+}
+
+// args returns the arguments with the placeholders replaced
+func (e *External) args(input, output string) []string {
+	args := make([]string, 0, len(e.Args)+1)
+	placed := false
+	for _, arg := range e.Args[1:] {
+		if strings.Contains(arg, InputPlaceholder) || strings.Contains(arg, OutputPlaceholder) {
+			placed = true
+		}
+		arg = strings.ReplaceAll(arg, InputPlaceholder, input)
+		args = append(args, strings.ReplaceAll(arg, OutputPlaceholder, output))
+	}
+	if !placed {
+		args = append(args, input, output)
+	}
+	return args
+}
+
+func writeFile(path string, src io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create conversion input: %w", err)
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return fmt.Errorf("write conversion input: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write conversion input: %w", err)
+	}
+	return nil
+}
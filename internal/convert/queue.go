@@ -0,0 +1,245 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a job is submitted to a full queue.
+var ErrQueueFull = errors.New("conversion queue is full")
+
+// States of a conversion job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// finishedJobRetention is how long finished jobs can be looked up; a job
+// submitted again after that runs again
+const finishedJobRetention = 10 * time.Minute
+
+// Task is the work of a job, such as converting a book and caching the
+// result
+type Task func(ctx context.Context) error
+
+// Job describes a conversion job. BookID, From and To are informational.
+type Job struct {
+	ID         string     `json:"id"`
+	BookID     string     `json:"book_id,omitempty"`
+	From       string     `json:"from,omitempty"`
+	To         string     `json:"to,omitempty"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// QueueStats describes the queue and its jobs since startup.
+type QueueStats struct {
+	Workers   int   `json:"workers"`
+	Capacity  int   `json:"capacity"`
+	Queued    int   `json:"queued"`
+	Running   int   `json:"running"`
+	Done      int64 `json:"done"`
+	Failed    int64 `json:"failed"`
+	Prewarmed int64 `json:"prewarmed"`
+}
+
+// job is a submitted job and its task
+type job struct {
+	Job
+	task       Task
+	background bool
+	done       chan struct{}
+}
+
+// Queue runs conversion jobs on a fixed number of workers. Jobs are
+// deduplicated by ID, so that requests for a book being converted wait for
+// the same job. Background jobs, such as pre-warming the cache, only run on
+// workers left idle by submitted ones.
+type Queue struct {
+	workers    int
+	pending    chan *job
+	background chan *job
+
+	mu        sync.Mutex
+	jobs      map[string]*job
+	running   int
+	done      int64
+	failed    int64
+	prewarmed int64
+}
+
+// NewQueue creates a queue of workers workers holding at most size waiting
+// jobs; values below 1 mean one. Call Start to run it.
+func NewQueue(workers, size int) *Queue {
+	workers, size = max(workers, 1), max(size, 1)
+	return &Queue{
+		workers:    workers,
+		pending:    make(chan *job, size),
+		background: make(chan *job),
+		jobs:       make(map[string]*job),
+	}
+}
+
+// Start runs the workers until ctx is done.
+func (q *Queue) Start(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		// Submitted jobs first, background ones when there are none
+		var j *job
+		select {
+		case j = <-q.pending:
+		default:
+			select {
+			case j = <-q.pending:
+			case j = <-q.background:
+			case <-ctx.Done():
+				return
+			}
+		}
+		q.run(ctx, j)
+	}
+}
+
+func (q *Queue) run(ctx context.Context, j *job) {
+	q.mu.Lock()
+	if j.background {
+		// Submitted meanwhile, or converted recently
+		if _, ok := q.jobs[j.ID]; ok {
+			q.mu.Unlock()
+			return
+		}
+		q.jobs[j.ID] = j
+	}
+	started := time.Now()
+	j.State, j.StartedAt = JobRunning, &started
+	q.running++
+	q.mu.Unlock()
+
+	err := j.task(ctx)
+
+	q.mu.Lock()
+	finished := time.Now()
+	j.FinishedAt = &finished
+	q.running--
+	if err != nil {
+		j.State, j.Error = JobFailed, err.Error()
+		q.failed++
+		log.Printf("Conversion: job %s (book_id=%s, %s to %s) failed: %v", j.ID, j.BookID, j.From, j.To, err)
+	} else {
+		j.State = JobDone
+		q.done++
+		if j.background {
+			q.prewarmed++
+		}
+	}
+	q.mu.Unlock()
+	close(j.done)
+}
+
+// Submit queues a job running task, or returns the job of the same ID
+// submitted before. It returns ErrQueueFull if too many jobs are waiting.
+func (q *Queue) Submit(desc Job, task Task) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pruneLocked()
+	if j, ok := q.jobs[desc.ID]; ok {
+		return j.Job, nil
+	}
+
+	j := &job{Job: desc, task: task, done: make(chan struct{})}
+	j.State, j.QueuedAt = JobQueued, time.Now()
+	select {
+	case q.pending <- j:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[j.ID] = j
+	return j.Job, nil
+}
+
+// Prewarm runs task as a background job once a worker is idle, blocking
+// until then or until ctx is done. Jobs of IDs known to the queue are
+// skipped.
+func (q *Queue) Prewarm(ctx context.Context, desc Job, task Task) error {
+	q.mu.Lock()
+	_, known := q.jobs[desc.ID]
+	q.mu.Unlock()
+	if known {
+		return nil
+	}
+
+	j := &job{Job: desc, task: task, background: true, done: make(chan struct{})}
+	j.State, j.QueuedAt = JobQueued, time.Now()
+	select {
+	case q.background <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Status returns the job of an ID, if it is waiting, running or finished
+// recently.
+func (q *Queue) Status(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// Wait waits for the job of an ID to finish or ctx to be done, and returns
+// the job as it then is.
+func (q *Queue) Wait(ctx context.Context, id string) (Job, bool) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Job{}, false
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+	}
+	return q.Status(id)
+}
+
+// Stats returns the queue statistics.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Workers:   q.workers,
+		Capacity:  cap(q.pending),
+		Queued:    len(q.pending),
+		Running:   q.running,
+		Done:      q.done,
+		Failed:    q.failed,
+		Prewarmed: q.prewarmed,
+	}
+}
+
+// pruneLocked forgets the jobs finished more than finishedJobRetention ago
+func (q *Queue) pruneLocked() {
+	cutoff := time.Now().Add(-finishedJobRetention)
+	for id, j := range q.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+}
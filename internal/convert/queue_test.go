@@ -0,0 +1,102 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestQueue checks jobs are deduplicated by ID, run by the workers, and
+// refused when too many are waiting, and that failures are reported.
+func TestQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewQueue(1, 1)
+
+	// Queued before the worker starts, so the second waits
+	release := make(chan struct{})
+	runs := 0
+	blocked := func(ctx context.Context) error {
+		runs++
+		<-release
+		return nil
+	}
+	if _, err := q.Submit(Job{ID: "a"}, blocked); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if job, err := q.Submit(Job{ID: "a"}, blocked); err != nil || job.State != JobQueued {
+		t.Fatalf("resubmitted job: %+v, %v", job, err)
+	}
+	if _, err := q.Submit(Job{ID: "b"}, blocked); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	q.Start(ctx)
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, 50*time.Millisecond)
+	job, ok := q.Wait(waitCtx, "a")
+	cancelWait()
+	if !ok || job.State != JobRunning || job.StartedAt == nil {
+		t.Fatalf("expected a running job, got %+v", job)
+	}
+	close(release)
+	if job, _ = q.Wait(ctx, "a"); job.State != JobDone || job.FinishedAt == nil {
+		t.Fatalf("expected a finished job, got %+v", job)
+	}
+	if runs != 1 {
+		t.Errorf("job ran %d times, want 1", runs)
+	}
+
+	if _, err := q.Submit(Job{ID: "c"}, func(context.Context) error { return errors.New("broken") }); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if job, _ = q.Wait(ctx, "c"); job.State != JobFailed || job.Error != "broken" {
+		t.Errorf("expected a failed job, got %+v", job)
+	}
+	if _, ok := q.Status("unknown"); ok {
+		t.Error("unknown job found")
+	}
+
+	stats := q.Stats()
+	if stats.Done != 1 || stats.Failed != 1 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// TestQueue_Prewarm checks background jobs run on idle workers and skip
+// jobs the queue knows.
+func TestQueue_Prewarm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewQueue(1, 4)
+	q.Start(ctx)
+
+	ran := make(chan string, 2)
+	task := func(id string) Task {
+		return func(context.Context) error {
+			ran <- id
+			return nil
+		}
+	}
+	if _, err := q.Submit(Job{ID: "a"}, task("a")); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	q.Wait(ctx, "a")
+	<-ran
+
+	if err := q.Prewarm(ctx, Job{ID: "a"}, task("a again")); err != nil {
+		t.Fatalf("Prewarm failed: %v", err)
+	}
+	if err := q.Prewarm(ctx, Job{ID: "b"}, task("b")); err != nil {
+		t.Fatalf("Prewarm failed: %v", err)
+	}
+	if id := <-ran; id != "b" {
+		t.Errorf("expected the new job to run, got %q", id)
+	}
+	if _, ok := q.Wait(ctx, "b"); !ok {
+		t.Fatal("pre-warm job not tracked")
+	}
+	if stats := q.Stats(); stats.Prewarmed != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
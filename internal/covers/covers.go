@@ -0,0 +1,173 @@
+// Package covers stores book cover thumbnails in the cache storage.
+package covers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for FB2 cover formats
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"slices"
+
+	"github.com/piligrim/pushkinlib/internal/blob"
+)
+
+// Thumbnail bounds; covers are scaled down to fit, never up.
+const (
+	ThumbnailWidth  = 300
+	ThumbnailHeight = 450
+)
+
+// Widths are the thumbnail widths Resize accepts. The set is small so that
+// clients cannot make the server render arbitrary sizes.
+var Widths = []int{100, 150, 200, ThumbnailWidth}
+
+// Store keeps JPEG thumbnails in a blob store, one blob per book.
+type Store struct {
+	blobs blob.Store
+}
+
+// NewStore creates a store rooted at the local directory dir.
+func NewStore(dir string) *Store {
+	return NewBlobStore(blob.NewLocal(dir))
+}
+
+// NewBlobStore creates a store on top of blobs, e.g. an S3 bucket.
+func NewBlobStore(blobs blob.Store) *Store {
+	return &Store{blobs: blobs}
+}
+
+// Key returns the blob key of a book thumbnail. Book IDs are hashed so
+// that arbitrary IDs cannot escape the cache directory.
+func Key(bookID string) string {
+	sum := sha1.Sum([]byte(bookID))
+	name := hex.EncodeToString(sum[:])
+	return name[:2] + "/" + name + ".jpg"
+}
+
+// Save writes a thumbnail for bookID, replacing any existing one.
+func (s *Store) Save(bookID string, thumbnail []byte) error {
+	if err := s.blobs.Put(context.Background(), Key(bookID), thumbnail); err != nil {
+		return fmt.Errorf("write cover: %w", err)
+	}
+	return nil
+}
+
+// Open returns the thumbnail of bookID, or blob.ErrNotFound.
+func (s *Store) Open(ctx context.Context, bookID string) (io.ReadSeekCloser, blob.Info, error) {
+	return s.blobs.Get(ctx, Key(bookID))
+}
+
+// Hash returns a short content hash of a thumbnail. It is part of the
+// cover URL, so a new cover gets a new URL and old ones can be cached
+// forever.
+func Hash(thumbnail []byte) string {
+	sum := sha256.Sum256(thumbnail)
+	return hex.EncodeToString(sum[:8])
+}
+
+// URL returns the address of a book cover under baseURL. Covers whose hash
+// is not known yet are served from the uncached API address.
+func URL(baseURL, bookID, hash string) string {
+	if hash == "" {
+		return baseURL + "/api/v1/books/" + bookID + "/cover"
+	}
+	return baseURL + "/covers/" + bookID + "/" + hash + ".jpg"
+}
+
+// AllowedWidth reports whether width is one of Widths
+func AllowedWidth(width int) bool {
+	return slices.Contains(Widths, width)
+}
+
+// Resize scales a thumbnail down to width, keeping its aspect ratio.
+// Thumbnails that are already narrow enough are returned as they are.
+func Resize(thumbnail []byte, width int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		return nil, fmt.Errorf("decode thumbnail: %w", err)
+	}
+	if src.Bounds().Dx() <= width {
+		return thumbnail, nil
+	}
+
+	dst := scaleToFit(src, width, src.Bounds().Dy())
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// MakeThumbnail decodes an image and re-encodes it as a JPEG that fits
+// within ThumbnailWidth x ThumbnailHeight.
+func MakeThumbnail(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	dst := scaleToFit(src, ThumbnailWidth, ThumbnailHeight)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleToFit downsamples src with box filtering so that it fits in
+// maxW x maxH, preserving the aspect ratio.
+func scaleToFit(src image.Image, maxW, maxH int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return src
+	}
+
+	scale := min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	if scale >= 1 {
+		return src
+	}
+
+	dw := max(1, int(float64(w)*scale))
+	dh := max(1, int(float64(h)*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0 := b.Min.Y + y*h/dh
+		y1 := max(y0+1, b.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			x0 := b.Min.X + x*w/dw
+			x1 := max(x0+1, b.Min.X+(x+1)*w/dw)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
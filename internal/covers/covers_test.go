@@ -0,0 +1,126 @@
+package covers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/blob"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	cases := []struct {
+		name         string
+		w, h         int
+		wantW, wantH int
+	}{
+		{name: "tall", w: 600, h: 1200, wantW: 225, wantH: 450},
+		{name: "wide", w: 900, h: 300, wantW: 300, wantH: 100},
+		{name: "small", w: 100, h: 150, wantW: 100, wantH: 150},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			thumb, err := MakeThumbnail(encodePNG(t, tc.w, tc.h))
+			if err != nil {
+				t.Fatalf("MakeThumbnail failed: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatalf("thumbnail is not a JPEG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tc.wantW || b.Dy() != tc.wantH {
+				t.Errorf("thumbnail size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tc.wantW, tc.wantH)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnail_InvalidImage(t *testing.T) {
+	if _, err := MakeThumbnail([]byte("not an image")); err == nil {
+		t.Error("expected error for invalid image data")
+	}
+}
+
+func TestResize(t *testing.T) {
+	thumb, err := MakeThumbnail(encodePNG(t, 600, 900))
+	if err != nil {
+		t.Fatalf("MakeThumbnail failed: %v", err)
+	}
+
+	resized, err := Resize(thumb, 100)
+	if err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(resized))
+	if err != nil {
+		t.Fatalf("resized cover is not a JPEG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 100 || b.Dy() != 150 {
+		t.Errorf("resized cover size = %dx%d, want 100x150", b.Dx(), b.Dy())
+	}
+
+	if same, err := Resize(thumb, ThumbnailWidth); err != nil || !bytes.Equal(same, thumb) {
+		t.Errorf("Resize to the thumbnail width changed it: %v", err)
+	}
+	if Hash(thumb) == Hash(resized) || len(Hash(thumb)) != 16 {
+		t.Errorf("unexpected hashes %q and %q", Hash(thumb), Hash(resized))
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir)
+
+	key := Key("../../etc/passwd")
+	if path, err := blob.NewLocal(dir).Path(key); err != nil || !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		t.Fatalf("cover key %s escapes store dir %s: %v", key, dir, err)
+	}
+
+	if err := store.Save("book-1", []byte("jpeg")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(Key("book-1"))))
+	if err != nil || string(data) != "jpeg" {
+		t.Fatalf("unexpected stored cover %q: %v", data, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*", "*.tmp")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	rc, _, err := store.Open(context.Background(), "book-1")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer rc.Close()
+	if data, _ := io.ReadAll(rc); string(data) != "jpeg" {
+		t.Errorf("Open returned %q", data)
+	}
+	if _, _, err := store.Open(context.Background(), "book-2"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing cover, got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+// Package enrichment fetches author biographies and portraits from
+// Wikipedia/Wikidata and caches them in the library database.
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// ErrDisabled is returned when enrichment is not enabled.
+var ErrDisabled = errors.New("author enrichment is disabled")
+
+// maxPortraitSize caps the portraits downloaded by Portrait.
+const maxPortraitSize = 2 << 20
+
+// Config holds enrichment settings.
+type Config struct {
+	// Language is the Wikipedia language edition, e.g. "ru".
+	Language string
+	// RequestInterval is the minimum delay between outgoing requests.
+	RequestInterval time.Duration
+	// CacheTTL is how long fetched results (including misses) are kept.
+	CacheTTL time.Duration
+	// APIURL overrides the MediaWiki API endpoint; used in tests.
+	APIURL string
+	// UserAgent is sent with every request, as required by Wikimedia.
+	UserAgent string
+}
+
+// Service looks up author info and caches it in the database.
+type Service struct {
+	repo   *storage.Repository
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	lastReq time.Time
+
+	queue   chan string
+	pending sync.Map
+}
+
+// NewService creates an enrichment service with defaults applied.
+func NewService(repo *storage.Repository, cfg Config) *Service {
+	if cfg.Language == "" {
+		cfg.Language = "ru"
+	}
+	if cfg.RequestInterval <= 0 {
+		cfg.RequestInterval = time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 30 * 24 * time.Hour
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = fmt.Sprintf("https://%s.wikipedia.org/w/api.php", cfg.Language)
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "Pushkinlib (https://github.com/piligrim/pushkinlib)"
+	}
+
+	return &Service{
+		repo:   repo,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+		queue:  make(chan string, 256),
+	}
+}
+
+// Start runs the background worker that fetches queued authors until ctx
+// is cancelled.
+func (s *Service) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case name := <-s.queue:
+				if _, err := s.Lookup(ctx, name); err != nil && ctx.Err() == nil {
+					log.Printf("Enrichment: failed to fetch info for %q: %v", name, err)
+				}
+				s.pending.Delete(name)
+			}
+		}
+	}()
+}
+
+// Lookup returns info for an author, fetching it when the cache is empty
+// or stale. A nil result means the author was not found.
+func (s *Service) Lookup(ctx context.Context, name string) (*storage.AuthorInfo, error) {
+	if s == nil {
+		return nil, ErrDisabled
+	}
+
+	cached, err := s.repo.GetAuthorInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && time.Since(cached.FetchedAt) < s.cfg.CacheTTL {
+		return foundOrNil(cached), nil
+	}
+
+	info, err := s.fetch(ctx, name)
+	if err != nil {
+		// Serve stale data rather than nothing when the source is unavailable
+		if cached != nil {
+			return foundOrNil(cached), nil
+		}
+		return nil, err
+	}
+
+	if err := s.repo.SaveAuthorInfo(info); err != nil {
+		return nil, err
+	}
+	return foundOrNil(info), nil
+}
+
+// Cached returns info from the cache without network access. Missing or
+// stale entries are queued for background fetching.
+func (s *Service) Cached(name string) *storage.AuthorInfo {
+	if s == nil {
+		return nil
+	}
+
+	cached, err := s.repo.GetAuthorInfo(name)
+	if err != nil {
+		log.Printf("Enrichment: %v", err)
+		return nil
+	}
+	if cached == nil || time.Since(cached.FetchedAt) >= s.cfg.CacheTTL {
+		s.enqueue(name)
+	}
+	if cached == nil {
+		return nil
+	}
+	return foundOrNil(cached)
+}
+
+// Portrait downloads the portrait of an author found by an earlier lookup,
+// so that clients get it from this server instead of from Wikimedia. A nil
+// result means there is none.
+func (s *Service) Portrait(ctx context.Context, name string) ([]byte, string, error) {
+	if s == nil {
+		return nil, "", ErrDisabled
+	}
+	info, err := s.repo.GetAuthorInfo(name)
+	if err != nil || info == nil || !info.Found || info.PhotoURL == "" {
+		return nil, "", err
+	}
+	if u, err := url.Parse(info.PhotoURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, "", fmt.Errorf("invalid portrait URL %q", info.PhotoURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, info.PhotoURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("portrait is %q, not an image", contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPortraitSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read portrait: %w", err)
+	}
+	if len(data) > maxPortraitSize {
+		return nil, "", fmt.Errorf("portrait is larger than %d bytes", maxPortraitSize)
+	}
+	return data, contentType, nil
+}
+
+func (s *Service) enqueue(name string) {
+	if _, loaded := s.pending.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+	select {
+	case s.queue <- name:
+	default:
+		// Queue is full; the author will be requested again on a later visit
+		s.pending.Delete(name)
+	}
+}
+
+func foundOrNil(info *storage.AuthorInfo) *storage.AuthorInfo {
+	if !info.Found {
+		return nil
+	}
+	return info
+}
+
+// wait blocks until the next request is allowed by the rate limit.
+func (s *Service) wait(ctx context.Context) error {
+	s.mu.Lock()
+	next := s.lastReq.Add(s.cfg.RequestInterval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	s.lastReq = next
+	s.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// apiResponse is the subset of the MediaWiki query response we use.
+type apiResponse struct {
+	Query struct {
+		Pages []struct {
+			Title     string `json:"title"`
+			Missing   bool   `json:"missing"`
+			Extract   string `json:"extract"`
+			FullURL   string `json:"fullurl"`
+			Thumbnail struct {
+				Source string `json:"source"`
+			} `json:"thumbnail"`
+			PageProps struct {
+				WikibaseItem string `json:"wikibase_item"`
+			} `json:"pageprops"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// fetch queries Wikipedia for the best matching article about a writer and
+// returns its intro, thumbnail and Wikidata ID.
+func (s *Service) fetch(ctx context.Context, name string) (*storage.AuthorInfo, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"action":        {"query"},
+		"format":        {"json"},
+		"formatversion": {"2"},
+		"generator":     {"search"},
+		"gsrsearch":     {name},
+		"gsrlimit":      {"1"},
+		"prop":          {"extracts|pageimages|pageprops|info"},
+		"exintro":       {"1"},
+		"explaintext":   {"1"},
+		"exsentences":   {"5"},
+		"piprop":        {"thumbnail"},
+		"pithumbsize":   {"400"},
+		"ppprop":        {"wikibase_item"},
+		"inprop":        {"url"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.APIURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var data apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	info := &storage.AuthorInfo{
+		AuthorName: name,
+		FetchedAt:  time.Now(),
+	}
+	if len(data.Query.Pages) == 0 || data.Query.Pages[0].Missing {
+		return info, nil
+	}
+
+	page := data.Query.Pages[0]
+	info.Found = strings.TrimSpace(page.Extract) != ""
+	info.Bio = strings.TrimSpace(page.Extract)
+	info.PhotoURL = page.Thumbnail.Source
+	info.SourceURL = page.FullURL
+	info.WikidataID = page.PageProps.WikibaseItem
+	return info, nil
+}
@@ -0,0 +1,213 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func setupTestService(t *testing.T, handler http.HandlerFunc) (*Service, *storage.Repository) {
+	t.Helper()
+
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	repo := storage.NewRepository(db)
+	service := NewService(repo, Config{
+		APIURL:          server.URL,
+		RequestInterval: time.Millisecond,
+	})
+	return service, repo
+}
+
+func wikipediaHandler(requests *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("gsrsearch") != "Пушкин Александр" {
+			fmt.Fprint(w, `{"batchcomplete":true}`)
+			return
+		}
+		fmt.Fprint(w, `{"query":{"pages":[{
+			"title":"Пушкин, Александр Сергеевич",
+			"extract":"Русский поэт, драматург и прозаик.",
+			"fullurl":"https://ru.wikipedia.org/wiki/Pushkin",
+			"thumbnail":{"source":"https://upload.wikimedia.org/pushkin.jpg"},
+			"pageprops":{"wikibase_item":"Q7200"}
+		}]}}`)
+	}
+}
+
+func TestLookupFetchesAndCaches(t *testing.T) {
+	var requests int32
+	service, repo := setupTestService(t, wikipediaHandler(&requests))
+
+	info, err := service.Lookup(context.Background(), "Пушкин Александр")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if info == nil {
+		t.Fatal("expected author info")
+	}
+	if info.Bio != "Русский поэт, драматург и прозаик." || info.WikidataID != "Q7200" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if info.PhotoURL != "https://upload.wikimedia.org/pushkin.jpg" {
+		t.Errorf("unexpected photo URL %q", info.PhotoURL)
+	}
+
+	if _, err := service.Lookup(context.Background(), "Пушкин Александр"); err != nil {
+		t.Fatalf("second Lookup failed: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("expected cached result to be reused, got %d requests", got)
+	}
+
+	stored, err := repo.GetAuthorInfo("Пушкин Александр")
+	if err != nil || stored == nil || !stored.Found {
+		t.Fatalf("expected info to be stored, got %+v (%v)", stored, err)
+	}
+}
+
+func TestLookupCachesMisses(t *testing.T) {
+	var requests int32
+	service, _ := setupTestService(t, wikipediaHandler(&requests))
+
+	for i := 0; i < 2; i++ {
+		info, err := service.Lookup(context.Background(), "Неизвестный Автор")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		if info != nil {
+			t.Fatalf("expected no info for unknown author, got %+v", info)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("expected miss to be cached, got %d requests", got)
+	}
+}
+
+func TestLookupServesStaleOnError(t *testing.T) {
+	service, repo := setupTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	if err := repo.SaveAuthorInfo(&storage.AuthorInfo{
+		AuthorName: "Гоголь Николай",
+		Found:      true,
+		Bio:        "Старая биография",
+		FetchedAt:  time.Now().Add(-365 * 24 * time.Hour),
+	}); err != nil {
+		t.Fatalf("SaveAuthorInfo failed: %v", err)
+	}
+
+	info, err := service.Lookup(context.Background(), "Гоголь Николай")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if info == nil || info.Bio != "Старая биография" {
+		t.Errorf("expected stale info, got %+v", info)
+	}
+
+	if _, err := service.Lookup(context.Background(), "Тургенев Иван"); err == nil {
+		t.Error("expected error when source is unavailable and nothing is cached")
+	}
+}
+
+func TestCachedQueuesBackgroundFetch(t *testing.T) {
+	var requests int32
+	service, _ := setupTestService(t, wikipediaHandler(&requests))
+
+	if info := service.Cached("Пушкин Александр"); info != nil {
+		t.Fatalf("expected empty cache, got %+v", info)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if info := service.Cached("Пушкин Александр"); info != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("background worker did not fetch queued author")
+}
+
+func TestPortrait(t *testing.T) {
+	service, repo := setupTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprint(w, "jpeg-data")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		}
+	})
+	ctx := context.Background()
+
+	if data, _, err := service.Portrait(ctx, "Пушкин Александр"); err != nil || data != nil {
+		t.Fatalf("expected no portrait without info, got %q, %v", data, err)
+	}
+
+	save := func(photoURL string) {
+		t.Helper()
+		if err := repo.SaveAuthorInfo(&storage.AuthorInfo{
+			AuthorName: "Пушкин Александр",
+			Found:      true,
+			PhotoURL:   photoURL,
+			FetchedAt:  time.Now(),
+		}); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+	}
+
+	save(service.cfg.APIURL + "/photo.jpg")
+	data, contentType, err := service.Portrait(ctx, "Пушкин Александр")
+	if err != nil {
+		t.Fatalf("Portrait failed: %v", err)
+	}
+	if string(data) != "jpeg-data" || contentType != "image/jpeg" {
+		t.Errorf("unexpected portrait %q (%s)", data, contentType)
+	}
+
+	save(service.cfg.APIURL + "/page.html")
+	if _, _, err := service.Portrait(ctx, "Пушкин Александр"); err == nil {
+		t.Error("expected error for a non-image response")
+	}
+
+	save("file:///etc/passwd")
+	if _, _, err := service.Portrait(ctx, "Пушкин Александр"); err == nil {
+		t.Error("expected error for a non-HTTP portrait URL")
+	}
+}
+
+func TestWaitRespectsRequestInterval(t *testing.T) {
+	service := NewService(nil, Config{RequestInterval: 50 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := service.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected requests to be spaced out, took %s", elapsed)
+	}
+}
@@ -0,0 +1,150 @@
+// Package genres holds the FB2 genre taxonomy: the genre codes of the
+// FictionBook standard with their Russian and English names, in groups such
+// as "Фантастика". The list is built into the binary; a CSV file can rename
+// genres and add codes of its own.
+package genres
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed fb2_genres.csv
+var embedded []byte
+
+// Genre is a genre code and its names
+type Genre struct {
+	Code   string `json:"code"`
+	NameRu string `json:"name_ru"`
+	NameEn string `json:"name_en"`
+	Group  string `json:"group"`
+}
+
+// Default returns the built-in genres, grouped, in the order of the list.
+func Default() []Genre {
+	list, err := parse(bytes.NewReader(embedded), nil)
+	if err != nil {
+		panic(fmt.Sprintf("genres: embedded list: %v", err))
+	}
+	return list
+}
+
+// Load returns the built-in genres overridden by the CSV at path, which has
+// a "code" column and any of "name_ru" (or "name"), "name_en" and "group".
+// Non-empty cells replace those of the built-in genre of the same code;
+// unknown codes are added at the end. An empty path or a missing file
+// leaves the built-in list as is.
+func Load(path string) ([]Genre, error) {
+	list := Default()
+	if strings.TrimSpace(path) == "" {
+		return list, nil
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open genre list: %w", err)
+	}
+	defer file.Close()
+
+	list, err = parse(file, list)
+	if err != nil {
+		return nil, fmt.Errorf("genre list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// parse reads a genre CSV over base
+func parse(r io.Reader, base []Genre) ([]Genre, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return base, nil
+	}
+
+	columns := map[string]int{}
+	for i, header := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(header))] = i
+	}
+	codeIndex, ok := columns["code"]
+	if !ok {
+		return nil, errors.New("no code column")
+	}
+	if _, ok := columns["name_ru"]; !ok {
+		if i, ok := columns["name"]; ok {
+			columns["name_ru"] = i
+		}
+	}
+	cell := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	list := append([]Genre(nil), base...)
+	index := make(map[string]int, len(list))
+	for i, g := range list {
+		index[g.Code] = i
+	}
+	for _, record := range records[1:] {
+		if codeIndex >= len(record) {
+			continue
+		}
+		code := strings.ToLower(strings.TrimSpace(record[codeIndex]))
+		if code == "" {
+			continue
+		}
+		i, ok := index[code]
+		if !ok {
+			i = len(list)
+			index[code] = i
+			list = append(list, Genre{Code: code})
+		}
+		g := &list[i]
+		if v := cell(record, "name_ru"); v != "" {
+			g.NameRu = v
+		}
+		if v := cell(record, "name_en"); v != "" {
+			g.NameEn = v
+		}
+		if v := cell(record, "group"); v != "" {
+			g.Group = v
+		}
+	}
+	return list, nil
+}
+
+// Names maps the codes of genres to their Russian names, or to the code
+// itself for genres without one.
+func Names(list []Genre) map[string]string {
+	names := make(map[string]string, len(list))
+	for _, g := range list {
+		names[g.Code] = g.NameRu
+		if g.NameRu == "" {
+			names[g.Code] = g.Code
+		}
+	}
+	return names
+}
+
+// Codes returns the set of the codes of genres.
+func Codes(list []Genre) map[string]bool {
+	codes := make(map[string]bool, len(list))
+	for _, g := range list {
+		codes[g.Code] = true
+	}
+	return codes
+}
@@ -0,0 +1,68 @@
+package genres
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDefault checks every built-in genre has a unique lowercase code, both
+// names and a group.
+func TestDefault(t *testing.T) {
+	list := Default()
+	if len(list) < 150 {
+		t.Fatalf("expected the FB2 genre list, got %d genres", len(list))
+	}
+	seen := make(map[string]bool)
+	for _, g := range list {
+		if seen[g.Code] {
+			t.Errorf("duplicate code %q", g.Code)
+		}
+		seen[g.Code] = true
+		if g.NameRu == "" || g.NameEn == "" || g.Group == "" {
+			t.Errorf("incomplete genre %+v", g)
+		}
+	}
+	if names := Names(list); names["sf_space"] != "Космическая фантастика" {
+		t.Errorf("unexpected name of sf_space %q", names["sf_space"])
+	}
+}
+
+// TestLoad checks a CSV renames built-in genres and adds its own, and that
+// no file leaves the built-in list.
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "genres.csv")
+	csv := "Code,Name\nSF_SPACE,Космос\nlocal_history,Краеведение\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	list, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if n := len(list); n != len(Default())+1 {
+		t.Errorf("expected one genre added, got %d genres", n)
+	}
+	byCode := make(map[string]Genre)
+	for _, g := range list {
+		byCode[g.Code] = g
+	}
+	if g := byCode["sf_space"]; g.NameRu != "Космос" || g.NameEn != "Space science fiction" || g.Group != "Фантастика" {
+		t.Errorf("unexpected override %+v", g)
+	}
+	if g := byCode["local_history"]; g.NameRu != "Краеведение" || !Codes(list)["local_history"] {
+		t.Errorf("unexpected added genre %+v", g)
+	}
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "missing.csv")} {
+		if list, err := Load(path); err != nil || len(list) != len(Default()) {
+			t.Errorf("Load(%q): %d genres, %v", path, len(list), err)
+		}
+	}
+	if err := os.WriteFile(path, []byte("name\nx\n"), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for a CSV without codes")
+	}
+}
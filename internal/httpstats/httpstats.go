@@ -0,0 +1,212 @@
+// Package httpstats aggregates request counts and latency percentiles per
+// route group and per route, for operators without a Prometheus stack.
+// Percentiles are computed over the most recent requests of each series, so
+// the memory used does not grow with traffic.
+package httpstats
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// sampleWindow is the number of recent latencies percentiles are computed over
+const sampleWindow = 2048
+
+// Route groups
+const (
+	GroupAPI       = "api"
+	GroupOPDS      = "opds"
+	GroupDownloads = "downloads"
+	GroupOther     = "other"
+)
+
+// Series are the statistics of a group or route. Latencies are in
+// milliseconds.
+type Series struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	P50          float64 `json:"p50_ms"`
+	P95          float64 `json:"p95_ms"`
+	P99          float64 `json:"p99_ms"`
+	Max          float64 `json:"max_ms"`
+}
+
+// Snapshot is the state of a Collector.
+type Snapshot struct {
+	Since  time.Time         `json:"since"`
+	Groups map[string]Series `json:"groups"`
+	// Routes are keyed by method and chi route pattern
+	// ("GET /api/v1/books/{id}")
+	Routes map[string]Series `json:"routes"`
+}
+
+// series accumulates one Series
+type series struct {
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+	max          time.Duration
+	samples      []time.Duration // ring buffer of the latest latencies
+	next         int
+}
+
+func (s *series) add(status int, d time.Duration) {
+	s.requests++
+	switch {
+	case status >= 500:
+		s.serverErrors++
+	case status >= 400:
+		s.clientErrors++
+	}
+	if d > s.max {
+		s.max = d
+	}
+	if len(s.samples) < sampleWindow {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % sampleWindow
+}
+
+func (s *series) snapshot() Series {
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return Series{
+		Requests:     s.requests,
+		ClientErrors: s.clientErrors,
+		ServerErrors: s.serverErrors,
+		P50:          milliseconds(percentile(sorted, 0.50)),
+		P95:          milliseconds(percentile(sorted, 0.95)),
+		P99:          milliseconds(percentile(sorted, 0.99)),
+		Max:          milliseconds(s.max),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Collector records the requests passing through its middleware.
+type Collector struct {
+	mu     sync.Mutex
+	since  time.Time
+	groups map[string]*series
+	routes map[string]*series
+}
+
+// New creates an empty collector.
+func New() *Collector {
+	c := &Collector{}
+	c.Reset()
+	return c
+}
+
+// Reset drops all statistics collected so far.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.since = time.Now()
+	c.groups = make(map[string]*series)
+	c.routes = make(map[string]*series)
+}
+
+// Record adds a request to the group and route statistics; an empty route
+// is counted in its group only.
+func (c *Collector) Record(group, route string, status int, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(c.groups, group).add(status, d)
+	if route != "" {
+		c.get(c.routes, route).add(status, d)
+	}
+}
+
+func (c *Collector) get(m map[string]*series, key string) *series {
+	s, ok := m[key]
+	if !ok {
+		s = &series{}
+		m[key] = s
+	}
+	return s
+}
+
+// Snapshot returns the statistics collected since startup or the last
+// Reset.
+func (c *Collector) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := Snapshot{
+		Since:  c.since,
+		Groups: make(map[string]Series, len(c.groups)),
+		Routes: make(map[string]Series, len(c.routes)),
+	}
+	for key, s := range c.groups {
+		snap.Groups[key] = s.snapshot()
+	}
+	for key, s := range c.routes {
+		snap.Routes[key] = s.snapshot()
+	}
+	return snap
+}
+
+// Group returns the route group of a request path.
+func Group(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/download/"):
+		return GroupDownloads
+	case path == "/opds" || strings.HasPrefix(path, "/opds/"):
+		return GroupOPDS
+	case strings.HasPrefix(path, "/api/"):
+		return GroupAPI
+	default:
+		return GroupOther
+	}
+}
+
+// Middleware records the latency and status of every request. Mount it on
+// the root chi router: the route pattern is read after the request is
+// served, when chi has matched every subrouter. Requests that match no
+// route are counted in their group only.
+func (c *Collector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		var route string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = r.Method + " " + pattern
+			}
+		}
+		c.Record(Group(r.URL.Path), route, status, time.Since(start))
+	})
+}
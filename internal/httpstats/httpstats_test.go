@@ -0,0 +1,99 @@
+package httpstats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCollectorPercentiles(t *testing.T) {
+	c := New()
+	for i := 1; i <= 100; i++ {
+		status := http.StatusOK
+		if i%10 == 0 {
+			status = http.StatusInternalServerError
+		}
+		c.Record(GroupAPI, "GET /api/v1/books", status, time.Duration(i)*time.Millisecond)
+	}
+
+	s := c.Snapshot().Groups[GroupAPI]
+	want := Series{Requests: 100, ServerErrors: 10, P50: 50, P95: 95, P99: 99, Max: 100}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+	if r := c.Snapshot().Routes["GET /api/v1/books"]; r != want {
+		t.Errorf("route: got %+v, want %+v", r, want)
+	}
+
+	c.Reset()
+	if snap := c.Snapshot(); len(snap.Groups) != 0 || len(snap.Routes) != 0 {
+		t.Errorf("expected no statistics after reset, got %+v", snap)
+	}
+}
+
+func TestCollectorWindow(t *testing.T) {
+	c := New()
+	for i := 0; i < sampleWindow; i++ {
+		c.Record(GroupOPDS, "", http.StatusOK, time.Second)
+	}
+	// The slow requests leave the window, the maximum stays
+	for i := 0; i < sampleWindow; i++ {
+		c.Record(GroupOPDS, "", http.StatusOK, time.Millisecond)
+	}
+
+	s := c.Snapshot().Groups[GroupOPDS]
+	if s.Requests != 2*sampleWindow || s.P99 != 1 || s.Max != 1000 {
+		t.Errorf("unexpected series %+v", s)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	cases := map[string]string{
+		"/api/v1/books":      GroupAPI,
+		"/opds":              GroupOPDS,
+		"/opds/search":       GroupOPDS,
+		"/opdsx":             GroupOther,
+		"/download/test-001": GroupDownloads,
+		"/books/test-001":    GroupOther,
+		"/":                  GroupOther,
+	}
+	for path, want := range cases {
+		if got := Group(path); got != want {
+			t.Errorf("Group(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := New()
+	r := chi.NewRouter()
+	r.Use(c.Middleware)
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Get("/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if chi.URLParam(r, "id") == "missing" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte("ok"))
+		})
+	})
+
+	for _, path := range []string{"/api/v1/books/1", "/api/v1/books/2", "/api/v1/books/missing", "/download/1"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	snap := c.Snapshot()
+	route := snap.Routes["GET /api/v1/books/{id}"]
+	if route.Requests != 3 || route.ClientErrors != 1 {
+		t.Errorf("unexpected route series %+v", route)
+	}
+	if len(snap.Routes) != 1 {
+		t.Errorf("unmatched requests must not create routes: %v", snap.Routes)
+	}
+	if n := snap.Groups[GroupDownloads].Requests; n != 1 {
+		t.Errorf("downloads requests = %d, want 1", n)
+	}
+}
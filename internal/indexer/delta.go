@@ -0,0 +1,196 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// deleteBatch bounds the number of books removed by one statement
+const deleteBatch = 500
+
+// DeltaResult contains statistics about a delta import.
+type DeltaResult struct {
+	Added          int
+	Updated        int
+	Removed        int
+	Skipped        int
+	UnmappedGenres int
+	// DetectedLanguages is the number of books whose language was
+	// detected from their annotation, see detectLanguages
+	DetectedLanguages int
+	AuthorMerges      int
+	AuthorSplits      int
+	Overrides         int
+	SearchTerms       int
+	SyncChanges       int
+	Collection        *inpx.CollectionInfo
+	Duration          time.Duration
+}
+
+// ImportDeltaINPX merges the books of an INPX file, such as a daily update
+// published next to a full catalog, into the existing catalog without
+// clearing it. Books whose ID is already known are updated in place, so
+// reading positions and other references to them are kept. Manual author
+// merges and metadata corrections are applied to the merged books again.
+func ImportDeltaINPX(repo *storage.Repository, inpxPath string) (*DeltaResult, error) {
+	return mergeINPX(repo, inpxPath, false)
+}
+
+// RefreshFromINPX brings the catalog up to date with a new version of the
+// full INPX without clearing it: books are merged as by ImportDeltaINPX,
+// and books the INPX no longer lists are removed, except those added by
+// delta imports that the full INPX does not list yet.
+func RefreshFromINPX(repo *storage.Repository, inpxPath string) (*DeltaResult, error) {
+	return mergeINPX(repo, inpxPath, true)
+}
+
+// mergeINPX merges the books of an INPX into the catalog, removing the
+// books it does not list if removeMissing is set
+func mergeINPX(repo *storage.Repository, inpxPath string, removeMissing bool) (*DeltaResult, error) {
+	if inpxPath == "" {
+		return nil, ErrINPXPathEmpty
+	}
+
+	start := time.Now()
+	books, collectionInfo, lineErrors, err := inpx.NewParser().ParseINPXWithErrors(inpxPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrINPXInvalid, err)
+	}
+	log.Printf("Delta import: parsed %d books from %s", len(books), inpxPath)
+
+	// A later record of the same book wins, as it would on reindex
+	index := make(map[string]int, len(books))
+	unique := books[:0]
+	for _, book := range books {
+		if i, ok := index[book.ID]; ok {
+			unique[i] = book
+			continue
+		}
+		index[book.ID] = len(unique)
+		unique = append(unique, book)
+	}
+	books = unique
+	if removeMissing && len(books) == 0 {
+		// Most likely a broken download rather than an emptied library
+		return nil, fmt.Errorf("%w: no books in %s, the catalog is kept", ErrINPXInvalid, inpxPath)
+	}
+	detected := detectLanguages(repo, books)
+	unmapped := normalizeGenres(repo, books)
+
+	ids := make([]string, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	existing, err := repo.ExistingBookIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.UpsertBooks(books); err != nil {
+		return nil, fmt.Errorf("failed to merge books: %w", err)
+	}
+
+	var removed []string
+	if removeMissing {
+		all, err := repo.AllBookIDs()
+		if err != nil {
+			return nil, err
+		}
+		delta, err := repo.DeltaBookIDs()
+		if err != nil {
+			return nil, err
+		}
+		var listed []string
+		for _, id := range all {
+			_, ok := index[id]
+			switch {
+			case ok && delta[id]:
+				listed = append(listed, id)
+			case !ok && !delta[id]:
+				removed = append(removed, id)
+			}
+		}
+		// The full INPX owns the delta books it lists from now on
+		if err := repo.UnmarkDeltaBooks(listed); err != nil {
+			return nil, err
+		}
+		for start := 0; start < len(removed); start += deleteBatch {
+			if err := repo.DeleteBooks(removed[start:min(start+deleteBatch, len(removed))]); err != nil {
+				return nil, fmt.Errorf("failed to remove books: %w", err)
+			}
+		}
+	} else {
+		var added []string
+		for _, id := range ids {
+			if !existing[id] {
+				added = append(added, id)
+			}
+		}
+		if err := repo.MarkDeltaBooks(added); err != nil {
+			return nil, err
+		}
+	}
+
+	merges, err := repo.ApplyAuthorMerges()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply author merges: %w", err)
+	}
+	splits, err := repo.ApplyAuthorSplits()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply author splits: %w", err)
+	}
+	overrides, err := repo.ApplyBookOverrides()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply book overrides: %w", err)
+	}
+
+	searchTerms := 0
+	if repo.SearchSuggestionsEnabled() {
+		searchTerms, err = repo.RebuildSearchTerms()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebuild search suggestions: %w", err)
+		}
+	}
+
+	syncChanges := 0
+	if repo.SyncEnabled() && removeMissing {
+		syncChanges, err = repo.RecordSyncChanges()
+		if err != nil {
+			return nil, fmt.Errorf("failed to record sync changes: %w", err)
+		}
+	} else if repo.SyncEnabled() {
+		syncChanges, err = repo.RecordBookSyncChanges(ids...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record sync changes: %w", err)
+		}
+	}
+
+	if _, err := repo.RefreshNavigationStats(); err != nil {
+		return nil, fmt.Errorf("failed to count navigation lists: %w", err)
+	}
+
+	repo.InvalidateQueryCache()
+
+	result := &DeltaResult{
+		Added:             len(books) - len(existing),
+		Updated:           len(existing),
+		Removed:           len(removed),
+		Skipped:           len(lineErrors),
+		UnmappedGenres:    len(unmapped),
+		DetectedLanguages: detected,
+		AuthorMerges:      merges,
+		AuthorSplits:      splits,
+		Overrides:         overrides,
+		SearchTerms:       searchTerms,
+		SyncChanges:       syncChanges,
+		Collection:        collectionInfo,
+		Duration:          time.Since(start),
+	}
+	log.Printf("Delta import: added %d books, updated %d, removed %d, skipped %d malformed lines in %s",
+		result.Added, result.Updated, result.Removed, result.Skipped, result.Duration.Truncate(time.Millisecond))
+	return result, nil
+}
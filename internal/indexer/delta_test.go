@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// writeTestINPX writes an INPX listing books with the given IDs
+func writeTestINPX(t *testing.T, name string, ids ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create INPX: %v", err)
+	}
+	defer f.Close()
+
+	w := inpx.NewWriter(f)
+	for _, id := range ids {
+		book := inpx.Book{ID: id, Title: "Книга " + id, Authors: []string{"Автор"}, ArchivePath: "fb2-000001",
+			FileNum: id, Format: "fb2", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if err := w.Add(book); err != nil {
+			t.Fatalf("failed to add book: %v", err)
+		}
+	}
+	if err := w.Close(inpx.CollectionInfo{Name: "Test", Version: "1"}); err != nil {
+		t.Fatalf("failed to write INPX: %v", err)
+	}
+	return path
+}
+
+// TestRefreshFromINPX checks that refreshing from the full INPX removes the
+// books it no longer lists but keeps those added by delta imports, until
+// the full INPX has listed them once.
+func TestRefreshFromINPX(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	bookIDs := func() []string {
+		t.Helper()
+		ids, err := repo.AllBookIDs()
+		if err != nil {
+			t.Fatalf("AllBookIDs failed: %v", err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	refresh := func(ids ...string) *DeltaResult {
+		t.Helper()
+		result, err := RefreshFromINPX(repo, writeTestINPX(t, "full.inpx", ids...))
+		if err != nil {
+			t.Fatalf("RefreshFromINPX failed: %v", err)
+		}
+		return result
+	}
+
+	if _, err := ReindexFromINPX(repo, writeTestINPX(t, "full.inpx", "1", "2")); err != nil {
+		t.Fatalf("ReindexFromINPX failed: %v", err)
+	}
+	if _, err := ImportDeltaINPX(repo, writeTestINPX(t, "daily.inpx", "3")); err != nil {
+		t.Fatalf("ImportDeltaINPX failed: %v", err)
+	}
+
+	if result := refresh("1", "4"); result.Added != 1 || result.Removed != 1 {
+		t.Errorf("expected 1 book added and 1 removed, got %+v", result)
+	}
+	if got, want := bookIDs(), []string{"1", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("books = %v, want %v", got, want)
+	}
+
+	// Once listed by the full INPX, the delta book goes when it is dropped
+	refresh("1", "3")
+	refresh("1")
+	if got, want := bookIDs(), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("books = %v, want %v", got, want)
+	}
+}
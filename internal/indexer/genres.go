@@ -0,0 +1,17 @@
+package indexer
+
+import (
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// normalizeGenres rewrites the genre codes of books with the repository's
+// genre mapping, if any, and returns the number of books per code it could
+// not map.
+func normalizeGenres(repo *storage.Repository, books []inpx.Book) map[string]int {
+	mapping := repo.GenreMapping()
+	if mapping == nil {
+		return map[string]int{}
+	}
+	return mapping.Apply(books)
+}
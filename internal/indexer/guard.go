@@ -0,0 +1,83 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBusy is matched by the error Guard.Begin returns while another job
+// runs.
+var ErrBusy = errors.New("reindex is already in progress")
+
+// Job is a run holding a Guard.
+type Job struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// BusyError is returned by Guard.Begin with the job that holds the guard.
+type BusyError struct {
+	Job Job
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s is already in progress (job %s)", e.Job.Kind, e.Job.ID)
+}
+
+// Is makes a BusyError match ErrBusy.
+func (e *BusyError) Is(target error) bool {
+	return target == ErrBusy
+}
+
+// Guard lets one job that rewrites the library run at a time: a reindex
+// clears and re-inserts all books, so two of them, or a reindex and a delta
+// import, would interleave. The zero value is ready to use.
+type Guard struct {
+	mu      sync.Mutex
+	running *Job
+	seq     int
+}
+
+// Begin starts a job of kind ("reindex", "import", ...) unless one is
+// running, in which case it returns a *BusyError naming it. The job must be
+// ended with End.
+func (g *Guard) Begin(kind string) (Job, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running != nil {
+		return Job{}, &BusyError{Job: *g.running}
+	}
+	g.seq++
+	job := Job{
+		ID:        fmt.Sprintf("%s-%d-%d", kind, time.Now().Unix(), g.seq),
+		Kind:      kind,
+		StartedAt: time.Now(),
+	}
+	g.running = &job
+	return job, nil
+}
+
+// End releases the guard held by job.
+func (g *Guard) End(job Job) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running != nil && g.running.ID == job.ID {
+		g.running = nil
+	}
+}
+
+// Current returns the running job, if any.
+func (g *Guard) Current() (Job, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running == nil {
+		return Job{}, false
+	}
+	return *g.running, true
+}
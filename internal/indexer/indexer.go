@@ -16,16 +16,31 @@ var (
 	ErrINPXPathEmpty = errors.New("inpx path is empty")
 	// ErrINPXNotFound indicates that the provided INPX file does not exist.
 	ErrINPXNotFound = errors.New("inpx file not found")
+	// ErrINPXInvalid indicates that the provided file is not a readable INPX.
+	ErrINPXInvalid = errors.New("invalid inpx file")
 )
 
 // Result contains statistics about a reindex operation.
 type Result struct {
 	Imported       int
-	Collection     *inpx.CollectionInfo
-	Duration       time.Duration
-	ParseDuration  time.Duration
-	ClearDuration  time.Duration
-	InsertDuration time.Duration
+	Skipped        int
+	UnmappedGenres int
+	// DetectedLanguages is the number of books whose language was
+	// detected from their annotation, see detectLanguages
+	DetectedLanguages int
+	AuthorMerges      int
+	AuthorSplits      int
+	Overrides         int
+	SearchTerms       int
+	SyncChanges       int
+	Collection        *inpx.CollectionInfo
+	Duration          time.Duration
+	ParseDuration     time.Duration
+	ClearDuration     time.Duration
+	InsertDuration    time.Duration
+	// FTSDuration is the time taken to build the full-text index after
+	// the insert when it was deferred, see storage.SetDeferredFTS
+	FTSDuration time.Duration
 }
 
 // ReindexFromINPX clears all existing data and loads books from the provided INPX file.
@@ -46,12 +61,31 @@ func ReindexFromINPX(repo *storage.Repository, inpxPath string) (*Result, error)
 
 	log.Printf("Reindex: parsing INPX file %s", inpxPath)
 	parseStart := time.Now()
-	books, collectionInfo, err := parser.ParseINPX(inpxPath)
+	books, collectionInfo, lineErrors, err := parser.ParseINPXWithErrors(inpxPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse inpx: %w", err)
 	}
 	parseDuration := time.Since(parseStart)
 	log.Printf("Reindex: parsed %d books in %s", len(books), parseDuration.Truncate(time.Millisecond))
+	if len(lineErrors) > 0 {
+		log.Printf("Reindex: skipped %d malformed INP lines", len(lineErrors))
+	}
+	if err := repo.ReplaceImportErrors(lineErrors); err != nil {
+		return nil, fmt.Errorf("failed to save import errors: %w", err)
+	}
+
+	detected := detectLanguages(repo, books)
+	if detected > 0 {
+		log.Printf("Reindex: detected the language of %d books from their annotations", detected)
+	}
+
+	unmapped := normalizeGenres(repo, books)
+	if len(unmapped) > 0 {
+		log.Printf("Reindex: %d genre codes could not be mapped to known genres", len(unmapped))
+	}
+	if err := repo.ReplaceUnmappedGenres(unmapped); err != nil {
+		return nil, fmt.Errorf("failed to save unmapped genres: %w", err)
+	}
 
 	log.Printf("Reindex: clearing existing data")
 	clearStart := time.Now()
@@ -69,12 +103,83 @@ func ReindexFromINPX(repo *storage.Repository, inpxPath string) (*Result, error)
 	insertDuration := time.Since(insertStart)
 	log.Printf("Reindex: inserted books in %s", insertDuration.Truncate(time.Millisecond))
 
+	ftsStart := time.Now()
+	built, err := repo.BuildDeferredFTS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search index: %w", err)
+	}
+	var ftsDuration time.Duration
+	if built {
+		ftsDuration = time.Since(ftsStart)
+		log.Printf("Reindex: built search index in %s", ftsDuration.Truncate(time.Millisecond))
+	}
+
+	merges, err := repo.ApplyAuthorMerges()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply author merges: %w", err)
+	}
+	if merges > 0 {
+		log.Printf("Reindex: applied %d manual author merges", merges)
+	}
+
+	splits, err := repo.ApplyAuthorSplits()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply author splits: %w", err)
+	}
+	if splits > 0 {
+		log.Printf("Reindex: applied %d manual author splits", splits)
+	}
+
+	overrides, err := repo.ApplyBookOverrides()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply book overrides: %w", err)
+	}
+	if overrides > 0 {
+		log.Printf("Reindex: applied %d manual book overrides", overrides)
+	}
+
+	searchTerms := 0
+	if repo.SearchSuggestionsEnabled() {
+		searchTerms, err = repo.RebuildSearchTerms()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebuild search suggestions: %w", err)
+		}
+		log.Printf("Reindex: indexed %d words for search suggestions", searchTerms)
+	}
+
+	syncChanges := 0
+	if repo.SyncEnabled() {
+		syncChanges, err = repo.RecordSyncChanges()
+		if err != nil {
+			return nil, fmt.Errorf("failed to record sync changes: %w", err)
+		}
+		log.Printf("Reindex: recorded %d changes for mirrors", syncChanges)
+	}
+
+	navStats, err := repo.RefreshNavigationStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count navigation lists: %w", err)
+	}
+	log.Printf("Reindex: precomputed %d navigation counts", navStats)
+
+	// Searches that ran during the import cached partial results
+	repo.InvalidateQueryCache()
+
 	return &Result{
-		Imported:       len(books),
-		Collection:     collectionInfo,
-		Duration:       time.Since(totalStart),
-		ParseDuration:  parseDuration,
-		ClearDuration:  clearDuration,
-		InsertDuration: insertDuration,
+		Imported:          len(books),
+		Skipped:           len(lineErrors),
+		UnmappedGenres:    len(unmapped),
+		DetectedLanguages: detected,
+		AuthorMerges:      merges,
+		AuthorSplits:      splits,
+		Overrides:         overrides,
+		SearchTerms:       searchTerms,
+		SyncChanges:       syncChanges,
+		Collection:        collectionInfo,
+		Duration:          time.Since(totalStart),
+		ParseDuration:     parseDuration,
+		ClearDuration:     clearDuration,
+		InsertDuration:    insertDuration,
+		FTSDuration:       ftsDuration,
 	}, nil
 }
@@ -0,0 +1,38 @@
+package indexer
+
+import (
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/langdetect"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// detectLanguages normalizes the language codes of books, such as "rus" or
+// "RU" to "ru", and, if the repository detects languages, replaces the
+// codes of books whose title and annotation are in another language: books
+// without a known code get the detected one, books with one only when the
+// text is in another script, since close languages such as Russian and
+// Ukrainian are told apart less reliably than the catalog does. It returns
+// the number of books whose language was detected.
+func detectLanguages(repo *storage.Repository, books []inpx.Book) int {
+	detect := repo.LanguageDetectionEnabled()
+	detected := 0
+	for i := range books {
+		book := &books[i]
+		code := langdetect.Normalize(book.Language)
+		if code != "" {
+			book.Language = code
+		}
+		if !detect || book.Annotation == "" {
+			continue
+		}
+		guess := langdetect.Detect(book.Title + "\n" + book.Annotation)
+		if guess == "" || guess == code {
+			continue
+		}
+		if code == "" || langdetect.Script(code) != langdetect.Script(guess) {
+			book.Language = guess
+			detected++
+		}
+	}
+	return detected
+}
@@ -0,0 +1,189 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrINPXChecksum indicates that a downloaded INPX does not match the
+// expected checksum.
+var ErrINPXChecksum = errors.New("inpx checksum mismatch")
+
+// remoteTimeout bounds the download of a remote INPX
+const remoteTimeout = 30 * time.Minute
+
+// IsRemoteINPX reports whether an INPX path is an http or https URL.
+func IsRemoteINPX(inpxPath string) bool {
+	return strings.HasPrefix(inpxPath, "http://") || strings.HasPrefix(inpxPath, "https://")
+}
+
+// RemoteINPX is an INPX served over HTTP, downloaded to a local cache
+// before each import and, on a schedule, checked for a new version.
+type RemoteINPX struct {
+	URL      string
+	CacheDir string
+	// SHA256 is the expected checksum of the file in hex; empty skips the check
+	SHA256 string
+	Client *http.Client
+}
+
+// Fetch returns the path of the cached INPX, downloading it first unless
+// the server reports that the cached copy is current, by its ETag or
+// Last-Modified. changed reports whether the content differs from the last
+// copy passed to MarkMerged, so an INPX whose merge failed is merged again
+// on the next Fetch. When the server cannot be reached, a cached copy is
+// used as long as it matches SHA256.
+func (s *RemoteINPX) Fetch(ctx context.Context) (cachePath string, changed bool, err error) {
+	cachePath = filepath.Join(s.CacheDir, remoteFileName(s.URL))
+	var previous []byte
+	if info, err := os.Stat(cachePath); err == nil && info.Mode().IsRegular() {
+		if sum, err := fileSHA256(cachePath); err == nil && s.checkSum(sum) == nil {
+			previous = sum
+		}
+	}
+
+	sum, err := s.download(ctx, cachePath, previous)
+	if err != nil {
+		if previous == nil || errors.Is(err, ErrINPXChecksum) {
+			return "", false, err
+		}
+		log.Printf("Reindex: failed to download %s, using the cached copy: %v", s.URL, err)
+		sum = previous
+	}
+	merged, _ := os.ReadFile(cachePath + ".merged")
+	return cachePath, string(merged) != hex.EncodeToString(sum), nil
+}
+
+// MarkMerged records the INPX at cachePath, as returned by Fetch, as merged
+// into the catalog, so the next Fetch only reports it changed once the
+// server has a different one.
+func (s *RemoteINPX) MarkMerged(cachePath string) error {
+	sum, err := fileSHA256(cachePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath+".merged", []byte(hex.EncodeToString(sum)), 0o644)
+}
+
+// download fetches the INPX into cachePath unless the server has no newer
+// one than the cached copy, whose SHA-256 is previous (nil if there is
+// none). It returns the SHA-256 of the cached copy afterwards.
+func (s *RemoteINPX) download(ctx context.Context, cachePath string, previous []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid INPX URL: %w", err)
+	}
+	if previous != nil {
+		if info, err := os.Stat(cachePath); err == nil {
+			req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+		if etag, err := os.ReadFile(cachePath + ".etag"); err == nil && len(etag) > 0 {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: remoteTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download INPX: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		log.Printf("Reindex: cached copy of %s is current", s.URL)
+		return previous, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download INPX: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(s.CacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create INPX cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.CacheDir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create INPX cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download INPX: %w", err)
+	}
+	sum := hash.Sum(nil)
+	if err := s.checkSum(sum); err != nil {
+		return nil, err
+	}
+
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tmp.Name(), modified, modified)
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return nil, fmt.Errorf("failed to save INPX: %w", err)
+	}
+	// The ETag is only a hint for the next request
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		os.WriteFile(cachePath+".etag", []byte(etag), 0o644)
+	} else {
+		os.Remove(cachePath + ".etag")
+	}
+
+	log.Printf("Reindex: downloaded %s (%d bytes) to %s", s.URL, size, cachePath)
+	return sum, nil
+}
+
+// fileSHA256 returns the SHA-256 of a file
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// checkSum compares a SHA-256 sum with SHA256
+func (s *RemoteINPX) checkSum(sum []byte) error {
+	if s.SHA256 == "" {
+		return nil
+	}
+	if got := hex.EncodeToString(sum); !strings.EqualFold(got, s.SHA256) {
+		return fmt.Errorf("%w: %s has SHA-256 %s, want %s", ErrINPXChecksum, s.URL, got, s.SHA256)
+	}
+	return nil
+}
+
+// remoteFileName names the cached copy of an INPX after the last element
+// of its URL path
+func remoteFileName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" && !strings.HasPrefix(name, ".") {
+			return name
+		}
+	}
+	return "catalog.inpx"
+}
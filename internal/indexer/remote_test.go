@@ -0,0 +1,95 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestRemoteINPX_Fetch checks that a cached INPX is revalidated by its
+// ETag, that it counts as changed until MarkMerged records it, and that the
+// cached copy is used while the server is down.
+func TestRemoteINPX_Fetch(t *testing.T) {
+	var mu sync.Mutex
+	content, etag := "v1", `"v1"`
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	remote := &RemoteINPX{URL: srv.URL + "/lib.inpx", CacheDir: t.TempDir()}
+	fetch := func(wantChanged bool) string {
+		t.Helper()
+		cachePath, changed, err := remote.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if changed != wantChanged {
+			t.Errorf("expected changed=%t, got %t", wantChanged, changed)
+		}
+		return cachePath
+	}
+
+	cachePath := fetch(true)
+	if data, _ := os.ReadFile(cachePath); string(data) != "v1" {
+		t.Errorf("expected v1 cached, got %q", data)
+	}
+	// The merge failed, so the same INPX is reported again
+	fetch(true)
+	if notModified != 1 {
+		t.Errorf("expected the cached copy revalidated by its ETag, got %d of %d requests not modified", notModified, requests)
+	}
+	if err := remote.MarkMerged(cachePath); err != nil {
+		t.Fatalf("MarkMerged failed: %v", err)
+	}
+	fetch(false)
+
+	mu.Lock()
+	content, etag = "v2", `"v2"`
+	mu.Unlock()
+	fetch(true)
+	if data, _ := os.ReadFile(cachePath); string(data) != "v2" {
+		t.Errorf("expected v2 cached, got %q", data)
+	}
+
+	srv.Close()
+	fetch(true)
+	remote.MarkMerged(cachePath)
+	fetch(false)
+}
+
+// TestRemoteINPX_Checksum checks that a download that does not match
+// SHA256 is rejected and leaves the cache alone.
+func TestRemoteINPX_Checksum(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("catalog"))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte("catalog"))
+	remote := &RemoteINPX{URL: srv.URL + "/lib.inpx", CacheDir: t.TempDir(), SHA256: hex.EncodeToString(sum[:])}
+	if _, changed, err := remote.Fetch(context.Background()); err != nil || !changed {
+		t.Fatalf("expected a matching download, got changed=%t, %v", changed, err)
+	}
+
+	remote.SHA256 = "00"
+	if _, _, err := remote.Fetch(context.Background()); !errors.Is(err, ErrINPXChecksum) {
+		t.Errorf("expected ErrINPXChecksum, got %v", err)
+	}
+}
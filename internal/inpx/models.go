@@ -19,6 +19,14 @@ type Book struct {
 	Date        time.Time `json:"date"`
 	Rating      int       `json:"rating,omitempty"`
 	Annotation  string    `json:"annotation,omitempty"`
+	// OtherSeries are the series the book belongs to besides Series
+	OtherSeries []SeriesRef `json:"other_series,omitempty"`
+}
+
+// SeriesRef is a series with the number of a book in it
+type SeriesRef struct {
+	Name string `json:"name"`
+	Num  int    `json:"num,omitempty"`
 }
 
 // CollectionInfo represents metadata about the collection
@@ -27,4 +35,12 @@ type CollectionInfo struct {
 	Version     string `json:"version"`
 	Description string `json:"description"`
 	Date        string `json:"date"`
-}
\ No newline at end of file
+}
+
+// LineError describes an INP line that was skipped during parsing
+type LineError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Reason  string `json:"reason"`
+	Content string `json:"content,omitempty"`
+}
@@ -0,0 +1,138 @@
+package inpx
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxPackedINPXSize bounds an INPX unpacked from a gzip stream or a
+// tarball, which is read into memory
+const maxPackedINPXSize = 1 << 30
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// isTarHeader reports whether head starts with a POSIX or GNU tar header
+func isTarHeader(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+// isPackedFile reports whether the file at path is gzip-compressed or a
+// tarball rather than a zipped INPX
+func isPackedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	head = head[:n]
+	return bytes.HasPrefix(head, gzipMagic) || isTarHeader(head), nil
+}
+
+// parsePacked parses a catalog as some mirrors distribute it: a gzipped
+// INPX (.inpx.gz) or INP file (.inp.gz), or a tarball, possibly gzipped
+// (.tar.gz, .tgz), holding an INPX or loose INP files and collection.info.
+// The kind is told by content, not by name.
+func (p *Parser) parsePacked(inpxPath string) ([]Book, *CollectionInfo, []LineError, error) {
+	f, err := os.Open(inpxPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open INPX file: %w", err)
+	}
+	defer f.Close()
+
+	name := filepath.Base(inpxPath)
+	r := bufio.NewReader(f)
+	if head, _ := r.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decompress INPX file: %w", err)
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	var content inpxContent
+	head, _ := r.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		err = p.parseZipStream(&content, name, r)
+	case isTarHeader(head):
+		err = p.parseTar(&content, r)
+	default:
+		// A single INP file, which names the archive of its books
+		if !strings.HasSuffix(name, ".inp") {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".inp"
+		}
+		err = p.addEntry(&content, name, r)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return content.result()
+}
+
+// parseZipStream parses a zipped INPX read from r. A zip file cannot be
+// read as a stream, so it is held in memory.
+func (p *Parser) parseZipStream(c *inpxContent, name string, r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxPackedINPXSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(data) > maxPackedINPXSize {
+		return fmt.Errorf("%s is larger than %d MB", name, maxPackedINPXSize>>20)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	return p.parseZipEntries(c, reader)
+}
+
+// parseTar parses the INPX files, INP files, collection.info and
+// version.info of a tarball, in any of its directories
+func (p *Parser) parseTar(c *inpxContent, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tarball: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Base(header.Name)
+		switch {
+		case strings.HasSuffix(strings.ToLower(name), ".inpx"):
+			if err := p.parseZipStream(c, header.Name, tr); err != nil {
+				return err
+			}
+		case isINPXEntry(name):
+			if err := p.addEntry(c, name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -5,10 +5,16 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"path"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
 // Parser handles INPX file parsing
@@ -19,50 +25,153 @@ func NewParser() *Parser {
 	return &Parser{}
 }
 
-// ParseINPX parses an INPX file and returns books and collection info
+// maxLineErrorContent limits how much of a skipped line is kept in LineError
+const maxLineErrorContent = 200
+
+// ParseINPX parses an INPX file and returns books and collection info.
+// inpxPath may also be a directory of loose .inp files and collection.info,
+// as produced by some tools instead of a zipped INPX, or a gzipped INPX,
+// INP file or tarball, see parsePacked.
 func (p *Parser) ParseINPX(inpxPath string) ([]Book, *CollectionInfo, error) {
+	books, collectionInfo, _, err := p.ParseINPXWithErrors(inpxPath)
+	return books, collectionInfo, err
+}
+
+// ParseINPXWithErrors parses an INPX file like ParseINPX and also reports
+// the malformed lines that were skipped.
+func (p *Parser) ParseINPXWithErrors(inpxPath string) ([]Book, *CollectionInfo, []LineError, error) {
+	if info, err := os.Stat(inpxPath); err == nil && info.IsDir() {
+		return p.parseINPDir(inpxPath)
+	}
+
+	if packed, err := isPackedFile(inpxPath); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open INPX file: %w", err)
+	} else if packed {
+		return p.parsePacked(inpxPath)
+	}
+
 	reader, err := zip.OpenReader(inpxPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open INPX file: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to open INPX file: %w", err)
 	}
 	defer reader.Close()
 
-	var books []Book
-	var collectionInfo *CollectionInfo
+	var content inpxContent
+	if err := p.parseZipEntries(&content, &reader.Reader); err != nil {
+		return nil, nil, nil, err
+	}
+	return content.result()
+}
 
-	for _, file := range reader.File {
-		switch {
-		case strings.HasSuffix(file.Name, ".inp"):
-			inpBooks, err := p.parseINPFile(file)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to parse INP file %s: %w", file.Name, err)
-			}
-			books = append(books, inpBooks...)
+// inpxContent collects the entries of an INPX, whatever holds them
+type inpxContent struct {
+	books          []Book
+	lineErrors     []LineError
+	collectionInfo *CollectionInfo
+	version        string
+}
 
-		case file.Name == "collection.info":
-			collectionInfo, err = p.parseCollectionInfo(file)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to parse collection.info: %w", err)
-			}
+// result returns the books, collection info and line errors collected
+func (c *inpxContent) result() ([]Book, *CollectionInfo, []LineError, error) {
+	if c.collectionInfo != nil && c.version != "" {
+		c.collectionInfo.Version = c.version
+	}
+	return c.books, c.collectionInfo, c.lineErrors, nil
+}
+
+// isINPXEntry reports whether an entry named name is part of an INPX: an
+// INP file, collection.info or version.info
+func isINPXEntry(name string) bool {
+	return strings.HasSuffix(name, ".inp") || name == "collection.info" || name == "version.info"
+}
+
+// addEntry parses an entry of an INPX for which isINPXEntry holds
+func (p *Parser) addEntry(c *inpxContent, name string, r io.Reader) error {
+	switch {
+	case strings.HasSuffix(name, ".inp"):
+		inpBooks, inpErrors, err := p.parseINPFile(name, r)
+		if err != nil {
+			return fmt.Errorf("failed to parse INP file %s: %w", name, err)
+		}
+		c.books = append(c.books, inpBooks...)
+		c.lineErrors = append(c.lineErrors, inpErrors...)
+
+	case name == "collection.info":
+		text, err := readInfo(r)
+		if err == nil {
+			c.collectionInfo, err = p.parseCollectionInfo(text)
 		}
+		if err != nil {
+			return fmt.Errorf("failed to parse collection.info: %w", err)
+		}
+
+	case name == "version.info":
+		text, err := readInfo(r)
+		if err != nil {
+			return fmt.Errorf("failed to read version.info: %w", err)
+		}
+		c.version = parseVersionInfo(text)
 	}
+	return nil
+}
 
-	return books, collectionInfo, nil
+// parseZipEntries parses the entries of a zipped INPX
+func (p *Parser) parseZipEntries(c *inpxContent, reader *zip.Reader) error {
+	for _, file := range reader.File {
+		if !isINPXEntry(file.Name) {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		err = p.addEntry(c, file.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// parseINPFile parses a single INP file
-func (p *Parser) parseINPFile(file *zip.File) ([]Book, error) {
-	rc, err := file.Open()
+// parseINPDir parses the .inp files and collection.info of a directory
+// with the same semantics as the entries of a zipped INPX
+func (p *Parser) parseINPDir(dir string) ([]Book, *CollectionInfo, []LineError, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, fmt.Errorf("failed to read INP directory: %w", err)
+	}
+
+	var content inpxContent
+	for _, entry := range entries {
+		if entry.IsDir() || !isINPXEntry(entry.Name()) {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open %s: %w", entry.Name(), err)
+		}
+		err = p.addEntry(&content, entry.Name(), f)
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
-	defer rc.Close()
+	return content.result()
+}
 
+// parseINPFile parses a single INP file, collecting malformed lines instead of failing
+func (p *Parser) parseINPFile(name string, r io.Reader) ([]Book, []LineError, error) {
 	var books []Book
-	scanner := bufio.NewScanner(rc)
-	defaultArchive := strings.TrimSuffix(path.Base(file.Name), ".inp")
+	var lineErrors []LineError
+	scanner := bufio.NewScanner(r)
+	// An INP in a subfolder of the INPX describes an archive in the same
+	// subfolder of the library
+	defaultArchive := strings.TrimSuffix(cleanArchivePath(name), ".inp")
+	lineNum := 0
 
 	for scanner.Scan() {
+		lineNum++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
@@ -70,31 +179,40 @@ func (p *Parser) parseINPFile(file *zip.File) ([]Book, error) {
 
 		book, err := p.parseINPLine(line)
 		if err != nil {
-			// Log error but continue parsing other lines
+			lineErrors = append(lineErrors, LineError{
+				File:    name,
+				Line:    lineNum,
+				Reason:  err.Error(),
+				Content: truncateLine(line, maxLineErrorContent),
+			})
 			continue
 		}
 
 		if book.ArchivePath == "" || book.ArchivePath == book.ID {
 			book.ArchivePath = defaultArchive
 		}
+		book.ArchivePath = cleanArchivePath(book.ArchivePath)
 		book.FileNum = book.ID
 
 		books = append(books, book)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return books, nil
+	return books, lineErrors, nil
 }
 
 // parseINPLine parses a single line from INP file
-// Format: AUTHOR\x04GENRE\x04TITLE\x04SERIES\x04SERIES_NUM\x04BOOK_ID\x04SIZE\x04ARCHIVE_PATH\x04FILE_NUM\x04FORMAT\x04DATE\x04LANG\x04RATING\x04ANNOTATION\x04
+// Format: AUTHOR\x04GENRE\x04TITLE\x04SERIES\x04SERIES_NUM\x04BOOK_ID\x04SIZE\x04ARCHIVE_PATH\x04FILE_NUM\x04FORMAT\x04DATE\x04LANG\x04RATING\x04ANNOTATION\x04[OTHER_SERIES\x04]
 func (p *Parser) parseINPLine(line string) (Book, error) {
 	parts := strings.Split(line, "\x04")
 	if len(parts) < 13 {
-		return Book{}, fmt.Errorf("invalid INP line format: %s", line)
+		return Book{}, fmt.Errorf("invalid INP line format: expected at least 13 fields, got %d", len(parts))
+	}
+	if strings.TrimSpace(parts[5]) == "" {
+		return Book{}, fmt.Errorf("missing book ID")
 	}
 
 	// Parse authors (comma-separated)
@@ -138,10 +256,56 @@ func (p *Parser) parseINPLine(line string) (Book, error) {
 		Rating:      rating,
 		Annotation:  annotation,
 	}
+	if len(parts) > 14 {
+		book.OtherSeries = ParseSeriesRefs(parts[14])
+	}
 
 	return book, nil
 }
 
+// ParseSeriesRefs reads the OTHER_SERIES field of an INP line: series
+// separated by ";", each optionally followed by "#" and the number of the
+// book in it ("Мир Полудня#3;Отдельная серия")
+func ParseSeriesRefs(field string) []SeriesRef {
+	var refs []SeriesRef
+	for _, entry := range strings.Split(field, ";") {
+		name, num := entry, 0
+		if i := strings.LastIndex(entry, "#"); i >= 0 {
+			if n, err := strconv.Atoi(strings.TrimSpace(entry[i+1:])); err == nil {
+				name, num = entry[:i], n
+			}
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			refs = append(refs, SeriesRef{Name: name, Num: num})
+		}
+	}
+	return refs
+}
+
+// FormatSeriesRefs is the inverse of ParseSeriesRefs. Semicolons inside
+// series names become commas.
+func FormatSeriesRefs(refs []SeriesRef) string {
+	entries := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		entry := strings.ReplaceAll(ref.Name, ";", ",")
+		if ref.Num > 0 {
+			entry += "#" + strconv.Itoa(ref.Num)
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ";")
+}
+
+// truncateLine shortens s to at most max runes, replacing field separators for readability
+func truncateLine(s string, max int) string {
+	s = strings.ReplaceAll(s, "\x04", " | ")
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + "…"
+}
+
 // parseAuthors splits author string by comma and trims spaces
 func (p *Parser) parseAuthors(authorStr string) []string {
 	if authorStr == "" {
@@ -181,20 +345,44 @@ func (p *Parser) parseDate(dateStr string) time.Time {
 	return time.Time{}
 }
 
-// parseCollectionInfo parses collection.info file
-func (p *Parser) parseCollectionInfo(file *zip.File) (*CollectionInfo, error) {
-	rc, err := file.Open()
+// readInfo reads collection.info or version.info
+func readInfo(r io.Reader) (string, error) {
+	content, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rc.Close()
+	return decodeInfoText(content), nil
+}
 
-	content, err := io.ReadAll(rc)
+// decodeInfoText converts the content of collection.info or version.info
+// to UTF-8. Many INPX generators write them in Windows-1251, the INP files
+// in UTF-8; content that is not valid UTF-8 is read as Windows-1251, or as
+// UTF-16 if it starts with a UTF-16 byte order mark.
+func decodeInfoText(content []byte) string {
+	if utf8.Valid(content) {
+		return strings.TrimPrefix(string(content), "\uFEFF")
+	}
+	decoded, _, err := transform.Bytes(unicode.BOMOverride(charmap.Windows1251.NewDecoder()), content)
 	if err != nil {
-		return nil, err
+		return string(content)
+	}
+	return string(decoded)
+}
+
+// parseVersionInfo returns the version in version.info, its first
+// non-empty line
+func parseVersionInfo(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
 	}
+	return ""
+}
 
-	lines := strings.Split(string(content), "\n")
+// parseCollectionInfo parses the text of collection.info
+func (p *Parser) parseCollectionInfo(text string) (*CollectionInfo, error) {
+	lines := strings.Split(text, "\n")
 	if len(lines) < 4 {
 		return nil, fmt.Errorf("invalid collection.info format")
 	}
@@ -215,3 +403,9 @@ func (p *Parser) parseCollectionInfo(file *zip.File) (*CollectionInfo, error) {
 
 	return info, nil
 }
+
+// cleanArchivePath turns the backslashes of archive paths written on
+// Windows ("fb2-000001-000500\part1") into slashes
+func cleanArchivePath(archivePath string) string {
+	return strings.ReplaceAll(archivePath, `\`, "/")
+}
@@ -1,8 +1,13 @@
 package inpx
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -54,3 +59,299 @@ func TestParseINPX(t *testing.T) {
 
 	t.Logf("First book: %s by %v", firstBook.Title, firstBook.Authors)
 }
+
+func TestParseINPXWithErrors(t *testing.T) {
+	inpxPath := filepath.Join(t.TempDir(), "test.inpx")
+
+	f, err := os.Create(inpxPath)
+	if err != nil {
+		t.Fatalf("failed to create INPX: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("fb2-000001-000100.inp")
+	if err != nil {
+		t.Fatalf("failed to create INP entry: %v", err)
+	}
+	lines := []string{
+		"Пушкин,Александр,Сергеевич:\x04prose_rus_classic:\x04Капитанская дочка\x04\x04\x0401\x041000\x04\x04\x04fb2\x042020-01-01\x04ru\x045\x04",
+		"broken line without separators",
+		"",
+		"Автор:\x04prose:\x04Без ID\x04\x04\x04\x041000\x04\x04\x04fb2\x042020-01-01\x04ru\x040\x04",
+	}
+	if _, err := w.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		t.Fatalf("failed to write INP entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	f.Close()
+
+	books, _, lineErrors, err := NewParser().ParseINPXWithErrors(inpxPath)
+	if err != nil {
+		t.Fatalf("ParseINPXWithErrors failed: %v", err)
+	}
+
+	if len(books) != 1 || books[0].ID != "01" {
+		t.Fatalf("expected one valid book, got %+v", books)
+	}
+	if len(lineErrors) != 2 {
+		t.Fatalf("expected 2 line errors, got %+v", lineErrors)
+	}
+	if lineErrors[0].File != "fb2-000001-000100.inp" || lineErrors[0].Line != 2 || lineErrors[0].Reason == "" {
+		t.Errorf("unexpected first error: %+v", lineErrors[0])
+	}
+	if lineErrors[1].Line != 4 || lineErrors[1].Reason != "missing book ID" {
+		t.Errorf("unexpected second error: %+v", lineErrors[1])
+	}
+}
+
+func TestParseINPDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"collection.info": "Test Library - 2024-05-01\n1\n65536\nLoose INP files\n",
+		"fb2-000001-000100.inp": "Пушкин,Александр,Сергеевич:\x04prose_rus_classic:\x04Капитанская дочка\x04\x04\x0401\x041000\x04\x04\x04fb2\x042020-01-01\x04ru\x045\x04\n" +
+			"broken line\n",
+		"fb2-000101-000200.inp": "Гоголь,Николай,Васильевич:\x04prose_rus_classic:\x04Нос\x04\x04\x04101\x04500\x04\x04\x04fb2\x042020-01-02\x04ru\x044\x04\n",
+		"readme.txt":            "not an index",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	books, info, lineErrors, err := NewParser().ParseINPXWithErrors(dir)
+	if err != nil {
+		t.Fatalf("ParseINPXWithErrors failed: %v", err)
+	}
+	if len(books) != 2 || books[0].ID != "01" || books[1].ID != "101" {
+		t.Fatalf("unexpected books: %+v", books)
+	}
+	if books[1].ArchivePath != "fb2-000101-000200" {
+		t.Errorf("archive path = %q, want the INP file name", books[1].ArchivePath)
+	}
+	if info == nil || info.Name != "Test Library - 2024-05-01" || info.Date != "2024-05-01" {
+		t.Errorf("unexpected collection info: %+v", info)
+	}
+	if len(lineErrors) != 1 || lineErrors[0].File != "fb2-000001-000100.inp" || lineErrors[0].Line != 2 {
+		t.Errorf("unexpected line errors: %+v", lineErrors)
+	}
+}
+
+// TestParseINPX_Subfolders checks archives in subfolders of multi-volume
+// collections, named by the INP path or by the archive field with either
+// separator.
+func TestParseINPX_Subfolders(t *testing.T) {
+	inpxPath := filepath.Join(t.TempDir(), "test.inpx")
+
+	f, err := os.Create(inpxPath)
+	if err != nil {
+		t.Fatalf("failed to create INPX: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("fb2-000001-000500/part1.inp")
+	if err != nil {
+		t.Fatalf("failed to create INP entry: %v", err)
+	}
+	lines := []string{
+		"Пушкин,Александр,Сергеевич:\x04prose_rus_classic:\x04Капитанская дочка\x04\x04\x0401\x041000\x04\x04\x04fb2\x042020-01-01\x04ru\x045\x04",
+		"Гоголь,Николай,Васильевич:\x04prose_rus_classic:\x04Нос\x04\x04\x0402\x04500\x04fb2-000001-000500\\part2\x04\x04fb2\x042020-01-02\x04ru\x044\x04",
+	}
+	if _, err := w.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		t.Fatalf("failed to write INP entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	f.Close()
+
+	books, _, err := NewParser().ParseINPX(inpxPath)
+	if err != nil {
+		t.Fatalf("ParseINPX failed: %v", err)
+	}
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books, got %+v", books)
+	}
+	if books[0].ArchivePath != "fb2-000001-000500/part1" {
+		t.Errorf("archive path = %q, want the INP path", books[0].ArchivePath)
+	}
+	if books[1].ArchivePath != "fb2-000001-000500/part2" {
+		t.Errorf("archive path = %q, want slashes", books[1].ArchivePath)
+	}
+}
+
+// TestSeriesRefs checks the OTHER_SERIES field survives a round trip
+// through FormatLine and parseINPLine.
+func TestSeriesRefs(t *testing.T) {
+	book := Book{
+		ID:          "1",
+		Title:       "Полдень, XXII век",
+		Authors:     []string{"Стругацкий,Аркадий"},
+		Series:      "Мир Полудня",
+		SeriesNum:   1,
+		Format:      "fb2",
+		OtherSeries: []SeriesRef{{Name: "Фантастика; классика", Num: 12}, {Name: "Избранное"}},
+	}
+
+	parsed, err := NewParser().parseINPLine(FormatLine(book))
+	if err != nil {
+		t.Fatalf("parseINPLine failed: %v", err)
+	}
+	want := []SeriesRef{{Name: "Фантастика, классика", Num: 12}, {Name: "Избранное"}}
+	if len(parsed.OtherSeries) != len(want) || parsed.OtherSeries[0] != want[0] || parsed.OtherSeries[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, parsed.OtherSeries)
+	}
+
+	book.OtherSeries = nil
+	if parsed, _ := NewParser().parseINPLine(FormatLine(book)); parsed.OtherSeries != nil {
+		t.Errorf("expected no other series, got %+v", parsed.OtherSeries)
+	}
+}
+
+// TestCollectionInfoEncoding reads a collection.info written in
+// Windows-1251, as by many INPX generators, from a directory and from a
+// zipped INPX
+func TestCollectionInfoEncoding(t *testing.T) {
+	dir := filepath.Join("testdata", "cp1251")
+	want := CollectionInfo{
+		Name:        "Библиотека Флибуста - 2024-05-01",
+		Version:     "20240501",
+		Description: "Локальная коллекция книг в формате FB2",
+		Date:        "2024-05-01",
+	}
+
+	books, info, err := NewParser().ParseINPX(dir)
+	if err != nil {
+		t.Fatalf("ParseINPX(directory) failed: %v", err)
+	}
+	if len(books) != 1 || info == nil || *info != want {
+		t.Errorf("ParseINPX(directory) = %d books, %+v; want %+v", len(books), info, want)
+	}
+
+	inpxPath := filepath.Join(t.TempDir(), "cp1251.inpx")
+	f, err := os.Create(inpxPath)
+	if err != nil {
+		t.Fatalf("failed to create INPX: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"version.info", "fb2-000001-000100.inp", "collection.info"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read fixture: %v", err)
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	f.Close()
+
+	_, info, err = NewParser().ParseINPX(inpxPath)
+	if err != nil {
+		t.Fatalf("ParseINPX(archive) failed: %v", err)
+	}
+	if info == nil || *info != want {
+		t.Errorf("ParseINPX(archive) collection info = %+v, want %+v", info, want)
+	}
+}
+
+func TestDecodeInfoText(t *testing.T) {
+	cases := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"utf-8", []byte("Библиотека\n"), "Библиотека\n"},
+		{"utf-8 with BOM", []byte("\xef\xbb\xbfБиблиотека\n"), "Библиотека\n"},
+		{"windows-1251", []byte("\xc1\xe8\xe1\xeb\xe8\xee\xf2\xe5\xea\xe0\n"), "Библиотека\n"},
+		{"utf-16le with BOM", []byte("\xff\xfe\x11\x04\x38\x04\x31\x04\n\x00"), "Биб\n"},
+	}
+	for _, tc := range cases {
+		if got := decodeInfoText(tc.content); got != tc.want {
+			t.Errorf("%s: decodeInfoText = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestParsePacked reads the same catalog gzipped, as a gzipped INP file
+// and in tarballs, as mirrors distribute it
+func TestParsePacked(t *testing.T) {
+	dir := t.TempDir()
+	inp := "Пушкин,Александр,Сергеевич:\x04prose_rus_classic:\x04Капитанская дочка\x04\x04\x0401\x041000\x04\x04\x04fb2\x042020-01-01\x04ru\x045\x04\n" +
+		"broken line\n"
+	info := "Test Library - 2024-05-01\n1\n65536\nPacked catalog\n"
+
+	var inpx bytes.Buffer
+	zw := zip.NewWriter(&inpx)
+	for name, content := range map[string]string{"fb2-000001-000100.inp": inp, "collection.info": info} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(data)
+		gw.Close()
+		return buf.Bytes()
+	}
+	tarball := func(files map[string][]byte) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "catalog/", Typeflag: tar.TypeDir, Mode: 0o755})
+		for name, content := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))})
+			tw.Write(content)
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+
+	cases := []struct {
+		name     string
+		content  []byte
+		archive  string
+		withInfo bool
+	}{
+		{"library.inpx.gz", gzipped(inpx.Bytes()), "fb2-000001-000100", true},
+		{"fb2-000001-000100.inp.gz", gzipped([]byte(inp)), "fb2-000001-000100", false},
+		{"library.tar.gz", gzipped(tarball(map[string][]byte{"catalog/library.inpx": inpx.Bytes()})), "fb2-000001-000100", true},
+		{"library.tgz", gzipped(tarball(map[string][]byte{
+			"catalog/fb2-000101-000200.inp": []byte(inp),
+			"catalog/collection.info":       []byte(info),
+		})), "fb2-000101-000200", true},
+		{"library.tar", tarball(map[string][]byte{"catalog/library.inpx": inpx.Bytes()}), "fb2-000001-000100", true},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, tc.content, 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", tc.name, err)
+		}
+		books, collectionInfo, lineErrors, err := NewParser().ParseINPXWithErrors(path)
+		if err != nil {
+			t.Errorf("%s: ParseINPXWithErrors failed: %v", tc.name, err)
+			continue
+		}
+		if len(books) != 1 || books[0].ID != "01" || books[0].ArchivePath != tc.archive {
+			t.Errorf("%s: unexpected books %+v", tc.name, books)
+		}
+		if len(lineErrors) != 1 || lineErrors[0].Line != 2 {
+			t.Errorf("%s: unexpected line errors %+v", tc.name, lineErrors)
+		}
+		if tc.withInfo != (collectionInfo != nil) || collectionInfo != nil && collectionInfo.Name != "Test Library - 2024-05-01" {
+			t.Errorf("%s: unexpected collection info %+v", tc.name, collectionInfo)
+		}
+	}
+}
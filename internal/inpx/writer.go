@@ -0,0 +1,114 @@
+package inpx
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writer writes an INPX archive: one .inp file per book archive followed by
+// collection.info and version.info. Books must be added grouped by their
+// archive path.
+type Writer struct {
+	zw       *zip.Writer
+	inp      io.Writer
+	archive  string
+	archives map[string]bool
+	count    int
+}
+
+// NewWriter creates a writer of an INPX archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w), archives: make(map[string]bool)}
+}
+
+// Add writes the INP line of book into the .inp file of its archive.
+func (w *Writer) Add(book Book) error {
+	if w.inp == nil || book.ArchivePath != w.archive {
+		if w.archives[book.ArchivePath] {
+			return fmt.Errorf("books of archive %s are not grouped together", book.ArchivePath)
+		}
+		inp, err := w.zw.Create(book.ArchivePath + ".inp")
+		if err != nil {
+			return fmt.Errorf("failed to create INP file: %w", err)
+		}
+		w.inp, w.archive = inp, book.ArchivePath
+		w.archives[book.ArchivePath] = true
+	}
+
+	if _, err := io.WriteString(w.inp, FormatLine(book)+"\n"); err != nil {
+		return fmt.Errorf("failed to write INP line: %w", err)
+	}
+	w.count++
+	return nil
+}
+
+// Count returns the number of books added so far.
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// Close writes collection.info and version.info and finishes the archive.
+// It does not close the underlying writer.
+func (w *Writer) Close(info CollectionInfo) error {
+	infoWriter, err := w.zw.Create("collection.info")
+	if err != nil {
+		return fmt.Errorf("failed to create collection.info: %w", err)
+	}
+	if _, err := fmt.Fprintf(infoWriter, "%s\n%s\n65536\n%s\n", info.Name, info.Version, info.Description); err != nil {
+		return fmt.Errorf("failed to write collection.info: %w", err)
+	}
+
+	versionWriter, err := w.zw.Create("version.info")
+	if err != nil {
+		return fmt.Errorf("failed to create version.info: %w", err)
+	}
+	if _, err := io.WriteString(versionWriter, info.Version+"\n"); err != nil {
+		return fmt.Errorf("failed to write version.info: %w", err)
+	}
+
+	if err := w.zw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize INPX zip: %w", err)
+	}
+	return nil
+}
+
+// FormatLine formats a book as an INP line that ParseINPX reads back.
+// Field separators and line breaks inside values are replaced by spaces.
+func FormatLine(book Book) string {
+	// AUTHOR\x04GENRE\x04TITLE\x04SERIES\x04SERIES_NUM\x04BOOK_ID\x04SIZE\x04ARCHIVE_PATH\x04FILE_NUM\x04FORMAT\x04DATE\x04LANG\x04RATING\x04ANNOTATION\x04[OTHER_SERIES\x04]
+
+	date := ""
+	if !book.Date.IsZero() {
+		date = book.Date.Format("2006-01-02")
+	}
+
+	fields := []string{
+		strings.Join(book.Authors, ","),      // AUTHOR
+		book.Genre,                           // GENRE
+		book.Title,                           // TITLE
+		book.Series,                          // SERIES
+		strconv.Itoa(book.SeriesNum),         // SERIES_NUM
+		book.ID,                              // BOOK_ID
+		strconv.FormatInt(book.FileSize, 10), // SIZE
+		book.ArchivePath,                     // ARCHIVE_PATH
+		book.FileNum,                         // FILE_NUM
+		book.Format,                          // FORMAT
+		date,                                 // DATE
+		book.Language,                        // LANG
+		strconv.Itoa(book.Rating),            // RATING
+		book.Annotation,                      // ANNOTATION
+	}
+	if len(book.OtherSeries) > 0 {
+		fields = append(fields, FormatSeriesRefs(book.OtherSeries)) // OTHER_SERIES
+	}
+	for i, field := range fields {
+		fields[i] = lineFieldReplacer.Replace(field)
+	}
+
+	return strings.Join(fields, "\x04") + "\x04"
+}
+
+var lineFieldReplacer = strings.NewReplacer("\x04", " ", "\r\n", " ", "\n", " ", "\r", " ")
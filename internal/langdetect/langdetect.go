@@ -0,0 +1,253 @@
+// Package langdetect guesses the language of book titles and annotations
+// from their script, letters specific to a language and common words. It
+// is meant for catalogs whose language field is missing or wrong, and
+// answers nothing rather than a poor guess.
+package langdetect
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scripts of languages and texts
+const (
+	Cyrillic = "cyrillic"
+	Latin    = "latin"
+	Greek    = "greek"
+	Hebrew   = "hebrew"
+	Arabic   = "arabic"
+	Han      = "han"
+)
+
+// minLetters is the fewest letters a text needs for its language to be
+// detected
+const minLetters = 20
+
+// minWordHits is the fewest common words of a Latin-script language a text
+// needs for it to be detected
+const minWordHits = 2
+
+// scripts are the scripts of the languages Detect and Normalize know
+var scripts = map[string]string{
+	"ru": Cyrillic, "uk": Cyrillic, "be": Cyrillic, "bg": Cyrillic, "sr": Cyrillic,
+	"mk": Cyrillic, "kk": Cyrillic, "tt": Cyrillic,
+	"en": Latin, "de": Latin, "fr": Latin, "es": Latin, "it": Latin, "pl": Latin,
+	"cs": Latin, "pt": Latin, "nl": Latin, "sv": Latin, "fi": Latin, "la": Latin,
+	"lt": Latin, "lv": Latin, "et": Latin, "hu": Latin, "ro": Latin, "tr": Latin,
+	"el": Greek, "he": Hebrew, "ar": Arabic, "zh": Han, "ja": Han,
+}
+
+// longCodes maps ISO 639-2 codes and language names found in catalogs to
+// two-letter codes
+var longCodes = map[string]string{
+	"rus": "ru", "eng": "en", "ukr": "uk", "bel": "be", "deu": "de", "ger": "de",
+	"fra": "fr", "fre": "fr", "spa": "es", "ita": "it", "pol": "pl", "ces": "cs",
+	"cze": "cs", "bul": "bg", "srp": "sr", "por": "pt", "nld": "nl", "dut": "nl",
+	"swe": "sv", "fin": "fi", "jpn": "ja", "zho": "zh", "chi": "zh", "heb": "he",
+	"lat": "la", "kaz": "kk", "tat": "tt", "lit": "lt", "lav": "lv", "est": "et",
+	"hun": "hu", "ron": "ro", "rum": "ro", "tur": "tr", "ell": "el", "gre": "el",
+	"ara": "ar", "mkd": "mk", "mac": "mk",
+	"russian": "ru", "english": "en", "ukrainian": "uk", "belarusian": "be",
+	"german": "de", "french": "fr", "spanish": "es", "italian": "it", "polish": "pl",
+	"рус": "ru", "русский": "ru", "англ": "en", "английский": "en", "укр": "uk",
+}
+
+// Normalize returns the two-letter code of a language code such as "RU",
+// "rus", "ru-RU" or "russian", or "" if it names no language Detect knows.
+func Normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	if short, ok := longCodes[code]; ok {
+		return short
+	}
+	if _, ok := scripts[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// Script returns the script a language is written in, or "" for languages
+// Detect does not know.
+func Script(language string) string {
+	return scripts[Normalize(language)]
+}
+
+// Languages returns the codes of the languages written in a script, sorted.
+func Languages(script string) []string {
+	var codes []string
+	for code, s := range scripts {
+		if s == script {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// letterCounts counts the letters of a text by script
+type letterCounts struct {
+	total, cyrillic, latin, greek, hebrew, arabic, han, kana int
+}
+
+func countLetters(text string) letterCounts {
+	var c letterCounts
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		c.total++
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			c.cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			c.latin++
+		case unicode.Is(unicode.Greek, r):
+			c.greek++
+		case unicode.Is(unicode.Hebrew, r):
+			c.hebrew++
+		case unicode.Is(unicode.Arabic, r):
+			c.arabic++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			c.kana++
+		case unicode.Is(unicode.Han, r):
+			c.han++
+		}
+	}
+	return c
+}
+
+// dominant returns the script of most of the letters counted, provided it
+// is at least two thirds of them
+func (c letterCounts) dominant() string {
+	if c.total == 0 {
+		return ""
+	}
+	best, count := "", 0
+	for _, s := range []struct {
+		script string
+		count  int
+	}{
+		{Cyrillic, c.cyrillic}, {Latin, c.latin}, {Greek, c.greek},
+		{Hebrew, c.hebrew}, {Arabic, c.arabic}, {Han, c.han + c.kana},
+	} {
+		if s.count > count {
+			best, count = s.script, s.count
+		}
+	}
+	if count*3 < c.total*2 {
+		return ""
+	}
+	return best
+}
+
+// TextScript returns the script most of the letters of a text are written
+// in, or "" for texts without letters or of mixed scripts. Unlike Detect it
+// works on texts of any length, such as search queries.
+func TextScript(text string) string {
+	return countLetters(text).dominant()
+}
+
+// Detect returns the two-letter code of the language of a text, or "" if
+// the text is too short or its language is unclear.
+func Detect(text string) string {
+	c := countLetters(text)
+	if c.total < minLetters {
+		return ""
+	}
+	switch c.dominant() {
+	case Cyrillic:
+		return detectCyrillic(text)
+	case Latin:
+		return detectLatin(text)
+	case Greek:
+		return "el"
+	case Hebrew:
+		return "he"
+	case Arabic:
+		return "ar"
+	case Han:
+		if c.kana > 0 {
+			return "ja"
+		}
+		return "zh"
+	}
+	return ""
+}
+
+// detectCyrillic tells Cyrillic languages apart by the letters only some
+// of them have
+func detectCyrillic(text string) string {
+	count := func(letters string) int {
+		n := 0
+		for _, r := range strings.ToLower(text) {
+			if strings.ContainsRune(letters, r) {
+				n++
+			}
+		}
+		return n
+	}
+	switch {
+	case count("ђћџљњј") > 0:
+		return "sr"
+	case count("ѓќѕ") > 0:
+		return "mk"
+	case count("ў") > 0, count("і") > 0 && count("ыэ") > 0:
+		return "be"
+	case count("іїєґ") > 0:
+		return "uk"
+	case count("ыэё") > 0:
+		return "ru"
+	case count("ъ") > 0:
+		return "bg"
+	}
+	return ""
+}
+
+// commonWords are frequent short words of Latin-script languages, chosen to
+// be rare in the others
+var commonWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "with", "his", "her", "was", "for", "this", "are", "from", "by"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "sich", "auf", "ein", "eine", "dem", "den", "von", "zu", "ich"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "dans", "du", "pour", "qui", "au", "sur", "pas", "avec", "sont"},
+	"es": {"el", "los", "las", "y", "del", "que", "en", "una", "por", "con", "para", "es", "su", "se", "como", "pero"},
+	"it": {"il", "di", "che", "è", "della", "per", "non", "una", "sono", "gli", "nel", "alla", "con", "del", "questo", "anche"},
+	"pl": {"się", "nie", "jest", "na", "w", "z", "że", "do", "to", "jak", "ale", "po", "od", "przez", "jego", "oraz"},
+	"cs": {"se", "je", "na", "že", "v", "a", "s", "to", "jako", "ale", "jeho", "by", "ve", "pro", "který", "také"},
+	"pt": {"o", "os", "as", "e", "do", "da", "que", "não", "uma", "com", "para", "em", "um", "dos", "das", "ao"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "zijn", "met", "voor", "ook", "maar", "hij", "naar"},
+}
+
+// detectLatin picks the Latin-script language whose common words the text
+// uses most, if it is clearly ahead of the others
+func detectLatin(text string) string {
+	hits := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for language, words := range commonWords {
+			for _, w := range words {
+				if w == word {
+					hits[language]++
+					break
+				}
+			}
+		}
+	}
+	best, first, second := "", 0, 0
+	for language, n := range hits {
+		switch {
+		case n > first:
+			best, first, second = language, n, first
+		case n > second:
+			second = n
+		}
+	}
+	// Ahead by half as many words again, so that ties stay unknown
+	if first < minWordHits || first*2 < second*3 {
+		return ""
+	}
+	return best
+}
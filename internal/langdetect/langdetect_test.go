@@ -0,0 +1,53 @@
+package langdetect
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Роман о судьбе молодого дворянина, который вернулся в столицу после долгих лет странствий.", "ru"},
+		{"Історія молодої жінки, яка повертається до рідного міста після війни і шукає свою родину.", "uk"},
+		{"Гісторыя маладой жанчыны, якая вяртаецца ў родны горад пасля вайны.", "be"},
+		{"The story of a young man who returns to the city after the war and looks for his family.", "en"},
+		{"Die Geschichte eines jungen Mannes, der nach dem Krieg in die Stadt zurückkehrt und nicht weiß, wohin.", "de"},
+		{"L'histoire d'une jeune femme qui revient dans la ville après la guerre et cherche sa famille.", "fr"},
+		{"Η ιστορία ενός νεαρού που επιστρέφει στην πόλη μετά τον πόλεμο.", "el"},
+		// Too short, and no language-specific letters or words
+		{"Война и мир", ""},
+		{"Lorem ipsum dolor sit amet consectetur adipiscing", ""},
+		{"Half русский half English текст с mixed словами", ""},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for code, want := range map[string]string{
+		"ru": "ru", " RU ": "ru", "rus": "ru", "ru-RU": "ru", "en_US": "en",
+		"ger": "de", "Russian": "ru", "рус": "ru", "": "", "xx": "", "1": "",
+	} {
+		if got := Normalize(code); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestTextScript(t *testing.T) {
+	for text, want := range map[string]string{
+		"Толстой":       Cyrillic,
+		"tolstoy":       Latin,
+		"Tolstoy война": "",
+		"1984":          "",
+	} {
+		if got := TextScript(text); got != want {
+			t.Errorf("TextScript(%q) = %q, want %q", text, got, want)
+		}
+	}
+	if got := Script("RUS"); got != Cyrillic {
+		t.Errorf("Script(RUS) = %q", got)
+	}
+}
@@ -0,0 +1,244 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Inline elements kept in XHTML annotations, by FB2 or HTML name
+var annotationInline = map[string]string{
+	"emphasis":      "em",
+	"em":            "em",
+	"i":             "em",
+	"strong":        "strong",
+	"b":             "strong",
+	"strikethrough": "del",
+	"del":           "del",
+	"s":             "del",
+	"sub":           "sub",
+	"sup":           "sup",
+	"code":          "code",
+}
+
+// Elements that start a paragraph of their own
+var annotationBlocks = map[string]bool{
+	"p":           true,
+	"div":         true,
+	"subtitle":    true,
+	"v":           true,
+	"text-author": true,
+	"title":       true,
+	"li":          true,
+}
+
+// annotationQuotes are rendered as blockquote
+var annotationQuotes = map[string]bool{
+	"cite":       true,
+	"blockquote": true,
+	"epigraph":   true,
+}
+
+// annotationMarkup detects an annotation with XML or HTML tags
+var annotationMarkup = regexp.MustCompile(`</?[A-Za-z][A-Za-z0-9:-]*[\s/>]`)
+
+// annotationParagraph is a paragraph of an annotation as XHTML and as text
+type annotationParagraph struct {
+	html, text strings.Builder
+	open       []string
+	// inLine is set once the current line has words; space once whitespace
+	// follows them
+	inLine, space bool
+}
+
+func (p *annotationParagraph) empty() bool {
+	return strings.TrimSpace(p.text.String()) == ""
+}
+
+// separate writes a pending space before the next word or inline element
+func (p *annotationParagraph) separate() {
+	if p.inLine && p.space {
+		p.html.WriteByte(' ')
+		p.text.WriteByte(' ')
+	}
+	p.space = false
+}
+
+func (p *annotationParagraph) writeText(s string) {
+	if s == "" {
+		return
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 || isSpace(s[0]) {
+		p.space = true
+	}
+	for i, word := range words {
+		if i > 0 {
+			p.space = true
+		}
+		p.separate()
+		p.html.WriteString(html.EscapeString(word))
+		p.text.WriteString(word)
+		p.inLine = true
+	}
+	if len(words) > 0 && isSpace(s[len(s)-1]) {
+		p.space = true
+	}
+}
+
+// lineBreak ends the current line of the paragraph
+func (p *annotationParagraph) lineBreak() {
+	p.html.WriteString("<br/>")
+	p.text.WriteString("\n")
+	p.inLine, p.space = false, false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// annotationWalker splits an annotation into paragraphs
+type annotationWalker struct {
+	out     strings.Builder
+	text    []string
+	current *annotationParagraph
+}
+
+func (w *annotationWalker) paragraph() *annotationParagraph {
+	if w.current == nil {
+		w.current = &annotationParagraph{}
+	}
+	return w.current
+}
+
+// flush closes the current paragraph
+func (w *annotationWalker) flush() {
+	p := w.current
+	w.current = nil
+	if p == nil || p.empty() {
+		return
+	}
+	for i := len(p.open) - 1; i >= 0; i-- {
+		p.html.WriteString("</" + p.open[i] + ">")
+	}
+	body := strings.TrimSuffix(p.html.String(), "<br/>")
+	w.out.WriteString("<p>" + body + "</p>")
+	w.text = append(w.text, strings.TrimSpace(p.text.String()))
+}
+
+func (w *annotationWalker) start(name string) {
+	switch {
+	case annotationBlocks[name]:
+		w.flush()
+	case annotationQuotes[name]:
+		w.flush()
+		w.out.WriteString("<blockquote>")
+	case name == "empty-line" || name == "br":
+		if p := w.current; p != nil && !p.empty() {
+			p.lineBreak()
+		}
+	default:
+		if tag, ok := annotationInline[name]; ok {
+			p := w.paragraph()
+			p.separate()
+			p.html.WriteString("<" + tag + ">")
+			p.open = append(p.open, tag)
+		}
+	}
+}
+
+func (w *annotationWalker) end(name string) {
+	switch {
+	case annotationBlocks[name]:
+		w.flush()
+	case annotationQuotes[name]:
+		w.flush()
+		if strings.HasSuffix(w.out.String(), "<blockquote>") {
+			trimmed := strings.TrimSuffix(w.out.String(), "<blockquote>")
+			w.out.Reset()
+			w.out.WriteString(trimmed)
+		} else {
+			w.out.WriteString("</blockquote>")
+		}
+	default:
+		tag, ok := annotationInline[name]
+		p := w.current
+		if !ok || p == nil || len(p.open) == 0 || p.open[len(p.open)-1] != tag {
+			return
+		}
+		p.html.WriteString("</" + tag + ">")
+		p.open = p.open[:len(p.open)-1]
+	}
+}
+
+// walkAnnotation parses an FB2 annotation, HTML markup or plain text, one
+// paragraph per line
+func walkAnnotation(content string) *annotationWalker {
+	w := &annotationWalker{}
+	if !annotationMarkup.MatchString(content) {
+		for _, line := range strings.Split(html.UnescapeString(content), "\n") {
+			w.paragraph().writeText(line)
+			w.flush()
+		}
+		return w
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader("<annotation>" + content + "</annotation>"))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	// Quotes opened so far, closed at the end of broken markup
+	quotes := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Keep what was read before the markup broke
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if annotationQuotes[name] {
+				quotes++
+			}
+			w.start(name)
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if annotationQuotes[name] {
+				if quotes == 0 {
+					continue
+				}
+				quotes--
+			}
+			w.end(name)
+		case xml.CharData:
+			w.paragraph().writeText(string(t))
+		}
+	}
+	w.flush()
+	for ; quotes > 0; quotes-- {
+		w.end("blockquote")
+	}
+	return w
+}
+
+// AnnotationXHTML converts a book annotation to an XHTML fragment of
+// paragraphs. The annotation may be the inner XML of an FB2 <annotation>,
+// HTML or plain text with one paragraph per line. Emphasis, strong, struck
+// out, sub/superscript and code text, line breaks and quotes are kept;
+// other markup, links and attributes are dropped and text is escaped.
+func AnnotationXHTML(content string) string {
+	return walkAnnotation(content).out.String()
+}
+
+// AnnotationText converts a book annotation like AnnotationXHTML does but
+// to plain text, one paragraph per line.
+func AnnotationText(content string) string {
+	return strings.Join(walkAnnotation(content).text, "\n")
+}
@@ -7,14 +7,21 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
 )
 
 // Extractor handles metadata extraction from book files
-type Extractor struct{}
+type Extractor struct {
+	// strict rejects FB2 files with validation problems; see SetStrict
+	strict bool
+	genres map[string]bool
+}
 
 // NewExtractor creates a new metadata extractor
 func NewExtractor() *Extractor {
@@ -41,6 +48,16 @@ func (e *Extractor) ExtractFromFile(filePath string) (*BookMetadata, error) {
 	// Generate unique ID from file path and size
 	metadata.ID = e.generateID(filePath, fileInfo.Size())
 
+	if e.strict {
+		problems, err := e.ValidateFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStrict(problems); err != nil {
+			return nil, err
+		}
+	}
+
 	switch ext {
 	case ".fb2":
 		metadata.Format = "fb2"
@@ -60,6 +77,44 @@ func (e *Extractor) ExtractFromFile(filePath string) (*BookMetadata, error) {
 	}
 }
 
+// ExtractFromZipEntry extracts metadata from a book stored inside a ZIP archive.
+// FilePath and FileName are set to the entry name within the archive.
+func (e *Extractor) ExtractFromZipEntry(file *zip.File) (*BookMetadata, error) {
+	ext := strings.ToLower(path.Ext(file.Name))
+
+	metadata := &BookMetadata{
+		FilePath: file.Name,
+		FileName: path.Base(file.Name),
+		FileSize: int64(file.UncompressedSize64),
+		Date:     file.Modified,
+	}
+
+	switch ext {
+	case ".fb2":
+		metadata.Format = "fb2"
+		if e.strict {
+			problems, err := e.validateZipEntry(file)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkStrict(problems); err != nil {
+				return nil, err
+			}
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip entry: %w", err)
+		}
+		defer rc.Close()
+		return e.parseFB2Content(rc, metadata)
+	case ".epub":
+		metadata.Format = "epub"
+		return e.extractEPUBMetadata(metadata)
+	default:
+		return nil, fmt.Errorf("unsupported file format: %s", ext)
+	}
+}
+
 // generateID generates unique ID for book
 func (e *Extractor) generateID(filePath string, size int64) string {
 	data := fmt.Sprintf("%s:%d", filePath, size)
@@ -158,14 +213,18 @@ func (e *Extractor) fillMetadataFromFB2(metadata *BookMetadata, desc *FB2Descrip
 		metadata.Language = "ru" // Default to Russian
 	}
 
-	// Series
-	if titleInfo.Sequence != nil {
-		metadata.Series = strings.TrimSpace(titleInfo.Sequence.Name)
-		if titleInfo.Sequence.Number != "" {
-			if num, err := strconv.Atoi(titleInfo.Sequence.Number); err == nil {
-				metadata.SeriesNum = num
-			}
+	// Series: the first sequence is the main one
+	for _, sequence := range titleInfo.Sequences {
+		name := strings.TrimSpace(sequence.Name)
+		if name == "" || name == metadata.Series {
+			continue
+		}
+		num, _ := strconv.Atoi(strings.TrimSpace(sequence.Number))
+		if metadata.Series == "" {
+			metadata.Series, metadata.SeriesNum = name, num
+			continue
 		}
+		metadata.OtherSeries = append(metadata.OtherSeries, inpx.SeriesRef{Name: name, Num: num})
 	}
 
 	// Annotation
@@ -225,28 +284,13 @@ func (e *Extractor) formatAuthorName(author FB2Author) string {
 	return ""
 }
 
-// cleanAnnotation cleans annotation text
+// cleanAnnotation converts the FB2 annotation to plain text, one paragraph
+// per line, cut to 1000 characters
 func (e *Extractor) cleanAnnotation(content string) string {
-	// Remove XML tags
-	content = strings.ReplaceAll(content, "<p>", "")
-	content = strings.ReplaceAll(content, "</p>", "\n")
-	content = strings.ReplaceAll(content, "<br/>", "\n")
-	content = strings.ReplaceAll(content, "<br>", "\n")
-
-	// Clean up extra whitespace
-	lines := strings.Split(content, "\n")
-	var cleanLines []string
-	for _, line := range lines {
-		if trimmed := strings.TrimSpace(line); trimmed != "" {
-			cleanLines = append(cleanLines, trimmed)
-		}
-	}
-
-	result := strings.Join(cleanLines, "\n")
-	if len(result) > 1000 {
-		result = result[:1000] + "..."
+	result := AnnotationText(content)
+	if runes := []rune(result); len(runes) > 1000 {
+		result = string(runes[:1000]) + "..."
 	}
-
 	return result
 }
 
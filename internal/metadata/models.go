@@ -1,26 +1,32 @@
 package metadata
 
-import "time"
+import (
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
 
 // BookMetadata represents extracted book metadata
 type BookMetadata struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title"`
-	Authors     []string  `json:"authors"`
-	Series      string    `json:"series,omitempty"`
-	SeriesNum   int       `json:"series_num,omitempty"`
-	Genres      []string  `json:"genres"`
-	Year        int       `json:"year,omitempty"`
-	Language    string    `json:"language"`
-	Annotation  string    `json:"annotation,omitempty"`
-	Keywords    []string  `json:"keywords,omitempty"`
-	Date        time.Time `json:"date"`
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors"`
+	Series    string   `json:"series,omitempty"`
+	SeriesNum int      `json:"series_num,omitempty"`
+	// OtherSeries are the series of the further <sequence> elements
+	OtherSeries []inpx.SeriesRef `json:"other_series,omitempty"`
+	Genres      []string         `json:"genres"`
+	Year        int              `json:"year,omitempty"`
+	Language    string           `json:"language"`
+	Annotation  string           `json:"annotation,omitempty"`
+	Keywords    []string         `json:"keywords,omitempty"`
+	Date        time.Time        `json:"date"`
 
 	// File info
-	FilePath    string `json:"file_path"`
-	FileName    string `json:"file_name"`
-	FileSize    int64  `json:"file_size"`
-	Format      string `json:"format"` // fb2, epub, etc
+	FilePath string `json:"file_path"`
+	FileName string `json:"file_name"`
+	FileSize int64  `json:"file_size"`
+	Format   string `json:"format"` // fb2, epub, etc
 
 	// Archive info (for generated archives)
 	ArchivePath string `json:"archive_path,omitempty"`
@@ -29,24 +35,24 @@ type BookMetadata struct {
 
 // FB2Description represents FB2 book description
 type FB2Description struct {
-	TitleInfo   FB2TitleInfo   `xml:"title-info"`
-	SrcTitleInfo *FB2TitleInfo `xml:"src-title-info,omitempty"`
+	TitleInfo    FB2TitleInfo    `xml:"title-info"`
+	SrcTitleInfo *FB2TitleInfo   `xml:"src-title-info,omitempty"`
 	DocumentInfo FB2DocumentInfo `xml:"document-info"`
-	PublishInfo *FB2PublishInfo `xml:"publish-info,omitempty"`
+	PublishInfo  *FB2PublishInfo `xml:"publish-info,omitempty"`
 }
 
 // FB2TitleInfo represents FB2 title information
 type FB2TitleInfo struct {
-	Genres      []FB2Genre      `xml:"genre"`
-	Authors     []FB2Author     `xml:"author"`
-	BookTitle   string          `xml:"book-title"`
-	Annotation  *FB2Annotation  `xml:"annotation,omitempty"`
-	Keywords    string          `xml:"keywords,omitempty"`
-	Date        *FB2Date        `xml:"date,omitempty"`
-	Lang        string          `xml:"lang"`
-	SrcLang     string          `xml:"src-lang,omitempty"`
-	Translators []FB2Author     `xml:"translator,omitempty"`
-	Sequence    *FB2Sequence    `xml:"sequence,omitempty"`
+	Genres      []FB2Genre     `xml:"genre"`
+	Authors     []FB2Author    `xml:"author"`
+	BookTitle   string         `xml:"book-title"`
+	Annotation  *FB2Annotation `xml:"annotation,omitempty"`
+	Keywords    string         `xml:"keywords,omitempty"`
+	Date        *FB2Date       `xml:"date,omitempty"`
+	Lang        string         `xml:"lang"`
+	SrcLang     string         `xml:"src-lang,omitempty"`
+	Translators []FB2Author    `xml:"translator,omitempty"`
+	Sequences   []FB2Sequence  `xml:"sequence,omitempty"`
 }
 
 // FB2Author represents FB2 author
@@ -83,10 +89,10 @@ type FB2Sequence struct {
 
 // FB2DocumentInfo represents FB2 document info
 type FB2DocumentInfo struct {
-	Authors  []FB2Author `xml:"author"`
-	Date     *FB2Date    `xml:"date,omitempty"`
-	ID       string      `xml:"id,omitempty"`
-	Version  string      `xml:"version,omitempty"`
+	Authors []FB2Author `xml:"author"`
+	Date    *FB2Date    `xml:"date,omitempty"`
+	ID      string      `xml:"id,omitempty"`
+	Version string      `xml:"version,omitempty"`
 }
 
 // FB2PublishInfo represents FB2 publish info
@@ -96,4 +102,4 @@ type FB2PublishInfo struct {
 	City      string `xml:"city,omitempty"`
 	Year      string `xml:"year,omitempty"`
 	ISBN      string `xml:"isbn,omitempty"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,251 @@
+package metadata
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// LoadGenreAliases reads a CSV with "alias" and "code" columns mapping
+// non-standard genre codes to canonical ones, keyed by lowercase alias.
+func LoadGenreAliases(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open genre aliases: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genre aliases %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return map[string]string{}, nil
+	}
+
+	aliasColumn, codeColumn := -1, -1
+	for i, header := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(header)) {
+		case "alias":
+			aliasColumn = i
+		case "code":
+			codeColumn = i
+		}
+	}
+	if aliasColumn == -1 || codeColumn == -1 {
+		return nil, fmt.Errorf("genre aliases %s need alias and code columns", path)
+	}
+
+	aliases := make(map[string]string, len(records)-1)
+	for _, record := range records[1:] {
+		if aliasColumn >= len(record) || codeColumn >= len(record) {
+			continue
+		}
+		alias := strings.ToLower(strings.TrimSpace(record[aliasColumn]))
+		code := strings.ToLower(strings.TrimSpace(record[codeColumn]))
+		if alias != "" && code != "" {
+			aliases[alias] = code
+		}
+	}
+	return aliases, nil
+}
+
+// SetStrict makes the extractor reject FB2 files that ValidateFB2 finds
+// problems in. Genre codes are checked against genres (lowercase codes)
+// unless it is empty.
+func (e *Extractor) SetStrict(genres map[string]bool) {
+	e.strict = true
+	e.genres = genres
+}
+
+// ValidateFile validates an FB2 file or every FB2 file inside a ZIP archive
+// and returns the problems found, prefixed with the entry name for ZIPs.
+// Other formats are not checked.
+func (e *Extractor) ValidateFile(filePath string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".fb2":
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		return ValidateFB2(file, e.genres), nil
+	case ".zip":
+		zipReader, err := zip.OpenReader(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip: %w", err)
+		}
+		defer zipReader.Close()
+
+		var problems []string
+		for _, file := range zipReader.File {
+			if !strings.HasSuffix(strings.ToLower(file.Name), ".fb2") {
+				continue
+			}
+			entryProblems, err := e.validateZipEntry(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, problem := range entryProblems {
+				problems = append(problems, file.Name+": "+problem)
+			}
+		}
+		return problems, nil
+	default:
+		return nil, nil
+	}
+}
+
+// validateZipEntry validates an FB2 file stored inside a ZIP archive
+func (e *Extractor) validateZipEntry(file *zip.File) ([]string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip entry: %w", err)
+	}
+	defer rc.Close()
+	return ValidateFB2(rc, e.genres), nil
+}
+
+// checkStrict returns an error listing the problems of an invalid file in
+// strict mode
+func checkStrict(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid FB2: %s", strings.Join(problems, "; "))
+}
+
+// ValidateFB2 reads a whole FB2 document and reports problems: malformed
+// XML, a root element other than FictionBook, a missing body, description
+// fields the FB2 schema requires and, if genres is not empty, genre codes
+// not in it. An empty result means the file is valid.
+func ValidateFB2(r io.Reader, genres map[string]bool) []string {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var (
+		problems []string
+		desc     *FB2Description
+		root     string
+		bodies   int
+		depth    int
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(problems, fmt.Sprintf("malformed XML: %v", err))
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = t.Name.Local
+				continue
+			}
+			if depth != 2 {
+				continue
+			}
+			switch t.Name.Local {
+			case "description":
+				if desc != nil {
+					problems = append(problems, "more than one description")
+				}
+				desc = &FB2Description{}
+				if err := decoder.DecodeElement(desc, &t); err != nil {
+					return append(problems, fmt.Sprintf("malformed XML: %v", err))
+				}
+				depth--
+			case "body":
+				bodies++
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	if root == "" {
+		return append(problems, "empty document")
+	}
+	if root != "FictionBook" {
+		return append(problems, fmt.Sprintf("root element is <%s>, want <FictionBook>", root))
+	}
+	if bodies == 0 {
+		problems = append(problems, "no body")
+	}
+	if desc == nil {
+		return append(problems, "no description")
+	}
+	return append(problems, validateDescription(desc, genres)...)
+}
+
+// validateDescription checks the description fields the FB2 schema requires
+func validateDescription(desc *FB2Description, genres map[string]bool) []string {
+	var problems []string
+	titleInfo := &desc.TitleInfo
+
+	if strings.TrimSpace(titleInfo.BookTitle) == "" {
+		problems = append(problems, "title-info: missing book-title")
+	}
+	if strings.TrimSpace(titleInfo.Lang) == "" {
+		problems = append(problems, "title-info: missing lang")
+	}
+	if len(titleInfo.Authors) == 0 {
+		problems = append(problems, "title-info: missing author")
+	}
+	for i, author := range titleInfo.Authors {
+		if !validFB2Author(author) {
+			problems = append(problems, fmt.Sprintf("title-info: author %d needs first-name and last-name or nickname", i+1))
+		}
+	}
+
+	if len(titleInfo.Genres) == 0 {
+		problems = append(problems, "title-info: missing genre")
+	}
+	for _, genre := range titleInfo.Genres {
+		code := strings.TrimSpace(genre.Value)
+		switch {
+		case code == "":
+			problems = append(problems, "title-info: empty genre")
+		case len(genres) > 0 && !genres[strings.ToLower(code)]:
+			problems = append(problems, fmt.Sprintf("title-info: unknown genre code %q", code))
+		}
+	}
+
+	docInfo := &desc.DocumentInfo
+	if len(docInfo.Authors) == 0 {
+		problems = append(problems, "document-info: missing author")
+	}
+	if docInfo.Date == nil {
+		problems = append(problems, "document-info: missing date")
+	}
+	if strings.TrimSpace(docInfo.ID) == "" {
+		problems = append(problems, "document-info: missing id")
+	}
+	if strings.TrimSpace(docInfo.Version) == "" {
+		problems = append(problems, "document-info: missing version")
+	}
+
+	return problems
+}
+
+// validFB2Author reports whether an author has the names the schema requires
+func validFB2Author(author FB2Author) bool {
+	if strings.TrimSpace(author.Nickname) != "" {
+		return true
+	}
+	return strings.TrimSpace(author.FirstName) != "" && strings.TrimSpace(author.LastName) != ""
+}
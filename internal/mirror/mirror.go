@@ -0,0 +1,354 @@
+// Package mirror pulls books and archives from another pushkinlib instance
+// through its sync API, keeping a local library an incremental copy of it.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// DefaultPageSize is the number of changes requested per call
+const DefaultPageSize = 500
+
+// Result summarizes one sync run.
+type Result struct {
+	Upserted int   `json:"upserted"`
+	Deleted  int   `json:"deleted"`
+	Archives int   `json:"archives"`
+	Cursor   int64 `json:"cursor"`
+}
+
+// Client mirrors a remote library into a local repository and books directory.
+type Client struct {
+	baseURL  string
+	booksDir string
+	repo     *storage.Repository
+	http     *http.Client
+	pageSize int
+}
+
+// NewClient creates a client for the instance at baseURL (e.g.
+// http://library:9090). Books are stored in repo, archives in booksDir.
+func NewClient(baseURL, booksDir string, repo *storage.Repository) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		booksDir: booksDir,
+		repo:     repo,
+		http:     &http.Client{Jar: jar, Timeout: 30 * time.Minute},
+		pageSize: DefaultPageSize,
+	}
+}
+
+// Login opens an admin session on the source instance. It is only needed
+// when the source has authentication enabled.
+func (c *Client) Login(ctx context.Context, user, password string) error {
+	body, err := json.Marshal(map[string]string{"username": user, "password": password})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/auth/login", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login failed: %s", responseError(resp))
+	}
+	return nil
+}
+
+// Sync applies all changes since the last run and downloads archives that
+// are missing locally or differ in size. Progress is saved after every page,
+// so an interrupted run resumes where it stopped.
+func (c *Client) Sync(ctx context.Context) (*Result, error) {
+	cursor, err := c.repo.GetSyncCursor(c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Cursor: cursor}
+
+	for {
+		page, err := c.fetchChanges(ctx, result.Cursor)
+		if err != nil {
+			return result, err
+		}
+		if err := c.apply(page.Changes, result); err != nil {
+			return result, err
+		}
+		if err := c.repo.SaveSyncCursor(c.baseURL, page.Cursor); err != nil {
+			return result, err
+		}
+		result.Cursor = page.Cursor
+		if !page.HasMore || len(page.Changes) == 0 {
+			break
+		}
+	}
+
+	if result.Upserted+result.Deleted > 0 && c.repo.SearchSuggestionsEnabled() {
+		if _, err := c.repo.RebuildSearchTerms(); err != nil {
+			log.Printf("Mirror: failed to rebuild search suggestions: %v", err)
+		}
+	}
+	if result.Upserted+result.Deleted > 0 {
+		if _, err := c.repo.RefreshNavigationStats(); err != nil {
+			log.Printf("Mirror: failed to count navigation lists: %v", err)
+		}
+	}
+
+	n, err := c.syncArchives(ctx)
+	result.Archives = n
+	return result, err
+}
+
+// changesPage mirrors the response of GET /api/v1/sync/changes
+type changesPage struct {
+	Changes []storage.SyncChange `json:"changes"`
+	Cursor  int64                `json:"cursor"`
+	HasMore bool                 `json:"has_more"`
+}
+
+func (c *Client) fetchChanges(ctx context.Context, since int64) (*changesPage, error) {
+	query := url.Values{}
+	query.Set("since", strconv.FormatInt(since, 10))
+	query.Set("limit", strconv.Itoa(c.pageSize))
+
+	var page changesPage
+	if err := c.getJSON(ctx, "/api/v1/sync/changes?"+query.Encode(), &page); err != nil {
+		return nil, fmt.Errorf("failed to fetch changes: %w", err)
+	}
+	return &page, nil
+}
+
+// apply stores one page of changes
+func (c *Client) apply(changes []storage.SyncChange, result *Result) error {
+	var (
+		books   []inpx.Book
+		deleted []string
+		changed []string
+	)
+	for _, change := range changes {
+		switch {
+		case change.Op == storage.SyncOpDelete:
+			deleted = append(deleted, change.BookID)
+		case change.Op == storage.SyncOpUpsert && change.Book != nil:
+			books = append(books, *change.Book)
+		default:
+			continue
+		}
+		changed = append(changed, change.BookID)
+	}
+
+	// The list sizes are counted again once the pull is done
+	if len(books)+len(deleted) > 0 {
+		if err := c.repo.InvalidateNavigationStats(); err != nil {
+			return err
+		}
+	}
+	if err := c.repo.UpsertBooks(books); err != nil {
+		return err
+	}
+	if err := c.repo.DeleteBooks(deleted); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if change.Op == storage.SyncOpUpsert && change.SHA256 != "" && change.Book != nil {
+			if err := c.repo.SaveBookChecksum(change.BookID, change.SHA256, change.Book.FileSize); err != nil {
+				return err
+			}
+		}
+	}
+	result.Upserted += len(books)
+	result.Deleted += len(deleted)
+
+	// Lets this mirror serve as a source itself
+	if c.repo.SyncEnabled() {
+		if _, err := c.repo.RecordBookSyncChanges(changed...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteArchive mirrors an entry of GET /api/v1/sync/archives. Name is the
+// slash-separated path of the archive relative to the books directory.
+type remoteArchive struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// syncArchives downloads every remote archive that is missing locally or
+// has a different size, and returns the number downloaded.
+func (c *Client) syncArchives(ctx context.Context) (int, error) {
+	var list struct {
+		Archives []remoteArchive `json:"archives"`
+	}
+	if err := c.getJSON(ctx, "/api/v1/sync/archives", &list); err != nil {
+		return 0, fmt.Errorf("failed to list archives: %w", err)
+	}
+	if err := os.MkdirAll(c.booksDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create books directory: %w", err)
+	}
+
+	downloaded := 0
+	for _, archive := range list.Archives {
+		if !validArchiveName(archive.Name) {
+			log.Printf("Mirror: skipping archive with invalid name %q", archive.Name)
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(c.booksDir, filepath.FromSlash(archive.Name))); err == nil && info.Size() == archive.Size {
+			continue
+		}
+		if err := c.downloadArchive(ctx, archive); err != nil {
+			return downloaded, err
+		}
+		downloaded++
+	}
+	return downloaded, nil
+}
+
+// downloadArchive fetches an archive into a temporary file and renames it
+// into place once complete. The temporary file keeps the Last-Modified
+// time of the archive on the source, so that a download interrupted
+// earlier is resumed with a Range request unless the archive changed since.
+func (c *Client) downloadArchive(ctx context.Context, archive remoteArchive) error {
+	target := filepath.Join(c.booksDir, filepath.FromSlash(archive.Name))
+	partial := target + ".part"
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", archive.Name, err)
+	}
+
+	var offset int64
+	var modified time.Time
+	if info, err := os.Stat(partial); err == nil && info.Size() < archive.Size {
+		offset, modified = info.Size(), info.ModTime()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/sync/archives/"+escapeArchiveName(archive.Name), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", modified.UTC().Format(http.TimeFormat))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", archive.Name, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags = os.O_WRONLY | os.O_APPEND
+		log.Printf("Mirror: resuming %s at %d of %d bytes", archive.Name, offset, archive.Size)
+	case resp.StatusCode == http.StatusOK:
+		// The source sends the whole archive when it changed
+		offset = 0
+	default:
+		return fmt.Errorf("failed to download %s: %s", archive.Name, responseError(resp))
+	}
+
+	f, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", partial, err)
+	}
+	size, err := io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if modified, parseErr := http.ParseTime(resp.Header.Get("Last-Modified")); parseErr == nil {
+		os.Chtimes(partial, modified, modified)
+	}
+	if err != nil {
+		// Keep what arrived for the next attempt to resume
+		return fmt.Errorf("failed to download %s: %w", archive.Name, err)
+	}
+	if size += offset; size != archive.Size {
+		os.Remove(partial)
+		return fmt.Errorf("failed to download %s: expected %d bytes, got %d", archive.Name, archive.Size, size)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to store %s: %w", archive.Name, err)
+	}
+	return nil
+}
+
+// validArchiveName reports whether an archive name from the source is a
+// relative path that stays inside the books directory
+func validArchiveName(name string) bool {
+	if name == "" || strings.ContainsRune(name, '\\') {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || strings.HasPrefix(elem, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeArchiveName escapes each element of an archive path for a URL
+func escapeArchiveName(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", responseError(resp))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// responseError describes a failed response, using the API error message
+// when the body carries one.
+func responseError(resp *http.Response) string {
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, envelope.Error.Message)
+	}
+	return resp.Status
+}
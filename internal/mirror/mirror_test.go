@@ -0,0 +1,212 @@
+package mirror
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/api"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func newTestRepo(t *testing.T) *storage.Repository {
+	t.Helper()
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return storage.NewRepository(db)
+}
+
+func testBook(id, title string) inpx.Book {
+	return inpx.Book{
+		ID:          id,
+		Title:       title,
+		Authors:     []string{"Александр Пушкин"},
+		Language:    "ru",
+		ArchivePath: "pushkin",
+		FileNum:     id,
+		Format:      "fb2",
+		Date:        time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// TestSync mirrors a source instance served over HTTP, first in full and
+// then incrementally.
+func TestSync(t *testing.T) {
+	source := newTestRepo(t)
+	source.SetSyncEnabled(true)
+	if err := source.InsertBooks([]inpx.Book{testBook("p-1", "Евгений Онегин"), testBook("p-2", "Капитанская дочка")}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if _, err := source.RecordSyncChanges(); err != nil {
+		t.Fatalf("RecordSyncChanges failed: %v", err)
+	}
+	sourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "pushkin.zip"), []byte("zip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := api.NewHandlers(source, sourceDir, "", auth.NewMiddleware(source, false))
+	server := httptest.NewServer(api.SetupRoutes(handlers))
+	defer server.Close()
+
+	local := newTestRepo(t)
+	localDir := t.TempDir()
+	client := NewClient(server.URL+"/", localDir, local)
+	client.pageSize = 1
+
+	result, err := client.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Upserted != 2 || result.Deleted != 0 || result.Archives != 1 || result.Cursor != 2 {
+		t.Errorf("unexpected first sync: %+v", result)
+	}
+	if data, err := os.ReadFile(filepath.Join(localDir, "pushkin.zip")); err != nil || string(data) != "zip data" {
+		t.Errorf("archive not mirrored: %q (%v)", data, err)
+	}
+	if book, err := local.GetBookByID("p-1"); err != nil || book == nil || book.Title != "Евгений Онегин" {
+		t.Fatalf("book not mirrored: %+v (%v)", book, err)
+	}
+
+	// Change one book and remove the other on the source
+	if err := source.UpsertBooks([]inpx.Book{testBook("p-1", "Евгений Онегин. Роман в стихах")}); err != nil {
+		t.Fatalf("UpsertBooks failed: %v", err)
+	}
+	if err := source.DeleteBooks([]string{"p-2"}); err != nil {
+		t.Fatalf("DeleteBooks failed: %v", err)
+	}
+	if _, err := source.RecordSyncChanges(); err != nil {
+		t.Fatalf("RecordSyncChanges failed: %v", err)
+	}
+
+	result, err = client.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Upserted != 1 || result.Deleted != 1 || result.Archives != 0 || result.Cursor != 4 {
+		t.Errorf("unexpected incremental sync: %+v", result)
+	}
+	if book, _ := local.GetBookByID("p-1"); book == nil || book.Title != "Евгений Онегин. Роман в стихах" {
+		t.Errorf("expected updated title, got %+v", book)
+	}
+	if book, _ := local.GetBookByID("p-2"); book != nil {
+		t.Errorf("expected p-2 to be deleted, got %+v", book)
+	}
+}
+
+// TestSync_Disabled verifies a source without SYNC_ENABLED is reported.
+func TestSync_Disabled(t *testing.T) {
+	source := newTestRepo(t)
+	handlers := api.NewHandlers(source, t.TempDir(), "", auth.NewMiddleware(source, false))
+	server := httptest.NewServer(api.SetupRoutes(handlers))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, t.TempDir(), newTestRepo(t)).Sync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Sync is not enabled") {
+		t.Fatalf("expected the source error, got %v", err)
+	}
+}
+
+// TestSync_ResumeArchive verifies an interrupted archive download resumes
+// where it stopped, and starts over when the archive changed on the source.
+func TestSync_ResumeArchive(t *testing.T) {
+	source := newTestRepo(t)
+	source.SetSyncEnabled(true)
+	sourceDir := t.TempDir()
+	archive := filepath.Join(sourceDir, "pushkin.zip")
+	if err := os.WriteFile(archive, []byte("zip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := api.NewHandlers(source, sourceDir, "", auth.NewMiddleware(source, false))
+	server := httptest.NewServer(api.SetupRoutes(handlers))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	client := NewClient(server.URL, localDir, newTestRepo(t))
+	partial := filepath.Join(localDir, "pushkin.zip.part")
+	local := filepath.Join(localDir, "pushkin.zip")
+
+	// Only the missing bytes are requested, so the kept prefix survives
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte("ZIP "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(partial, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, err := os.ReadFile(local); err != nil || string(data) != "ZIP data" {
+		t.Errorf("expected the download resumed, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file renamed, got %v", err)
+	}
+
+	// A part of an archive that changed since is downloaded again in full
+	if err := os.WriteFile(archive, []byte("new zip data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, []byte("zip "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(partial, info.ModTime(), info.ModTime().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, err := os.ReadFile(local); err != nil || string(data) != "new zip data" {
+		t.Errorf("expected the changed archive downloaded in full, got %q (%v)", data, err)
+	}
+}
+
+// TestSync_NestedArchives verifies archives in subfolders of the source
+// are mirrored to the same folders, and hidden folders are skipped.
+func TestSync_NestedArchives(t *testing.T) {
+	source := newTestRepo(t)
+	source.SetSyncEnabled(true)
+	sourceDir := t.TempDir()
+	for _, name := range []string{"classics/Пушкин и Гоголь.zip", ".trash/old.zip"} {
+		path := filepath.Join(sourceDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("zip data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handlers := api.NewHandlers(source, sourceDir, "", auth.NewMiddleware(source, false))
+	server := httptest.NewServer(api.SetupRoutes(handlers))
+	defer server.Close()
+
+	localDir := t.TempDir()
+	result, err := NewClient(server.URL, localDir, newTestRepo(t)).Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Archives != 1 {
+		t.Errorf("expected 1 archive, got %d", result.Archives)
+	}
+	if data, err := os.ReadFile(filepath.Join(localDir, "classics", "Пушкин и Гоголь.zip")); err != nil || string(data) != "zip data" {
+		t.Errorf("expected the nested archive mirrored, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, ".trash")); !os.IsNotExist(err) {
+		t.Errorf("expected the hidden folder skipped, got %v", err)
+	}
+}
@@ -0,0 +1,495 @@
+// Package notifications tells users about new books matching their saved
+// searches. After an import it collects, for every saved search with
+// notify set, the books added or changed since it was last run or
+// notified, and sends them per user by email and to the configured
+// webhooks.
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// ErrBusy is returned by Run while another run is in progress.
+var ErrBusy = errors.New("notifications are already being sent")
+
+// SMTPConfig holds the mail server new books are sent through.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Enabled reports whether mail can be sent
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != "" && c.From != ""
+}
+
+// Config holds notification settings.
+type Config struct {
+	// Webhooks receive every notification as a JSON POST.
+	Webhooks []string
+	SMTP     SMTPConfig
+	// MaxBooks is the most books listed per saved search; the rest are
+	// only counted.
+	MaxBooks int
+	// MinInterval is the least time between two notifications of a user at
+	// one destination. New books found sooner wait for a later run.
+	MinInterval time.Duration
+	// RatePerMinute bounds the emails and webhook calls sent per minute.
+	RatePerMinute int
+	// BaseURL is the public URL of the library, for links to books.
+	BaseURL   string
+	UserAgent string
+}
+
+// Enabled reports whether notifications have anywhere to go
+func (c Config) Enabled() bool {
+	return len(c.Webhooks) > 0 || c.SMTP.Enabled()
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	// Searches is the number of saved searches with notify set
+	Searches int `json:"searches"`
+	// Users is the number of users notified, Books the new books sent to
+	// them and Messages the emails and webhook calls sent
+	Users    int `json:"users"`
+	Books    int `json:"books"`
+	Messages int `json:"messages"`
+	// Deferred counts users with a destination notified less than
+	// MinInterval ago, Failed those with a destination that could not be
+	// reached; such destinations get their books at a later run
+	Deferred   int       `json:"deferred"`
+	Failed     int       `json:"failed"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Notifier sends the new books of saved searches.
+type Notifier struct {
+	repo   *storage.Repository
+	cfg    Config
+	client *http.Client
+	// sendMail is smtp.SendMail, replaced in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	running  sync.Mutex
+	trigger  chan struct{}
+	lastSend time.Time
+
+	lastMu sync.Mutex
+	last   *Report
+}
+
+// New creates a notifier with defaults applied.
+func New(repo *storage.Repository, cfg Config) *Notifier {
+	if cfg.MaxBooks <= 0 {
+		cfg.MaxBooks = 20
+	}
+	if cfg.RatePerMinute <= 0 {
+		cfg.RatePerMinute = 30
+	}
+	if cfg.SMTP.Port <= 0 {
+		cfg.SMTP.Port = 587
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "Pushkinlib (https://github.com/piligrim/pushkinlib)"
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+
+	return &Notifier{
+		repo:     repo,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 15 * time.Second},
+		sendMail: smtp.SendMail,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// ParseWebhooks parses a comma-separated list of webhook URLs.
+func ParseWebhooks(spec string) ([]string, error) {
+	var hooks []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		u, err := url.Parse(item)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", item)
+		}
+		hooks = append(hooks, u.String())
+	}
+	return hooks, nil
+}
+
+// Start runs the worker that sends notifications when triggered, until ctx
+// is cancelled.
+func (n *Notifier) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.trigger:
+				report, err := n.Run(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Notifications: %v", err)
+					}
+					continue
+				}
+				if report.Users > 0 || report.Failed > 0 {
+					log.Printf("Notifications: %d new books sent to %d users in %d messages, %d deferred, %d failed",
+						report.Books, report.Users, report.Messages, report.Deferred, report.Failed)
+				}
+			}
+		}
+	}()
+}
+
+// Trigger asks the worker started by Start for a run, typically after an
+// import. Triggers during a run are merged into one more run.
+func (n *Notifier) Trigger() {
+	select {
+	case n.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// LastReport returns the report of the last run, or nil before the first.
+func (n *Notifier) LastReport() *Report {
+	n.lastMu.Lock()
+	defer n.lastMu.Unlock()
+	return n.last
+}
+
+// Run sends the new books of every saved search with notify set, batched
+// into one message per user and destination, and returns what was sent.
+func (n *Notifier) Run(ctx context.Context) (*Report, error) {
+	if !n.running.TryLock() {
+		return nil, ErrBusy
+	}
+	defer n.running.Unlock()
+
+	// Books changed while the run goes on are left for the next one
+	started := time.Now()
+	report := &Report{StartedAt: started}
+	searches, err := n.repo.ListNotifySearches()
+	if err != nil {
+		return nil, err
+	}
+	report.Searches = len(searches)
+
+	for start := 0; start < len(searches); {
+		end := start + 1
+		for end < len(searches) && searches[end].UserID == searches[start].UserID {
+			end++
+		}
+		if err := n.notifyUser(ctx, searches[start:end], started, report); err != nil {
+			return nil, err
+		}
+		start = end
+	}
+
+	report.DurationMs = time.Since(started).Milliseconds()
+	n.lastMu.Lock()
+	n.last = report
+	n.lastMu.Unlock()
+	return report, nil
+}
+
+// searchBooks are the new books of a saved search
+type searchBooks struct {
+	search storage.SavedSearch
+	books  []storage.Book
+	total  int
+}
+
+// destination is a webhook or an email address notifications go to. key
+// names it in the delivery records of saved searches.
+type destination struct {
+	key     string
+	webhook string
+	email   string
+}
+
+// destinations returns where the new books of the searches of user go:
+// every webhook, and the addresses of the searches that are the address of
+// the account
+func (n *Notifier) destinations(user *storage.User, searches []storage.SavedSearch) []destination {
+	var dests []destination
+	for _, hook := range n.cfg.Webhooks {
+		dests = append(dests, destination{key: "webhook:" + hook, webhook: hook})
+	}
+	if !n.cfg.SMTP.Enabled() || user == nil || user.Email == "" {
+		return dests
+	}
+	seen := map[string]bool{}
+	for _, search := range searches {
+		address := strings.ToLower(search.Email)
+		if address == "" || seen[address] || !strings.EqualFold(address, user.Email) {
+			continue
+		}
+		seen[address] = true
+		dests = append(dests, destination{key: "email:" + address, email: search.Email})
+	}
+	return dests
+}
+
+// notifyUser sends the new books of the saved searches of one user to each
+// destination. Every destination keeps its own delivery records, so one
+// that fails gets its books at a later run without repeating them to the
+// others. Delivery failures are counted in the report; only database and
+// context errors are returned.
+func (n *Notifier) notifyUser(ctx context.Context, searches []storage.SavedSearch, started time.Time, report *Report) error {
+	userID := searches[0].UserID
+	user, err := n.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	hidden, err := n.repo.RestrictionsFor(user)
+	if err != nil {
+		return err
+	}
+	deliveries, err := n.repo.SavedSearchDeliveries(userID)
+	if err != nil {
+		return err
+	}
+
+	// Destinations last delivered at the same time share the search
+	found := map[string]*searchBooks{}
+	newBooks := func(search storage.SavedSearch, since time.Time) (*searchBooks, error) {
+		key := search.ID + "@" + since.Format(time.RFC3339Nano)
+		if item, ok := found[key]; ok {
+			return item, nil
+		}
+		filter := search.Filter
+		filter.ChangedAfter = &since
+		filter.Limit = n.cfg.MaxBooks
+		filter.Hidden = hidden
+		result, err := n.repo.SearchBooks(filter)
+		if err != nil {
+			return nil, fmt.Errorf("saved search %s: %w", search.ID, err)
+		}
+		item := &searchBooks{search: search, books: result.Books, total: result.Total}
+		found[key] = item
+		return item, nil
+	}
+
+	sentBooks := map[string]int{}
+	var sent, deferred, failed bool
+	for _, dest := range n.destinations(user, searches) {
+		var batch []searchBooks
+		recent := false
+		for _, search := range searches {
+			if dest.email != "" && !strings.EqualFold(search.Email, dest.email) {
+				continue
+			}
+			delivered := deliveries[search.ID][dest.key]
+			if n.cfg.MinInterval > 0 && started.Sub(delivered) < n.cfg.MinInterval {
+				recent = true
+				break
+			}
+			item, err := newBooks(search, search.PendingSince(delivered))
+			if err != nil {
+				return err
+			}
+			if item.total > 0 {
+				batch = append(batch, *item)
+			}
+		}
+		if recent {
+			deferred = true
+			continue
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := n.wait(ctx); err != nil {
+			return err
+		}
+		if dest.webhook != "" {
+			err = n.postWebhook(ctx, dest.webhook, user, userID, batch)
+		} else {
+			err = n.sendEmail(dest.email, batch)
+		}
+		if err != nil {
+			log.Printf("Notifications: %s: %v", dest.key, err)
+			failed = true
+			continue
+		}
+		report.Messages++
+		sent = true
+
+		ids := make([]string, len(batch))
+		for i, item := range batch {
+			ids[i] = item.search.ID
+			sentBooks[item.search.ID] = max(sentBooks[item.search.ID], item.total)
+		}
+		if err := n.repo.MarkSavedSearchesDelivered(ids, dest.key, started); err != nil {
+			return err
+		}
+	}
+
+	for _, count := range sentBooks {
+		report.Books += count
+	}
+	if sent {
+		report.Users++
+	}
+	if deferred {
+		report.Deferred++
+	}
+	if failed {
+		report.Failed++
+	}
+	return nil
+}
+
+// wait paces messages to RatePerMinute
+func (n *Notifier) wait(ctx context.Context) error {
+	interval := time.Minute / time.Duration(n.cfg.RatePerMinute)
+	if delay := time.Until(n.lastSend.Add(interval)); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	n.lastSend = time.Now()
+	return nil
+}
+
+// bookURL returns the public page of a book
+func (n *Notifier) bookURL(id string) string {
+	return n.cfg.BaseURL + "/books/" + url.PathEscape(id)
+}
+
+// WebhookBook is a book in a webhook notification.
+type WebhookBook struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Authors []string `json:"authors,omitempty"`
+	URL     string   `json:"url"`
+}
+
+// WebhookSearch is a saved search in a webhook notification. NewBooks
+// counts all its new books, Books lists at most MaxBooks of them.
+type WebhookSearch struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	NewBooks int           `json:"new_books"`
+	Books    []WebhookBook `json:"books"`
+}
+
+// WebhookPayload is the body POSTed to webhooks.
+type WebhookPayload struct {
+	UserID   string          `json:"user_id"`
+	Username string          `json:"username,omitempty"`
+	Searches []WebhookSearch `json:"searches"`
+}
+
+// postWebhook sends a batch to a webhook, which must answer 2xx
+func (n *Notifier) postWebhook(ctx context.Context, hook string, user *storage.User, userID string, batch []searchBooks) error {
+	payload := WebhookPayload{UserID: userID}
+	if user != nil {
+		payload.Username = user.Username
+	}
+	for _, item := range batch {
+		search := WebhookSearch{ID: item.search.ID, Name: item.search.Name, NewBooks: item.total, Books: []WebhookBook{}}
+		for _, book := range item.books {
+			entry := WebhookBook{ID: book.ID, Title: book.Title, URL: n.bookURL(book.ID)}
+			for _, author := range book.Authors {
+				entry.Authors = append(entry.Authors, author.Name)
+			}
+			search.Books = append(search.Books, entry)
+		}
+		payload.Searches = append(payload.Searches, search)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.cfg.UserAgent)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail sends the searches of a batch to one address
+func (n *Notifier) sendEmail(address string, batch []searchBooks) error {
+	var auth smtp.Auth
+	if n.cfg.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", n.cfg.SMTP.Username, n.cfg.SMTP.Password, n.cfg.SMTP.Host)
+	}
+	addr := n.cfg.SMTP.Host + ":" + strconv.Itoa(n.cfg.SMTP.Port)
+	return n.sendMail(addr, auth, n.cfg.SMTP.From, []string{address}, n.emailMessage(address, batch))
+}
+
+// emailMessage composes a plain text email to address listing the new
+// books of the searches of a batch
+func (n *Notifier) emailMessage(address string, batch []searchBooks) []byte {
+	var body strings.Builder
+	for _, item := range batch {
+		fmt.Fprintf(&body, "«%s» — новых книг: %d\r\n\r\n", item.search.Name, item.total)
+		for _, book := range item.books {
+			var authors []string
+			for _, author := range book.Authors {
+				authors = append(authors, author.Name)
+			}
+			if len(authors) > 0 {
+				fmt.Fprintf(&body, "%s — %s\r\n", strings.Join(authors, ", "), book.Title)
+			} else {
+				fmt.Fprintf(&body, "%s\r\n", book.Title)
+			}
+			fmt.Fprintf(&body, "%s\r\n\r\n", n.bookURL(book.ID))
+		}
+		if more := item.total - len(item.books); more > 0 {
+			fmt.Fprintf(&body, "…и ещё %d\r\n\r\n", more)
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.SMTP.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", address)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Новые книги по сохранённым поискам"))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(body.String())
+	return msg.Bytes()
+}
@@ -0,0 +1,229 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// sentMail is an email captured instead of sending it
+type sentMail struct {
+	addr string
+	to   []string
+	msg  string
+}
+
+func setupTestNotifier(t *testing.T, cfg Config) (*Notifier, *storage.Repository, *[]sentMail) {
+	t.Helper()
+
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := storage.NewRepository(db)
+
+	if cfg.RatePerMinute == 0 {
+		cfg.RatePerMinute = 60000
+	}
+	notifier := New(repo, cfg)
+	var mails []sentMail
+	notifier.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, sentMail{addr: addr, to: to, msg: string(msg)})
+		return nil
+	}
+	return notifier, repo, &mails
+}
+
+// addBooks inserts books after a pause, so that they are newer than the
+// saved searches made before
+func addBooks(t *testing.T, repo *storage.Repository, books ...inpx.Book) {
+	t.Helper()
+	time.Sleep(10 * time.Millisecond)
+	for i := range books {
+		books[i].Format, books[i].Date = "fb2", time.Now()
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+}
+
+func TestNotifier_Run(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []WebhookPayload
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	notifier, repo, mails := setupTestNotifier(t, Config{
+		Webhooks:    []string{hook.URL},
+		SMTP:        SMTPConfig{Host: "mail.example.com", From: "library@example.com"},
+		MaxBooks:    1,
+		MinInterval: time.Hour,
+		BaseURL:     "https://books.example.com/",
+	})
+
+	user, err := repo.CreateUser("reader", "secret", "Reader", false)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := repo.SetUserEmail(user.ID, "Reader@example.com"); err != nil {
+		t.Fatalf("SetUserEmail failed: %v", err)
+	}
+	for _, search := range []storage.SavedSearch{
+		{Name: "Фантастика", Filter: storage.BookFilter{Genres: []string{"sf"}}, Notify: true, Email: "reader@example.com"},
+		{Name: "Толстой", Filter: storage.BookFilter{Authors: []string{"Толстой"}}, Notify: true, Email: "reader@example.com"},
+		// Only the address of the account receives mail
+		{Name: "Чужой адрес", Filter: storage.BookFilter{Genres: []string{"prose"}}, Notify: true, Email: "victim@example.com"},
+		{Name: "Без уведомлений", Filter: storage.BookFilter{Genres: []string{"sf"}}},
+	} {
+		search.UserID = user.ID
+		if _, err := repo.CreateSavedSearch(search); err != nil {
+			t.Fatalf("CreateSavedSearch failed: %v", err)
+		}
+	}
+
+	addBooks(t, repo,
+		inpx.Book{ID: "n-1", Title: "Солярис", Authors: []string{"Лем"}, Genre: "sf"},
+		inpx.Book{ID: "n-2", Title: "Непобедимый", Authors: []string{"Лем"}, Genre: "sf"},
+		inpx.Book{ID: "n-3", Title: "Война и мир", Authors: []string{"Толстой"}, Genre: "prose"},
+	)
+
+	report, err := notifier.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Searches != 3 || report.Users != 1 || report.Books != 4 || report.Messages != 2 || report.Failed != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// One webhook call batches all searches of the user, one email those
+	// with the address of the account
+	if len(payloads) != 1 || payloads[0].Username != "reader" || len(payloads[0].Searches) != 3 {
+		t.Fatalf("unexpected webhook payloads %+v", payloads)
+	}
+	for _, search := range payloads[0].Searches {
+		if search.Name == "Фантастика" {
+			if search.NewBooks != 2 || len(search.Books) != 1 {
+				t.Errorf("expected 2 new books with 1 listed, got %+v", search)
+			} else if !strings.HasPrefix(search.Books[0].URL, "https://books.example.com/books/n-") {
+				t.Errorf("unexpected book URL %q", search.Books[0].URL)
+			}
+		}
+	}
+	if len(*mails) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(*mails))
+	}
+	mail := (*mails)[0]
+	if mail.addr != "mail.example.com:587" || len(mail.to) != 1 || mail.to[0] != "reader@example.com" {
+		t.Errorf("unexpected email envelope %+v", mail)
+	}
+	for _, want := range []string{"«Фантастика» — новых книг: 2", "«Толстой» — новых книг: 1", "Толстой — Война и мир", "…и ещё 1"} {
+		if !strings.Contains(mail.msg, want) {
+			t.Errorf("expected email to contain %q, got:\n%s", want, mail.msg)
+		}
+	}
+	if strings.Contains(mail.msg, "Чужой адрес") {
+		t.Errorf("expected the search with another address left out, got:\n%s", mail.msg)
+	}
+
+	// The books were sent, and the user was notified too recently for more
+	addBooks(t, repo, inpx.Book{ID: "n-4", Title: "Эдем", Authors: []string{"Лем"}, Genre: "sf"})
+	report, err = notifier.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Users != 0 || report.Deferred != 1 || len(payloads) != 1 || len(*mails) != 1 {
+		t.Errorf("expected the user deferred, got %+v", report)
+	}
+
+	// Without the interval only the book added since is sent
+	notifier.cfg.MinInterval = 0
+	report, err = notifier.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Users != 1 || report.Books != 1 || len(payloads) != 2 {
+		t.Fatalf("expected only the new book sent, got %+v", report)
+	}
+	if got := payloads[1].Searches; len(got) != 1 || got[0].Books[0].ID != "n-4" {
+		t.Errorf("unexpected webhook searches %+v", got)
+	}
+}
+
+// TestNotifier_RunFailed checks that a destination that could not be
+// reached gets the books at the next run, and only it.
+func TestNotifier_RunFailed(t *testing.T) {
+	var calls, okCalls int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+	okHook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okCalls++
+	}))
+	defer okHook.Close()
+
+	notifier, repo, _ := setupTestNotifier(t, Config{Webhooks: []string{hook.URL, okHook.URL}})
+	if _, err := repo.CreateSavedSearch(storage.SavedSearch{
+		UserID: "user-1", Name: "Фантастика", Filter: storage.BookFilter{Genres: []string{"sf"}}, Notify: true,
+	}); err != nil {
+		t.Fatalf("CreateSavedSearch failed: %v", err)
+	}
+	addBooks(t, repo, inpx.Book{ID: "f-1", Title: "Солярис", Authors: []string{"Лем"}, Genre: "sf"})
+
+	report, err := notifier.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Failed != 1 || report.Users != 1 || report.Messages != 1 || okCalls != 1 {
+		t.Errorf("expected one delivery failed and one sent, got %+v", report)
+	}
+
+	// The book is sent again at the next run, to the failed webhook only
+	report, err = notifier.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Failed != 0 || report.Users != 1 || report.Books != 1 || calls != 2 || okCalls != 1 {
+		t.Errorf("expected the book sent on retry, got %+v after %d and %d calls", report, calls, okCalls)
+	}
+	if last := notifier.LastReport(); last != report {
+		t.Errorf("expected the last report kept, got %+v", last)
+	}
+}
+
+func TestParseWebhooks(t *testing.T) {
+	hooks, err := ParseWebhooks(" https://hooks.example.com/a , http://localhost:8080/b,")
+	if err != nil {
+		t.Fatalf("ParseWebhooks failed: %v", err)
+	}
+	if len(hooks) != 2 || hooks[0] != "https://hooks.example.com/a" {
+		t.Errorf("unexpected webhooks %v", hooks)
+	}
+	for _, spec := range []string{"ftp://example.com", "hooks.example.com/a", "https://"} {
+		if _, err := ParseWebhooks(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
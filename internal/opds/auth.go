@@ -0,0 +1,95 @@
+package opds
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// OPDS Authentication 1.0 constants
+const (
+	RelAuthDocument  = "http://opds-spec.org/auth/document"
+	TypeAuthDocument = "application/opds-authentication+json"
+	AuthTypeBasic    = "http://opds-spec.org/auth/basic"
+)
+
+// AuthDocument is an OPDS Authentication Document
+type AuthDocument struct {
+	ID             string     `json:"id"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description,omitempty"`
+	Links          []AuthLink `json:"links,omitempty"`
+	Authentication []AuthFlow `json:"authentication"`
+}
+
+// AuthLink is a link inside an Authentication Document
+type AuthLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+// AuthFlow describes one supported authentication flow
+type AuthFlow struct {
+	Type   string      `json:"type"`
+	Labels *AuthLabels `json:"labels,omitempty"`
+}
+
+// AuthLabels are the input labels shown by the client for Basic Auth
+type AuthLabels struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// AuthDocumentURL returns the absolute URL of the Authentication Document
+func (b *Builder) AuthDocumentURL() string {
+	return b.baseURL + "/opds/auth.json"
+}
+
+// BuildAuthDocument creates the Authentication Document for HTTP Basic Auth
+func (b *Builder) BuildAuthDocument() *AuthDocument {
+	return &AuthDocument{
+		ID:          b.AuthDocumentURL(),
+		Title:       b.catalogTitle,
+		Description: "Войдите, используя логин и пароль библиотеки",
+		Links: []AuthLink{
+			{Rel: "start", Href: b.baseURL + "/opds", Type: TypeNavigation},
+			{Rel: "logo", Href: b.baseURL + "/favicon.ico"},
+		},
+		Authentication: []AuthFlow{
+			{
+				Type:   AuthTypeBasic,
+				Labels: &AuthLabels{Login: "Логин", Password: "Пароль"},
+			},
+		},
+	}
+}
+
+// SetAuthEnabled makes feeds advertise the Authentication Document
+func (h *Handler) SetAuthEnabled(enabled bool) {
+	h.authEnabled = enabled
+}
+
+// AuthDocumentURL returns the absolute URL of the Authentication Document
+func (h *Handler) AuthDocumentURL() string {
+	return h.builder().AuthDocumentURL()
+}
+
+// AuthDocumentJSON returns the encoded Authentication Document
+func (h *Handler) AuthDocumentJSON() ([]byte, error) {
+	return json.Marshal(h.builder().BuildAuthDocument())
+}
+
+// AuthDocument serves the OPDS Authentication Document
+func (h *Handler) AuthDocument(w http.ResponseWriter, r *http.Request) {
+	data, err := h.AuthDocumentJSON()
+	if err != nil {
+		http.Error(w, "Failed to encode authentication document", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", TypeAuthDocument)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("AuthDocument: failed to write response: %v", err)
+	}
+}
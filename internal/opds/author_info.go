@@ -0,0 +1,89 @@
+package opds
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// AuthorInfoProvider returns cached author biographies without blocking on
+// external requests.
+type AuthorInfoProvider interface {
+	Cached(name string) *storage.AuthorInfo
+}
+
+// SetAuthorInfoProvider enables author bios and portraits in author entries
+func (h *Handler) SetAuthorInfoProvider(provider AuthorInfoProvider) {
+	h.authorInfo = provider
+}
+
+// applyAuthorInfo adds a biography and portrait links to an author entry.
+// The portrait is linked through the server, so readers do not contact
+// Wikimedia.
+func (b *Builder) applyAuthorInfo(entry *Entry, authorID int, info *storage.AuthorInfo) {
+	if info == nil {
+		return
+	}
+
+	if info.Bio != "" {
+		entry.Content = &Content{Type: "text", Text: info.Bio}
+	}
+
+	if info.PhotoURL != "" {
+		imageType := imageMIMEType(info.PhotoURL)
+		portraitURL := fmt.Sprintf("%s/api/v1/authors/%d/portrait", b.baseURL, authorID)
+		entry.Links = append(entry.Links,
+			Link{Rel: RelImage, Type: imageType, Href: portraitURL},
+			Link{Rel: RelThumbnail, Type: imageType, Href: portraitURL},
+		)
+	}
+}
+
+// applyDisambiguation warns in an author's book feed that its books may be
+// by several people of that name and links the namesakes
+func (b *Builder) applyDisambiguation(feed *Feed, author *storage.Author, d *storage.AuthorDisambiguation) {
+	if d == nil {
+		return
+	}
+
+	names := make([]string, len(d.Namesakes))
+	for i, namesake := range d.Namesakes {
+		names[i] = namesake.Name
+		feed.Links = append(feed.Links, Link{
+			Rel:   RelRelated,
+			Type:  TypeAcquisition,
+			Href:  fmt.Sprintf("%s/authors/%d", b.catalogURL(""), namesake.ID),
+			Title: namesake.Name,
+		})
+	}
+
+	var notice []string
+	if d.MayBeMerged {
+		notice = append(notice, fmt.Sprintf("Под именем «%s» могут быть собраны книги разных авторов.", author.Name))
+		if d.Note != "" {
+			notice = append(notice, d.Note)
+		}
+	}
+	if len(names) > 0 {
+		notice = append(notice, "Другие авторы с этим именем: "+strings.Join(names, ", ")+".")
+	}
+	feed.Subtitle = strings.Join(notice, " ")
+}
+
+// imageMIMEType guesses an image MIME type from the URL extension
+func imageMIMEType(url string) string {
+	switch strings.ToLower(path.Ext(url)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".svg":
+		return "image/svg+xml"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
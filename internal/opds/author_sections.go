@@ -0,0 +1,254 @@
+package opds
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// authorSection is a sub-section of the feed of a prolific author
+type authorSection struct {
+	path    string
+	title   string
+	summary string
+	feed    string
+}
+
+// AuthorSeries serves the series of an author (navigation)
+func (h *Handler) AuthorSeries(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	series, err := h.repo.ListAuthorSeries(author.Name, scopeLanguage(r), hidden)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeFeed(w, r, h.builderFor(r).BuildAuthorSeriesFeed(author, series))
+}
+
+// AuthorBooksInSeries serves the books of an author in one series, in
+// series order
+func (h *Handler) AuthorBooksInSeries(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	seriesID, err := strconv.Atoi(chi.URLParam(r, "seriesID"))
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+	series, err := h.repo.GetSeriesByID(seriesID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if series == nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	h.serveAuthorBooks(w, r, author, fmt.Sprintf("/series/%d", series.ID),
+		fmt.Sprintf("%s — %s", author.Name, series.Name),
+		storage.BookFilter{Series: []string{series.Name}, SortBy: "series", SortOrder: "asc"})
+}
+
+// AuthorBooksAlphabetical serves the books of an author by title
+func (h *Handler) AuthorBooksAlphabetical(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	h.serveAuthorBooks(w, r, author, "/alphabet", fmt.Sprintf("%s — по алфавиту", author.Name),
+		storage.BookFilter{SortBy: "title", SortOrder: "asc"})
+}
+
+// AuthorBooksByDate serves the books of an author, newest additions first
+func (h *Handler) AuthorBooksByDate(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	h.serveAuthorBooks(w, r, author, "/new", fmt.Sprintf("%s — по дате добавления", author.Name),
+		storage.BookFilter{SortBy: "date_added", SortOrder: "desc"})
+}
+
+// AuthorBooksWithoutSeries serves the books of an author outside any
+// series, by title
+func (h *Handler) AuthorBooksWithoutSeries(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	h.serveAuthorBooks(w, r, author, "/noseries", fmt.Sprintf("%s — вне серий", author.Name),
+		storage.BookFilter{WithoutSeries: true, SortBy: "title", SortOrder: "asc"})
+}
+
+// serveAuthorBooks serves a page of the books of an author matching filter
+// as an acquisition feed at /authors/{id} followed by path
+func (h *Handler) serveAuthorBooks(w http.ResponseWriter, r *http.Request, author *storage.Author, path, title string, filter storage.BookFilter) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter.Authors = []string{author.Name}
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b := h.builderFor(r)
+	feedID := fmt.Sprintf("%s/authors/%d%s", b.catalogURL(""), author.ID, path)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := b.BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	b.addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// serveAuthorSections serves the navigation feed of a prolific author, who
+// has total books: by series, by title, by date added and outside series.
+// Sections without books are left out.
+func (h *Handler) serveAuthorSections(w http.ResponseWriter, r *http.Request, author *storage.Author, total int) {
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	series, err := h.repo.ListAuthorSeries(author.Name, scopeLanguage(r), hidden)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	withoutSeries, err := h.searchBooks(r, storage.BookFilter{Authors: []string{author.Name}, WithoutSeries: true, Limit: 1})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	disambiguation, err := h.repo.GetAuthorDisambiguation(author)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var sections []authorSection
+	if len(series) > 0 {
+		sections = append(sections, authorSection{
+			path: "/series", title: "По сериям", summary: fmt.Sprintf("Серий: %d", len(series)), feed: TypeNavigation,
+		})
+	}
+	sections = append(sections,
+		authorSection{path: "/alphabet", title: "По алфавиту", summary: bookCountSummary(total), feed: TypeAcquisition},
+		authorSection{path: "/new", title: "По дате добавления", summary: bookCountSummary(total), feed: TypeAcquisition},
+	)
+	if withoutSeries.Total > 0 {
+		sections = append(sections, authorSection{
+			path: "/noseries", title: "Вне серий", summary: bookCountSummary(withoutSeries.Total), feed: TypeAcquisition,
+		})
+	}
+
+	b := h.builderFor(r)
+	feed := b.BuildAuthorSectionsFeed(author, sections)
+	b.applyDisambiguation(feed, author, disambiguation)
+	h.writeFeed(w, r, feed)
+}
+
+// authorFromRequest looks up the author of the {id} URL parameter,
+// answering the request itself if there is none
+func (h *Handler) authorFromRequest(w http.ResponseWriter, r *http.Request) (*storage.Author, bool) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid author ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	author, err := h.repo.GetAuthorByID(authorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if author == nil {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return nil, false
+	}
+	return author, true
+}
+
+// BuildAuthorSectionsFeed creates the navigation feed of an author listing
+// the sections of their books
+func (b *Builder) BuildAuthorSectionsFeed(author *storage.Author, sections []authorSection) *Feed {
+	path := fmt.Sprintf("/authors/%d", author.ID)
+	feed, feedURL, _, now := b.newNavigationFeed(fmt.Sprintf("Книги автора %s", author.Name), path, 1, len(sections), len(sections))
+	b.setUpLink(feed, b.catalogURL("/authors"))
+
+	for _, section := range sections {
+		sectionURL := feedURL + section.path
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      sectionURL,
+			Title:   section.title,
+			Updated: now,
+			Summary: section.summary,
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  section.feed,
+					Href:  sectionURL,
+					Title: section.title,
+				},
+			},
+		})
+	}
+
+	return feed
+}
+
+// BuildAuthorSeriesFeed creates a navigation feed listing the series of an
+// author with the number of their books in each
+func (b *Builder) BuildAuthorSeriesFeed(author *storage.Author, series []storage.Series) *Feed {
+	path := fmt.Sprintf("/authors/%d/series", author.ID)
+	feed, feedURL, _, now := b.newNavigationFeed(fmt.Sprintf("%s — серии", author.Name), path, 1, len(series), len(series))
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
+	b.setUpLink(feed, b.catalogURL(fmt.Sprintf("/authors/%d", author.ID)))
+
+	for _, item := range series {
+		seriesURL := fmt.Sprintf("%s/%d", feedURL, item.ID)
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      seriesURL,
+			Title:   item.Name,
+			Updated: now,
+			Summary: bookCountSummary(item.BookCount),
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeAcquisition,
+					Href:  seriesURL,
+					Title: fmt.Sprintf("Книги серии %s", item.Name),
+					Count: item.BookCount,
+				},
+			},
+		})
+	}
+
+	return feed
+}
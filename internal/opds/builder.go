@@ -2,19 +2,40 @@ package opds
 
 import (
 	"fmt"
+	"html"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/metadata"
 	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
+// opdsThumbnailWidth is the width of the cover thumbnails linked from feeds
+const opdsThumbnailWidth = 150
+
 // Builder creates OPDS feeds
 type Builder struct {
 	baseURL      string
 	catalogTitle string
 	genreNames   map[string]string
+	// language scopes the catalog to books in one language; see forLanguage
+	language string
+	// conversions returns the formats a book format can be converted to on
+	// download; see Handler.SetConversions
+	conversions func(format string) []string
+	// preferredFormat is the download format listed first in acquisition
+	// links; see withPreferredFormat
+	preferredFormat string
+	// updated returns when the catalog last changed; see updatedAt
+	updated func() time.Time
+	// annotationLimit is the number of characters of an annotation kept in
+	// feed entries, 0 keeps all; see Handler.SetAnnotationLimit
+	annotationLimit int
 }
 
 // NewBuilder creates a new OPDS builder
@@ -26,16 +47,37 @@ func NewBuilder(baseURL, catalogTitle string, genreNames map[string]string) *Bui
 	}
 }
 
+// catalogURL returns the absolute URL of path within the catalog, which is
+// /opds or, for a language-scoped builder, /opds/lang/{language}
+func (b *Builder) catalogURL(path string) string {
+	if b.language != "" {
+		return b.baseURL + "/opds/lang/" + url.PathEscape(b.language) + path
+	}
+	return b.baseURL + "/opds" + path
+}
+
+// updatedAt returns when the catalog last changed, which is the updated
+// time of navigation feeds and their entries, or the current time if it is
+// not known
+func (b *Builder) updatedAt() time.Time {
+	if b.updated != nil {
+		if updated := b.updated(); !updated.IsZero() {
+			return updated
+		}
+	}
+	return time.Now()
+}
+
 // BuildRootFeed creates the root OPDS catalog
 func (b *Builder) BuildRootFeed() *Feed {
-	now := time.Now()
+	now := b.updatedAt()
 
 	feed := &Feed{
 		Xmlns:     "http://www.w3.org/2005/Atom",
 		XmlnsDC:   "http://purl.org/dc/terms/",
 		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
 
-		ID:      b.baseURL + "/opds",
+		ID:      b.catalogURL(""),
 		Title:   b.catalogTitle,
 		Updated: now,
 		Icon:    b.baseURL + "/favicon.ico",
@@ -49,23 +91,33 @@ func (b *Builder) BuildRootFeed() *Feed {
 			{
 				Rel:  "self",
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 			{
 				Rel:  RelStart,
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 			{
 				Rel:  RelSearch,
 				Type: TypeSearch,
-				Href: b.baseURL + "/opds/search?q={searchTerms}",
+				Href: b.catalogURL("/opensearch.xml"),
+			},
+			{
+				Rel:  RelSearch,
+				Type: TypeAcquisition,
+				Href: b.catalogURL("/search?q={searchTerms}"),
+			},
+			{
+				Rel:  RelCrawlable,
+				Type: TypeAcquisition,
+				Href: b.catalogURL("/all"),
 			},
 		},
 
 		Entries: []Entry{
 			{
-				ID:      b.baseURL + "/opds/books/new",
+				ID:      b.catalogURL("/books/new"),
 				Title:   "Новые поступления",
 				Updated: now,
 				Summary: "Недавно добавленные книги",
@@ -73,12 +125,12 @@ func (b *Builder) BuildRootFeed() *Feed {
 					{
 						Rel:  RelSubsection,
 						Type: TypeAcquisition,
-						Href: b.baseURL + "/opds/books/new",
+						Href: b.catalogURL("/books/new"),
 					},
 				},
 			},
 			{
-				ID:      b.baseURL + "/opds/authors",
+				ID:      b.catalogURL("/authors"),
 				Title:   "По авторам",
 				Updated: now,
 				Summary: "Каталог по авторам",
@@ -86,12 +138,25 @@ func (b *Builder) BuildRootFeed() *Feed {
 					{
 						Rel:  RelSubsection,
 						Type: TypeNavigation,
-						Href: b.baseURL + "/opds/authors",
+						Href: b.catalogURL("/authors"),
 					},
 				},
 			},
 			{
-				ID:      b.baseURL + "/opds/series",
+				ID:      b.catalogURL("/authors/top"),
+				Title:   topAuthorsTitle,
+				Updated: now,
+				Summary: "Авторы по числу книг в каталоге",
+				Links: []Link{
+					{
+						Rel:  RelSubsection,
+						Type: TypeNavigation,
+						Href: b.catalogURL("/authors/top"),
+					},
+				},
+			},
+			{
+				ID:      b.catalogURL("/series"),
 				Title:   "По сериям",
 				Updated: now,
 				Summary: "Каталог по сериям",
@@ -99,12 +164,25 @@ func (b *Builder) BuildRootFeed() *Feed {
 					{
 						Rel:  RelSubsection,
 						Type: TypeNavigation,
-						Href: b.baseURL + "/opds/series",
+						Href: b.catalogURL("/series"),
+					},
+				},
+			},
+			{
+				ID:      b.catalogURL("/series/first"),
+				Title:   "Начните серию",
+				Updated: now,
+				Summary: "Первые книги серий",
+				Links: []Link{
+					{
+						Rel:  RelSubsection,
+						Type: TypeAcquisition,
+						Href: b.catalogURL("/series/first"),
 					},
 				},
 			},
 			{
-				ID:      b.baseURL + "/opds/genres",
+				ID:      b.catalogURL("/genres"),
 				Title:   "По жанрам",
 				Updated: now,
 				Summary: "Каталог по жанрам",
@@ -112,7 +190,33 @@ func (b *Builder) BuildRootFeed() *Feed {
 					{
 						Rel:  RelSubsection,
 						Type: TypeNavigation,
-						Href: b.baseURL + "/opds/genres",
+						Href: b.catalogURL("/genres"),
+					},
+				},
+			},
+			{
+				ID:      b.catalogURL("/tags"),
+				Title:   "По тегам",
+				Updated: now,
+				Summary: "Подборки библиотекаря",
+				Links: []Link{
+					{
+						Rel:  RelSubsection,
+						Type: TypeNavigation,
+						Href: b.catalogURL("/tags"),
+					},
+				},
+			},
+			{
+				ID:      b.catalogURL("/years"),
+				Title:   "По десятилетиям",
+				Updated: now,
+				Summary: "Каталог по десятилетиям и годам издания",
+				Links: []Link{
+					{
+						Rel:  RelSubsection,
+						Type: TypeNavigation,
+						Href: b.catalogURL("/years"),
 					},
 				},
 			},
@@ -124,21 +228,34 @@ func (b *Builder) BuildRootFeed() *Feed {
 
 // BuildAuthorsFeed creates a navigation feed listing authors
 func (b *Builder) BuildAuthorsFeed(authors []storage.Author, page, totalAuthors, pageSize int) *Feed {
-	feed, _, _, now := b.newNavigationFeed("Авторы", "/opds/authors", page, totalAuthors, pageSize)
+	return b.buildAuthorList("Авторы", "/authors", authors, page, totalAuthors, pageSize)
+}
+
+// buildAuthorList creates a navigation feed at path listing authors with
+// their numbers of books
+func (b *Builder) buildAuthorList(title, path string, authors []storage.Author, page, totalAuthors, pageSize int) *Feed {
+	feed, _, _, now := b.newNavigationFeed(title, path, page, totalAuthors, pageSize)
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
 
 	for _, author := range authors {
-		authorURL := fmt.Sprintf("%s/opds/authors/%d", b.baseURL, author.ID)
+		authorURL := fmt.Sprintf("%s/authors/%d", b.catalogURL(""), author.ID)
+		// Prolific authors get a navigation feed of sections
+		linkType := TypeAcquisition
+		if author.BookCount > pageSize {
+			linkType = TypeNavigation
+		}
 		feed.Entries = append(feed.Entries, Entry{
 			ID:      authorURL,
 			Title:   author.Name,
 			Updated: now,
-			Summary: "Книги автора",
+			Summary: bookCountSummary(author.BookCount),
 			Links: []Link{
 				{
 					Rel:   RelSubsection,
-					Type:  TypeNavigation,
+					Type:  linkType,
 					Href:  authorURL,
 					Title: fmt.Sprintf("Книги автора %s", author.Name),
+					Count: author.BookCount,
 				},
 			},
 		})
@@ -149,21 +266,23 @@ func (b *Builder) BuildAuthorsFeed(authors []storage.Author, page, totalAuthors,
 
 // BuildSeriesFeed creates a navigation feed listing series
 func (b *Builder) BuildSeriesFeed(series []storage.Series, page, totalSeries, pageSize int) *Feed {
-	feed, _, _, now := b.newNavigationFeed("Серии", "/opds/series", page, totalSeries, pageSize)
+	feed, _, _, now := b.newNavigationFeed("Серии", "/series", page, totalSeries, pageSize)
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
 
 	for _, item := range series {
-		seriesURL := fmt.Sprintf("%s/opds/series/%d", b.baseURL, item.ID)
+		seriesURL := fmt.Sprintf("%s/series/%d", b.catalogURL(""), item.ID)
 		feed.Entries = append(feed.Entries, Entry{
 			ID:      seriesURL,
 			Title:   item.Name,
 			Updated: now,
-			Summary: "Книги серии",
+			Summary: bookCountSummary(item.BookCount),
 			Links: []Link{
 				{
 					Rel:   RelSubsection,
-					Type:  TypeNavigation,
+					Type:  TypeAcquisition,
 					Href:  seriesURL,
 					Title: fmt.Sprintf("Книги серии %s", item.Name),
+					Count: item.BookCount,
 				},
 			},
 		})
@@ -174,22 +293,24 @@ func (b *Builder) BuildSeriesFeed(series []storage.Series, page, totalSeries, pa
 
 // BuildGenresFeed creates a navigation feed listing genres
 func (b *Builder) BuildGenresFeed(genres []storage.Genre, page, totalGenres, pageSize int) *Feed {
-	feed, _, _, now := b.newNavigationFeed("Жанры", "/opds/genres", page, totalGenres, pageSize)
+	feed, _, _, now := b.newNavigationFeed("Жанры", "/genres", page, totalGenres, pageSize)
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
 
 	for _, item := range genres {
-		genreURL := fmt.Sprintf("%s/opds/genres/%d", b.baseURL, item.ID)
+		genreURL := fmt.Sprintf("%s/genres/%d", b.catalogURL(""), item.ID)
 		label := b.genreLabel(item.Name)
 		feed.Entries = append(feed.Entries, Entry{
 			ID:      genreURL,
 			Title:   label,
 			Updated: now,
-			Summary: fmt.Sprintf("Книги жанра %s", label),
+			Summary: bookCountSummary(item.BookCount),
 			Links: []Link{
 				{
 					Rel:   RelSubsection,
-					Type:  TypeNavigation,
+					Type:  TypeAcquisition,
 					Href:  genreURL,
 					Title: fmt.Sprintf("Книги жанра %s", label),
+					Count: item.BookCount,
 				},
 			},
 		})
@@ -198,6 +319,62 @@ func (b *Builder) BuildGenresFeed(genres []storage.Genre, page, totalGenres, pag
 	return feed
 }
 
+// bookCountSummary renders a number of books in Russian ("1 книга",
+// "3 книги", "25 книг")
+func bookCountSummary(n int) string {
+	word := "книг"
+	switch mod100 := n % 100; {
+	case mod100 >= 11 && mod100 <= 14:
+	case n%10 == 1:
+		word = "книга"
+	case n%10 >= 2 && n%10 <= 4:
+		word = "книги"
+	}
+	return fmt.Sprintf("%d %s", n, word)
+}
+
+// BuildTagsFeed creates a navigation feed listing tags
+func (b *Builder) BuildTagsFeed(tags []storage.Tag, page, totalTags, pageSize int) *Feed {
+	feed, _, _, now := b.newNavigationFeed("Теги", "/tags", page, totalTags, pageSize)
+
+	for _, tag := range tags {
+		tagURL := fmt.Sprintf("%s/tags/%d", b.catalogURL(""), tag.ID)
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      tagURL,
+			Title:   tag.Name,
+			Updated: now,
+			Summary: fmt.Sprintf("Книг: %d", tag.BookCount),
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeAcquisition,
+					Href:  tagURL,
+					Title: fmt.Sprintf("Книги с тегом %s", tag.Name),
+				},
+			},
+		})
+	}
+
+	return feed
+}
+
+// featuredRootEntry links the root catalog to the books picked by admins
+func (b *Builder) featuredRootEntry() Entry {
+	return Entry{
+		ID:      b.catalogURL("/featured"),
+		Title:   "Рекомендуем",
+		Updated: b.updatedAt(),
+		Summary: "Книги, выбранные библиотекарем",
+		Links: []Link{
+			{
+				Rel:  RelSubsection,
+				Type: TypeAcquisition,
+				Href: b.catalogURL("/featured"),
+			},
+		},
+	}
+}
+
 func (b *Builder) newNavigationFeed(title, path string, page, totalItems, pageSize int) (*Feed, string, int, time.Time) {
 	if page <= 0 {
 		page = 1
@@ -206,8 +383,8 @@ func (b *Builder) newNavigationFeed(title, path string, page, totalItems, pageSi
 		pageSize = 30
 	}
 
-	now := time.Now()
-	feedURL := b.baseURL + path
+	now := b.updatedAt()
+	feedURL := b.catalogURL(path)
 	feedID := feedURL
 	if page > 1 {
 		feedID = fmt.Sprintf("%s?page=%d", feedURL, page)
@@ -236,16 +413,18 @@ func (b *Builder) newNavigationFeed(title, path string, page, totalItems, pageSi
 			{
 				Rel:  RelStart,
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 			{
 				Rel:  RelUp,
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 		},
 	}
 
+	b.setPaging(feed, page, pageSize, totalItems)
+
 	if page > 1 {
 		prevURL := b.buildPageURL(feedURL, page-1)
 		feed.Links = append(feed.Links, Link{
@@ -267,19 +446,29 @@ func (b *Builder) newNavigationFeed(title, path string, page, totalItems, pageSi
 	return feed, feedURL, pageSize, now
 }
 
-// BuildBooksFeed creates a feed of books
-func (b *Builder) BuildBooksFeed(books []storage.Book, title, feedID string, page, totalBooks int) *Feed {
-	now := time.Now()
-	pageSize := len(books)
+// setPaging adds the OpenSearch totalResults, startIndex and itemsPerPage
+// elements, so that clients can show the position in a paginated feed
+func (b *Builder) setPaging(feed *Feed, page, pageSize, total int) {
+	if page <= 0 {
+		page = 1
+	}
+	feed.XmlnsOpenSearch = "http://a9.com/-/spec/opensearch/1.1/"
+	feed.OpenSearch = &OpenSearch{
+		TotalResults: total,
+		StartIndex:   (page-1)*pageSize + 1,
+		ItemsPerPage: pageSize,
+	}
+}
 
+// BuildBooksFeed creates a feed of books
+func (b *Builder) BuildBooksFeed(books []storage.Book, title, feedID string, page, pageSize, totalBooks int) *Feed {
 	feed := &Feed{
 		Xmlns:     "http://www.w3.org/2005/Atom",
 		XmlnsDC:   "http://purl.org/dc/terms/",
 		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
 
-		ID:      feedID,
-		Title:   title,
-		Updated: now,
+		ID:    feedID,
+		Title: title,
 
 		Author: &Person{
 			Name: b.catalogTitle,
@@ -295,16 +484,18 @@ func (b *Builder) BuildBooksFeed(books []storage.Book, title, feedID string, pag
 			{
 				Rel:  RelStart,
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 			{
 				Rel:  RelUp,
 				Type: TypeNavigation,
-				Href: b.baseURL + "/opds",
+				Href: b.catalogURL(""),
 			},
 		},
 	}
 
+	b.setPaging(feed, page, pageSize, totalBooks)
+
 	// Add pagination links if needed
 	if page > 1 {
 		prevURL := b.buildPageURL(feedID, page-1)
@@ -324,22 +515,117 @@ func (b *Builder) BuildBooksFeed(books []storage.Book, title, feedID string, pag
 		})
 	}
 
-	// Convert books to entries
+	// Convert books to entries; the feed is as recent as its latest entry
 	for _, book := range books {
 		entry := b.bookToEntry(book)
 		feed.Entries = append(feed.Entries, entry)
+		if entry.Updated.After(feed.Updated) {
+			feed.Updated = entry.Updated
+		}
+	}
+	if feed.Updated.IsZero() {
+		feed.Updated = b.updatedAt()
 	}
 
 	return feed
 }
 
-// bookToEntry converts a storage.Book to OPDS Entry
+// OPDS image relations, used for book covers and author portraits
+const (
+	RelImage     = "http://opds-spec.org/image"
+	RelThumbnail = "http://opds-spec.org/image/thumbnail"
+)
+
+// BuildBookEntry creates the complete catalog entry of a book: the whole
+// annotation, every link and the feeds of its authors, series, genre and
+// tags
+func (b *Builder) BuildBookEntry(book storage.Book) *EntryDocument {
+	entry := b.bookEntry(book, 0)
+	entry.Links = append(entry.Links, Link{
+		Rel:  "self",
+		Type: TypeEntry,
+		Href: b.bookEntryURL(book.ID),
+	})
+	entry.Links = append(entry.Links, b.relatedLinks(book)...)
+
+	return &EntryDocument{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+
+		Entry: entry,
+	}
+}
+
+// bookEntryURL returns the URL of the complete catalog entry of a book
+func (b *Builder) bookEntryURL(id string) string {
+	return b.catalogURL("/books/" + url.PathEscape(id))
+}
+
+// relatedLinks links a book to the feeds of its authors, series, genres and tags
+func (b *Builder) relatedLinks(book storage.Book) []Link {
+	var links []Link
+	related := func(path, title string) {
+		links = append(links, Link{
+			Rel:   RelRelated,
+			Type:  TypeAcquisition,
+			Href:  b.catalogURL(path),
+			Title: title,
+		})
+	}
+	for _, author := range book.Authors {
+		related(fmt.Sprintf("/authors/%d", author.ID), "Все книги автора "+author.Name)
+	}
+	if book.Series != nil {
+		related(fmt.Sprintf("/series/%d", book.Series.ID), "Все книги серии "+book.Series.Name)
+	}
+	for _, series := range book.OtherSeries {
+		related(fmt.Sprintf("/series/%d", series.ID), "Все книги серии "+series.Name)
+	}
+	for _, genre := range bookGenres(book) {
+		related(fmt.Sprintf("/genres/%d", genre.ID), "Жанр: "+b.genreLabel(genre.Name))
+	}
+	for _, tag := range book.Tags {
+		related(fmt.Sprintf("/tags/%d", tag.ID), "Книги с тегом "+tag.Name)
+	}
+	return links
+}
+
+// bookToEntry converts a storage.Book to OPDS Entry of a feed
 func (b *Builder) bookToEntry(book storage.Book) Entry {
+	entry := b.bookEntry(book, b.annotationLimit)
+	entry.Links = append(entry.Links, Link{
+		Rel:   "alternate",
+		Type:  TypeEntry,
+		Href:  b.bookEntryURL(book.ID),
+		Title: "Полное описание",
+	})
+	return entry
+}
+
+// bookEntry converts a storage.Book to OPDS Entry, keeping up to limit
+// characters of the annotation
+func (b *Builder) bookEntry(book storage.Book, limit int) Entry {
+	summary := metadata.AnnotationText(book.Annotation)
+	annotation := metadata.AnnotationXHTML(book.Annotation)
+	shortened, truncated := truncateText(summary, limit)
+	if truncated {
+		summary = shortened
+		annotation = metadata.AnnotationXHTML(html.EscapeString(shortened))
+	}
+
 	entry := Entry{
 		ID:      b.baseURL + "/opds/books/" + book.ID,
 		Title:   book.Title,
 		Updated: book.UpdatedAt,
-		Summary: book.Annotation,
+		Summary: summary,
+	}
+	if entry.Updated.IsZero() {
+		entry.Updated = book.DateAdded
+	}
+	if !book.DateAdded.IsZero() {
+		published := book.DateAdded
+		entry.Published = &published
 	}
 
 	// Add authors
@@ -349,13 +635,14 @@ func (b *Builder) bookToEntry(book storage.Book) Entry {
 		})
 	}
 
-	// Add genre
-	var genreLabel string
-	if book.Genre != nil {
-		genreLabel = b.genreLabel(book.Genre.Name)
+	// Add genres
+	var genreLabels []string
+	for _, genre := range bookGenres(book) {
+		label := b.genreLabel(genre.Name)
+		genreLabels = append(genreLabels, label)
 		entry.Categories = append(entry.Categories, Category{
-			Term:  book.Genre.Name,
-			Label: genreLabel,
+			Term:  genre.Name,
+			Label: label,
 		})
 	}
 
@@ -369,21 +656,38 @@ func (b *Builder) bookToEntry(book storage.Book) Entry {
 		entry.Issued = strconv.Itoa(book.Year)
 	}
 
-	// Add acquisition link
-	downloadURL := b.baseURL + "/download/" + book.ID
-	fileType := b.getFileType(book.Format)
+	// Checksum lets mirrors match files across servers
+	if book.SHA256 != "" {
+		entry.Identifier = "urn:sha256:" + book.SHA256
+	}
 
-	entry.Links = append(entry.Links, Link{
-		Rel:    RelAcquisitionOpen,
-		Type:   fileType,
-		Href:   downloadURL,
-		Length: book.FileSize,
-	})
+	// Add acquisition links
+	for _, acq := range b.acquisitions(book) {
+		entry.Links = append(entry.Links, Link{
+			Rel:    RelAcquisitionOpen,
+			Type:   acq.Type,
+			Href:   acq.Href,
+			Length: acq.Length,
+		})
+	}
+
+	// Add cover links once the background job has extracted a thumbnail
+	if book.HasCover {
+		coverURL := covers.URL(b.baseURL, book.ID, book.CoverHash)
+		thumbnailURL := coverURL
+		if book.CoverHash != "" {
+			thumbnailURL += "?w=" + strconv.Itoa(opdsThumbnailWidth)
+		}
+		entry.Links = append(entry.Links,
+			Link{Rel: RelImage, Type: "image/jpeg", Href: coverURL},
+			Link{Rel: RelThumbnail, Type: "image/jpeg", Href: thumbnailURL},
+		)
+	}
 
 	// Add content with details
 	var details []string
-	if genreLabel != "" {
-		details = append(details, "Жанр: "+genreLabel)
+	if len(genreLabels) > 0 {
+		details = append(details, "Жанр: "+strings.Join(genreLabels, ", "))
 	}
 
 	if book.Series != nil {
@@ -393,6 +697,13 @@ func (b *Builder) bookToEntry(book storage.Book) Entry {
 		}
 		details = append(details, "Серия: "+seriesInfo)
 	}
+	for _, series := range book.OtherSeries {
+		seriesInfo := series.Name
+		if series.Num > 0 {
+			seriesInfo += fmt.Sprintf(" #%d", series.Num)
+		}
+		details = append(details, "Также в серии: "+seriesInfo)
+	}
 
 	if book.Year > 0 {
 		details = append(details, "Год: "+strconv.Itoa(book.Year))
@@ -406,30 +717,97 @@ func (b *Builder) bookToEntry(book storage.Book) Entry {
 		details = append(details, "Размер: "+b.formatFileSize(book.FileSize))
 	}
 
-	if len(details) > 0 {
-		content := strings.Join(details, "\n")
-		if book.Annotation != "" {
-			content = book.Annotation + "\n\n" + content
-		}
-
+	if len(details) > 0 || book.Annotation != "" {
 		entry.Content = &Content{
-			Type: "text",
-			Text: content,
+			Type:  "xhtml",
+			XHTML: entryXHTML(annotation, details),
 		}
 	}
 
 	return entry
 }
 
+// truncateText shortens text to limit characters, the last of them an
+// ellipsis, and reports whether it did. A limit of 0 keeps any text.
+func truncateText(text string, limit int) (string, bool) {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return text, false
+	}
+	cut := 0
+	for i := 0; i < limit-1; i++ {
+		_, size := utf8.DecodeRuneInString(text[cut:])
+		cut += size
+	}
+	return strings.TrimRightFunc(text[:cut], unicode.IsSpace) + "…", true
+}
+
+// entryXHTML renders the content of a book entry: the annotation
+// paragraphs followed by a paragraph of details, one per line
+func entryXHTML(annotation string, details []string) string {
+	var sb strings.Builder
+	sb.WriteString(`<div xmlns="http://www.w3.org/1999/xhtml">`)
+	sb.WriteString(annotation)
+	if len(details) > 0 {
+		escaped := make([]string, len(details))
+		for i, detail := range details {
+			escaped[i] = html.EscapeString(detail)
+		}
+		sb.WriteString("<p>" + strings.Join(escaped, "<br/>") + "</p>")
+	}
+	sb.WriteString("</div>")
+	return sb.String()
+}
+
+// acquisition is a download of a book in one packaging
+type acquisition struct {
+	Href   string
+	Type   string
+	Length int64
+	// Format is the format downloaded, as in "epub" or "fb2.zip"
+	Format string
+}
+
+// acquisitions returns the downloads of a book: the file as stored, for
+// FB2 the same file zipped on the fly (.fb2.zip), and the formats the file
+// is converted to on download. The preferred format, if any, comes first.
+func (b *Builder) acquisitions(book storage.Book) []acquisition {
+	downloadURL := b.baseURL + "/download/" + book.ID
+	format := strings.ToLower(book.Format)
+	if format == "" {
+		format = "fb2"
+	}
+	acqs := []acquisition{{Href: downloadURL, Type: b.getFileType(book.Format), Length: book.FileSize, Format: format}}
+	if format == "fb2" {
+		acqs = append(acqs, acquisition{Href: downloadURL + "?packaging=zip", Type: TypeFB2, Format: "fb2.zip"})
+	}
+	if b.conversions != nil {
+		for _, target := range b.conversions(format) {
+			acqs = append(acqs, acquisition{Href: downloadURL + "?format=" + url.QueryEscape(target), Type: b.getFileType(target), Format: target})
+		}
+	}
+	for i, acq := range acqs {
+		if i > 0 && acq.Format == b.preferredFormat {
+			copy(acqs[1:i+1], acqs[:i])
+			acqs[0] = acq
+			break
+		}
+	}
+	return acqs
+}
+
 // getFileType returns MIME type for file format
 func (b *Builder) getFileType(format string) string {
 	switch strings.ToLower(format) {
 	case "fb2":
-		return TypeFB2
+		return TypeFB2XML
 	case "epub":
 		return TypeEPUB
 	case "pdf":
 		return TypePDF
+	case "mobi":
+		return "application/x-mobipocket-ebook"
+	case "azw3":
+		return "application/vnd.amazon.ebook"
 	default:
 		return "application/octet-stream"
 	}
@@ -442,8 +820,13 @@ func (b *Builder) buildPageURL(baseURL string, page int) string {
 		return baseURL
 	}
 
+	// The first page has no page parameter, so links back to it match its self link
 	q := u.Query()
-	q.Set("page", strconv.Itoa(page))
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	} else {
+		q.Del("page")
+	}
 	u.RawQuery = q.Encode()
 
 	return u.String()
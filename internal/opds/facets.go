@@ -0,0 +1,243 @@
+package opds
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// defaultPageSize is the number of entries per feed page unless set with
+// SetPageSize
+const defaultPageSize = 30
+
+// searchParams are the search inputs shared by the Atom and OPDS 2.0 feeds
+type searchParams struct {
+	Query    string
+	Format   string
+	Language string
+	// Sort is one of feedOrders, or "" for relevance
+	Sort     string
+	Page     int
+	PageSize int
+}
+
+// facetGroup is one facet dimension (format, language, order) of a search
+// feed
+type facetGroup struct {
+	Title   string
+	Param   string
+	Options []facetOption
+}
+
+// facetOption is a selectable facet value; an empty Value clears the facet
+type facetOption struct {
+	Title  string
+	Value  string
+	Count  int
+	Active bool
+}
+
+// parseSearchParams reads search parameters; queryParam is "q" for Atom
+// feeds and "query" for OPDS 2.0.
+func (h *Handler) parseSearchParams(r *http.Request, queryParam string) searchParams {
+	q := r.URL.Query()
+	return searchParams{
+		Query:    strings.TrimSpace(q.Get(queryParam)),
+		Format:   strings.ToLower(strings.TrimSpace(q.Get("format"))),
+		Language: strings.TrimSpace(q.Get("language")),
+		Sort:     requestedOrder(r),
+		Page:     h.getPageFromQuery(r),
+		PageSize: h.pageSize(),
+	}
+}
+
+func (p searchParams) filter() storage.BookFilter {
+	filter := storage.BookFilter{
+		Query:     p.Query,
+		Limit:     p.PageSize,
+		Offset:    (p.Page - 1) * p.PageSize,
+		SortBy:    "relevance",
+		SortOrder: "asc",
+
+		WithAnnotations: true,
+	}
+	if p.Format != "" {
+		filter.Formats = []string{p.Format}
+	}
+	if p.Language != "" {
+		filter.Languages = []string{p.Language}
+	}
+	applyOrder(&filter, p.Sort)
+	return filter
+}
+
+// search runs a feed search and counts its facets. Unusable queries yield
+// an empty result, since readers show feeds better than errors. Requests
+// scoped to a language search only its books.
+func (h *Handler) search(r *http.Request, p searchParams) (*storage.BookList, []facetGroup, error) {
+	filter := p.filter()
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	filter.Hidden = hidden
+	language := scopeLanguage(r)
+	if language != "" {
+		filter.Languages = []string{language}
+	}
+
+	result, err := h.repo.SearchBooks(filter)
+	if errors.Is(err, storage.ErrInvalidQuery) {
+		return &storage.BookList{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// Further pages of a search are not searches of their own
+	if p.Page <= 1 {
+		h.logSearch(r, p.Query, result)
+	}
+	// Facets count the books shown, those of the relaxed query
+	if result.Fallback {
+		filter.Query = result.FallbackQuery
+	}
+
+	formats, err := h.repo.CountBookFacet(filter, "format")
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := []facetGroup{newFacetGroup("Формат", "format", p.Format, formats, strings.ToUpper)}
+	// A language catalog has nothing to choose from
+	if language == "" {
+		languages, err := h.repo.CountBookFacet(filter, "language")
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, newFacetGroup("Язык", "language", p.Language, languages, func(v string) string { return v }))
+	}
+	groups = append(groups, orderFacetGroup(p.Sort, "По релевантности"))
+	return result, groups, nil
+}
+
+// searchTitle is the title of a search feed, telling the reader when the
+// books are those of a relaxed query because the query found nothing
+func searchTitle(p searchParams, result *storage.BookList, empty string) string {
+	switch {
+	case result.Fallback:
+		return fmt.Sprintf("Ничего не найдено по запросу «%s». Возможно, вы искали: %s", p.Query, result.FallbackQuery)
+	case p.Query != "":
+		return fmt.Sprintf("Поиск: %s", p.Query)
+	default:
+		return empty
+	}
+}
+
+func newFacetGroup(title, param, active string, counts []storage.FacetCount, label func(string) string) facetGroup {
+	group := facetGroup{
+		Title:   title,
+		Param:   param,
+		Options: []facetOption{{Title: "Все", Active: active == ""}},
+	}
+	for _, fc := range counts {
+		group.Options = append(group.Options, facetOption{
+			Title:  label(fc.Value),
+			Value:  fc.Value,
+			Count:  fc.Count,
+			Active: fc.Value == active,
+		})
+	}
+	return group
+}
+
+// with returns a copy of p with a facet parameter replaced and paging reset
+func (p searchParams) with(param, value string) searchParams {
+	switch param {
+	case "format":
+		p.Format = value
+	case "language":
+		p.Language = value
+	case "sort":
+		p.Sort = value
+	}
+	p.Page = 1
+	return p
+}
+
+// searchURL builds an absolute search URL for path, relative to the catalog,
+// with the given params
+func (b *Builder) searchURL(path, queryParam string, p searchParams) string {
+	values := url.Values{}
+	if p.Query != "" {
+		values.Set(queryParam, p.Query)
+	}
+	if p.Format != "" {
+		values.Set("format", p.Format)
+	}
+	if p.Language != "" {
+		values.Set("language", p.Language)
+	}
+	if p.Sort != "" {
+		values.Set("sort", p.Sort)
+	}
+	if p.Page > 1 {
+		values.Set("page", strconv.Itoa(p.Page))
+	}
+
+	u := b.catalogURL(path)
+	if encoded := values.Encode(); encoded != "" {
+		u += "?" + encoded
+	}
+	return u
+}
+
+// addFacetLinks adds OPDS 1.2 facet links to an Atom search feed
+func (b *Builder) addFacetLinks(feed *Feed, p searchParams, groups []facetGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
+
+	for _, group := range groups {
+		for _, option := range group.Options {
+			feed.Links = append(feed.Links, Link{
+				Rel:         RelFacet,
+				Type:        TypeAcquisition,
+				Href:        b.searchURL("/search", "q", p.with(group.Param, option.Value)),
+				Title:       option.Title,
+				FacetGroup:  group.Title,
+				ActiveFacet: option.Active,
+				Count:       option.Count,
+			})
+		}
+	}
+}
+
+// addSuggestionEntries adds "did you mean" entries linking to searches for
+// corrected queries. Facet filters are kept, paging is reset.
+func (b *Builder) addSuggestionEntries(feed *Feed, p searchParams, suggestions []string) {
+	now := b.updatedAt()
+	for _, suggestion := range suggestions {
+		corrected := p
+		corrected.Query, corrected.Page = suggestion, 1
+		href := b.searchURL("/search", "q", corrected)
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      href,
+			Title:   "Возможно, вы имели в виду: " + suggestion,
+			Updated: now,
+			Summary: "Ничего не найдено по запросу «" + p.Query + "»",
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeAcquisition,
+					Href:  href,
+					Title: suggestion,
+				},
+			},
+		})
+	}
+}
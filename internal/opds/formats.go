@@ -0,0 +1,47 @@
+package opds
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/auth"
+)
+
+type preferredFormatKey struct{}
+
+// FormatPreference is a middleware that looks up the download format the
+// reader prefers, set per user or per API token, for the acquisition links
+// of the feeds below it. Without auth the preference is that of the
+// instance, stored for the empty user ID.
+func (h *Handler) FormatPreference(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID := ""
+		if apiToken := auth.TokenFromContext(r.Context()); apiToken != nil {
+			tokenID = apiToken.ID
+		}
+		pref, err := h.repo.GetFormatPreference(auth.UserIDFromContext(r.Context()), tokenID)
+		if err != nil {
+			log.Printf("FormatPreference: %v", err)
+		}
+		if format := pref.Format(); format != "" {
+			r = r.WithContext(context.WithValue(r.Context(), preferredFormatKey{}, format))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preferredFormat returns the download format the reader of the request
+// prefers, if any
+func preferredFormat(r *http.Request) string {
+	format, _ := r.Context().Value(preferredFormatKey{}).(string)
+	return format
+}
+
+// withPreferredFormat returns a copy of the builder that lists downloads in
+// format first
+func (b *Builder) withPreferredFormat(format string) *Builder {
+	scoped := *b
+	scoped.preferredFormat = format
+	return &scoped
+}
@@ -1,94 +1,21 @@
 package opds
 
 import (
-	"bufio"
-	"encoding/csv"
-	"io"
-	"os"
 	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/genres"
+	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
-// LoadGenreNames loads genre code translations from CSV file.
-// The CSV is expected to have headers with at least "code" and "name_ru" columns.
-// Returns a map of lowercased genre codes to localized names.
+// LoadGenreNames returns the Russian names of the built-in FB2 genres,
+// overridden by the genre CSV at path, keyed by lowercase genre code; see
+// genres.Load.
 func LoadGenreNames(path string) (map[string]string, error) {
-	genres := make(map[string]string)
-	if strings.TrimSpace(path) == "" {
-		return genres, nil
-	}
-
-	file, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return genres, nil
-		}
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(bufio.NewReader(file))
-	reader.FieldsPerRecord = -1
-
-	headers, err := reader.Read()
+	list, err := genres.Load(path)
 	if err != nil {
-		if err == io.EOF {
-			return genres, nil
-		}
 		return nil, err
 	}
-
-	for i := range headers {
-		headers[i] = strings.TrimSpace(strings.ToLower(headers[i]))
-	}
-
-	codeIndex := indexOf(headers, "code")
-	nameIndex := indexOf(headers, "name_ru")
-	if nameIndex == -1 {
-		nameIndex = indexOf(headers, "name")
-	}
-
-	if codeIndex == -1 || nameIndex == -1 {
-		return genres, nil
-	}
-
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		if len(record) <= codeIndex {
-			continue
-		}
-
-		code := strings.TrimSpace(strings.ToLower(record[codeIndex]))
-		if code == "" {
-			continue
-		}
-
-		name := code
-		if len(record) > nameIndex {
-			value := strings.TrimSpace(record[nameIndex])
-			if value != "" {
-				name = value
-			}
-		}
-
-		genres[code] = name
-	}
-
-	return genres, nil
-}
-
-func indexOf(slice []string, target string) int {
-	for i, v := range slice {
-		if v == target {
-			return i
-		}
-	}
-	return -1
+	return genres.Names(list), nil
 }
 
 // genreLabel returns a human-friendly label for a genre code.
@@ -130,6 +57,18 @@ func (b *Builder) genreLabel(code string) string {
 	return strings.Join(labels, ", ")
 }
 
+// bookGenres returns all genres of a book, or its main genre for books
+// loaded without the others
+func bookGenres(book storage.Book) []storage.Genre {
+	if len(book.Genres) > 0 {
+		return book.Genres
+	}
+	if book.Genre != nil {
+		return []storage.Genre{*book.Genre}
+	}
+	return nil
+}
+
 func splitGenreCodes(code string) []string {
 	return strings.FieldsFunc(code, func(r rune) bool {
 		switch r {
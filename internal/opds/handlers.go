@@ -5,19 +5,32 @@ import (
 	"encoding/xml"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/sections"
 	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
 // Handler handles OPDS requests
 type Handler struct {
-	repo    *storage.Repository
-	builder *Builder
+	repo        *storage.Repository
+	feeds       atomic.Pointer[Builder]
+	authEnabled bool
+	authorInfo  AuthorInfoProvider
+	upstreams   UpstreamCatalogs
+	sections    []sections.Section
+
+	opds2Enabled     bool
+	languagesEnabled bool
+	feedPageSize     atomic.Int64
 }
 
 // NewHandler creates a new OPDS handler
@@ -25,22 +38,245 @@ func NewHandler(repo *storage.Repository, baseURL, catalogTitle string, genreNam
 	if genreNames == nil {
 		genreNames = map[string]string{}
 	}
-	return &Handler{
-		repo:    repo,
-		builder: NewBuilder(baseURL, catalogTitle, genreNames),
+	h := &Handler{repo: repo}
+	b := NewBuilder(baseURL, catalogTitle, genreNames)
+	b.updated = h.catalogUpdated
+	h.feeds.Store(b)
+	h.feedPageSize.Store(defaultPageSize)
+	return h
+}
+
+// SetBaseURL changes the public URL used in feed links. It is safe to call
+// while requests are served.
+func (h *Handler) SetBaseURL(baseURL string) {
+	b := *h.builder()
+	b.baseURL = strings.TrimSuffix(baseURL, "/")
+	h.feeds.Store(&b)
+}
+
+// SetConversions makes book entries offer downloads in the formats targets
+// returns for the stored format, converted by /download/{id}?format=. It
+// must be called before requests are served.
+func (h *Handler) SetConversions(targets func(format string) []string) {
+	b := *h.builder()
+	b.conversions = targets
+	h.feeds.Store(&b)
+}
+
+// SetAnnotationLimit sets the number of characters of an annotation shown
+// in feed entries. Longer annotations end with an ellipsis, and the entry
+// links to the complete one; 0 shows annotations in full. It is safe to
+// call while requests are served.
+func (h *Handler) SetAnnotationLimit(limit int) {
+	b := *h.builder()
+	b.annotationLimit = max(limit, 0)
+	h.feeds.Store(&b)
+}
+
+// BaseURL returns the public URL used in feed links
+func (h *Handler) BaseURL() string {
+	return h.builder().baseURL
+}
+
+// SetPageSize sets the number of entries per feed page; values below 1
+// restore the default. It is safe to call while requests are served.
+func (h *Handler) SetPageSize(size int) {
+	if size < 1 {
+		size = defaultPageSize
+	}
+	h.feedPageSize.Store(int64(size))
+}
+
+// catalogUpdated returns when the catalog last changed, or zero if unknown
+func (h *Handler) catalogUpdated() time.Time {
+	if h.repo == nil {
+		return time.Time{}
+	}
+	updated, err := h.repo.CatalogUpdatedAt()
+	if err != nil {
+		log.Printf("catalogUpdated: %v", err)
+	}
+	return updated
+}
+
+// builder returns the feed builder for the current base URL
+func (h *Handler) builder() *Builder {
+	return h.feeds.Load()
+}
+
+func (h *Handler) pageSize() int {
+	return int(h.feedPageSize.Load())
+}
+
+// restrictions returns the genres and tags hidden from the reader, who is
+// anonymous unless the catalog requires Basic Auth
+func (h *Handler) restrictions(r *http.Request) (*storage.Restrictions, error) {
+	return h.repo.RestrictionsFor(auth.UserFromContext(r.Context()))
+}
+
+// logSearch adds a search to the search log when it is enabled. Searchers
+// are told apart by user, or by address if anonymous. A query that only a
+// relaxed retry found books for is logged as finding nothing.
+func (h *Handler) logSearch(r *http.Request, query string, result *storage.BookList) {
+	results := result.Total
+	if result.Fallback {
+		results = 0
+	}
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		client = "user:" + user.ID
+	}
+	if err := h.repo.LogSearch(query, results, client); err != nil {
+		log.Printf("Search log: %v", err)
 	}
 }
 
+// searchBooks runs a search that leaves out books hidden from the reader
+// and books outside the language the request is scoped to. Entries show
+// the annotations, so they are loaded.
+func (h *Handler) searchBooks(r *http.Request, filter storage.BookFilter) (*storage.BookList, error) {
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		return nil, err
+	}
+	filter.Hidden = hidden
+	filter.WithAnnotations = true
+	if language := scopeLanguage(r); language != "" {
+		filter.Languages = []string{language}
+	}
+	return h.repo.SearchBooks(filter)
+}
+
 // Root serves the root OPDS catalog
 func (h *Handler) Root(w http.ResponseWriter, r *http.Request) {
-	feed := h.builder.BuildRootFeed()
-	h.writeFeed(w, feed)
+	b := h.builderFor(r)
+	feed := b.BuildRootFeed()
+	feed.Entries = append(feed.Entries, b.sectionRootEntries(h.sections)...)
+	searches, err := h.repo.ListSavedSearches(auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(searches) > 0 {
+		feed.Entries = append(feed.Entries, b.savedSearchesRootEntry(len(searches)))
+	}
+	switch {
+	case b.language != "":
+		b.scopeRootFeed(feed)
+	case h.languagesEnabled:
+		hidden, err := h.restrictions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		languages, err := h.repo.CountBookFacet(storage.BookFilter{Hidden: hidden}, "language")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b.addLanguageEntries(feed, languages)
+	}
+	if h.upstreams != nil && b.language == "" {
+		feed.Entries = append(feed.Entries, b.upstreamsRootEntry())
+	}
+
+	// The curated section heads the catalog when the reader can see any of it
+	featured, err := h.searchBooks(r, storage.BookFilter{Featured: true, Limit: 1})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if featured.Total > 0 {
+		feed.Entries = append([]Entry{b.featuredRootEntry()}, feed.Entries...)
+	}
+	h.writeFeed(w, r, feed)
+}
+
+// FeaturedBooks serves the books picked by admins, in their order
+func (h *Handler) FeaturedBooks(w http.ResponseWriter, r *http.Request) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		Featured: true,
+		Limit:    pageSize,
+		Offset:   (page - 1) * pageSize,
+		SortBy:   "featured",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feedID := h.builderFor(r).catalogURL("/featured")
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, "Рекомендуем", feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// ShelfBooks serves a shared shelf, in its order. The token query
+// parameter stands in for credentials; see Repository.ShelfToken.
+func (h *Handler) ShelfBooks(w http.ResponseWriter, r *http.Request) {
+	shelfID := chi.URLParam(r, "id")
+	token := r.URL.Query().Get("token")
+	if !h.repo.ValidShelfToken(shelfID, token) {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+	shelf, err := h.repo.GetShelf(shelfID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if shelf == nil {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		Shelf:  shelf.ID,
+		Limit:  pageSize,
+		Offset: (page - 1) * pageSize,
+		SortBy: "shelf",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b := h.builder()
+	shelfURL := b.catalogURL("/shelves/"+url.PathEscape(shelf.ID)) + "?token=" + url.QueryEscape(token)
+	feed := b.BuildBooksFeed(result.Books, shelf.Name, b.buildPageURL(shelfURL, page), page, pageSize, result.Total)
+	b.addOrderLinks(feed, order)
+	// A revoked token must stop working at once, so caches keep nothing
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeFeed(w, r, feed)
 }
 
 // NewBooks serves newest books
 func (h *Handler) NewBooks(w http.ResponseWriter, r *http.Request) {
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 
 	filter := storage.BookFilter{
 		Limit:     pageSize,
@@ -49,137 +285,179 @@ func (h *Handler) NewBooks(w http.ResponseWriter, r *http.Request) {
 		SortOrder: "desc",
 	}
 
-	result, err := h.repo.SearchBooks(filter)
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	feedID := h.builder.baseURL + "/opds/books/new"
+	feedID := h.builderFor(r).catalogURL("/books/new")
 	if page > 1 {
 		feedID += "?page=" + strconv.Itoa(page)
 	}
 
-	feed := h.builder.BuildBooksFeed(result.Books, "Новые поступления", feedID, page, result.Total)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, "Новые поступления", feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
 }
 
-// SearchBooks handles OPDS search
-func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
+// Page sizes of the complete acquisition feed
+const (
+	crawlPageSize    = 500
+	maxCrawlPageSize = 1000
+)
+
+// AllBooks serves the complete acquisition feed (rel="crawlable"): every
+// book the reader may see, ordered by ID so that harvesters enumerate the
+// catalog page by page without gaps or repeats. page_size sets the size of
+// its pages, up to 1000.
+func (h *Handler) AllBooks(w http.ResponseWriter, r *http.Request) {
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := crawlPageSize
+	if size, err := strconv.Atoi(r.URL.Query().Get("page_size")); err == nil && size > 0 {
+		pageSize = min(size, maxCrawlPageSize)
+	}
 
 	filter := storage.BookFilter{
-		Query:     query,
 		Limit:     pageSize,
 		Offset:    (page - 1) * pageSize,
-		SortBy:    "relevance",
+		SortBy:    "id",
 		SortOrder: "asc",
 	}
 
-	result, err := h.repo.SearchBooks(filter)
+	result, err := h.searchBooks(r, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	title := "Результаты поиска"
-	if query != "" {
-		title = fmt.Sprintf("Поиск: %s", query)
+	feedID := h.builderFor(r).catalogURL("/all")
+	if pageSize != crawlPageSize {
+		feedID += "?page_size=" + strconv.Itoa(pageSize)
 	}
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, "Все книги", h.builderFor(r).buildPageURL(feedID, page), page, pageSize, result.Total)
+	h.writeFeed(w, r, feed)
+}
 
-	feedID := h.builder.baseURL + "/opds/search"
-	if query != "" {
-		feedID += "?q=" + query
-	}
-	if page > 1 {
-		separator := "?"
-		if query != "" {
-			separator = "&"
-		}
-		feedID += separator + "page=" + strconv.Itoa(page)
+// SearchBooks handles OPDS search with format and language facets
+func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	params := h.parseSearchParams(r, "q")
+
+	result, facets, err := h.search(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	feed := h.builder.BuildBooksFeed(result.Books, title, feedID, page, result.Total)
-	h.writeFeed(w, feed)
+	title := searchTitle(params, result, "Результаты поиска")
+	feedID := h.builderFor(r).searchURL("/search", "q", params)
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, params.Page, params.PageSize, result.Total)
+	h.builderFor(r).addFacetLinks(feed, params, facets)
+	h.builderFor(r).addSuggestionEntries(feed, params, result.Suggestions)
+	h.addUpstreamMatches(feed, params)
+	h.writeFeed(w, r, feed)
 }
 
 // Authors serves authors catalog (navigation)
 func (h *Handler) Authors(w http.ResponseWriter, r *http.Request) {
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 	if page < 1 {
 		page = 1
 	}
 
-	authors, total, err := h.repo.ListAuthors(pageSize, (page-1)*pageSize)
+	authors, total, err := h.repo.ListAuthorsInLanguage(scopeLanguage(r), pageSize, (page-1)*pageSize)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	feed := h.builder.BuildAuthorsFeed(authors, page, total, pageSize)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildAuthorsFeed(authors, page, total, pageSize)
+	if h.authorInfo != nil {
+		for i, author := range authors {
+			h.builderFor(r).applyAuthorInfo(&feed.Entries[i], author.ID, h.authorInfo.Cached(author.Name))
+		}
+	}
+	h.writeFeed(w, r, feed)
 }
 
 // Series serves series catalog (navigation)
 func (h *Handler) Series(w http.ResponseWriter, r *http.Request) {
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 	if page < 1 {
 		page = 1
 	}
 
-	seriesList, total, err := h.repo.ListSeries(pageSize, (page-1)*pageSize)
+	seriesList, total, err := h.repo.ListSeriesInLanguage(scopeLanguage(r), pageSize, (page-1)*pageSize)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	feed := h.builder.BuildSeriesFeed(seriesList, page, total, pageSize)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildSeriesFeed(seriesList, page, total, pageSize)
+	h.writeFeed(w, r, feed)
 }
 
 // Genres serves genres catalog (navigation)
 func (h *Handler) Genres(w http.ResponseWriter, r *http.Request) {
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 	if page < 1 {
 		page = 1
 	}
 
-	genres, total, err := h.repo.ListGenres(pageSize, (page-1)*pageSize)
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	genres, total, err := h.repo.ListGenresInLanguage(scopeLanguage(r), pageSize, (page-1)*pageSize, hidden)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	feed := h.builder.BuildGenresFeed(genres, page, total, pageSize)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildGenresFeed(genres, page, total, pageSize)
+	h.writeFeed(w, r, feed)
 }
 
-// BooksByAuthor serves books by specific author
-func (h *Handler) BooksByAuthor(w http.ResponseWriter, r *http.Request) {
-	authorIDParam := chi.URLParam(r, "id")
-	authorID, err := strconv.Atoi(authorIDParam)
+// Tags serves tags catalog (navigation)
+func (h *Handler) Tags(w http.ResponseWriter, r *http.Request) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+	if page < 1 {
+		page = 1
+	}
+
+	hidden, err := h.restrictions(r)
 	if err != nil {
-		http.Error(w, "Invalid author ID", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	author, err := h.repo.GetAuthorByID(authorID)
+	tags, total, err := h.repo.ListTagsInLanguage(scopeLanguage(r), pageSize, (page-1)*pageSize, hidden)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if author == nil {
-		http.Error(w, "Author not found", http.StatusNotFound)
+
+	feed := h.builderFor(r).BuildTagsFeed(tags, page, total, pageSize)
+	h.writeFeed(w, r, feed)
+}
+
+// BooksByAuthor serves books by specific author. An author with more than
+// a page of books gets a navigation feed of sections instead.
+func (h *Handler) BooksByAuthor(w http.ResponseWriter, r *http.Request) {
+	author, ok := h.authorFromRequest(w, r)
+	if !ok {
 		return
 	}
 
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 
 	filter := storage.BookFilter{
 		Authors:   []string{author.Name},
@@ -189,20 +467,36 @@ func (h *Handler) BooksByAuthor(w http.ResponseWriter, r *http.Request) {
 		SortOrder: "asc",
 	}
 
-	result, err := h.repo.SearchBooks(filter)
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// A chosen order lists all the books instead of the sections
+	if page == 1 && result.Total > pageSize && order == "" {
+		h.serveAuthorSections(w, r, author, result.Total)
+		return
+	}
 
 	title := fmt.Sprintf("Книги автора %s", author.Name)
-	feedID := fmt.Sprintf("%s/opds/authors/%d", h.builder.baseURL, author.ID)
+	feedID := fmt.Sprintf("%s/authors/%d", h.builderFor(r).catalogURL(""), author.ID)
 	if page > 1 {
 		feedID += "?page=" + strconv.Itoa(page)
 	}
 
-	feed := h.builder.BuildBooksFeed(result.Books, title, feedID, page, result.Total)
-	h.writeFeed(w, feed)
+	disambiguation, err := h.repo.GetAuthorDisambiguation(author)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.builderFor(r).applyDisambiguation(feed, author, disambiguation)
+	h.writeFeed(w, r, feed)
 }
 
 // BooksBySeries serves books belonging to a specific series
@@ -225,7 +519,7 @@ func (h *Handler) BooksBySeries(w http.ResponseWriter, r *http.Request) {
 	}
 
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 
 	filter := storage.BookFilter{
 		Series:    []string{series.Name},
@@ -235,20 +529,56 @@ func (h *Handler) BooksBySeries(w http.ResponseWriter, r *http.Request) {
 		SortOrder: "asc",
 	}
 
-	result, err := h.repo.SearchBooks(filter)
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	title := fmt.Sprintf("Книги серии %s", series.Name)
-	feedID := fmt.Sprintf("%s/opds/series/%d", h.builder.baseURL, series.ID)
+	feedID := fmt.Sprintf("%s/series/%d", h.builderFor(r).catalogURL(""), series.ID)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// FirstInSeries serves the first books of all series, ordered by series
+func (h *Handler) FirstInSeries(w http.ResponseWriter, r *http.Request) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		FirstInSeries: true,
+		Limit:         pageSize,
+		Offset:        (page - 1) * pageSize,
+		SortBy:        "series",
+		SortOrder:     "asc",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feedID := h.builderFor(r).catalogURL("/series/first")
 	if page > 1 {
 		feedID += "?page=" + strconv.Itoa(page)
 	}
 
-	feed := h.builder.BuildBooksFeed(result.Books, title, feedID, page, result.Total)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, "Начните серию", feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
 }
 
 // BooksByGenre serves books belonging to a specific genre
@@ -271,7 +601,7 @@ func (h *Handler) BooksByGenre(w http.ResponseWriter, r *http.Request) {
 	}
 
 	page := h.getPageFromQuery(r)
-	pageSize := 30
+	pageSize := h.pageSize()
 
 	filter := storage.BookFilter{
 		Genres:    []string{genre.Name},
@@ -281,28 +611,84 @@ func (h *Handler) BooksByGenre(w http.ResponseWriter, r *http.Request) {
 		SortOrder: "asc",
 	}
 
-	result, err := h.repo.SearchBooks(filter)
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	genreLabel := h.builder.genreLabel(genre.Name)
+	genreLabel := h.builderFor(r).genreLabel(genre.Name)
 	title := fmt.Sprintf("Книги жанра %s", genreLabel)
-	feedID := fmt.Sprintf("%s/opds/genres/%d", h.builder.baseURL, genre.ID)
+	feedID := fmt.Sprintf("%s/genres/%d", h.builderFor(r).catalogURL(""), genre.ID)
 	if page > 1 {
 		feedID += "?page=" + strconv.Itoa(page)
 	}
 
-	feed := h.builder.BuildBooksFeed(result.Books, title, feedID, page, result.Total)
-	h.writeFeed(w, feed)
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// BooksByTag serves books marked with a specific tag
+func (h *Handler) BooksByTag(w http.ResponseWriter, r *http.Request) {
+	tagIDParam := chi.URLParam(r, "id")
+	tagID, err := strconv.Atoi(tagIDParam)
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.repo.GetTagByID(tagID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag == nil {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		Tags:      []string{tag.Name},
+		Limit:     pageSize,
+		Offset:    (page - 1) * pageSize,
+		SortBy:    "title",
+		SortOrder: "asc",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := fmt.Sprintf("Книги с тегом %s", tag.Name)
+	feedID := fmt.Sprintf("%s/tags/%d", h.builderFor(r).catalogURL(""), tag.ID)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
 }
 
 // OpenSearch serves OpenSearch description
 func (h *Handler) OpenSearch(w http.ResponseWriter, r *http.Request) {
 	// Escape XML-special characters to prevent XML injection
-	title := xmlEscape(h.builder.catalogTitle)
-	baseURL := xmlEscape(h.builder.baseURL)
+	b := h.builderFor(r)
+	title := xmlEscape(b.catalogTitle)
+	baseURL := xmlEscape(b.baseURL)
+	searchURL := xmlEscape(b.catalogURL("/search"))
 
 	description := `<?xml version="1.0" encoding="UTF-8"?>
 <OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
@@ -311,7 +697,7 @@ func (h *Handler) OpenSearch(w http.ResponseWriter, r *http.Request) {
     <Tags>books library catalog</Tags>
     <Contact>admin@example.com</Contact>
     <Url type="application/atom+xml;profile=opds-catalog"
-         template="` + baseURL + `/opds/search?q={searchTerms}"/>
+         template="` + searchURL + `?q={searchTerms}"/>
     <LongName>` + title + ` - поиск книг</LongName>
     <Image height="64" width="64" type="image/png">` + baseURL + `/favicon.ico</Image>
     <Query role="example" searchTerms="фантастика"/>
@@ -361,7 +747,15 @@ func (h *Handler) getPageFromQuery(r *http.Request) int {
 }
 
 // writeFeed writes OPDS feed as XML
-func (h *Handler) writeFeed(w http.ResponseWriter, feed *Feed) {
+func (h *Handler) writeFeed(w http.ResponseWriter, r *http.Request, feed *Feed) {
+	if h.authEnabled {
+		feed.Links = append(feed.Links, Link{
+			Rel:  RelAuthDocument,
+			Type: TypeAuthDocument,
+			Href: h.builder().AuthDocumentURL(),
+		})
+	}
+
 	// Marshal to buffer first so we can still send an error status if encoding fails
 	var buf bytes.Buffer
 	buf.WriteString(xml.Header)
@@ -373,50 +767,118 @@ func (h *Handler) writeFeed(w http.ResponseWriter, feed *Feed) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=3600")
+	// Serve the feed with its OPDS kind, as declared by its self link
+	contentType := TypeNavigation
+	for _, link := range feed.Links {
+		if link.Rel == "self" && link.Type != "" {
+			contentType = link.Type
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType+";charset=utf-8")
+	h.setCacheHeaders(w, r)
 	if _, err := w.Write(buf.Bytes()); err != nil {
 		log.Printf("writeFeed: failed to write response: %v", err)
 	}
 }
 
+// setCacheHeaders lets shared caches keep a feed only when it is the same
+// for everyone: nobody signs in and no access rules hide books. Otherwise
+// the feed depends on the reader and is cached by their client only. A
+// Cache-Control the handler set is kept.
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, r *http.Request) {
+	if w.Header().Get("Cache-Control") != "" {
+		return
+	}
+	hidden, err := h.restrictions(r)
+	if h.authEnabled || auth.UserFromContext(r.Context()) != nil || hidden != nil || err != nil {
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Add("Vary", "Authorization, Cookie")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+}
+
+// BookEntry serves the complete catalog entry of a book, which entries of
+// feeds link to with rel="alternate". The annotation is never shortened.
+func (h *Handler) BookEntry(w http.ResponseWriter, r *http.Request) {
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	book, err := h.repo.GetBookByID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	language := scopeLanguage(r)
+	if book == nil || hidden.Hides(book) || (language != "" && book.Language != language) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+	// Books hidden by an admin are shown to admins only, as in the API
+	if user := auth.UserFromContext(r.Context()); book.Hidden && (user == nil || !user.IsAdmin) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	doc := h.builderFor(r).BuildBookEntry(*book)
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		http.Error(w, "Failed to encode entry", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", TypeEntry+";charset=utf-8")
+	h.setCacheHeaders(w, r)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("BookEntry: failed to write response: %v", err)
+	}
+}
+
 // notImplemented serves a placeholder feed for not implemented features
-func (h *Handler) notImplemented(w http.ResponseWriter, feature string) {
+func (h *Handler) notImplemented(w http.ResponseWriter, r *http.Request, feature string) {
 	feed := &Feed{
 		Xmlns:     "http://www.w3.org/2005/Atom",
 		XmlnsDC:   "http://purl.org/dc/terms/",
 		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
 
-		ID:      h.builder.baseURL + "/opds/not-implemented",
+		ID:      h.builder().catalogURL("/not-implemented"),
 		Title:   feature + " (В разработке)",
-		Updated: time.Now(),
+		Updated: h.builder().updatedAt(),
 
 		Author: &Person{
-			Name: h.builder.catalogTitle,
+			Name: h.builder().catalogTitle,
 		},
 
 		Links: []Link{
 			{
 				Rel:  RelStart,
 				Type: TypeNavigation,
-				Href: h.builder.baseURL + "/opds",
+				Href: h.builder().catalogURL(""),
 			},
 			{
 				Rel:  RelUp,
 				Type: TypeNavigation,
-				Href: h.builder.baseURL + "/opds",
+				Href: h.builder().catalogURL(""),
 			},
 		},
 
 		Entries: []Entry{
 			{
-				ID:      h.builder.baseURL + "/opds/not-implemented",
+				ID:      h.builder().catalogURL("/not-implemented"),
 				Title:   "Функция в разработке",
-				Updated: time.Now(),
+				Updated: h.builder().updatedAt(),
 				Summary: fmt.Sprintf("Раздел '%s' будет реализован в следующих версиях.", feature),
 			},
 		},
 	}
 
-	h.writeFeed(w, feed)
+	h.writeFeed(w, r, feed)
 }
@@ -1,15 +1,21 @@
 package opds
 
 import (
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
 	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/sections"
 	"github.com/piligrim/pushkinlib/internal/storage"
 )
 
@@ -78,7 +84,7 @@ func TestWriteFeed_ErrorOnInvalidFeed(t *testing.T) {
 
 	// A nil feed should cause encoding to fail or produce empty output
 	// but writeFeed should not panic
-	h.writeFeed(w, &Feed{
+	h.writeFeed(w, httptest.NewRequest("GET", "/opds/", nil), &Feed{
 		Title:   "Test",
 		Updated: time.Now(),
 	})
@@ -89,6 +95,51 @@ func TestWriteFeed_ErrorOnInvalidFeed(t *testing.T) {
 	}
 }
 
+// TestFeedCacheHeaders verifies feeds are cached publicly only while they
+// are the same for every reader.
+func TestFeedCacheHeaders(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	var handler http.Handler = http.HandlerFunc(h.NewBooks)
+	var username string
+	headers := func() (string, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/opds/books/new", nil)
+		if username != "" {
+			req.SetBasicAuth(username, "secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return w.Header().Get("Cache-Control"), w.Header().Get("Vary")
+	}
+
+	if cacheControl, _ := headers(); cacheControl != "public, max-age=3600" {
+		t.Errorf("expected a public feed without auth, got %q", cacheControl)
+	}
+	if err := h.repo.SetAccessRule(storage.AccessRule{Kind: storage.AccessKindGenre, Name: "prose_classic"}); err != nil {
+		t.Fatalf("SetAccessRule: %v", err)
+	}
+	if cacheControl, _ := headers(); !strings.HasPrefix(cacheControl, "private") {
+		t.Errorf("expected a private feed with access rules, got %q", cacheControl)
+	}
+	if _, err := h.repo.DeleteAccessRule(storage.AccessKindGenre, "prose_classic"); err != nil {
+		t.Fatalf("DeleteAccessRule: %v", err)
+	}
+
+	if _, err := h.repo.CreateUser("reader", "secret", "reader", false); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	h.SetAuthEnabled(true)
+	handler = auth.NewMiddleware(h.repo, true).RequireBasicAuth(handler)
+	username = "reader"
+	cacheControl, vary := headers()
+	if !strings.HasPrefix(cacheControl, "private") || !strings.Contains(vary, "Authorization") || !strings.Contains(vary, "Cookie") {
+		t.Errorf("expected a private feed varying by credentials, got Cache-Control %q, Vary %q", cacheControl, vary)
+	}
+}
+
 // TestOpenSearch_XMLEscaping verifies XML injection is prevented (#7).
 func TestOpenSearch_XMLEscaping(t *testing.T) {
 	dbPath := filepath.Join(t.TempDir(), "test.db")
@@ -150,3 +201,1239 @@ func TestOpenSearch_ContentType(t *testing.T) {
 		t.Errorf("expected opensearchdescription+xml content type, got %s", ct)
 	}
 }
+
+// TestSearch_InvalidQuery verifies unusable queries yield an empty feed.
+func TestSearch_InvalidQuery(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+
+	req := httptest.NewRequest("GET", "/opds/search?q=%2A%2A%2A", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "opds-001") {
+		t.Errorf("expected no entries, got %s", w.Body.String())
+	}
+}
+
+// TestSearch_Suggestions verifies a misspelled query yields a "did you
+// mean" entry that keeps the active facets.
+func TestSearch_Suggestions(t *testing.T) {
+	h := setupFacetTestHandler(t)
+	h.repo.SetSearchSuggestionsEnabled(true)
+	if _, err := h.repo.RebuildSearchTerms(); err != nil {
+		t.Fatalf("RebuildSearchTerms failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/opds/search?q=Пушкн&format=fb2", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "Возможно, вы имели в виду: Пушкин" {
+		t.Fatalf("expected one suggestion entry, got %+v", feed.Entries)
+	}
+	if href := feed.Entries[0].Links[0].Href; href != "http://localhost:9090/opds/search?format=fb2&q=%D0%9F%D1%83%D1%88%D0%BA%D0%B8%D0%BD" {
+		t.Errorf("unexpected suggestion link %s", href)
+	}
+}
+
+// TestSearch_Fallback verifies a query found only by a relaxed retry says
+// so in the feed title and counts facets over the books shown.
+func TestSearch_Fallback(t *testing.T) {
+	h := setupFacetTestHandler(t)
+	h.repo.SetSearchFallback(true)
+
+	req := httptest.NewRequest("GET", "/opds/search?q=pushkin", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if feed.Title != "Ничего не найдено по запросу «pushkin». Возможно, вы искали: пушкин" {
+		t.Errorf("unexpected title %q", feed.Title)
+	}
+	if len(feed.Entries) != 3 {
+		t.Errorf("expected the 3 books of Пушкин, got %d entries", len(feed.Entries))
+	}
+	if !strings.Contains(w.Body.String(), `title="FB2" opds:facetGroup="Формат" thr:count="2"`) {
+		t.Errorf("expected facets counted over the books shown, got %s", w.Body.String())
+	}
+}
+
+// TestAuthDocument verifies the Authentication Document and its feed link.
+func TestAuthDocument(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetAuthEnabled(true)
+
+	req := httptest.NewRequest("GET", "/opds/auth.json", nil)
+	w := httptest.NewRecorder()
+	h.AuthDocument(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != TypeAuthDocument {
+		t.Errorf("expected %s, got %s", TypeAuthDocument, ct)
+	}
+
+	var doc AuthDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid authentication document: %v", err)
+	}
+	if doc.ID != "http://localhost:9090/opds/auth.json" || len(doc.Authentication) != 1 || doc.Authentication[0].Type != AuthTypeBasic {
+		t.Errorf("unexpected authentication document: %+v", doc)
+	}
+
+	req = httptest.NewRequest("GET", "/opds", nil)
+	w = httptest.NewRecorder()
+	h.Root(w, req)
+
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("response is not valid XML: %v", err)
+	}
+	found := false
+	for _, link := range feed.Links {
+		if link.Rel == RelAuthDocument && link.Href == doc.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected feed to link the authentication document")
+	}
+}
+
+type stubAuthorInfo map[string]*storage.AuthorInfo
+
+func (s stubAuthorInfo) Cached(name string) *storage.AuthorInfo {
+	return s[name]
+}
+
+// TestAuthors_AuthorInfo verifies author entries carry bio and portrait.
+func TestAuthors_AuthorInfo(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetAuthorInfoProvider(stubAuthorInfo{
+		"OPDS Author": {Bio: "Биография автора", PhotoURL: "https://example.org/photo.png"},
+	})
+
+	req := httptest.NewRequest("GET", "/opds/authors", nil)
+	w := httptest.NewRecorder()
+
+	h.Authors(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(feed.Entries))
+	}
+
+	entry := feed.Entries[0]
+	if entry.Content == nil || entry.Content.Text != "Биография автора" {
+		t.Errorf("expected bio as entry content, got %+v", entry.Content)
+	}
+
+	var thumbnail *Link
+	for i := range entry.Links {
+		if entry.Links[i].Rel == RelThumbnail {
+			thumbnail = &entry.Links[i]
+		}
+	}
+	if thumbnail == nil || thumbnail.Type != "image/png" {
+		t.Fatalf("expected portrait thumbnail link, got %+v", entry.Links)
+	}
+	// The portrait is proxied, so readers never contact Wikimedia
+	if !strings.HasPrefix(thumbnail.Href, "http://localhost:9090/api/v1/authors/") ||
+		!strings.HasSuffix(thumbnail.Href, "/portrait") {
+		t.Errorf("expected proxied portrait URL, got %q", thumbnail.Href)
+	}
+}
+
+// TestNavigationFeeds_BookCounts verifies author, top author and genre
+// entries show how many books they hold.
+func TestNavigationFeeds_BookCounts(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+
+	for path, serve := range map[string]http.HandlerFunc{
+		"/opds/authors":     h.Authors,
+		"/opds/authors/top": h.TopAuthors,
+		"/opds/genres":      h.Genres,
+	} {
+		w := httptest.NewRecorder()
+		serve(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+		body := w.Body.String()
+		for _, want := range []string{`xmlns:thr="http://purl.org/syndication/thread/1.0"`, `thr:count="1"`, "<summary>1 книга</summary>"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: expected %s in %s", path, want, body)
+			}
+		}
+	}
+}
+
+func TestBookCountSummary(t *testing.T) {
+	for n, want := range map[int]string{
+		0: "0 книг", 1: "1 книга", 3: "3 книги", 5: "5 книг", 11: "11 книг",
+		12: "12 книг", 21: "21 книга", 104: "104 книги", 111: "111 книг",
+	} {
+		if got := bookCountSummary(n); got != want {
+			t.Errorf("bookCountSummary(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+// TestBookToEntry_CoverLinks verifies cover links appear only for books with covers.
+func TestBookToEntry_CoverLinks(t *testing.T) {
+	b := NewBuilder("http://localhost:9090", "Test Catalog", nil)
+
+	hasImage := func(entry Entry) bool {
+		for _, link := range entry.Links {
+			if link.Rel == RelThumbnail && link.Href == "http://localhost:9090/api/v1/books/b1/cover" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if hasImage(b.bookToEntry(storage.Book{ID: "b1", Title: "Без обложки"})) {
+		t.Error("unexpected cover link for book without cover")
+	}
+	if !hasImage(b.bookToEntry(storage.Book{ID: "b1", Title: "С обложкой", HasCover: true})) {
+		t.Error("expected cover link for book with cover")
+	}
+
+	// Covers with a known hash link to the cacheable address
+	entry := b.bookToEntry(storage.Book{ID: "b1", Title: "С обложкой", HasCover: true, CoverHash: "0123abcd"})
+	links := map[string]string{}
+	for _, link := range entry.Links {
+		links[link.Rel] = link.Href
+	}
+	if links[RelImage] != "http://localhost:9090/covers/b1/0123abcd.jpg" || links[RelThumbnail] != "http://localhost:9090/covers/b1/0123abcd.jpg?w=150" {
+		t.Errorf("unexpected cover links %v", links)
+	}
+}
+
+// TestBookToEntry_Conversions checks converted formats get acquisition links.
+func TestBookToEntry_Conversions(t *testing.T) {
+	b := NewBuilder("http://localhost:9090", "Test Catalog", nil)
+	b.conversions = func(format string) []string {
+		if format == "fb2" {
+			return []string{"azw3", "epub"}
+		}
+		return nil
+	}
+
+	types := map[string]string{}
+	for _, link := range b.bookToEntry(storage.Book{ID: "b1", Title: "Книга", Format: "fb2"}).Links {
+		if link.Rel == RelAcquisitionOpen {
+			types[link.Href] = link.Type
+		}
+	}
+	want := map[string]string{
+		"http://localhost:9090/download/b1":               TypeFB2XML,
+		"http://localhost:9090/download/b1?packaging=zip": TypeFB2,
+		"http://localhost:9090/download/b1?format=azw3":   "application/vnd.amazon.ebook",
+		"http://localhost:9090/download/b1?format=epub":   TypeEPUB,
+	}
+	for href, typ := range want {
+		if types[href] != typ {
+			t.Errorf("link %s: got type %q, want %q", href, types[href], typ)
+		}
+	}
+}
+
+// TestHandler_FormatPreference verifies the preferred download format is
+// the first acquisition link of book entries.
+func TestHandler_FormatPreference(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetConversions(func(format string) []string { return []string{"azw3", "epub"} })
+	router := chi.NewRouter()
+	router.Use(h.FormatPreference)
+	router.Get("/opds/books/new", h.NewBooks)
+	firstAcquisition := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/opds/books/new", nil))
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil || len(feed.Entries) == 0 {
+			t.Fatalf("invalid feed: %v", err)
+		}
+		for _, link := range feed.Entries[0].Links {
+			if link.Rel == RelAcquisitionOpen {
+				return link.Href
+			}
+		}
+		return ""
+	}
+
+	for format, want := range map[string]string{
+		"":        "http://localhost:9090/download/opds-001",
+		"epub":    "http://localhost:9090/download/opds-001?format=epub",
+		"fb2.zip": "http://localhost:9090/download/opds-001?packaging=zip",
+		"pdf":     "http://localhost:9090/download/opds-001",
+	} {
+		if err := h.repo.SetFormatPreference("", "", format); err != nil {
+			t.Fatalf("SetFormatPreference failed: %v", err)
+		}
+		if got := firstAcquisition(); got != want {
+			t.Errorf("%q: first acquisition %q, want %q", format, got, want)
+		}
+	}
+
+	// The order of a reader's token must not reach other readers through
+	// shared caches
+	user, err := h.repo.CreateUser("reader", "secret", "reader", false)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	apiToken, secret, err := h.repo.CreateAPIToken(user.ID, "reader", []string{storage.ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if err := h.repo.SetFormatPreference(user.ID, apiToken.ID, "epub"); err != nil {
+		t.Fatalf("SetFormatPreference failed: %v", err)
+	}
+	h.SetAuthEnabled(true)
+	req := httptest.NewRequest("GET", "/opds/books/new", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	w := httptest.NewRecorder()
+	auth.NewMiddleware(h.repo, true).RequireBasicAuth(router).ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "opds-001?format=epub") {
+		t.Fatalf("expected the token's format first, got %d: %s", w.Code, w.Body.String())
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private") || !strings.Contains(w.Header().Get("Vary"), "Authorization") {
+		t.Errorf("expected a private feed, got Cache-Control %q, Vary %q", cacheControl, w.Header().Get("Vary"))
+	}
+}
+
+// TestBookToEntry_Checksum verifies stored checksums become dc:identifier.
+func TestBookToEntry_Checksum(t *testing.T) {
+	b := NewBuilder("http://localhost:9090", "Test Catalog", nil)
+
+	entry := b.bookToEntry(storage.Book{ID: "b1", Title: "Книга", SHA256: "abc123"})
+	data, err := xml.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<dc:identifier>urn:sha256:abc123</dc:identifier>") {
+		t.Errorf("expected dc:identifier, got %s", data)
+	}
+	if b.bookToEntry(storage.Book{ID: "b2", Title: "Книга"}).Identifier != "" {
+		t.Error("unexpected identifier for book without checksum")
+	}
+}
+
+// TestBookToEntry_AnnotationXHTML verifies FB2 annotation markup becomes
+// sanitized XHTML content and plain text summary.
+func TestBookToEntry_AnnotationXHTML(t *testing.T) {
+	b := NewBuilder("http://localhost:9090", "Test Catalog", nil)
+
+	annotation := `<p>Роман <emphasis>в стихах</emphasis>, <strong>1833</strong>.</p>
+		<empty-line/>
+		<poem><stanza><v>Мой дядя самых честных правил,</v><v>Когда не в шутку занемог</v></stanza></poem>
+		<p>Ссылка <a l:href="javascript:alert(1)" onclick="x()">здесь</a> &amp; <script>alert(2)</script></p>
+		<cite><p>Цитата</p></cite>`
+	entry := b.bookToEntry(storage.Book{ID: "b1", Title: "Евгений Онегин", Format: "fb2", Annotation: annotation})
+
+	want := `<div xmlns="http://www.w3.org/1999/xhtml">` +
+		`<p>Роман <em>в стихах</em>, <strong>1833</strong>.</p>` +
+		`<p>Мой дядя самых честных правил,</p><p>Когда не в шутку занемог</p>` +
+		`<p>Ссылка здесь &amp; alert(2)</p>` +
+		`<blockquote><p>Цитата</p></blockquote>` +
+		`<p>Формат: FB2</p></div>`
+	if entry.Content == nil || entry.Content.Type != "xhtml" || entry.Content.XHTML != want {
+		t.Fatalf("unexpected content:\n%+v\nwant %s", entry.Content, want)
+	}
+	if !strings.HasPrefix(entry.Summary, "Роман в стихах, 1833.\nМой дядя") {
+		t.Errorf("unexpected summary %q", entry.Summary)
+	}
+
+	data, err := xml.Marshal(Feed{Entries: []Entry{entry}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Feed
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Errorf("content is not well-formed: %v\n%s", err, data)
+	}
+
+	plain := b.bookToEntry(storage.Book{ID: "b2", Title: "Книга", Annotation: "Первый абзац.\nВторой <абзац>."})
+	if want := `<div xmlns="http://www.w3.org/1999/xhtml"><p>Первый абзац.</p><p>Второй &lt;абзац&gt;.</p></div>`; plain.Content == nil || plain.Content.XHTML != want {
+		t.Errorf("unexpected content of plain annotation: %+v", plain.Content)
+	}
+}
+
+// TestBuildBooksFeed_OpenSearchPaging verifies paginated feeds report their
+// size and position as OpenSearch elements.
+func TestBuildBooksFeed_OpenSearchPaging(t *testing.T) {
+	b := NewBuilder("http://localhost:9090", "Test Catalog", nil)
+
+	feed := b.BuildBooksFeed(nil, "Книги", "http://localhost:9090/opds/books/new", 3, 30, 3600)
+	data, err := xml.Marshal(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`xmlns:opensearch="http://a9.com/-/spec/opensearch/1.1/"`,
+		"<opensearch:totalResults>3600</opensearch:totalResults>",
+		"<opensearch:startIndex>61</opensearch:startIndex>",
+		"<opensearch:itemsPerPage>30</opensearch:itemsPerPage>",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in %s", want, data)
+		}
+	}
+
+	authors := b.BuildAuthorsFeed(nil, 1, 0, 30)
+	if authors.OpenSearch == nil || authors.TotalResults != 0 || authors.StartIndex != 1 {
+		t.Errorf("unexpected navigation paging: %+v", authors.OpenSearch)
+	}
+	if root := b.BuildRootFeed(); root.OpenSearch != nil {
+		t.Error("unexpected paging in the root feed")
+	}
+}
+
+// TestHandler_Reload verifies the base URL and page size can be changed on
+// a live handler.
+func TestHandler_Reload(t *testing.T) {
+	h := setupFacetTestHandler(t)
+	h.SetBaseURL("https://books.example.org/")
+	h.SetPageSize(2)
+
+	w := httptest.NewRecorder()
+	h.NewBooks(w, httptest.NewRequest("GET", "/opds/books/new", nil))
+	body := w.Body.String()
+
+	if strings.Contains(body, "localhost:9090") || !strings.Contains(body, `href="https://books.example.org/opds/books/new?page=2"`) {
+		t.Errorf("expected links to the new base URL and a next page:\n%s", body)
+	}
+	if n := strings.Count(body, "<entry>"); n != 2 {
+		t.Errorf("expected 2 entries per page, got %d", n)
+	}
+
+	h.SetPageSize(0)
+	if h.pageSize() != defaultPageSize {
+		t.Errorf("expected the default page size, got %d", h.pageSize())
+	}
+}
+
+// TestHandler_RestrictedGenre verifies books and navigation entries of a
+// restricted genre are left out of feeds for anonymous readers.
+func TestHandler_RestrictedGenre(t *testing.T) {
+	h := setupFacetTestHandler(t)
+	if err := h.repo.SetAccessRule(storage.AccessRule{Kind: storage.AccessKindGenre, Name: "prose_classic"}); err != nil {
+		t.Fatalf("SetAccessRule: %v", err)
+	}
+
+	for _, path := range []string{"/opds/books/new", "/opds/search?q=Пушкин", "/opds/genres"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		switch {
+		case strings.HasPrefix(path, "/opds/books"):
+			h.NewBooks(w, r)
+		case strings.HasPrefix(path, "/opds/search"):
+			h.SearchBooks(w, r)
+		default:
+			h.Genres(w, r)
+		}
+		if n := strings.Count(w.Body.String(), "<entry>"); n != 0 {
+			t.Errorf("%s: expected no entries, got %d:\n%s", path, n, w.Body.String())
+		}
+	}
+}
+
+// TestHandler_Years verifies the decade → year → books navigation.
+func TestHandler_Years(t *testing.T) {
+	h := setupFacetTestHandler(t)
+
+	get := func(handler http.HandlerFunc, path, param, value string) Feed {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		if param != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add(param, value)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed
+	}
+
+	decades := get(h.Years, "/opds/years", "", "")
+	if len(decades.Entries) != 2 || decades.Entries[0].Title != "1830-е" || decades.Entries[0].Summary != "Книг: 2" ||
+		decades.Entries[1].Title != "1840-е" {
+		t.Errorf("unexpected decades: %+v", decades.Entries)
+	}
+
+	years := get(h.YearsOfDecade, "/opds/years/decade/1830", "decade", "1830")
+	if len(years.Entries) != 2 || years.Entries[1].Title != "1836" ||
+		years.Entries[1].Links[0].Href != "http://localhost:9090/opds/years/1836" {
+		t.Errorf("unexpected years: %+v", years.Entries)
+	}
+	if all := years.Entries[0]; all.Summary != "Книг: 2" ||
+		all.Links[0].Href != "http://localhost:9090/opds/years/decade/1830/books" {
+		t.Errorf("unexpected decade entry: %+v", all)
+	}
+
+	decadeBooks := get(h.BooksOfDecade, "/opds/years/decade/1830/books", "decade", "1830")
+	if len(decadeBooks.Entries) != 2 {
+		t.Errorf("expected 2 books of the 1830s, got %d", len(decadeBooks.Entries))
+	}
+
+	books := get(h.BooksByYear, "/opds/years/1836", "year", "1836")
+	if len(books.Entries) != 2 {
+		t.Errorf("expected 2 books of 1836, got %d", len(books.Entries))
+	}
+
+	req := httptest.NewRequest("GET", "/opds/years/decade/1835", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("decade", "1835")
+	w := httptest.NewRecorder()
+	h.YearsOfDecade(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid decade, got %d", w.Code)
+	}
+}
+
+// TestHandler_FirstInSeries verifies the feed lists only first volumes.
+func TestHandler_FirstInSeries(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "fs-1", Title: "Том первый", Authors: []string{"Автор"}, Series: "Цикл", SeriesNum: 1, Format: "fb2", Date: time.Now()},
+		{ID: "fs-2", Title: "Том второй", Authors: []string{"Автор"}, Series: "Цикл", SeriesNum: 2, Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.FirstInSeries(w, httptest.NewRequest("GET", "/opds/series/first", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if feed.Title != "Начните серию" || len(feed.Entries) != 1 || feed.Entries[0].Title != "Том первый" {
+		t.Errorf("unexpected feed %q: %+v", feed.Title, feed.Entries)
+	}
+}
+
+// TestBooksByAuthor_Disambiguation verifies the author feed warns about a
+// record that may hold books of namesakes and links them.
+func TestBooksByAuthor_Disambiguation(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	authors, err := h.repo.FindAuthors("OPDS Author", 1)
+	if err != nil || len(authors) == 0 {
+		t.Fatalf("FindAuthors = %v, %v", authors, err)
+	}
+	if err := h.repo.SetAuthorAmbiguous(authors[0].ID, "Два разных автора."); err != nil {
+		t.Fatalf("SetAuthorAmbiguous: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/opds/authors/"+strconv.Itoa(authors[0].ID), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(authors[0].ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.BooksByAuthor(w, req)
+
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if !strings.Contains(feed.Subtitle, "книги разных авторов") || !strings.Contains(feed.Subtitle, "Два разных автора.") {
+		t.Errorf("expected a disambiguation notice, got subtitle %q", feed.Subtitle)
+	}
+}
+
+// TestBooksByAuthor_Sections verifies a prolific author gets a navigation
+// feed of sections, each backed by its own query.
+func TestBooksByAuthor_Sections(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetPageSize(2)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "opds-s2", Title: "Вторая", Authors: []string{"OPDS Author"}, Series: "Цикл", SeriesNum: 2, Format: "fb2", Date: time.Now()},
+		{ID: "opds-s1", Title: "Первая", Authors: []string{"OPDS Author"}, Series: "Цикл", SeriesNum: 1, Format: "fb2", Date: time.Now()},
+		{ID: "opds-x", Title: "Сборник", Authors: []string{"OPDS Author"}, OtherSeries: []inpx.SeriesRef{{Name: "Цикл", Num: 3}}, Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	authors, err := h.repo.FindAuthors("OPDS Author", 1)
+	if err != nil || len(authors) == 0 {
+		t.Fatalf("FindAuthors = %v, %v", authors, err)
+	}
+	authorPath := "/opds/authors/" + strconv.Itoa(authors[0].ID)
+
+	router := chi.NewRouter()
+	router.Get("/opds/authors/{id}", h.BooksByAuthor)
+	router.Get("/opds/authors/{id}/series", h.AuthorSeries)
+	router.Get("/opds/authors/{id}/series/{seriesID}", h.AuthorBooksInSeries)
+	router.Get("/opds/authors/{id}/alphabet", h.AuthorBooksAlphabetical)
+	router.Get("/opds/authors/{id}/new", h.AuthorBooksByDate)
+	router.Get("/opds/authors/{id}/noseries", h.AuthorBooksWithoutSeries)
+	get := func(path string) Feed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed
+	}
+	titles := func(feed Feed) []string {
+		var titles []string
+		for _, entry := range feed.Entries {
+			titles = append(titles, entry.Title)
+		}
+		return titles
+	}
+
+	sections := get(authorPath)
+	if got := strings.Join(titles(sections), ", "); got != "По сериям, По алфавиту, По дате добавления, Вне серий" {
+		t.Fatalf("unexpected sections: %s", got)
+	}
+	if sections.Entries[0].Links[0].Type != TypeNavigation || sections.Entries[1].Summary != bookCountSummary(4) ||
+		sections.Entries[3].Summary != bookCountSummary(1) {
+		t.Errorf("unexpected section entries: %+v", sections.Entries)
+	}
+
+	series := get(authorPath + "/series")
+	if len(series.Entries) != 1 || series.Entries[0].Title != "Цикл" || series.Entries[0].Summary != bookCountSummary(3) {
+		t.Fatalf("unexpected series: %+v", series.Entries)
+	}
+	inSeries := get(strings.TrimPrefix(series.Entries[0].Links[0].Href, "http://localhost:9090"))
+	if got := strings.Join(titles(inSeries), ", "); got != "Первая, Вторая" {
+		t.Errorf("first page of the series = %s, want series order", got)
+	}
+
+	if got := strings.Join(titles(get(authorPath+"/alphabet")), ", "); got != "OPDS Test Book, Вторая" {
+		t.Errorf("first page by title = %s", got)
+	}
+	if got := strings.Join(titles(get(authorPath+"/noseries")), ", "); got != "OPDS Test Book" {
+		t.Errorf("books outside series = %s", got)
+	}
+	if got := len(get(authorPath + "/new").Entries); got != 2 {
+		t.Errorf("expected a page of 2 new books, got %d", got)
+	}
+
+	// The flat feed stays for later pages and authors with one page of books
+	if got := len(get(authorPath + "?page=2").Entries); got != 2 {
+		t.Errorf("expected 2 books on the second flat page, got %d", got)
+	}
+	h.SetPageSize(10)
+	if got := len(get(authorPath).Entries); got != 4 {
+		t.Errorf("expected a flat feed of 4 books, got %d", got)
+	}
+}
+
+// TestHandler_Languages verifies the language sections of the root feed and
+// the catalog scoped to one language.
+func TestHandler_Languages(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetLanguagesEnabled(true)
+	book := inpx.Book{
+		ID: "opds-en", Title: "English Book", Authors: []string{"English Author"},
+		Genre: "fiction", Language: "en", Format: "fb2", Date: time.Now(),
+	}
+	if err := h.repo.InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to insert test book: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/opds/", h.Root)
+	router.Route("/opds/lang/{lang}", func(r chi.Router) {
+		r.Use(h.LanguageScope)
+		r.Get("/", h.Root)
+		r.Get("/authors", h.Authors)
+		r.Get("/search", h.SearchBooks)
+	})
+	get := func(path string) Feed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed
+	}
+
+	root := get("/opds/")
+	if len(root.Entries) < 2 || root.Entries[0].Title != "English" || root.Entries[1].Title != "Русский" ||
+		root.Entries[1].Links[0].Href != "http://localhost:9090/opds/lang/ru" {
+		t.Errorf("expected language sections first, got %+v", root.Entries)
+	}
+
+	scoped := get("/opds/lang/ru/")
+	if scoped.Title != "Test Catalog — Русский" || scoped.ID != "http://localhost:9090/opds/lang/ru" {
+		t.Errorf("unexpected scoped root %q %q", scoped.Title, scoped.ID)
+	}
+	for _, entry := range scoped.Entries {
+		if !strings.HasPrefix(entry.Links[0].Href, "http://localhost:9090/opds/lang/ru/") {
+			t.Errorf("link %s leaves the language catalog", entry.Links[0].Href)
+		}
+	}
+
+	authors := get("/opds/lang/en/authors")
+	if len(authors.Entries) != 1 || authors.Entries[0].Title != "English Author" {
+		t.Errorf("expected only the English author, got %+v", authors.Entries)
+	}
+
+	found := get("/opds/lang/en/search?q=Book")
+	if len(found.Entries) != 1 || found.Entries[0].Title != "English Book" {
+		t.Errorf("expected only the English book, got %+v", found.Entries)
+	}
+}
+
+// TestHandler_Featured verifies the root catalog links to the curated
+// section only while it holds books, and the section keeps the admin's order.
+func TestHandler_Featured(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	if err := h.repo.InsertBooks([]inpx.Book{{ID: "opds-002", Title: "Second Book", Format: "fb2", Date: time.Now()}}); err != nil {
+		t.Fatalf("failed to insert book: %v", err)
+	}
+
+	root := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.Root(w, httptest.NewRequest("GET", "/opds", nil))
+		return w.Body.String()
+	}
+	if strings.Contains(root(), "/opds/featured") {
+		t.Error("root links to an empty featured section")
+	}
+
+	if err := h.repo.SetFeaturedBooks([]string{"opds-002", "opds-001"}); err != nil {
+		t.Fatalf("SetFeaturedBooks failed: %v", err)
+	}
+	if body := root(); !strings.Contains(body, `href="http://localhost:9090/opds/featured"`) {
+		t.Errorf("root does not link to the featured section:\n%s", body)
+	}
+
+	w := httptest.NewRecorder()
+	h.FeaturedBooks(w, httptest.NewRequest("GET", "/opds/featured", nil))
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "Second Book" || feed.Entries[1].Title != "OPDS Test Book" {
+		t.Errorf("unexpected featured entries %+v", feed.Entries)
+	}
+}
+
+// TestHandler_Sections verifies configured sections are listed in the root
+// catalog and in the catalogs of their languages, and serve their books.
+func TestHandler_Sections(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	book := inpx.Book{ID: "opds-en", Title: "English Book", Genre: "fiction", Language: "en", Format: "fb2", Date: time.Now()}
+	if err := h.repo.InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to insert test book: %v", err)
+	}
+	list, err := sections.Parse("Русская проза|Проза на русском=id=ru-prose&genres=fiction&languages=ru;Вся проза=genres=fiction")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	h.SetSections(list)
+
+	router := chi.NewRouter()
+	router.Get("/opds/", h.Root)
+	router.Get("/opds/sections/{id}", h.Section)
+	router.Route("/opds/lang/{lang}", func(r chi.Router) {
+		r.Use(h.LanguageScope)
+		r.Get("/", h.Root)
+		r.Get("/sections/{id}", h.Section)
+	})
+	get := func(path string) (int, Feed) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var feed Feed
+		if w.Code == http.StatusOK {
+			if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+				t.Fatalf("%s: invalid feed: %v", path, err)
+			}
+		}
+		return w.Code, feed
+	}
+	sectionTitles := func(feed Feed) []string {
+		var titles []string
+		for _, entry := range feed.Entries {
+			if strings.Contains(entry.ID, "/sections/") {
+				titles = append(titles, entry.Title)
+			}
+		}
+		return titles
+	}
+
+	_, root := get("/opds/")
+	if titles := sectionTitles(root); len(titles) != 2 || titles[0] != "Русская проза" || titles[1] != "Вся проза" {
+		t.Errorf("expected both sections in the root, got %v", titles)
+	}
+	_, english := get("/opds/lang/en/")
+	if titles := sectionTitles(english); len(titles) != 1 || titles[0] != "Вся проза" {
+		t.Errorf("expected only the section without languages in English, got %v", titles)
+	}
+
+	_, prose := get("/opds/sections/ru-prose")
+	if prose.Title != "Русская проза" || len(prose.Entries) != 1 || prose.Entries[0].Title != "OPDS Test Book" {
+		t.Errorf("unexpected section feed %q %+v", prose.Title, prose.Entries)
+	}
+	_, all := get("/opds/lang/en/sections/2")
+	if len(all.Entries) != 1 || all.Entries[0].Title != "English Book" {
+		t.Errorf("expected the English book only, got %+v", all.Entries)
+	}
+
+	for _, path := range []string{"/opds/sections/missing", "/opds/lang/en/sections/ru-prose"} {
+		if code, _ := get(path); code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, code)
+		}
+	}
+}
+
+// TestHandler_SavedSearches verifies the root links to the reader's saved
+// searches, which show their new books until opened.
+func TestHandler_SavedSearches(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	router := chi.NewRouter()
+	router.Get("/opds/", h.Root)
+	router.Get("/opds/saved", h.SavedSearches)
+	router.Get("/opds/saved/{id}", h.SavedSearchBooks)
+	get := func(path string) Feed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed
+	}
+	linksToSaved := func() bool {
+		for _, entry := range get("/opds/").Entries {
+			if entry.Title == savedSearchesTitle {
+				return true
+			}
+		}
+		return false
+	}
+
+	if linksToSaved() {
+		t.Error("root links to saved searches the reader has none of")
+	}
+	search, err := h.repo.CreateSavedSearch(storage.SavedSearch{Name: "Художественное", Filter: storage.BookFilter{Genres: []string{"fiction"}}, Notify: true})
+	if err != nil {
+		t.Fatalf("CreateSavedSearch failed: %v", err)
+	}
+	if !linksToSaved() {
+		t.Error("root does not link to saved searches")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := h.repo.InsertBooks([]inpx.Book{{ID: "opds-002", Title: "New Book", Genre: "fiction", Format: "fb2", Date: time.Now()}}); err != nil {
+		t.Fatalf("failed to insert book: %v", err)
+	}
+	saved := get("/opds/saved")
+	if len(saved.Entries) != 1 || saved.Entries[0].Summary != "Новых книг: 1" {
+		t.Errorf("expected one saved search with a new book, got %+v", saved.Entries)
+	}
+
+	books := get("/opds/saved/" + search.ID)
+	if books.Title != "Художественное" || len(books.Entries) != 2 {
+		t.Errorf("unexpected saved search feed %q %+v", books.Title, books.Entries)
+	}
+	if saved := get("/opds/saved"); saved.Entries[0].Summary != "" {
+		t.Errorf("expected no new books after the search was opened, got %q", saved.Entries[0].Summary)
+	}
+
+	// With accounts the root lists the searches of the reader, so shared
+	// caches must not keep it
+	user, err := h.repo.CreateUser("reader", "secret", "reader", false)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := h.repo.CreateSavedSearch(storage.SavedSearch{UserID: user.ID, Name: "Моё", Filter: storage.BookFilter{Genres: []string{"fiction"}}}); err != nil {
+		t.Fatalf("CreateSavedSearch failed: %v", err)
+	}
+	h.SetAuthEnabled(true)
+	req := httptest.NewRequest("GET", "/opds/", nil)
+	req.SetBasicAuth("reader", "secret")
+	w := httptest.NewRecorder()
+	auth.NewMiddleware(h.repo, true).RequireBasicAuth(router).ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), savedSearchesTitle) {
+		t.Fatalf("expected the root with the reader's saved searches, got %d: %s", w.Code, w.Body.String())
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private") || !strings.Contains(w.Header().Get("Vary"), "Authorization") {
+		t.Errorf("expected a private root feed, got Cache-Control %q, Vary %q", cacheControl, w.Header().Get("Vary"))
+	}
+}
+
+// TestShelfBooks_Token verifies a shared shelf is served only with its token.
+func TestShelfBooks_Token(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+
+	shelf, err := h.repo.CreateShelf("", "Полка")
+	if err != nil {
+		t.Fatalf("CreateShelf failed: %v", err)
+	}
+	if err := h.repo.AddShelfBook(shelf.ID, "opds-001"); err != nil {
+		t.Fatalf("AddShelfBook failed: %v", err)
+	}
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/opds/shelves/"+shelf.ID+"?token="+token, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", shelf.ID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		h.ShelfBooks(w, req)
+		return w
+	}
+
+	if w := serve("forged"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a wrong token, got %d", w.Code)
+	}
+
+	token, err := h.repo.ShelfToken(shelf.ID)
+	if err != nil {
+		t.Fatalf("ShelfToken failed: %v", err)
+	}
+	w := serve(token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("expected a shared shelf not to be cached, got %q", cacheControl)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"<title>Полка</title>", "OPDS Test Book", "token=" + token} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in %s", want, body)
+		}
+	}
+}
+
+// TestFeedDates verifies entries carry the date a book was added and when it
+// last changed, and feeds are as recent as their latest entry.
+func TestFeedDates(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	added := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "fd-1", Title: "Давняя книга", Authors: []string{"Автор"}, Format: "fb2", Date: added},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	latest, err := h.repo.CatalogUpdatedAt()
+	if err != nil || latest.IsZero() {
+		t.Fatalf("CatalogUpdatedAt = %v, %v", latest, err)
+	}
+
+	w := httptest.NewRecorder()
+	h.NewBooks(w, httptest.NewRequest("GET", "/opds/books/new", nil))
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	var newest time.Time
+	for _, entry := range feed.Entries {
+		if entry.Updated.After(newest) {
+			newest = entry.Updated
+		}
+		if entry.Title == "Давняя книга" && (entry.Published == nil || !entry.Published.Equal(added)) {
+			t.Errorf("expected published %v, got %v", added, entry.Published)
+		}
+	}
+	if !feed.Updated.Equal(newest) {
+		t.Errorf("feed updated %v, latest entry %v", feed.Updated, newest)
+	}
+
+	w = httptest.NewRecorder()
+	h.Root(w, httptest.NewRequest("GET", "/opds", nil))
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	if !feed.Updated.Equal(latest) {
+		t.Errorf("root feed updated %v, want the last catalog change %v", feed.Updated, latest)
+	}
+}
+
+// TestAnnotationLimit verifies long annotations are shortened in feeds,
+// whose entries link to the complete entry.
+func TestAnnotationLimit(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetAnnotationLimit(10)
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "al-1", Title: "Многословная", Authors: []string{"Автор"}, Annotation: "Очень длинная аннотация книги", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.NewBooks(w, httptest.NewRequest("GET", "/opds/books/new", nil))
+	var feed Feed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed: %v", err)
+	}
+	var entry *Entry
+	for i := range feed.Entries {
+		if feed.Entries[i].Title == "Многословная" {
+			entry = &feed.Entries[i]
+		}
+	}
+	if entry == nil || entry.Summary != "Очень дли…" {
+		t.Fatalf("expected a shortened summary, got %+v", entry)
+	}
+	if entry.Content == nil || strings.Contains(entry.Content.XHTML, "аннотация") {
+		t.Errorf("expected shortened content, got %+v", entry.Content)
+	}
+	var complete string
+	for _, link := range entry.Links {
+		if link.Rel == "alternate" && link.Type == TypeEntry {
+			complete = link.Href
+		}
+	}
+	if complete != "http://localhost:9090/opds/books/al-1" {
+		t.Errorf("expected a link to the complete entry, got %q", complete)
+	}
+}
+
+// TestBookEntry verifies the complete entry of a book carries the whole
+// annotation and links to the feeds of its author and series, and that a
+// book hidden by an admin has no entry for other readers.
+func TestBookEntry(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetAnnotationLimit(10)
+	annotation := "Очень длинная аннотация книги"
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "be-1", Title: "Многословная", Authors: []string{"Автор"}, Series: "Цикл", Annotation: annotation, Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	var handler http.Handler = http.HandlerFunc(h.BookEntry)
+	var username string
+	bookEntry := func(id string) (*httptest.ResponseRecorder, EntryDocument) {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req := httptest.NewRequest("GET", "/opds/books/"+id, nil)
+		if username != "" {
+			req.SetBasicAuth(username, "secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		var doc EntryDocument
+		if w.Code == http.StatusOK {
+			if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("invalid entry: %v", err)
+			}
+		}
+		return w, doc
+	}
+
+	w, doc := bookEntry("be-1")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), TypeEntry) {
+		t.Fatalf("expected an entry document, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if doc.ID != "http://localhost:9090/opds/books/be-1" || doc.Summary != annotation {
+		t.Errorf("expected the complete entry, got %q: %q", doc.ID, doc.Summary)
+	}
+	related := map[string]bool{}
+	for _, link := range doc.Links {
+		if link.Rel == RelRelated {
+			related[link.Title] = true
+		}
+	}
+	if !related["Все книги автора Автор"] || !related["Все книги серии Цикл"] {
+		t.Errorf("expected links to the author and series feeds, got %v", related)
+	}
+	if w, _ := bookEntry("missing"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown book, got %d", w.Code)
+	}
+
+	if err := h.repo.HideBook("be-1"); err != nil {
+		t.Fatalf("HideBook failed: %v", err)
+	}
+	if w, _ := bookEntry("be-1"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a hidden book, got %d", w.Code)
+	}
+	for name, admin := range map[string]bool{"reader": false, "admin": true} {
+		if _, err := h.repo.CreateUser(name, "secret", name, admin); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	handler = auth.NewMiddleware(h.repo, true).RequireBasicAuth(handler)
+	for name, want := range map[string]int{"reader": http.StatusNotFound, "admin": http.StatusOK} {
+		username = name
+		if w, _ := bookEntry("be-1"); w.Code != want {
+			t.Errorf("hidden book for %s: expected %d, got %d", name, want, w.Code)
+		}
+	}
+}
+
+// TestHandler_AllBooks verifies the complete acquisition feed is linked
+// from the root and pages through every book in ID order.
+func TestHandler_AllBooks(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	var books []inpx.Book
+	for _, id := range []string{"c-3", "c-1", "c-2"} {
+		books = append(books, inpx.Book{ID: id, Title: "Книга " + id, Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()})
+	}
+	if err := h.repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.Root(w, httptest.NewRequest("GET", "/opds/", nil))
+	if !strings.Contains(w.Body.String(), `rel="`+RelCrawlable+`"`) ||
+		!strings.Contains(w.Body.String(), `href="http://localhost:9090/opds/all"`) {
+		t.Errorf("root does not link the crawlable feed:\n%s", w.Body.String())
+	}
+
+	fetch := func(path string) Feed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.AllBooks(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed
+	}
+	link := func(feed Feed, rel string) string {
+		for _, l := range feed.Links {
+			if l.Rel == rel {
+				return l.Href
+			}
+		}
+		return ""
+	}
+
+	var titles []string
+	feed := fetch("/opds/all?page_size=2")
+	for _, e := range feed.Entries {
+		titles = append(titles, e.Title)
+	}
+	next := link(feed, RelNext)
+	if next != "http://localhost:9090/opds/all?page=2&page_size=2" {
+		t.Fatalf("unexpected next link %q", next)
+	}
+	feed = fetch(strings.TrimPrefix(next, "http://localhost:9090"))
+	for _, e := range feed.Entries {
+		titles = append(titles, e.Title)
+	}
+	if link(feed, RelNext) != "" {
+		t.Errorf("last page links a next page")
+	}
+	if got := strings.Join(titles, ", "); got != "Книга c-1, Книга c-2, Книга c-3, OPDS Test Book" {
+		t.Errorf("unexpected order: %s", got)
+	}
+
+	if feed := fetch("/opds/all"); len(feed.Entries) != 4 || link(feed, "self") != "http://localhost:9090/opds/all" {
+		t.Errorf("default page: %d entries, self %q", len(feed.Entries), link(feed, "self"))
+	}
+}
+
+// TestHandler_SortLinks verifies book feeds link their other orders as
+// facets and keep a chosen order across pages.
+func TestHandler_SortLinks(t *testing.T) {
+	h := setupTestOPDSHandler(t)
+	h.SetPageSize(2)
+	now := time.Now()
+	if err := h.repo.InsertBooks([]inpx.Book{
+		{ID: "s-1", Title: "Бесы", Authors: []string{"Достоевский Фёдор"}, Format: "fb2", Date: now.Add(-time.Hour)},
+		{ID: "s-2", Title: "Анна Каренина", Authors: []string{"Толстой Лев"}, Format: "fb2", Date: now.Add(time.Hour)},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	fetch := func(path string) (Feed, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.NewBooks(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var feed Feed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid feed: %v", path, err)
+		}
+		return feed, w.Body.String()
+	}
+	link := func(feed Feed, rel string) string {
+		for _, l := range feed.Links {
+			if l.Rel == rel {
+				return l.Href
+			}
+		}
+		return ""
+	}
+
+	feed, body := fetch("/opds/books/new")
+	for _, want := range []string{
+		`href="http://localhost:9090/opds/books/new" title="По умолчанию" opds:facetGroup="Сортировка" opds:activeFacet="true"`,
+		`href="http://localhost:9090/opds/books/new?sort=title" title="По названию" opds:facetGroup="Сортировка"`,
+		`rel="` + RelSortNew + `" type="` + TypeAcquisition + `" href="http://localhost:9090/opds/books/new?sort=new"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %s:\n%s", want, body)
+		}
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "Анна Каренина" {
+		t.Errorf("unexpected default order: %+v", feed.Entries)
+	}
+
+	feed, body = fetch("/opds/books/new?sort=title")
+	if !strings.Contains(body, `title="По названию" opds:facetGroup="Сортировка" opds:activeFacet="true"`) {
+		t.Errorf("chosen order is not the active facet:\n%s", body)
+	}
+	if self := link(feed, "self"); self != "http://localhost:9090/opds/books/new?sort=title" {
+		t.Errorf("unexpected self link %q", self)
+	}
+	next := link(feed, RelNext)
+	if next != "http://localhost:9090/opds/books/new?page=2&sort=title" {
+		t.Fatalf("unexpected next link %q", next)
+	}
+	titles := []string{feed.Entries[0].Title, feed.Entries[1].Title}
+	feed, _ = fetch(strings.TrimPrefix(next, "http://localhost:9090"))
+	for _, e := range feed.Entries {
+		titles = append(titles, e.Title)
+	}
+	if got := strings.Join(titles, ", "); got != "OPDS Test Book, Анна Каренина, Бесы" {
+		t.Errorf("unexpected order: %s", got)
+	}
+}
@@ -0,0 +1,124 @@
+package opds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// languageNames are the titles of the language sections of the root feed;
+// other languages are shown by code
+var languageNames = map[string]string{
+	"ru": "Русский",
+	"uk": "Українська",
+	"be": "Беларуская",
+	"en": "English",
+	"de": "Deutsch",
+	"fr": "Français",
+	"es": "Español",
+	"it": "Italiano",
+	"pl": "Polski",
+	"bg": "Български",
+	"cs": "Čeština",
+	"la": "Latina",
+}
+
+type languageKey struct{}
+
+// SetLanguagesEnabled toggles the language sections of the root feed and
+// the language-scoped catalogs under /opds/lang/{language}.
+func (h *Handler) SetLanguagesEnabled(enabled bool) {
+	h.languagesEnabled = enabled
+}
+
+// LanguagesEnabled reports whether the catalog is partitioned by language.
+func (h *Handler) LanguagesEnabled() bool {
+	return h.languagesEnabled
+}
+
+// LanguageScope is a middleware that scopes the catalog routes below it to
+// the {lang} URL parameter.
+func (h *Handler) LanguageScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language := strings.TrimSpace(chi.URLParam(r, "lang"))
+		if language == "" {
+			http.Error(w, "Invalid language", http.StatusBadRequest)
+			return
+		}
+		ctx := context.WithValue(r.Context(), languageKey{}, language)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// scopeLanguage returns the language the request is scoped to, if any
+func scopeLanguage(r *http.Request) string {
+	language, _ := r.Context().Value(languageKey{}).(string)
+	return language
+}
+
+// builderFor returns the feed builder for the request, scoped to its
+// language and listing the download format the reader prefers first
+func (h *Handler) builderFor(r *http.Request) *Builder {
+	b := h.builder()
+	if language := scopeLanguage(r); language != "" {
+		b = b.forLanguage(language)
+	}
+	if format := preferredFormat(r); format != "" {
+		b = b.withPreferredFormat(format)
+	}
+	return b
+}
+
+// forLanguage returns a copy of the builder whose links stay within the
+// catalog of language
+func (b *Builder) forLanguage(language string) *Builder {
+	scoped := *b
+	scoped.language = language
+	return &scoped
+}
+
+// languageTitle returns the display name of a language code
+func languageTitle(code string) string {
+	if name, ok := languageNames[strings.ToLower(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// addLanguageEntries adds a section per language to the root feed
+func (b *Builder) addLanguageEntries(feed *Feed, languages []storage.FacetCount) {
+	now := b.updatedAt()
+	entries := make([]Entry, 0, len(languages))
+	for _, lang := range languages {
+		href := b.forLanguage(lang.Value).catalogURL("")
+		entries = append(entries, Entry{
+			ID:      href,
+			Title:   languageTitle(lang.Value),
+			Updated: now,
+			Summary: fmt.Sprintf("Книг: %d", lang.Count),
+			Links: []Link{
+				{
+					Rel:  RelSubsection,
+					Type: TypeNavigation,
+					Href: href,
+				},
+			},
+		})
+	}
+	feed.Entries = append(entries, feed.Entries...)
+}
+
+// scopeRootFeed titles the root feed of a language catalog and links it up
+// to the whole catalog
+func (b *Builder) scopeRootFeed(feed *Feed) {
+	feed.Title = b.catalogTitle + " — " + languageTitle(b.language)
+	feed.Links = append(feed.Links, Link{
+		Rel:  RelUp,
+		Type: TypeNavigation,
+		Href: b.baseURL + "/opds",
+	})
+}
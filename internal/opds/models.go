@@ -7,22 +7,36 @@ import (
 
 // Feed represents OPDS Atom feed
 type Feed struct {
-	XMLName   xml.Name `xml:"feed"`
-	Xmlns     string   `xml:"xmlns,attr"`
-	XmlnsDC   string   `xml:"xmlns:dc,attr"`
-	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
-
-	ID      string    `xml:"id"`
-	Title   string    `xml:"title"`
-	Updated time.Time `xml:"updated"`
-	Icon    string    `xml:"icon,omitempty"`
+	XMLName         xml.Name `xml:"feed"`
+	Xmlns           string   `xml:"xmlns,attr"`
+	XmlnsDC         string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS       string   `xml:"xmlns:opds,attr"`
+	XmlnsThr        string   `xml:"xmlns:thr,attr,omitempty"`
+	XmlnsOpenSearch string   `xml:"xmlns:opensearch,attr,omitempty"`
+
+	ID       string    `xml:"id"`
+	Title    string    `xml:"title"`
+	Subtitle string    `xml:"subtitle,omitempty"`
+	Updated  time.Time `xml:"updated"`
+	Icon     string    `xml:"icon,omitempty"`
 
 	Author *Person `xml:"author,omitempty"`
 	Links  []Link  `xml:"link"`
 
+	// Paging of a paginated feed; see Builder.setPaging
+	*OpenSearch
+
 	Entries []Entry `xml:"entry"`
 }
 
+// OpenSearch holds the OpenSearch 1.1 response elements that tell clients
+// the size of a paginated feed
+type OpenSearch struct {
+	TotalResults int `xml:"opensearch:totalResults"`
+	StartIndex   int `xml:"opensearch:startIndex"`
+	ItemsPerPage int `xml:"opensearch:itemsPerPage"`
+}
+
 // Entry represents OPDS entry (book or navigation)
 type Entry struct {
 	ID      string    `xml:"id"`
@@ -36,8 +50,23 @@ type Entry struct {
 	Links      []Link     `xml:"link"`
 
 	// Dublin Core elements
-	Language string `xml:"dc:language,omitempty"`
-	Issued   string `xml:"dc:issued,omitempty"`
+	Identifier string `xml:"dc:identifier,omitempty"`
+	Language   string `xml:"dc:language,omitempty"`
+	Issued     string `xml:"dc:issued,omitempty"`
+
+	// Published is when a book was added to the library
+	Published *time.Time `xml:"published,omitempty"`
+}
+
+// EntryDocument is a standalone Atom entry, such as the complete catalog
+// entry of a book
+type EntryDocument struct {
+	XMLName   xml.Name `xml:"entry"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	XmlnsDC   string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
+
+	Entry
 }
 
 // Person represents author or contributor
@@ -54,6 +83,11 @@ type Link struct {
 	Title    string `xml:"title,attr,omitempty"`
 	HrefLang string `xml:"hreflang,attr,omitempty"`
 	Length   int64  `xml:"length,attr,omitempty"`
+
+	// OPDS 1.2 facet attributes
+	FacetGroup  string `xml:"opds:facetGroup,attr,omitempty"`
+	ActiveFacet bool   `xml:"opds:activeFacet,attr,omitempty"`
+	Count       int    `xml:"thr:count,attr,omitempty"`
 }
 
 // Category represents genre/category
@@ -66,29 +100,40 @@ type Category struct {
 type Content struct {
 	Type string `xml:"type,attr"`
 	Text string `xml:",chardata"`
+	// XHTML is the markup of type="xhtml" content, a single XHTML div
+	XHTML string `xml:",innerxml"`
 }
 
 // Constants for OPDS relations
 const (
 	// Navigation relations
-	RelStart       = "start"
-	RelUp          = "up"
-	RelNext        = "next"
-	RelPrev        = "prev"
-	RelSubsection  = "subsection"
-	RelSearch      = "search"
+	RelStart      = "start"
+	RelUp         = "up"
+	RelNext       = "next"
+	RelPrev       = "prev"
+	RelSubsection = "subsection"
+	RelSearch     = "search"
+	RelRelated    = "related"
 
 	// Acquisition relations
 	RelAcquisition     = "http://opds-spec.org/acquisition"
 	RelAcquisitionOpen = "http://opds-spec.org/acquisition/open-access"
 
+	// Facet relation (OPDS 1.2)
+	RelFacet = "http://opds-spec.org/facet"
+
+	// Complete acquisition feed for harvesters (OPDS 1.2)
+	RelCrawlable = "http://opds-spec.org/crawlable"
+
 	// Content types
-	TypeNavigation = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	TypeNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
 	TypeAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
 	TypeSearch      = "application/opensearchdescription+xml"
+	TypeEntry       = "application/atom+xml;type=entry;profile=opds-catalog"
 
 	// File types
-	TypeFB2  = "application/fb2+zip"
-	TypeEPUB = "application/epub+zip"
-	TypePDF  = "application/pdf"
-)
\ No newline at end of file
+	TypeFB2    = "application/fb2+zip"
+	TypeFB2XML = "application/x-fictionbook+xml"
+	TypeEPUB   = "application/epub+zip"
+	TypePDF    = "application/pdf"
+)
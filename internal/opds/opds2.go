@@ -0,0 +1,345 @@
+package opds
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/piligrim/pushkinlib/internal/covers"
+	"github.com/piligrim/pushkinlib/internal/metadata"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// OPDS 2.0 media types
+const (
+	TypeOPDS2 = "application/opds+json"
+
+	schemaBook = "http://schema.org/Book"
+)
+
+// opds2RootPreview is the number of newest books shown in the OPDS 2.0 root
+const opds2RootPreview = 10
+
+// Feed2 is an OPDS 2.0 feed
+type Feed2 struct {
+	Metadata     Feed2Metadata  `json:"metadata"`
+	Links        []Link2        `json:"links"`
+	Navigation   []Link2        `json:"navigation,omitempty"`
+	Facets       []Facet2       `json:"facets,omitempty"`
+	Publications []Publication2 `json:"publications"`
+}
+
+// Feed2Metadata describes an OPDS 2.0 feed
+type Feed2Metadata struct {
+	Title         string `json:"title"`
+	NumberOfItems int    `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+// Link2 is an OPDS 2.0 link object
+type Link2 struct {
+	Href       string           `json:"href"`
+	Type       string           `json:"type,omitempty"`
+	Rel        string           `json:"rel,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Templated  bool             `json:"templated,omitempty"`
+	Length     int64            `json:"length,omitempty"`
+	Properties *Link2Properties `json:"properties,omitempty"`
+}
+
+// Link2Properties holds link properties used by facets
+type Link2Properties struct {
+	NumberOfItems int `json:"numberOfItems,omitempty"`
+}
+
+// Facet2 is a group of OPDS 2.0 facet links
+type Facet2 struct {
+	Metadata Feed2Metadata `json:"metadata"`
+	Links    []Link2       `json:"links"`
+}
+
+// Publication2 is a book in an OPDS 2.0 feed
+type Publication2 struct {
+	Metadata PublicationMetadata `json:"metadata"`
+	Links    []Link2             `json:"links"`
+	Images   []Link2             `json:"images,omitempty"`
+}
+
+// PublicationMetadata is the Readium Web Publication metadata of a book
+type PublicationMetadata struct {
+	Type        string                 `json:"@type"`
+	Identifier  string                 `json:"identifier"`
+	Title       string                 `json:"title"`
+	Author      []Contributor2         `json:"author,omitempty"`
+	Language    string                 `json:"language,omitempty"`
+	Published   string                 `json:"published,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Subject     []Subject2             `json:"subject,omitempty"`
+	BelongsTo   *PublicationCollection `json:"belongsTo,omitempty"`
+}
+
+// Contributor2 is an author of a publication
+type Contributor2 struct {
+	Name string `json:"name"`
+}
+
+// Subject2 is a publication genre
+type Subject2 struct {
+	Name string `json:"name"`
+	Code string `json:"code,omitempty"`
+}
+
+// PublicationCollection lists the collections a publication belongs to
+type PublicationCollection struct {
+	Series []SeriesRef2 `json:"series,omitempty"`
+}
+
+// SeriesRef2 is a series a publication belongs to
+type SeriesRef2 struct {
+	Name     string `json:"name"`
+	Position int    `json:"position,omitempty"`
+}
+
+// SetOPDS2Enabled toggles the OPDS 2.0 catalog under /opds/v2.
+func (h *Handler) SetOPDS2Enabled(enabled bool) {
+	h.opds2Enabled = enabled
+}
+
+// OPDS2Enabled reports whether the OPDS 2.0 catalog is served.
+func (h *Handler) OPDS2Enabled() bool {
+	return h.opds2Enabled
+}
+
+// searchLink2 is the templated OPDS 2.0 search link
+func (b *Builder) searchLink2() Link2 {
+	return Link2{
+		Href:      b.baseURL + "/opds/v2/search{?query,format,language,sort}",
+		Type:      TypeOPDS2,
+		Rel:       RelSearch,
+		Templated: true,
+	}
+}
+
+// BuildRootFeed2 creates the OPDS 2.0 root with the newest books
+func (b *Builder) BuildRootFeed2(newest []storage.Book) *Feed2 {
+	root := b.baseURL + "/opds/v2"
+	feed := &Feed2{
+		Metadata: Feed2Metadata{Title: b.catalogTitle},
+		Links: []Link2{
+			{Href: root, Type: TypeOPDS2, Rel: "self"},
+			{Href: root, Type: TypeOPDS2, Rel: RelStart},
+			{Href: b.baseURL + "/opds", Type: TypeNavigation, Rel: "alternate", Title: "OPDS 1.2"},
+			b.searchLink2(),
+		},
+		Navigation: []Link2{
+			{Href: b.baseURL + "/opds/v2/books/new", Type: TypeOPDS2, Title: "Новые поступления"},
+			{Href: b.baseURL + "/opds/v2/search", Type: TypeOPDS2, Title: "Все книги"},
+		},
+	}
+	feed.Publications = b.publications2(newest)
+	return feed
+}
+
+// BuildBooksFeed2 creates a paginated OPDS 2.0 feed of books. selfURL must
+// not contain a page parameter for the first page.
+func (b *Builder) BuildBooksFeed2(books []storage.Book, title, selfURL string, page, pageSize, total int) *Feed2 {
+	feed := &Feed2{
+		Metadata: Feed2Metadata{
+			Title:         title,
+			NumberOfItems: total,
+			ItemsPerPage:  pageSize,
+			CurrentPage:   page,
+		},
+		Links: []Link2{
+			{Href: selfURL, Type: TypeOPDS2, Rel: "self"},
+			{Href: b.baseURL + "/opds/v2", Type: TypeOPDS2, Rel: RelStart},
+			b.searchLink2(),
+		},
+	}
+
+	if page > 1 {
+		feed.Links = append(feed.Links,
+			Link2{Href: b.buildPageURL(selfURL, 1), Type: TypeOPDS2, Rel: "first"},
+			Link2{Href: b.buildPageURL(selfURL, page-1), Type: TypeOPDS2, Rel: RelPrev},
+		)
+	}
+	if page*pageSize < total {
+		feed.Links = append(feed.Links, Link2{Href: b.buildPageURL(selfURL, page+1), Type: TypeOPDS2, Rel: RelNext})
+	}
+
+	feed.Publications = b.publications2(books)
+	return feed
+}
+
+// addFacets2 adds OPDS 2.0 facet groups to a search feed
+func (b *Builder) addFacets2(feed *Feed2, p searchParams, groups []facetGroup) {
+	for _, group := range groups {
+		facet := Facet2{Metadata: Feed2Metadata{Title: group.Title}}
+		for _, option := range group.Options {
+			link := Link2{
+				Href:  b.searchURL("/v2/search", "query", p.with(group.Param, option.Value)),
+				Type:  TypeOPDS2,
+				Title: option.Title,
+			}
+			if option.Active {
+				link.Rel = "self"
+			}
+			if option.Count > 0 {
+				link.Properties = &Link2Properties{NumberOfItems: option.Count}
+			}
+			facet.Links = append(facet.Links, link)
+		}
+		feed.Facets = append(feed.Facets, facet)
+	}
+}
+
+func (b *Builder) publications2(books []storage.Book) []Publication2 {
+	publications := make([]Publication2, 0, len(books))
+	for _, book := range books {
+		publications = append(publications, b.bookToPublication(book))
+	}
+	return publications
+}
+
+// bookToPublication converts a storage.Book to an OPDS 2.0 publication
+func (b *Builder) bookToPublication(book storage.Book) Publication2 {
+	pub := Publication2{
+		Metadata: PublicationMetadata{
+			Type:        schemaBook,
+			Identifier:  b.baseURL + "/opds/books/" + book.ID,
+			Title:       book.Title,
+			Language:    book.Language,
+			Description: metadata.AnnotationText(book.Annotation),
+		},
+	}
+	for _, acq := range b.acquisitions(book) {
+		pub.Links = append(pub.Links, Link2{
+			Href:   acq.Href,
+			Type:   acq.Type,
+			Rel:    RelAcquisitionOpen,
+			Length: acq.Length,
+		})
+	}
+
+	for _, author := range book.Authors {
+		pub.Metadata.Author = append(pub.Metadata.Author, Contributor2{Name: author.Name})
+	}
+	if book.Year > 0 {
+		pub.Metadata.Published = strconv.Itoa(book.Year)
+	}
+	for _, genre := range bookGenres(book) {
+		pub.Metadata.Subject = append(pub.Metadata.Subject, Subject2{Name: b.genreLabel(genre.Name), Code: genre.Name})
+	}
+	if book.Series != nil {
+		pub.Metadata.BelongsTo = &PublicationCollection{
+			Series: []SeriesRef2{{Name: book.Series.Name, Position: book.SeriesNum}},
+		}
+	}
+	for _, series := range book.OtherSeries {
+		if pub.Metadata.BelongsTo == nil {
+			pub.Metadata.BelongsTo = &PublicationCollection{}
+		}
+		pub.Metadata.BelongsTo.Series = append(pub.Metadata.BelongsTo.Series, SeriesRef2{Name: series.Name, Position: series.Num})
+	}
+	if book.HasCover {
+		pub.Images = []Link2{{Href: covers.URL(b.baseURL, book.ID, book.CoverHash), Type: "image/jpeg"}}
+	}
+
+	return pub
+}
+
+// Root2 serves the OPDS 2.0 root catalog
+func (h *Handler) Root2(w http.ResponseWriter, r *http.Request) {
+	result, err := h.searchBooks(r, storage.BookFilter{
+		Limit:     opds2RootPreview,
+		SortBy:    "date_added",
+		SortOrder: "desc",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeFeed2(w, r, h.builderFor(r).BuildRootFeed2(result.Books))
+}
+
+// NewBooks2 serves newest books as an OPDS 2.0 feed
+func (h *Handler) NewBooks2(w http.ResponseWriter, r *http.Request) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+	result, err := h.searchBooks(r, storage.BookFilter{
+		Limit:     pageSize,
+		Offset:    (page - 1) * pageSize,
+		SortBy:    "date_added",
+		SortOrder: "desc",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b := h.builderFor(r)
+	selfURL := b.baseURL + "/opds/v2/books/new"
+	if page > 1 {
+		selfURL = b.buildPageURL(selfURL, page)
+	}
+	feed := b.BuildBooksFeed2(result.Books, "Новые поступления", selfURL, page, pageSize, result.Total)
+	h.writeFeed2(w, r, feed)
+}
+
+// SearchBooks2 handles OPDS 2.0 search with format and language facets
+func (h *Handler) SearchBooks2(w http.ResponseWriter, r *http.Request) {
+	params := h.parseSearchParams(r, "query")
+
+	result, facets, err := h.search(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := searchTitle(params, result, "Все книги")
+	b := h.builderFor(r)
+	selfURL := b.searchURL("/v2/search", "query", params)
+	feed := b.BuildBooksFeed2(result.Books, title, selfURL, params.Page, params.PageSize, result.Total)
+	b.addFacets2(feed, params, facets)
+	for _, suggestion := range result.Suggestions {
+		corrected := params
+		corrected.Query, corrected.Page = suggestion, 1
+		feed.Navigation = append(feed.Navigation, Link2{
+			Href:  b.searchURL("/v2/search", "query", corrected),
+			Type:  TypeOPDS2,
+			Rel:   "related",
+			Title: "Возможно, вы имели в виду: " + suggestion,
+		})
+	}
+	h.writeFeed2(w, r, feed)
+}
+
+// writeFeed2 writes an OPDS 2.0 feed as JSON
+func (h *Handler) writeFeed2(w http.ResponseWriter, r *http.Request, feed *Feed2) {
+	if h.authEnabled {
+		feed.Links = append(feed.Links, Link2{
+			Href: h.builder().AuthDocumentURL(),
+			Type: TypeAuthDocument,
+			Rel:  RelAuthDocument,
+		})
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		http.Error(w, "Failed to encode feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", TypeOPDS2+"; charset=utf-8")
+	h.setCacheHeaders(w, r)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("writeFeed2: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package opds
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// setupFacetTestHandler creates a handler over books in two formats and
+// two languages.
+func setupFacetTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := storage.NewRepository(db)
+	added := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	books := []inpx.Book{
+		{ID: "b1", Title: "Капитанская дочка", Authors: []string{"Александр Пушкин"}, Genre: "prose_classic",
+			Series: "Повести", SeriesNum: 2, Year: 1836, Language: "ru", Format: "fb2", FileSize: 2048,
+			Annotation: "Исторический роман", Date: added},
+		{ID: "b2", Title: "Дубровский", Authors: []string{"Александр Пушкин"}, Genre: "prose_classic",
+			Year: 1841, Language: "ru", Format: "epub", FileSize: 4096, Date: added.Add(time.Hour)},
+		{ID: "b3", Title: "The Captain's Daughter", Authors: []string{"Александр Пушкин"}, Genre: "prose_classic",
+			Year: 1836, Language: "en", Format: "fb2", FileSize: 1024, Date: added.Add(2 * time.Hour)},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	h := NewHandler(repo, "http://localhost:9090", "Test Catalog", map[string]string{"prose_classic": "Классика"})
+	h.SetOPDS2Enabled(true)
+	return h
+}
+
+// updatedRe matches Atom timestamps, which depend on insertion time
+var updatedRe = regexp.MustCompile(`<updated>[^<]*</updated>`)
+
+// assertGolden compares got with testdata/name, rewriting it with -update.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -update to create): %v", err)
+	}
+	if string(want) != string(got) {
+		t.Errorf("%s mismatch (run with -update to accept)\n--- got:\n%s", name, got)
+	}
+}
+
+// validateOPDS2Feed checks a feed against the required members of the
+// OPDS 2.0 feed, link, facet and publication JSON schemas.
+func validateOPDS2Feed(t *testing.T, data []byte) {
+	t.Helper()
+	var feed map[string]interface{}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		t.Fatalf("feed is not valid JSON: %v", err)
+	}
+
+	metadata, ok := feed["metadata"].(map[string]interface{})
+	if !ok || metadata["title"] == nil {
+		t.Error("feed: metadata.title is required")
+	}
+
+	links, _ := feed["links"].([]interface{})
+	hasSelf := false
+	for _, l := range links {
+		link := validateOPDS2Link(t, "feed link", l)
+		if link["rel"] == "self" {
+			hasSelf = true
+		}
+		if templated, _ := link["templated"].(bool); templated && !strings.Contains(link["href"].(string), "{?") {
+			t.Errorf("feed link: templated href has no template: %v", link["href"])
+		}
+	}
+	if !hasSelf {
+		t.Error("feed: a self link is required")
+	}
+
+	_, hasNav := feed["navigation"]
+	_, hasPubs := feed["publications"]
+	_, hasGroups := feed["groups"]
+	if !hasNav && !hasPubs && !hasGroups {
+		t.Error("feed: one of navigation, publications or groups is required")
+	}
+
+	if nav, ok := feed["navigation"].([]interface{}); ok {
+		for _, l := range nav {
+			if validateOPDS2Link(t, "navigation", l)["title"] == nil {
+				t.Error("navigation: links must have a title")
+			}
+		}
+	}
+
+	facets, _ := feed["facets"].([]interface{})
+	for _, f := range facets {
+		facet, _ := f.(map[string]interface{})
+		if md, ok := facet["metadata"].(map[string]interface{}); !ok || md["title"] == nil {
+			t.Error("facet: metadata.title is required")
+		}
+		facetLinks, _ := facet["links"].([]interface{})
+		if len(facetLinks) == 0 {
+			t.Error("facet: at least one link is required")
+		}
+		for _, l := range facetLinks {
+			validateOPDS2Link(t, "facet link", l)
+		}
+	}
+
+	pubs, _ := feed["publications"].([]interface{})
+	for _, p := range pubs {
+		pub, _ := p.(map[string]interface{})
+		md, ok := pub["metadata"].(map[string]interface{})
+		if !ok || md["title"] == nil || md["@type"] == nil {
+			t.Error("publication: metadata with @type and title is required")
+		}
+		pubLinks, _ := pub["links"].([]interface{})
+		hasAcquisition := false
+		for _, l := range pubLinks {
+			rel, _ := validateOPDS2Link(t, "publication link", l)["rel"].(string)
+			if strings.HasPrefix(rel, "http://opds-spec.org/acquisition") {
+				hasAcquisition = true
+			}
+		}
+		if !hasAcquisition {
+			t.Error("publication: an acquisition link is required")
+		}
+	}
+}
+
+func validateOPDS2Link(t *testing.T, where string, l interface{}) map[string]interface{} {
+	t.Helper()
+	link, ok := l.(map[string]interface{})
+	if !ok {
+		t.Errorf("%s: not an object", where)
+		return map[string]interface{}{}
+	}
+	if href, _ := link["href"].(string); href == "" {
+		t.Errorf("%s: href is required", where)
+	}
+	if typ, _ := link["type"].(string); typ == "" {
+		t.Errorf("%s: type is expected on every link", where)
+	}
+	return link
+}
+
+func serveOPDS2(t *testing.T, handler http.HandlerFunc, target string) []byte {
+	t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d: %s", target, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, TypeOPDS2) {
+		t.Errorf("%s: unexpected Content-Type %q", target, ct)
+	}
+	return w.Body.Bytes()
+}
+
+// TestOPDS2_Root checks the root feed and its templated search link.
+func TestOPDS2_Root(t *testing.T) {
+	h := setupFacetTestHandler(t)
+
+	body := serveOPDS2(t, h.Root2, "/opds/v2")
+	validateOPDS2Feed(t, body)
+	assertGolden(t, "opds2_root.json", body)
+}
+
+// TestOPDS2_SearchFacets checks search results with format and language facets.
+func TestOPDS2_SearchFacets(t *testing.T) {
+	h := setupFacetTestHandler(t)
+
+	body := serveOPDS2(t, h.SearchBooks2, "/opds/v2/search?query=Пушкин&format=fb2")
+	validateOPDS2Feed(t, body)
+	assertGolden(t, "opds2_search.json", body)
+
+	var feed Feed2
+	if err := json.Unmarshal(body, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Publications) != 2 || feed.Metadata.NumberOfItems != 2 {
+		t.Errorf("expected 2 fb2 books, got %d (numberOfItems %d)", len(feed.Publications), feed.Metadata.NumberOfItems)
+	}
+}
+
+// TestOPDS2_SearchInvalidQuery verifies unusable queries yield a valid empty feed.
+func TestOPDS2_SearchInvalidQuery(t *testing.T) {
+	h := setupFacetTestHandler(t)
+
+	body := serveOPDS2(t, h.SearchBooks2, "/opds/v2/search?query=%2A%2A")
+	validateOPDS2Feed(t, body)
+	if !strings.Contains(string(body), `"publications": []`) {
+		t.Errorf("expected empty publications, got %s", body)
+	}
+}
+
+// TestSearch_AtomFacets checks Atom search facet links match the OPDS 2.0 ones.
+func TestSearch_AtomFacets(t *testing.T) {
+	h := setupFacetTestHandler(t)
+
+	req := httptest.NewRequest("GET", "/opds/search?q=Пушкин&format=fb2", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := updatedRe.ReplaceAll(w.Body.Bytes(), []byte("<updated>-</updated>"))
+	assertGolden(t, "atom_search.xml", body)
+}
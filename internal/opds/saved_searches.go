@@ -0,0 +1,124 @@
+package opds
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/auth"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// savedSearchesTitle is the title of the section of the reader's saved
+// searches
+const savedSearchesTitle = "Мои подборки"
+
+// SavedSearches serves the saved searches of the reader, each with the
+// number of its new books if the reader asked to be notified (navigation)
+func (h *Handler) SavedSearches(w http.ResponseWriter, r *http.Request) {
+	searches, err := h.repo.ListSavedSearches(auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hidden, err := h.restrictions(r)
+	if err == nil {
+		err = h.repo.CountNewBooks(searches, hidden)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeFeed(w, r, h.builderFor(r).BuildSavedSearchesFeed(searches))
+}
+
+// SavedSearchBooks runs a saved search of the reader. Opening its first
+// page resets its new books.
+func (h *Handler) SavedSearchBooks(w http.ResponseWriter, r *http.Request) {
+	search, err := h.repo.GetSavedSearch(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if search == nil || search.UserID != auth.UserIDFromContext(r.Context()) {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := search.Filter
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page <= 1 {
+		if err := h.repo.MarkSavedSearchSeen(search); err != nil {
+			log.Printf("OPDS saved search: %v", err)
+		}
+	}
+
+	b := h.builderFor(r)
+	feed := b.BuildBooksFeed(result.Books, search.Name, b.buildPageURL(b.savedSearchURL(search.ID), page), page, pageSize, result.Total)
+	b.addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// savedSearchURL returns the URL of the books of a saved search
+func (b *Builder) savedSearchURL(id string) string {
+	return b.catalogURL("/saved/" + url.PathEscape(id))
+}
+
+// savedSearchesRootEntry links the root catalog to the reader's saved
+// searches
+func (b *Builder) savedSearchesRootEntry(count int) Entry {
+	return Entry{
+		ID:      b.catalogURL("/saved"),
+		Title:   savedSearchesTitle,
+		Updated: b.updatedAt(),
+		Summary: fmt.Sprintf("Сохранённых поисков: %d", count),
+		Links: []Link{
+			{
+				Rel:  RelSubsection,
+				Type: TypeNavigation,
+				Href: b.catalogURL("/saved"),
+			},
+		},
+	}
+}
+
+// BuildSavedSearchesFeed creates a navigation feed listing saved searches
+func (b *Builder) BuildSavedSearchesFeed(searches []storage.SavedSearch) *Feed {
+	feed, _, _, now := b.newNavigationFeed(savedSearchesTitle, "/saved", 1, len(searches), len(searches))
+	for _, search := range searches {
+		href := b.savedSearchURL(search.ID)
+		entry := Entry{
+			ID:      href,
+			Title:   search.Name,
+			Updated: now,
+			Links: []Link{
+				{
+					Rel:  RelSubsection,
+					Type: TypeAcquisition,
+					Href: href,
+				},
+			},
+		}
+		if search.NewBooks > 0 {
+			entry.Summary = fmt.Sprintf("Новых книг: %d", search.NewBooks)
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
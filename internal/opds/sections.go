@@ -0,0 +1,83 @@
+package opds
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/sections"
+)
+
+// SetSections adds sections configured by admins to the root catalog. It
+// must be called before requests are served.
+func (h *Handler) SetSections(list []sections.Section) {
+	h.sections = list
+}
+
+// Section serves the books of a configured section, in the order of its
+// filter unless the reader picks another
+func (h *Handler) Section(w http.ResponseWriter, r *http.Request) {
+	section := sections.Find(h.sections, chi.URLParam(r, "id"))
+	if section == nil || (scopeLanguage(r) != "" && !section.InLanguage(scopeLanguage(r))) {
+		http.Error(w, "Section not found", http.StatusNotFound)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := section.Filter
+	filter.Limit = pageSize
+	filter.Offset = (page - 1) * pageSize
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feedID := h.builderFor(r).sectionURL(section.ID)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, section.Title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// sectionURL returns the URL of the feed of a configured section
+func (b *Builder) sectionURL(id string) string {
+	return b.catalogURL("/sections/" + url.PathEscape(id))
+}
+
+// sectionRootEntries links the root catalog to the configured sections. A
+// language catalog leaves out sections of other languages.
+func (b *Builder) sectionRootEntries(list []sections.Section) []Entry {
+	now := b.updatedAt()
+	var entries []Entry
+	for _, section := range list {
+		if b.language != "" && !section.InLanguage(b.language) {
+			continue
+		}
+		href := b.sectionURL(section.ID)
+		entries = append(entries, Entry{
+			ID:      href,
+			Title:   section.Title,
+			Updated: now,
+			Summary: section.Description,
+			Links: []Link{
+				{
+					Rel:  RelSubsection,
+					Type: TypeAcquisition,
+					Href: href,
+				},
+			},
+		})
+	}
+	return entries
+}
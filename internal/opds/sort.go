@@ -0,0 +1,121 @@
+package opds
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// RelSortNew links a feed to its own books ordered newest first (OPDS 1.2)
+const RelSortNew = "http://opds-spec.org/sort/new"
+
+// sortFacetGroupTitle is the title of the facet group of feed orders
+const sortFacetGroupTitle = "Сортировка"
+
+// feedOrder is an order the books of a feed can be listed in
+type feedOrder struct {
+	value, title      string
+	sortBy, sortOrder string
+}
+
+// feedOrders are the orders offered in book feeds, chosen with the sort
+// query parameter. Without it a feed keeps its own order.
+var feedOrders = []feedOrder{
+	{"new", "Сначала новые", "date_added", "desc"},
+	{"title", "По названию", "title", "asc"},
+	{"author", "По автору", "author", "asc"},
+}
+
+// requestedOrder returns the sort parameter of a request if it names one
+// of feedOrders, or ""
+func requestedOrder(r *http.Request) string {
+	value := r.URL.Query().Get("sort")
+	for _, order := range feedOrders {
+		if order.value == value {
+			return value
+		}
+	}
+	return ""
+}
+
+// applyOrder sorts filter in the order named value, or leaves it as is
+func applyOrder(filter *storage.BookFilter, value string) {
+	for _, order := range feedOrders {
+		if order.value == value {
+			filter.SortBy, filter.SortOrder = order.sortBy, order.sortOrder
+			return
+		}
+	}
+}
+
+// orderFacetGroup is the facet group that reorders a feed, with the
+// feed's own order first
+func orderFacetGroup(active, defaultTitle string) facetGroup {
+	group := facetGroup{
+		Title:   sortFacetGroupTitle,
+		Param:   "sort",
+		Options: []facetOption{{Title: defaultTitle, Active: active == ""}},
+	}
+	for _, order := range feedOrders {
+		group.Options = append(group.Options, facetOption{Title: order.title, Value: order.value, Active: order.value == active})
+	}
+	return group
+}
+
+// withOrder sets the sort parameter of a feed URL, or removes it if value
+// is empty
+func withOrder(feedURL, value string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return feedURL
+	}
+	q := u.Query()
+	if value != "" {
+		q.Set("sort", value)
+	} else {
+		q.Del("sort")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// addOrderLinks adds facet links that reorder an acquisition feed, and a
+// sort/new link, and keeps the order named active in its own and paging
+// links. Searches carry the order in their facets instead, see
+// orderFacetGroup.
+func (b *Builder) addOrderLinks(feed *Feed, active string) {
+	var self string
+	for i, link := range feed.Links {
+		switch link.Rel {
+		case "self", RelPrev, RelNext:
+			if active != "" {
+				feed.Links[i].Href = withOrder(link.Href, active)
+			}
+			if link.Rel == "self" {
+				self = link.Href
+			}
+		}
+	}
+	if active != "" {
+		feed.ID = withOrder(feed.ID, active)
+	}
+
+	// Reordering starts over from the first page
+	first := b.buildPageURL(self, 1)
+	for _, option := range orderFacetGroup(active, "По умолчанию").Options {
+		feed.Links = append(feed.Links, Link{
+			Rel:         RelFacet,
+			Type:        TypeAcquisition,
+			Href:        withOrder(first, option.Value),
+			Title:       option.Title,
+			FacetGroup:  sortFacetGroupTitle,
+			ActiveFacet: option.Active,
+		})
+	}
+	feed.Links = append(feed.Links, Link{
+		Rel:  RelSortNew,
+		Type: TypeAcquisition,
+		Href: withOrder(first, "new"),
+	})
+}
@@ -0,0 +1,40 @@
+package opds
+
+import (
+	"net/http"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// topAuthorsTitle is the title of the list of the most prolific authors
+const topAuthorsTitle = "Авторы с наибольшим числом книг"
+
+// TopAuthors serves the authors with the most books, most first, as ranked
+// at the last import (navigation)
+func (h *Handler) TopAuthors(w http.ResponseWriter, r *http.Request) {
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+	if page < 1 {
+		page = 1
+	}
+
+	authors, total, err := h.repo.ListTopAuthors(scopeLanguage(r), pageSize, (page-1)*pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feed := h.builderFor(r).BuildTopAuthorsFeed(authors, page, total, pageSize)
+	if h.authorInfo != nil {
+		for i, author := range authors {
+			h.builderFor(r).applyAuthorInfo(&feed.Entries[i], author.ID, h.authorInfo.Cached(author.Name))
+		}
+	}
+	h.writeFeed(w, r, feed)
+}
+
+// BuildTopAuthorsFeed creates a navigation feed listing the authors with
+// the most books, at most storage.TopAuthorsLimit of them
+func (b *Builder) BuildTopAuthorsFeed(authors []storage.Author, page, totalAuthors, pageSize int) *Feed {
+	return b.buildAuthorList(topAuthorsTitle, "/authors/top", authors, page, totalAuthors, pageSize)
+}
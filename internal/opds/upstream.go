@@ -0,0 +1,348 @@
+package opds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// UpstreamCatalogs gives access to external OPDS catalogs aggregated into
+// this one. Their crawled entries are read from the repository.
+type UpstreamCatalogs interface {
+	// Proxied reports whether downloads from a source go through this server
+	Proxied(sourceID string) bool
+	// Fetch requests a file of a source, with its credentials if any
+	Fetch(ctx context.Context, sourceID, href string) (*http.Response, error)
+}
+
+// SetUpstreamCatalogs enables the /opds/upstreams section and federated
+// search. It must be called before the routes are registered.
+func (h *Handler) SetUpstreamCatalogs(upstreams UpstreamCatalogs) {
+	h.upstreams = upstreams
+}
+
+// UpstreamsEnabled reports whether external catalogs are aggregated
+func (h *Handler) UpstreamsEnabled() bool {
+	return h.upstreams != nil
+}
+
+// proxiedHeaders are copied from upstream download responses
+var proxiedHeaders = []string{"Content-Type", "Content-Length", "Content-Disposition", "Last-Modified", "ETag"}
+
+// Upstreams serves the list of external catalogs (navigation)
+func (h *Handler) Upstreams(w http.ResponseWriter, r *http.Request) {
+	sources, err := h.repo.ListUpstreamSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeFeed(w, r, h.builder().BuildUpstreamsFeed(sources))
+}
+
+// UpstreamBooks serves the books crawled from one external catalog
+func (h *Handler) UpstreamBooks(w http.ResponseWriter, r *http.Request) {
+	source, err := h.repo.GetUpstreamSource(chi.URLParam(r, "source"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		http.Error(w, "Catalog not found", http.StatusNotFound)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+	entries, total, err := h.repo.ListUpstreamEntries(source.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feedID := h.builder().baseURL + "/opds/upstreams/" + url.PathEscape(source.ID)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+	feed := h.builder().BuildBooksFeed(nil, source.Title, feedID, page, pageSize, total)
+	h.builder().setUpLink(feed, h.builder().baseURL+"/opds/upstreams")
+	for _, entry := range entries {
+		feed.Entries = append(feed.Entries, h.builder().upstreamEntryToEntry(entry, "", h.upstreams.Proxied(entry.SourceID)))
+	}
+	h.writeFeed(w, r, feed)
+}
+
+// SearchUpstreams runs a federated search over all external catalogs, with
+// a facet per catalog
+func (h *Handler) SearchUpstreams(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	sourceID := r.URL.Query().Get("source")
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	entries, total, err := h.repo.SearchUpstreamEntries(query, sourceID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counts, err := h.repo.CountUpstreamMatches(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sources, err := h.repo.ListUpstreamSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	titles := make(map[string]string, len(sources))
+	for _, src := range sources {
+		titles[src.ID] = src.Title
+	}
+
+	title := "Поиск во внешних каталогах"
+	if query != "" {
+		title = fmt.Sprintf("Внешние каталоги: %s", query)
+	}
+	feed := h.builder().BuildBooksFeed(nil, title, h.builder().upstreamSearchURL(query, sourceID, page), page, pageSize, total)
+	h.builder().setUpLink(feed, h.builder().baseURL+"/opds/upstreams")
+	h.builder().addUpstreamFacetLinks(feed, query, sourceID, sources, counts)
+	for _, entry := range entries {
+		feed.Entries = append(feed.Entries, h.builder().upstreamEntryToEntry(entry, titles[entry.SourceID], h.upstreams.Proxied(entry.SourceID)))
+	}
+	h.writeFeed(w, r, feed)
+}
+
+// UpstreamDownload proxies a file or image of an external catalog entry.
+// Only sources configured for proxying are served.
+func (h *Handler) UpstreamDownload(w http.ResponseWriter, r *http.Request) {
+	sourceID := chi.URLParam(r, "source")
+	if !h.upstreams.Proxied(sourceID) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	entry, err := h.repo.GetUpstreamEntry(sourceID, chi.URLParam(r, "key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if entry == nil || err != nil || index < 0 || index >= len(entry.Links) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	resp, err := h.upstreams.Fetch(r.Context(), sourceID, entry.Links[index].Href)
+	if err != nil {
+		log.Printf("UpstreamDownload: %s: %v", sourceID, err)
+		http.Error(w, "Upstream catalog is unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("UpstreamDownload: %s returned HTTP %d", entry.Links[index].Href, resp.StatusCode)
+		http.Error(w, "Upstream catalog returned an error", http.StatusBadGateway)
+		return
+	}
+
+	for _, header := range proxiedHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("UpstreamDownload: %s: %v", sourceID, err)
+	}
+}
+
+// addUpstreamMatches adds an entry to a local search feed linking to the
+// federated search when external catalogs have matches too
+func (h *Handler) addUpstreamMatches(feed *Feed, p searchParams) {
+	if h.upstreams == nil || p.Query == "" || p.Page > 1 {
+		return
+	}
+	counts, err := h.repo.CountUpstreamMatches(p.Query)
+	if err != nil {
+		log.Printf("SearchBooks: failed to count upstream matches: %v", err)
+		return
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+
+	href := h.builder().upstreamSearchURL(p.Query, "", 1)
+	feed.Entries = append(feed.Entries, Entry{
+		ID:      href,
+		Title:   fmt.Sprintf("Найдено во внешних каталогах: %d", total),
+		Updated: time.Now(),
+		Summary: "Результаты по запросу «" + p.Query + "» в подключённых каталогах",
+		Links: []Link{
+			{
+				Rel:  RelSubsection,
+				Type: TypeAcquisition,
+				Href: href,
+			},
+		},
+	})
+}
+
+// upstreamsRootEntry links the root catalog to the external catalogs
+func (b *Builder) upstreamsRootEntry() Entry {
+	return Entry{
+		ID:      b.baseURL + "/opds/upstreams",
+		Title:   "Внешние каталоги",
+		Updated: time.Now(),
+		Summary: "Книги из подключённых OPDS-каталогов",
+		Links: []Link{
+			{
+				Rel:  RelSubsection,
+				Type: TypeNavigation,
+				Href: b.baseURL + "/opds/upstreams",
+			},
+		},
+	}
+}
+
+// BuildUpstreamsFeed creates a navigation feed listing external catalogs
+func (b *Builder) BuildUpstreamsFeed(sources []storage.UpstreamSource) *Feed {
+	feed, _, _, now := b.newNavigationFeed("Внешние каталоги", "/upstreams", 1, len(sources), len(sources))
+	feed.Links = append(feed.Links, Link{
+		Rel:  RelSearch,
+		Type: TypeAcquisition,
+		Href: b.baseURL + "/opds/upstreams/search?q={searchTerms}",
+	})
+
+	for _, src := range sources {
+		sourceURL := b.baseURL + "/opds/upstreams/" + url.PathEscape(src.ID)
+		summary := fmt.Sprintf("Книг: %d", src.Entries)
+		if src.CrawledAt == nil {
+			summary = "Каталог ещё не загружен"
+		}
+		updated := now
+		if src.CrawledAt != nil {
+			updated = *src.CrawledAt
+		}
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      sourceURL,
+			Title:   src.Title,
+			Updated: updated,
+			Summary: summary,
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeAcquisition,
+					Href:  sourceURL,
+					Title: src.Title,
+				},
+			},
+		})
+	}
+
+	return feed
+}
+
+// upstreamEntryToEntry converts a crawled entry. Links point to the
+// upstream, or to the download proxy for proxied sources. sourceTitle is
+// shown in the content when results of several catalogs are mixed.
+func (b *Builder) upstreamEntryToEntry(e storage.UpstreamEntry, sourceTitle string, proxied bool) Entry {
+	entry := Entry{
+		ID:       e.EntryID,
+		Title:    e.Title,
+		Updated:  e.Updated,
+		Summary:  e.Summary,
+		Language: e.Language,
+	}
+	for _, name := range e.Authors {
+		entry.Authors = append(entry.Authors, Person{Name: name})
+	}
+
+	for i, link := range e.Links {
+		href := link.Href
+		if proxied {
+			href = fmt.Sprintf("%s/opds/upstreams/%s/download/%s/%d", b.baseURL, url.PathEscape(e.SourceID), e.Key, i)
+		}
+		entry.Links = append(entry.Links, Link{Rel: link.Rel, Type: link.Type, Href: href, Title: link.Title})
+	}
+
+	if sourceTitle != "" {
+		content := "Каталог: " + sourceTitle
+		if e.Summary != "" {
+			content = e.Summary + "\n\n" + content
+		}
+		entry.Content = &Content{Type: "text", Text: content}
+	}
+
+	return entry
+}
+
+// upstreamSearchURL builds an absolute federated search URL
+func (b *Builder) upstreamSearchURL(query, sourceID string, page int) string {
+	values := url.Values{}
+	if query != "" {
+		values.Set("q", query)
+	}
+	if sourceID != "" {
+		values.Set("source", sourceID)
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+
+	u := b.baseURL + "/opds/upstreams/search"
+	if encoded := values.Encode(); encoded != "" {
+		u += "?" + encoded
+	}
+	return u
+}
+
+// addUpstreamFacetLinks adds a "Каталог" facet group to a federated search feed
+func (b *Builder) addUpstreamFacetLinks(feed *Feed, query, active string, sources []storage.UpstreamSource, counts map[string]int) {
+	feed.XmlnsThr = "http://purl.org/syndication/thread/1.0"
+	const group = "Каталог"
+
+	feed.Links = append(feed.Links, Link{
+		Rel:         RelFacet,
+		Type:        TypeAcquisition,
+		Href:        b.upstreamSearchURL(query, "", 1),
+		Title:       "Все",
+		FacetGroup:  group,
+		ActiveFacet: active == "",
+	})
+	for _, src := range sources {
+		if counts[src.ID] == 0 && src.ID != active {
+			continue
+		}
+		feed.Links = append(feed.Links, Link{
+			Rel:         RelFacet,
+			Type:        TypeAcquisition,
+			Href:        b.upstreamSearchURL(query, src.ID, 1),
+			Title:       src.Title,
+			FacetGroup:  group,
+			ActiveFacet: src.ID == active,
+			Count:       counts[src.ID],
+		})
+	}
+}
+
+// setUpLink points the feed's rel=up link at href
+func (b *Builder) setUpLink(feed *Feed, href string) {
+	for i := range feed.Links {
+		if feed.Links[i].Rel == RelUp {
+			feed.Links[i].Type = TypeNavigation
+			feed.Links[i].Href = href
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package opds
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// fakeUpstreams proxies the "private" source and serves fixed content
+type fakeUpstreams struct {
+	fetched []string
+}
+
+func (f *fakeUpstreams) Proxied(sourceID string) bool { return sourceID == "private" }
+
+func (f *fakeUpstreams) Fetch(ctx context.Context, sourceID, href string) (*http.Response, error) {
+	f.fetched = append(f.fetched, href)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/epub+zip"}},
+		Body:       io.NopCloser(strings.NewReader("epub")),
+	}, nil
+}
+
+func setupUpstreamTestHandler(t *testing.T) (*Handler, *fakeUpstreams) {
+	t.Helper()
+	h := setupFacetTestHandler(t)
+	repo := h.repo
+
+	if err := repo.SetUpstreamSources([]storage.UpstreamSource{
+		{ID: "public", Title: "Открытый каталог", URL: "https://public.example.org/opds"},
+		{ID: "private", Title: "Домашний каталог", URL: "https://private.example.org/opds"},
+	}); err != nil {
+		t.Fatalf("SetUpstreamSources failed: %v", err)
+	}
+	entry := func(key, title string) storage.UpstreamEntry {
+		return storage.UpstreamEntry{
+			Key: key, EntryID: "urn:" + key, Title: title, Authors: []string{"Александр Пушкин"},
+			Updated: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Links: []storage.UpstreamLink{
+				{Rel: RelAcquisition, Type: TypeEPUB, Href: "https://files.example.org/" + key + ".epub"},
+			},
+		}
+	}
+	if err := repo.ReplaceUpstreamEntries("public", []storage.UpstreamEntry{entry("p1", "Медный всадник")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ReplaceUpstreamEntries("private", []storage.UpstreamEntry{entry("h1", "Медный всадник"), entry("h2", "Полтава")}); err != nil {
+		t.Fatal(err)
+	}
+
+	upstreams := &fakeUpstreams{}
+	h.SetUpstreamCatalogs(upstreams)
+	return h, upstreams
+}
+
+func serveUpstreamRoute(h *Handler, handler http.HandlerFunc, target string, params map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// TestUpstreamFeeds covers the root entry, the catalog list, per-catalog
+// feeds with proxied and direct links, and the federated search.
+func TestUpstreamFeeds(t *testing.T) {
+	h, _ := setupUpstreamTestHandler(t)
+
+	root := serveUpstreamRoute(h, h.Root, "/opds", nil).Body.String()
+	if !strings.Contains(root, "http://localhost:9090/opds/upstreams") {
+		t.Error("expected the root catalog to link external catalogs")
+	}
+
+	list := serveUpstreamRoute(h, h.Upstreams, "/opds/upstreams", nil).Body.String()
+	for _, want := range []string{"Открытый каталог", "Домашний каталог", "Книг: 2", "/opds/upstreams/search?q={searchTerms}"} {
+		if !strings.Contains(list, want) {
+			t.Errorf("catalog list is missing %q", want)
+		}
+	}
+
+	public := serveUpstreamRoute(h, h.UpstreamBooks, "/opds/upstreams/public", map[string]string{"source": "public"}).Body.String()
+	if !strings.Contains(public, `href="https://files.example.org/p1.epub"`) {
+		t.Errorf("expected a direct link for an unproxied catalog:\n%s", public)
+	}
+	private := serveUpstreamRoute(h, h.UpstreamBooks, "/opds/upstreams/private", map[string]string{"source": "private"}).Body.String()
+	if strings.Contains(private, "files.example.org") || !strings.Contains(private, "/opds/upstreams/private/download/h1/0") {
+		t.Errorf("expected proxied links for a proxied catalog:\n%s", private)
+	}
+
+	if w := serveUpstreamRoute(h, h.UpstreamBooks, "/opds/upstreams/nope", map[string]string{"source": "nope"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown catalog, got %d", w.Code)
+	}
+
+	search := serveUpstreamRoute(h, h.SearchUpstreams, "/opds/upstreams/search?q=всадник", nil).Body.String()
+	if strings.Count(search, "<entry>") != 2 || !strings.Contains(search, "Каталог: Открытый каталог") {
+		t.Errorf("expected matches from both catalogs:\n%s", search)
+	}
+	if !strings.Contains(search, `opds:facetGroup="Каталог"`) || !strings.Contains(search, "source=private") {
+		t.Errorf("expected catalog facets:\n%s", search)
+	}
+	filtered := serveUpstreamRoute(h, h.SearchUpstreams, "/opds/upstreams/search?q=всадник&source=public", nil).Body.String()
+	if strings.Count(filtered, "<entry>") != 1 {
+		t.Errorf("expected one match in the selected catalog:\n%s", filtered)
+	}
+}
+
+// TestSearch_UpstreamMatches verifies local search results link to the
+// federated search when external catalogs match.
+func TestSearch_UpstreamMatches(t *testing.T) {
+	h, _ := setupUpstreamTestHandler(t)
+
+	body := serveUpstreamRoute(h, h.SearchBooks, "/opds/search?q=Полтава", nil).Body.String()
+	if !strings.Contains(body, "Найдено во внешних каталогах: 1") {
+		t.Errorf("expected a link to external matches:\n%s", body)
+	}
+
+	body = serveUpstreamRoute(h, h.SearchBooks, "/opds/search?q=Дубровский", nil).Body.String()
+	if strings.Contains(body, "внешних каталогах") {
+		t.Error("expected no external entry without external matches")
+	}
+}
+
+// TestUpstreamDownload checks proxied files are streamed and unproxied
+// catalogs are refused.
+func TestUpstreamDownload(t *testing.T) {
+	h, upstreams := setupUpstreamTestHandler(t)
+
+	w := serveUpstreamRoute(h, h.UpstreamDownload, "/opds/upstreams/private/download/h2/0",
+		map[string]string{"source": "private", "key": "h2", "index": "0"})
+	if w.Code != http.StatusOK || w.Body.String() != "epub" || w.Header().Get("Content-Type") != "application/epub+zip" {
+		t.Errorf("unexpected proxied download: %d %q", w.Code, w.Body.String())
+	}
+	if len(upstreams.fetched) != 1 || upstreams.fetched[0] != "https://files.example.org/h2.epub" {
+		t.Errorf("unexpected upstream requests: %v", upstreams.fetched)
+	}
+
+	for _, params := range []map[string]string{
+		{"source": "public", "key": "p1", "index": "0"},
+		{"source": "private", "key": "h2", "index": "5"},
+		{"source": "private", "key": "missing", "index": "0"},
+	} {
+		w := serveUpstreamRoute(h, h.UpstreamDownload, "/opds/upstreams/x", params)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%v: expected 404, got %d", params, w.Code)
+		}
+	}
+}
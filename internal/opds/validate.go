@@ -0,0 +1,570 @@
+package opds
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Violation severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// defaultValidateMaxPages bounds how many feeds one validation run fetches.
+const defaultValidateMaxPages = 200
+
+// Violation is a single OPDS 1.2 requirement a feed does not meet
+type Violation struct {
+	URL      string `json:"url"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// ValidationReport is the result of crawling and validating a catalog
+type ValidationReport struct {
+	StartURL   string      `json:"start_url"`
+	Pages      int         `json:"pages"`
+	Truncated  bool        `json:"truncated"`
+	Errors     int         `json:"errors"`
+	Warnings   int         `json:"warnings"`
+	Violations []Violation `json:"violations"`
+}
+
+// Validator crawls an OPDS catalog and checks every feed it reaches.
+type Validator struct {
+	client   *http.Client
+	maxPages int
+}
+
+// NewValidator creates a validator that fetches feeds with client and stops
+// after maxPages feeds (0 means the default limit).
+func NewValidator(client *http.Client, maxPages int) *Validator {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if maxPages <= 0 {
+		maxPages = defaultValidateMaxPages
+	}
+	return &Validator{client: client, maxPages: maxPages}
+}
+
+// HandlerClient returns an HTTP client that serves every request in-process
+// from h, whatever host the URL names. It lets the validator crawl feeds
+// whose links use the public base URL without going through the network.
+func HandlerClient(h http.Handler) *http.Client {
+	return &http.Client{Transport: handlerTransport{h}}
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	t.handler.ServeHTTP(rec, req)
+	return &http.Response{
+		StatusCode: rec.status,
+		Status:     fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		Header:     rec.header,
+		Body:       io.NopCloser(&rec.body),
+		Request:    req,
+	}, nil
+}
+
+// responseRecorder is a minimal http.ResponseWriter that buffers a response
+type responseRecorder struct {
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+// Validation targets: the subset of Atom and OPDS we inspect
+type vFeed struct {
+	XMLName xml.Name `xml:"feed"`
+	ID      string   `xml:"http://www.w3.org/2005/Atom id"`
+	Title   string   `xml:"http://www.w3.org/2005/Atom title"`
+	Updated string   `xml:"http://www.w3.org/2005/Atom updated"`
+	Links   []vLink  `xml:"http://www.w3.org/2005/Atom link"`
+	Entries []vEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type vEntry struct {
+	ID      string  `xml:"http://www.w3.org/2005/Atom id"`
+	Title   string  `xml:"http://www.w3.org/2005/Atom title"`
+	Updated string  `xml:"http://www.w3.org/2005/Atom updated"`
+	Links   []vLink `xml:"http://www.w3.org/2005/Atom link"`
+}
+
+type vLink struct {
+	Rel         string `xml:"rel,attr"`
+	Type        string `xml:"type,attr"`
+	Href        string `xml:"href,attr"`
+	Title       string `xml:"title,attr"`
+	FacetGroup  string `xml:"http://opds-spec.org/2010/catalog facetGroup,attr"`
+	ActiveFacet string `xml:"http://opds-spec.org/2010/catalog activeFacet,attr"`
+}
+
+type vOpenSearch struct {
+	XMLName xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	URLs    []struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"http://a9.com/-/spec/opensearch/1.1/ Url"`
+}
+
+// registeredRels are the short link relations allowed in OPDS catalogs
+// (IANA link relations used by Atom and OPDS); others must be URIs.
+var registeredRels = map[string]bool{
+	"alternate": true, "related": true, "self": true, "enclosure": true, "via": true,
+	"start": true, "up": true, "next": true, "prev": true, "previous": true,
+	"first": true, "last": true, "search": true, "subsection": true,
+	"license": true, "replies": true, "edit": true, "describedby": true,
+}
+
+// feedKind returns "navigation" or "acquisition" for OPDS catalog media
+// types and "" for anything else. Catalog entries (type=entry) are not
+// feeds.
+func feedKind(mediaType string) (string, bool) {
+	mt, params, err := mime.ParseMediaType(mediaType)
+	if err != nil || mt != "application/atom+xml" || params["profile"] != "opds-catalog" || params["type"] == "entry" {
+		return "", false
+	}
+	return params["kind"], true
+}
+
+type pageLink struct {
+	from string
+	kind string
+}
+
+type crawlState struct {
+	report   *ValidationReport
+	queue    []string
+	queued   map[string]bool
+	reached  map[string]pageLink // URL -> where it was first linked from and with what kind
+	prevWant map[string]string   // page URL -> URL its prev link must point to
+	searched map[string]bool     // OpenSearch descriptions already checked
+}
+
+func (s *crawlState) add(v Violation) {
+	if v.Severity == SeverityError {
+		s.report.Errors++
+	} else {
+		s.report.Warnings++
+	}
+	s.report.Violations = append(s.report.Violations, v)
+}
+
+func (s *crawlState) enqueue(u string) {
+	if !s.queued[u] {
+		s.queued[u] = true
+		s.queue = append(s.queue, u)
+	}
+}
+
+// Validate crawls the catalog starting at startURL, following navigation
+// and pagination links on the same host, and reports every violation found.
+func (v *Validator) Validate(ctx context.Context, startURL string) (*ValidationReport, error) {
+	start, err := url.Parse(startURL)
+	if err != nil || start.Host == "" {
+		return nil, fmt.Errorf("invalid start URL %q", startURL)
+	}
+
+	state := &crawlState{
+		report:   &ValidationReport{StartURL: startURL, Violations: []Violation{}},
+		queued:   map[string]bool{},
+		reached:  map[string]pageLink{},
+		prevWant: map[string]string{},
+		searched: map[string]bool{},
+	}
+	state.enqueue(start.String())
+
+	for len(state.queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if state.report.Pages >= v.maxPages {
+			state.report.Truncated = true
+			break
+		}
+
+		pageURL := state.queue[0]
+		state.queue = state.queue[1:]
+		state.report.Pages++
+		v.visit(ctx, state, start, pageURL)
+	}
+
+	return state.report, nil
+}
+
+func (v *Validator) fetch(ctx context.Context, u string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+func (v *Validator) visit(ctx context.Context, state *crawlState, start *url.URL, pageURL string) {
+	resp, body, err := v.fetch(ctx, pageURL)
+	if err != nil {
+		state.add(Violation{URL: pageURL, Severity: SeverityError, Rule: "http.fetch", Message: err.Error()})
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := fmt.Sprintf("feed returned HTTP %d", resp.StatusCode)
+		if from, ok := state.reached[pageURL]; ok {
+			msg += " (linked from " + from.from + ")"
+		}
+		state.add(Violation{URL: pageURL, Severity: SeverityError, Rule: "http.status", Message: msg})
+		return
+	}
+
+	violations, feed := validateFeed(pageURL, resp.Header.Get("Content-Type"), body)
+	for _, violation := range violations {
+		state.add(violation)
+	}
+	if feed == nil {
+		return
+	}
+
+	self := selfHref(feed, pageURL)
+
+	// The link that led here declared a kind; the feed must agree with it
+	if from, ok := state.reached[pageURL]; ok && from.kind != "" {
+		if kind := feedSelfKind(feed); kind != "" && kind != from.kind {
+			state.add(Violation{URL: pageURL, Severity: SeverityWarning, Rule: "link.kind-mismatch",
+				Message: fmt.Sprintf("linked from %s as kind=%s but the feed declares kind=%s", from.from, from.kind, kind)})
+		}
+	}
+
+	// Pagination: prev must point back to the page whose next led here
+	if want, ok := state.prevWant[pageURL]; ok {
+		prev := findLink(feed.Links, RelPrev, "previous")
+		switch {
+		case prev == nil:
+			state.add(Violation{URL: pageURL, Severity: SeverityError, Rule: "pagination.prev-missing",
+				Message: "page reached through rel=next has no rel=prev link back to " + want})
+		case !sameURL(resolve(pageURL, prev.Href), want):
+			state.add(Violation{URL: pageURL, Severity: SeverityError, Rule: "pagination.prev-mismatch",
+				Message: fmt.Sprintf("rel=prev points to %s, expected %s", prev.Href, want)})
+		}
+	}
+
+	if next := findLink(feed.Links, RelNext); next != nil {
+		target := resolve(pageURL, next.Href)
+		if sameURL(target, self) || sameURL(target, pageURL) {
+			state.add(Violation{URL: pageURL, Severity: SeverityError, Rule: "pagination.loop",
+				Message: "rel=next points to the page itself"})
+		} else if _, seen := state.prevWant[target]; !seen {
+			state.prevWant[target] = self
+		}
+	}
+
+	v.followLinks(ctx, state, start, pageURL, feed)
+}
+
+// followLinks queues catalog feeds linked from a page and checks the
+// OpenSearch descriptions it advertises.
+func (v *Validator) followLinks(ctx context.Context, state *crawlState, start *url.URL, pageURL string, feed *vFeed) {
+	links := append([]vLink{}, feed.Links...)
+	for _, entry := range feed.Entries {
+		links = append(links, entry.Links...)
+	}
+
+	for _, link := range links {
+		if link.Href == "" {
+			continue
+		}
+		target := resolve(pageURL, link.Href)
+		if link.Rel == RelSearch && strings.HasPrefix(link.Type, TypeSearch) {
+			if !state.searched[target] {
+				state.searched[target] = true
+				v.checkOpenSearch(ctx, state, target)
+			}
+			continue
+		}
+
+		kind, isFeed := feedKind(link.Type)
+		if !isFeed || strings.Contains(link.Href, "{") || link.Rel == RelFacet {
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil || u.Host != start.Host {
+			continue
+		}
+		if _, ok := state.reached[target]; !ok {
+			state.reached[target] = pageLink{from: pageURL, kind: kind}
+		}
+		state.enqueue(target)
+	}
+}
+
+func (v *Validator) checkOpenSearch(ctx context.Context, state *crawlState, u string) {
+	resp, body, err := v.fetch(ctx, u)
+	if err != nil {
+		state.add(Violation{URL: u, Severity: SeverityError, Rule: "opensearch.fetch", Message: err.Error()})
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		state.add(Violation{URL: u, Severity: SeverityError, Rule: "opensearch.fetch",
+			Message: fmt.Sprintf("OpenSearch description returned HTTP %d", resp.StatusCode)})
+		return
+	}
+
+	var doc vOpenSearch
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		state.add(Violation{URL: u, Severity: SeverityError, Rule: "opensearch.document",
+			Message: "rel=search link of type " + TypeSearch + " does not point to an OpenSearch description: " + err.Error()})
+		return
+	}
+
+	hasAtom := false
+	for _, tmpl := range doc.URLs {
+		if !strings.Contains(tmpl.Template, "{searchTerms}") {
+			state.add(Violation{URL: u, Severity: SeverityError, Rule: "opensearch.template",
+				Message: "Url template has no {searchTerms} parameter: " + tmpl.Template})
+		}
+		if strings.HasPrefix(tmpl.Type, "application/atom+xml") {
+			hasAtom = true
+		}
+	}
+	if !hasAtom {
+		state.add(Violation{URL: u, Severity: SeverityError, Rule: "opensearch.template",
+			Message: "no Url template of type application/atom+xml"})
+	}
+}
+
+// validateFeed checks a single Atom feed against OPDS 1.2 requirements.
+// It returns the violations found and the parsed feed, or nil if the body
+// could not be parsed.
+func validateFeed(pageURL, contentType string, body []byte) ([]Violation, *vFeed) {
+	var violations []Violation
+	add := func(severity, rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{URL: pageURL, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	mt, params, err := mime.ParseMediaType(contentType)
+	switch {
+	case err != nil || mt != "application/atom+xml":
+		add(SeverityError, "content-type", "feed served as %q, expected application/atom+xml", contentType)
+	case params["profile"] != "opds-catalog":
+		add(SeverityWarning, "content-type", "Content-Type %q has no profile=opds-catalog parameter", contentType)
+	}
+
+	var feed vFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		add(SeverityError, "xml", "feed is not well-formed XML: %v", err)
+		return violations, nil
+	}
+	if feed.XMLName.Space != "http://www.w3.org/2005/Atom" {
+		add(SeverityError, "atom.namespace", "root element is not an Atom feed (namespace %q)", feed.XMLName.Space)
+		return violations, nil
+	}
+
+	if strings.TrimSpace(feed.ID) == "" {
+		add(SeverityError, "atom.id", "feed has no atom:id")
+	}
+	if strings.TrimSpace(feed.Title) == "" {
+		add(SeverityError, "atom.title", "feed has no atom:title")
+	}
+	checkUpdated(feed.Updated, "feed", add)
+
+	if findLink(feed.Links, "self") == nil {
+		add(SeverityError, "link.self", "feed has no rel=self link")
+	}
+	if findLink(feed.Links, RelStart) == nil {
+		add(SeverityWarning, "link.start", "feed has no rel=start link to the catalog root")
+	}
+	for _, link := range feed.Links {
+		checkLink(link, "feed", add)
+	}
+
+	kind := feedSelfKind(&feed)
+	for i, entry := range feed.Entries {
+		where := fmt.Sprintf("entry %d (%s)", i+1, entry.ID)
+		if strings.TrimSpace(entry.ID) == "" {
+			add(SeverityError, "atom.id", "entry %d has no atom:id", i+1)
+		}
+		if strings.TrimSpace(entry.Title) == "" {
+			add(SeverityError, "atom.title", "%s has no atom:title", where)
+		}
+		checkUpdated(entry.Updated, where, add)
+		for _, link := range entry.Links {
+			checkLink(link, where, add)
+		}
+
+		switch kind {
+		case "navigation":
+			if !hasCatalogLink(entry.Links) {
+				add(SeverityError, "entry.navigation", "%s in a navigation feed has no link to a catalog feed", where)
+			}
+		case "acquisition":
+			if !hasAcquisitionLink(entry.Links) && !hasCatalogLink(entry.Links) {
+				add(SeverityError, "entry.acquisition", "%s in an acquisition feed has no acquisition link", where)
+			}
+		}
+	}
+
+	return violations, &feed
+}
+
+func checkUpdated(value, where string, add func(severity, rule, format string, args ...interface{})) {
+	if strings.TrimSpace(value) == "" {
+		add(SeverityError, "atom.updated", "%s has no atom:updated", where)
+		return
+	}
+	if _, err := time.Parse(time.RFC3339, strings.TrimSpace(value)); err != nil {
+		add(SeverityError, "atom.updated", "%s has atom:updated %q that is not an RFC 3339 date", where, value)
+	}
+}
+
+func checkLink(link vLink, where string, add func(severity, rule, format string, args ...interface{})) {
+	if strings.TrimSpace(link.Href) == "" {
+		add(SeverityError, "link.href", "%s has a rel=%q link without href", where, link.Rel)
+	}
+
+	switch {
+	case link.Rel == "":
+		add(SeverityWarning, "link.rel", "%s has a link to %s without rel", where, link.Href)
+	case !registeredRels[link.Rel] && !strings.Contains(link.Rel, ":"):
+		add(SeverityError, "link.rel", "%s uses unregistered relation %q; extension relations must be URIs", where, link.Rel)
+	}
+
+	if strings.TrimSpace(link.Type) == "" {
+		add(SeverityError, "link.type", "%s has a rel=%q link to %s without type", where, link.Rel, link.Href)
+		return
+	}
+	if _, _, err := mime.ParseMediaType(link.Type); err != nil {
+		add(SeverityError, "link.type", "%s has a rel=%q link with invalid media type %q", where, link.Rel, link.Type)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(link.Rel, "http://opds-spec.org/image"):
+		if !strings.HasPrefix(link.Type, "image/") {
+			add(SeverityError, "link.image-type", "%s has an image link of type %q", where, link.Type)
+		}
+	case link.Rel == RelFacet:
+		if link.FacetGroup == "" || link.Title == "" {
+			add(SeverityError, "link.facet", "%s has a facet link without opds:facetGroup or title", where)
+		}
+		if link.ActiveFacet != "" && link.ActiveFacet != "true" && link.ActiveFacet != "false" {
+			add(SeverityError, "link.facet", "%s has opds:activeFacet=%q, expected true or false", where, link.ActiveFacet)
+		}
+	case link.Rel == RelSearch:
+		if !strings.HasPrefix(link.Type, TypeSearch) && !strings.HasPrefix(link.Type, "application/atom+xml") {
+			add(SeverityError, "link.search", "%s has a search link of type %q", where, link.Type)
+		}
+		if strings.HasPrefix(link.Type, TypeSearch) && strings.Contains(link.Href, "{") {
+			add(SeverityError, "link.search", "%s: search link of type %s must point to an OpenSearch description, not a template (%s)", where, TypeSearch, link.Href)
+		}
+	case link.Rel == RelSubsection, link.Rel == RelStart, link.Rel == RelUp, link.Rel == RelNext, link.Rel == RelPrev, link.Rel == "previous":
+		if _, ok := feedKind(link.Type); !ok {
+			add(SeverityWarning, "link.type", "%s has a rel=%s link of type %q, expected an OPDS catalog type", where, link.Rel, link.Type)
+		}
+	}
+}
+
+func findLink(links []vLink, rels ...string) *vLink {
+	for i := range links {
+		for _, rel := range rels {
+			if links[i].Rel == rel {
+				return &links[i]
+			}
+		}
+	}
+	return nil
+}
+
+func hasCatalogLink(links []vLink) bool {
+	for _, link := range links {
+		if _, ok := feedKind(link.Type); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAcquisitionLink(links []vLink) bool {
+	for _, link := range links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") && link.Type != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func feedSelfKind(feed *vFeed) string {
+	if self := findLink(feed.Links, "self"); self != nil {
+		kind, _ := feedKind(self.Type)
+		return kind
+	}
+	return ""
+}
+
+func selfHref(feed *vFeed, pageURL string) string {
+	if self := findLink(feed.Links, "self"); self != nil && self.Href != "" {
+		return resolve(pageURL, self.Href)
+	}
+	return pageURL
+}
+
+func resolve(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// sameURL compares URLs ignoring query parameter order
+func sameURL(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.Scheme == ub.Scheme && ua.Host == ub.Host &&
+		strings.TrimSuffix(ua.Path, "/") == strings.TrimSuffix(ub.Path, "/") &&
+		ua.Query().Encode() == ub.Query().Encode()
+}
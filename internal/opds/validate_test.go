@@ -0,0 +1,147 @@
+package opds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	testNavType = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	testAcqType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+// testFeed wraps links and entries in a minimal Atom feed
+func testFeed(links, entries string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>urn:test</id>
+  <title>Test</title>
+  <updated>2024-05-01T12:00:00Z</updated>
+  ` + links + entries + `
+</feed>`)
+}
+
+func hasRule(violations []Violation, severity, rule string) bool {
+	for _, v := range violations {
+		if v.Severity == severity && v.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// TestValidateFeed checks that each OPDS 1.2 requirement is reported.
+func TestValidateFeed(t *testing.T) {
+	validLinks := `<link rel="self" href="/opds/books" type="` + testAcqType + `"/>
+  <link rel="start" href="/opds" type="` + testNavType + `"/>`
+	book := func(links string) string {
+		return `<entry><id>urn:book:1</id><title>Book</title><updated>2024-05-01T12:00:00Z</updated>` + links + `</entry>`
+	}
+	acquisition := `<link rel="http://opds-spec.org/acquisition/open-access" href="/download/1" type="application/x-fictionbook+xml"/>`
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		severity    string
+		rule        string
+	}{
+		{"wrong content type", "text/html", testFeed(validLinks, book(acquisition)), SeverityError, "content-type"},
+		{"missing profile", "application/atom+xml", testFeed(validLinks, book(acquisition)), SeverityWarning, "content-type"},
+		{"not xml", testAcqType, []byte("<feed"), SeverityError, "xml"},
+		{"missing self", testAcqType, testFeed(`<link rel="start" href="/opds" type="`+testNavType+`"/>`, ""), SeverityError, "link.self"},
+		{"unregistered rel", testAcqType, testFeed(validLinks+`<link rel="bogus" href="/x" type="text/html"/>`, ""), SeverityError, "link.rel"},
+		{"link without type", testAcqType, testFeed(validLinks+`<link rel="alternate" href="/x"/>`, ""), SeverityError, "link.type"},
+		{"no acquisition link", testAcqType, testFeed(validLinks, book(`<link rel="alternate" href="/x" type="text/html"/>`)), SeverityError, "entry.acquisition"},
+		{"bad updated", testAcqType, testFeed(validLinks, strings.Replace(book(acquisition), "2024-05-01T12:00:00Z", "01.05.2024", 1)), SeverityError, "atom.updated"},
+		{"search template as opensearch", testAcqType, testFeed(validLinks+`<link rel="search" href="/opds/search?q={searchTerms}" type="application/opensearchdescription+xml"/>`, ""), SeverityError, "link.search"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, _ := validateFeed("http://library.test/opds/books", tt.contentType, tt.body)
+			if !hasRule(violations, tt.severity, tt.rule) {
+				t.Errorf("expected %s %s, got %+v", tt.severity, tt.rule, violations)
+			}
+		})
+	}
+
+	violations, feed := validateFeed("http://library.test/opds/books", testAcqType, testFeed(validLinks, book(acquisition)))
+	if feed == nil || len(violations) != 0 {
+		t.Errorf("expected a valid feed, got %+v", violations)
+	}
+}
+
+// TestValidate_Pagination checks the prev/next chain rules across pages.
+func TestValidate_Pagination(t *testing.T) {
+	tests := []struct {
+		name string
+		prev string // prev link of page 2, "" for none
+		loop bool
+		rule string
+	}{
+		{"prev missing", "", false, "pagination.prev-missing"},
+		{"prev mismatch", "/opds/books?page=5", false, "pagination.prev-mismatch"},
+		{"next loop", "/opds/books", true, "pagination.loop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/opds/books", func(w http.ResponseWriter, r *http.Request) {
+				page := 1
+				fmt.Sscanf(r.URL.Query().Get("page"), "%d", &page)
+
+				self := "/opds/books"
+				if page > 1 {
+					self = fmt.Sprintf("/opds/books?page=%d", page)
+				}
+				links := `<link rel="self" href="` + self + `" type="` + testAcqType + `"/>
+  <link rel="start" href="/opds/books" type="` + testAcqType + `"/>`
+				switch {
+				case page == 1:
+					links += `<link rel="next" href="/opds/books?page=2" type="` + testAcqType + `"/>`
+				case tt.loop:
+					links += `<link rel="next" href="` + self + `" type="` + testAcqType + `"/>`
+				}
+				if page > 1 && tt.prev != "" {
+					links += `<link rel="previous" href="` + tt.prev + `" type="` + testAcqType + `"/>`
+				}
+
+				w.Header().Set("Content-Type", testAcqType)
+				w.Write(testFeed(links, ""))
+			})
+
+			report, err := NewValidator(HandlerClient(mux), 0).Validate(context.Background(), "http://library.test/opds/books")
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if !hasRule(report.Violations, SeverityError, tt.rule) {
+				t.Errorf("expected %s, got %+v", tt.rule, report.Violations)
+			}
+		})
+	}
+}
+
+// TestValidate_MaxPages verifies the crawl stops at the page limit.
+func TestValidate_MaxPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/opds/", func(w http.ResponseWriter, r *http.Request) {
+		links := `<link rel="self" href="` + r.URL.Path + `" type="` + testNavType + `"/>
+  <link rel="start" href="/opds/" type="` + testNavType + `"/>
+  <link rel="subsection" href="` + r.URL.Path + `x" type="` + testNavType + `"/>`
+		w.Header().Set("Content-Type", testNavType)
+		w.Write(testFeed(links, ""))
+	})
+
+	report, err := NewValidator(HandlerClient(mux), 3).Validate(context.Background(), "http://library.test/opds/")
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if report.Pages != 3 || !report.Truncated {
+		t.Errorf("expected 3 pages and a truncated report, got %d pages (truncated=%t)", report.Pages, report.Truncated)
+	}
+}
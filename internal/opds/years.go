@@ -0,0 +1,221 @@
+package opds
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// Years serves publication decades (navigation)
+func (h *Handler) Years(w http.ResponseWriter, r *http.Request) {
+	years, ok := h.listYears(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeFeed(w, r, h.builderFor(r).BuildDecadesFeed(storage.GroupDecades(years)))
+}
+
+// YearsOfDecade serves the publication years of one decade (navigation)
+func (h *Handler) YearsOfDecade(w http.ResponseWriter, r *http.Request) {
+	decade, err := strconv.Atoi(chi.URLParam(r, "decade"))
+	if err != nil || decade%10 != 0 {
+		http.Error(w, "Invalid decade", http.StatusBadRequest)
+		return
+	}
+
+	years, ok := h.listYears(w, r)
+	if !ok {
+		return
+	}
+
+	var inDecade []storage.YearCount
+	for _, yc := range years {
+		if yc.Year/10*10 == decade {
+			inDecade = append(inDecade, yc)
+		}
+	}
+	if len(inDecade) == 0 {
+		http.Error(w, "Decade not found", http.StatusNotFound)
+		return
+	}
+
+	h.writeFeed(w, r, h.builderFor(r).BuildYearsFeed(decade, inDecade))
+}
+
+// BooksOfDecade serves books published in one decade, oldest first
+func (h *Handler) BooksOfDecade(w http.ResponseWriter, r *http.Request) {
+	decade, err := strconv.Atoi(chi.URLParam(r, "decade"))
+	if err != nil || decade <= 0 || decade%10 != 0 {
+		http.Error(w, "Invalid decade", http.StatusBadRequest)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		YearFrom:  decade,
+		YearTo:    decade + 9,
+		Limit:     pageSize,
+		Offset:    (page - 1) * pageSize,
+		SortBy:    "year",
+		SortOrder: "asc",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := fmt.Sprintf("Книги %d-х годов", decade)
+	feedID := fmt.Sprintf("%s/years/decade/%d/books", h.builderFor(r).catalogURL(""), decade)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// BooksByYear serves books published in a specific year
+func (h *Handler) BooksByYear(w http.ResponseWriter, r *http.Request) {
+	year, err := strconv.Atoi(chi.URLParam(r, "year"))
+	if err != nil || year <= 0 {
+		http.Error(w, "Invalid year", http.StatusBadRequest)
+		return
+	}
+
+	page := h.getPageFromQuery(r)
+	pageSize := h.pageSize()
+
+	filter := storage.BookFilter{
+		YearFrom:  year,
+		YearTo:    year,
+		Limit:     pageSize,
+		Offset:    (page - 1) * pageSize,
+		SortBy:    "title",
+		SortOrder: "asc",
+	}
+
+	order := requestedOrder(r)
+	applyOrder(&filter, order)
+
+	result, err := h.searchBooks(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := fmt.Sprintf("Книги %d года", year)
+	feedID := fmt.Sprintf("%s/years/%d", h.builderFor(r).catalogURL(""), year)
+	if page > 1 {
+		feedID += "?page=" + strconv.Itoa(page)
+	}
+
+	feed := h.builderFor(r).BuildBooksFeed(result.Books, title, feedID, page, pageSize, result.Total)
+	h.builderFor(r).addOrderLinks(feed, order)
+	h.writeFeed(w, r, feed)
+}
+
+// listYears returns the years of the books visible to the reader in the
+// language the request is scoped to
+func (h *Handler) listYears(w http.ResponseWriter, r *http.Request) ([]storage.YearCount, bool) {
+	hidden, err := h.restrictions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	years, err := h.repo.ListYearsInLanguage(scopeLanguage(r), hidden)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return years, true
+}
+
+// BuildDecadesFeed creates a navigation feed listing publication decades
+func (b *Builder) BuildDecadesFeed(decades []storage.YearCount) *Feed {
+	feed, _, _, now := b.newNavigationFeed("По десятилетиям", "/years", 1, len(decades), len(decades))
+
+	for _, decade := range decades {
+		decadeURL := fmt.Sprintf("%s/years/decade/%d", b.catalogURL(""), decade.Year)
+		title := fmt.Sprintf("%d-е", decade.Year)
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      decadeURL,
+			Title:   title,
+			Updated: now,
+			Summary: fmt.Sprintf("Книг: %d", decade.Count),
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeNavigation,
+					Href:  decadeURL,
+					Title: fmt.Sprintf("Книги %s годов", title),
+				},
+			},
+		})
+	}
+
+	return feed
+}
+
+// BuildYearsFeed creates a navigation feed listing the years of a decade,
+// after an entry with all books of the decade
+func (b *Builder) BuildYearsFeed(decade int, years []storage.YearCount) *Feed {
+	path := fmt.Sprintf("/years/decade/%d", decade)
+	feed, _, _, now := b.newNavigationFeed(fmt.Sprintf("%d-е", decade), path, 1, len(years), len(years))
+	for i := range feed.Links {
+		if feed.Links[i].Rel == RelUp {
+			feed.Links[i].Href = b.catalogURL("/years")
+		}
+	}
+
+	total := 0
+	for _, year := range years {
+		total += year.Count
+	}
+	decadeURL := b.catalogURL(path + "/books")
+	feed.Entries = append(feed.Entries, Entry{
+		ID:      decadeURL,
+		Title:   fmt.Sprintf("Все книги %d-х", decade),
+		Updated: now,
+		Summary: fmt.Sprintf("Книг: %d", total),
+		Links: []Link{
+			{
+				Rel:   RelSubsection,
+				Type:  TypeAcquisition,
+				Href:  decadeURL,
+				Title: fmt.Sprintf("Книги %d-х годов", decade),
+			},
+		},
+	})
+
+	for _, year := range years {
+		yearURL := fmt.Sprintf("%s/years/%d", b.catalogURL(""), year.Year)
+		feed.Entries = append(feed.Entries, Entry{
+			ID:      yearURL,
+			Title:   strconv.Itoa(year.Year),
+			Updated: now,
+			Summary: fmt.Sprintf("Книг: %d", year.Count),
+			Links: []Link{
+				{
+					Rel:   RelSubsection,
+					Type:  TypeAcquisition,
+					Href:  yearURL,
+					Title: fmt.Sprintf("Книги %d года", year.Year),
+				},
+			},
+		})
+	}
+
+	return feed
+}
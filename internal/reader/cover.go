@@ -0,0 +1,77 @@
+package reader
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// ExtractCover returns the decoded cover image referenced from
+// <title-info><coverpage>. Book bodies are skipped without being parsed.
+// Returns nil data if the book has no cover.
+func ExtractCover(r io.Reader) ([]byte, string, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var (
+		coverID     string
+		inTitle     bool
+		inCoverpage bool
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, "", nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("xml token error: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "title-info":
+				inTitle = true
+			case "coverpage":
+				inCoverpage = inTitle
+			case "image":
+				if inCoverpage && coverID == "" {
+					coverID = strings.TrimPrefix(parseImage(&t).Href, "#")
+				}
+			case "body":
+				if coverID == "" {
+					// Coverpage is always in the description, which precedes bodies
+					return nil, "", nil
+				}
+				if err := decoder.Skip(); err != nil {
+					return nil, "", fmt.Errorf("skip body: %w", err)
+				}
+			case "binary":
+				var bin FB2Binary
+				if err := decoder.DecodeElement(&bin, &t); err != nil {
+					return nil, "", fmt.Errorf("parse binary: %w", err)
+				}
+				if bin.ID != coverID {
+					continue
+				}
+				data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(bin.Data), ""))
+				if err != nil {
+					return nil, "", fmt.Errorf("decode cover: %w", err)
+				}
+				return data, bin.ContentType, nil
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "title-info":
+				inTitle = false
+			case "coverpage":
+				inCoverpage = false
+			}
+		}
+	}
+}
@@ -184,6 +184,45 @@ func TestParseFB2_Binary(t *testing.T) {
 	}
 }
 
+func TestExtractCover(t *testing.T) {
+	fb2 := `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+ <description>
+  <title-info>
+   <book-title>Covered</book-title>
+   <coverpage><image l:href="#cover.png"/></coverpage>
+  </title-info>
+ </description>
+ <body><section><p>Text <image l:href="#other.png"/></p></section></body>
+ <binary id="other.png" content-type="image/png">b3RoZXI=</binary>
+ <binary id="cover.png" content-type="image/png">
+  Y292
+  ZXI=
+ </binary>
+</FictionBook>`
+
+	data, contentType, err := ExtractCover(strings.NewReader(fb2))
+	if err != nil {
+		t.Fatalf("ExtractCover failed: %v", err)
+	}
+	if string(data) != "cover" {
+		t.Errorf("cover data = %q, want %q", data, "cover")
+	}
+	if contentType != "image/png" {
+		t.Errorf("content-type = %q, want %q", contentType, "image/png")
+	}
+}
+
+func TestExtractCover_NoCoverpage(t *testing.T) {
+	data, _, err := ExtractCover(strings.NewReader(sampleFB2))
+	if err != nil {
+		t.Fatalf("ExtractCover failed: %v", err)
+	}
+	if data != nil {
+		t.Errorf("expected no cover without <coverpage>, got %q", data)
+	}
+}
+
 func TestParseFB2_NoBody(t *testing.T) {
 	xml := `<?xml version="1.0"?><FictionBook><description></description></FictionBook>`
 	_, err := ParseFB2(strings.NewReader(xml))
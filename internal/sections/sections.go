@@ -0,0 +1,178 @@
+// Package sections parses the sections admins add to the root of the
+// catalog: saved book filters with a title, such as Russian children's
+// books, served as collections in OPDS and the API.
+package sections
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// Section is a saved book filter shown as a catalog section.
+type Section struct {
+	ID          string             `json:"id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Filter      storage.BookFilter `json:"filter"`
+}
+
+// listParams are the filter parameters that take several values, by their
+// names in the book search API and singular aliases
+var listParams = map[string]string{
+	"authors": "authors", "author": "authors",
+	"series": "series",
+	"genres": "genres", "genre": "genres",
+	"languages": "languages", "language": "languages",
+	"formats": "formats", "format": "formats",
+	"tags": "tags", "tag": "tags",
+}
+
+// Parse parses a ";"-separated list of sections, each written as
+// "Title|Description=filter". The description is optional and the filter
+// takes the parameters of the book search API as a query string, e.g.
+// "Детские книги|Для детей=genres=child&languages=ru". An id parameter sets
+// the ID used in URLs, which defaults to the position of the section.
+func Parse(spec string) ([]Section, error) {
+	var list []Section
+	ids := make(map[string]bool)
+
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		head, query, ok := strings.Cut(item, "=")
+		title, description, _ := strings.Cut(head, "|")
+		title, description = strings.TrimSpace(title), strings.TrimSpace(description)
+		if !ok || title == "" {
+			return nil, fmt.Errorf("invalid section %q: expected Title|Description=filter", item)
+		}
+
+		values, err := url.ParseQuery(strings.TrimSpace(query))
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter of section %q: %w", title, err)
+		}
+		filter, err := parseFilter(values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter of section %q: %w", title, err)
+		}
+
+		id := values.Get("id")
+		if id == "" {
+			id = strconv.Itoa(len(list) + 1)
+		}
+		if id != url.PathEscape(id) {
+			return nil, fmt.Errorf("invalid ID %q of section %q", id, title)
+		}
+		if ids[id] {
+			return nil, fmt.Errorf("duplicate section ID %q", id)
+		}
+		ids[id] = true
+
+		list = append(list, Section{ID: id, Title: title, Description: description, Filter: filter})
+	}
+
+	return list, nil
+}
+
+// parseFilter reads the filter parameters of a section, rejecting unknown
+// ones so that a typo does not silently widen the section
+func parseFilter(values url.Values) (storage.BookFilter, error) {
+	var filter storage.BookFilter
+	for key, vals := range values {
+		value := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "id":
+		case "q":
+			filter.Query = value
+		case "year":
+			filter.YearFrom, err = strconv.Atoi(value)
+			filter.YearTo = filter.YearFrom
+		case "year_from":
+			filter.YearFrom, err = strconv.Atoi(value)
+		case "year_to":
+			filter.YearTo, err = strconv.Atoi(value)
+		case "series_num_from":
+			filter.SeriesNumFrom, err = strconv.Atoi(value)
+		case "series_num_to":
+			filter.SeriesNumTo, err = strconv.Atoi(value)
+		case "featured":
+			filter.Featured, err = strconv.ParseBool(value)
+		case "first_in_series":
+			filter.FirstInSeries, err = strconv.ParseBool(value)
+		case "without_series":
+			filter.WithoutSeries, err = strconv.ParseBool(value)
+		case "sort_by":
+			if !storage.IsValidSortField(value) {
+				return filter, fmt.Errorf("invalid sort_by %q, expected one of: %s", value, strings.Join(storage.SortFields, ", "))
+			}
+			filter.SortBy = value
+		case "sort_order":
+			if value != "asc" && value != "desc" {
+				return filter, fmt.Errorf("invalid sort_order %q, expected asc or desc", value)
+			}
+			filter.SortOrder = value
+		default:
+			name, ok := listParams[key]
+			if !ok {
+				return filter, fmt.Errorf("unknown parameter %q", key)
+			}
+			var items []string
+			for _, v := range vals {
+				for _, item := range strings.Split(v, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						items = append(items, item)
+					}
+				}
+			}
+			switch name {
+			case "authors":
+				filter.Authors = append(filter.Authors, items...)
+			case "series":
+				filter.Series = append(filter.Series, items...)
+			case "genres":
+				filter.Genres = append(filter.Genres, items...)
+			case "languages":
+				filter.Languages = append(filter.Languages, items...)
+			case "formats":
+				filter.Formats = append(filter.Formats, items...)
+			case "tags":
+				filter.Tags = append(filter.Tags, items...)
+			}
+		}
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s %q", key, value)
+		}
+	}
+	return filter, nil
+}
+
+// Find returns the section with the given ID, or nil.
+func Find(list []Section, id string) *Section {
+	for i := range list {
+		if list[i].ID == id {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// InLanguage reports whether a section may hold books in a language: it
+// filters by no language or includes this one.
+func (s Section) InLanguage(language string) bool {
+	if len(s.Filter.Languages) == 0 {
+		return true
+	}
+	for _, l := range s.Filter.Languages {
+		if strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package sections
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	list, err := Parse("Детские книги|Для детей на русском=genre=child&languages=ru,uk; Новая фантастика=id=new-sf&genres=sf&year_from=2000&sort_by=year&sort_order=desc;")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 sections, got %d", len(list))
+	}
+
+	kids := list[0]
+	if kids.ID != "1" || kids.Title != "Детские книги" || kids.Description != "Для детей на русском" {
+		t.Errorf("unexpected section %+v", kids)
+	}
+	if !reflect.DeepEqual(kids.Filter.Genres, []string{"child"}) || !reflect.DeepEqual(kids.Filter.Languages, []string{"ru", "uk"}) {
+		t.Errorf("unexpected filter %+v", kids.Filter)
+	}
+	if !kids.InLanguage("RU") || kids.InLanguage("en") {
+		t.Errorf("expected the section in Russian and Ukrainian only")
+	}
+
+	sf := list[1]
+	if sf.ID != "new-sf" || sf.Description != "" || sf.Filter.YearFrom != 2000 || sf.Filter.SortBy != "year" || sf.Filter.SortOrder != "desc" {
+		t.Errorf("unexpected section %+v", sf)
+	}
+	if !sf.InLanguage("en") {
+		t.Errorf("expected a section without languages in every language")
+	}
+
+	if Find(list, "new-sf") != &list[1] || Find(list, "2") != nil {
+		t.Errorf("Find returned the wrong section")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for spec, want := range map[string]string{
+		"Без фильтра":                       "expected Title|Description=filter",
+		"|Описание=genres=sf":               "expected Title|Description=filter",
+		"Опечатка=genrs=sf":                 "unknown parameter",
+		"Годы=year_from=two":                "invalid year_from",
+		"Порядок=sort_by=weight":            "invalid sort_by",
+		"А=id=x&genres=sf;Б=id=x&genres=dt": "duplicate section ID",
+		"Путь=id=a/b":                       "invalid ID",
+	} {
+		_, err := Parse(spec)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q): expected error containing %q, got %v", spec, want, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidAccessRule is returned for a rule with an unknown kind or an
+// empty name.
+var ErrInvalidAccessRule = errors.New("invalid access rule")
+
+// ListAccessRules returns all access rules ordered by kind and name.
+func (r *Repository) ListAccessRules() ([]AccessRule, error) {
+	if rules := r.accessRules.Load(); rules != nil {
+		return *rules, nil
+	}
+
+	rows, err := r.db.db.Query(`SELECT kind, name, role, created_at FROM access_rules ORDER BY kind, name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []AccessRule{}
+	for rows.Next() {
+		var rule AccessRule
+		if err := rows.Scan(&rule.Kind, &rule.Name, &rule.Role, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan access rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating access rules: %w", err)
+	}
+
+	// Rules are consulted on every request, so they are cached until changed.
+	// A read-only instance cannot tell when the writer changes them.
+	if !r.db.readOnly {
+		r.accessRules.Store(&rules)
+	}
+	return rules, nil
+}
+
+// SetAccessRule creates or updates the rule for a genre or tag.
+func (r *Repository) SetAccessRule(rule AccessRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Role = strings.TrimSpace(rule.Role)
+	if (rule.Kind != AccessKindGenre && rule.Kind != AccessKindTag) || rule.Name == "" {
+		return ErrInvalidAccessRule
+	}
+
+	defer r.accessRules.Store(nil)
+	if _, err := r.db.db.Exec(
+		`INSERT INTO access_rules (kind, name, role) VALUES (?, ?, ?)
+		 ON CONFLICT(kind, name) DO UPDATE SET role = excluded.role`,
+		rule.Kind, rule.Name, rule.Role,
+	); err != nil {
+		return fmt.Errorf("failed to save access rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccessRule removes a rule; it reports false if there was none.
+func (r *Repository) DeleteAccessRule(kind, name string) (bool, error) {
+	defer r.accessRules.Store(nil)
+	result, err := r.db.db.Exec("DELETE FROM access_rules WHERE kind = ? AND name = ?", kind, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete access rule: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetUserRoles returns the roles of a user in alphabetical order.
+func (r *Repository) GetUserRoles(userID string) ([]string, error) {
+	rows, err := r.db.db.Query("SELECT role FROM user_roles WHERE user_id = ? ORDER BY role", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan user role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles of a user.
+func (r *Repository) SetUserRoles(userID string, roles []string) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to clear user roles: %w", err)
+	}
+	for _, role := range roles {
+		if role = strings.TrimSpace(role); role == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)", userID, role); err != nil {
+			return fmt.Errorf("failed to save user role: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user roles: %w", err)
+	}
+	return nil
+}
+
+// RestrictionsFor returns the genres and tags hidden from user, or nil when
+// the user may see everything. A nil user is an anonymous visitor.
+func (r *Repository) RestrictionsFor(user *User) (*Restrictions, error) {
+	if user != nil && user.IsAdmin {
+		return nil, nil
+	}
+	rules, err := r.ListAccessRules()
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	roles := make(map[string]bool)
+	if user != nil {
+		userRoles, err := r.GetUserRoles(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range userRoles {
+			roles[role] = true
+		}
+	}
+
+	hidden := &Restrictions{Anonymous: user == nil}
+	for _, rule := range rules {
+		if user != nil && (rule.Role == "" || roles[rule.Role]) {
+			continue
+		}
+		switch rule.Kind {
+		case AccessKindGenre:
+			hidden.Genres = append(hidden.Genres, rule.Name)
+		case AccessKindTag:
+			hidden.Tags = append(hidden.Tags, rule.Name)
+		}
+	}
+	if len(hidden.Genres) == 0 && len(hidden.Tags) == 0 {
+		return nil, nil
+	}
+	return hidden, nil
+}
+
+// Hides reports whether a book with loaded genres and tags is restricted.
+// A nil receiver hides nothing.
+func (x *Restrictions) Hides(book *Book) bool {
+	if x == nil {
+		return false
+	}
+	if book.Genre != nil && x.HidesGenre(book.Genre.Name) {
+		return true
+	}
+	for _, genre := range book.Genres {
+		if x.HidesGenre(genre.Name) {
+			return true
+		}
+	}
+	for _, tag := range book.Tags {
+		if x.HidesTag(tag.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HidesGenre reports whether books of the genre are restricted
+func (x *Restrictions) HidesGenre(name string) bool {
+	return x != nil && slices.Contains(x.Genres, name)
+}
+
+// HidesTag reports whether books with the tag are restricted
+func (x *Restrictions) HidesTag(name string) bool {
+	return x != nil && slices.Contains(x.Tags, name)
+}
+
+// restrictionConditions returns the WHERE conditions on b that leave out
+// the books x restricts, with their arguments
+func restrictionConditions(x *Restrictions) ([]string, []interface{}) {
+	if x == nil {
+		return nil, nil
+	}
+	var conditions []string
+	var args []interface{}
+	if len(x.Genres) > 0 {
+		conditions = append(conditions, "NOT "+genreFilterCondition(len(x.Genres)))
+		for _, genre := range x.Genres {
+			args = append(args, genre)
+		}
+	}
+	if len(x.Tags) > 0 {
+		conditions = append(conditions, fmt.Sprintf(
+			"b.id NOT IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name IN (%s))",
+			createPlaceholders(len(x.Tags))))
+		for _, tag := range x.Tags {
+			args = append(args, tag)
+		}
+	}
+	return conditions, args
+}
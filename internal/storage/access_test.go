@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// newAccessTestRepo creates a library with a plain book, an erotica book and
+// a book tagged "18+"
+func newAccessTestRepo(t *testing.T) *Repository {
+	t.Helper()
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	books := []inpx.Book{
+		{ID: "a-1", Title: "Капитанская дочка", Authors: []string{"Александр Пушкин"}, Genre: "prose_rus_classic"},
+		{ID: "a-2", Title: "Гавриилиада", Authors: []string{"Александр Пушкин"}, Genre: "love_erotica"},
+		{ID: "a-3", Title: "Тень Баркова", Authors: []string{"Александр Пушкин"}, Genre: "poetry"},
+	}
+	for i := range books {
+		books[i].Language, books[i].Format, books[i].Date = "ru", "fb2", time.Now()
+		books[i].ArchivePath, books[i].FileNum = "books", books[i].ID
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	tag, err := repo.CreateTag("18+")
+	if err != nil {
+		t.Fatalf("CreateTag: %v", err)
+	}
+	if err := repo.AddBookTag("a-3", tag.ID); err != nil {
+		t.Fatalf("AddBookTag: %v", err)
+	}
+	return repo
+}
+
+func visibleIDs(t *testing.T, repo *Repository, hidden *Restrictions) map[string]bool {
+	t.Helper()
+	result, err := repo.SearchBooks(BookFilter{Hidden: hidden, Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchBooks: %v", err)
+	}
+	ids := make(map[string]bool)
+	for _, book := range result.Books {
+		ids[book.ID] = true
+	}
+	if result.Total != len(ids) {
+		t.Errorf("total = %d, want %d", result.Total, len(ids))
+	}
+	return ids
+}
+
+func TestRestrictionsFor(t *testing.T) {
+	repo := newAccessTestRepo(t)
+
+	// Without rules nothing is hidden
+	if hidden, err := repo.RestrictionsFor(nil); err != nil || hidden != nil {
+		t.Fatalf("RestrictionsFor(nil) = %+v, %v; want nil", hidden, err)
+	}
+
+	if err := repo.SetAccessRule(AccessRule{Kind: AccessKindGenre, Name: "love_erotica"}); err != nil {
+		t.Fatalf("SetAccessRule: %v", err)
+	}
+	if err := repo.SetAccessRule(AccessRule{Kind: AccessKindTag, Name: "18+", Role: "adult"}); err != nil {
+		t.Fatalf("SetAccessRule: %v", err)
+	}
+	if err := repo.SetAccessRule(AccessRule{Kind: "author", Name: "x"}); err != ErrInvalidAccessRule {
+		t.Errorf("SetAccessRule(author) = %v, want ErrInvalidAccessRule", err)
+	}
+
+	reader, err := repo.CreateUser("reader", "secret123", "", false)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	adult, err := repo.CreateUser("adult", "secret123", "", false)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := repo.SetUserRoles(adult.ID, []string{"adult", " "}); err != nil {
+		t.Fatalf("SetUserRoles: %v", err)
+	}
+	admin, err := repo.CreateUser("admin", "secret123", "", true)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user *User
+		want []string
+	}{
+		{"anonymous", nil, []string{"a-1"}},
+		{"signed in", reader, []string{"a-1", "a-2"}},
+		{"with role", adult, []string{"a-1", "a-2", "a-3"}},
+		{"admin", admin, []string{"a-1", "a-2", "a-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hidden, err := repo.RestrictionsFor(tt.user)
+			if err != nil {
+				t.Fatalf("RestrictionsFor: %v", err)
+			}
+			ids := visibleIDs(t, repo, hidden)
+			if len(ids) != len(tt.want) {
+				t.Errorf("visible = %v, want %v", ids, tt.want)
+			}
+			for _, id := range tt.want {
+				if !ids[id] {
+					t.Errorf("book %s is hidden, want visible", id)
+				}
+			}
+		})
+	}
+
+	// Lists of genres and tags leave out the hidden ones
+	hidden, _ := repo.RestrictionsFor(nil)
+	genres, total, err := repo.ListVisibleGenres(10, 0, hidden)
+	if err != nil {
+		t.Fatalf("ListVisibleGenres: %v", err)
+	}
+	if total != 2 || len(genres) != 2 {
+		t.Errorf("visible genres = %v (total %d), want 2", genres, total)
+	}
+	tags, total, err := repo.ListVisibleTags(10, 0, hidden)
+	if err != nil {
+		t.Fatalf("ListVisibleTags: %v", err)
+	}
+	if total != 0 || len(tags) != 0 {
+		t.Errorf("visible tags = %v (total %d), want none", tags, total)
+	}
+
+	// Removing a rule takes effect immediately
+	if ok, err := repo.DeleteAccessRule(AccessKindGenre, "love_erotica"); err != nil || !ok {
+		t.Fatalf("DeleteAccessRule = %v, %v", ok, err)
+	}
+	hidden, _ = repo.RestrictionsFor(nil)
+	if ids := visibleIDs(t, repo, hidden); !ids["a-2"] || ids["a-3"] {
+		t.Errorf("visible after delete = %v, want a-1 and a-2", ids)
+	}
+
+	// Roles are listed with users and removed with them
+	users, err := repo.ListUsers()
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	for _, user := range users {
+		if user.ID == adult.ID && (len(user.Roles) != 1 || user.Roles[0] != "adult") {
+			t.Errorf("roles of %s = %v, want [adult]", user.Username, user.Roles)
+		}
+	}
+	if err := repo.DeleteUser(adult.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if roles, err := repo.GetUserRoles(adult.ID); err != nil || len(roles) != 0 {
+		t.Errorf("roles after delete = %v, %v", roles, err)
+	}
+}
+
+func TestRestrictions_Hides(t *testing.T) {
+	hidden := &Restrictions{Genres: []string{"love_erotica"}, Tags: []string{"18+"}}
+
+	tests := []struct {
+		name string
+		book Book
+		want bool
+	}{
+		{"plain", Book{Genre: &Genre{Name: "poetry"}}, false},
+		{"genre", Book{Genre: &Genre{Name: "love_erotica"}}, true},
+		{"tag", Book{Tags: []Tag{{Name: "classic"}, {Name: "18+"}}}, true},
+		{"no genre", Book{}, false},
+	}
+	for _, tt := range tests {
+		if got := hidden.Hides(&tt.book); got != tt.want {
+			t.Errorf("%s: Hides = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var none *Restrictions
+	if none.Hides(&Book{Genre: &Genre{Name: "love_erotica"}}) {
+		t.Error("nil restrictions hide a book")
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrAuthorNotFound is returned when an operation targets an author that does not exist.
+var ErrAuthorNotFound = errors.New("author not found")
+
+// LibraryStats contains aggregate counters for the admin panel
+type LibraryStats struct {
+	Books        int   `json:"books"`
+	Authors      int   `json:"authors"`
+	Series       int   `json:"series"`
+	Genres       int   `json:"genres"`
+	Tags         int   `json:"tags"`
+	Users        int   `json:"users"`
+	Overrides    int   `json:"overrides"`
+	AuthorMerges int   `json:"author_merges"`
+	ImportErrors int   `json:"import_errors"`
+	TotalSize    int64 `json:"total_size"`
+}
+
+// GetLibraryStats returns aggregate counters for the library
+func (r *Repository) GetLibraryStats() (*LibraryStats, error) {
+	var stats LibraryStats
+	err := r.db.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM books),
+			(SELECT COUNT(*) FROM authors),
+			(SELECT COUNT(*) FROM series),
+			(SELECT COUNT(*) FROM genres),
+			(SELECT COUNT(*) FROM tags),
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM book_overrides),
+			(SELECT COUNT(*) FROM author_merges),
+			(SELECT COUNT(*) FROM import_errors),
+			(SELECT COALESCE(SUM(file_size), 0) FROM books)`,
+	).Scan(&stats.Books, &stats.Authors, &stats.Series, &stats.Genres, &stats.Tags,
+		&stats.Users, &stats.Overrides, &stats.AuthorMerges, &stats.ImportErrors, &stats.TotalSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load library stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// FindAuthors returns authors whose name contains the query
+func (r *Repository) FindAuthors(query string, limit int) ([]Author, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT id, name FROM authors WHERE name LIKE ? ORDER BY LOWER(name) LIMIT ?`,
+		"%"+query+"%", limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query authors: %w", err)
+	}
+	defer rows.Close()
+
+	var authors []Author
+	for rows.Next() {
+		var author Author
+		if err := rows.Scan(&author.ID, &author.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan author: %w", err)
+		}
+		authors = append(authors, author)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating authors: %w", err)
+	}
+	return authors, nil
+}
+
+// MergeAuthors moves all books of the source author to the target author and
+// deletes the source. The merge is remembered by name and re-applied after reindex.
+func (r *Repository) MergeAuthors(sourceID, targetID int) (*Author, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var source, target Author
+	if err := tx.QueryRow("SELECT id, name FROM authors WHERE id = ?", sourceID).Scan(&source.ID, &source.Name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAuthorNotFound
+		}
+		return nil, fmt.Errorf("failed to load author %d: %w", sourceID, err)
+	}
+	if err := tx.QueryRow("SELECT id, name FROM authors WHERE id = ?", targetID).Scan(&target.ID, &target.Name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAuthorNotFound
+		}
+		return nil, fmt.Errorf("failed to load author %d: %w", targetID, err)
+	}
+
+	if err := mergeAuthorsTx(tx, source.ID, target.ID); err != nil {
+		return nil, fmt.Errorf("failed to merge authors: %w", err)
+	}
+
+	// Earlier merges into the source now point to the new target, and so
+	// do its aliases
+	if _, err := tx.Exec("UPDATE author_merges SET target_name = ? WHERE target_name = ?", target.Name, source.Name); err != nil {
+		return nil, fmt.Errorf("failed to update author merges: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE author_aliases SET author_name = ? WHERE author_name = ?", target.Name, source.Name); err != nil {
+		return nil, fmt.Errorf("failed to update author aliases: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM author_aliases WHERE alias_name = author_name"); err != nil {
+		return nil, fmt.Errorf("failed to update author aliases: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO author_merges (source_name, target_name) VALUES (?, ?)
+		 ON CONFLICT(source_name) DO UPDATE SET target_name = excluded.target_name`,
+		source.Name, target.Name,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save author merge: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit author merge: %w", err)
+	}
+
+	return &target, nil
+}
+
+// ApplyAuthorMerges re-applies stored author merges after INPX import.
+// Returns the number of merges applied.
+func (r *Repository) ApplyAuthorMerges() (int, error) {
+	rows, err := r.db.db.Query(`
+		SELECT s.id, t.id
+		FROM author_merges m
+		JOIN authors s ON s.name = m.source_name
+		JOIN authors t ON t.name = m.target_name`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query author merges: %w", err)
+	}
+
+	type pair struct{ source, target int }
+	var pairs []pair
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.source, &p.target); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan author merge: %w", err)
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating author merges: %w", err)
+	}
+	rows.Close()
+
+	if len(pairs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range pairs {
+		if p.source == p.target {
+			continue
+		}
+		if err := mergeAuthorsTx(tx, p.source, p.target); err != nil {
+			return 0, fmt.Errorf("failed to merge author %d into %d: %w", p.source, p.target, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit author merges: %w", err)
+	}
+
+	return len(pairs), nil
+}
+
+// mergeAuthorsTx reassigns book links from source to target, deletes the source
+// author and refreshes FTS rows of affected books
+func mergeAuthorsTx(tx *sql.Tx, sourceID, targetID int) error {
+	rows, err := tx.Query("SELECT book_id FROM book_authors WHERE author_id = ?", sourceID)
+	if err != nil {
+		return err
+	}
+	var bookIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		bookIDs = append(bookIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT OR IGNORE INTO book_authors (book_id, author_id) SELECT book_id, ? FROM book_authors WHERE author_id = ?",
+		targetID, sourceID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM book_authors WHERE author_id = ?", sourceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM authors WHERE id = ?", sourceID); err != nil {
+		return err
+	}
+
+	for _, id := range bookIDs {
+		if err := refreshBookFTSTx(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
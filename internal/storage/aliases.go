@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAlias is returned when an author would become an alias of
+// itself.
+var ErrInvalidAlias = errors.New("author cannot be an alias of itself")
+
+// ListAuthorAliases returns all aliases ordered by canonical author.
+func (r *Repository) ListAuthorAliases() ([]AuthorAlias, error) {
+	rows, err := r.db.db.Query(
+		`SELECT alias_name, author_name, created_at FROM author_aliases ORDER BY LOWER(author_name), LOWER(alias_name)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query author aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []AuthorAlias{}
+	for rows.Next() {
+		var alias AuthorAlias
+		if err := rows.Scan(&alias.Alias, &alias.Author, &alias.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan author alias: %w", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating author aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// SetAuthorAlias makes the author aliasID an alias of authorID. Aliases
+// form one level: if authorID is an alias itself, its canonical author is
+// used, and aliases of aliasID move to the canonical author.
+func (r *Repository) SetAuthorAlias(aliasID, authorID int) (*AuthorAlias, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	alias, err := authorNameTx(tx, aliasID)
+	if err != nil {
+		return nil, err
+	}
+	author, err := authorNameTx(tx, authorID)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := canonicalAuthorTx(tx, author)
+	if err != nil {
+		return nil, err
+	}
+	if canonical == alias {
+		return nil, ErrInvalidAlias
+	}
+
+	if _, err := tx.Exec("UPDATE author_aliases SET author_name = ? WHERE author_name = ?", canonical, alias); err != nil {
+		return nil, fmt.Errorf("failed to move author aliases: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO author_aliases (alias_name, author_name) VALUES (?, ?)
+		 ON CONFLICT(alias_name) DO UPDATE SET author_name = excluded.author_name`,
+		alias, canonical,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save author alias: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit author alias: %w", err)
+	}
+	return &AuthorAlias{Alias: alias, Author: canonical}, nil
+}
+
+// DeleteAuthorAlias unlinks the author aliasID from its canonical author; it
+// reports false if the author was no alias.
+func (r *Repository) DeleteAuthorAlias(aliasID int) (bool, error) {
+	result, err := r.db.db.Exec(
+		"DELETE FROM author_aliases WHERE alias_name = (SELECT name FROM authors WHERE id = ?)", aliasID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete author alias: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// AuthorNames returns name together with the canonical author and all
+// aliases it is linked with, name first.
+func (r *Repository) AuthorNames(name string) ([]string, error) {
+	var canonical string
+	err := r.db.db.QueryRow("SELECT author_name FROM author_aliases WHERE alias_name = ?", name).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		canonical = name
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to resolve author alias: %w", err)
+	}
+
+	rows, err := r.db.db.Query(
+		"SELECT alias_name FROM author_aliases WHERE author_name = ? ORDER BY LOWER(alias_name)", canonical)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query author aliases: %w", err)
+	}
+	defer rows.Close()
+
+	names := []string{name}
+	if canonical != name {
+		names = append(names, canonical)
+	}
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, fmt.Errorf("failed to scan author alias: %w", err)
+		}
+		if alias != name {
+			names = append(names, alias)
+		}
+	}
+	return names, rows.Err()
+}
+
+// expandAuthorAliases adds the linked names of every author in names
+func (r *Repository) expandAuthorAliases(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	var expanded []string
+	for _, name := range names {
+		linked, err := r.AuthorNames(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range linked {
+			if !seen[n] {
+				seen[n] = true
+				expanded = append(expanded, n)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+func authorNameTx(tx *sql.Tx, id int) (string, error) {
+	var name string
+	if err := tx.QueryRow("SELECT name FROM authors WHERE id = ?", id).Scan(&name); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrAuthorNotFound
+		}
+		return "", fmt.Errorf("failed to load author %d: %w", id, err)
+	}
+	return name, nil
+}
+
+func canonicalAuthorTx(tx *sql.Tx, name string) (string, error) {
+	var canonical string
+	err := tx.QueryRow("SELECT author_name FROM author_aliases WHERE alias_name = ?", name).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		return name, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve author alias: %w", err)
+	}
+	return canonical, nil
+}
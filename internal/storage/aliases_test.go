@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestAuthorAliases(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	books := []inpx.Book{
+		{ID: "b-1", Title: "Двенадцать стульев", Authors: []string{"Илья Ильф"}},
+		{ID: "b-2", Title: "Золотой телёнок", Authors: []string{"Ильф и Петров"}},
+		{ID: "b-3", Title: "Светлая личность", Authors: []string{"Ф. Толстоевский"}},
+		{ID: "b-4", Title: "Фронтовой дневник", Authors: []string{"Евгений Петров"}},
+	}
+	for i := range books {
+		books[i].Genre, books[i].Language, books[i].Format, books[i].Date = "prose", "ru", "fb2", time.Now()
+		books[i].ArchivePath, books[i].FileNum = "books", books[i].ID
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	authorID := func(name string) int {
+		t.Helper()
+		authors, err := repo.FindAuthors(name, 1)
+		if err != nil || len(authors) == 0 {
+			t.Fatalf("FindAuthors(%q) = %v, %v", name, authors, err)
+		}
+		return authors[0].ID
+	}
+	booksOf := func(name string) int {
+		t.Helper()
+		result, err := repo.SearchBooks(BookFilter{Authors: []string{name}, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks: %v", err)
+		}
+		return result.Total
+	}
+
+	ilf, duet, pseudonym := authorID("Илья Ильф"), authorID("Ильф и Петров"), authorID("Толстоевский")
+	if _, err := repo.SetAuthorAlias(duet, ilf); err != nil {
+		t.Fatalf("SetAuthorAlias: %v", err)
+	}
+	// An alias of an alias is linked to the canonical author
+	alias, err := repo.SetAuthorAlias(pseudonym, duet)
+	if err != nil {
+		t.Fatalf("SetAuthorAlias: %v", err)
+	}
+	if alias.Author != "Илья Ильф" {
+		t.Errorf("alias linked to %q, want the canonical author", alias.Author)
+	}
+	if _, err := repo.SetAuthorAlias(ilf, pseudonym); err != ErrInvalidAlias {
+		t.Errorf("SetAuthorAlias(canonical, own alias) = %v, want ErrInvalidAlias", err)
+	}
+	if _, err := repo.SetAuthorAlias(ilf, 9999); err != ErrAuthorNotFound {
+		t.Errorf("SetAuthorAlias(unknown) = %v, want ErrAuthorNotFound", err)
+	}
+
+	for _, name := range []string{"Илья Ильф", "Ильф и Петров", "Ф. Толстоевский"} {
+		if n := booksOf(name); n != 3 {
+			t.Errorf("books of %q = %d, want 3", name, n)
+		}
+	}
+	if n := booksOf("Евгений Петров"); n != 1 {
+		t.Errorf("unrelated author has %d books, want 1", n)
+	}
+
+	names, err := repo.AuthorNames("Ф. Толстоевский")
+	if err != nil {
+		t.Fatalf("AuthorNames: %v", err)
+	}
+	if len(names) != 3 || names[0] != "Ф. Толстоевский" || names[1] != "Илья Ильф" {
+		t.Errorf("AuthorNames = %v", names)
+	}
+
+	deleted, err := repo.DeleteAuthorAlias(pseudonym)
+	if err != nil || !deleted {
+		t.Fatalf("DeleteAuthorAlias = %v, %v", deleted, err)
+	}
+	if deleted, _ := repo.DeleteAuthorAlias(pseudonym); deleted {
+		t.Error("expected a second delete to report false")
+	}
+	if n := booksOf("Илья Ильф"); n != 2 {
+		t.Errorf("books after unlinking = %d, want 2", n)
+	}
+	aliases, err := repo.ListAuthorAliases()
+	if err != nil || len(aliases) != 1 || aliases[0].Alias != "Ильф и Петров" {
+		t.Errorf("ListAuthorAliases = %+v, %v", aliases, err)
+	}
+}
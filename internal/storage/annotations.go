@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// annotationExpr is the annotation of book b, or NULL if it has none.
+// Annotations live apart from the books table so that scans over books
+// stay small; see BookFilter.WithAnnotations.
+const annotationExpr = `(SELECT bn.annotation FROM book_annotations bn WHERE bn.book_id = b.id)`
+
+// setBookAnnotationTx stores the annotation of a book; an empty one
+// removes the row
+func setBookAnnotationTx(tx *sql.Tx, bookID, annotation string) error {
+	if annotation == "" {
+		_, err := tx.Exec("DELETE FROM book_annotations WHERE book_id = ?", bookID)
+		return err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO book_annotations (book_id, annotation) VALUES (?, ?)
+		 ON CONFLICT(book_id) DO UPDATE SET annotation = excluded.annotation`,
+		bookID, annotation,
+	)
+	return err
+}
+
+// getBookAnnotation returns the annotation of a book, or "" if it has none
+func (r *Repository) getBookAnnotation(bookID string) (string, error) {
+	var annotation string
+	err := r.db.db.QueryRow("SELECT annotation FROM book_annotations WHERE book_id = ?", bookID).Scan(&annotation)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return annotation, err
+}
+
+// loadAnnotations fills in the annotations of books with one query
+func (r *Repository) loadAnnotations(books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	index := make(map[string]int, len(books))
+	args := make([]interface{}, 0, len(books))
+	for i, book := range books {
+		index[book.ID] = i
+		args = append(args, book.ID)
+	}
+
+	rows, err := r.db.db.Query(
+		"SELECT book_id, annotation FROM book_annotations WHERE book_id IN ("+createPlaceholders(len(args))+")", args...)
+	if err != nil {
+		return fmt.Errorf("failed to query annotations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, annotation string
+		if err := rows.Scan(&id, &annotation); err != nil {
+			return fmt.Errorf("failed to scan annotation: %w", err)
+		}
+		if i, ok := index[id]; ok {
+			books[i].Annotation = annotation
+		}
+	}
+	return rows.Err()
+}
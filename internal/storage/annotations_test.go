@@ -0,0 +1,97 @@
+package storage_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestAnnotationsLoadedOnDemand(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "a-1", Title: "С аннотацией", Annotation: "Длинная аннотация о море", Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()},
+		{ID: "a-2", Title: "Без аннотации", Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	book, err := repo.GetBookByID("a-1")
+	if err != nil || book == nil || book.Annotation != "Длинная аннотация о море" {
+		t.Fatalf("GetBookByID = %+v, %v; want the annotation", book, err)
+	}
+
+	list, err := repo.SearchBooks(storage.BookFilter{Query: "море"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(list.Books) != 1 || list.Books[0].Annotation != "" {
+		t.Errorf("expected one match without annotation, got %+v", list.Books)
+	}
+
+	list, err = repo.SearchBooks(storage.BookFilter{Query: "море", WithAnnotations: true})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(list.Books) != 1 || list.Books[0].Annotation != "Длинная аннотация о море" {
+		t.Errorf("expected the annotation to be loaded, got %+v", list.Books)
+	}
+
+	annotation := "Новая аннотация"
+	if _, err := repo.UpdateBook("a-2", storage.BookUpdate{Annotation: &annotation}); err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	if book, _ := repo.GetBookByID("a-2"); book == nil || book.Annotation != annotation {
+		t.Errorf("annotation after update = %+v, want %q", book, annotation)
+	}
+}
+
+func TestAnnotationsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	repo := storage.NewRepository(db)
+	if err := repo.InsertBooks([]inpx.Book{{ID: "m-1", Title: "Старая книга", Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()}}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	db.Close()
+
+	// Bring back the layout of older releases, which kept annotations in books
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		"ALTER TABLE books ADD COLUMN annotation TEXT",
+		"UPDATE books SET annotation = 'Аннотация из books'",
+		"DELETE FROM book_annotations",
+	} {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	raw.Close()
+
+	db, err = storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	book, err := storage.NewRepository(db).GetBookByID("m-1")
+	if err != nil || book == nil || book.Annotation != "Аннотация из books" {
+		t.Errorf("GetBookByID after migration = %+v, %v; want the moved annotation", book, err)
+	}
+}
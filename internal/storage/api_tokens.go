@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes of API tokens
+const (
+	ScopeRead     = "read"     // browse and search the catalog, OPDS feeds, reader
+	ScopeDownload = "download" // download book files
+	ScopeAdmin    = "admin"    // admin endpoints, for admin users only
+)
+
+// TokenScopes lists the valid scopes of API tokens
+var TokenScopes = []string{ScopeRead, ScopeDownload, ScopeAdmin}
+
+// APITokenPrefix starts every API token, which tells them apart from
+// session tokens
+const APITokenPrefix = "pkl_"
+
+// ErrAPITokenNotFound is returned for operations on a token that does not
+// exist or belongs to another user
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// tokenTouchInterval bounds how often the last use of a token is recorded
+const tokenTouchInterval = time.Minute
+
+// IsValidTokenScope reports whether scope is a scope of API tokens
+func IsValidTokenScope(scope string) bool {
+	for _, s := range TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token grants scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIToken is the stored form of a token
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a token of a user with the given scopes, which
+// expires at expiresAt unless it is nil. It returns the token record and
+// the token itself, which is not stored.
+func (r *Repository) CreateAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (*APIToken, string, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate api token id: %w", err)
+	}
+	secret, err := generateToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate api token: %w", err)
+	}
+	token := APITokenPrefix + secret
+
+	record := &APIToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(APITokenPrefix)+8],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if _, err := r.db.db.Exec(
+		`INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.UserID, record.Name, hashAPIToken(token), record.Prefix,
+		strings.Join(scopes, ","), record.CreatedAt, nullTime(expiresAt),
+	); err != nil {
+		return nil, "", fmt.Errorf("insert api token: %w", err)
+	}
+	return record, token, nil
+}
+
+// GetAPIToken returns the unexpired token record of a token, or nil if
+// there is none. It records the use of the token.
+func (r *Repository) GetAPIToken(token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, nil
+	}
+	row := r.db.db.QueryRow(
+		`SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		 FROM api_tokens WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		hashAPIToken(token), time.Now(),
+	)
+	record, err := scanAPIToken(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get api token: %w", err)
+	}
+
+	now := time.Now()
+	if !r.db.ReadOnly() && (record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= tokenTouchInterval) {
+		// Best effort: a failure to record the use does not fail the request
+		r.db.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, record.ID)
+		record.LastUsedAt = &now
+	}
+	return record, nil
+}
+
+// ListAPITokens returns the tokens of a user, newest first
+func (r *Repository) ListAPITokens(userID string) ([]APIToken, error) {
+	rows, err := r.db.db.Query(
+		`SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		 FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		record, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		tokens = append(tokens, *record)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes a token of a user, or fails with
+// ErrAPITokenNotFound
+func (r *Repository) DeleteAPIToken(userID, id string) error {
+	result, err := r.db.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("delete api token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAPITokenNotFound
+	}
+	if _, err := r.db.db.Exec("DELETE FROM format_preferences WHERE user_id = ? AND token_id = ?", userID, id); err != nil {
+		return fmt.Errorf("delete api token format preference: %w", err)
+	}
+	return nil
+}
+
+// scanAPIToken scans a row of id, user_id, name, prefix, scopes,
+// created_at, expires_at and last_used_at
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var record APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&record.ID, &record.UserID, &record.Name, &record.Prefix, &scopes,
+		&record.CreatedAt, &expiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		record.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		record.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		record.LastUsedAt = &lastUsedAt.Time
+	}
+	return &record, nil
+}
+
+// nullTime stores a nil time as NULL
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
@@ -0,0 +1,66 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestAPITokens(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	user, err := repo.CreateUser("bot", "botpass123", "Bot", false)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	record, token, err := repo.CreateAPIToken(user.ID, "script", []string{storage.ScopeRead, storage.ScopeDownload}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	found, err := repo.GetAPIToken(token)
+	if err != nil || found == nil || found.ID != record.ID || found.UserID != user.ID ||
+		!found.HasScope(storage.ScopeDownload) || found.HasScope(storage.ScopeAdmin) || found.LastUsedAt == nil {
+		t.Fatalf("GetAPIToken = %+v, %v", found, err)
+	}
+	if found, err := repo.GetAPIToken(token + "x"); err != nil || found != nil {
+		t.Errorf("GetAPIToken of a wrong token = %+v, %v; want nil", found, err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	_, expired, err := repo.CreateAPIToken(user.ID, "old", []string{storage.ScopeRead}, &past)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if found, err := repo.GetAPIToken(expired); err != nil || found != nil {
+		t.Errorf("GetAPIToken of an expired token = %+v, %v; want nil", found, err)
+	}
+
+	tokens, err := repo.ListAPITokens(user.ID)
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("ListAPITokens = %+v, %v", tokens, err)
+	}
+	if err := repo.DeleteAPIToken("someone-else", record.ID); err != storage.ErrAPITokenNotFound {
+		t.Errorf("DeleteAPIToken of another user = %v, want ErrAPITokenNotFound", err)
+	}
+	if err := repo.DeleteAPIToken(user.ID, record.ID); err != nil {
+		t.Fatalf("DeleteAPIToken failed: %v", err)
+	}
+	if found, _ := repo.GetAPIToken(token); found != nil {
+		t.Error("a revoked token is still valid")
+	}
+
+	if err := repo.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if tokens, err := repo.ListAPITokens(user.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("tokens of a deleted user = %+v, %v", tokens, err)
+	}
+}
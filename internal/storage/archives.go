@@ -0,0 +1,61 @@
+package storage
+
+import "fmt"
+
+// ListArchives returns every archive the books name, by path, with the
+// number of books in each. Books without an archive are counted under an
+// empty path.
+func (r *Repository) ListArchives() ([]Archive, error) {
+	rows, err := r.db.db.Query(
+		`SELECT COALESCE(b.archive_path, '') AS path, COUNT(*),
+		        EXISTS(SELECT 1 FROM hidden_archives h WHERE h.archive_path = b.archive_path)
+		 FROM books b
+		 GROUP BY path
+		 ORDER BY path`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archives: %w", err)
+	}
+	defer rows.Close()
+
+	archives := []Archive{}
+	for rows.Next() {
+		var archive Archive
+		if err := rows.Scan(&archive.ArchivePath, &archive.Books, &archive.Hidden); err != nil {
+			return nil, fmt.Errorf("failed to scan archive: %w", err)
+		}
+		archives = append(archives, archive)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating archives: %w", err)
+	}
+	return archives, nil
+}
+
+// ListArchiveBooks returns the books stored in an archive, by ID
+func (r *Repository) ListArchiveBooks(archivePath string) ([]ArchiveBook, error) {
+	rows, err := r.db.db.Query(
+		`SELECT id, title, COALESCE(format, ''), COALESCE(file_num, ''), COALESCE(file_size, 0)
+		 FROM books
+		 WHERE archive_path = ?
+		 ORDER BY id`,
+		archivePath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive books: %w", err)
+	}
+	defer rows.Close()
+
+	books := []ArchiveBook{}
+	for rows.Next() {
+		var book ArchiveBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.Format, &book.FileNum, &book.FileSize); err != nil {
+			return nil, fmt.Errorf("failed to scan archive book: %w", err)
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating archive books: %w", err)
+	}
+	return books, nil
+}
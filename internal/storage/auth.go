@@ -48,14 +48,14 @@ func (r *Repository) CreateUser(username, password, displayName string, isAdmin
 // GetUserByUsername returns a user by username, or nil if not found.
 func (r *Repository) GetUserByUsername(username string) (*User, error) {
 	row := r.db.db.QueryRow(
-		`SELECT id, username, password_hash, display_name, is_admin, created_at, updated_at
+		`SELECT id, username, password_hash, display_name, email, is_admin, created_at, updated_at
 		 FROM users WHERE username = ?`, username,
 	)
 
 	var user User
 	var isAdmin int
 	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName,
-		&isAdmin, &user.CreatedAt, &user.UpdatedAt)
+		&user.Email, &isAdmin, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -69,14 +69,14 @@ func (r *Repository) GetUserByUsername(username string) (*User, error) {
 // GetUserByID returns a user by ID, or nil if not found.
 func (r *Repository) GetUserByID(id string) (*User, error) {
 	row := r.db.db.QueryRow(
-		`SELECT id, username, password_hash, display_name, is_admin, created_at, updated_at
+		`SELECT id, username, password_hash, display_name, email, is_admin, created_at, updated_at
 		 FROM users WHERE id = ?`, id,
 	)
 
 	var user User
 	var isAdmin int
 	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName,
-		&isAdmin, &user.CreatedAt, &user.UpdatedAt)
+		&user.Email, &isAdmin, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -181,7 +181,7 @@ func (r *Repository) CountUsers() (int, error) {
 // ListUsers returns all users ordered by creation date.
 func (r *Repository) ListUsers() ([]User, error) {
 	rows, err := r.db.db.Query(
-		`SELECT id, username, password_hash, display_name, is_admin, created_at, updated_at
+		`SELECT id, username, password_hash, display_name, email, is_admin, created_at, updated_at
 		 FROM users ORDER BY created_at ASC`,
 	)
 	if err != nil {
@@ -194,21 +194,48 @@ func (r *Repository) ListUsers() ([]User, error) {
 		var user User
 		var isAdmin int
 		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName,
-			&isAdmin, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			&user.Email, &isAdmin, &user.CreatedAt, &user.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("scan user: %w", err)
 		}
 		user.IsAdmin = isAdmin != 0
 		users = append(users, user)
 	}
-	return users, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		roles, err := r.GetUserRoles(users[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		users[i].Roles = roles
+	}
+	return users, nil
 }
 
 // DeleteUser deletes a user and all their sessions by user ID.
 func (r *Repository) DeleteUser(id string) error {
-	// Delete sessions first
+	// Delete sessions, API tokens, roles, shelves, saved searches and format
+	// preferences first
 	if _, err := r.db.db.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
 		return fmt.Errorf("delete user sessions: %w", err)
 	}
+	if _, err := r.db.db.Exec("DELETE FROM api_tokens WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("delete user api tokens: %w", err)
+	}
+	if _, err := r.db.db.Exec("DELETE FROM user_roles WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("delete user roles: %w", err)
+	}
+	if _, err := r.db.db.Exec("DELETE FROM shelves WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("delete user shelves: %w", err)
+	}
+	if _, err := r.db.db.Exec("DELETE FROM saved_searches WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("delete user saved searches: %w", err)
+	}
+	if _, err := r.db.db.Exec("DELETE FROM format_preferences WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("delete user format preferences: %w", err)
+	}
 	result, err := r.db.db.Exec("DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("delete user: %w", err)
@@ -240,6 +267,23 @@ func (r *Repository) UpdateUserPassword(id, newPassword string) error {
 	return nil
 }
 
+// SetUserEmail sets the address a user's saved searches may mail new books
+// to; an empty address stops email notifications.
+func (r *Repository) SetUserEmail(id, email string) error {
+	result, err := r.db.db.Exec(
+		"UPDATE users SET email = ?, updated_at = ? WHERE id = ?",
+		email, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("update user email: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
 // generateID generates a random hex ID for users.
 func generateID() (string, error) {
 	b := make([]byte, 16)
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Reasons two authors are suggested as duplicates, from the surest
+const (
+	// DuplicateSameName: the names differ in case, punctuation, "ё" or the
+	// order of words only
+	DuplicateSameName = "same_name"
+	// DuplicateTransliteration: the names spell alike once romanized, as
+	// Cyrillic and Latin spellings or two romanizations of one name do
+	DuplicateTransliteration = "transliteration"
+	// DuplicateSimilar: the names differ by a typo or two
+	DuplicateSimilar = "similar"
+)
+
+const (
+	// duplicateWindow is the number of following names, in the order of
+	// their spelling keys, each name is compared with
+	duplicateWindow = 10
+	// minSimilarLength is the shortest spelling key, in runes, compared by
+	// edit distance; shorter names differ too much by a single letter
+	minSimilarLength = 10
+)
+
+// AuthorDuplicate is a pair of author records that likely name one person.
+// Target is the one with more books, the suggested target of a merge.
+type AuthorDuplicate struct {
+	Source Author `json:"source"`
+	Target Author `json:"target"`
+	Reason string `json:"reason"`
+	// Distance is the edit distance between the spelling keys of the names
+	Distance int `json:"distance"`
+}
+
+// FindAuthorDuplicates suggests pairs of authors to merge, surest first.
+// Authors marked ambiguous or split, and aliases of each other, are left
+// out: they were told apart on purpose.
+func (r *Repository) FindAuthorDuplicates() ([]AuthorDuplicate, error) {
+	rows, err := r.db.db.Query(`
+		SELECT a.id, a.name, COUNT(ba.book_id)
+		FROM authors a
+		JOIN book_authors ba ON ba.author_id = a.id
+		WHERE a.name NOT IN (SELECT author_name FROM ambiguous_authors)
+		  AND a.name NOT IN (SELECT author_name FROM author_splits)
+		  AND a.name NOT IN (SELECT target_name FROM author_splits)
+		GROUP BY a.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query authors: %w", err)
+	}
+	defer rows.Close()
+
+	var authors []Author
+	for rows.Next() {
+		var author Author
+		if err := rows.Scan(&author.ID, &author.Name, &author.BookCount); err != nil {
+			return nil, fmt.Errorf("failed to scan author: %w", err)
+		}
+		authors = append(authors, author)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating authors: %w", err)
+	}
+
+	aliases := make(map[[2]string]bool)
+	aliasRows, err := r.db.db.Query("SELECT alias_name, author_name FROM author_aliases")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query author aliases: %w", err)
+	}
+	defer aliasRows.Close()
+	for aliasRows.Next() {
+		var alias, name string
+		if err := aliasRows.Scan(&alias, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan author alias: %w", err)
+		}
+		aliases[[2]string{alias, name}] = true
+		aliases[[2]string{name, alias}] = true
+	}
+	if err := aliasRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating author aliases: %w", err)
+	}
+
+	var duplicates []AuthorDuplicate
+	for _, duplicate := range findAuthorDuplicates(authors) {
+		if !aliases[[2]string{duplicate.Source.Name, duplicate.Target.Name}] {
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+	return duplicates, nil
+}
+
+// duplicateReasons orders the reasons from the surest
+var duplicateReasons = map[string]int{DuplicateSameName: 0, DuplicateTransliteration: 1, DuplicateSimilar: 2}
+
+// findAuthorDuplicates pairs authors whose names normalize or transliterate
+// to the same words, or whose spelling keys are close. Rather than all
+// pairs, each name is compared with the next ones in key order, which
+// finds most typos in linear time.
+func findAuthorDuplicates(authors []Author) []AuthorDuplicate {
+	type entry struct {
+		author     Author
+		normalized string
+		key        string
+	}
+	entries := make([]entry, 0, len(authors))
+	for _, author := range authors {
+		normalized := normalizeAuthorName(author.Name)
+		if normalized == "" {
+			continue
+		}
+		entries = append(entries, entry{author, normalized, spellingKey(normalized)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].author.ID < entries[j].author.ID
+	})
+
+	var duplicates []AuthorDuplicate
+	for i := range entries {
+		for j := i + 1; j < len(entries) && j <= i+duplicateWindow; j++ {
+			a, b := entries[i], entries[j]
+			var reason string
+			distance := 0
+			switch {
+			case a.normalized == b.normalized:
+				reason = DuplicateSameName
+			case a.key == b.key:
+				reason = DuplicateTransliteration
+			default:
+				if utf8.RuneCountInString(a.key) < minSimilarLength || utf8.RuneCountInString(b.key) < minSimilarLength {
+					continue
+				}
+				distance = editDistance(a.key, b.key)
+				if distance > maxDuplicateDistance(a.key, b.key) {
+					continue
+				}
+				reason = DuplicateSimilar
+			}
+
+			source, target := a.author, b.author
+			if source.BookCount > target.BookCount || (source.BookCount == target.BookCount && source.ID < target.ID) {
+				source, target = target, source
+			}
+			duplicates = append(duplicates, AuthorDuplicate{Source: source, Target: target, Reason: reason, Distance: distance})
+		}
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		a, b := duplicates[i], duplicates[j]
+		if a.Reason != b.Reason {
+			return duplicateReasons[a.Reason] < duplicateReasons[b.Reason]
+		}
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		return a.Source.BookCount+a.Target.BookCount > b.Source.BookCount+b.Target.BookCount
+	})
+	return duplicates
+}
+
+// maxDuplicateDistance is the edit distance up to which two spelling keys
+// are taken for one name: a letter in short names, two in long ones
+func maxDuplicateDistance(a, b string) int {
+	if min(utf8.RuneCountInString(a), utf8.RuneCountInString(b)) >= 12 {
+		return 2
+	}
+	return 1
+}
+
+// normalizeAuthorName lowercases a name, folds "ё" and Latin diacritics,
+// drops punctuation and sorts the words, so "Толстой, Лев" and "лев
+// толстой" normalize alike
+func normalizeAuthorName(name string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFC.String(yoFolder.Replace(name)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			// "é" → "e", but "й" stays a letter of its own
+			if !unicode.Is(unicode.Cyrillic, r) {
+				r = []rune(norm.NFD.String(string(r)))[0]
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		case unicode.Is(unicode.Mn, r):
+		default:
+			sb.WriteByte(' ')
+		}
+	}
+	words := strings.Fields(sb.String())
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// spellingTable romanizes Cyrillic letters for spelling keys, simply
+// rather than exactly: letters that romanizations spell differently map
+// to the same Latin letters
+var spellingTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch",
+	'ъ': "", 'ы': "i", 'ь': "", 'э': "e", 'ю': "iu", 'я': "ia",
+	'і': "i", 'ї': "i", 'є': "e", 'ґ': "g", 'ў': "u",
+}
+
+// latinSpellings folds Latin spellings of the same sounds, in order
+var latinSpellings = strings.NewReplacer(
+	"shch", "sch", "kh", "h", "ph", "f", "tz", "ts", "ck", "k", "x", "ks",
+	"w", "v", "y", "i", "j", "i", "q", "k",
+)
+
+// spellingKey romanizes a normalized name and folds romanization variants,
+// so that "толстой лев" and "lev tolstoy" get the same key
+func spellingKey(normalized string) string {
+	var sb strings.Builder
+	for _, r := range normalized {
+		if latin, ok := spellingTable[r]; ok {
+			sb.WriteString(latin)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	key := latinSpellings.Replace(sb.String())
+
+	// Doubled letters are often single in another spelling: "Бродский"
+	// romanizes to "brodskii", "Brodsky" to "brodski"
+	var out strings.Builder
+	var prev rune
+	for _, r := range key {
+		if r != prev || r == ' ' {
+			out.WriteRune(r)
+		}
+		prev = r
+	}
+
+	// Romanized words may sort in another order
+	words := strings.Fields(out.String())
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
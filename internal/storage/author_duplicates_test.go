@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// TestFindAuthorDuplicates checks names are paired by normalization,
+// transliteration and edit distance, with the larger author as target, and
+// that ambiguous authors and aliases are left out.
+func TestFindAuthorDuplicates(t *testing.T) {
+	repo := newSearchTestRepo(t) // "Лев Толстой" with one book
+	var books []inpx.Book
+	for i, authors := range [][]string{
+		{"Толстой, Лев"},
+		{"Lev Tolstoy"},
+		{"Лев Толстой"},
+		{"Фёдор Достоевский"},
+		{"Федор Достоевскй"},
+		{"Иосиф Бродский"},
+		{"Iosif Brodsky"},
+		{"Иван Петров"},
+		{"Иван Петрова"},
+		{"Михаил Булгаков"},
+		{"Михаил Булгакв"},
+		{"Анна Ким"},
+		{"Анна Кин"},
+	} {
+		books = append(books, inpx.Book{
+			ID: "d-" + string(rune('a'+i)), Title: "Книга", Authors: authors,
+			Language: "ru", Format: "fb2", Date: time.Now(),
+		})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	ids := make(map[string]int)
+	authors, _, err := repo.ListAuthors(100, 0)
+	if err != nil {
+		t.Fatalf("ListAuthors failed: %v", err)
+	}
+	for _, author := range authors {
+		ids[author.Name] = author.ID
+	}
+	if err := repo.SetAuthorAmbiguous(ids["Иван Петров"], "два автора"); err != nil {
+		t.Fatalf("SetAuthorAmbiguous failed: %v", err)
+	}
+	if _, err := repo.SetAuthorAlias(ids["Iosif Brodsky"], ids["Иосиф Бродский"]); err != nil {
+		t.Fatalf("SetAuthorAlias failed: %v", err)
+	}
+
+	duplicates, err := repo.FindAuthorDuplicates()
+	if err != nil {
+		t.Fatalf("FindAuthorDuplicates failed: %v", err)
+	}
+
+	type pair struct{ source, target, reason string }
+	want := []pair{
+		{"Толстой, Лев", "Лев Толстой", DuplicateSameName},
+		{"Lev Tolstoy", "Лев Толстой", DuplicateTransliteration},
+		{"Федор Достоевскй", "Фёдор Достоевский", DuplicateTransliteration},
+		{"Lev Tolstoy", "Толстой, Лев", DuplicateTransliteration},
+		{"Михаил Булгакв", "Михаил Булгаков", DuplicateSimilar},
+	}
+	var got []pair
+	for _, d := range duplicates {
+		got = append(got, pair{d.Source.Name, d.Target.Name, d.Reason})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d duplicates, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("duplicate %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+	if target := duplicates[0].Target; target.BookCount != 2 {
+		t.Errorf("expected the target to have 2 books, got %+v", target)
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetAuthorInfo returns cached external info for an author name, or nil if
+// nothing has been fetched yet.
+func (r *Repository) GetAuthorInfo(name string) (*AuthorInfo, error) {
+	var (
+		info                                 AuthorInfo
+		bio, photoURL, sourceURL, wikidataID sql.NullString
+	)
+	err := r.db.db.QueryRow(
+		`SELECT author_name, found, bio, photo_url, source_url, wikidata_id, fetched_at
+		 FROM author_info WHERE author_name = ?`, name,
+	).Scan(&info.AuthorName, &info.Found, &bio, &photoURL, &sourceURL, &wikidataID, &info.FetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load author info for %q: %w", name, err)
+	}
+
+	info.Bio = bio.String
+	info.PhotoURL = photoURL.String
+	info.SourceURL = sourceURL.String
+	info.WikidataID = wikidataID.String
+	return &info, nil
+}
+
+// SaveAuthorInfo stores or replaces cached external info for an author.
+func (r *Repository) SaveAuthorInfo(info *AuthorInfo) error {
+	if _, err := r.db.db.Exec(
+		`INSERT INTO author_info (author_name, found, bio, photo_url, source_url, wikidata_id, fetched_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(author_name) DO UPDATE SET
+		   found = excluded.found,
+		   bio = excluded.bio,
+		   photo_url = excluded.photo_url,
+		   source_url = excluded.source_url,
+		   wikidata_id = excluded.wikidata_id,
+		   fetched_at = excluded.fetched_at`,
+		info.AuthorName, info.Found, info.Bio, info.PhotoURL, info.SourceURL, info.WikidataID, info.FetchedAt,
+	); err != nil {
+		return fmt.Errorf("failed to save author info for %q: %w", info.AuthorName, err)
+	}
+	return nil
+}
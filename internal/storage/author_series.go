@@ -0,0 +1,51 @@
+package storage
+
+import "fmt"
+
+// ListAuthorSeries returns the series the books of the author with the
+// given name belong to, as their main or a further series, with the number
+// of those books. Only books in language, unless it is empty, and books not
+// hidden by restrictions count.
+func (r *Repository) ListAuthorSeries(author, language string, hidden *Restrictions) ([]Series, error) {
+	return cachedQuery(r, func() ([]Series, error) {
+		return r.listAuthorSeries(author, language, hidden)
+	}, "author_series", author, language, hidden)
+}
+
+func (r *Repository) listAuthorSeries(author, language string, hidden *Restrictions) ([]Series, error) {
+	condition, args := visibleBooksCondition(hidden)
+	condition = `b.id IN (SELECT fba.book_id FROM book_authors fba JOIN authors fa ON fa.id = fba.author_id
+		WHERE fa.name = ?) AND ` + condition
+	args = append([]interface{}{author}, args...)
+	if language != "" {
+		condition += " AND b.language = ?"
+		args = append(args, language)
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT s.id, s.name, COUNT(*) FROM (
+			SELECT b.id AS book_id, b.series_id AS series_id FROM books b
+			WHERE b.series_id IS NOT NULL AND `+condition+`
+			UNION
+			SELECT b.id, bs.series_id FROM book_series bs JOIN books b ON b.id = bs.book_id
+			WHERE `+condition+`
+		) x JOIN series s ON s.id = x.series_id
+		GROUP BY s.id
+		ORDER BY LOWER(s.name)`,
+		append(append([]interface{}{}, args...), args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query author series: %w", err)
+	}
+	defer rows.Close()
+
+	var seriesList []Series
+	for rows.Next() {
+		var series Series
+		if err := rows.Scan(&series.ID, &series.Name, &series.BookCount); err != nil {
+			return nil, fmt.Errorf("failed to scan author series: %w", err)
+		}
+		seriesList = append(seriesList, series)
+	}
+	return seriesList, rows.Err()
+}
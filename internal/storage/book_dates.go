@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// bookDatesUpsert records the fingerprint of imported books; the time only
+// moves when the fingerprint differs from the stored one.
+const (
+	bookDatesUpsert   = "INSERT INTO book_dates (book_id, fingerprint, updated_at) VALUES "
+	bookDatesConflict = ` ON CONFLICT(book_id) DO UPDATE SET
+		updated_at = CASE WHEN book_dates.fingerprint = excluded.fingerprint
+		                  THEN book_dates.updated_at ELSE excluded.updated_at END,
+		fingerprint = excluded.fingerprint`
+)
+
+// bookFingerprint hashes the catalog data of a book, so that importing an
+// unchanged book again does not count as a change
+func bookFingerprint(book inpx.Book) string {
+	data, _ := json.Marshal(book)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// setBookDateTx records an imported book and copies the time its data last
+// changed to books.updated_at
+func setBookDateTx(tx *sql.Tx, book inpx.Book, now time.Time) error {
+	if _, err := tx.Exec(bookDatesUpsert+"(?, ?, ?)"+bookDatesConflict, book.ID, bookFingerprint(book), now); err != nil {
+		return err
+	}
+	return applyBookDatesTx(tx, []interface{}{book.ID})
+}
+
+// applyBookDatesTx copies the recorded times of the given books to books.updated_at
+func applyBookDatesTx(tx *sql.Tx, ids []interface{}) error {
+	_, err := tx.Exec(
+		`UPDATE books SET updated_at = (SELECT d.updated_at FROM book_dates d WHERE d.book_id = books.id)
+		 WHERE id IN (`+createPlaceholders(len(ids))+`)
+		   AND EXISTS (SELECT 1 FROM book_dates d WHERE d.book_id = books.id)`, ids...)
+	return err
+}
+
+// CatalogUpdatedAt returns when the catalog last changed: the latest time a
+// book was added or its data changed. It is zero for an empty catalog.
+func (r *Repository) CatalogUpdatedAt() (time.Time, error) {
+	return cachedQuery(r, func() (time.Time, error) {
+		var updated time.Time
+		err := r.db.db.QueryRow("SELECT updated_at FROM books ORDER BY updated_at DESC LIMIT 1").Scan(&updated)
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to query catalog update time: %w", err)
+		}
+		return updated, nil
+	}, "catalogUpdated")
+}
@@ -0,0 +1,86 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// TestBookDatesSurviveReindex verifies that a reindex keeps the update time
+// of unchanged books and moves it for changed ones
+func TestBookDatesSurviveReindex(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	added := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	books := []inpx.Book{
+		{ID: "d-1", Title: "Неизменная", Authors: []string{"Автор"}, Format: "fb2", Date: added},
+		{ID: "d-2", Title: "Изменённая", Authors: []string{"Автор"}, Format: "fb2", Date: added},
+	}
+	reindex := func() {
+		t.Helper()
+		if err := repo.ClearAllBooks(); err != nil {
+			t.Fatalf("ClearAllBooks failed: %v", err)
+		}
+		if err := repo.InsertBooks(books); err != nil {
+			t.Fatalf("InsertBooks failed: %v", err)
+		}
+	}
+	updated := func(id string) time.Time {
+		t.Helper()
+		book, err := repo.GetBookByID(id)
+		if err != nil || book == nil {
+			t.Fatalf("GetBookByID(%s) = %+v, %v", id, book, err)
+		}
+		return book.UpdatedAt
+	}
+
+	reindex()
+	first := updated("d-1")
+	if first.IsZero() {
+		t.Fatal("expected an update time")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	books[1].Title = "Изменённая книга"
+	reindex()
+	if got := updated("d-1"); !got.Equal(first) {
+		t.Errorf("unchanged book moved from %v to %v", first, got)
+	}
+	if got := updated("d-2"); !got.After(first) {
+		t.Errorf("changed book kept %v", got)
+	}
+
+	latest, err := repo.CatalogUpdatedAt()
+	if err != nil {
+		t.Fatalf("CatalogUpdatedAt failed: %v", err)
+	}
+	if !latest.Equal(updated("d-2")) {
+		t.Errorf("CatalogUpdatedAt = %v, want %v", latest, updated("d-2"))
+	}
+
+	// A manual correction is a change, but re-applying it is not
+	title := "Исправленное название"
+	if _, err := repo.UpdateBook("d-1", storage.BookUpdate{Title: &title}); err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	edited := updated("d-1")
+	if !edited.After(first) {
+		t.Errorf("edited book kept %v", edited)
+	}
+	time.Sleep(10 * time.Millisecond)
+	reindex()
+	if _, err := repo.ApplyBookOverrides(); err != nil {
+		t.Fatalf("ApplyBookOverrides failed: %v", err)
+	}
+	if got := updated("d-1"); !got.Equal(edited) {
+		t.Errorf("re-applied correction moved the update time from %v to %v", edited, got)
+	}
+}
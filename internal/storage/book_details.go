@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetBookDetails returns a book with the books before and after it in its
+// series, up to authorBooks other books of its authors and its genres with
+// their numbers of books. Only books the viewer may see are counted and
+// listed. It returns nil if there is no such book.
+func (r *Repository) GetBookDetails(id string, authorBooks int, hidden *Restrictions) (*BookDetails, error) {
+	book, err := r.GetBookByID(id)
+	if err != nil || book == nil {
+		return nil, err
+	}
+
+	details := &BookDetails{Book: book, AuthorBooks: []Book{}, Genres: []Genre{}}
+	visible, visibleArgs := visibleBooksCondition(hidden)
+
+	if book.Series != nil {
+		if err := r.db.db.QueryRow(
+			"SELECT COUNT(*) FROM books b WHERE b.series_id = ? AND "+visible,
+			append([]interface{}{book.Series.ID}, visibleArgs...)...,
+		).Scan(&details.SeriesTotal); err != nil {
+			return nil, fmt.Errorf("failed to count series books: %w", err)
+		}
+
+		// Unnumbered books have no place in the order of the series
+		if book.SeriesNum > 0 {
+			prev, err := r.relatedBooks("b.series_id = ? AND b.series_num > 0 AND b.series_num < ?",
+				[]interface{}{book.Series.ID, book.SeriesNum}, "b.series_num DESC, b.title DESC, b.id DESC", 1, hidden)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load previous book: %w", err)
+			}
+			next, err := r.relatedBooks("b.series_id = ? AND b.series_num > ?",
+				[]interface{}{book.Series.ID, book.SeriesNum}, "b.series_num ASC, b.title ASC, b.id ASC", 1, hidden)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load next book: %w", err)
+			}
+			if len(prev) > 0 {
+				details.Previous = &prev[0]
+			}
+			if len(next) > 0 {
+				details.Next = &next[0]
+			}
+		}
+	}
+
+	if len(book.Authors) > 0 && authorBooks > 0 {
+		args := []interface{}{book.ID}
+		for _, author := range book.Authors {
+			args = append(args, author.ID)
+		}
+		books, err := r.relatedBooks(
+			"b.id <> ? AND b.id IN (SELECT rba.book_id FROM book_authors rba WHERE rba.author_id IN ("+
+				createPlaceholders(len(book.Authors))+"))",
+			args, "b.rating DESC, b.date_added DESC, b.id ASC", authorBooks, hidden)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load books of the authors: %w", err)
+		}
+		details.AuthorBooks = append(details.AuthorBooks, books...)
+	}
+
+	for _, genre := range book.Genres {
+		if err := r.db.db.QueryRow(
+			"SELECT COUNT(*) FROM book_genres rbg JOIN books b ON b.id = rbg.book_id WHERE rbg.genre_id = ? AND "+visible,
+			append([]interface{}{genre.ID}, visibleArgs...)...,
+		).Scan(&genre.BookCount); err != nil {
+			return nil, fmt.Errorf("failed to count genre books: %w", err)
+		}
+		details.Genres = append(details.Genres, genre)
+	}
+
+	return details, nil
+}
+
+// visibleBooksCondition returns the WHERE condition on b that keeps the
+// books a viewer may see, with its arguments
+func visibleBooksCondition(hidden *Restrictions) (string, []interface{}) {
+	conditions, args := restrictionConditions(hidden)
+	return strings.Join(append([]string{visibleCondition}, conditions...), " AND "), args
+}
+
+// relatedBooks returns up to limit visible books matching where, a
+// condition on b, in the given order with their authors and genres
+func (r *Repository) relatedBooks(where string, args []interface{}, order string, limit int, hidden *Restrictions) ([]Book, error) {
+	visible, visibleArgs := visibleBooksCondition(hidden)
+	query := fmt.Sprintf(`SELECT %s FROM books b
+		LEFT JOIN series s ON b.series_id = s.id
+		LEFT JOIN genres g ON b.genre_id = g.id
+		WHERE %s AND %s
+		ORDER BY %s LIMIT ?`, bookSelectColumns, where, visible, order)
+	args = append(append(append([]interface{}{}, args...), visibleArgs...), limit)
+
+	rows, err := r.db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		book, err := r.scanBook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan book: %w", err)
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range books {
+		if books[i].Authors, err = r.getBookAuthors(books[i].ID); err != nil {
+			return nil, fmt.Errorf("failed to load authors for book %s: %w", books[i].ID, err)
+		}
+	}
+	if err := r.loadBookGenres(books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
@@ -0,0 +1,75 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestGetBookDetails(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	book := func(id, title string, num, rating int, genre string) inpx.Book {
+		return inpx.Book{ID: id, Title: title, Authors: []string{"Толкин"}, Series: "Властелин колец", SeriesNum: num,
+			Genre: genre, Rating: rating, Format: "fb2", Date: time.Now()}
+	}
+	books := []inpx.Book{
+		book("lotr-1", "Братство Кольца", 1, 5, "sf_fantasy:"),
+		book("lotr-2", "Две крепости", 2, 4, "sf_fantasy:"),
+		book("lotr-3", "Возвращение короля", 3, 3, "sf_fantasy:adventure:"),
+		book("lotr-x", "Приложения", 0, 0, "sf_fantasy:"),
+		{ID: "hobbit", Title: "Хоббит", Authors: []string{"Толкин"}, Genre: "child_tale", Rating: 2, Format: "fb2", Date: time.Now()},
+		{ID: "other", Title: "Чужая книга", Authors: []string{"Другой"}, Genre: "sf_fantasy", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	details, err := repo.GetBookDetails("lotr-2", 10, nil)
+	if err != nil || details == nil {
+		t.Fatalf("GetBookDetails = %+v, %v", details, err)
+	}
+	if details.Book.ID != "lotr-2" || details.SeriesTotal != 4 {
+		t.Errorf("unexpected book or series total: %s, %d", details.Book.ID, details.SeriesTotal)
+	}
+	if details.Previous == nil || details.Previous.ID != "lotr-1" || details.Next == nil || details.Next.ID != "lotr-3" {
+		t.Errorf("unexpected neighbours: %+v, %+v", details.Previous, details.Next)
+	}
+	if len(details.Next.Authors) != 1 || len(details.Next.Genres) != 2 {
+		t.Errorf("expected neighbours with authors and genres, got %+v", details.Next)
+	}
+	if len(details.AuthorBooks) != 4 || details.AuthorBooks[0].ID != "lotr-1" || details.AuthorBooks[1].ID != "lotr-3" {
+		t.Errorf("unexpected books of the author: %+v", details.AuthorBooks)
+	}
+	if len(details.Genres) != 1 || details.Genres[0].Name != "sf_fantasy" || details.Genres[0].BookCount != 5 {
+		t.Errorf("unexpected genres: %+v", details.Genres)
+	}
+
+	details, err = repo.GetBookDetails("lotr-1", 1, &storage.Restrictions{Genres: []string{"adventure"}})
+	if err != nil || details == nil {
+		t.Fatalf("GetBookDetails = %+v, %v", details, err)
+	}
+	if details.Previous != nil || details.Next == nil || details.Next.ID != "lotr-2" || details.SeriesTotal != 3 {
+		t.Errorf("unexpected series with a restricted book: %+v, %+v, %d", details.Previous, details.Next, details.SeriesTotal)
+	}
+	if len(details.AuthorBooks) != 1 || details.AuthorBooks[0].ID != "lotr-2" {
+		t.Errorf("unexpected limited books of the author: %+v", details.AuthorBooks)
+	}
+
+	details, err = repo.GetBookDetails("lotr-3", 10, nil)
+	if err != nil || details.Next != nil || details.Previous == nil || details.Previous.ID != "lotr-2" {
+		t.Errorf("unexpected neighbours of the last book: %+v, %v", details, err)
+	}
+
+	if details, err := repo.GetBookDetails("missing", 10, nil); err != nil || details != nil {
+		t.Errorf("GetBookDetails(missing) = %+v, %v; want nil", details, err)
+	}
+}
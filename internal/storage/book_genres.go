@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// bookGenresExpr is the INPX genre field of book b: its genre codes in
+// order, each followed by a colon. It is NULL if the book has none.
+const bookGenresExpr = `(SELECT GROUP_CONCAT(name || ':', '') FROM (
+	SELECT xg.name AS name FROM book_genres bg JOIN genres xg ON xg.id = bg.genre_id
+	WHERE bg.book_id = b.id ORDER BY bg.rowid))`
+
+// genreCodes splits an INPX genre field such as "sf_fantasy:sf_epic:" into
+// its codes, in order and without repeats. Some generators separate codes
+// with commas instead.
+func genreCodes(field string) []string {
+	var codes []string
+	seen := make(map[string]bool)
+	parts := strings.FieldsFunc(field, func(r rune) bool { return r == ':' || r == ',' || r == ';' })
+	for _, part := range parts {
+		code := strings.TrimSpace(part)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// bookGenresTx resolves the genres of an INPX genre field, creating missing
+// ones. The first is the main genre, stored in books.genre_id.
+func (r *Repository) bookGenresTx(tx *sql.Tx, field string, cache map[string]int) ([]int, error) {
+	codes := genreCodes(field)
+	ids := make([]int, 0, len(codes))
+	for _, code := range codes {
+		id, err := r.getOrCreateGenreTx(tx, code, cache)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// mainGenreID is the books.genre_id of a book with the given genres
+func mainGenreID(genreIDs []int) sql.NullInt64 {
+	if len(genreIDs) == 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(genreIDs[0]), Valid: true}
+}
+
+// setBookGenresTx replaces the genres of a book
+func setBookGenresTx(tx *sql.Tx, bookID string, genreIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM book_genres WHERE book_id = ?", bookID); err != nil {
+		return err
+	}
+	for _, id := range genreIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO book_genres (book_id, genre_id) VALUES (?, ?)", bookID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genreFilterCondition matches books with any genre of the given names
+func genreFilterCondition(names int) string {
+	return "b.id IN (SELECT fbg.book_id FROM book_genres fbg JOIN genres fg ON fg.id = fbg.genre_id WHERE fg.name IN (" +
+		createPlaceholders(names) + "))"
+}
+
+// getBookGenres returns the genres of a book
+func (r *Repository) getBookGenres(bookID string) ([]Genre, error) {
+	byBook, err := r.queryBookGenres([]interface{}{bookID})
+	if err != nil {
+		return nil, err
+	}
+	return byBook[bookID], nil
+}
+
+// loadBookGenres fills in the genres of books with one query
+func (r *Repository) loadBookGenres(books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(books))
+	for _, book := range books {
+		args = append(args, book.ID)
+	}
+	byBook, err := r.queryBookGenres(args)
+	if err != nil {
+		return err
+	}
+	for i := range books {
+		books[i].Genres = byBook[books[i].ID]
+	}
+	return nil
+}
+
+// queryBookGenres returns the genres of the given books by book ID
+func (r *Repository) queryBookGenres(ids []interface{}) (map[string][]Genre, error) {
+	rows, err := r.db.db.Query(
+		`SELECT bg.book_id, g.id, g.name
+		 FROM book_genres bg JOIN genres g ON g.id = bg.genre_id
+		 WHERE bg.book_id IN (`+createPlaceholders(len(ids))+`)
+		 ORDER BY bg.rowid`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query book genres: %w", err)
+	}
+	defer rows.Close()
+
+	byBook := make(map[string][]Genre)
+	for rows.Next() {
+		var bookID string
+		var genre Genre
+		if err := rows.Scan(&bookID, &genre.ID, &genre.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan book genre: %w", err)
+		}
+		byBook[bookID] = append(byBook[bookID], genre)
+	}
+	return byBook, rows.Err()
+}
@@ -0,0 +1,133 @@
+package storage_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestBookGenres(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "bg-1", Title: "Хоббит", Authors: []string{"Автор"}, Genre: "sf_fantasy:adv_fantasy:", Format: "fb2", Date: time.Now()},
+		{ID: "bg-2", Title: "Остров", Authors: []string{"Автор"}, Genre: "adventure", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	book, err := repo.GetBookByID("bg-1")
+	if err != nil || book == nil {
+		t.Fatalf("GetBookByID = %v, %v", book, err)
+	}
+	if book.Genre == nil || book.Genre.Name != "sf_fantasy" ||
+		len(book.Genres) != 2 || book.Genres[0].Name != "sf_fantasy" || book.Genres[1].Name != "adv_fantasy" {
+		t.Errorf("unexpected genres: %+v, %+v", book.Genre, book.Genres)
+	}
+
+	list, err := repo.SearchBooks(storage.BookFilter{Genres: []string{"adv_fantasy"}})
+	if err != nil || list.Total != 1 || list.Books[0].ID != "bg-1" || len(list.Books[0].Genres) != 2 {
+		t.Errorf("search by further genre = %+v, %v", list, err)
+	}
+
+	genres, total, err := repo.ListGenres(10, 0)
+	if err != nil || total != 3 {
+		t.Fatalf("ListGenres = %+v, %d, %v", genres, total, err)
+	}
+	for _, genre := range genres {
+		if genre.BookCount != 1 {
+			t.Errorf("genre %s counts %d books, want 1", genre.Name, genre.BookCount)
+		}
+	}
+
+	facets, err := repo.CountBookFacets(storage.BookFilter{})
+	if err != nil || len(facets.Genres) != 3 {
+		t.Errorf("genre facets = %+v, %v", facets, err)
+	}
+
+	hidden := &storage.Restrictions{Genres: []string{"adv_fantasy"}}
+	list, err = repo.SearchBooks(storage.BookFilter{Hidden: hidden})
+	if err != nil || list.Total != 1 || list.Books[0].ID != "bg-2" {
+		t.Errorf("search hiding a further genre = %+v, %v", list, err)
+	}
+	if !hidden.Hides(book) {
+		t.Error("expected a book to be hidden by its further genre")
+	}
+
+	genre := "adventure"
+	if _, err := repo.UpdateBook("bg-1", storage.BookUpdate{Genre: &genre}); err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	if book, _ := repo.GetBookByID("bg-1"); book == nil || len(book.Genres) != 1 || book.Genres[0].Name != "adventure" {
+		t.Errorf("genres after update = %+v", book)
+	}
+}
+
+func TestBookGenresMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	repo := storage.NewRepository(db)
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "m-1", Title: "Старая книга", Authors: []string{"Автор"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+		{ID: "m-2", Title: "Другая книга", Authors: []string{"Автор"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	db.Close()
+
+	// Bring back the layout of older releases, which stored a whole genre
+	// field as one genre
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		"DROP TABLE book_genres",
+		"INSERT INTO genres (name) VALUES ('sf_history:prose:')",
+		"UPDATE books SET genre_id = (SELECT id FROM genres WHERE name = 'sf_history:prose:') WHERE id = 'm-1'",
+		"INSERT INTO access_rules (kind, name) VALUES ('genre', 'sf_history:prose:')",
+	} {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	raw.Close()
+
+	db, err = storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	repo = storage.NewRepository(db)
+
+	book, err := repo.GetBookByID("m-1")
+	if err != nil || book == nil || book.Genre == nil || book.Genre.Name != "sf_history" ||
+		len(book.Genres) != 2 || book.Genres[1].Name != "prose" {
+		t.Fatalf("GetBookByID after migration = %+v, %v; want the split genres", book, err)
+	}
+	if book, _ := repo.GetBookByID("m-2"); book == nil || len(book.Genres) != 1 || book.Genres[0].Name != "prose" {
+		t.Errorf("unexpected genres of a single-genre book: %+v", book)
+	}
+
+	genres, total, err := repo.ListGenres(10, 0)
+	if err != nil || total != 2 {
+		t.Errorf("ListGenres after migration = %+v, %d, %v; want sf_history and prose", genres, total, err)
+	}
+	rules, err := repo.ListAccessRules()
+	if err != nil || len(rules) != 2 {
+		t.Errorf("access rules after migration = %+v, %v; want one per code", rules, err)
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// otherSeriesExpr lists the further series of book b as name, char(30),
+// number, separated by char(31); see parseOtherSeries. It is NULL if the
+// book has none.
+const otherSeriesExpr = `(SELECT GROUP_CONCAT(name || char(30) || num, char(31)) FROM (
+	SELECT xs.name AS name, bs.series_num AS num FROM book_series bs JOIN series xs ON xs.id = bs.series_id
+	WHERE bs.book_id = b.id ORDER BY bs.rowid))`
+
+// parseOtherSeries reads a value of otherSeriesExpr
+func parseOtherSeries(value string) []inpx.SeriesRef {
+	var refs []inpx.SeriesRef
+	for _, entry := range strings.Split(value, "\x1f") {
+		name, num, _ := strings.Cut(entry, "\x1e")
+		if name == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(num)
+		refs = append(refs, inpx.SeriesRef{Name: name, Num: n})
+	}
+	return refs
+}
+
+// seriesLink is a row of book_series
+type seriesLink struct {
+	seriesID int
+	num      int
+}
+
+// otherSeriesLinksTx resolves the further series of a book, creating
+// missing ones. The main series and repeats are left out.
+func (r *Repository) otherSeriesLinksTx(tx *sql.Tx, book inpx.Book, cache map[string]int) ([]seriesLink, error) {
+	var links []seriesLink
+	seen := map[string]bool{book.Series: true}
+	for _, ref := range book.OtherSeries {
+		if ref.Name == "" || seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+
+		id, err := r.getOrCreateSeriesTx(tx, ref.Name, cache)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, seriesLink{seriesID: id, num: ref.Num})
+	}
+	return links, nil
+}
+
+// setOtherSeriesTx replaces the further series of a book
+func setOtherSeriesTx(tx *sql.Tx, bookID string, links []seriesLink) error {
+	if _, err := tx.Exec("DELETE FROM book_series WHERE book_id = ?", bookID); err != nil {
+		return err
+	}
+	for _, link := range links {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO book_series (book_id, series_id, series_num) VALUES (?, ?, ?)",
+			bookID, link.seriesID, link.num,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getBookOtherSeries returns the further series of a book
+func (r *Repository) getBookOtherSeries(bookID string) ([]BookSeries, error) {
+	byBook, err := r.queryOtherSeries([]interface{}{bookID})
+	if err != nil {
+		return nil, err
+	}
+	return byBook[bookID], nil
+}
+
+// loadOtherSeries fills in the further series of books with one query
+func (r *Repository) loadOtherSeries(books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(books))
+	for _, book := range books {
+		args = append(args, book.ID)
+	}
+	byBook, err := r.queryOtherSeries(args)
+	if err != nil {
+		return err
+	}
+	for i := range books {
+		books[i].OtherSeries = byBook[books[i].ID]
+	}
+	return nil
+}
+
+// queryOtherSeries returns the further series of the given books by book ID
+func (r *Repository) queryOtherSeries(ids []interface{}) (map[string][]BookSeries, error) {
+	rows, err := r.db.db.Query(
+		`SELECT bs.book_id, s.id, s.name, bs.series_num
+		 FROM book_series bs JOIN series s ON s.id = bs.series_id
+		 WHERE bs.book_id IN (`+createPlaceholders(len(ids))+`)
+		 ORDER BY bs.rowid`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query other series: %w", err)
+	}
+	defer rows.Close()
+
+	byBook := make(map[string][]BookSeries)
+	for rows.Next() {
+		var bookID string
+		var series BookSeries
+		if err := rows.Scan(&bookID, &series.ID, &series.Name, &series.Num); err != nil {
+			return nil, fmt.Errorf("failed to scan other series: %w", err)
+		}
+		byBook[bookID] = append(byBook[bookID], series)
+	}
+	return byBook, rows.Err()
+}
@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestOtherSeries(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "os-1", Title: "Полдень", Authors: []string{"Автор"}, Series: "Мир Полудня", SeriesNum: 1, Format: "fb2", Date: time.Now(),
+			OtherSeries: []inpx.SeriesRef{{Name: "Избранное", Num: 2}, {Name: "Мир Полудня", Num: 1}}},
+		{ID: "os-2", Title: "Улитка", Authors: []string{"Автор"}, Series: "Избранное", SeriesNum: 1, Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	book, err := repo.GetBookByID("os-1")
+	if err != nil || book == nil {
+		t.Fatalf("GetBookByID = %+v, %v", book, err)
+	}
+	// The main series is not repeated among the further ones
+	if len(book.OtherSeries) != 1 || book.OtherSeries[0].Name != "Избранное" || book.OtherSeries[0].Num != 2 {
+		t.Errorf("unexpected other series %+v", book.OtherSeries)
+	}
+
+	list, err := repo.SearchBooks(storage.BookFilter{Series: []string{"Избранное"}, SortBy: "title"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if list.Total != 2 || len(list.Books[0].OtherSeries) != 1 {
+		t.Errorf("expected both books of the series with other series loaded, got %+v", list.Books)
+	}
+
+	series, _, err := repo.ListSeries(10, 0)
+	if err != nil {
+		t.Fatalf("ListSeries failed: %v", err)
+	}
+	counts := map[string]int{}
+	for _, s := range series {
+		counts[s.Name] = s.BookCount
+	}
+	if counts["Избранное"] != 2 || counts["Мир Полудня"] != 1 {
+		t.Errorf("unexpected series counts %v", counts)
+	}
+
+	// Mirrors replace the further series along with the book
+	books[0].OtherSeries = []inpx.SeriesRef{{Name: "Сборники"}}
+	if err := repo.UpsertBooks(books[:1]); err != nil {
+		t.Fatalf("UpsertBooks failed: %v", err)
+	}
+	book, _ = repo.GetBookByID("os-1")
+	if len(book.OtherSeries) != 1 || book.OtherSeries[0].Name != "Сборники" {
+		t.Errorf("unexpected other series after upsert %+v", book.OtherSeries)
+	}
+
+	var exported []inpx.Book
+	if _, err := repo.EachExportBook(func(b inpx.Book) error {
+		exported = append(exported, b)
+		return nil
+	}); err != nil {
+		t.Fatalf("EachExportBook failed: %v", err)
+	}
+	for _, b := range exported {
+		if b.ID == "os-1" && (len(b.OtherSeries) != 1 || b.OtherSeries[0].Name != "Сборники") {
+			t.Errorf("export lost other series: %+v", b.OtherSeries)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// insertBatchSize is the number of rows written by a single multi-row INSERT.
+// The widest statement (books, 14 columns) stays well below SQLite's
+// default limit of 32766 bound parameters.
+const insertBatchSize = 500
+
+// multiRowInsert accumulates rows for one table and writes them with
+// multi-row INSERT ... VALUES statements of up to insertBatchSize rows.
+type multiRowInsert struct {
+	tx      *sql.Tx
+	prefix  string
+	row     string
+	columns int
+	args    []interface{}
+	full    *sql.Stmt
+	// suffix follows the rows, e.g. an ON CONFLICT clause
+	suffix string
+}
+
+func newMultiRowInsert(tx *sql.Tx, prefix string, columns int) *multiRowInsert {
+	return &multiRowInsert{
+		tx:      tx,
+		prefix:  prefix,
+		row:     "(" + createPlaceholders(columns) + ")",
+		columns: columns,
+		args:    make([]interface{}, 0, insertBatchSize*columns),
+	}
+}
+
+func (m *multiRowInsert) add(values ...interface{}) {
+	m.args = append(m.args, values...)
+}
+
+func (m *multiRowInsert) query(rows int) string {
+	var sb strings.Builder
+	sb.Grow(len(m.prefix) + rows*(len(m.row)+2))
+	sb.WriteString(m.prefix)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(m.row)
+	}
+	sb.WriteString(m.suffix)
+	return sb.String()
+}
+
+// flush writes all pending rows. Full batches reuse one prepared statement,
+// the remainder is executed as a one-off statement.
+func (m *multiRowInsert) flush() error {
+	chunk := insertBatchSize * m.columns
+	args := m.args
+	for len(args) >= chunk {
+		if m.full == nil {
+			stmt, err := m.tx.Prepare(m.query(insertBatchSize))
+			if err != nil {
+				return err
+			}
+			m.full = stmt
+		}
+		if _, err := m.full.Exec(args[:chunk]...); err != nil {
+			return err
+		}
+		args = args[chunk:]
+	}
+
+	if len(args) > 0 {
+		if _, err := m.tx.Exec(m.query(len(args)/m.columns), args...); err != nil {
+			return err
+		}
+	}
+
+	m.args = m.args[:0]
+	return nil
+}
+
+func (m *multiRowInsert) close() {
+	if m.full != nil {
+		m.full.Close()
+	}
+}
+
+// bookInsertBatch collects rows for books, book_authors, book_series,
+// book_genres, book_annotations, books_fts and book_dates and writes them together so
+// that foreign keys are always satisfied.
+type bookInsertBatch struct {
+	tx *sql.Tx
+	// skipFTSDelete is set when books_fts, book_series, book_genres
+	// and book_annotations have no rows of the books yet, as after
+	// ClearAllBooks
+	skipFTSDelete bool
+	ids           map[string]struct{}
+	books         *multiRowInsert
+	bookAuthors   *multiRowInsert
+	bookSeries    *multiRowInsert
+	annotations   *multiRowInsert
+	ftsIDs        *multiRowInsert
+	fts           *multiRowInsert
+	dates         *multiRowInsert
+	bookGenres    *multiRowInsert
+	// now is the update time of books whose data changed
+	now time.Time
+	// skipFTS leaves books_fts to BuildDeferredFTS
+	skipFTS bool
+}
+
+func newBookInsertBatch(tx *sql.Tx, skipFTSDelete bool) *bookInsertBatch {
+	dates := newMultiRowInsert(tx, bookDatesUpsert, 3)
+	dates.suffix = bookDatesConflict
+	fts := newMultiRowInsert(tx, "INSERT INTO books_fts (rowid, title, annotation, authors, series) VALUES ", 5)
+	fts.row = "((SELECT id FROM books_fts_ids WHERE book_id = ?), ?, ?, ?, ?)"
+	return &bookInsertBatch{
+		tx:            tx,
+		skipFTSDelete: skipFTSDelete,
+		now:           time.Now(),
+		ids:           make(map[string]struct{}, insertBatchSize),
+		books: newMultiRowInsert(tx, `INSERT OR REPLACE INTO books
+			(id, title, series_id, series_num, genre_id, year, language,
+			 file_size, archive_path, file_num, format, date_added, rating, updated_at)
+			VALUES `, 14),
+		bookAuthors: newMultiRowInsert(tx, "INSERT OR IGNORE INTO book_authors (book_id, author_id) VALUES ", 2),
+		bookSeries:  newMultiRowInsert(tx, "INSERT OR IGNORE INTO book_series (book_id, series_id, series_num) VALUES ", 3),
+		annotations: newMultiRowInsert(tx, "INSERT OR REPLACE INTO book_annotations (book_id, annotation) VALUES ", 2),
+		ftsIDs:      newMultiRowInsert(tx, "INSERT OR IGNORE INTO books_fts_ids (book_id) VALUES ", 1),
+		fts:         fts,
+		dates:       dates,
+		bookGenres:  newMultiRowInsert(tx, "INSERT OR IGNORE INTO book_genres (book_id, genre_id) VALUES ", 2),
+	}
+}
+
+// has reports whether a book with the given ID is waiting in the batch
+func (b *bookInsertBatch) has(id string) bool {
+	_, ok := b.ids[id]
+	return ok
+}
+
+// size returns the number of pending books
+func (b *bookInsertBatch) size() int {
+	return len(b.ids)
+}
+
+func (b *bookInsertBatch) add(book inpx.Book, seriesID sql.NullInt64, genreIDs []int, authorIDs []int, otherSeries []seriesLink) {
+	b.ids[book.ID] = struct{}{}
+
+	b.books.add(
+		book.ID,
+		book.Title,
+		seriesID,
+		book.SeriesNum,
+		mainGenreID(genreIDs),
+		book.Year,
+		book.Language,
+		book.FileSize,
+		book.ArchivePath,
+		book.FileNum,
+		book.Format,
+		book.Date,
+		book.Rating,
+		b.now,
+	)
+	b.dates.add(book.ID, bookFingerprint(book), b.now)
+	if book.Annotation != "" {
+		b.annotations.add(book.ID, book.Annotation)
+	}
+
+	for _, authorID := range authorIDs {
+		b.bookAuthors.add(book.ID, authorID)
+	}
+	for _, link := range otherSeries {
+		b.bookSeries.add(book.ID, link.seriesID, link.num)
+	}
+	for _, genreID := range genreIDs {
+		b.bookGenres.add(book.ID, genreID)
+	}
+
+	if b.skipFTS {
+		return
+	}
+	authorsText := strings.Join(book.Authors, " ")
+	b.ftsIDs.add(book.ID)
+	b.fts.add(book.ID, foldSearchText(book.Title), foldSearchText(book.Annotation),
+		foldSearchText(authorsText), foldSearchText(book.Series))
+}
+
+// flush writes all pending rows: books first, then their author, series and
+// genre links, annotations and full-text entries, and finally the update times.
+func (b *bookInsertBatch) flush() error {
+	if len(b.ids) == 0 {
+		return nil
+	}
+
+	if err := b.books.flush(); err != nil {
+		return fmt.Errorf("books: %w", err)
+	}
+	if err := b.bookAuthors.flush(); err != nil {
+		return fmt.Errorf("book_authors: %w", err)
+	}
+
+	ids := make([]interface{}, 0, len(b.ids))
+	for id := range b.ids {
+		ids = append(ids, id)
+	}
+	if !b.skipFTSDelete {
+		in := " WHERE book_id IN (" + createPlaceholders(len(ids)) + ")"
+		if err := deleteBooksFTSTx(b.tx, ids); err != nil {
+			return fmt.Errorf("books_fts delete: %w", err)
+		}
+		if _, err := b.tx.Exec("DELETE FROM book_annotations"+in, ids...); err != nil {
+			return fmt.Errorf("book_annotations delete: %w", err)
+		}
+		if _, err := b.tx.Exec("DELETE FROM book_series"+in, ids...); err != nil {
+			return fmt.Errorf("book_series delete: %w", err)
+		}
+		if _, err := b.tx.Exec("DELETE FROM book_genres"+in, ids...); err != nil {
+			return fmt.Errorf("book_genres delete: %w", err)
+		}
+	}
+	if err := b.bookSeries.flush(); err != nil {
+		return fmt.Errorf("book_series: %w", err)
+	}
+	if err := b.bookGenres.flush(); err != nil {
+		return fmt.Errorf("book_genres: %w", err)
+	}
+	if err := b.annotations.flush(); err != nil {
+		return fmt.Errorf("book_annotations: %w", err)
+	}
+	if err := b.ftsIDs.flush(); err != nil {
+		return fmt.Errorf("books_fts_ids: %w", err)
+	}
+	if err := b.fts.flush(); err != nil {
+		return fmt.Errorf("books_fts: %w", err)
+	}
+	if err := b.dates.flush(); err != nil {
+		return fmt.Errorf("book_dates: %w", err)
+	}
+	if err := applyBookDatesTx(b.tx, ids); err != nil {
+		return fmt.Errorf("book dates: %w", err)
+	}
+
+	clear(b.ids)
+	return nil
+}
+
+func (b *bookInsertBatch) close() {
+	b.books.close()
+	b.bookAuthors.close()
+	b.bookSeries.close()
+	b.annotations.close()
+	b.fts.close()
+	b.dates.close()
+	b.bookGenres.close()
+}
+
+// dropBookIndexesTx drops the secondary indexes of the books table and
+// returns their definitions so they can be rebuilt after a bulk load.
+func dropBookIndexesTx(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`SELECT name, sql FROM sqlite_master
+		WHERE type = 'index' AND tbl_name = 'books' AND sql IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list book indexes: %w", err)
+	}
+
+	var names, defs []string
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan book index: %w", err)
+		}
+		names = append(names, name)
+		defs = append(defs, def)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating book indexes: %w", err)
+	}
+
+	for _, name := range names {
+		if _, err := tx.Exec(`DROP INDEX IF EXISTS "` + name + `"`); err != nil {
+			return nil, fmt.Errorf("failed to drop index %s: %w", name, err)
+		}
+	}
+	return defs, nil
+}
+
+// createIndexesTx recreates indexes from their stored definitions
+func createIndexesTx(tx *sql.Tx, defs []string) error {
+	for _, def := range defs {
+		if _, err := tx.Exec(def); err != nil {
+			return fmt.Errorf("failed to rebuild index: %w", err)
+		}
+	}
+	return nil
+}
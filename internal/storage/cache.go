@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// queryCache is an LRU cache of catalog query results that expire after a
+// time to live. Results are shared between callers, which must not modify
+// them.
+type queryCache struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	order      *list.List // most recently used first
+	entries    map[string]*list.Element
+	generation uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// listPage is a cached page of a paginated list
+type listPage[T any] struct {
+	items []T
+	total int
+}
+
+func newQueryCache(size int, ttl time.Duration) *queryCache {
+	return &queryCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached value of key and the current generation, which
+// must be passed to add with the freshly loaded value on a miss
+func (c *queryCache) get(key string) (interface{}, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, c.generation
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, c.generation
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, c.generation
+}
+
+// add caches value unless the cache was purged since it was loaded
+func (c *queryCache) add(key string, value interface{}, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *queryCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.generation++
+}
+
+// SetQueryCache keeps the results of up to size catalog queries (searches,
+// facet counts, author, series, genre and year lists) for ttl, so that many
+// clients polling the same feeds do not hit SQLite every time. A size or
+// ttl of zero disables the cache.
+func (r *Repository) SetQueryCache(size int, ttl time.Duration) {
+	if size <= 0 || ttl <= 0 {
+		r.queryCache.Store(nil)
+		return
+	}
+	r.queryCache.Store(newQueryCache(size, ttl))
+}
+
+// InvalidateQueryCache drops all cached query results. It is called after
+// reindexing and after changes to the catalog made through the admin API.
+func (r *Repository) InvalidateQueryCache() {
+	if c := r.queryCache.Load(); c != nil {
+		c.purge()
+	}
+}
+
+// cachedQuery returns the cached result of the query identified by kind and
+// args, or runs load and caches its result. Errors are not cached.
+func cachedQuery[T any](r *Repository, load func() (T, error), kind string, args ...interface{}) (T, error) {
+	c := r.queryCache.Load()
+	if c == nil {
+		return load()
+	}
+	// JSON keeps the elements of string slices apart, unlike fmt
+	data, err := json.Marshal(args)
+	if err != nil {
+		return load()
+	}
+	key := kind + string(data)
+
+	value, ok, generation := c.get(key)
+	if ok {
+		return value.(T), nil
+	}
+	result, err := load()
+	if err == nil {
+		c.add(key, result, generation)
+	}
+	return result, err
+}
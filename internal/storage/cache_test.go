@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestQueryCache_LRU(t *testing.T) {
+	c := newQueryCache(2, time.Minute)
+	_, _, gen := c.get("a")
+	c.add("a", 1, gen)
+	c.add("b", 2, gen)
+	c.get("a") // b is now the least recently used
+	c.add("c", 3, gen)
+
+	if _, ok, _ := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	// A result loaded before a purge is not cached
+	_, _, gen = c.get("d")
+	c.purge()
+	c.add("d", 4, gen)
+	if _, ok, _ := c.get("d"); ok {
+		t.Error("expected a stale result to be dropped")
+	}
+
+	c = newQueryCache(2, time.Millisecond)
+	c.add("a", 1, 0)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := c.get("a"); ok {
+		t.Error("expected a to expire")
+	}
+}
+
+func TestRepository_QueryCache(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := NewRepository(db)
+	repo.SetQueryCache(10, time.Minute)
+
+	insert := func(id string) {
+		t.Helper()
+		book := inpx.Book{ID: id, Title: "Book " + id, Authors: []string{"Author " + id}, Genre: "prose",
+			Language: "ru", Format: "fb2", ArchivePath: "books", FileNum: id, Date: time.Now()}
+		if err := repo.InsertBooks([]inpx.Book{book}); err != nil {
+			t.Fatalf("InsertBooks: %v", err)
+		}
+	}
+	total := func() int {
+		t.Helper()
+		result, err := repo.SearchBooks(BookFilter{Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks: %v", err)
+		}
+		return result.Total
+	}
+
+	insert("1")
+	if n := total(); n != 1 {
+		t.Fatalf("expected 1 book, got %d", n)
+	}
+	insert("2")
+	if n := total(); n != 1 {
+		t.Errorf("expected the cached result, got %d books", n)
+	}
+	// Restrictions are part of the key
+	hidden := &Restrictions{Genres: []string{"prose"}}
+	if result, err := repo.SearchBooks(BookFilter{Limit: 10, Hidden: hidden}); err != nil || result.Total != 0 {
+		t.Errorf("expected no visible books, got %v, %v", result, err)
+	}
+
+	repo.InvalidateQueryCache()
+	if n := total(); n != 2 {
+		t.Errorf("expected 2 books after invalidation, got %d", n)
+	}
+	authors, count, err := repo.ListAuthors(10, 0)
+	if err != nil || count != 2 || len(authors) != 2 {
+		t.Errorf("ListAuthors = %v, %d, %v", authors, count, err)
+	}
+}
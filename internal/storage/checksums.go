@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetBookChecksum returns the stored checksum of a book file, or nil if it
+// has not been computed yet.
+func (r *Repository) GetBookChecksum(bookID string) (*BookChecksum, error) {
+	var sum BookChecksum
+	err := r.db.db.QueryRow(
+		`SELECT book_id, sha256, size, computed_at FROM book_checksums WHERE book_id = ?`, bookID,
+	).Scan(&sum.BookID, &sum.SHA256, &sum.Size, &sum.ComputedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum for %s: %w", bookID, err)
+	}
+	return &sum, nil
+}
+
+// SaveBookChecksum stores the SHA-256 (hex) and size of a book file,
+// replacing any previous value.
+func (r *Repository) SaveBookChecksum(bookID, sha256 string, size int64) error {
+	if _, err := r.db.db.Exec(
+		`INSERT INTO book_checksums (book_id, sha256, size, computed_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(book_id) DO UPDATE SET sha256 = excluded.sha256, size = excluded.size, computed_at = excluded.computed_at`,
+		bookID, sha256, size,
+	); err != nil {
+		return fmt.Errorf("failed to save checksum for %s: %w", bookID, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CoverStats summarizes the progress of cover extraction
+type CoverStats struct {
+	Books     int `json:"books"`
+	Checked   int `json:"checked"`
+	WithCover int `json:"with_cover"`
+}
+
+// ListBooksPendingCover returns books that have not been checked for an
+// embedded cover yet, ordered so that books from the same archive come
+// together. Results start after the (afterArchive, afterID) cursor, which
+// lets callers skip books they failed to process. Only ID, archive path,
+// file number and format are filled.
+func (r *Repository) ListBooksPendingCover(afterArchive, afterID string, limit int) ([]Book, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT b.id, b.archive_path, b.file_num, b.format
+		 FROM books b
+		 LEFT JOIN book_covers bc ON bc.book_id = b.id
+		 WHERE bc.book_id IS NULL AND (b.archive_path, b.id) > (?, ?)
+		 ORDER BY b.archive_path, b.id
+		 LIMIT ?`, afterArchive, afterID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query books pending cover: %w", err)
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := rows.Scan(&book.ID, &book.ArchivePath, &book.FileNum, &book.Format); err != nil {
+			return nil, fmt.Errorf("failed to scan book: %w", err)
+		}
+		books = append(books, book)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating books pending cover: %w", err)
+	}
+	return books, nil
+}
+
+// SetBookCover records the cover thumbnail of a book by its content hash;
+// an empty hash records that the book has no cover
+func (r *Repository) SetBookCover(bookID, hash string) error {
+	var nullableHash sql.NullString
+	if hash != "" {
+		nullableHash = sql.NullString{String: hash, Valid: true}
+	}
+	if _, err := r.db.db.Exec(
+		`INSERT INTO book_covers (book_id, has_cover, hash, checked_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(book_id) DO UPDATE SET has_cover = excluded.has_cover, hash = excluded.hash, checked_at = excluded.checked_at`,
+		bookID, hash != "", nullableHash,
+	); err != nil {
+		return fmt.Errorf("failed to save cover state for %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// ClearBookCover forgets that a book was checked, so that the next cover
+// job extracts its cover again
+func (r *Repository) ClearBookCover(bookID string) error {
+	if _, err := r.db.db.Exec("DELETE FROM book_covers WHERE book_id = ?", bookID); err != nil {
+		return fmt.Errorf("failed to clear cover state for %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// GetCoverHash returns the content hash of a book's cover thumbnail, or ""
+// if the book has no cover or its hash is not known yet
+func (r *Repository) GetCoverHash(bookID string) (string, error) {
+	var hash sql.NullString
+	err := r.db.db.QueryRow(
+		"SELECT hash FROM book_covers WHERE book_id = ? AND has_cover = 1", bookID,
+	).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load cover hash for %s: %w", bookID, err)
+	}
+	return hash.String, nil
+}
+
+// ListCoversWithoutHash returns up to limit books whose thumbnail was
+// saved before cover hashes were recorded
+func (r *Repository) ListCoversWithoutHash(limit int) ([]string, error) {
+	rows, err := r.db.db.Query(
+		"SELECT book_id FROM book_covers WHERE has_cover = 1 AND hash IS NULL ORDER BY book_id LIMIT ?", limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query covers without hash: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan cover: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetCoverStats returns cover extraction progress for the current library
+func (r *Repository) GetCoverStats() (*CoverStats, error) {
+	var stats CoverStats
+	if err := r.db.db.QueryRow(
+		`SELECT COUNT(*),
+		        COUNT(bc.book_id),
+		        COALESCE(SUM(bc.has_cover), 0)
+		 FROM books b
+		 LEFT JOIN book_covers bc ON bc.book_id = b.id`,
+	).Scan(&stats.Books, &stats.Checked, &stats.WithCover); err != nil {
+		return nil, fmt.Errorf("failed to count covers: %w", err)
+	}
+	return &stats, nil
+}
@@ -16,7 +16,11 @@ var schemaFS embed.FS
 
 // Database wraps SQLite database operations
 type Database struct {
-	db *sql.DB
+	db       *sql.DB
+	path     string
+	readOnly bool
+	// lock is held by databases opened with NewExclusiveDatabase
+	lock *databaseLock
 }
 
 // NewDatabase creates a new database connection and initializes schema
@@ -31,7 +35,7 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	database := &Database{db: db}
+	database := &Database{db: db, path: dbPath}
 
 	if err := database.initSchema(); err != nil {
 		db.Close()
@@ -41,9 +45,48 @@ func NewDatabase(dbPath string) (*Database, error) {
 	return database, nil
 }
 
-// Close closes the database connection
+// NewReadOnlyDatabase opens an existing database without writing to it: the
+// schema is neither created nor migrated and every write fails. Several
+// read-only instances can serve the file while one writer maintains it.
+func NewReadOnlyDatabase(dbPath string) (*Database, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_query_only=1&_foreign_keys=1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	database := &Database{db: db, path: dbPath, readOnly: true}
+	if !database.tableExists("books") {
+		db.Close()
+		return nil, fmt.Errorf("database %s has no schema; start a writable instance first", dbPath)
+	}
+
+	return database, nil
+}
+
+// ReadOnly reports whether the database was opened read-only
+func (d *Database) ReadOnly() bool {
+	return d.readOnly
+}
+
+// Close closes the database connection and releases its lock
 func (d *Database) Close() error {
-	return d.db.Close()
+	err := d.db.Close()
+	if d.lock != nil {
+		if lockErr := d.lock.release(); err == nil {
+			err = lockErr
+		}
+		d.lock = nil
+	}
+	return err
 }
 
 // DB returns the underlying sql.DB for advanced operations
@@ -59,6 +102,13 @@ func (d *Database) initSchema() error {
 	if err := d.migrateReadingPositionsPK(); err != nil {
 		return fmt.Errorf("failed to migrate reading_positions PK: %w", err)
 	}
+	if err := d.migrateFormatPreferencesPK(); err != nil {
+		return fmt.Errorf("failed to migrate format_preferences PK: %w", err)
+	}
+
+	// Databases of older releases keep a whole INPX genre field such as
+	// "sf_fantasy:sf_epic:" as one genre
+	splitGenres := d.tableExists("books") && !d.tableExists("book_genres")
 
 	schema, err := schemaFS.ReadFile("schema.sql")
 	if err != nil {
@@ -74,9 +124,110 @@ func (d *Database) initSchema() error {
 		return fmt.Errorf("failed to migrate reading_positions: %w", err)
 	}
 
+	if !d.columnExists("authors", "source_id") {
+		if _, err := d.db.Exec("ALTER TABLE authors ADD COLUMN source_id TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate authors: add column source_id: %w", err)
+		}
+	}
+
+	if !d.columnExists("book_overrides", "hidden_at") {
+		if _, err := d.db.Exec("ALTER TABLE book_overrides ADD COLUMN hidden_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to migrate book_overrides: add column hidden_at: %w", err)
+		}
+	}
+
+	if err := d.migrateBookAnnotations(); err != nil {
+		return fmt.Errorf("failed to migrate book annotations: %w", err)
+	}
+
+	if splitGenres {
+		if err := d.migrateBookGenres(); err != nil {
+			return fmt.Errorf("failed to migrate book genres: %w", err)
+		}
+	}
+
+	// books_fts of older releases kept a copy of the text it indexes
+	if d.columnExists("books_fts", "book_id") {
+		tokenizer, err := d.FTSTokenizer()
+		if err != nil {
+			return fmt.Errorf("failed to migrate books_fts: %w", err)
+		}
+		if err := d.RebuildFTS(tokenizer); err != nil {
+			return fmt.Errorf("failed to migrate books_fts: %w", err)
+		}
+	}
+
+	if !d.columnExists("shelves", "share_token") {
+		if _, err := d.db.Exec("ALTER TABLE shelves ADD COLUMN share_token TEXT"); err != nil {
+			return fmt.Errorf("failed to migrate shelves: add column share_token: %w", err)
+		}
+	}
+
+	if !d.columnExists("search_log", "salt_window") {
+		if _, err := d.db.Exec("ALTER TABLE search_log ADD COLUMN salt_window TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate search_log: add column salt_window: %w", err)
+		}
+	}
+
+	if !d.columnExists("users", "email") {
+		if _, err := d.db.Exec("ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate users: add column email: %w", err)
+		}
+	}
+
+	if !d.columnExists("book_covers", "hash") {
+		if _, err := d.db.Exec("ALTER TABLE book_covers ADD COLUMN hash TEXT"); err != nil {
+			return fmt.Errorf("failed to migrate book_covers: add column hash: %w", err)
+		}
+	}
+
+	for _, column := range []struct{ name, ddl string }{
+		{"email", "ALTER TABLE saved_searches ADD COLUMN email TEXT NOT NULL DEFAULT ''"},
+		{"notified_at", "ALTER TABLE saved_searches ADD COLUMN notified_at DATETIME"},
+	} {
+		if !d.columnExists("saved_searches", column.name) {
+			if _, err := d.db.Exec(column.ddl); err != nil {
+				return fmt.Errorf("failed to migrate saved_searches: add column %s: %w", column.name, err)
+			}
+		}
+	}
+
 	return nil
 }
 
+// migrateFormatPreferencesPK recreates format_preferences of older
+// releases, keyed by user only, with the token_id column of API token
+// preferences.
+func (d *Database) migrateFormatPreferencesPK() error {
+	if !d.tableExists("format_preferences") || d.columnExists("format_preferences", "token_id") {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`CREATE TABLE format_preferences_new (
+			user_id TEXT NOT NULL DEFAULT '',
+			token_id TEXT NOT NULL DEFAULT '',
+			format TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, token_id)
+		)`,
+		"INSERT INTO format_preferences_new (user_id, format, updated_at) SELECT user_id, format, updated_at FROM format_preferences",
+		"DROP TABLE format_preferences",
+		"ALTER TABLE format_preferences_new RENAME TO format_preferences",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("recreate format_preferences: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 // migrateReadingPositions adds new columns to reading_positions for existing databases.
 func (d *Database) migrateReadingPositions() error {
 	migrations := []struct {
@@ -99,6 +250,110 @@ func (d *Database) migrateReadingPositions() error {
 	return nil
 }
 
+// migrateBookAnnotations moves annotations of existing databases from the
+// books table to book_annotations. The freed pages are reused by later
+// imports; MAINTENANCE_VACUUM returns them to the file system.
+func (d *Database) migrateBookAnnotations() error {
+	if !d.columnExists("books", "annotation") {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO book_annotations (book_id, annotation)
+		SELECT id, annotation FROM books WHERE annotation IS NOT NULL AND annotation <> ''`); err != nil {
+		return fmt.Errorf("copy annotations: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE books DROP COLUMN annotation"); err != nil {
+		return fmt.Errorf("drop column annotation: %w", err)
+	}
+	return tx.Commit()
+}
+
+// migrateBookGenres splits genres named after a whole INPX genre field into
+// one genre per code, links every book to all of its codes in book_genres
+// and points books.genre_id at the first. Access rules of a split genre
+// apply to each of its codes.
+func (d *Database) migrateBookGenres() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	type genre struct {
+		id   int64
+		name string
+	}
+	var genres []genre
+	rows, err := tx.Query("SELECT id, name FROM genres ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("list genres: %w", err)
+	}
+	for rows.Next() {
+		var g genre
+		if err := rows.Scan(&g.id, &g.name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan genre: %w", err)
+		}
+		genres = append(genres, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list genres: %w", err)
+	}
+
+	genreID := func(code string) (int64, error) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO genres (name) VALUES (?)", code); err != nil {
+			return 0, err
+		}
+		var id int64
+		err := tx.QueryRow("SELECT id FROM genres WHERE name = ?", code).Scan(&id)
+		return id, err
+	}
+
+	for _, g := range genres {
+		codes := genreCodes(g.name)
+		if len(codes) == 1 && codes[0] == g.name {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO book_genres (book_id, genre_id) SELECT id, genre_id FROM books WHERE genre_id = ?", g.id); err != nil {
+				return fmt.Errorf("link genre %s: %w", g.name, err)
+			}
+			continue
+		}
+
+		var mainID sql.NullInt64
+		for _, code := range codes {
+			id, err := genreID(code)
+			if err != nil {
+				return fmt.Errorf("create genre %s: %w", code, err)
+			}
+			if !mainID.Valid {
+				mainID = sql.NullInt64{Int64: id, Valid: true}
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO book_genres (book_id, genre_id) SELECT id, ? FROM books WHERE genre_id = ?", id, g.id); err != nil {
+				return fmt.Errorf("link genre %s: %w", code, err)
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO access_rules (kind, name, role, created_at) SELECT kind, ?, role, created_at FROM access_rules WHERE kind = 'genre' AND name = ?", code, g.name); err != nil {
+				return fmt.Errorf("split access rule %s: %w", g.name, err)
+			}
+		}
+		if _, err := tx.Exec("UPDATE books SET genre_id = ? WHERE genre_id = ?", mainID, g.id); err != nil {
+			return fmt.Errorf("update books of genre %s: %w", g.name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM access_rules WHERE kind = 'genre' AND name = ?", g.name); err != nil {
+			return fmt.Errorf("delete access rule %s: %w", g.name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM genres WHERE id = ?", g.id); err != nil {
+			return fmt.Errorf("delete genre %s: %w", g.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
 // migrateReadingPositionsPK migrates reading_positions from old schema (book_id-only PK)
 // to new schema with composite PK (user_id, book_id).
 // This runs BEFORE schema.sql so the CREATE TABLE IF NOT EXISTS won't conflict.
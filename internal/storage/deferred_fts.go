@@ -0,0 +1,48 @@
+package storage
+
+import "fmt"
+
+// SetDeferredFTS makes InsertBooks into an empty catalog, as after
+// ClearAllBooks, skip the full-text index. BuildDeferredFTS then fills it
+// with a single INSERT ... SELECT, which is much faster than indexing the
+// books batch by batch. Until then text searches find nothing.
+func (r *Repository) SetDeferredFTS(enabled bool) {
+	r.ftsDeferred.Store(enabled)
+}
+
+// BuildDeferredFTS rebuilds books_fts from the catalog if an import left
+// it to be built, or if the catalog has books but the index is empty, as
+// when the server stopped before the index was built. It reports whether
+// the index was rebuilt.
+func (r *Repository) BuildDeferredFTS() (bool, error) {
+	if !r.ftsPending.Load() {
+		var missing bool
+		if err := r.db.db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM books) AND NOT EXISTS (SELECT 1 FROM books_fts)",
+		).Scan(&missing); err != nil {
+			return false, fmt.Errorf("failed to check full-text index: %w", err)
+		}
+		if !missing {
+			return false, nil
+		}
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := clearBooksFTSTx(tx); err != nil {
+		return false, fmt.Errorf("failed to clear full-text index: %w", err)
+	}
+	if err := indexBooksTx(tx, ""); err != nil {
+		return false, fmt.Errorf("failed to build full-text index: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit full-text index: %w", err)
+	}
+
+	r.ftsPending.Store(false)
+	return true, nil
+}
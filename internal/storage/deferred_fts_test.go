@@ -0,0 +1,67 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestDeferredFTS(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+	repo.SetDeferredFTS(true)
+
+	search := func() int {
+		t.Helper()
+		list, err := repo.SearchBooks(storage.BookFilter{Query: "Толстой"})
+		if err != nil {
+			t.Fatalf("SearchBooks failed: %v", err)
+		}
+		return list.Total
+	}
+
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("ClearAllBooks failed: %v", err)
+	}
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "d-1", Title: "Война и мир", Authors: []string{"Лев Толстой"}, Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if n := search(); n != 0 {
+		t.Errorf("expected the index to be deferred, found %d books", n)
+	}
+
+	// A later import before the build is indexed with the rest
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "d-2", Title: "Анна Каренина", Authors: []string{"Лев Толстой"}, Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	if built, err := repo.BuildDeferredFTS(); err != nil || !built {
+		t.Fatalf("BuildDeferredFTS = %v, %v; want a build", built, err)
+	}
+	repo.InvalidateQueryCache()
+	if n := search(); n != 2 {
+		t.Errorf("expected 2 books after the build, found %d", n)
+	}
+	if built, err := repo.BuildDeferredFTS(); err != nil || built {
+		t.Errorf("second BuildDeferredFTS = %v, %v; want nothing to build", built, err)
+	}
+
+	// An index lost to an interrupted import is rebuilt as well
+	if _, err := db.DB().Exec("DELETE FROM books_fts"); err != nil {
+		t.Fatalf("failed to clear index: %v", err)
+	}
+	if built, err := repo.BuildDeferredFTS(); err != nil || !built {
+		t.Errorf("BuildDeferredFTS of an empty index = %v, %v; want a build", built, err)
+	}
+}
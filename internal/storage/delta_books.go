@@ -0,0 +1,65 @@
+package storage
+
+import "fmt"
+
+// MarkDeltaBooks records books added by a delta import. Refreshing from the
+// full INPX keeps them until the full INPX lists them too.
+func (r *Repository) MarkDeltaBooks(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows := newMultiRowInsert(tx, "INSERT OR IGNORE INTO delta_books (book_id) VALUES ", 1)
+	defer rows.close()
+	for _, id := range ids {
+		rows.add(id)
+	}
+	if err := rows.flush(); err != nil {
+		return fmt.Errorf("failed to mark delta books: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delta books: %w", err)
+	}
+	return nil
+}
+
+// DeltaBookIDs returns the books added by delta imports that the full INPX
+// did not list yet.
+func (r *Repository) DeltaBookIDs() (map[string]bool, error) {
+	rows, err := r.db.db.Query("SELECT book_id FROM delta_books")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list delta books: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan delta book: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// UnmarkDeltaBooks hands books of delta imports over to the full INPX,
+// which now lists them.
+func (r *Repository) UnmarkDeltaBooks(ids []string) error {
+	for start := 0; start < len(ids); start += existingIDsBatch {
+		batch := ids[start:min(start+existingIDsBatch, len(ids))]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		if _, err := r.db.db.Exec("DELETE FROM delta_books WHERE book_id IN ("+createPlaceholders(len(batch))+")", args...); err != nil {
+			return fmt.Errorf("failed to unmark delta books: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSplit is returned when a split names no books of the author or
+// keeps the author's name.
+var ErrInvalidSplit = errors.New("split must move books of the author to a new name")
+
+// AuthorDisambiguation tells apart authors that share a name
+type AuthorDisambiguation struct {
+	// MayBeMerged is set when the record may hold books of several people:
+	// an admin marked the name so, or books of a namesake were split off it
+	MayBeMerged bool   `json:"may_be_merged"`
+	Note        string `json:"note,omitempty"`
+	// Namesakes are the other records of the same name made by splits
+	Namesakes []Author `json:"namesakes"`
+}
+
+// SplitAuthor moves the given books of authorID to the author name, e.g.
+// "Николай Иванов (историк)", which is created if needed. sourceID, when
+// not empty, is stored as the external ID of that author. The split is
+// remembered by book and name and re-applied after reindex; an author left
+// without books is deleted.
+func (r *Repository) SplitAuthor(authorID int, bookIDs []string, name, sourceID string) (*Author, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(bookIDs) == 0 {
+		return nil, ErrInvalidSplit
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	source, err := authorNameTx(tx, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if source == name {
+		return nil, ErrInvalidSplit
+	}
+	for _, bookID := range bookIDs {
+		var linked int
+		if err := tx.QueryRow(
+			"SELECT COUNT(*) FROM book_authors WHERE book_id = ? AND author_id = ?", bookID, authorID,
+		).Scan(&linked); err != nil {
+			return nil, fmt.Errorf("failed to check book %s: %w", bookID, err)
+		}
+		if linked == 0 {
+			return nil, fmt.Errorf("%w: book %s is not by %s", ErrInvalidSplit, bookID, source)
+		}
+	}
+
+	target, err := r.splitAuthorTx(tx, authorID, bookIDs, name, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split author: %w", err)
+	}
+	for _, bookID := range bookIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO author_splits (book_id, author_name, target_name, source_id) VALUES (?, ?, ?, ?)
+			 ON CONFLICT(book_id, author_name) DO UPDATE SET
+			   target_name = excluded.target_name,
+			   source_id = excluded.source_id`,
+			bookID, source, name, sourceID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to save author split: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit author split: %w", err)
+	}
+	return target, nil
+}
+
+// ApplyAuthorSplits re-applies stored author splits after INPX import, once
+// author merges are applied. Splits of books that are gone or no longer by
+// the author are skipped. Returns the number of splits applied.
+func (r *Repository) ApplyAuthorSplits() (int, error) {
+	type split struct {
+		bookID, author, target, sourceID string
+	}
+	// Splits of a split-off author need the earlier split, so keep the order
+	rows, err := r.db.db.Query(
+		"SELECT book_id, author_name, target_name, source_id FROM author_splits ORDER BY rowid")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query author splits: %w", err)
+	}
+	var splits []split
+	for rows.Next() {
+		var s split
+		if err := rows.Scan(&s.bookID, &s.author, &s.target, &s.sourceID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan author split: %w", err)
+		}
+		splits = append(splits, s)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating author splits: %w", err)
+	}
+	rows.Close()
+
+	if len(splits) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	applied := 0
+	for _, s := range splits {
+		var authorID int
+		err := tx.QueryRow(`
+			SELECT a.id FROM authors a JOIN book_authors ba ON ba.author_id = a.id
+			WHERE a.name = ? AND ba.book_id = ?`, s.author, s.bookID).Scan(&authorID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve author split of book %s: %w", s.bookID, err)
+		}
+		if _, err := r.splitAuthorTx(tx, authorID, []string{s.bookID}, s.target, s.sourceID); err != nil {
+			return 0, fmt.Errorf("failed to split author of book %s: %w", s.bookID, err)
+		}
+		applied++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit author splits: %w", err)
+	}
+	return applied, nil
+}
+
+// splitAuthorTx relinks bookIDs from authorID to the author name, sets its
+// source ID if given and deletes authorID if it has no books left
+func (r *Repository) splitAuthorTx(tx *sql.Tx, authorID int, bookIDs []string, name, sourceID string) (*Author, error) {
+	targetID, err := r.getOrCreateAuthorTx(tx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sourceID != "" {
+		if _, err := tx.Exec("UPDATE authors SET source_id = ? WHERE id = ?", sourceID, targetID); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, bookID := range bookIDs {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO book_authors (book_id, author_id) VALUES (?, ?)", bookID, targetID,
+		); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM book_authors WHERE book_id = ? AND author_id = ?", bookID, authorID,
+		); err != nil {
+			return nil, err
+		}
+		if err := refreshBookFTSTx(tx, bookID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM authors WHERE id = ? AND NOT EXISTS (SELECT 1 FROM book_authors WHERE author_id = ?)",
+		authorID, authorID,
+	); err != nil {
+		return nil, err
+	}
+
+	target := Author{ID: targetID}
+	if err := tx.QueryRow("SELECT name, source_id FROM authors WHERE id = ?", targetID).Scan(&target.Name, &target.SourceID); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// SetAuthorAmbiguous marks the name of authorID as possibly shared by
+// several people, with an optional note shown to readers. The mark is kept
+// by name and survives reindex.
+func (r *Repository) SetAuthorAmbiguous(authorID int, note string) error {
+	result, err := r.db.db.Exec(
+		`INSERT INTO ambiguous_authors (author_name, note)
+		 SELECT name, ? FROM authors WHERE id = ?
+		 ON CONFLICT(author_name) DO UPDATE SET note = excluded.note`,
+		strings.TrimSpace(note), authorID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark author as ambiguous: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAuthorNotFound
+	}
+	return nil
+}
+
+// ClearAuthorAmbiguous removes the mark set by SetAuthorAmbiguous; it
+// reports false if the author was not marked.
+func (r *Repository) ClearAuthorAmbiguous(authorID int) (bool, error) {
+	result, err := r.db.db.Exec(
+		"DELETE FROM ambiguous_authors WHERE author_name = (SELECT name FROM authors WHERE id = ?)", authorID)
+	if err != nil {
+		return false, fmt.Errorf("failed to clear ambiguous author: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetAuthorDisambiguation returns what is known about namesakes of the
+// author: whether its record may hold books of several people and the
+// other records of its name.
+func (r *Repository) GetAuthorDisambiguation(author *Author) (*AuthorDisambiguation, error) {
+	result := &AuthorDisambiguation{Namesakes: []Author{}}
+
+	err := r.db.db.QueryRow("SELECT note FROM ambiguous_authors WHERE author_name = ?", author.Name).Scan(&result.Note)
+	switch {
+	case err == nil:
+		result.MayBeMerged = true
+	case err != sql.ErrNoRows:
+		return nil, fmt.Errorf("failed to load ambiguous author: %w", err)
+	}
+
+	// The original name is the one books were split off
+	base := author.Name
+	err = r.db.db.QueryRow(
+		"SELECT author_name FROM author_splits WHERE target_name = ? ORDER BY rowid LIMIT 1", author.Name,
+	).Scan(&base)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to resolve split author: %w", err)
+	}
+
+	rows, err := r.db.db.Query(`
+		SELECT id, name, source_id FROM authors
+		WHERE (name = ? OR name IN (SELECT target_name FROM author_splits WHERE author_name = ?)) AND id != ?
+		ORDER BY LOWER(name)`, base, base, author.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query namesakes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var namesake Author
+		if err := rows.Scan(&namesake.ID, &namesake.Name, &namesake.SourceID); err != nil {
+			return nil, fmt.Errorf("failed to scan namesake: %w", err)
+		}
+		result.Namesakes = append(result.Namesakes, namesake)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating namesakes: %w", err)
+	}
+
+	// Books left under the original name after a split are not checked
+	if base == author.Name && len(result.Namesakes) > 0 {
+		result.MayBeMerged = true
+	}
+	return result, nil
+}
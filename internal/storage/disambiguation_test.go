@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestAuthorSplits(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	books := []inpx.Book{
+		{ID: "b-1", Title: "Северная война", Authors: []string{"Николай Иванов"}},
+		{ID: "b-2", Title: "Полтава", Authors: []string{"Николай Иванов"}},
+		{ID: "b-3", Title: "Звёздный путь", Authors: []string{"Николай Иванов"}},
+	}
+	for i := range books {
+		books[i].Genre, books[i].Language, books[i].Format, books[i].Date = "prose", "ru", "fb2", time.Now()
+		books[i].ArchivePath, books[i].FileNum = "books", books[i].ID
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	authorOf := func(name string) *Author {
+		t.Helper()
+		authors, err := repo.FindAuthors(name, 10)
+		if err != nil {
+			t.Fatalf("FindAuthors: %v", err)
+		}
+		for _, author := range authors {
+			if author.Name == name {
+				a, err := repo.GetAuthorByID(author.ID)
+				if err != nil {
+					t.Fatalf("GetAuthorByID: %v", err)
+				}
+				return a
+			}
+		}
+		return nil
+	}
+	booksOf := func(name string) int {
+		t.Helper()
+		result, err := repo.SearchBooks(BookFilter{Authors: []string{name}, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks: %v", err)
+		}
+		return result.Total
+	}
+
+	ivanov := authorOf("Николай Иванов")
+	if _, err := repo.SplitAuthor(ivanov.ID, []string{"b-3"}, "Николай Иванов", ""); !errors.Is(err, ErrInvalidSplit) {
+		t.Errorf("SplitAuthor(same name) = %v, want ErrInvalidSplit", err)
+	}
+	if _, err := repo.SplitAuthor(ivanov.ID, []string{"missing"}, "Николай Иванов (фантаст)", ""); !errors.Is(err, ErrInvalidSplit) {
+		t.Errorf("SplitAuthor(foreign book) = %v, want ErrInvalidSplit", err)
+	}
+
+	split, err := repo.SplitAuthor(ivanov.ID, []string{"b-3"}, "Николай Иванов (фантаст)", "flibusta:4242")
+	if err != nil {
+		t.Fatalf("SplitAuthor: %v", err)
+	}
+	if split.Name != "Николай Иванов (фантаст)" || split.SourceID != "flibusta:4242" {
+		t.Errorf("split author = %+v", split)
+	}
+	if n := booksOf("Николай Иванов"); n != 2 {
+		t.Errorf("books of the original author = %d, want 2", n)
+	}
+	if n := booksOf("Николай Иванов (фантаст)"); n != 1 {
+		t.Errorf("books of the split author = %d, want 1", n)
+	}
+
+	d, err := repo.GetAuthorDisambiguation(ivanov)
+	if err != nil {
+		t.Fatalf("GetAuthorDisambiguation: %v", err)
+	}
+	if !d.MayBeMerged || len(d.Namesakes) != 1 || d.Namesakes[0].ID != split.ID {
+		t.Errorf("disambiguation of the original author = %+v", d)
+	}
+	d, err = repo.GetAuthorDisambiguation(split)
+	if err != nil {
+		t.Fatalf("GetAuthorDisambiguation: %v", err)
+	}
+	if d.MayBeMerged || len(d.Namesakes) != 1 || d.Namesakes[0].ID != ivanov.ID {
+		t.Errorf("disambiguation of the split author = %+v", d)
+	}
+
+	// Reindex recreates the merged record; the split is applied again
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("ClearAllBooks: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if applied, err := repo.ApplyAuthorSplits(); err != nil || applied != 1 {
+		t.Fatalf("ApplyAuthorSplits = %d, %v, want 1", applied, err)
+	}
+	if n := booksOf("Николай Иванов (фантаст)"); n != 1 {
+		t.Errorf("books of the split author after reindex = %d, want 1", n)
+	}
+	if a := authorOf("Николай Иванов (фантаст)"); a == nil || a.SourceID != "flibusta:4242" {
+		t.Errorf("split author after reindex = %+v, want the source ID kept", a)
+	}
+
+	ivanov = authorOf("Николай Иванов")
+	if err := repo.SetAuthorAmbiguous(ivanov.ID, "Историк и поэт"); err != nil {
+		t.Fatalf("SetAuthorAmbiguous: %v", err)
+	}
+	if d, err := repo.GetAuthorDisambiguation(ivanov); err != nil || d.Note != "Историк и поэт" {
+		t.Errorf("disambiguation of a marked author = %+v, %v", d, err)
+	}
+	if err := repo.SetAuthorAmbiguous(9999, ""); err != ErrAuthorNotFound {
+		t.Errorf("SetAuthorAmbiguous(unknown) = %v, want ErrAuthorNotFound", err)
+	}
+	if cleared, err := repo.ClearAuthorAmbiguous(ivanov.ID); err != nil || !cleared {
+		t.Errorf("ClearAuthorAmbiguous = %v, %v", cleared, err)
+	}
+}
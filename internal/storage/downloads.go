@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const downloadColumns = `id, downloaded_at, book_id, title, user_id, username, ip, user_agent, bytes, complete`
+
+// LogDownload appends a download to the audit log. Times are stored in UTC
+// so that they compare as text.
+func (r *Repository) LogDownload(rec DownloadRecord) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	complete := 0
+	if rec.Complete {
+		complete = 1
+	}
+	if _, err := r.db.db.Exec(
+		`INSERT INTO download_log (downloaded_at, book_id, title, user_id, username, ip, user_agent, bytes, complete)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Time.UTC().Truncate(time.Second), rec.BookID, rec.Title, rec.UserID, rec.Username,
+		rec.IP, rec.UserAgent, rec.Bytes, complete,
+	); err != nil {
+		return fmt.Errorf("failed to log download: %w", err)
+	}
+	return nil
+}
+
+// ListDownloads returns a page of the download log matching filter, newest
+// first, and the number of matching entries.
+func (r *Repository) ListDownloads(filter DownloadFilter) ([]DownloadRecord, int, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 30
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	where, args := downloadWhere(filter)
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM download_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count downloads: %w", err)
+	}
+
+	var records []DownloadRecord
+	err := r.eachDownload(where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, filter.Limit, filter.Offset), func(rec DownloadRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	return records, total, err
+}
+
+// EachDownload calls fn for every download log entry matching filter,
+// newest first, ignoring Limit and Offset. It stops at the first error.
+func (r *Repository) EachDownload(filter DownloadFilter, fn func(DownloadRecord) error) error {
+	where, args := downloadWhere(filter)
+	return r.eachDownload(where+" ORDER BY id DESC", args, fn)
+}
+
+func (r *Repository) eachDownload(clauses string, args []interface{}, fn func(DownloadRecord) error) error {
+	rows, err := r.db.db.Query("SELECT "+downloadColumns+" FROM download_log"+clauses, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query downloads: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec DownloadRecord
+		if err := rows.Scan(&rec.ID, &rec.Time, &rec.BookID, &rec.Title, &rec.UserID, &rec.Username,
+			&rec.IP, &rec.UserAgent, &rec.Bytes, &rec.Complete); err != nil {
+			return fmt.Errorf("failed to scan download: %w", err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating downloads: %w", err)
+	}
+	return nil
+}
+
+func downloadWhere(filter DownloadFilter) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.BookID != "" {
+		conditions = append(conditions, "book_id = ?")
+		args = append(args, filter.BookID)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "downloaded_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "downloaded_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// PruneDownloads rotates the download log: it deletes entries older than
+// before and, when maxEntries is positive, all but the newest maxEntries.
+// It returns the number of deleted entries.
+func (r *Repository) PruneDownloads(before time.Time, maxEntries int) (int64, error) {
+	var deleted int64
+	if !before.IsZero() {
+		result, err := r.db.db.Exec("DELETE FROM download_log WHERE downloaded_at < ?", before.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to prune download log: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if maxEntries > 0 {
+		result, err := r.db.db.Exec(
+			`DELETE FROM download_log WHERE id <= (SELECT id FROM download_log ORDER BY id DESC LIMIT 1 OFFSET ?)`,
+			maxEntries)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune download log: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// PopularBooks returns the IDs of the limit books downloaded most often
+// since a time, most downloaded first. Only the download log counts, so
+// there are none while it is disabled.
+func (r *Repository) PopularBooks(since time.Time, limit int) ([]string, error) {
+	rows, err := r.db.db.Query(
+		`SELECT book_id FROM download_log WHERE downloaded_at >= ?
+		 GROUP BY book_id ORDER BY COUNT(*) DESC, MAX(id) DESC LIMIT ?`,
+		since.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query popular books: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan popular book: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating popular books: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// EachExportBook calls fn with the INPX record of every book, grouped by
+// archive, as the catalog currently stands: metadata corrections and
+// author merges made by admins are included and hidden books are left
+// out. Books whose file has
+// the same checksum as an earlier book are duplicates and skipped; their
+// number is returned. It stops at the first error.
+func (r *Repository) EachExportBook(fn func(inpx.Book) error) (int, error) {
+	rows, err := r.db.db.Query(`
+		SELECT b.id, b.title, COALESCE(s.name, ''), b.series_num, COALESCE(` + bookGenresExpr + `, g.name, ''),
+		       b.year, b.language, b.file_size, b.archive_path, b.file_num, b.format,
+		       b.date_added, b.rating, COALESCE(` + annotationExpr + `, ''), COALESCE(bc.sha256, ''),
+		       (SELECT GROUP_CONCAT(name, char(31)) FROM (
+		            SELECT a.name FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+		            WHERE ba.book_id = b.id ORDER BY ba.rowid)),
+		       ` + otherSeriesExpr + `
+		FROM books b
+		LEFT JOIN series s ON b.series_id = s.id
+		LEFT JOIN genres g ON b.genre_id = g.id
+		LEFT JOIN book_checksums bc ON bc.book_id = b.id
+		WHERE ` + visibleCondition + `
+		ORDER BY b.archive_path, b.id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query books for export: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	duplicates := 0
+	for rows.Next() {
+		var (
+			book        inpx.Book
+			checksum    string
+			authors     sql.NullString
+			otherSeries sql.NullString
+			date        sql.NullTime
+		)
+		if err := rows.Scan(&book.ID, &book.Title, &book.Series, &book.SeriesNum, &book.Genre,
+			&book.Year, &book.Language, &book.FileSize, &book.ArchivePath, &book.FileNum, &book.Format,
+			&date, &book.Rating, &book.Annotation, &checksum, &authors, &otherSeries); err != nil {
+			return duplicates, fmt.Errorf("failed to scan book: %w", err)
+		}
+
+		if checksum != "" {
+			if seen[checksum] {
+				duplicates++
+				continue
+			}
+			seen[checksum] = true
+		}
+		book.Date = date.Time
+		if authors.Valid {
+			book.Authors = strings.Split(authors.String, "\x1f")
+		}
+		book.OtherSeries = parseOtherSeries(otherSeries.String)
+		// INP has no year field: the year is read from the date, so a
+		// corrected year has to be carried by it
+		if book.Year > 0 && book.Year != book.Date.Year() {
+			book.Date = time.Date(book.Year, book.Date.Month(), book.Date.Day(), 0, 0, 0, 0, time.UTC)
+		}
+
+		if err := fn(book); err != nil {
+			return duplicates, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return duplicates, fmt.Errorf("error iterating books for export: %w", err)
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestEachExportBook(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	date := time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)
+	books := []inpx.Book{
+		{ID: "2", Title: "Нос", Authors: []string{"Гоголь", "Николай"}, ArchivePath: "b", Annotation: "Строка\nвторая"},
+		{ID: "1", Title: "Капитанская дочка", Authors: []string{"Пушкин"}, Series: "Повести", SeriesNum: 2, ArchivePath: "a"},
+		{ID: "3", Title: "Нос (копия)", Authors: []string{"Гоголь"}, ArchivePath: "c"},
+	}
+	for i := range books {
+		books[i].Genre, books[i].Language, books[i].Format, books[i].Date = "prose", "ru", "fb2", date
+		books[i].Year, books[i].FileSize, books[i].FileNum = 2020, 1000, books[i].ID
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	title := "Капитанская дочка (исправлено)"
+	if _, err := repo.UpdateBook("1", BookUpdate{Title: &title}); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	for _, id := range []string{"2", "3"} {
+		if err := repo.SaveBookChecksum(id, "same-file", 1000); err != nil {
+			t.Fatalf("SaveBookChecksum: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writer := inpx.NewWriter(&buf)
+	duplicates, err := repo.EachExportBook(writer.Add)
+	if err != nil {
+		t.Fatalf("EachExportBook: %v", err)
+	}
+	if err := writer.Close(inpx.CollectionInfo{Name: "Export - 2024-01-01", Version: "2024-01-01", Description: "test"}); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if duplicates != 1 {
+		t.Errorf("duplicates = %d, want 1", duplicates)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.inpx")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write INPX: %v", err)
+	}
+	exported, info, lineErrors, err := inpx.NewParser().ParseINPXWithErrors(path)
+	if err != nil || len(lineErrors) != 0 {
+		t.Fatalf("exported INPX does not parse: %v %+v", err, lineErrors)
+	}
+	if info == nil || info.Name != "Export - 2024-01-01" {
+		t.Errorf("unexpected collection info: %+v", info)
+	}
+	if len(exported) != 2 {
+		t.Fatalf("exported %d books, want 2: %+v", len(exported), exported)
+	}
+
+	first := exported[0]
+	if first.ID != "1" || first.Title != title || first.Series != "Повести" || first.SeriesNum != 2 ||
+		first.ArchivePath != "a" || first.Year != 2020 || !first.Date.Equal(date) {
+		t.Errorf("unexpected first book: %+v", first)
+	}
+	second := exported[1]
+	if second.ID != "2" || len(second.Authors) != 2 || second.Authors[0] != "Гоголь" || second.Annotation != "Строка вторая" {
+		t.Errorf("unexpected second book: %+v", second)
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// facetColumns maps facet names accepted by CountBookFacet to book columns
+var facetColumns = map[string]string{
+	"format":   "b.format",
+	"language": "b.language",
+}
+
+// CountBookFacet counts books matching filter per value of a facet field
+// ("format" or "language"), most frequent first. The filter on the facet's
+// own field is ignored so that every alternative value is counted.
+func (r *Repository) CountBookFacet(filter BookFilter, field string) ([]FacetCount, error) {
+	return cachedQuery(r, func() ([]FacetCount, error) {
+		return r.countBookFacetUncached(filter, field)
+	}, "facet", filter, filter.Hidden, field)
+}
+
+func (r *Repository) countBookFacetUncached(filter BookFilter, field string) ([]FacetCount, error) {
+	column, ok := facetColumns[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown facet field %q", field)
+	}
+	if err := validateSearchQuery(filter.Query); err != nil {
+		return nil, err
+	}
+
+	if len(filter.Authors) > 0 {
+		authors, err := r.expandAuthorAliases(filter.Authors)
+		if err != nil {
+			return nil, err
+		}
+		filter.Authors = authors
+	}
+
+	switch field {
+	case "format":
+		filter.Formats = nil
+	case "language":
+		filter.Languages = nil
+	}
+
+	counts, err := r.countBookFacet(filter, column, true)
+	if err != nil && isFTSQueryError(err) {
+		log.Printf("CountBookFacet: FTS query %q failed, falling back to LIKE search: %v", filter.Query, err)
+		counts, err = r.countBookFacet(filter, column, false)
+	}
+	return counts, err
+}
+
+func (r *Repository) countBookFacet(filter BookFilter, column string, useFTS bool) ([]FacetCount, error) {
+	from := buildSearchFrom(filter, useFTS)
+	query := fmt.Sprintf(`SELECT value, COUNT(*) AS n
+		FROM (SELECT DISTINCT b.id, %s AS value%s)
+		WHERE value IS NOT NULL AND value != ''
+		GROUP BY value ORDER BY n DESC, value`, column, from.sql)
+
+	rows, err := r.db.db.Query(query, from.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facet: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []FacetCount
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan facet count: %w", err)
+		}
+		counts = append(counts, fc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating facet counts: %w", err)
+	}
+	return counts, nil
+}
+
+// CountBookFacets counts books matching filter per genre, language, format
+// and publication decade in a single grouped query. As with CountBookFacet,
+// each facet ignores the filter on its own field, so the counts of the
+// alternatives a reader could switch to are shown.
+func (r *Repository) CountBookFacets(filter BookFilter) (*BookFacets, error) {
+	filter.Limit, filter.Offset, filter.SortBy, filter.SortOrder = 0, 0, "", ""
+	return cachedQuery(r, func() (*BookFacets, error) {
+		return r.countBookFacetsUncached(filter)
+	}, "facets", filter, filter.Hidden)
+}
+
+func (r *Repository) countBookFacetsUncached(filter BookFilter) (*BookFacets, error) {
+	if err := validateSearchQuery(filter.Query); err != nil {
+		return nil, err
+	}
+
+	if len(filter.Authors) > 0 {
+		authors, err := r.expandAuthorAliases(filter.Authors)
+		if err != nil {
+			return nil, err
+		}
+		filter.Authors = authors
+	}
+
+	facets, err := r.countBookFacets(filter, true)
+	if err != nil && isFTSQueryError(err) {
+		log.Printf("CountBookFacets: FTS query %q failed, falling back to LIKE search: %v", filter.Query, err)
+		facets, err = r.countBookFacets(filter, false)
+	}
+	return facets, err
+}
+
+func (r *Repository) countBookFacets(filter BookFilter, useFTS bool) (*BookFacets, error) {
+	withoutGenres, withoutLanguages, withoutFormats, withoutYears := filter, filter, filter, filter
+	withoutGenres.Genres = nil
+	withoutLanguages.Languages = nil
+	withoutFormats.Formats = nil
+	withoutYears.YearFrom, withoutYears.YearTo = 0, 0
+
+	var (
+		parts []string
+		args  []interface{}
+	)
+	for _, facet := range []struct {
+		name, column, value string
+		filter              BookFilter
+	}{
+		{"genre", "", "", withoutGenres},
+		{"language", "b.language", "value", withoutLanguages},
+		{"format", "b.format", "value", withoutFormats},
+		{"year", "b.year", "value / 10 * 10", withoutYears},
+	} {
+		from := buildSearchFrom(facet.filter, useFTS)
+		if facet.name == "genre" {
+			// A book counts for each of its genres
+			parts = append(parts, fmt.Sprintf(`SELECT 'genre', xg.name, COUNT(*)
+				FROM (SELECT DISTINCT b.id AS id%s) m
+				JOIN book_genres xbg ON xbg.book_id = m.id
+				JOIN genres xg ON xg.id = xbg.genre_id
+				GROUP BY 2`, from.sql))
+			args = append(args, from.args...)
+			continue
+		}
+		condition := "value IS NOT NULL AND value != ''"
+		if facet.name == "year" {
+			condition = "value > 0"
+		}
+		parts = append(parts, fmt.Sprintf(`SELECT '%s', %s, COUNT(*)
+			FROM (SELECT DISTINCT b.id, %s AS value%s)
+			WHERE %s
+			GROUP BY 2`, facet.name, facet.value, facet.column, from.sql, condition))
+		args = append(args, from.args...)
+	}
+
+	rows, err := r.db.db.Query(strings.Join(parts, " UNION ALL ")+" ORDER BY 1, 3 DESC, 2", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count facets: %w", err)
+	}
+	defer rows.Close()
+
+	facets := &BookFacets{
+		Genres:    []FacetCount{},
+		Languages: []FacetCount{},
+		Formats:   []FacetCount{},
+		Years:     []YearCount{},
+	}
+	for rows.Next() {
+		var (
+			name string
+			fc   FacetCount
+		)
+		if err := rows.Scan(&name, &fc.Value, &fc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan facet count: %w", err)
+		}
+		switch name {
+		case "genre":
+			facets.Genres = append(facets.Genres, fc)
+		case "language":
+			facets.Languages = append(facets.Languages, fc)
+		case "format":
+			facets.Formats = append(facets.Formats, fc)
+		case "year":
+			decade, _ := strconv.Atoi(fc.Value)
+			facets.Years = append(facets.Years, YearCount{Year: decade, Count: fc.Count})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating facet counts: %w", err)
+	}
+
+	sort.Slice(facets.Years, func(i, j int) bool { return facets.Years[i].Year < facets.Years[j].Year })
+	return facets, nil
+}
+
+// ListYears returns the distinct publication years with their book counts,
+// oldest first. Books without a year and books hidden by hidden are left out.
+func (r *Repository) ListYears(hidden *Restrictions) ([]YearCount, error) {
+	return r.ListYearsInLanguage("", hidden)
+}
+
+// ListYearsInLanguage is ListYears for the books in language; an empty
+// language counts all books
+func (r *Repository) ListYearsInLanguage(language string, hidden *Restrictions) ([]YearCount, error) {
+	return cachedQuery(r, func() ([]YearCount, error) {
+		return r.listYears(language, hidden)
+	}, "years", language, hidden)
+}
+
+// GroupDecades sums year counts, oldest first, per decade; Year of the
+// result is the first year of the decade
+func GroupDecades(years []YearCount) []YearCount {
+	var decades []YearCount
+	for _, yc := range years {
+		decade := yc.Year / 10 * 10
+		if n := len(decades); n > 0 && decades[n-1].Year == decade {
+			decades[n-1].Count += yc.Count
+			continue
+		}
+		decades = append(decades, YearCount{Year: decade, Count: yc.Count})
+	}
+	return decades
+}
+
+func (r *Repository) listYears(language string, hidden *Restrictions) ([]YearCount, error) {
+	filter := BookFilter{Hidden: hidden}
+	if language != "" {
+		filter.Languages = []string{language}
+	}
+	from := buildSearchFrom(filter, false)
+	query := fmt.Sprintf(`SELECT value, COUNT(*)
+		FROM (SELECT DISTINCT b.id, b.year AS value%s)
+		WHERE value > 0
+		GROUP BY value ORDER BY value`, from.sql)
+
+	rows, err := r.db.db.Query(query, from.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count years: %w", err)
+	}
+	defer rows.Close()
+
+	var years []YearCount
+	for rows.Next() {
+		var yc YearCount
+		if err := rows.Scan(&yc.Year, &yc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan year count: %w", err)
+		}
+		years = append(years, yc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating year counts: %w", err)
+	}
+	return years, nil
+}
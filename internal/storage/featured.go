@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// featuredOrderExpr is the position of a book among the featured books;
+// books that are not featured sort last
+const featuredOrderExpr = `(SELECT fb.position FROM featured_books fb WHERE fb.book_id = b.id)`
+
+// FeatureBook adds a book to the featured books at position (1 is first)
+// or moves it there. A position below 1 or past the end puts the book last.
+func (r *Repository) FeatureBook(id string, position int) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkBookExists(tx, id); err != nil {
+		return err
+	}
+	ids, err := featuredIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	var others []string
+	for _, featured := range ids {
+		if featured != id {
+			others = append(others, featured)
+		}
+	}
+	if position < 1 || position > len(others) {
+		position = len(others) + 1
+	}
+	ordered := make([]string, 0, len(others)+1)
+	ordered = append(ordered, others[:position-1]...)
+	ordered = append(ordered, id)
+	ordered = append(ordered, others[position-1:]...)
+
+	if err := writeFeaturedOrder(tx, ordered); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit featured books: %w", err)
+	}
+	return nil
+}
+
+// UnfeatureBook removes a book from the featured books; it reports false
+// if the book was not featured.
+func (r *Repository) UnfeatureBook(id string) (bool, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM featured_books WHERE book_id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to unfeature book: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	ids, err := featuredIDs(tx)
+	if err != nil {
+		return false, err
+	}
+	if err := writeFeaturedOrder(tx, ids); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit featured books: %w", err)
+	}
+	return true, nil
+}
+
+// SetFeaturedBooks replaces the featured books with ids, in that order.
+// Repeated IDs are ignored; an unknown ID fails with ErrBookNotFound.
+func (r *Repository) SetFeaturedBooks(ids []string) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool, len(ids))
+	ordered := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := checkBookExists(tx, id); err != nil {
+			return fmt.Errorf("%w: %s", err, id)
+		}
+		ordered = append(ordered, id)
+	}
+
+	if err := writeFeaturedOrder(tx, ordered); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit featured books: %w", err)
+	}
+	return nil
+}
+
+// checkBookExists returns ErrBookNotFound unless the book is imported
+func checkBookExists(tx *sql.Tx, id string) error {
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM books WHERE id = ?", id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check book: %w", err)
+	}
+	if exists == 0 {
+		return ErrBookNotFound
+	}
+	return nil
+}
+
+// featuredIDs returns the featured book IDs in display order
+func featuredIDs(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query("SELECT book_id FROM featured_books ORDER BY position, book_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query featured books: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan featured book: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// writeFeaturedOrder makes ids the featured books, numbered from 1. Books
+// that stay featured keep the time they were first picked.
+func writeFeaturedOrder(tx *sql.Tx, ids []string) error {
+	keep := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keep[i] = id
+	}
+	query := "DELETE FROM featured_books"
+	if len(ids) > 0 {
+		query += " WHERE book_id NOT IN (" + createPlaceholders(len(ids)) + ")"
+	}
+	if _, err := tx.Exec(query, keep...); err != nil {
+		return fmt.Errorf("failed to clear featured books: %w", err)
+	}
+
+	for i, id := range ids {
+		if _, err := tx.Exec(
+			`INSERT INTO featured_books (book_id, position) VALUES (?, ?)
+			 ON CONFLICT(book_id) DO UPDATE SET position = excluded.position`,
+			id, i+1,
+		); err != nil {
+			return fmt.Errorf("failed to save featured book: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import "fmt"
+
+// SizeMismatch is a book whose file in its archive has another size than
+// the INPX says
+type SizeMismatch struct {
+	BookID      string `json:"book_id"`
+	Title       string `json:"title"`
+	ArchivePath string `json:"archive_path"`
+	FileName    string `json:"file_name"`
+	CatalogSize int64  `json:"catalog_size"`
+	ActualSize  int64  `json:"actual_size"`
+	// Fixed is set when the catalog size was replaced by the actual one
+	Fixed bool `json:"fixed"`
+}
+
+// SizeCheck is the result of comparing the file sizes of the catalog with
+// the archive entries
+type SizeCheck struct {
+	Archives   int `json:"archives"`
+	Checked    int `json:"checked"`
+	Mismatches int `json:"mismatches"`
+	Fixed      int `json:"fixed"`
+	// Unchecked books have no archive or no entry in it
+	Unchecked  int   `json:"unchecked"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// ReplaceSizeMismatches replaces the report of the last file size check
+// with mismatches
+func (r *Repository) ReplaceSizeMismatches(mismatches []SizeMismatch) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM size_mismatches"); err != nil {
+		return fmt.Errorf("failed to clear size mismatches: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO size_mismatches
+		(book_id, title, archive_path, file_name, catalog_size, actual_size, fixed)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare size mismatch insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, m := range mismatches {
+		if _, err := stmt.Exec(m.BookID, m.Title, m.ArchivePath, m.FileName, m.CatalogSize, m.ActualSize, m.Fixed); err != nil {
+			return fmt.Errorf("failed to insert size mismatch: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit size mismatches: %w", err)
+	}
+	return nil
+}
+
+// ListSizeMismatches returns a page of the report of the last file size
+// check, by archive and book, and the number of mismatches in it
+func (r *Repository) ListSizeMismatches(limit, offset int) ([]SizeMismatch, int, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT book_id, title, archive_path, file_name, catalog_size, actual_size, fixed
+		 FROM size_mismatches ORDER BY archive_path, book_id LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query size mismatches: %w", err)
+	}
+	defer rows.Close()
+
+	mismatches := []SizeMismatch{}
+	for rows.Next() {
+		var m SizeMismatch
+		if err := rows.Scan(&m.BookID, &m.Title, &m.ArchivePath, &m.FileName, &m.CatalogSize, &m.ActualSize, &m.Fixed); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan size mismatch: %w", err)
+		}
+		mismatches = append(mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating size mismatches: %w", err)
+	}
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM size_mismatches").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count size mismatches: %w", err)
+	}
+	return mismatches, total, nil
+}
+
+// UpdateBookFileSizes sets the file sizes of books, by ID
+func (r *Repository) UpdateBookFileSizes(sizes map[string]int64) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE books SET file_size = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare file size update: %w", err)
+	}
+	defer stmt.Close()
+	for id, size := range sizes {
+		if _, err := stmt.Exec(size, id); err != nil {
+			return fmt.Errorf("failed to update file size of book %s: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file sizes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// formatPattern matches download formats: a file extension such as "epub",
+// or "fb2.zip" for FB2 zipped on download
+var formatPattern = regexp.MustCompile(`^[a-z0-9]{1,10}(\.zip)?$`)
+
+// NormalizeFormat returns a download format in lower case without a
+// leading dot, and whether it is a valid format
+func NormalizeFormat(format string) (string, bool) {
+	format = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(format)), ".")
+	return format, formatPattern.MatchString(format)
+}
+
+// FormatPreference is the download format a user prefers, and the one of
+// the API token of the request, if any
+type FormatPreference struct {
+	User  string `json:"user,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// Format returns the preferred format: that of the token, else that of the
+// user, or "" if there is none.
+func (p FormatPreference) Format() string {
+	if p.Token != "" {
+		return p.Token
+	}
+	return p.User
+}
+
+// SetFormatPreference sets the download format a user prefers or, with a
+// token ID, the one preferred by the client using that API token of the
+// user. An empty format removes the preference.
+func (r *Repository) SetFormatPreference(userID, tokenID, format string) error {
+	if format == "" {
+		if _, err := r.db.db.Exec("DELETE FROM format_preferences WHERE user_id = ? AND token_id = ?", userID, tokenID); err != nil {
+			return fmt.Errorf("failed to delete format preference: %w", err)
+		}
+		return nil
+	}
+	if _, err := r.db.db.Exec(
+		`INSERT INTO format_preferences (user_id, token_id, format, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(user_id, token_id) DO UPDATE SET format = excluded.format, updated_at = excluded.updated_at`,
+		userID, tokenID, format, time.Now(),
+	); err != nil {
+		return fmt.Errorf("failed to set format preference: %w", err)
+	}
+	return nil
+}
+
+// GetFormatPreference returns the download formats a user and, with a
+// token ID, the client using that API token prefer.
+func (r *Repository) GetFormatPreference(userID, tokenID string) (FormatPreference, error) {
+	var pref FormatPreference
+	rows, err := r.db.db.Query(
+		"SELECT token_id, format FROM format_preferences WHERE user_id = ? AND token_id IN ('', ?)",
+		userID, tokenID,
+	)
+	if err != nil {
+		return pref, fmt.Errorf("failed to get format preference: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token, format string
+		if err := rows.Scan(&token, &format); err != nil {
+			return pref, fmt.Errorf("failed to scan format preference: %w", err)
+		}
+		if token == "" {
+			pref.User = format
+		} else {
+			pref.Token = format
+		}
+	}
+	return pref, rows.Err()
+}
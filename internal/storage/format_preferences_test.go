@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestFormatPreferences(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	user, err := repo.CreateUser("reader", "secret", "Reader", false)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token, _, err := repo.CreateAPIToken(user.ID, "ereader", []string{storage.ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+
+	if err := repo.SetFormatPreference(user.ID, "", "epub"); err != nil {
+		t.Fatalf("SetFormatPreference failed: %v", err)
+	}
+	pref, err := repo.GetFormatPreference(user.ID, token.ID)
+	if err != nil || pref.Format() != "epub" || pref.Token != "" {
+		t.Errorf("expected the user preference, got %+v (%v)", pref, err)
+	}
+
+	if err := repo.SetFormatPreference(user.ID, token.ID, "mobi"); err != nil {
+		t.Fatalf("SetFormatPreference failed: %v", err)
+	}
+	if err := repo.SetFormatPreference(user.ID, token.ID, "azw3"); err != nil {
+		t.Fatalf("SetFormatPreference failed: %v", err)
+	}
+	if pref, _ := repo.GetFormatPreference(user.ID, token.ID); pref.Format() != "azw3" || pref.User != "epub" {
+		t.Errorf("expected the token preference to override, got %+v", pref)
+	}
+	if pref, _ := repo.GetFormatPreference(user.ID, ""); pref.Format() != "epub" {
+		t.Errorf("expected the user preference without the token, got %+v", pref)
+	}
+
+	// Revoking the token drops its preference
+	if err := repo.DeleteAPIToken(user.ID, token.ID); err != nil {
+		t.Fatalf("DeleteAPIToken failed: %v", err)
+	}
+	if pref, _ := repo.GetFormatPreference(user.ID, token.ID); pref.Token != "" {
+		t.Errorf("expected the token preference deleted, got %+v", pref)
+	}
+
+	if err := repo.SetFormatPreference(user.ID, "", ""); err != nil {
+		t.Fatalf("SetFormatPreference failed: %v", err)
+	}
+	if pref, _ := repo.GetFormatPreference(user.ID, ""); pref.Format() != "" {
+		t.Errorf("expected no preference, got %+v", pref)
+	}
+
+	for format, valid := range map[string]bool{".EPUB": true, "fb2.zip": true, "e/pub": false, "": false} {
+		if _, ok := storage.NormalizeFormat(format); ok != valid {
+			t.Errorf("NormalizeFormat(%q) valid = %v, want %v", format, ok, valid)
+		}
+	}
+}
+
+func TestFormatPreferencesMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Close()
+
+	// Bring back the layout of older releases, which kept one format per user
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		"DROP TABLE format_preferences",
+		"CREATE TABLE format_preferences (user_id TEXT PRIMARY KEY, format TEXT NOT NULL, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP)",
+		"INSERT INTO format_preferences (user_id, format) VALUES ('user-1', 'epub')",
+	} {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	raw.Close()
+
+	db, err = storage.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	if err := repo.SetFormatPreference("user-1", "token-1", "mobi"); err != nil {
+		t.Fatalf("SetFormatPreference after migration failed: %v", err)
+	}
+	if pref, err := repo.GetFormatPreference("user-1", "token-1"); err != nil || pref.User != "epub" || pref.Token != "mobi" {
+		t.Errorf("GetFormatPreference after migration = %+v, %v; want the kept user format", pref, err)
+	}
+}
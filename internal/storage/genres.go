@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// GenreMapping rewrites the genre codes of imported books to canonical
+// codes, so that misspelled codes such as "sf_fantasy_" or "det_classic2"
+// are counted with the genre they mean.
+type GenreMapping struct {
+	canonical map[string]bool
+	aliases   map[string]string
+}
+
+// NewGenreMapping creates a mapping to the canonical lowercase codes, with
+// aliases mapping lowercase non-standard codes to canonical ones. Without
+// canonical codes only the aliases are applied and no code is unmapped.
+func NewGenreMapping(canonical map[string]bool, aliases map[string]string) *GenreMapping {
+	m := &GenreMapping{canonical: canonical, aliases: make(map[string]string, len(aliases))}
+	for alias, code := range aliases {
+		m.aliases[strings.ToLower(strings.TrimSpace(alias))] = strings.ToLower(strings.TrimSpace(code))
+	}
+	return m
+}
+
+// code maps a single genre code; ok is false if it is not canonical and no
+// alias or obvious correction applies
+func (m *GenreMapping) code(raw string) (string, bool) {
+	code := strings.ToLower(strings.TrimSpace(raw))
+	if mapped, ok := m.aliases[code]; ok {
+		return mapped, true
+	}
+	if len(m.canonical) == 0 || m.canonical[code] {
+		return code, true
+	}
+	// Stray suffixes: "sf_fantasy_", "det_classic2"
+	if trimmed := strings.TrimRight(code, "_0123456789"); m.canonical[trimmed] {
+		return trimmed, true
+	}
+	return code, false
+}
+
+// Normalize maps the codes of an INPX genre field such as
+// "sf_fantasy_:sf_epic:" and returns the field with canonical codes and the
+// codes it could not map, which are kept as they are.
+func (m *GenreMapping) Normalize(genre string) (string, []string) {
+	parts := strings.Split(genre, ":")
+	trailing := strings.HasSuffix(genre, ":")
+
+	codes := make([]string, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	var unmapped []string
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		code, ok := m.code(part)
+		if !ok {
+			unmapped = append(unmapped, code)
+		}
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	normalized := strings.Join(codes, ":")
+	if trailing && normalized != "" {
+		normalized += ":"
+	}
+	return normalized, unmapped
+}
+
+// Apply normalizes the genres of books in place and returns the number of
+// books per unmapped code.
+func (m *GenreMapping) Apply(books []inpx.Book) map[string]int {
+	unmapped := make(map[string]int)
+	for i := range books {
+		if books[i].Genre == "" {
+			continue
+		}
+		genre, codes := m.Normalize(books[i].Genre)
+		books[i].Genre = genre
+		for _, code := range codes {
+			unmapped[code]++
+		}
+	}
+	return unmapped
+}
+
+// SetGenreMapping makes imports normalize genre codes with m; nil turns
+// normalization off.
+func (r *Repository) SetGenreMapping(m *GenreMapping) {
+	r.genreMapping.Store(m)
+}
+
+// GenreMapping returns the mapping imports apply, or nil.
+func (r *Repository) GenreMapping() *GenreMapping {
+	return r.genreMapping.Load()
+}
+
+// ReplaceUnmappedGenres replaces the report of genre codes the last import
+// could not map with unmapped (code to number of books)
+func (r *Repository) ReplaceUnmappedGenres(unmapped map[string]int) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM unmapped_genres"); err != nil {
+		return fmt.Errorf("failed to clear unmapped genres: %w", err)
+	}
+	for code, books := range unmapped {
+		if _, err := tx.Exec("INSERT INTO unmapped_genres (code, books) VALUES (?, ?)", code, books); err != nil {
+			return fmt.Errorf("failed to insert unmapped genre: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unmapped genres: %w", err)
+	}
+	return nil
+}
+
+// ListUnmappedGenres returns the genre codes the last import could not map,
+// most frequent first
+func (r *Repository) ListUnmappedGenres() ([]FacetCount, error) {
+	rows, err := r.db.db.Query("SELECT code, books FROM unmapped_genres")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unmapped genres: %w", err)
+	}
+	defer rows.Close()
+
+	var genres []FacetCount
+	for rows.Next() {
+		var genre FacetCount
+		if err := rows.Scan(&genre.Value, &genre.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan unmapped genre: %w", err)
+		}
+		genres = append(genres, genre)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating unmapped genres: %w", err)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Count != genres[j].Count {
+			return genres[i].Count > genres[j].Count
+		}
+		return genres[i].Value < genres[j].Value
+	})
+	return genres, nil
+}
@@ -0,0 +1,81 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestGenreMappingNormalize(t *testing.T) {
+	m := storage.NewGenreMapping(
+		map[string]bool{"sf_fantasy": true, "det_classic": true, "city_fantasy": true, "sf": true},
+		map[string]string{"SF_Fantasy_City": "city_fantasy"},
+	)
+
+	cases := []struct {
+		genre    string
+		want     string
+		unmapped []string
+	}{
+		{genre: "sf_fantasy", want: "sf_fantasy"},
+		{genre: "sf_fantasy_", want: "sf_fantasy"},
+		{genre: "det_classic2", want: "det_classic"},
+		{genre: "SF_FANTASY:", want: "sf_fantasy:"},
+		{genre: "sf_fantasy_city:sf:", want: "city_fantasy:sf:"},
+		{genre: "sf_fantasy:sf_fantasy_:", want: "sf_fantasy:"},
+		{genre: "sf:weird_stuff:", want: "sf:weird_stuff:", unmapped: []string{"weird_stuff"}},
+	}
+	for _, tc := range cases {
+		got, unmapped := m.Normalize(tc.genre)
+		if got != tc.want || !reflect.DeepEqual(unmapped, tc.unmapped) {
+			t.Errorf("Normalize(%q) = %q, %v; want %q, %v", tc.genre, got, unmapped, tc.want, tc.unmapped)
+		}
+	}
+}
+
+// TestUnmappedGenresReport checks that misspelled codes consolidate under
+// the canonical genre and the rest is reported.
+func TestUnmappedGenresReport(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+	repo.SetGenreMapping(storage.NewGenreMapping(map[string]bool{"sf_fantasy": true}, nil))
+
+	books := []inpx.Book{
+		{ID: "1", Title: "A", Genre: "sf_fantasy", Format: "fb2"},
+		{ID: "2", Title: "B", Genre: "sf_fantasy_", Format: "fb2"},
+		{ID: "3", Title: "C", Genre: "made_up", Format: "fb2"},
+		{ID: "4", Title: "D", Genre: "made_up:", Format: "fb2"},
+		{ID: "5", Title: "E", Genre: "other", Format: "fb2"},
+	}
+	unmapped := repo.GenreMapping().Apply(books)
+	if err := repo.ReplaceUnmappedGenres(unmapped); err != nil {
+		t.Fatalf("ReplaceUnmappedGenres: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("InsertBooks: %v", err)
+	}
+
+	list, err := repo.SearchBooks(storage.BookFilter{Genres: []string{"sf_fantasy"}})
+	if err != nil {
+		t.Fatalf("SearchBooks: %v", err)
+	}
+	if list.Total != 2 {
+		t.Errorf("expected both fantasy books under sf_fantasy, got %d", list.Total)
+	}
+
+	report, err := repo.ListUnmappedGenres()
+	if err != nil {
+		t.Fatalf("ListUnmappedGenres: %v", err)
+	}
+	want := []storage.FacetCount{{Value: "made_up", Count: 2}, {Value: "other", Count: 1}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// visibleCondition keeps the books an admin hid, one by one or by archive,
+// out of the catalog
+const visibleCondition = `b.id NOT IN (SELECT book_id FROM book_overrides WHERE hidden_at IS NOT NULL)
+	AND (b.archive_path IS NULL OR b.archive_path NOT IN (SELECT archive_path FROM hidden_archives))`
+
+// HideBook hides a book from the catalog. Like other overrides this
+// survives reindexing.
+func (r *Repository) HideBook(id string) error {
+	var exists int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM books WHERE id = ?", id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check book: %w", err)
+	}
+	if exists == 0 {
+		return ErrBookNotFound
+	}
+
+	if _, err := r.db.db.Exec(
+		`INSERT INTO book_overrides (book_id, hidden_at) VALUES (?, ?)
+		 ON CONFLICT(book_id) DO UPDATE SET hidden_at = COALESCE(book_overrides.hidden_at, excluded.hidden_at)`,
+		id, time.Now(),
+	); err != nil {
+		return fmt.Errorf("failed to hide book: %w", err)
+	}
+	return nil
+}
+
+// UnhideBook returns a hidden book to the catalog; it reports false if the
+// book was not hidden.
+func (r *Repository) UnhideBook(id string) (bool, error) {
+	result, err := r.db.db.Exec("UPDATE book_overrides SET hidden_at = NULL WHERE book_id = ? AND hidden_at IS NOT NULL", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to unhide book: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// HideArchive hides the books of an archive, including books added to it
+// later, and returns how many books it holds now.
+func (r *Repository) HideArchive(archivePath string) (int, error) {
+	if _, err := r.db.db.Exec("INSERT OR IGNORE INTO hidden_archives (archive_path, hidden_at) VALUES (?, ?)", archivePath, time.Now()); err != nil {
+		return 0, fmt.Errorf("failed to hide archive: %w", err)
+	}
+
+	var books int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM books WHERE archive_path = ?", archivePath).Scan(&books); err != nil {
+		return 0, fmt.Errorf("failed to count archive books: %w", err)
+	}
+	return books, nil
+}
+
+// UnhideArchive returns the books of a hidden archive to the catalog; it
+// reports false if the archive was not hidden.
+func (r *Repository) UnhideArchive(archivePath string) (bool, error) {
+	result, err := r.db.db.Exec("DELETE FROM hidden_archives WHERE archive_path = ?", archivePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to unhide archive: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ListHiddenBooks returns the books hidden one by one, most recently hidden
+// first. Books missing from the current import have no title.
+func (r *Repository) ListHiddenBooks() ([]HiddenBook, error) {
+	rows, err := r.db.db.Query(
+		`SELECT o.book_id, COALESCE(b.title, ''), o.hidden_at
+		 FROM book_overrides o
+		 LEFT JOIN books b ON b.id = o.book_id
+		 WHERE o.hidden_at IS NOT NULL
+		 ORDER BY o.hidden_at DESC, o.book_id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hidden books: %w", err)
+	}
+	defer rows.Close()
+
+	books := []HiddenBook{}
+	for rows.Next() {
+		var book HiddenBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.HiddenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan hidden book: %w", err)
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hidden books: %w", err)
+	}
+	return books, nil
+}
+
+// ListHiddenArchives returns the hidden archives with the number of books
+// each holds, most recently hidden first.
+func (r *Repository) ListHiddenArchives() ([]HiddenArchive, error) {
+	rows, err := r.db.db.Query(
+		`SELECT h.archive_path, (SELECT COUNT(*) FROM books b WHERE b.archive_path = h.archive_path), h.hidden_at
+		 FROM hidden_archives h
+		 ORDER BY h.hidden_at DESC, h.archive_path`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hidden archives: %w", err)
+	}
+	defer rows.Close()
+
+	archives := []HiddenArchive{}
+	for rows.Next() {
+		var archive HiddenArchive
+		if err := rows.Scan(&archive.ArchivePath, &archive.Books, &archive.HiddenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan hidden archive: %w", err)
+		}
+		archives = append(archives, archive)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hidden archives: %w", err)
+	}
+	return archives, nil
+}
+
+// IsBookHidden reports whether the book or its archive is hidden
+func (r *Repository) IsBookHidden(id string) (bool, error) {
+	var hidden bool
+	err := r.db.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM book_overrides WHERE book_id = ? AND hidden_at IS NOT NULL)
+		     OR EXISTS(SELECT 1 FROM books b JOIN hidden_archives h ON h.archive_path = b.archive_path WHERE b.id = ?)`,
+		id, id,
+	).Scan(&hidden)
+	if err != nil {
+		return false, fmt.Errorf("failed to check hidden book: %w", err)
+	}
+	return hidden, nil
+}
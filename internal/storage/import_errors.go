@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// ReplaceImportErrors replaces the stored import error log with errs
+func (r *Repository) ReplaceImportErrors(errs []inpx.LineError) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM import_errors"); err != nil {
+		return fmt.Errorf("failed to clear import errors: %w", err)
+	}
+
+	if len(errs) > 0 {
+		stmt, err := tx.Prepare("INSERT INTO import_errors (file, line, reason, content) VALUES (?, ?, ?, ?)")
+		if err != nil {
+			return fmt.Errorf("failed to prepare import error insert: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, e := range errs {
+			if _, err := stmt.Exec(e.File, e.Line, e.Reason, e.Content); err != nil {
+				return fmt.Errorf("failed to insert import error: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import errors: %w", err)
+	}
+	return nil
+}
+
+// ListImportErrors returns a paginated list of errors from the last import
+func (r *Repository) ListImportErrors(limit, offset int) ([]ImportError, int, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT id, file, line, reason, content, created_at
+		 FROM import_errors ORDER BY file, line LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query import errors: %w", err)
+	}
+	defer rows.Close()
+
+	var errs []ImportError
+	for rows.Next() {
+		var e ImportError
+		var content sql.NullString
+		if err := rows.Scan(&e.ID, &e.File, &e.Line, &e.Reason, &content, &e.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan import error: %w", err)
+		}
+		e.Content = content.String
+		errs = append(errs, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating import errors: %w", err)
+	}
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM import_errors").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count import errors: %w", err)
+	}
+
+	return errs, total, nil
+}
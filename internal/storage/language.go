@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/piligrim/pushkinlib/internal/langdetect"
+)
+
+// DefaultLanguageBoost is the factor relevance ordering multiplies the
+// rank of books in the script of the query by.
+const DefaultLanguageBoost = 1.5
+
+// SetLanguageDetection enables guessing the language of imported books
+// whose catalog language is missing or contradicts their annotation, see
+// langdetect.Detect.
+func (r *Repository) SetLanguageDetection(enabled bool) {
+	r.languageDetection.Store(enabled)
+}
+
+// LanguageDetectionEnabled reports whether imports detect languages.
+func (r *Repository) LanguageDetectionEnabled() bool {
+	return r.languageDetection.Load()
+}
+
+// SetLanguageBoost changes the factor relevance ordering favors books
+// written in the script of the query by, so that a Cyrillic query ranks
+// Russian books above English ones matching as well; 1 or less disables
+// it. Cached search results ranked with the old factor are dropped.
+func (r *Repository) SetLanguageBoost(boost float64) {
+	r.languageBoost.Store(&boost)
+	r.InvalidateQueryCache()
+}
+
+// LanguageBoost returns the factor of books in the script of the query.
+func (r *Repository) LanguageBoost() float64 {
+	if boost := r.languageBoost.Load(); boost != nil {
+		return *boost
+	}
+	return DefaultLanguageBoost
+}
+
+// languageRank scales rank, a bm25 score where lower is better, by the
+// language boost for books in the script of the query. Searches limited to
+// languages, and queries of mixed or unknown script, keep rank as is.
+func (r *Repository) languageRank(rank string, filter BookFilter) string {
+	boost := r.LanguageBoost()
+	if boost <= 1 || len(filter.Languages) > 0 {
+		return rank
+	}
+	languages := langdetect.Languages(langdetect.TextScript(filter.Query))
+	if len(languages) == 0 {
+		return rank
+	}
+	return fmt.Sprintf("(%s * CASE WHEN b.language IN ('%s') THEN %s ELSE 1 END)",
+		rank, strings.Join(languages, "', '"), formatWeight(boost))
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrDatabaseLocked is returned by NewExclusiveDatabase when another process
+// has the database open for writing
+var ErrDatabaseLocked = errors.New("database is in use by another process")
+
+// databaseLock is an advisory lock on the .lock file next to a database.
+// The operating system releases it when the process exits, so a crashed
+// server leaves no stale lock behind.
+type databaseLock struct {
+	file *os.File
+}
+
+// lockDatabase takes the lock of the database at dbPath and records the
+// ID of the current process in the lock file
+func lockDatabase(dbPath string) (*databaseLock, error) {
+	if err := ensureDir(filepath.Dir(dbPath)); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	path := dbPath + ".lock"
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	locked, err := tryLockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if !locked {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if pid := strings.TrimSpace(string(holder)); pid != "" {
+			return nil, fmt.Errorf("%w (pid %s holds %s); stop it or start this instance with READ_ONLY=true",
+				ErrDatabaseLocked, pid, path)
+		}
+		return nil, fmt.Errorf("%w (%s is locked); stop it or start this instance with READ_ONLY=true",
+			ErrDatabaseLocked, path)
+	}
+
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &databaseLock{file: file}, nil
+}
+
+// release gives up the lock. The lock file stays; its content is stale
+// until the next process takes the lock.
+func (l *databaseLock) release() error {
+	if err := unlockFile(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// NewExclusiveDatabase opens the database like NewDatabase, after taking an
+// advisory lock that keeps a second writing process, such as another
+// server, from opening it: two importing instances would wreck each other's
+// data. It fails with ErrDatabaseLocked while another process holds the
+// lock. Close releases it. Read-only instances (NewReadOnlyDatabase) and
+// the sync command, whose transactions SQLite serializes, do not take it.
+func NewExclusiveDatabase(dbPath string) (*Database, error) {
+	lock, err := lockDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := NewDatabase(dbPath)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	database.lock = lock
+	return database, nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// tryLockFile always succeeds where flock is not available; instances are
+// not kept from sharing a database there
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile releases the lock of tryLockFile
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package storage_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestNewExclusiveDatabase(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("databases are not locked without flock")
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewExclusiveDatabase(path)
+	if err != nil {
+		t.Fatalf("NewExclusiveDatabase failed: %v", err)
+	}
+	if pid, _ := os.ReadFile(path + ".lock"); strings.TrimSpace(string(pid)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want the current PID", pid)
+	}
+
+	_, err = storage.NewExclusiveDatabase(path)
+	if !errors.Is(err, storage.ErrDatabaseLocked) || !strings.Contains(err.Error(), "READ_ONLY=true") {
+		t.Errorf("second NewExclusiveDatabase = %v, want ErrDatabaseLocked with a hint", err)
+	}
+
+	readOnly, err := storage.NewReadOnlyDatabase(path)
+	if err != nil {
+		t.Errorf("NewReadOnlyDatabase of a locked database failed: %v", err)
+	} else {
+		readOnly.Close()
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	db, err = storage.NewExclusiveDatabase(path)
+	if err != nil {
+		t.Fatalf("NewExclusiveDatabase after Close failed: %v", err)
+	}
+	db.Close()
+}
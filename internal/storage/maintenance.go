@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// MaintenanceResult reports what a maintenance run did and how much space it reclaimed
+type MaintenanceResult struct {
+	DBSizeBefore  int64 `json:"db_size_before"`
+	DBSizeAfter   int64 `json:"db_size_after"`
+	WALSizeBefore int64 `json:"wal_size_before"`
+	WALSizeAfter  int64 `json:"wal_size_after"`
+	Reclaimed     int64 `json:"reclaimed_bytes"`
+
+	// Values returned by PRAGMA wal_checkpoint: busy flag, WAL frames, frames checkpointed
+	CheckpointBusy   int `json:"checkpoint_busy"`
+	CheckpointLog    int `json:"checkpoint_log_frames"`
+	CheckpointFrames int `json:"checkpoint_frames"`
+
+	Vacuumed   bool  `json:"vacuumed"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// RunMaintenance checkpoints and truncates the WAL, runs PRAGMA optimize and,
+// when vacuum is set, rebuilds the database file with VACUUM.
+func (r *Repository) RunMaintenance(vacuum bool) (*MaintenanceResult, error) {
+	start := time.Now()
+	result := &MaintenanceResult{
+		DBSizeBefore:  fileSize(r.db.path),
+		WALSizeBefore: fileSize(r.db.path + "-wal"),
+	}
+
+	if err := r.db.db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(
+		&result.CheckpointBusy, &result.CheckpointLog, &result.CheckpointFrames,
+	); err != nil {
+		return nil, fmt.Errorf("failed to checkpoint WAL: %w", err)
+	}
+
+	if _, err := r.db.db.Exec("PRAGMA optimize"); err != nil {
+		return nil, fmt.Errorf("failed to optimize database: %w", err)
+	}
+
+	if vacuum {
+		if _, err := r.db.db.Exec("VACUUM"); err != nil {
+			return nil, fmt.Errorf("failed to vacuum database: %w", err)
+		}
+		result.Vacuumed = true
+
+		// VACUUM goes through the WAL in WAL mode; truncate it again
+		if _, err := r.db.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return nil, fmt.Errorf("failed to checkpoint WAL after vacuum: %w", err)
+		}
+	}
+
+	result.DBSizeAfter = fileSize(r.db.path)
+	result.WALSizeAfter = fileSize(r.db.path + "-wal")
+	result.Reclaimed = (result.DBSizeBefore + result.WALSizeBefore) - (result.DBSizeAfter + result.WALSizeAfter)
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	return result, nil
+}
+
+// fileSize returns the size of path, or 0 if it does not exist
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -24,26 +24,136 @@ type Book struct {
 	DateAdded   time.Time `json:"date_added" db:"date_added"`
 	Rating      int       `json:"rating,omitempty" db:"rating"`
 	Annotation  string    `json:"annotation,omitempty" db:"annotation"`
+	Tags        []Tag     `json:"tags,omitempty"`
+	HasCover    bool      `json:"has_cover"`
+	CoverHash   string    `json:"cover_hash,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Hidden      bool      `json:"hidden,omitempty"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	// OtherSeries are the series the book belongs to besides Series
+	OtherSeries []BookSeries `json:"other_series,omitempty"`
+	// Genres are all genres of the book; Genre is the first of them
+	Genres []Genre `json:"genres,omitempty"`
 }
 
 // Author represents an author
 type Author struct {
 	ID   int    `json:"id" db:"id"`
 	Name string `json:"name" db:"name"`
+	// SourceID identifies the person in an external catalog, when known;
+	// it tells apart authors that share a name
+	SourceID string `json:"source_id,omitempty" db:"source_id"`
+	// BookCount is the number of visible books; only listings fill it in
+	BookCount int `json:"book_count,omitempty"`
+}
+
+// AuthorAlias links an author record, e.g. a pseudonym, to the canonical
+// author it is another name of
+type AuthorAlias struct {
+	Alias     string    `json:"alias"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Series represents a book series
 type Series struct {
-	ID   int    `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID        int    `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	BookCount int    `json:"book_count,omitempty"`
+}
+
+// BookSeries is a further series of a book with its number in it
+type BookSeries struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Num  int    `json:"series_num,omitempty"`
 }
 
 // Genre represents a book genre
 type Genre struct {
-	ID   int    `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID        int    `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	BookCount int    `json:"book_count,omitempty"`
+}
+
+// AuthorInfo holds an author biography and portrait from an external source
+type AuthorInfo struct {
+	AuthorName string    `json:"-" db:"author_name"`
+	Found      bool      `json:"-" db:"found"`
+	Bio        string    `json:"bio,omitempty" db:"bio"`
+	PhotoURL   string    `json:"photo_url,omitempty" db:"photo_url"`
+	SourceURL  string    `json:"source_url,omitempty" db:"source_url"`
+	WikidataID string    `json:"wikidata_id,omitempty" db:"wikidata_id"`
+	FetchedAt  time.Time `json:"fetched_at" db:"fetched_at"`
+}
+
+// UpstreamSource is an external OPDS catalog aggregated into this one
+type UpstreamSource struct {
+	ID        string     `json:"id" db:"id"`
+	Title     string     `json:"title" db:"title"`
+	URL       string     `json:"url" db:"url"`
+	Entries   int        `json:"entries" db:"entries"`
+	CrawledAt *time.Time `json:"crawled_at,omitempty" db:"crawled_at"`
+	LastError string     `json:"last_error,omitempty" db:"last_error"`
+}
+
+// UpstreamEntry is a book entry crawled from an external OPDS catalog
+type UpstreamEntry struct {
+	SourceID string         `json:"source_id" db:"source_id"`
+	Key      string         `json:"key" db:"key"`
+	EntryID  string         `json:"entry_id" db:"entry_id"`
+	Title    string         `json:"title" db:"title"`
+	Authors  []string       `json:"authors,omitempty" db:"authors"`
+	Summary  string         `json:"summary,omitempty" db:"summary"`
+	Language string         `json:"language,omitempty" db:"language"`
+	Updated  time.Time      `json:"updated" db:"updated"`
+	Links    []UpstreamLink `json:"links" db:"links"`
+}
+
+// UpstreamLink is a link of an upstream entry with its href made absolute
+type UpstreamLink struct {
+	Rel   string `json:"rel"`
+	Type  string `json:"type"`
+	Href  string `json:"href"`
+	Title string `json:"title,omitempty"`
+}
+
+// ImportError represents an INP line skipped during the last import
+type ImportError struct {
+	ID        int       `json:"id" db:"id"`
+	File      string    `json:"file" db:"file"`
+	Line      int       `json:"line" db:"line"`
+	Reason    string    `json:"reason" db:"reason"`
+	Content   string    `json:"content,omitempty" db:"content"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// BookChecksum is the SHA-256 of a book file as extracted from its archive
+type BookChecksum struct {
+	BookID     string    `json:"book_id" db:"book_id"`
+	SHA256     string    `json:"sha256" db:"sha256"`
+	Size       int64     `json:"size" db:"size"`
+	ComputedAt time.Time `json:"computed_at" db:"computed_at"`
+}
+
+// Tag represents a librarian-defined tag
+type Tag struct {
+	ID        int    `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	BookCount int    `json:"book_count"`
+}
+
+// BookUpdate represents a manual correction of book metadata.
+// Nil fields are left unchanged. An empty Series removes the book from its series.
+// Genre may list several codes separated by colons, as in INPX.
+type BookUpdate struct {
+	Title      *string `json:"title,omitempty"`
+	Annotation *string `json:"annotation,omitempty"`
+	Genre      *string `json:"genre,omitempty"`
+	Series     *string `json:"series,omitempty"`
+	SeriesNum  *int    `json:"series_num,omitempty"`
+	Rating     *int    `json:"rating,omitempty"`
 }
 
 // BookFilter represents search and filter parameters
@@ -54,12 +164,31 @@ type BookFilter struct {
 	Genres    []string `json:"genres,omitempty"`
 	Languages []string `json:"languages,omitempty"`
 	Formats   []string `json:"formats,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
 	YearFrom  int      `json:"year_from,omitempty"`
 	YearTo    int      `json:"year_to,omitempty"`
+	Featured  bool     `json:"featured,omitempty"` // only books picked by admins
+	Shelf     string   `json:"shelf,omitempty"`    // only books of this shelf
 	Limit     int      `json:"limit,omitempty"`
 	Offset    int      `json:"offset,omitempty"`
-	SortBy    string   `json:"sort_by,omitempty"`    // title, year, date_added, relevance
+	SortBy    string   `json:"sort_by,omitempty"`    // see SortFields
 	SortOrder string   `json:"sort_order,omitempty"` // asc, desc
+	// SeriesNumFrom and SeriesNumTo bound the number of a book in its
+	// series, and FirstInSeries keeps the books numbered 1; books outside
+	// series never match them
+	SeriesNumFrom int  `json:"series_num_from,omitempty"`
+	SeriesNumTo   int  `json:"series_num_to,omitempty"`
+	FirstInSeries bool `json:"first_in_series,omitempty"`
+	// WithoutSeries keeps the books outside any series, main or further
+	WithoutSeries bool `json:"without_series,omitempty"`
+	// ChangedAfter keeps the books added or changed after this time, as
+	// recorded across reindexes in books.updated_at
+	ChangedAfter *time.Time `json:"changed_after,omitempty"`
+	// WithAnnotations loads the annotations of the books found, which
+	// are left empty otherwise
+	WithAnnotations bool `json:"with_annotations,omitempty"`
+	// Hidden excludes books the viewer may not see; see RestrictionsFor
+	Hidden *Restrictions `json:"-"`
 }
 
 // BookList represents paginated book results
@@ -69,6 +198,51 @@ type BookList struct {
 	Limit   int    `json:"limit"`
 	Offset  int    `json:"offset"`
 	HasMore bool   `json:"has_more"`
+	// Suggestions are corrected queries offered when nothing was found
+	Suggestions []string `json:"suggestions,omitempty"`
+	// Warnings name parts of the query that were not understood as written,
+	// such as unknown field prefixes
+	Warnings []string `json:"warnings,omitempty"`
+	// Fallback is set when the query found nothing and the books are those
+	// of FallbackQuery, a relaxed query found by FallbackStrategy
+	Fallback         bool   `json:"fallback,omitempty"`
+	FallbackQuery    string `json:"fallback_query,omitempty"`
+	FallbackStrategy string `json:"fallback_strategy,omitempty"`
+}
+
+// BookDetails is a book with what its detail page shows next to it
+type BookDetails struct {
+	Book *Book `json:"book"`
+	// Previous and Next are the books before and after it in its series by
+	// number; SeriesTotal is the number of books in the series
+	Previous    *Book `json:"previous,omitempty"`
+	Next        *Book `json:"next,omitempty"`
+	SeriesTotal int   `json:"series_total,omitempty"`
+	// AuthorBooks are other books of its authors, best rated first
+	AuthorBooks []Book `json:"author_books"`
+	// Genres are the genres of the book with their numbers of books
+	Genres []Genre `json:"genres"`
+}
+
+// FacetCount is the number of matching books for one facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// BookFacets holds the numbers of books matching a search per facet value.
+// Years are grouped by decade: Year is the first year of the decade.
+type BookFacets struct {
+	Genres    []FacetCount `json:"genres"`
+	Languages []FacetCount `json:"languages"`
+	Formats   []FacetCount `json:"formats"`
+	Years     []YearCount  `json:"years"`
+}
+
+// YearCount is the number of books published in one year
+type YearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
 }
 
 // ReadingPosition represents a saved reading position
@@ -135,7 +309,9 @@ type User struct {
 	Username     string    `json:"username" db:"username"`
 	PasswordHash string    `json:"-" db:"password_hash"`
 	DisplayName  string    `json:"display_name" db:"display_name"`
+	Email        string    `json:"email,omitempty" db:"email"`
 	IsAdmin      bool      `json:"is_admin" db:"is_admin"`
+	Roles        []string  `json:"roles,omitempty"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -147,3 +323,125 @@ type Session struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
 }
+
+// APIToken is a token that lets a script act as its user within its scopes.
+// The token itself is shown only once, when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// SavedSearch is a book filter a user saved under a name
+type SavedSearch struct {
+	ID     string     `json:"id"`
+	UserID string     `json:"-"`
+	Name   string     `json:"name"`
+	Filter BookFilter `json:"filter"`
+	// Notify asks for the books added or changed since the search was last
+	// run to be counted in NewBooks
+	Notify   bool `json:"notify"`
+	NewBooks int  `json:"new_books"`
+	// Email receives the new books of a search with Notify set, when mail
+	// is configured
+	Email      string     `json:"email,omitempty"`
+	SeenAt     time.Time  `json:"seen_at"`
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Shelf is a user's reading list
+type Shelf struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"-" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	BookCount int       `json:"book_count"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Access rule kinds
+const (
+	AccessKindGenre = "genre"
+	AccessKindTag   = "tag"
+)
+
+// AccessRule restricts the books of a genre or tag. Without a role any
+// signed-in user may see them; with a role only users who have it. Admins
+// see everything.
+type AccessRule struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HiddenBook is a book an admin hid from the catalog
+type HiddenBook struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title,omitempty"`
+	HiddenAt time.Time `json:"hidden_at"`
+}
+
+// HiddenArchive is an archive whose books an admin hid from the catalog
+type HiddenArchive struct {
+	ArchivePath string    `json:"archive_path"`
+	Books       int       `json:"books"`
+	HiddenAt    time.Time `json:"hidden_at"`
+}
+
+// Archive is an archive named by the books of the catalog
+type Archive struct {
+	ArchivePath string `json:"archive_path"`
+	Books       int    `json:"books"`
+	Hidden      bool   `json:"hidden"`
+}
+
+// ArchiveBook is a book as its archive is expected to hold it
+type ArchiveBook struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Format  string `json:"format"`
+	FileNum string `json:"file_num,omitempty"`
+	// FileSize is the size of the book file as the INPX gives it
+	FileSize int64 `json:"file_size"`
+}
+
+// Restrictions are the genres and tags whose books a viewer may not see
+type Restrictions struct {
+	Genres []string
+	Tags   []string
+	// Anonymous is set when signing in could lift the restrictions
+	Anonymous bool
+}
+
+// DownloadRecord is an entry of the download audit log
+type DownloadRecord struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	BookID    string    `json:"book_id"`
+	Title     string    `json:"title"`
+	UserID    string    `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Bytes     int64     `json:"bytes"`
+	// Complete is false when the client went away before the whole file was sent
+	Complete bool `json:"complete"`
+}
+
+// DownloadFilter selects download log entries; zero fields match anything
+type DownloadFilter struct {
+	BookID   string
+	Username string
+	IP       string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
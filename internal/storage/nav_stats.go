@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Kinds of navigation counts kept in nav_stats. Totals are keyed by
+// language, "" counting all of them; letter buckets by the upper-cased
+// first letter of the name; top authors by language and author ID, see
+// topAuthorKey.
+const (
+	navAuthors       = "authors"
+	navSeries        = "series"
+	navGenres        = "genres"
+	navAuthorLetters = "author_letters"
+	navSeriesLetters = "series_letters"
+	navTopAuthors    = "top_authors"
+)
+
+// LetterCount is the number of authors or series whose names start with a
+// letter
+type LetterCount struct {
+	Letter string `json:"letter"`
+	Count  int    `json:"count"`
+}
+
+// NavigationStats are the precomputed sizes of the navigation lists
+type NavigationStats struct {
+	Authors       int           `json:"authors"`
+	Series        int           `json:"series"`
+	Genres        int           `json:"genres"`
+	AuthorLetters []LetterCount `json:"author_letters"`
+	SeriesLetters []LetterCount `json:"series_letters"`
+}
+
+// navStatsQueries count the authors, series and genres with books in each
+// language, the same sets the language-scoped lists page through
+var navStatsQueries = []struct {
+	kind, total, byLanguage string
+}{
+	{navAuthors, "SELECT COUNT(*) FROM authors", `
+		SELECT b.language, COUNT(DISTINCT ba.author_id)
+		FROM book_authors ba JOIN books b ON b.id = ba.book_id
+		GROUP BY b.language`},
+	{navSeries, "SELECT COUNT(*) FROM series", `
+		SELECT language, COUNT(DISTINCT series_id) FROM (
+			SELECT language, series_id FROM books WHERE series_id IS NOT NULL
+			UNION
+			SELECT b.language, bs.series_id FROM book_series bs JOIN books b ON b.id = bs.book_id
+		) GROUP BY language`},
+	{navGenres, "SELECT COUNT(*) FROM genres", `
+		SELECT b.language, COUNT(DISTINCT bg.genre_id)
+		FROM book_genres bg JOIN books b ON b.id = bg.book_id
+		GROUP BY b.language`},
+}
+
+// RefreshNavigationStats recounts the authors, series and genres, in all
+// and in each language, the authors and series by first letter, and ranks
+// the authors with the most books (see ListTopAuthors). The
+// lists read their totals from these counts instead of counting large
+// tables on the first request after an import. It returns the number of
+// counts stored.
+func (r *Repository) RefreshNavigationStats() (int, error) {
+	type stat struct {
+		kind, key string
+		count     int
+	}
+	var stats []stat
+
+	for _, q := range navStatsQueries {
+		var total int
+		if err := r.db.db.QueryRow(q.total).Scan(&total); err != nil {
+			return 0, fmt.Errorf("failed to count %s: %w", q.kind, err)
+		}
+		stats = append(stats, stat{q.kind, "", total})
+
+		counts, err := r.groupCounts(q.byLanguage)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count %s by language: %w", q.kind, err)
+		}
+		for language, count := range counts {
+			if language != "" {
+				stats = append(stats, stat{q.kind, language, count})
+			}
+		}
+	}
+
+	for kind, table := range map[string]string{navAuthorLetters: "authors", navSeriesLetters: "series"} {
+		counts, err := r.groupCounts("SELECT SUBSTR(name, 1, 1), COUNT(*) FROM " + table + " GROUP BY 1")
+		if err != nil {
+			return 0, fmt.Errorf("failed to count %s by letter: %w", table, err)
+		}
+		// SQLite only folds ASCII, so letters are merged after folding
+		letters := make(map[string]int)
+		for prefix, count := range counts {
+			letters[nameLetter(prefix)] += count
+		}
+		for letter, count := range letters {
+			stats = append(stats, stat{kind, letter, count})
+		}
+	}
+
+	top, err := r.rankTopAuthors()
+	if err != nil {
+		return 0, fmt.Errorf("failed to rank authors: %w", err)
+	}
+	for key, books := range top {
+		stats = append(stats, stat{navTopAuthors, key, books})
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM nav_stats"); err != nil {
+		return 0, fmt.Errorf("failed to clear navigation counts: %w", err)
+	}
+	rows := newMultiRowInsert(tx, "INSERT INTO nav_stats (kind, key, count) VALUES ", 3)
+	defer rows.close()
+	for _, s := range stats {
+		rows.add(s.kind, s.key, s.count)
+	}
+	if err := rows.flush(); err != nil {
+		return 0, fmt.Errorf("failed to insert navigation counts: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit navigation counts: %w", err)
+	}
+	return len(stats), nil
+}
+
+// groupCounts runs a query of keys and counts
+func (r *Repository) groupCounts(query string) (map[string]int, error) {
+	rows, err := r.db.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var key sql.NullString
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		counts[key.String] += count
+	}
+	return counts, rows.Err()
+}
+
+// nameLetter is the letter a name is filed under: its first letter upper
+// cased, or "#" for names starting with a digit or punctuation
+func nameLetter(prefix string) string {
+	for _, r := range prefix {
+		if unicode.IsLetter(r) {
+			return strings.ToUpper(yoFolder.Replace(string(r)))
+		}
+	}
+	return "#"
+}
+
+// InvalidateNavigationStats drops the precomputed counts after the catalog
+// was edited, so that the lists count again until the next import
+func (r *Repository) InvalidateNavigationStats() error {
+	if _, err := r.db.db.Exec("DELETE FROM nav_stats"); err != nil {
+		return fmt.Errorf("failed to clear navigation counts: %w", err)
+	}
+	return nil
+}
+
+// navigationCount returns the precomputed count of kind in language, and
+// false if the counts were not computed since the last change
+func (r *Repository) navigationCount(kind, language string) (int, bool) {
+	rows, err := r.db.db.Query("SELECT key, count FROM nav_stats WHERE kind = ? AND key IN ('', ?)", kind, language)
+	if err != nil {
+		return 0, false
+	}
+	defer rows.Close()
+
+	// The total over all languages marks the counts as present; languages
+	// without books have no row of their own
+	found, count := false, 0
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return 0, false
+		}
+		if key == "" {
+			found = true
+		}
+		if key == language {
+			count = n
+		}
+	}
+	if rows.Err() != nil {
+		return 0, false
+	}
+	return count, found
+}
+
+// GetNavigationStats returns the precomputed sizes of the author, series
+// and genre lists and the authors and series by first letter, or nil if
+// they were not computed since the last change to the catalog
+func (r *Repository) GetNavigationStats() (*NavigationStats, error) {
+	rows, err := r.db.db.Query("SELECT kind, key, count FROM nav_stats")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query navigation counts: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &NavigationStats{AuthorLetters: []LetterCount{}, SeriesLetters: []LetterCount{}}
+	found := false
+	for rows.Next() {
+		var kind, key string
+		var count int
+		if err := rows.Scan(&kind, &key, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan navigation count: %w", err)
+		}
+		switch {
+		case kind == navAuthors && key == "":
+			stats.Authors, found = count, true
+		case kind == navSeries && key == "":
+			stats.Series = count
+		case kind == navGenres && key == "":
+			stats.Genres = count
+		case kind == navAuthorLetters:
+			stats.AuthorLetters = append(stats.AuthorLetters, LetterCount{key, count})
+		case kind == navSeriesLetters:
+			stats.SeriesLetters = append(stats.SeriesLetters, LetterCount{key, count})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating navigation counts: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	for _, letters := range [][]LetterCount{stats.AuthorLetters, stats.SeriesLetters} {
+		sort.Slice(letters, func(i, j int) bool { return letters[i].Letter < letters[j].Letter })
+	}
+	return stats, nil
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// TestRefreshNavigationStats checks the precomputed totals match the
+// counted ones in all and in each language, that the lists use them until
+// invalidated, and that names are bucketed by their folded first letter.
+func TestRefreshNavigationStats(t *testing.T) {
+	repo := newSearchTestRepo(t) // "Лев Толстой", "Романы", in Russian
+	books := []inpx.Book{
+		{ID: "n-1", Title: "Ёлка", Authors: []string{"Ёлкин Иван"}, Genre: "prose", Language: "ru"},
+		{ID: "n-2", Title: "Emma", Authors: []string{"austen Jane"}, Series: "Novels", Genre: "classic", Language: "en"},
+		{ID: "n-3", Title: "Persuasion", Authors: []string{"Austen Jane"}, Genre: "classic", Language: "en"},
+		{ID: "n-4", Title: "1984", Authors: []string{"1984 Collective"}, Language: "en"},
+	}
+	for i := range books {
+		books[i].Format, books[i].Date = "fb2", time.Now()
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	type totals struct{ authors, series, genres int }
+	listTotals := func(language string) totals {
+		t.Helper()
+		_, authors, err := repo.ListAuthorsInLanguage(language, 10, 0)
+		if err != nil {
+			t.Fatalf("ListAuthorsInLanguage failed: %v", err)
+		}
+		_, series, err := repo.ListSeriesInLanguage(language, 10, 0)
+		if err != nil {
+			t.Fatalf("ListSeriesInLanguage failed: %v", err)
+		}
+		_, genres, err := repo.ListGenresInLanguage(language, 10, 0, nil)
+		if err != nil {
+			t.Fatalf("ListGenresInLanguage failed: %v", err)
+		}
+		return totals{authors, series, genres}
+	}
+	languages := []string{"", "ru", "en", "de"}
+	counted := make(map[string]totals)
+	for _, language := range languages {
+		counted[language] = listTotals(language)
+	}
+
+	if stats, err := repo.GetNavigationStats(); err != nil || stats != nil {
+		t.Fatalf("expected no counts before the refresh, got %+v, %v", stats, err)
+	}
+	if _, err := repo.RefreshNavigationStats(); err != nil {
+		t.Fatalf("RefreshNavigationStats failed: %v", err)
+	}
+	for _, language := range languages {
+		if got := listTotals(language); got != counted[language] {
+			t.Errorf("language %q: expected totals %+v, got %+v", language, counted[language], got)
+		}
+	}
+
+	// Totals come from the stored counts, not the tables
+	if _, err := repo.db.db.Exec("INSERT INTO authors (name) VALUES ('Незнакомец')"); err != nil {
+		t.Fatalf("failed to insert author: %v", err)
+	}
+	if got := listTotals(""); got != counted[""] {
+		t.Errorf("expected stored totals %+v, got %+v", counted[""], got)
+	}
+
+	stats, err := repo.GetNavigationStats()
+	if err != nil || stats == nil {
+		t.Fatalf("GetNavigationStats failed: %+v, %v", stats, err)
+	}
+	want := map[string]int{"#": 1, "A": 2, "Ё": 0, "Е": 1, "Л": 1}
+	got := make(map[string]int)
+	for _, lc := range stats.AuthorLetters {
+		got[lc.Letter] = lc.Count
+	}
+	for letter, count := range want {
+		if got[letter] != count {
+			t.Errorf("letter %q: expected %d authors, got %d (%v)", letter, count, got[letter], stats.AuthorLetters)
+		}
+	}
+	if stats.Authors != counted[""].authors || stats.Series != counted[""].series || stats.Genres != counted[""].genres {
+		t.Errorf("unexpected totals %+v, counted %+v", stats, counted[""])
+	}
+
+	if err := repo.InvalidateNavigationStats(); err != nil {
+		t.Fatalf("InvalidateNavigationStats failed: %v", err)
+	}
+	if got := listTotals(""); got.authors != counted[""].authors+1 {
+		t.Errorf("expected the authors to be counted again, got %+v", got)
+	}
+}
+
+// TestListTopAuthors checks authors are listed by their number of books,
+// in all and in one language, the same whether counted or precomputed.
+func TestListTopAuthors(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "top.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	books := []inpx.Book{
+		{ID: "t-1", Title: "Один", Authors: []string{"Плодовитый Автор"}, Language: "ru"},
+		{ID: "t-2", Title: "Два", Authors: []string{"Плодовитый Автор"}, Language: "ru"},
+		{ID: "t-3", Title: "Three", Authors: []string{"Плодовитый Автор"}, Language: "en"},
+		{ID: "t-4", Title: "Four", Authors: []string{"English Writer"}, Language: "en"},
+		{ID: "t-5", Title: "Five", Authors: []string{"English Writer"}, Language: "en"},
+		{ID: "t-6", Title: "Шесть", Authors: []string{"Редкий Автор"}, Language: "ru"},
+	}
+	for i := range books {
+		books[i].Format, books[i].Date = "fb2", time.Now()
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	list := func(language string, limit, offset int) (string, int) {
+		t.Helper()
+		authors, total, err := repo.ListTopAuthors(language, limit, offset)
+		if err != nil {
+			t.Fatalf("ListTopAuthors failed: %v", err)
+		}
+		var names []string
+		for _, a := range authors {
+			names = append(names, fmt.Sprintf("%s=%d", a.Name, a.BookCount))
+		}
+		return strings.Join(names, ", "), total
+	}
+	want := map[string]string{
+		"":   "Плодовитый Автор=3, English Writer=2, Редкий Автор=1",
+		"ru": "Плодовитый Автор=2, Редкий Автор=1",
+		"en": "English Writer=2, Плодовитый Автор=1",
+		"de": "",
+	}
+	check := func(stage string) {
+		t.Helper()
+		for language, names := range want {
+			if got, total := list(language, 10, 0); got != names || total != strings.Count(names, "=") {
+				t.Errorf("%s, language %q: got %q (%d), want %q", stage, language, got, total, names)
+			}
+		}
+		if got, total := list("", 1, 1); got != "English Writer=2" || total != 3 {
+			t.Errorf("%s: unexpected second page %q (%d)", stage, got, total)
+		}
+	}
+
+	check("counted")
+	if _, err := repo.RefreshNavigationStats(); err != nil {
+		t.Fatalf("RefreshNavigationStats failed: %v", err)
+	}
+	repo.InvalidateQueryCache()
+	check("precomputed")
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrBookNotFound is returned when an operation targets a book that does not exist.
+var ErrBookNotFound = errors.New("book not found")
+
+// UpdateBook records a metadata override for a book and applies it immediately.
+// The override is stored separately so it can be re-applied after a reindex.
+func (r *Repository) UpdateBook(id string, upd BookUpdate) (*Book, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM books WHERE id = ?", id).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check book: %w", err)
+	}
+	if exists == 0 {
+		return nil, ErrBookNotFound
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(
+		`INSERT INTO book_overrides (book_id, title, annotation, genre, series, series_num, rating, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(book_id) DO UPDATE SET
+		   title = COALESCE(excluded.title, book_overrides.title),
+		   annotation = COALESCE(excluded.annotation, book_overrides.annotation),
+		   genre = COALESCE(excluded.genre, book_overrides.genre),
+		   series = COALESCE(excluded.series, book_overrides.series),
+		   series_num = COALESCE(excluded.series_num, book_overrides.series_num),
+		   rating = COALESCE(excluded.rating, book_overrides.rating),
+		   updated_at = excluded.updated_at`,
+		id, upd.Title, upd.Annotation, upd.Genre, upd.Series, upd.SeriesNum, upd.Rating, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save book override: %w", err)
+	}
+
+	if err := r.applyBookUpdateTx(tx, id, upd, now); err != nil {
+		return nil, fmt.Errorf("failed to apply book override: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit book override: %w", err)
+	}
+
+	return r.GetBookByID(id)
+}
+
+// ApplyBookOverrides re-applies all stored overrides to books that exist.
+// Returns the number of books updated. Called after INPX import.
+func (r *Repository) ApplyBookOverrides() (int, error) {
+	rows, err := r.db.db.Query(
+		`SELECT o.book_id, o.title, o.annotation, o.genre, o.series, o.series_num, o.rating, o.updated_at
+		 FROM book_overrides o
+		 JOIN books b ON b.id = o.book_id
+		 WHERE COALESCE(o.title, o.annotation, o.genre, o.series, o.series_num, o.rating) IS NOT NULL`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query book overrides: %w", err)
+	}
+
+	type override struct {
+		bookID    string
+		upd       BookUpdate
+		updatedAt time.Time
+	}
+
+	var overrides []override
+	for rows.Next() {
+		var ov override
+		var title, annotation, genre, series sql.NullString
+		var seriesNum, rating sql.NullInt64
+		if err := rows.Scan(&ov.bookID, &title, &annotation, &genre, &series, &seriesNum, &rating, &ov.updatedAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan book override: %w", err)
+		}
+		ov.upd = BookUpdate{
+			Title:      nullStringPtr(title),
+			Annotation: nullStringPtr(annotation),
+			Genre:      nullStringPtr(genre),
+			Series:     nullStringPtr(series),
+			SeriesNum:  nullIntPtr(seriesNum),
+			Rating:     nullIntPtr(rating),
+		}
+		overrides = append(overrides, ov)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating book overrides: %w", err)
+	}
+	rows.Close()
+
+	if len(overrides) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, ov := range overrides {
+		if err := r.applyBookUpdateTx(tx, ov.bookID, ov.upd, ov.updatedAt); err != nil {
+			return 0, fmt.Errorf("failed to apply override for book %s: %w", ov.bookID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit book overrides: %w", err)
+	}
+
+	return len(overrides), nil
+}
+
+// applyBookUpdateTx writes the non-nil fields of upd to the book row and refreshes its FTS entry.
+// The book counts as updated at the given time unless its data changed later.
+func (r *Repository) applyBookUpdateTx(tx *sql.Tx, bookID string, upd BookUpdate, at time.Time) error {
+	sets := []string{"updated_at = MAX(updated_at, ?)"}
+	args := []interface{}{at}
+
+	if upd.Title != nil {
+		sets = append(sets, "title = ?")
+		args = append(args, *upd.Title)
+	}
+	if upd.Rating != nil {
+		sets = append(sets, "rating = ?")
+		args = append(args, *upd.Rating)
+	}
+	if upd.SeriesNum != nil {
+		sets = append(sets, "series_num = ?")
+		args = append(args, *upd.SeriesNum)
+	}
+	var genreIDs []int
+	if upd.Genre != nil {
+		var err error
+		if genreIDs, err = r.bookGenresTx(tx, *upd.Genre, nil); err != nil {
+			return err
+		}
+		sets = append(sets, "genre_id = ?")
+		args = append(args, mainGenreID(genreIDs))
+	}
+	if upd.Series != nil {
+		var seriesID sql.NullInt64
+		if *upd.Series != "" {
+			id, err := r.getOrCreateSeriesTx(tx, *upd.Series, nil)
+			if err != nil {
+				return err
+			}
+			seriesID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+		sets = append(sets, "series_id = ?")
+		args = append(args, seriesID)
+	}
+
+	args = append(args, bookID)
+	query := "UPDATE books SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 && upd.Annotation != nil {
+		if err := setBookAnnotationTx(tx, bookID, *upd.Annotation); err != nil {
+			return err
+		}
+	}
+	if n, _ := result.RowsAffected(); n > 0 && upd.Genre != nil {
+		if err := setBookGenresTx(tx, bookID, genreIDs); err != nil {
+			return err
+		}
+	}
+
+	return refreshBookFTSTx(tx, bookID)
+}
+
+// refreshBookFTSTx rebuilds the full-text entry of a single book from the current row
+func refreshBookFTSTx(tx *sql.Tx, bookID string) error {
+	if err := deleteBooksFTSTx(tx, []interface{}{bookID}); err != nil {
+		return err
+	}
+
+	return indexBooksTx(tx, " WHERE b.id = ?", bookID)
+}
+
+func nullStringPtr(ns sql.NullString) *string {
+	if !ns.Valid {
+		return nil
+	}
+	return &ns.String
+}
+
+func nullIntPtr(ni sql.NullInt64) *int {
+	if !ni.Valid {
+		return nil
+	}
+	v := int(ni.Int64)
+	return &v
+}
@@ -125,3 +125,35 @@ func TestPragmaString_DisallowedName(t *testing.T) {
 		t.Fatal("expected error for disallowed PRAGMA name")
 	}
 }
+
+// TestNewReadOnlyDatabase verifies a read-only database needs an existing
+// schema and rejects writes.
+func TestNewReadOnlyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	if _, err := NewReadOnlyDatabase(dbPath); err == nil {
+		t.Fatal("expected an error for a missing database")
+	}
+
+	writer, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	writer.Close()
+
+	db, err := NewReadOnlyDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("NewReadOnlyDatabase: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	if !repo.ReadOnly() {
+		t.Error("expected ReadOnly to be true")
+	}
+	if _, err := repo.SearchBooks(BookFilter{Limit: 1}); err != nil {
+		t.Errorf("SearchBooks: %v", err)
+	}
+	if _, err := repo.CreateTag("tag"); err == nil {
+		t.Error("expected CreateTag to fail")
+	}
+}
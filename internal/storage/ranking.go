@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RankWeights are the bm25 weights of the searchable books_fts columns. A
+// match in a column weighted 10 counts ten times as much as one in a column
+// weighted 1, so a search for an author's name ranks their books above books
+// that merely mention them in the annotation.
+type RankWeights struct {
+	Title      float64
+	Annotation float64
+	Authors    float64
+	Series     float64
+}
+
+// DefaultRankWeights favor authors and titles over series and annotations.
+var DefaultRankWeights = RankWeights{Title: 10, Annotation: 1, Authors: 20, Series: 5}
+
+// ParseRankWeights parses weights such as "title=10,authors=20"; columns
+// left out keep their default weight.
+func ParseRankWeights(s string) (RankWeights, error) {
+	weights := DefaultRankWeights
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return weights, fmt.Errorf("invalid rank weight %q: want column=weight", part)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid rank weight %q: want a non-negative number", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "title":
+			weights.Title = weight
+		case "annotation":
+			weights.Annotation = weight
+		case "authors":
+			weights.Authors = weight
+		case "series":
+			weights.Series = weight
+		default:
+			return weights, fmt.Errorf("invalid rank weight %q: unknown column %q", part, name)
+		}
+	}
+	return weights, nil
+}
+
+// String formats the weights the way ParseRankWeights reads them
+func (w RankWeights) String() string {
+	return fmt.Sprintf("title=%s,annotation=%s,authors=%s,series=%s",
+		formatWeight(w.Title), formatWeight(w.Annotation), formatWeight(w.Authors), formatWeight(w.Series))
+}
+
+func formatWeight(weight float64) string {
+	return strconv.FormatFloat(weight, 'g', -1, 64)
+}
+
+// SetRankWeights changes the column weights of relevance ordering and drops
+// cached search results ranked with the old ones.
+func (r *Repository) SetRankWeights(w RankWeights) {
+	r.rankWeights.Store(&w)
+	r.InvalidateQueryCache()
+}
+
+// RankWeights returns the column weights of relevance ordering.
+func (r *Repository) RankWeights() RankWeights {
+	if w := r.rankWeights.Load(); w != nil {
+		return *w
+	}
+	return DefaultRankWeights
+}
+
+// rankExpr returns the bm25 call ranking books_fts matches
+func (r *Repository) rankExpr() string {
+	w := r.RankWeights()
+	return fmt.Sprintf("bm25(books_fts, %s, %s, %s, %s)",
+		formatWeight(w.Title), formatWeight(w.Annotation), formatWeight(w.Authors), formatWeight(w.Series))
+}
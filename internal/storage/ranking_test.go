@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestParseRankWeights(t *testing.T) {
+	w, err := ParseRankWeights("")
+	if err != nil || w != DefaultRankWeights {
+		t.Fatalf("ParseRankWeights(\"\") = %+v, %v; want defaults", w, err)
+	}
+
+	w, err = ParseRankWeights(" Authors=50 , annotation=0.5")
+	if err != nil {
+		t.Fatalf("ParseRankWeights failed: %v", err)
+	}
+	want := RankWeights{Title: 10, Annotation: 0.5, Authors: 50, Series: 5}
+	if w != want {
+		t.Errorf("got %+v, want %+v", w, want)
+	}
+	if got := w.String(); got != "title=10,annotation=0.5,authors=50,series=5" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"title", "title=x", "title=-1", "genre=2"} {
+		if _, err := ParseRankWeights(bad); err == nil {
+			t.Errorf("ParseRankWeights(%q) succeeded", bad)
+		}
+	}
+}
+
+// TestSearchBooks_AuthorRanking checks books by the searched author outrank
+// books that only mention them in the annotation.
+func TestSearchBooks_AuthorRanking(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "rank.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// Short annotations that repeat the name score well without weights
+	books := []inpx.Book{
+		{ID: "mention-1", Title: "Лицей", Authors: []string{"Юрий Тынянов"}, Annotation: "Пушкин, Пушкин и снова Пушкин", Format: "fb2", Date: time.Now()},
+		{ID: "mention-2", Title: "Дуэль", Authors: []string{"Вересаев Викентий"}, Annotation: "Пушкин в жизни", Format: "fb2", Date: time.Now()},
+		{ID: "author-1", Title: "Евгений Онегин, роман в стихах с комментариями и приложениями", Authors: []string{"Александр Сергеевич Пушкин"},
+			Annotation: "Роман в стихах о жизни столичного дворянства, любви, дружбе, дуэли и долге", Format: "fb2", Date: time.Now()},
+	}
+	// bm25 gives no weight to a term found in most rows
+	for i := 0; i < 10; i++ {
+		books = append(books, inpx.Book{ID: fmt.Sprintf("other-%d", i), Title: "Повесть", Authors: []string{"Иван Тургенев"}, Format: "fb2", Date: time.Now()})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	first := func() string {
+		t.Helper()
+		result, err := repo.SearchBooks(BookFilter{Query: "Пушкин", Limit: 10})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if result.Total != 3 {
+			t.Fatalf("expected 3 books, got %d", result.Total)
+		}
+		return result.Books[0].ID
+	}
+
+	if got := first(); got != "author-1" {
+		t.Errorf("expected the author's book first, got %s", got)
+	}
+
+	repo.SetRankWeights(RankWeights{Title: 10, Annotation: 1, Authors: 0, Series: 5})
+	if got := first(); !strings.HasPrefix(got, "mention-") {
+		t.Errorf("expected a mention first without the authors weight, got %s", got)
+	}
+}
+
+// TestSearchBooks_LanguageBoost checks relevance ordering favors books in
+// the script of the query.
+func TestSearchBooks_LanguageBoost(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "lang.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// Equal matches, ordered by ID without the boost
+	books := []inpx.Book{
+		{ID: "a-ru", Title: "Dune", Authors: []string{"Frank Herbert"}, Language: "ru", Format: "fb2", Date: time.Now()},
+		{ID: "b-en", Title: "Dune", Authors: []string{"Frank Herbert"}, Language: "en", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	first := func(filter BookFilter) string {
+		t.Helper()
+		filter.Limit = 10
+		result, err := repo.SearchBooks(filter)
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if len(result.Books) == 0 {
+			t.Fatal("nothing found")
+		}
+		return result.Books[0].ID
+	}
+
+	if got := first(BookFilter{Query: "dune"}); got != "b-en" {
+		t.Errorf("expected the English book first, got %s", got)
+	}
+	if got := first(BookFilter{Query: "dune", Languages: []string{"ru", "en"}}); got != "a-ru" {
+		t.Errorf("expected no boost with a language filter, got %s", got)
+	}
+	repo.SetLanguageBoost(1)
+	if got := first(BookFilter{Query: "dune"}); got != "a-ru" {
+		t.Errorf("expected no boost when disabled, got %s", got)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"log"
 	"strings"
 	"sync/atomic"
-	"time"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/piligrim/pushkinlib/internal/inpx"
@@ -17,21 +16,59 @@ import (
 type Repository struct {
 	db       *Database
 	ftsFresh atomic.Bool
+	// ftsDeferred leaves books_fts of imports into an empty catalog to
+	// BuildDeferredFTS; ftsPending is set while it has books to index
+	ftsDeferred atomic.Bool
+	ftsPending  atomic.Bool
+
+	suggestionsEnabled atomic.Bool
+	fallbackEnabled    atomic.Bool
+	genreMapping       atomic.Pointer[GenreMapping]
+	rankWeights        atomic.Pointer[RankWeights]
+	languageDetection  atomic.Bool
+	languageBoost      atomic.Pointer[float64]
+	syncEnabled        atomic.Bool
+	searchLog          atomic.Pointer[searchLog]
+
+	accessRules atomic.Pointer[[]AccessRule]
+	queryCache  atomic.Pointer[queryCache]
 }
 
 const bookSelectColumns = `
 	b.id, b.title, b.series_id, b.series_num, b.genre_id, b.year,
 	b.language, b.file_size, b.archive_path, b.file_num, b.format,
-	b.date_added, b.rating, b.annotation, b.created_at, b.updated_at,
-	s.name as series_name, g.name as genre_name`
+	b.date_added, b.rating, b.created_at, b.updated_at,
+	s.name as series_name, g.name as genre_name,
+	EXISTS(SELECT 1 FROM book_covers bc WHERE bc.book_id = b.id AND bc.has_cover = 1) as has_cover,
+	(SELECT bc.hash FROM book_covers bc WHERE bc.book_id = b.id AND bc.has_cover = 1) as cover_hash,
+	(SELECT bs.sha256 FROM book_checksums bs WHERE bs.book_id = b.id) as sha256`
 
 // NewRepository creates a new repository
 func NewRepository(db *Database) *Repository {
 	return &Repository{db: db}
 }
 
+// ReadOnly reports whether the underlying database rejects writes
+func (r *Repository) ReadOnly() bool {
+	return r.db.readOnly
+}
+
 // ListAuthors returns a paginated list of authors
 func (r *Repository) ListAuthors(limit, offset int) ([]Author, int, error) {
+	return r.ListAuthorsInLanguage("", limit, offset)
+}
+
+// ListAuthorsInLanguage returns a paginated list of the authors of books in
+// language, or of all authors if language is empty
+func (r *Repository) ListAuthorsInLanguage(language string, limit, offset int) ([]Author, int, error) {
+	page, err := cachedQuery(r, func() (listPage[Author], error) {
+		authors, total, err := r.listAuthors(language, limit, offset)
+		return listPage[Author]{authors, total}, err
+	}, "authors", language, limit, offset)
+	return page.items, page.total, err
+}
+
+func (r *Repository) listAuthors(language string, limit, offset int) ([]Author, int, error) {
 	if limit <= 0 {
 		limit = 30
 	}
@@ -39,9 +76,23 @@ func (r *Repository) ListAuthors(limit, offset int) ([]Author, int, error) {
 		offset = 0
 	}
 
+	where, args := "", []interface{}{}
+	if language != "" {
+		where = ` WHERE EXISTS (SELECT 1 FROM book_authors ba JOIN books b ON b.id = ba.book_id
+			WHERE ba.author_id = authors.id AND b.language = ?)`
+		args = append(args, language)
+	}
+
+	// The page is picked first, so only its books are counted
+	bookJoin, bookArgs := bookCountJoin(language)
 	rows, err := r.db.db.Query(
-		"SELECT id, name FROM authors ORDER BY LOWER(name) LIMIT ? OFFSET ?",
-		limit, offset,
+		`SELECT a.id, a.name, COUNT(b.id)
+		 FROM (SELECT id, name FROM authors`+where+` ORDER BY LOWER(name) LIMIT ? OFFSET ?) a
+		 LEFT JOIN book_authors ba ON ba.author_id = a.id
+		 LEFT JOIN books b ON b.id = ba.book_id`+bookJoin+`
+		 GROUP BY a.id
+		 ORDER BY LOWER(a.name)`,
+		append(append(append([]interface{}{}, args...), limit, offset), bookArgs...)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query authors: %w", err)
@@ -51,7 +102,7 @@ func (r *Repository) ListAuthors(limit, offset int) ([]Author, int, error) {
 	var authors []Author
 	for rows.Next() {
 		var author Author
-		if err := rows.Scan(&author.ID, &author.Name); err != nil {
+		if err := rows.Scan(&author.ID, &author.Name, &author.BookCount); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan author: %w", err)
 		}
 		authors = append(authors, author)
@@ -61,8 +112,11 @@ func (r *Repository) ListAuthors(limit, offset int) ([]Author, int, error) {
 		return nil, 0, fmt.Errorf("error iterating authors: %w", err)
 	}
 
+	if total, ok := r.navigationCount(navAuthors, language); ok {
+		return authors, total, nil
+	}
 	var total int
-	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM authors").Scan(&total); err != nil {
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM authors"+where, args...).Scan(&total); err != nil {
 		return nil, 0, fmt.Errorf("failed to count authors: %w", err)
 	}
 
@@ -72,7 +126,7 @@ func (r *Repository) ListAuthors(limit, offset int) ([]Author, int, error) {
 // GetAuthorByID returns an author by ID
 func (r *Repository) GetAuthorByID(authorID int) (*Author, error) {
 	var author Author
-	err := r.db.db.QueryRow("SELECT id, name FROM authors WHERE id = ?", authorID).Scan(&author.ID, &author.Name)
+	err := r.db.db.QueryRow("SELECT id, name, source_id FROM authors WHERE id = ?", authorID).Scan(&author.ID, &author.Name, &author.SourceID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -82,8 +136,33 @@ func (r *Repository) GetAuthorByID(authorID int) (*Author, error) {
 	return &author, nil
 }
 
+// bookCountJoin returns the join condition of the books counted for an
+// author, series or genre: the visible books in language, or in any
+// language if it is empty
+func bookCountJoin(language string) (string, []interface{}) {
+	join := " AND " + visibleCondition
+	if language == "" {
+		return join, nil
+	}
+	return join + " AND b.language = ?", []interface{}{language}
+}
+
 // ListSeries returns a paginated list of series
 func (r *Repository) ListSeries(limit, offset int) ([]Series, int, error) {
+	return r.ListSeriesInLanguage("", limit, offset)
+}
+
+// ListSeriesInLanguage returns a paginated list of the series with books in
+// language, or of all series if language is empty
+func (r *Repository) ListSeriesInLanguage(language string, limit, offset int) ([]Series, int, error) {
+	page, err := cachedQuery(r, func() (listPage[Series], error) {
+		seriesList, total, err := r.listSeries(language, limit, offset)
+		return listPage[Series]{seriesList, total}, err
+	}, "series", language, limit, offset)
+	return page.items, page.total, err
+}
+
+func (r *Repository) listSeries(language string, limit, offset int) ([]Series, int, error) {
 	if limit <= 0 {
 		limit = 30
 	}
@@ -91,9 +170,24 @@ func (r *Repository) ListSeries(limit, offset int) ([]Series, int, error) {
 		offset = 0
 	}
 
+	where, args := "", []interface{}{}
+	if language != "" {
+		where = ` WHERE EXISTS (SELECT 1 FROM books b WHERE b.series_id = series.id AND b.language = ?)
+			OR EXISTS (SELECT 1 FROM book_series bs JOIN books b ON b.id = bs.book_id
+			           WHERE bs.series_id = series.id AND b.language = ?)`
+		args = append(args, language, language)
+	}
+
+	// Books of the series as their main or a further one
+	bookJoin, bookArgs := bookCountJoin(language)
 	rows, err := r.db.db.Query(
-		"SELECT id, name FROM series ORDER BY LOWER(name) LIMIT ? OFFSET ?",
-		limit, offset,
+		`SELECT s.id, s.name,
+		        (SELECT COUNT(*) FROM books b WHERE b.series_id = s.id`+bookJoin+`) +
+		        (SELECT COUNT(*) FROM book_series bs JOIN books b ON b.id = bs.book_id
+		         WHERE bs.series_id = s.id`+bookJoin+`)
+		 FROM (SELECT id, name FROM series`+where+` ORDER BY LOWER(name) LIMIT ? OFFSET ?) s
+		 ORDER BY LOWER(s.name)`,
+		append(append(append(append([]interface{}{}, bookArgs...), bookArgs...), args...), limit, offset)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query series: %w", err)
@@ -103,7 +197,7 @@ func (r *Repository) ListSeries(limit, offset int) ([]Series, int, error) {
 	var seriesList []Series
 	for rows.Next() {
 		var series Series
-		if err := rows.Scan(&series.ID, &series.Name); err != nil {
+		if err := rows.Scan(&series.ID, &series.Name, &series.BookCount); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan series: %w", err)
 		}
 		seriesList = append(seriesList, series)
@@ -113,8 +207,11 @@ func (r *Repository) ListSeries(limit, offset int) ([]Series, int, error) {
 		return nil, 0, fmt.Errorf("error iterating series: %w", err)
 	}
 
+	if total, ok := r.navigationCount(navSeries, language); ok {
+		return seriesList, total, nil
+	}
 	var total int
-	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM series").Scan(&total); err != nil {
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM series"+where, args...).Scan(&total); err != nil {
 		return nil, 0, fmt.Errorf("failed to count series: %w", err)
 	}
 
@@ -136,6 +233,27 @@ func (r *Repository) GetSeriesByID(seriesID int) (*Series, error) {
 
 // ListGenres returns a paginated list of genres
 func (r *Repository) ListGenres(limit, offset int) ([]Genre, int, error) {
+	return r.ListVisibleGenres(limit, offset, nil)
+}
+
+// ListVisibleGenres returns a paginated list of the genres not hidden by
+// restrictions
+func (r *Repository) ListVisibleGenres(limit, offset int, hidden *Restrictions) ([]Genre, int, error) {
+	return r.ListGenresInLanguage("", limit, offset, hidden)
+}
+
+// ListGenresInLanguage returns a paginated list of the genres with books in
+// language, or of all genres if language is empty, leaving out the genres
+// hidden by restrictions
+func (r *Repository) ListGenresInLanguage(language string, limit, offset int, hidden *Restrictions) ([]Genre, int, error) {
+	page, err := cachedQuery(r, func() (listPage[Genre], error) {
+		genres, total, err := r.listVisibleGenres(language, limit, offset, hidden)
+		return listPage[Genre]{genres, total}, err
+	}, "genres", language, limit, offset, hidden)
+	return page.items, page.total, err
+}
+
+func (r *Repository) listVisibleGenres(language string, limit, offset int, hidden *Restrictions) ([]Genre, int, error) {
 	if limit <= 0 {
 		limit = 30
 	}
@@ -143,9 +261,37 @@ func (r *Repository) ListGenres(limit, offset int) ([]Genre, int, error) {
 		offset = 0
 	}
 
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	bookJoin, bookArgs := bookCountJoin(language)
+	if hidden != nil && len(hidden.Genres) > 0 {
+		conditions = append(conditions, "name NOT IN ("+createPlaceholders(len(hidden.Genres))+")")
+		// A book is hidden by any of its genres
+		bookJoin += " AND NOT " + genreFilterCondition(len(hidden.Genres))
+		for _, genre := range hidden.Genres {
+			args = append(args, genre)
+			bookArgs = append(bookArgs, genre)
+		}
+	}
+	if language != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM book_genres bg JOIN books b ON b.id = bg.book_id WHERE bg.genre_id = genres.id AND b.language = ?)")
+		args = append(args, language)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
 	rows, err := r.db.db.Query(
-		"SELECT id, name FROM genres ORDER BY LOWER(name) LIMIT ? OFFSET ?",
-		limit, offset,
+		`SELECT g.id, g.name, COUNT(b.id)
+		 FROM (SELECT id, name FROM genres`+where+` ORDER BY LOWER(name) LIMIT ? OFFSET ?) g
+		 LEFT JOIN book_genres bg ON bg.genre_id = g.id
+		 LEFT JOIN books b ON b.id = bg.book_id`+bookJoin+`
+		 GROUP BY g.id
+		 ORDER BY LOWER(g.name)`,
+		append(append(append([]interface{}{}, args...), limit, offset), bookArgs...)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query genres: %w", err)
@@ -155,7 +301,7 @@ func (r *Repository) ListGenres(limit, offset int) ([]Genre, int, error) {
 	var genres []Genre
 	for rows.Next() {
 		var genre Genre
-		if err := rows.Scan(&genre.ID, &genre.Name); err != nil {
+		if err := rows.Scan(&genre.ID, &genre.Name, &genre.BookCount); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan genre: %w", err)
 		}
 		genres = append(genres, genre)
@@ -165,8 +311,13 @@ func (r *Repository) ListGenres(limit, offset int) ([]Genre, int, error) {
 		return nil, 0, fmt.Errorf("error iterating genres: %w", err)
 	}
 
+	if hidden == nil || len(hidden.Genres) == 0 {
+		if total, ok := r.navigationCount(navGenres, language); ok {
+			return genres, total, nil
+		}
+	}
 	var total int
-	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM genres").Scan(&total); err != nil {
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM genres"+where, args...).Scan(&total); err != nil {
 		return nil, 0, fmt.Errorf("failed to count genres: %w", err)
 	}
 
@@ -250,57 +401,65 @@ func (r *Repository) InsertBooks(books []inpx.Book) error {
 	defer tx.Rollback()
 
 	skipFTSDelete := r.ftsFresh.Swap(false)
+	deferFTS := r.ftsPending.Load() || (skipFTSDelete && r.ftsDeferred.Load())
 
-	bookStmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO books
-		(id, title, series_id, series_num, genre_id, year, language,
-		 file_size, archive_path, file_num, format, date_added, rating, annotation, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare book insert statement: %w", err)
-	}
-	defer bookStmt.Close()
-
-	bookAuthorStmt, err := tx.Prepare(`
-		INSERT OR IGNORE INTO book_authors (book_id, author_id)
-		VALUES (?, ?)`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare book author statement: %w", err)
-	}
-	defer bookAuthorStmt.Close()
-
-	var ftsDeleteStmt *sql.Stmt
-	if !skipFTSDelete {
-		ftsDeleteStmt, err = tx.Prepare("DELETE FROM books_fts WHERE book_id = ?")
+	// After ClearAllBooks the table is empty: building the secondary indexes
+	// once at the end is much cheaper than maintaining them row by row.
+	var bookIndexes []string
+	if skipFTSDelete {
+		bookIndexes, err = dropBookIndexesTx(tx)
 		if err != nil {
-			return fmt.Errorf("failed to prepare books_fts delete statement: %w", err)
+			return err
 		}
-		defer ftsDeleteStmt.Close()
 	}
 
-	ftsInsertStmt, err := tx.Prepare(`
-		INSERT INTO books_fts (book_id, title, annotation, authors, series)
-		VALUES (?, ?, ?, ?, ?)`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare books_fts insert statement: %w", err)
-	}
-	defer ftsInsertStmt.Close()
+	batch := newBookInsertBatch(tx, skipFTSDelete)
+	batch.skipFTS = deferFTS
+	defer batch.close()
 
 	authorCache := make(map[string]int, 1024)
 	seriesCache := make(map[string]int, 256)
 	genreCache := make(map[string]int, 128)
 
 	for i, book := range books {
-		if err := r.insertBookTx(tx, book, bookStmt, bookAuthorStmt, ftsDeleteStmt, ftsInsertStmt, authorCache, seriesCache, genreCache, skipFTSDelete); err != nil {
+		// A repeated ID must replace the row written earlier, so flush first
+		// to keep the same outcome as inserting books one by one.
+		if batch.has(book.ID) {
+			if err := batch.flush(); err != nil {
+				return fmt.Errorf("failed to insert books: %w", err)
+			}
+		}
+
+		if err := r.queueBookTx(tx, batch, book, authorCache, seriesCache, genreCache); err != nil {
 			return fmt.Errorf("failed to insert book %s: %w", book.ID, err)
 		}
 
+		if batch.size() >= insertBatchSize {
+			if err := batch.flush(); err != nil {
+				return fmt.Errorf("failed to insert books: %w", err)
+			}
+		}
+
 		if (i+1)%50000 == 0 || i+1 == len(books) {
 			log.Printf("Reindex: inserted %d/%d books", i+1, len(books))
 		}
 	}
 
-	return tx.Commit()
+	if err := batch.flush(); err != nil {
+		return fmt.Errorf("failed to insert books: %w", err)
+	}
+
+	if err := createIndexesTx(tx, bookIndexes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if deferFTS {
+		r.ftsPending.Store(true)
+	}
+	return nil
 }
 
 type pragmaSnapshot struct {
@@ -405,13 +564,13 @@ func (r *Repository) setPragmaJournalMode(mode string) (string, error) {
 	return strings.ToUpper(result), nil
 }
 
-// insertBookTx inserts a single book within a transaction
-func (r *Repository) insertBookTx(
+// queueBookTx resolves series, genre and author IDs for a book and adds its
+// rows to the pending insert batch
+func (r *Repository) queueBookTx(
 	tx *sql.Tx,
+	batch *bookInsertBatch,
 	book inpx.Book,
-	bookStmt, bookAuthorStmt, ftsDeleteStmt, ftsInsertStmt *sql.Stmt,
 	authorCache, seriesCache, genreCache map[string]int,
-	skipFTSDelete bool,
 ) error {
 	var seriesID sql.NullInt64
 	if book.Series != "" {
@@ -422,35 +581,12 @@ func (r *Repository) insertBookTx(
 		seriesID = sql.NullInt64{Int64: int64(id), Valid: true}
 	}
 
-	var genreID sql.NullInt64
-	if book.Genre != "" {
-		id, err := r.getOrCreateGenreTx(tx, book.Genre, genreCache)
-		if err != nil {
-			return err
-		}
-		genreID = sql.NullInt64{Int64: int64(id), Valid: true}
-	}
-
-	if _, err := bookStmt.Exec(
-		book.ID,
-		book.Title,
-		seriesID,
-		book.SeriesNum,
-		genreID,
-		book.Year,
-		book.Language,
-		book.FileSize,
-		book.ArchivePath,
-		book.FileNum,
-		book.Format,
-		book.Date,
-		book.Rating,
-		book.Annotation,
-		time.Now(),
-	); err != nil {
+	genreIDs, err := r.bookGenresTx(tx, book.Genre, genreCache)
+	if err != nil {
 		return err
 	}
 
+	authorIDs := make([]int, 0, len(book.Authors))
 	for _, authorName := range book.Authors {
 		if authorName == "" {
 			continue
@@ -460,23 +596,15 @@ func (r *Repository) insertBookTx(
 		if err != nil {
 			return err
 		}
-
-		if _, err := bookAuthorStmt.Exec(book.ID, authorID); err != nil {
-			return err
-		}
+		authorIDs = append(authorIDs, authorID)
 	}
 
-	if !skipFTSDelete && ftsDeleteStmt != nil {
-		if _, err := ftsDeleteStmt.Exec(book.ID); err != nil {
-			return err
-		}
-	}
-
-	authorsText := strings.Join(book.Authors, " ")
-	if _, err := ftsInsertStmt.Exec(book.ID, book.Title, book.Annotation, authorsText, book.Series); err != nil {
+	otherSeries, err := r.otherSeriesLinksTx(tx, book, seriesCache)
+	if err != nil {
 		return err
 	}
 
+	batch.add(book, seriesID, genreIDs, authorIDs, otherSeries)
 	return nil
 }
 
@@ -607,6 +735,12 @@ func isUniqueConstraintError(err error) bool {
 
 // SearchBooks searches books with filters
 func (r *Repository) SearchBooks(filter BookFilter) (*BookList, error) {
+	return cachedQuery(r, func() (*BookList, error) {
+		return r.searchBooksUncached(filter)
+	}, "books", filter, filter.Hidden)
+}
+
+func (r *Repository) searchBooksUncached(filter BookFilter) (*BookList, error) {
 	sanitized := filter
 	if sanitized.Limit <= 0 {
 		sanitized.Limit = 30
@@ -615,7 +749,75 @@ func (r *Repository) SearchBooks(filter BookFilter) (*BookList, error) {
 		sanitized.Offset = 0
 	}
 
-	query, queryArgs, countQuery, countArgs := r.buildSearchSQL(sanitized)
+	if err := validateSearchQuery(sanitized.Query); err != nil {
+		return nil, err
+	}
+
+	// Books of an author include those published under the author's aliases
+	if len(sanitized.Authors) > 0 {
+		authors, err := r.expandAuthorAliases(sanitized.Authors)
+		if err != nil {
+			return nil, err
+		}
+		sanitized.Authors = authors
+	}
+
+	list, err := r.searchBooks(sanitized, true)
+	if err != nil && isFTSQueryError(err) {
+		log.Printf("SearchBooks: FTS query %q failed, falling back to LIKE search: %v", sanitized.Query, err)
+		list, err = r.searchBooks(sanitized, false)
+		if err == nil {
+			list.Warnings = append(list.Warnings, "full-text search failed, words were matched as substrings")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	list.Warnings = append(searchQueryWarnings(sanitized.Query), list.Warnings...)
+
+	// The retry applies to every page, since the query itself found nothing
+	if list.Total == 0 && strings.TrimSpace(sanitized.Query) != "" && r.fallbackEnabled.Load() {
+		fallback, err := r.searchFallback(sanitized)
+		if err != nil {
+			return nil, err
+		}
+		if fallback != nil {
+			fallback.Warnings = list.Warnings
+			return fallback, nil
+		}
+	}
+
+	if list.Total == 0 && sanitized.Offset == 0 && strings.TrimSpace(sanitized.Query) != "" && r.suggestionsEnabled.Load() {
+		suggestions, err := r.SuggestQueries(sanitized.Query, maxSuggestions)
+		if err != nil {
+			log.Printf("SearchBooks: failed to build suggestions for %q: %v", sanitized.Query, err)
+		}
+		if sanitized.Hidden != nil {
+			suggestions = r.visibleSuggestions(sanitized, suggestions)
+		}
+		list.Suggestions = suggestions
+	}
+	return list, nil
+}
+
+// visibleSuggestions drops suggestions that only match books hidden from the
+// viewer, since the words would reveal them
+func (r *Repository) visibleSuggestions(filter BookFilter, suggestions []string) []string {
+	visible := suggestions[:0]
+	for _, suggestion := range suggestions {
+		probe := filter
+		probe.Query, probe.Limit, probe.Offset = suggestion, 1, 0
+		if list, err := r.searchBooks(probe, true); err == nil && list.Total > 0 {
+			visible = append(visible, suggestion)
+		}
+	}
+	return visible
+}
+
+// searchBooks runs a search, matching the text query with FTS5 when useFTS
+// is set and with LIKE otherwise.
+func (r *Repository) searchBooks(sanitized BookFilter, useFTS bool) (*BookList, error) {
+	query, queryArgs, countQuery, countArgs := r.buildSearchSQL(sanitized, useFTS)
 
 	var total int
 	if err := r.db.db.QueryRow(countQuery, countArgs...).Scan(&total); err != nil {
@@ -648,6 +850,18 @@ func (r *Repository) SearchBooks(filter BookFilter) (*BookList, error) {
 		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
 
+	if err := r.loadOtherSeries(books); err != nil {
+		return nil, err
+	}
+	if err := r.loadBookGenres(books); err != nil {
+		return nil, err
+	}
+	if sanitized.WithAnnotations {
+		if err := r.loadAnnotations(books); err != nil {
+			return nil, err
+		}
+	}
+
 	return &BookList{
 		Books:   books,
 		Total:   total,
@@ -657,7 +871,7 @@ func (r *Repository) SearchBooks(filter BookFilter) (*BookList, error) {
 	}, nil
 }
 
-func (r *Repository) buildSearchSQL(filter BookFilter) (string, []interface{}, string, []interface{}) {
+func (r *Repository) buildSearchSQL(filter BookFilter, useFTS bool) (string, []interface{}, string, []interface{}) {
 	limit := filter.Limit
 	if limit <= 0 {
 		limit = 30
@@ -667,11 +881,50 @@ func (r *Repository) buildSearchSQL(filter BookFilter) (string, []interface{}, s
 		offset = 0
 	}
 
+	from := buildSearchFrom(filter, useFTS)
+	sortBy := filter.SortBy
+	if sortBy == "shelf" && filter.Shelf == "" {
+		// Shelf order needs the shelf join
+		sortBy = ""
+	}
+	orderClause := buildOrderClause(sortBy, filter.SortOrder, from.hasFTS, r.languageRank(r.rankExpr(), filter))
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("SELECT ")
+	queryBuilder.WriteString(bookSelectColumns)
+	queryBuilder.WriteString(from.sql)
+	if from.joinedAuthors {
+		queryBuilder.WriteString(" GROUP BY b.id")
+	}
+	queryBuilder.WriteString(orderClause)
+	queryBuilder.WriteString(" LIMIT ? OFFSET ?")
+
+	queryArgs := make([]interface{}, 0, len(from.args)+2)
+	queryArgs = append(queryArgs, from.args...)
+	queryArgs = append(queryArgs, limit, offset)
+
+	countQuery := "SELECT COUNT(DISTINCT b.id)" + from.sql
+	countArgs := make([]interface{}, 0, len(from.args))
+	countArgs = append(countArgs, from.args...)
+
+	return queryBuilder.String(), queryArgs, countQuery, countArgs
+}
+
+// searchFrom is the FROM ... WHERE part of a book search, shared by the
+// result, count and facet queries.
+type searchFrom struct {
+	sql           string
+	args          []interface{}
+	hasFTS        bool
+	joinedAuthors bool
+}
+
+func buildSearchFrom(filter BookFilter, useFTS bool) searchFrom {
 	joins := []string{
 		"LEFT JOIN series s ON b.series_id = s.id",
 		"LEFT JOIN genres g ON b.genre_id = g.id",
 	}
-	conditions := make([]string, 0)
+	conditions := []string{visibleCondition}
 	baseArgs := make([]interface{}, 0)
 	joinedAuthors := false
 	hasFTS := false
@@ -686,39 +939,53 @@ func (r *Repository) buildSearchSQL(filter BookFilter) (string, []interface{}, s
 
 	if strings.TrimSpace(filter.Query) != "" {
 		ftsQuery, fallback := prepareFTSSearch(filter.Query)
-		if ftsQuery != "" {
+		if ftsQuery != "" && useFTS {
 			hasFTS = true
-			joins = append(joins, "JOIN books_fts ON books_fts.book_id = b.id")
+			joins = append(joins, "JOIN books_fts_ids fi ON fi.book_id = b.id",
+				"JOIN books_fts ON books_fts.rowid = fi.id")
 			conditions = append(conditions, "books_fts MATCH ?")
 			baseArgs = append(baseArgs, ftsQuery)
+		} else if ftsQuery != "" {
+			addAuthorJoin()
+			likeConditions, likeArgs := buildLikeConditions(parseSearchQuery(filter.Query))
+			conditions = append(conditions, likeConditions...)
+			baseArgs = append(baseArgs, likeArgs...)
 		} else if fallback != "" {
 			addAuthorJoin()
 			like := "%" + strings.ToLower(fallback) + "%"
-			conditions = append(conditions, "(LOWER(b.title) LIKE ? OR LOWER(b.annotation) LIKE ? OR LOWER(a.name) LIKE ? OR LOWER(s.name) LIKE ?)")
+			conditions = append(conditions, "(LOWER(b.title) LIKE ? OR LOWER("+annotationExpr+") LIKE ? OR LOWER(a.name) LIKE ? OR LOWER(s.name) LIKE ?)")
 			baseArgs = append(baseArgs, like, like, like, like)
 		}
 	}
 
 	if len(filter.Authors) > 0 {
-		addAuthorJoin()
+		// A subquery rather than the author join, so the search starts
+		// from the authors' books instead of scanning every book
 		placeholders := createPlaceholders(len(filter.Authors))
-		conditions = append(conditions, fmt.Sprintf("a.name IN (%s)", placeholders))
+		conditions = append(conditions, fmt.Sprintf(
+			"b.id IN (SELECT fba.book_id FROM book_authors fba JOIN authors fa ON fa.id = fba.author_id WHERE fa.name IN (%s))",
+			placeholders))
 		for _, author := range filter.Authors {
 			baseArgs = append(baseArgs, author)
 		}
 	}
 
 	if len(filter.Series) > 0 {
+		// Books of a further series count as well
 		placeholders := createPlaceholders(len(filter.Series))
-		conditions = append(conditions, fmt.Sprintf("s.name IN (%s)", placeholders))
-		for _, series := range filter.Series {
-			baseArgs = append(baseArgs, series)
+		conditions = append(conditions, fmt.Sprintf(
+			"(b.series_id IN (SELECT id FROM series WHERE name IN (%s)) OR b.id IN (SELECT fbs.book_id FROM book_series fbs JOIN series fs ON fs.id = fbs.series_id WHERE fs.name IN (%s)))",
+			placeholders, placeholders))
+		for range 2 {
+			for _, series := range filter.Series {
+				baseArgs = append(baseArgs, series)
+			}
 		}
 	}
 
 	if len(filter.Genres) > 0 {
-		placeholders := createPlaceholders(len(filter.Genres))
-		conditions = append(conditions, fmt.Sprintf("g.name IN (%s)", placeholders))
+		// Any genre of a book counts, not only the main one
+		conditions = append(conditions, genreFilterCondition(len(filter.Genres)))
 		for _, genre := range filter.Genres {
 			baseArgs = append(baseArgs, genre)
 		}
@@ -740,6 +1007,30 @@ func (r *Repository) buildSearchSQL(filter BookFilter) (string, []interface{}, s
 		}
 	}
 
+	if len(filter.Tags) > 0 {
+		placeholders := createPlaceholders(len(filter.Tags))
+		conditions = append(conditions, fmt.Sprintf(
+			"b.id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name IN (%s))",
+			placeholders))
+		for _, tag := range filter.Tags {
+			baseArgs = append(baseArgs, tag)
+		}
+	}
+
+	if filter.Featured {
+		conditions = append(conditions, "b.id IN (SELECT book_id FROM featured_books)")
+	}
+
+	if filter.Shelf != "" {
+		joins = append(joins, "JOIN shelf_books sb ON sb.book_id = b.id")
+		conditions = append(conditions, "sb.shelf_id = ?")
+		baseArgs = append(baseArgs, filter.Shelf)
+	}
+
+	hiddenConditions, hiddenArgs := restrictionConditions(filter.Hidden)
+	conditions = append(conditions, hiddenConditions...)
+	baseArgs = append(baseArgs, hiddenArgs...)
+
 	if filter.YearFrom > 0 {
 		conditions = append(conditions, "b.year >= ?")
 		baseArgs = append(baseArgs, filter.YearFrom)
@@ -750,74 +1041,119 @@ func (r *Repository) buildSearchSQL(filter BookFilter) (string, []interface{}, s
 		baseArgs = append(baseArgs, filter.YearTo)
 	}
 
-	orderClause := buildOrderClause(filter.SortBy, filter.SortOrder, hasFTS)
+	if filter.SeriesNumFrom > 0 {
+		conditions = append(conditions, "b.series_id IS NOT NULL AND b.series_num >= ?")
+		baseArgs = append(baseArgs, filter.SeriesNumFrom)
+	}
 
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("SELECT ")
-	queryBuilder.WriteString(bookSelectColumns)
-	queryBuilder.WriteString(" FROM books b")
-	for _, join := range joins {
-		queryBuilder.WriteString(" ")
-		queryBuilder.WriteString(join)
+	if filter.SeriesNumTo > 0 {
+		conditions = append(conditions, "b.series_id IS NOT NULL AND b.series_num <= ?")
+		baseArgs = append(baseArgs, filter.SeriesNumTo)
 	}
-	if len(conditions) > 0 {
-		queryBuilder.WriteString(" WHERE ")
-		queryBuilder.WriteString(strings.Join(conditions, " AND "))
+
+	if filter.FirstInSeries {
+		conditions = append(conditions, "b.series_id IS NOT NULL AND b.series_num = 1")
 	}
-	if joinedAuthors {
-		queryBuilder.WriteString(" GROUP BY b.id")
+
+	if filter.WithoutSeries {
+		conditions = append(conditions, "b.series_id IS NULL AND b.id NOT IN (SELECT book_id FROM book_series)")
 	}
-	queryBuilder.WriteString(orderClause)
-	queryBuilder.WriteString(" LIMIT ? OFFSET ?")
 
-	queryArgs := make([]interface{}, 0, len(baseArgs)+2)
-	queryArgs = append(queryArgs, baseArgs...)
-	queryArgs = append(queryArgs, limit, offset)
+	if filter.ChangedAfter != nil {
+		conditions = append(conditions, "b.updated_at > ?")
+		baseArgs = append(baseArgs, *filter.ChangedAfter)
+	}
 
-	var countBuilder strings.Builder
-	countBuilder.WriteString("SELECT COUNT(DISTINCT b.id) FROM books b")
+	var fromBuilder strings.Builder
+	fromBuilder.WriteString(" FROM books b")
 	for _, join := range joins {
-		countBuilder.WriteString(" ")
-		countBuilder.WriteString(join)
+		fromBuilder.WriteString(" ")
+		fromBuilder.WriteString(join)
 	}
 	if len(conditions) > 0 {
-		countBuilder.WriteString(" WHERE ")
-		countBuilder.WriteString(strings.Join(conditions, " AND "))
+		fromBuilder.WriteString(" WHERE ")
+		fromBuilder.WriteString(strings.Join(conditions, " AND "))
+	}
+
+	return searchFrom{
+		sql:           fromBuilder.String(),
+		args:          baseArgs,
+		hasFTS:        hasFTS,
+		joinedAuthors: joinedAuthors,
 	}
+}
 
-	countArgs := make([]interface{}, 0, len(baseArgs))
-	countArgs = append(countArgs, baseArgs...)
+// SortFields lists the accepted values of BookFilter.SortBy
+var SortFields = []string{"title", "year", "date_added", "relevance", "author", "series", "size", "rating", "featured", "id"}
 
-	return queryBuilder.String(), queryArgs, countBuilder.String(), countArgs
+// IsValidSortField reports whether sortBy is empty or one of SortFields
+func IsValidSortField(sortBy string) bool {
+	if sortBy == "" {
+		return true
+	}
+	for _, field := range SortFields {
+		if field == sortBy {
+			return true
+		}
+	}
+	return false
 }
 
-func buildOrderClause(sortBy, sortOrder string, hasFTS bool) string {
+// primaryAuthorExpr selects the first author of a book in INPX order
+const primaryAuthorExpr = `(SELECT pa.name FROM book_authors pba JOIN authors pa ON pa.id = pba.author_id
+	WHERE pba.book_id = b.id ORDER BY pba.rowid LIMIT 1)`
+
+// buildOrderClause builds ORDER BY with stable secondary keys (title, then id)
+// so pagination does not shuffle books that share the primary key. rank is
+// the expression relevance ordering sorts full-text matches by.
+func buildOrderClause(sortBy, sortOrder string, hasFTS bool, rank string) string {
 	if sortBy == "" && hasFTS {
 		sortBy = "relevance"
 	}
 
-	var column string
+	direction := "ASC"
+	if strings.ToLower(sortOrder) == "desc" {
+		direction = "DESC"
+	}
+
+	var keys []string
 	switch sortBy {
 	case "year":
-		column = "b.year"
+		keys = []string{"b.year " + direction}
 	case "date_added":
-		column = "b.date_added"
+		keys = []string{"b.date_added " + direction}
 	case "relevance":
 		if hasFTS {
-			column = "bm25(books_fts)"
-		} else {
-			column = "b.title"
+			keys = []string{rank + " " + direction}
 		}
-	default:
-		column = "b.title"
-	}
-
-	direction := "ASC"
-	if strings.ToLower(sortOrder) == "desc" {
-		direction = "DESC"
+	case "author":
+		// Books without authors go last regardless of direction
+		keys = []string{primaryAuthorExpr + " IS NULL", primaryAuthorExpr + " " + direction}
+	case "series":
+		keys = []string{"s.name IS NULL", "s.name " + direction, "b.series_num " + direction}
+	case "size":
+		keys = []string{"b.file_size " + direction}
+	case "rating":
+		keys = []string{"b.rating " + direction}
+	case "featured":
+		// Books that are not featured go last regardless of direction
+		keys = []string{featuredOrderExpr + " IS NULL", featuredOrderExpr + " " + direction}
+	case "shelf":
+		// Only with BookFilter.Shelf, which joins shelf_books
+		keys = []string{"sb.position " + direction}
+	case "id":
+		// IDs are unique, so no further keys are needed
+		return " ORDER BY b.id " + direction
+	}
+
+	if len(keys) == 0 {
+		keys = []string{"b.title " + direction}
+	} else {
+		keys = append(keys, "b.title ASC")
 	}
+	keys = append(keys, "b.id ASC")
 
-	return " ORDER BY " + column + " " + direction
+	return " ORDER BY " + strings.Join(keys, ", ")
 }
 
 func createPlaceholders(count int) string {
@@ -831,19 +1167,22 @@ func createPlaceholders(count int) string {
 func (r *Repository) scanBook(rows *sql.Rows) (Book, error) {
 	var book Book
 	var seriesID, genreID sql.NullInt64
-	var seriesName, genreName sql.NullString
+	var seriesName, genreName, coverHash, checksum sql.NullString
 
 	err := rows.Scan(
 		&book.ID, &book.Title, &seriesID, &book.SeriesNum, &genreID,
 		&book.Year, &book.Language, &book.FileSize, &book.ArchivePath,
 		&book.FileNum, &book.Format, &book.DateAdded, &book.Rating,
-		&book.Annotation, &book.CreatedAt, &book.UpdatedAt,
-		&seriesName, &genreName,
+		&book.CreatedAt, &book.UpdatedAt,
+		&seriesName, &genreName, &book.HasCover, &coverHash, &checksum,
 	)
 	if err != nil {
 		return book, err
 	}
 
+	book.CoverHash = coverHash.String
+	book.SHA256 = checksum.String
+
 	if seriesID.Valid && seriesName.Valid {
 		book.Series = &Series{
 			ID:   int(seriesID.Int64),
@@ -911,6 +1250,28 @@ func (r *Repository) GetBookByID(id string) (*Book, error) {
 	}
 	book.Authors = authors
 
+	if book.OtherSeries, err = r.getBookOtherSeries(book.ID); err != nil {
+		return nil, fmt.Errorf("failed to load other series: %w", err)
+	}
+
+	if book.Genres, err = r.getBookGenres(book.ID); err != nil {
+		return nil, fmt.Errorf("failed to load genres: %w", err)
+	}
+
+	if book.Annotation, err = r.getBookAnnotation(book.ID); err != nil {
+		return nil, fmt.Errorf("failed to load annotation: %w", err)
+	}
+
+	tags, err := r.getBookTags(book.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	book.Tags = tags
+
+	if book.Hidden, err = r.IsBookHidden(book.ID); err != nil {
+		return nil, err
+	}
+
 	return &book, nil
 }
 
@@ -918,19 +1279,22 @@ func (r *Repository) GetBookByID(id string) (*Book, error) {
 func (r *Repository) scanBookRow(row *sql.Row) (Book, error) {
 	var book Book
 	var seriesID, genreID sql.NullInt64
-	var seriesName, genreName sql.NullString
+	var seriesName, genreName, coverHash, checksum sql.NullString
 
 	err := row.Scan(
 		&book.ID, &book.Title, &seriesID, &book.SeriesNum, &genreID,
 		&book.Year, &book.Language, &book.FileSize, &book.ArchivePath,
 		&book.FileNum, &book.Format, &book.DateAdded, &book.Rating,
-		&book.Annotation, &book.CreatedAt, &book.UpdatedAt,
-		&seriesName, &genreName,
+		&book.CreatedAt, &book.UpdatedAt,
+		&seriesName, &genreName, &book.HasCover, &coverHash, &checksum,
 	)
 	if err != nil {
 		return book, err
 	}
 
+	book.CoverHash = coverHash.String
+	book.SHA256 = checksum.String
+
 	if seriesID.Valid && seriesName.Valid {
 		book.Series = &Series{
 			ID:   int(seriesID.Int64),
@@ -950,6 +1314,8 @@ func (r *Repository) scanBookRow(row *sql.Row) (Book, error) {
 
 // ClearAllBooks removes all books and related data
 func (r *Repository) ClearAllBooks() error {
+	r.InvalidateQueryCache()
+
 	tx, err := r.db.db.Begin()
 	if err != nil {
 		return err
@@ -967,6 +1333,21 @@ func (r *Repository) ClearAllBooks() error {
 		return err
 	}
 
+	_, err = tx.Exec("DELETE FROM book_annotations")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM book_series")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM book_genres")
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec("DELETE FROM authors")
 	if err != nil {
 		return err
@@ -982,16 +1363,26 @@ func (r *Repository) ClearAllBooks() error {
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM books_fts")
+	_, err = tx.Exec("DELETE FROM nav_stats")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM delta_books")
 	if err != nil {
 		return err
 	}
 
+	if err := clearBooksFTSTx(tx); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
 	r.ftsFresh.Store(true)
+	r.ftsPending.Store(false)
 	return nil
 }
 
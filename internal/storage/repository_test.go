@@ -1,7 +1,12 @@
 package storage_test
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -76,3 +81,724 @@ func TestSearchBooksUsesFTS(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateBookOverrideSurvivesReindex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	book := inpx.Book{
+		ID:          "ov-1",
+		Title:       "Старое название",
+		Authors:     []string{"Автор"},
+		Genre:       "prose",
+		Language:    "ru",
+		ArchivePath: "books",
+		Format:      "fb2",
+		Date:        time.Now(),
+	}
+	if err := repo.InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to insert book: %v", err)
+	}
+
+	title := "Исправленное название"
+	series := "Новая серия"
+	rating := 4
+	updated, err := repo.UpdateBook("ov-1", storage.BookUpdate{Title: &title, Series: &series, Rating: &rating})
+	if err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	if updated.Title != title || updated.Series == nil || updated.Series.Name != series || updated.Rating != rating {
+		t.Fatalf("override not applied: %+v", updated)
+	}
+
+	// Simulate a reindex: the override must be re-applied on top of fresh data
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+	if err := repo.InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to reinsert book: %v", err)
+	}
+	applied, err := repo.ApplyBookOverrides()
+	if err != nil {
+		t.Fatalf("ApplyBookOverrides failed: %v", err)
+	}
+	if applied != 1 {
+		t.Errorf("expected 1 applied override, got %d", applied)
+	}
+
+	result, err := repo.SearchBooks(storage.BookFilter{Query: "Исправленное"})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if result.Total != 1 || result.Books[0].Title != title {
+		t.Fatalf("expected overridden title to be searchable, got %+v", result)
+	}
+	if result.Books[0].Genre == nil || result.Books[0].Genre.Name != "prose" {
+		t.Errorf("genre should stay untouched, got %+v", result.Books[0].Genre)
+	}
+
+	if _, err := repo.UpdateBook("missing", storage.BookUpdate{Title: &title}); err != storage.ErrBookNotFound {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+}
+
+// TestHiddenBooksSurviveReindex hides a book and an archive and checks both
+// stay out of search and export after the books are reimported.
+func TestHiddenBooksSurviveReindex(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "h-1", Title: "Первая", Authors: []string{"Автор"}, ArchivePath: "a.zip", Format: "fb2", Date: time.Now()},
+		{ID: "h-2", Title: "Вторая", Authors: []string{"Автор"}, ArchivePath: "a.zip", Format: "fb2", Date: time.Now()},
+		{ID: "h-3", Title: "Третья", Authors: []string{"Автор"}, ArchivePath: "b.zip", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if err := repo.HideBook("h-1"); err != nil {
+		t.Fatalf("HideBook failed: %v", err)
+	}
+	if err := repo.HideBook("missing"); err != storage.ErrBookNotFound {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+	if n, err := repo.HideArchive("b.zip"); err != nil || n != 1 {
+		t.Fatalf("HideArchive = %d, %v; want 1 book", n, err)
+	}
+
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to reinsert books: %v", err)
+	}
+
+	result, err := repo.SearchBooks(storage.BookFilter{})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if result.Total != 1 || result.Books[0].ID != "h-2" {
+		t.Errorf("expected only h-2 visible, got %+v", result.Books)
+	}
+
+	var exported []string
+	if _, err := repo.EachExportBook(func(book inpx.Book) error {
+		exported = append(exported, book.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("EachExportBook failed: %v", err)
+	}
+	if len(exported) != 1 || exported[0] != "h-2" {
+		t.Errorf("expected only h-2 exported, got %v", exported)
+	}
+
+	book, err := repo.GetBookByID("h-3")
+	if err != nil || book == nil || !book.Hidden {
+		t.Errorf("expected h-3 to be found and marked hidden, got %+v, %v", book, err)
+	}
+}
+
+func TestBookTagsFilterAndSurviveReindex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "t-1", Title: "Капитанская дочка", Authors: []string{"Пушкин"}, Genre: "prose", Language: "ru", ArchivePath: "books", Format: "fb2", Date: time.Now()},
+		{ID: "t-2", Title: "Дубровский", Authors: []string{"Пушкин"}, Genre: "prose", Language: "ru", ArchivePath: "books", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	tag, err := repo.CreateTag("школьная программа")
+	if err != nil {
+		t.Fatalf("CreateTag failed: %v", err)
+	}
+	if _, err := repo.CreateTag("школьная программа"); !errors.Is(err, storage.ErrTagExists) {
+		t.Fatalf("expected ErrTagExists, got %v", err)
+	}
+	if err := repo.AddBookTag("t-1", tag.ID); err != nil {
+		t.Fatalf("AddBookTag failed: %v", err)
+	}
+	if err := repo.AddBookTag("missing", tag.ID); !errors.Is(err, storage.ErrBookNotFound) {
+		t.Fatalf("expected ErrBookNotFound, got %v", err)
+	}
+
+	// Simulate a reindex: tag assignments must be kept
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to re-insert books: %v", err)
+	}
+
+	result, err := repo.SearchBooks(storage.BookFilter{Tags: []string{tag.Name}, Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 1 || len(result.Books) != 1 || result.Books[0].ID != "t-1" {
+		t.Fatalf("expected only t-1 for tag filter, got %+v", result.Books)
+	}
+
+	book, err := repo.GetBookByID("t-1")
+	if err != nil {
+		t.Fatalf("GetBookByID failed: %v", err)
+	}
+	if len(book.Tags) != 1 || book.Tags[0].Name != tag.Name {
+		t.Errorf("expected book tags to be loaded, got %+v", book.Tags)
+	}
+
+	tags, total, err := repo.ListTags(10, 0)
+	if err != nil {
+		t.Fatalf("ListTags failed: %v", err)
+	}
+	if total != 1 || tags[0].BookCount != 1 {
+		t.Errorf("unexpected tag list: total=%d tags=%+v", total, tags)
+	}
+
+	if err := repo.DeleteTag(tag.ID); err != nil {
+		t.Fatalf("DeleteTag failed: %v", err)
+	}
+	if err := repo.DeleteTag(tag.ID); !errors.Is(err, storage.ErrTagNotFound) {
+		t.Fatalf("expected ErrTagNotFound, got %v", err)
+	}
+}
+
+func TestMergeAuthorsSurvivesReindex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "m-1", Title: "Евгений Онегин", Authors: []string{"Пушкин Александр"}, Language: "ru", ArchivePath: "books", Format: "fb2", Date: time.Now()},
+		{ID: "m-2", Title: "Полтава", Authors: []string{"Пушкин А."}, Language: "ru", ArchivePath: "books", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	findAuthor := func(name string) int {
+		authors, err := repo.FindAuthors(name, 10)
+		if err != nil || len(authors) != 1 {
+			t.Fatalf("expected one author %q, got %+v (err %v)", name, authors, err)
+		}
+		return authors[0].ID
+	}
+
+	target, err := repo.MergeAuthors(findAuthor("Пушкин А."), findAuthor("Пушкин Александр"))
+	if err != nil {
+		t.Fatalf("MergeAuthors failed: %v", err)
+	}
+	if target.Name != "Пушкин Александр" {
+		t.Fatalf("unexpected merge target %+v", target)
+	}
+
+	check := func() {
+		t.Helper()
+		result, err := repo.SearchBooks(storage.BookFilter{Authors: []string{"Пушкин Александр"}, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks failed: %v", err)
+		}
+		if result.Total != 2 {
+			t.Fatalf("expected both books under merged author, got %d", result.Total)
+		}
+		if _, total, _ := repo.ListAuthors(10, 0); total != 1 {
+			t.Fatalf("expected 1 author after merge, got %d", total)
+		}
+	}
+	check()
+
+	// Simulate a reindex: the merge must be re-applied
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to re-insert books: %v", err)
+	}
+	if n, err := repo.ApplyAuthorMerges(); err != nil || n != 1 {
+		t.Fatalf("ApplyAuthorMerges = %d, %v", n, err)
+	}
+	check()
+
+	if _, err := repo.MergeAuthors(9999, target.ID); !errors.Is(err, storage.ErrAuthorNotFound) {
+		t.Errorf("expected ErrAuthorNotFound, got %v", err)
+	}
+}
+
+func TestReplaceImportErrors(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	first := []inpx.LineError{
+		{File: "a.inp", Line: 3, Reason: "bad"},
+		{File: "a.inp", Line: 1, Reason: "bad", Content: "x"},
+	}
+	if err := repo.ReplaceImportErrors(first); err != nil {
+		t.Fatalf("ReplaceImportErrors failed: %v", err)
+	}
+
+	errs, total, err := repo.ListImportErrors(10, 0)
+	if err != nil {
+		t.Fatalf("ListImportErrors failed: %v", err)
+	}
+	if total != 2 || errs[0].Line != 1 || errs[0].Content != "x" {
+		t.Fatalf("unexpected import errors: total=%d %+v", total, errs)
+	}
+
+	// A clean import clears the previous log
+	if err := repo.ReplaceImportErrors(nil); err != nil {
+		t.Fatalf("ReplaceImportErrors failed: %v", err)
+	}
+	if _, total, _ := repo.ListImportErrors(10, 0); total != 0 {
+		t.Errorf("expected empty log, got %d", total)
+	}
+}
+
+func TestSearchBooksSortOptions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	now := time.Now()
+	books := []inpx.Book{
+		{ID: "s-1", Title: "Б", Authors: []string{"Толстой", "Айвазовский"}, Series: "Цикл", SeriesNum: 2, FileSize: 300, Rating: 1, Language: "ru", ArchivePath: "a", Format: "fb2", Date: now},
+		{ID: "s-2", Title: "А", Authors: []string{"Гоголь"}, Series: "Цикл", SeriesNum: 1, FileSize: 100, Rating: 5, Language: "ru", ArchivePath: "a", Format: "fb2", Date: now},
+		{ID: "s-3", Title: "В", Authors: []string{"Булгаков"}, FileSize: 200, Rating: 3, Language: "ru", ArchivePath: "a", Format: "fb2", Date: now},
+		{ID: "s-4", Title: "Г", Authors: []string{"Булгаков"}, FileSize: 200, Rating: 3, Language: "ru", ArchivePath: "a", Format: "fb2", Date: now},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	cases := []struct {
+		sortBy, order string
+		want          []string
+	}{
+		// Primary author is the first one listed, not the alphabetically first
+		{"author", "asc", []string{"s-3", "s-4", "s-2", "s-1"}},
+		// Books without series go last; ties broken by title
+		{"series", "asc", []string{"s-2", "s-1", "s-3", "s-4"}},
+		{"size", "desc", []string{"s-1", "s-3", "s-4", "s-2"}},
+		{"rating", "desc", []string{"s-2", "s-3", "s-4", "s-1"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.sortBy+"_"+tc.order, func(t *testing.T) {
+			result, err := repo.SearchBooks(storage.BookFilter{SortBy: tc.sortBy, SortOrder: tc.order, Limit: 10})
+			if err != nil {
+				t.Fatalf("SearchBooks failed: %v", err)
+			}
+			var got []string
+			for _, b := range result.Books {
+				got = append(got, b.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("expected order %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	if storage.IsValidSortField("bogus") || !storage.IsValidSortField("") || !storage.IsValidSortField("series") {
+		t.Error("IsValidSortField returned unexpected results")
+	}
+}
+
+func TestSearchBooksSeriesNumFilters(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	now := time.Now()
+	books := []inpx.Book{
+		{ID: "n-1", Title: "Первая", Authors: []string{"Автор"}, Series: "Цикл", SeriesNum: 1, Format: "fb2", Date: now},
+		{ID: "n-2", Title: "Вторая", Authors: []string{"Автор"}, Series: "Цикл", SeriesNum: 2, Format: "fb2", Date: now},
+		{ID: "n-3", Title: "Третья", Authors: []string{"Автор"}, Series: "Цикл", SeriesNum: 3, Format: "fb2", Date: now},
+		{ID: "n-4", Title: "Начало", Authors: []string{"Автор"}, Series: "Другой цикл", SeriesNum: 1, Format: "fb2", Date: now},
+		{ID: "n-5", Title: "Отдельная", Authors: []string{"Автор"}, Format: "fb2", Date: now},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		filter storage.BookFilter
+		want   []string
+	}{
+		{"first_in_series", storage.BookFilter{FirstInSeries: true}, []string{"n-4", "n-1"}},
+		{"from", storage.BookFilter{SeriesNumFrom: 2}, []string{"n-2", "n-3"}},
+		{"to", storage.BookFilter{SeriesNumTo: 2}, []string{"n-4", "n-1", "n-2"}},
+		{"range", storage.BookFilter{SeriesNumFrom: 2, SeriesNumTo: 2}, []string{"n-2"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.SortBy, tc.filter.SortOrder = "series", "asc"
+			result, err := repo.SearchBooks(tc.filter)
+			if err != nil {
+				t.Fatalf("SearchBooks failed: %v", err)
+			}
+			var got []string
+			for _, b := range result.Books {
+				got = append(got, b.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") || result.Total != len(tc.want) {
+				t.Errorf("expected %v, got %v (total %d)", tc.want, got, result.Total)
+			}
+		})
+	}
+}
+
+func TestRunMaintenance(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := storage.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+
+	var books []inpx.Book
+	for i := 0; i < 200; i++ {
+		books = append(books, inpx.Book{
+			ID: fmt.Sprintf("mt-%d", i), Title: strings.Repeat("Книга ", 50), Authors: []string{"Автор"},
+			Language: "ru", ArchivePath: "a", Format: "fb2", Date: time.Now(),
+		})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+
+	result, err := repo.RunMaintenance(true)
+	if err != nil {
+		t.Fatalf("RunMaintenance failed: %v", err)
+	}
+	if !result.Vacuumed {
+		t.Error("expected vacuum to run")
+	}
+	if result.DBSizeBefore == 0 || result.DBSizeAfter == 0 {
+		t.Errorf("expected database sizes to be reported: %+v", result)
+	}
+	if result.WALSizeAfter != 0 {
+		t.Errorf("expected WAL to be truncated, got %d bytes", result.WALSizeAfter)
+	}
+	if result.Reclaimed <= 0 {
+		t.Errorf("expected space to be reclaimed after clearing books: %+v", result)
+	}
+}
+
+func TestInsertBooksBatches(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewRepository(db)
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+
+	// Several full batches plus a remainder, and a repeated ID inside one batch.
+	books := generateBenchmarkBooks(1234)
+	dup := books[10]
+	dup.Title = "Обновлённое название"
+	dup.Authors = []string{"Новый Автор"}
+	books = append(books[:20], append([]inpx.Book{dup}, books[20:]...)...)
+
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	list, err := repo.SearchBooks(storage.BookFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if list.Total != 1234 {
+		t.Fatalf("expected 1234 books, got %d", list.Total)
+	}
+
+	book, err := repo.GetBookByID(dup.ID)
+	if err != nil || book == nil {
+		t.Fatalf("failed to load book %s: %v", dup.ID, err)
+	}
+	if book.Title != dup.Title {
+		t.Errorf("expected later duplicate to win, got title %q", book.Title)
+	}
+	if len(book.Authors) != 1 || book.Authors[0].Name != "Новый Автор" {
+		t.Errorf("unexpected authors for replaced book: %+v", book.Authors)
+	}
+
+	found, err := repo.SearchBooks(storage.BookFilter{Query: "Обновлённое", Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if found.Total != 1 {
+		t.Errorf("expected a single FTS match for replaced book, got %d", found.Total)
+	}
+
+	last, err := repo.GetBookByID(books[len(books)-1].ID)
+	if err != nil || last == nil {
+		t.Fatalf("book from the last partial batch is missing: %v", err)
+	}
+	if len(last.Authors) != 2 {
+		t.Errorf("expected 2 authors, got %d", len(last.Authors))
+	}
+
+	var indexes int
+	if err := db.DB().QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_books_%'",
+	).Scan(&indexes); err != nil {
+		t.Fatalf("failed to count indexes: %v", err)
+	}
+	if indexes != 8 {
+		t.Errorf("expected book indexes to be rebuilt, found %d", indexes)
+	}
+
+	// Inserting again without clearing must replace FTS rows, not duplicate them.
+	if err := repo.InsertBooks(books[:600]); err != nil {
+		t.Fatalf("failed to re-insert books: %v", err)
+	}
+	found, err = repo.SearchBooks(storage.BookFilter{Query: "Обновлённое", Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if found.Total != 1 {
+		t.Errorf("expected a single FTS match after re-insert, got %d", found.Total)
+	}
+}
+
+func generateBenchmarkBooks(n int) []inpx.Book {
+	books := make([]inpx.Book, n)
+	now := time.Now()
+	for i := range books {
+		books[i] = inpx.Book{
+			ID:          fmt.Sprintf("bench-%d", i),
+			Title:       fmt.Sprintf("Книга номер %d", i),
+			Authors:     []string{fmt.Sprintf("Автор %d", i%5000), fmt.Sprintf("Соавтор %d", i%700)},
+			Series:      fmt.Sprintf("Серия %d", i%2000),
+			SeriesNum:   i % 12,
+			Genre:       fmt.Sprintf("genre_%d", i%150),
+			Year:        1900 + i%120,
+			Language:    "ru",
+			FileSize:    int64(100000 + i),
+			ArchivePath: fmt.Sprintf("fb2-%06d.zip", i/1000),
+			FileNum:     fmt.Sprintf("%d", i),
+			Format:      "fb2",
+			Date:        now,
+			Rating:      i % 6,
+			Annotation:  "Аннотация к книге для проверки скорости индексации.",
+		}
+	}
+	return books
+}
+
+// BenchmarkInsertBooks loads 50k books into a cleared library; set
+// BENCH_BOOKS to load another number, such as 1000000 for a full catalog.
+func BenchmarkInsertBooks(b *testing.B) {
+	n := 50000
+	if s := os.Getenv("BENCH_BOOKS"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			b.Fatalf("invalid BENCH_BOOKS %q", s)
+		}
+	}
+	books := generateBenchmarkBooks(n)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := storage.NewDatabase(filepath.Join(b.TempDir(), "bench.db"))
+		if err != nil {
+			b.Fatalf("failed to create database: %v", err)
+		}
+		repo := storage.NewRepository(db)
+		// Reindex always clears the library before a bulk insert.
+		if err := repo.ClearAllBooks(); err != nil {
+			b.Fatalf("failed to clear books: %v", err)
+		}
+		b.StartTimer()
+
+		if err := repo.InsertBooks(books); err != nil {
+			b.Fatalf("failed to insert books: %v", err)
+		}
+
+		b.StopTimer()
+		db.Close()
+		b.StartTimer()
+	}
+}
+
+func TestFeaturedBooks(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	var books []inpx.Book
+	for _, id := range []string{"f-1", "f-2", "f-3", "f-4"} {
+		books = append(books, inpx.Book{ID: id, Title: "Книга " + id, Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	featured := func() []string {
+		t.Helper()
+		result, err := repo.SearchBooks(storage.BookFilter{Featured: true, SortBy: "featured"})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, book := range result.Books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		if got := featured(); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("featured books = %v, want %v", got, want)
+		}
+	}
+
+	for _, id := range []string{"f-3", "f-1"} {
+		if err := repo.FeatureBook(id, 0); err != nil {
+			t.Fatalf("FeatureBook(%s) failed: %v", id, err)
+		}
+	}
+	expect("f-3", "f-1")
+
+	// Insert at the top, then move a book down
+	if err := repo.FeatureBook("f-4", 1); err != nil {
+		t.Fatalf("FeatureBook failed: %v", err)
+	}
+	expect("f-4", "f-3", "f-1")
+	if err := repo.FeatureBook("f-4", 2); err != nil {
+		t.Fatalf("FeatureBook failed: %v", err)
+	}
+	expect("f-3", "f-4", "f-1")
+	if err := repo.FeatureBook("missing", 0); err != storage.ErrBookNotFound {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+
+	if removed, err := repo.UnfeatureBook("f-3"); err != nil || !removed {
+		t.Fatalf("UnfeatureBook = %v, %v", removed, err)
+	}
+	if removed, _ := repo.UnfeatureBook("f-3"); removed {
+		t.Error("second UnfeatureBook reported a removal")
+	}
+	expect("f-4", "f-1")
+
+	if err := repo.SetFeaturedBooks([]string{"f-2", "f-1", "f-2"}); err != nil {
+		t.Fatalf("SetFeaturedBooks failed: %v", err)
+	}
+	expect("f-2", "f-1")
+	if err := repo.SetFeaturedBooks([]string{"f-3", "missing"}); !errors.Is(err, storage.ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+	expect("f-2", "f-1")
+
+	// Picks survive reindexing; hidden books drop out of the section
+	if err := repo.ClearAllBooks(); err != nil {
+		t.Fatalf("failed to clear books: %v", err)
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to reinsert books: %v", err)
+	}
+	if err := repo.HideBook("f-2"); err != nil {
+		t.Fatalf("HideBook failed: %v", err)
+	}
+	expect("f-1")
+}
+
+func TestListBookCounts(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	books := []inpx.Book{
+		{ID: "c-1", Title: "Первая", Authors: []string{"Автор"}, Series: "Серия", Genre: "prose", Language: "ru", Format: "fb2", Date: time.Now()},
+		{ID: "c-2", Title: "Вторая", Authors: []string{"Автор", "Соавтор"}, Series: "Серия", Genre: "prose", Language: "en", Format: "fb2", Date: time.Now()},
+		{ID: "c-3", Title: "Третья", Authors: []string{"Автор"}, Series: "Серия", Genre: "prose", Language: "ru", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	// Hidden books are not counted
+	if err := repo.HideBook("c-3"); err != nil {
+		t.Fatalf("HideBook failed: %v", err)
+	}
+
+	authors, _, err := repo.ListAuthors(10, 0)
+	if err != nil {
+		t.Fatalf("ListAuthors failed: %v", err)
+	}
+	counts := map[string]int{}
+	for _, author := range authors {
+		counts[author.Name] = author.BookCount
+	}
+	if counts["Автор"] != 2 || counts["Соавтор"] != 1 {
+		t.Errorf("unexpected author counts %v", counts)
+	}
+
+	if authors, _, err := repo.ListAuthorsInLanguage("ru", 10, 0); err != nil || len(authors) != 1 || authors[0].BookCount != 1 {
+		t.Errorf("ListAuthorsInLanguage(ru) = %+v, %v; want Автор with 1 book", authors, err)
+	}
+	if series, _, err := repo.ListSeries(10, 0); err != nil || len(series) != 1 || series[0].BookCount != 2 {
+		t.Errorf("ListSeries = %+v, %v; want 2 books", series, err)
+	}
+	if genres, _, err := repo.ListGenresInLanguage("en", 10, 0, nil); err != nil || len(genres) != 1 || genres[0].BookCount != 1 {
+		t.Errorf("ListGenresInLanguage(en) = %+v, %v; want 1 book", genres, err)
+	}
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// savedSearchColumns selects a saved search
+const savedSearchColumns = "id, user_id, name, filter, notify, email, seen_at, notified_at, created_at"
+
+// CreateSavedSearch saves the filter of a search with its user, name,
+// notify and email set. Paging, shelves and restrictions are not part of a
+// search and are dropped; an unsearchable query fails with ErrInvalidQuery.
+func (r *Repository) CreateSavedSearch(search SavedSearch) (*SavedSearch, error) {
+	filter := search.Filter
+	if err := validateSearchQuery(filter.Query); err != nil {
+		return nil, err
+	}
+	filter.Limit, filter.Offset = 0, 0
+	filter.Shelf = ""
+	filter.ChangedAfter = nil
+	filter.WithAnnotations = false
+	filter.Hidden = nil
+
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode saved search: %w", err)
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, fmt.Errorf("generate saved search id: %w", err)
+	}
+	now := time.Now()
+	search.ID, search.Filter, search.NewBooks = id, filter, 0
+	search.SeenAt, search.NotifiedAt, search.CreatedAt = now, nil, now
+	if _, err := r.db.db.Exec(
+		"INSERT INTO saved_searches ("+savedSearchColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		search.ID, search.UserID, search.Name, string(data), search.Notify, search.Email,
+		search.SeenAt, search.NotifiedAt, search.CreatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to create saved search: %w", err)
+	}
+	return &search, nil
+}
+
+// scanSavedSearch scans a row of savedSearchColumns
+func scanSavedSearch(scan func(dest ...interface{}) error) (*SavedSearch, error) {
+	var search SavedSearch
+	var data string
+	var notified sql.NullTime
+	if err := scan(&search.ID, &search.UserID, &search.Name, &data, &search.Notify, &search.Email,
+		&search.SeenAt, &notified, &search.CreatedAt); err != nil {
+		return nil, err
+	}
+	if notified.Valid {
+		search.NotifiedAt = &notified.Time
+	}
+	if err := json.Unmarshal([]byte(data), &search.Filter); err != nil {
+		return nil, fmt.Errorf("failed to decode saved search %s: %w", search.ID, err)
+	}
+	return &search, nil
+}
+
+// ListSavedSearches returns the saved searches of a user by name, without
+// their numbers of new books; see CountNewBooks.
+func (r *Repository) ListSavedSearches(userID string) ([]SavedSearch, error) {
+	return r.querySavedSearches("WHERE user_id = ? ORDER BY LOWER(name), id", userID)
+}
+
+// ListNotifySearches returns the saved searches of all users that have
+// notify set, by user and name
+func (r *Repository) ListNotifySearches() ([]SavedSearch, error) {
+	return r.querySavedSearches("WHERE notify = 1 ORDER BY user_id, LOWER(name), id")
+}
+
+// querySavedSearches returns the saved searches selected by the WHERE and
+// ORDER BY clauses given
+func (r *Repository) querySavedSearches(clauses string, args ...interface{}) ([]SavedSearch, error) {
+	rows, err := r.db.db.Query("SELECT "+savedSearchColumns+" FROM saved_searches "+clauses, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan saved search: %w", err)
+		}
+		searches = append(searches, *search)
+	}
+	return searches, rows.Err()
+}
+
+// GetSavedSearch returns a saved search by ID, or nil if not found
+func (r *Repository) GetSavedSearch(id string) (*SavedSearch, error) {
+	search, err := scanSavedSearch(r.db.db.QueryRow(
+		"SELECT "+savedSearchColumns+" FROM saved_searches WHERE id = ?", id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved search: %w", err)
+	}
+	return search, nil
+}
+
+// DeleteSavedSearch deletes a saved search; it reports false if it did not
+// exist.
+func (r *Repository) DeleteSavedSearch(id string) (bool, error) {
+	result, err := r.db.db.Exec("DELETE FROM saved_searches WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete saved search: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// MarkSavedSearchSeen records that a saved search was run now, so that
+// only books changed later count as new. A read-only instance keeps the
+// earlier time.
+func (r *Repository) MarkSavedSearchSeen(search *SavedSearch) error {
+	if r.ReadOnly() {
+		return nil
+	}
+	now := time.Now()
+	if _, err := r.db.db.Exec("UPDATE saved_searches SET seen_at = ? WHERE id = ?", now, search.ID); err != nil {
+		return fmt.Errorf("failed to update saved search: %w", err)
+	}
+	search.SeenAt = now
+	search.NewBooks = 0
+	return nil
+}
+
+// SavedSearchDeliveries returns, by saved search of a user and then by
+// destination, when books were last delivered there.
+func (r *Repository) SavedSearchDeliveries(userID string) (map[string]map[string]time.Time, error) {
+	rows, err := r.db.db.Query(`
+		SELECT d.search_id, d.destination, d.delivered_at
+		FROM saved_search_deliveries d
+		JOIN saved_searches s ON s.id = d.search_id
+		WHERE s.user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved search deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make(map[string]map[string]time.Time)
+	for rows.Next() {
+		var searchID, destination string
+		var at time.Time
+		if err := rows.Scan(&searchID, &destination, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan saved search delivery: %w", err)
+		}
+		if deliveries[searchID] == nil {
+			deliveries[searchID] = make(map[string]time.Time)
+		}
+		deliveries[searchID][destination] = at
+	}
+	return deliveries, rows.Err()
+}
+
+// MarkSavedSearchesDelivered records that the books of saved searches
+// changed up to at were sent to destination, and sets their notified_at.
+func (r *Repository) MarkSavedSearchesDelivered(ids []string, destination string, at time.Time) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`
+			INSERT INTO saved_search_deliveries (search_id, destination, delivered_at) VALUES (?, ?, ?)
+			ON CONFLICT(search_id, destination) DO UPDATE SET delivered_at = excluded.delivered_at`,
+			id, destination, at,
+		); err != nil {
+			return fmt.Errorf("failed to record saved search delivery: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE saved_searches SET notified_at = ? WHERE id = ?", at, id); err != nil {
+			return fmt.Errorf("failed to update saved search: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// PendingSince returns the time after which changed books of a search are
+// yet to be sent to a destination last delivered to at delivered (zero if
+// never): when the search was last run or delivered, whichever is later.
+func (s SavedSearch) PendingSince(delivered time.Time) time.Time {
+	if delivered.After(s.SeenAt) {
+		return delivered
+	}
+	return s.SeenAt
+}
+
+// CountNewBooks sets NewBooks of the saved searches that ask for it to the
+// number of their books added or changed since they were last run, leaving
+// out the books hidden by restrictions.
+func (r *Repository) CountNewBooks(searches []SavedSearch, hidden *Restrictions) error {
+	for i := range searches {
+		if !searches[i].Notify {
+			continue
+		}
+		filter := searches[i].Filter
+		filter.ChangedAfter = &searches[i].SeenAt
+		filter.Limit = 1
+		filter.Hidden = hidden
+		result, err := r.SearchBooks(filter)
+		if err != nil {
+			return fmt.Errorf("failed to count new books of saved search %s: %w", searches[i].ID, err)
+		}
+		searches[i].NewBooks = result.Total
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package storage_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestSavedSearches(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "q-1", Title: "Дюна", Authors: []string{"Херберт"}, Genre: "sf", Format: "fb2", Date: time.Now()},
+		{ID: "q-2", Title: "Анна Каренина", Authors: []string{"Толстой"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	if _, err := repo.CreateSavedSearch(storage.SavedSearch{UserID: "user-1", Name: "Пусто", Filter: storage.BookFilter{Query: "!!!"}}); !errors.Is(err, storage.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+
+	search, err := repo.CreateSavedSearch(storage.SavedSearch{
+		UserID: "user-1", Name: "Фантастика", Filter: storage.BookFilter{Genres: []string{"sf"}, Limit: 5, Shelf: "x"}, Notify: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateSavedSearch failed: %v", err)
+	}
+	if search.Filter.Limit != 0 || search.Filter.Shelf != "" {
+		t.Errorf("expected paging and shelf dropped, got %+v", search.Filter)
+	}
+	if _, err := repo.CreateSavedSearch(storage.SavedSearch{UserID: "user-2", Name: "Чужая"}); err != nil {
+		t.Fatalf("CreateSavedSearch failed: %v", err)
+	}
+
+	list, err := repo.ListSavedSearches("user-1")
+	if err != nil {
+		t.Fatalf("ListSavedSearches failed: %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "Фантастика" || list[0].Filter.Genres[0] != "sf" || !list[0].Notify {
+		t.Fatalf("unexpected saved searches %+v", list)
+	}
+
+	// Nothing changed since the search was saved
+	if err := repo.CountNewBooks(list, nil); err != nil {
+		t.Fatalf("CountNewBooks failed: %v", err)
+	}
+	if list[0].NewBooks != 0 {
+		t.Errorf("expected no new books, got %d", list[0].NewBooks)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "q-3", Title: "Солярис", Authors: []string{"Лем"}, Genre: "sf", Format: "fb2", Date: time.Now()},
+		{ID: "q-4", Title: "Война и мир", Authors: []string{"Толстой"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	if err := repo.CountNewBooks(list, nil); err != nil {
+		t.Fatalf("CountNewBooks failed: %v", err)
+	}
+	if list[0].NewBooks != 1 {
+		t.Errorf("expected 1 new book, got %d", list[0].NewBooks)
+	}
+
+	if err := repo.MarkSavedSearchSeen(&list[0]); err != nil {
+		t.Fatalf("MarkSavedSearchSeen failed: %v", err)
+	}
+	got, err := repo.GetSavedSearch(search.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetSavedSearch failed: %v", err)
+	}
+	seen := []storage.SavedSearch{*got}
+	if err := repo.CountNewBooks(seen, nil); err != nil || seen[0].NewBooks != 0 {
+		t.Errorf("expected no new books after the search was run, got %d (%v)", seen[0].NewBooks, err)
+	}
+
+	if deleted, err := repo.DeleteSavedSearch(search.ID); err != nil || !deleted {
+		t.Errorf("DeleteSavedSearch = %v, %v", deleted, err)
+	}
+	if got, _ := repo.GetSavedSearch(search.ID); got != nil {
+		t.Errorf("expected the saved search deleted, got %+v", got)
+	}
+}
@@ -1,7 +1,8 @@
 -- Authors table
 CREATE TABLE IF NOT EXISTS authors (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT UNIQUE NOT NULL
+    name TEXT UNIQUE NOT NULL,
+    source_id TEXT NOT NULL DEFAULT ''
 );
 
 -- Genres table
@@ -31,13 +32,19 @@ CREATE TABLE IF NOT EXISTS books (
     format TEXT,
     date_added DATETIME,
     rating INTEGER,
-    annotation TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (series_id) REFERENCES series(id),
     FOREIGN KEY (genre_id) REFERENCES genres(id)
 );
 
+-- Book annotations, kept out of books so that scans of the catalog stay
+-- small; only non-empty annotations have a row
+CREATE TABLE IF NOT EXISTS book_annotations (
+    book_id TEXT PRIMARY KEY,
+    annotation TEXT NOT NULL
+);
+
 -- Book authors junction table (many-to-many)
 CREATE TABLE IF NOT EXISTS book_authors (
     book_id TEXT,
@@ -55,8 +62,44 @@ CREATE INDEX IF NOT EXISTS idx_books_year ON books(year);
 CREATE INDEX IF NOT EXISTS idx_books_language ON books(language);
 CREATE INDEX IF NOT EXISTS idx_books_format ON books(format);
 CREATE INDEX IF NOT EXISTS idx_books_date_added ON books(date_added);
+CREATE INDEX IF NOT EXISTS idx_books_updated_at ON books(updated_at);
+
+-- Series a book belongs to besides its main one (books.series_id); FB2
+-- allows several <sequence> elements
+CREATE TABLE IF NOT EXISTS book_series (
+    book_id TEXT NOT NULL,
+    series_id INTEGER NOT NULL,
+    series_num INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, series_id),
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_series_series ON book_series(series_id);
+
+-- Genres of a book in INPX order, one row per genre code; the first is
+-- also books.genre_id
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id TEXT NOT NULL,
+    genre_id INTEGER NOT NULL,
+    PRIMARY KEY (book_id, genre_id),
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (genre_id) REFERENCES genres(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_genres_genre ON book_genres(genre_id);
+
+-- When the catalog data of each book last changed. A reindex recreates the
+-- books rows, so the time is kept here, keyed by a hash of the data, and
+-- copied to books.updated_at; it survives ClearAllBooks.
+CREATE TABLE IF NOT EXISTS book_dates (
+    book_id TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+);
 
 CREATE INDEX IF NOT EXISTS idx_authors_name ON authors(name);
+CREATE INDEX IF NOT EXISTS idx_book_authors_author ON book_authors(author_id);
 CREATE INDEX IF NOT EXISTS idx_genres_name ON genres(name);
 CREATE INDEX IF NOT EXISTS idx_series_name ON series(name);
 
@@ -81,12 +124,146 @@ CREATE INDEX IF NOT EXISTS idx_reading_positions_status ON reading_positions(sta
 CREATE INDEX IF NOT EXISTS idx_reading_positions_updated ON reading_positions(updated_at);
 CREATE INDEX IF NOT EXISTS idx_reading_positions_user ON reading_positions(user_id);
 
+-- Manual metadata corrections (survive reindex, re-applied after import).
+-- NULL columns mean "not overridden". No FK: rows must outlive ClearAllBooks.
+CREATE TABLE IF NOT EXISTS book_overrides (
+    book_id TEXT PRIMARY KEY,
+    title TEXT,
+    annotation TEXT,
+    genre TEXT,
+    series TEXT,
+    series_num INTEGER,
+    rating INTEGER,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    -- Set while the book is hidden from the catalog
+    hidden_at DATETIME
+);
+
+-- Archives whose books are hidden from the catalog, kept across reindex
+CREATE TABLE IF NOT EXISTS hidden_archives (
+    archive_path TEXT PRIMARY KEY,
+    hidden_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Manual author merges, keyed by name so they can be re-applied after reindex
+CREATE TABLE IF NOT EXISTS author_merges (
+    source_name TEXT PRIMARY KEY,
+    target_name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- INP lines skipped during the last import
+CREATE TABLE IF NOT EXISTS import_errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    file TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    content TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Genre codes the last import could not map to canonical codes
+CREATE TABLE IF NOT EXISTS unmapped_genres (
+    code TEXT PRIMARY KEY,
+    books INTEGER NOT NULL
+);
+
+-- Books whose file in its archive has another size than the INPX says,
+-- found by the last file size check
+CREATE TABLE IF NOT EXISTS size_mismatches (
+    book_id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    archive_path TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    catalog_size INTEGER NOT NULL,
+    actual_size INTEGER NOT NULL,
+    fixed INTEGER NOT NULL DEFAULT 0
+);
+
+-- Librarian-curated tags, independent of INPX genres.
+-- book_tags has no FK on books so tags survive reindex.
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS book_tags (
+    book_id TEXT NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (book_id, tag_id),
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_tags_tag ON book_tags(tag_id);
+
+-- Books picked by admins for the "Рекомендуем" section, in display order
+-- (position 1 first). No FK on books, so picks survive reindex.
+CREATE TABLE IF NOT EXISTS featured_books (
+    book_id TEXT PRIMARY KEY,
+    position INTEGER NOT NULL,
+    featured_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Reading lists of users; user_id is empty when auth is disabled.
+-- share_token is the random token of the shared OPDS feed of a shelf, NULL
+-- while the shelf is not shared.
+CREATE TABLE IF NOT EXISTS shelves (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    share_token TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_shelves_user ON shelves(user_id);
+
+-- Book filters users saved under a name, as JSON. seen_at is when the
+-- search was last run; books changed since then count as new. notified_at
+-- is when new books were last sent to email or any notification webhook.
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    filter TEXT NOT NULL,
+    notify INTEGER NOT NULL DEFAULT 0,
+    email TEXT NOT NULL DEFAULT '',
+    seen_at DATETIME NOT NULL,
+    notified_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user ON saved_searches(user_id);
+
+-- When books of a saved search changed up to delivered_at were sent to one
+-- destination: "webhook:<url>" or "email:<address>"
+CREATE TABLE IF NOT EXISTS saved_search_deliveries (
+    search_id TEXT NOT NULL,
+    destination TEXT NOT NULL,
+    delivered_at DATETIME NOT NULL,
+    PRIMARY KEY (search_id, destination),
+    FOREIGN KEY (search_id) REFERENCES saved_searches(id) ON DELETE CASCADE
+);
+
+-- Books of a shelf in display order. No FK on books, so shelves survive
+-- reindex.
+CREATE TABLE IF NOT EXISTS shelf_books (
+    shelf_id TEXT NOT NULL,
+    book_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (shelf_id, book_id),
+    FOREIGN KEY (shelf_id) REFERENCES shelves(id) ON DELETE CASCADE
+);
+
 -- Users table (only used when AUTH_ENABLED=true)
 CREATE TABLE IF NOT EXISTS users (
     id TEXT PRIMARY KEY,
     username TEXT UNIQUE NOT NULL,
     password_hash TEXT NOT NULL,
     display_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
     is_admin INTEGER NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
@@ -104,14 +281,250 @@ CREATE TABLE IF NOT EXISTS sessions (
 CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
 
+-- API tokens of scripts and bots. Only a SHA-256 hash of each token is
+-- kept; scopes is a comma-separated list of read, download and admin.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    prefix TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME,
+    last_used_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);
+
+-- Download formats users prefer, listed first in acquisition links. A
+-- token_id of '' is the preference of the user; a preference of an API
+-- token overrides it for the client using that token.
+CREATE TABLE IF NOT EXISTS format_preferences (
+    user_id TEXT NOT NULL DEFAULT '',
+    token_id TEXT NOT NULL DEFAULT '',
+    format TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, token_id)
+);
+
 -- Full-text search will be implemented later when FTS5 is available
 
--- Full-text search virtual table
+-- Full-text search virtual table. It is contentless: the text is only
+-- tokenized, books_fts_ids maps its rowids to books.
 CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
-    book_id UNINDEXED,
     title,
     annotation,
     authors,
     series,
-    tokenize='unicode61'
+    content='',
+    contentless_delete=1,
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+-- The rowid of a book in books_fts. It outlives REPLACE of the book row,
+-- which would give books.rowid a new value.
+CREATE TABLE IF NOT EXISTS books_fts_ids (
+    id INTEGER PRIMARY KEY,
+    book_id TEXT NOT NULL UNIQUE
+);
+
+-- Author biographies and portraits fetched from external sources.
+-- Keyed by name so the cache survives reindex; found=0 caches misses.
+CREATE TABLE IF NOT EXISTS author_info (
+    author_name TEXT PRIMARY KEY,
+    found INTEGER NOT NULL DEFAULT 0,
+    bio TEXT,
+    photo_url TEXT,
+    source_url TEXT,
+    wikidata_id TEXT,
+    fetched_at DATETIME NOT NULL
+);
+
+-- Embedded cover extraction results, filled progressively by a background
+-- job. No FK on books so already processed books are not re-scanned after
+-- reindex.
+CREATE TABLE IF NOT EXISTS book_covers (
+    book_id TEXT PRIMARY KEY,
+    has_cover INTEGER NOT NULL DEFAULT 0,
+    -- Content hash of the thumbnail, part of the cover URL
+    hash TEXT,
+    checked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Search vocabulary for "did you mean" suggestions: words from titles,
+-- author and series names, indexed by their trigrams. Rebuilt after import.
+CREATE TABLE IF NOT EXISTS search_terms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    term TEXT UNIQUE NOT NULL,
+    length INTEGER NOT NULL,
+    weight INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS search_trigrams (
+    trigram TEXT NOT NULL,
+    term_id INTEGER NOT NULL,
+    PRIMARY KEY (trigram, term_id)
+) WITHOUT ROWID;
+
+-- SHA-256 of book files, computed on first download or by verification.
+-- No FK on books so checksums survive reindex and reveal changed archives.
+CREATE TABLE IF NOT EXISTS book_checksums (
+    book_id TEXT PRIMARY KEY,
+    sha256 TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    computed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Change log served to mirrors: the latest change of every book, numbered
+-- by a global sequence. Maintained by diffing row fingerprints, so a reindex
+-- only logs books that actually changed.
+CREATE TABLE IF NOT EXISTS sync_state (
+    book_id TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    seq INTEGER NOT NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_state_seq ON sync_state(seq);
+
+-- Progress of this instance mirroring other instances
+CREATE TABLE IF NOT EXISTS sync_cursors (
+    source TEXT PRIMARY KEY,
+    cursor INTEGER NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- External OPDS catalogs aggregated into this one, and the entries crawled
+-- from them. Entries are replaced wholesale on every crawl; key is a hash of
+-- the upstream entry id so links to an entry stay stable across crawls.
+CREATE TABLE IF NOT EXISTS upstream_sources (
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    url TEXT NOT NULL,
+    entries INTEGER NOT NULL DEFAULT 0,
+    crawled_at DATETIME,
+    last_error TEXT
+);
+
+CREATE TABLE IF NOT EXISTS upstream_entries (
+    source_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    entry_id TEXT NOT NULL,
+    title TEXT NOT NULL,
+    authors TEXT,
+    summary TEXT,
+    language TEXT,
+    updated DATETIME,
+    links TEXT NOT NULL,
+    PRIMARY KEY (source_id, key)
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS upstream_entries_fts USING fts5(
+    source_id UNINDEXED,
+    key UNINDEXED,
+    title,
+    annotation,
+    authors,
+    series,
+    tokenize='unicode61 remove_diacritics 2'
 );
+
+-- Access rules: books of a restricted genre or tag are hidden from anonymous
+-- users, and from users without the rule's role when it has one
+CREATE TABLE IF NOT EXISTS access_rules (
+    kind TEXT NOT NULL CHECK (kind IN ('genre', 'tag')),
+    name TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kind, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id TEXT NOT NULL,
+    role TEXT NOT NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+-- Download audit log, pruned by age and size
+CREATE TABLE IF NOT EXISTS download_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    downloaded_at DATETIME NOT NULL,
+    book_id TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    user_id TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    bytes INTEGER NOT NULL DEFAULT 0,
+    complete INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_log_time ON download_log(downloaded_at);
+CREATE INDEX IF NOT EXISTS idx_download_log_book ON download_log(book_id);
+
+-- Author aliases (pseudonyms): books of an alias are listed with its
+-- canonical author. Keyed by name so they survive reindex.
+CREATE TABLE IF NOT EXISTS author_aliases (
+    alias_name TEXT PRIMARY KEY,
+    author_name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_author_aliases_author ON author_aliases(author_name);
+
+-- Admin splits of authors that share a name: the author author_name of
+-- book_id becomes the separate author target_name. Keyed by book and name
+-- so they can be re-applied after reindex.
+CREATE TABLE IF NOT EXISTS author_splits (
+    book_id TEXT NOT NULL,
+    author_name TEXT NOT NULL,
+    target_name TEXT NOT NULL,
+    source_id TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (book_id, author_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_author_splits_author ON author_splits(author_name);
+CREATE INDEX IF NOT EXISTS idx_author_splits_target ON author_splits(target_name);
+
+-- Author names an admin marked as possibly shared by several people
+CREATE TABLE IF NOT EXISTS ambiguous_authors (
+    author_name TEXT PRIMARY KEY,
+    note TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sizes of the author, series and genre lists, in all languages and in each
+-- one, and authors and series by first letter. Computed by the indexer after
+-- import and cleared by edits to the catalog.
+CREATE TABLE IF NOT EXISTS nav_stats (
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (kind, key)
+) WITHOUT ROWID;
+
+-- Books added by delta imports that the full INPX does not list yet;
+-- refreshing from the full INPX keeps them
+CREATE TABLE IF NOT EXISTS delta_books (
+    book_id TEXT PRIMARY KEY,
+    imported_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Search queries, normalized, with the number of books found. searcher is a
+-- salted hash that only tells searchers apart within its salt_window.
+-- Pruned by age.
+CREATE TABLE IF NOT EXISTS search_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    searched_at DATETIME NOT NULL,
+    query TEXT NOT NULL,
+    results INTEGER NOT NULL,
+    searcher TEXT NOT NULL,
+    salt_window TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_log_time ON search_log(searched_at);
+CREATE INDEX IF NOT EXISTS idx_search_log_query ON search_log(query);
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// benchmarkLibrarySize is the number of books the search benchmarks run on
+const benchmarkLibrarySize = 100000
+
+var (
+	benchLibraryOnce sync.Once
+	benchLibraryDir  string
+	benchLibrary     *Repository
+	benchLibraryErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchLibrary != nil {
+		benchLibrary.db.Close()
+	}
+	if benchLibraryDir != "" {
+		os.RemoveAll(benchLibraryDir)
+	}
+	os.Exit(code)
+}
+
+// syntheticBooks returns n books spread over 5000 authors, 2000 series,
+// 150 genres, four languages and a century of years
+func syntheticBooks(n int) []inpx.Book {
+	languages := []string{"ru", "ru", "uk", "en"}
+	words := []string{"война", "мир", "море", "город", "ночь", "дорога", "сад", "зима"}
+	books := make([]inpx.Book, n)
+	now := time.Now()
+	for i := range books {
+		books[i] = inpx.Book{
+			ID:          fmt.Sprintf("bench-%d", i),
+			Title:       fmt.Sprintf("%s и %s %d", words[i%len(words)], words[(i/7)%len(words)], i),
+			Authors:     []string{fmt.Sprintf("Автор %d", i%5000)},
+			Series:      fmt.Sprintf("Серия %d", i%2000),
+			SeriesNum:   i % 12,
+			Genre:       fmt.Sprintf("genre_%d", i%150),
+			Year:        1900 + i%120,
+			Language:    languages[i%len(languages)],
+			FileSize:    int64(100000 + i),
+			ArchivePath: fmt.Sprintf("fb2-%06d.zip", i/1000),
+			FileNum:     fmt.Sprintf("%d", i),
+			Format:      "fb2",
+			Date:        now.Add(-time.Duration(i) * time.Minute),
+			Rating:      i % 6,
+			Annotation:  "Аннотация: " + words[(i/3)%len(words)] + " " + words[(i/11)%len(words)],
+		}
+	}
+	return books
+}
+
+// benchmarkRepository returns a library of benchmarkLibrarySize books,
+// seeded once and shared by all benchmarks
+func benchmarkRepository(b *testing.B) *Repository {
+	b.Helper()
+	benchLibraryOnce.Do(func() {
+		benchLibraryDir, benchLibraryErr = os.MkdirTemp("", "pushkinlib-bench-")
+		if benchLibraryErr != nil {
+			return
+		}
+		db, err := NewDatabase(filepath.Join(benchLibraryDir, "bench.db"))
+		if err != nil {
+			benchLibraryErr = err
+			return
+		}
+		benchLibrary = NewRepository(db)
+		benchLibraryErr = benchLibrary.InsertBooks(syntheticBooks(benchmarkLibrarySize))
+	})
+	if benchLibraryErr != nil {
+		b.Fatalf("failed to seed benchmark library: %v", benchLibraryErr)
+	}
+	return benchLibrary
+}
+
+// benchmarkSearch runs filter uncached, so every iteration hits SQLite
+func benchmarkSearch(b *testing.B, filter BookFilter) {
+	repo := benchmarkRepository(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list, err := repo.searchBooksUncached(filter)
+		if err != nil {
+			b.Fatalf("search failed: %v", err)
+		}
+		if list.Total == 0 {
+			b.Fatalf("search %+v found nothing", filter)
+		}
+	}
+}
+
+func BenchmarkSearchBooksFTS(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Query: "война мир", Limit: 30})
+}
+
+func BenchmarkSearchBooksFTSPrefix(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Query: "дорог", Limit: 30})
+}
+
+func BenchmarkSearchBooksAuthor(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Authors: []string{"Автор 42"}, Limit: 30})
+}
+
+func BenchmarkSearchBooksAuthorAndQuery(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Query: "город", Authors: []string{"Автор 42"}, Limit: 30})
+}
+
+func BenchmarkSearchBooksDeepPage(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Limit: 30, Offset: benchmarkLibrarySize - 1000})
+}
+
+func BenchmarkSearchBooksDeepPageFiltered(b *testing.B) {
+	benchmarkSearch(b, BookFilter{Languages: []string{"ru"}, Limit: 30, Offset: 40000, SortBy: "date_added", SortOrder: "desc"})
+}
+
+// queryPlan returns the EXPLAIN QUERY PLAN details of query
+func queryPlan(t *testing.T, r *Repository, query string, args []interface{}) []string {
+	t.Helper()
+	rows, err := r.db.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatalf("failed to explain %s: %v", query, err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatalf("failed to scan plan: %v", err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("error iterating plan: %v", err)
+	}
+	return plan
+}
+
+// TestSearchQueryPlans guards the search SQL against full scans of books:
+// each filter must reach the books through an index.
+func TestSearchQueryPlans(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "plans.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	if err := repo.InsertBooks(syntheticBooks(2000)); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		filter BookFilter
+		// want are plan steps the result and count queries must both use
+		want []string
+	}{
+		{name: "fts", filter: BookFilter{Query: "война"}, want: []string{"SCAN books_fts VIRTUAL TABLE", "SEARCH fi USING INTEGER PRIMARY KEY (rowid=?)", "SEARCH b USING INDEX sqlite_autoindex_books_1 (id=?)"}},
+		{name: "author", filter: BookFilter{Authors: []string{"Автор 1"}}, want: []string{"USING INDEX idx_book_authors_author (author_id=?)"}},
+		{name: "series", filter: BookFilter{Series: []string{"Серия 1"}}, want: []string{"SEARCH b USING INDEX idx_books_series"}},
+		{name: "genre", filter: BookFilter{Genres: []string{"genre_1"}}, want: []string{"USING INDEX idx_book_genres_genre (genre_id=?)"}},
+		{name: "language", filter: BookFilter{Languages: []string{"uk"}}, want: []string{"SEARCH b USING INDEX idx_books_language"}},
+		{name: "years", filter: BookFilter{YearFrom: 1950, YearTo: 1960}, want: []string{"SEARCH b USING INDEX idx_books_year"}},
+		{name: "tag", filter: BookFilter{Tags: []string{"favorite"}}, want: []string{"USING INDEX idx_book_tags_tag (tag_id=?)"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, queryArgs, countQuery, countArgs := repo.buildSearchSQL(tc.filter, true)
+			for _, q := range []struct {
+				sql  string
+				args []interface{}
+			}{{query, queryArgs}, {countQuery, countArgs}} {
+				plan := queryPlan(t, repo, q.sql, q.args)
+				joined := strings.Join(plan, "\n")
+				for _, step := range plan {
+					if step == "SCAN b" || strings.HasPrefix(step, "SCAN b ") {
+						t.Errorf("full scan of books:\n%s", joined)
+					}
+				}
+				for _, want := range tc.want {
+					if !strings.Contains(joined, want) {
+						t.Errorf("plan lacks %q:\n%s", want, joined)
+					}
+				}
+			}
+		})
+	}
+
+	// Unfiltered pages walk the title index instead of sorting every book
+	query, args, _, _ := repo.buildSearchSQL(BookFilter{Offset: 1000}, true)
+	if plan := strings.Join(queryPlan(t, repo, query, args), "\n"); !strings.Contains(plan, "SCAN b USING INDEX idx_books_title") ||
+		strings.Contains(plan, "USE TEMP B-TREE FOR ORDER BY") {
+		t.Errorf("default order does not use the title index:\n%s", plan)
+	}
+}
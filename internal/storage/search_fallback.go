@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Ways a search that found nothing is retried, from the closest to the
+// query as typed
+const (
+	// FallbackAnyField searches the words of field prefixes such as
+	// "author:" in every field
+	FallbackAnyField = "any_field"
+	// FallbackTransliteration searches Latin words in Cyrillic and the
+	// other way round
+	FallbackTransliteration = "transliteration"
+	// FallbackFuzzy replaces misspelled words by similar words from the
+	// library, as the suggestions do
+	FallbackFuzzy = "fuzzy"
+)
+
+// SetSearchFallback enables retrying searches that found nothing with
+// relaxed queries, see searchFallback.
+func (r *Repository) SetSearchFallback(enabled bool) {
+	r.fallbackEnabled.Store(enabled)
+}
+
+// SearchFallbackEnabled reports whether searches are retried.
+func (r *Repository) SearchFallbackEnabled() bool {
+	return r.fallbackEnabled.Load()
+}
+
+// searchFallback retries a search that found nothing with ever more relaxed
+// queries: without field prefixes, transliterated, and with misspelled
+// words corrected. It returns the books of the first query that finds any,
+// marked as a fallback, or nil.
+func (r *Repository) searchFallback(filter BookFilter) (*BookList, error) {
+	type attempt struct{ strategy, query string }
+	var attempts []attempt
+
+	base := filter.Query
+	if relaxed := withoutSearchFields(base); relaxed != "" {
+		attempts = append(attempts, attempt{FallbackAnyField, relaxed})
+		base = relaxed
+	}
+	queries := []string{base}
+	if transliterated := transliterateQuery(base); transliterated != base {
+		attempts = append(attempts, attempt{FallbackTransliteration, transliterated})
+		queries = append(queries, transliterated)
+	}
+	if r.suggestionsEnabled.Load() {
+		for _, query := range queries {
+			suggestions, err := r.SuggestQueries(query, maxSuggestions)
+			if err != nil {
+				return nil, err
+			}
+			for _, suggestion := range suggestions {
+				attempts = append(attempts, attempt{FallbackFuzzy, suggestion})
+			}
+		}
+	}
+
+	for _, a := range attempts {
+		probe := filter
+		probe.Query = a.query
+		list, err := r.searchBooks(probe, true)
+		if err != nil && isFTSQueryError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if list.Total > 0 {
+			list.Fallback = true
+			list.FallbackQuery = a.query
+			list.FallbackStrategy = a.strategy
+			return list, nil
+		}
+	}
+	return nil, nil
+}
+
+// withoutSearchFields returns the words of a query with field prefixes, all
+// searched in every field, or "" if the query has no field prefixes
+func withoutSearchFields(query string) string {
+	parsed := parseSearchQuery(query)
+	if len(parsed.TitleTerms)+len(parsed.AuthorTerms)+len(parsed.SeriesTerms)+len(parsed.AnnotationTerms) == 0 {
+		return ""
+	}
+	var tokens []string
+	for _, terms := range [][]string{parsed.GeneralTerms, parsed.TitleTerms, parsed.AuthorTerms, parsed.SeriesTerms, parsed.AnnotationTerms} {
+		tokens = append(tokens, terms...)
+	}
+	return strings.Join(uniqueTokens(tokens), " ")
+}
+
+// romanTable romanizes Cyrillic the way names are commonly spelled in
+// Latin, so that "Чехов" becomes "chekhov"
+var romanTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// cyrillicSpellings spell Latin letters in Cyrillic, longest first
+var cyrillicSpellings = []struct{ latin, cyrillic string }{
+	{"shch", "щ"}, {"sch", "щ"},
+	{"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"tz", "ц"}, {"ch", "ч"}, {"sh", "ш"},
+	{"ya", "я"}, {"ja", "я"}, {"yu", "ю"}, {"ju", "ю"}, {"yo", "ё"}, {"jo", "ё"}, {"ye", "е"},
+	{"ph", "ф"}, {"ck", "к"}, {"x", "кс"},
+	{"a", "а"}, {"b", "б"}, {"c", "к"}, {"d", "д"}, {"e", "е"}, {"f", "ф"}, {"g", "г"},
+	{"h", "х"}, {"i", "и"}, {"j", "й"}, {"k", "к"}, {"l", "л"}, {"m", "м"}, {"n", "н"},
+	{"o", "о"}, {"p", "п"}, {"q", "к"}, {"r", "р"}, {"s", "с"}, {"t", "т"}, {"u", "у"},
+	{"v", "в"}, {"w", "в"}, {"z", "з"},
+}
+
+// transliterateQuery spells the Latin words of a query in Cyrillic and the
+// Cyrillic words in Latin, so that "tolstoy" finds "Толстой" and "Чехов"
+// finds "Chekhov". Words are matched by prefix, so a lost soft sign does no
+// harm.
+func transliterateQuery(query string) string {
+	words := tokenizeText(query)
+	for i, word := range words {
+		switch {
+		case strings.IndexFunc(word, isCyrillic) >= 0:
+			words[i] = romanizeWord(word)
+		case strings.IndexFunc(word, isLatin) >= 0:
+			words[i] = cyrillizeWord(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func isCyrillic(r rune) bool { return unicode.Is(unicode.Cyrillic, r) }
+
+func isLatin(r rune) bool { return r >= 'a' && r <= 'z' }
+
+// romanizeWord romanizes a lower-case word; the endings "ий" and "ый" are
+// spelled "y", as in "Dostoevsky"
+func romanizeWord(word string) string {
+	for _, ending := range []string{"ий", "ый"} {
+		if stem, ok := strings.CutSuffix(word, ending); ok && stem != "" {
+			return romanizeWord(stem) + "y"
+		}
+	}
+	var sb strings.Builder
+	for _, r := range word {
+		if latin, ok := romanTable[r]; ok {
+			sb.WriteString(latin)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// cyrillizeWord spells a lower-case Latin word in Cyrillic. "y" is "й"
+// after a vowel, "ий" at the end after a consonant and "ы" otherwise, so
+// "tolstoy", "dostoevsky" and "bykov" come out right.
+func cyrillizeWord(word string) string {
+	var out []rune
+	afterVowel := func() bool {
+		return len(out) > 0 && strings.ContainsRune("аеёиоуыэюя", out[len(out)-1])
+	}
+	for rest := word; rest != ""; {
+		if rest[0] == 'y' && !strings.HasPrefix(rest, "ya") && !strings.HasPrefix(rest, "yu") &&
+			!strings.HasPrefix(rest, "yo") && !strings.HasPrefix(rest, "ye") {
+			switch {
+			case afterVowel():
+				out = append(out, 'й')
+			case len(rest) == 1 && len(out) > 0:
+				out = append(out, 'и', 'й')
+			default:
+				out = append(out, 'ы')
+			}
+			rest = rest[1:]
+			continue
+		}
+		matched := false
+		for _, s := range cyrillicSpellings {
+			if strings.HasPrefix(rest, s.latin) {
+				out = append(out, []rune(s.cyrillic)...)
+				rest = rest[len(s.latin):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			r := []rune(rest)[0]
+			out = append(out, r)
+			rest = rest[len(string(r)):]
+		}
+	}
+	return string(out)
+}
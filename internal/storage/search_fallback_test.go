@@ -0,0 +1,63 @@
+package storage
+
+import "testing"
+
+// TestSearchBooks_Fallback checks searches that find nothing are retried
+// without field prefixes, transliterated and with typos corrected, and are
+// left empty while disabled or when no retry finds anything.
+func TestSearchBooks_Fallback(t *testing.T) {
+	repo := newSuggestTestRepo(t) // "Война и мир" by "Лев Толстой", two books of "Фёдор Достоевский"
+	repo.SetSearchFallback(true)
+
+	cases := []struct {
+		query, fallbackQuery, strategy string
+		total                          int
+	}{
+		{"title:Толстой", "толстой", FallbackAnyField, 1},
+		{"tolstoy", "толстой", FallbackTransliteration, 1},
+		{"Dostoevsky", "достоевский", FallbackTransliteration, 2},
+		{"Идиотт", "Идиот", FallbackFuzzy, 1},
+		{"xyzzy", "", "", 0},
+		{"Толстой", "", "", 1},
+	}
+	for _, tc := range cases {
+		result, err := repo.SearchBooks(BookFilter{Query: tc.query})
+		if err != nil {
+			t.Fatalf("SearchBooks(%q) failed: %v", tc.query, err)
+		}
+		if result.Total != tc.total || result.Fallback != (tc.fallbackQuery != "") ||
+			result.FallbackQuery != tc.fallbackQuery || result.FallbackStrategy != tc.strategy {
+			t.Errorf("SearchBooks(%q): expected %d books from %q by %q, got %d from %q by %q",
+				tc.query, tc.total, tc.fallbackQuery, tc.strategy, result.Total, result.FallbackQuery, result.FallbackStrategy)
+		}
+	}
+
+	repo.SetSearchFallback(false)
+	result, err := repo.SearchBooks(BookFilter{Query: "tolstoy"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 0 || result.Fallback {
+		t.Errorf("expected no retry while disabled, got %d books, fallback %v", result.Total, result.Fallback)
+	}
+}
+
+func TestTransliterateQuery(t *testing.T) {
+	cases := map[string]string{
+		"tolstoy":           "толстой",
+		"Dostoevsky":        "достоевский",
+		"bykov":             "быков",
+		"Chekhov":           "чехов",
+		"krasnyy":           "красный",
+		"Tsvetaeva Yesenin": "цветаева есенин",
+		"Толстой":           "tolstoy",
+		"Достоевский":       "dostoevsky",
+		"Щука и Ёж":         "shchuka i ezh",
+		"1984 Orwell":       "1984 орвелл",
+	}
+	for query, want := range cases {
+		if got := transliterateQuery(query); got != want {
+			t.Errorf("transliterateQuery(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
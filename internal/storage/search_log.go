@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// searchLogPruneInterval is how often old searches are deleted
+	searchLogPruneInterval = time.Hour
+	// maxLoggedQueryLength is the number of runes of a query kept in the log
+	maxLoggedQueryLength = 200
+)
+
+// SearchLogSettings configure the log of search queries
+type SearchLogSettings struct {
+	// Retention is how long searches are kept; zero keeps them forever
+	Retention time.Duration
+	// MinSearchers is the number of different people who must have made a
+	// query before it is shown to anyone but admins
+	MinSearchers int
+}
+
+// searchLog is the enabled search log
+type searchLog struct {
+	SearchLogSettings
+	pruned atomic.Int64
+
+	mu sync.Mutex
+	// salt makes searcher hashes unlinkable to users and addresses, even
+	// with the database and the configuration at hand. It is made anew on
+	// every start and every day; window tells apart the searches hashed
+	// with it, which alone can be compared.
+	salt   []byte
+	window string
+	day    string
+}
+
+// currentSalt returns the salt of searches made at now and the window they
+// belong to, starting a new window with a new salt every day
+func (sl *searchLog) currentSalt(now time.Time) ([]byte, string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if day := now.Format("2006-01-02"); day != sl.day {
+		salt, window := make([]byte, 16), make([]byte, 8)
+		rand.Read(salt) // never fails since Go 1.24
+		rand.Read(window)
+		sl.salt, sl.window, sl.day = salt, day+"-"+hex.EncodeToString(window), day
+	}
+	return sl.salt, sl.window
+}
+
+// SearchQueryCount is how often a query was searched
+type SearchQueryCount struct {
+	Query    string `json:"query"`
+	Searches int    `json:"searches"`
+	// Searchers is the largest number of different people who made the
+	// query within one salt window, about a day
+	Searchers int `json:"searchers"`
+	// Results is the number of books the latest search found
+	Results int `json:"results"`
+}
+
+// SearchStats are the most frequent queries and those that found nothing
+type SearchStats struct {
+	Searches    int                `json:"searches"`
+	Top         []SearchQueryCount `json:"top"`
+	ZeroResults []SearchQueryCount `json:"zero_results"`
+}
+
+// SetSearchLog logs search queries with the number of books found; nil
+// disables the log. Queries are stored normalized, without the user or
+// address: searchers are told apart by a hash salted anew on every start
+// and every day, which only serves to count them within that window. It
+// must be called before requests are served.
+func (r *Repository) SetSearchLog(settings *SearchLogSettings) {
+	if settings == nil {
+		r.searchLog.Store(nil)
+		return
+	}
+	r.searchLog.Store(&searchLog{SearchLogSettings: *settings})
+}
+
+// SearchLogEnabled reports whether search queries are logged
+func (r *Repository) SearchLogEnabled() bool {
+	return r.searchLog.Load() != nil
+}
+
+// normalizeLoggedQuery lowercases a query, folds "ё" and collapses spaces,
+// so that searches for the same words are counted together
+func normalizeLoggedQuery(query string) string {
+	query = strings.Join(strings.Fields(strings.ToLower(yoFolder.Replace(query))), " ")
+	if utf8.RuneCountInString(query) > maxLoggedQueryLength {
+		query = string([]rune(query)[:maxLoggedQueryLength])
+	}
+	return query
+}
+
+// LogSearch records a search for query that found results books. client
+// identifies the searcher, such as a user ID or an address; only its salted
+// hash is stored. Empty queries are not logged, nor anything while the log
+// is disabled.
+func (r *Repository) LogSearch(query string, results int, client string) error {
+	sl := r.searchLog.Load()
+	if sl == nil {
+		return nil
+	}
+	query = normalizeLoggedQuery(query)
+	if query == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	salt, window := sl.currentSalt(now)
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(client))
+	searcher := hex.EncodeToString(hash.Sum(nil)[:8])
+
+	if _, err := r.db.db.Exec(
+		"INSERT INTO search_log (searched_at, query, results, searcher, salt_window) VALUES (?, ?, ?, ?, ?)",
+		now.Truncate(time.Second), query, results, searcher, window,
+	); err != nil {
+		return fmt.Errorf("failed to log search: %w", err)
+	}
+
+	// Prune at most once per interval, off the request
+	last := sl.pruned.Load()
+	if sl.Retention > 0 && now.Unix()-last >= int64(searchLogPruneInterval/time.Second) && sl.pruned.CompareAndSwap(last, now.Unix()) {
+		go func() {
+			n, err := r.PruneSearchLog(now.Add(-sl.Retention))
+			if err != nil {
+				log.Printf("Search log: %v", err)
+			} else if n > 0 {
+				log.Printf("Search log: removed %d old searches", n)
+			}
+		}()
+	}
+	return nil
+}
+
+// PruneSearchLog deletes the searches made before a time and returns their
+// number
+func (r *Repository) PruneSearchLog(before time.Time) (int64, error) {
+	result, err := r.db.db.Exec("DELETE FROM search_log WHERE searched_at < ?", before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune search log: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
+
+// GetSearchStats returns the limit most frequent queries searched since a
+// time and the limit most frequent ones whose latest search found nothing.
+// Unless all is set, queries made by fewer people than the log's
+// MinSearchers are left out, so that no one's searches can be singled out.
+// Searcher hashes change with every salt window, so people are counted
+// within each window and a query must reach MinSearchers in one of them.
+func (r *Repository) GetSearchStats(since time.Time, limit int, all bool) (*SearchStats, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	minSearchers := 1
+	if sl := r.searchLog.Load(); sl != nil && !all {
+		minSearchers = max(sl.MinSearchers, 1)
+	}
+
+	stats := &SearchStats{Top: []SearchQueryCount{}, ZeroResults: []SearchQueryCount{}}
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM search_log WHERE searched_at >= ?", since.UTC()).Scan(&stats.Searches); err != nil {
+		return nil, fmt.Errorf("failed to count searches: %w", err)
+	}
+
+	// SQLite takes the bare results column from the row with MAX(id), so
+	// it is the count of the latest search. Searches logged before salt
+	// windows were recorded fall in the window of their day.
+	grouped := `
+		SELECT query, searches, searchers, results FROM (
+			SELECT query, COUNT(*) AS searches, results, MAX(id)
+			FROM search_log WHERE searched_at >= ?
+			GROUP BY query
+		) JOIN (
+			SELECT query, MAX(window_searchers) AS searchers FROM (
+				SELECT query, COUNT(DISTINCT searcher) AS window_searchers
+				FROM search_log WHERE searched_at >= ?
+				GROUP BY query, COALESCE(NULLIF(salt_window, ''), DATE(searched_at))
+			) GROUP BY query
+		) USING (query)
+		WHERE searchers >= ?`
+	for _, list := range []struct {
+		dst   *[]SearchQueryCount
+		where string
+	}{
+		{&stats.Top, ""},
+		{&stats.ZeroResults, " AND results = 0"},
+	} {
+		rows, err := r.db.db.Query(grouped+list.where+" ORDER BY searches DESC, searchers DESC, query LIMIT ?",
+			since.UTC(), since.UTC(), minSearchers, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query search stats: %w", err)
+		}
+		for rows.Next() {
+			var qc SearchQueryCount
+			if err := rows.Scan(&qc.Query, &qc.Searches, &qc.Searchers, &qc.Results); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan search stats: %w", err)
+			}
+			*list.dst = append(*list.dst, qc)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error iterating search stats: %w", err)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSearchLog checks queries are counted normalized, that queries of too
+// few searchers are only shown to admins, that zero-result queries go by
+// the latest search, that searchers are only counted within one salt
+// window, and that old searches are pruned.
+func TestSearchLog(t *testing.T) {
+	repo := newSearchTestRepo(t)
+	if err := repo.LogSearch("толстой", 1, "a"); err != nil {
+		t.Fatalf("LogSearch failed: %v", err)
+	}
+	if stats, err := repo.GetSearchStats(time.Now().Add(-time.Hour), 10, true); err != nil || stats.Searches != 0 {
+		t.Fatalf("expected nothing logged while disabled, got %+v, %v", stats, err)
+	}
+
+	repo.SetSearchLog(&SearchLogSettings{Retention: 24 * time.Hour, MinSearchers: 2})
+	for _, s := range []struct {
+		query   string
+		results int
+		client  string
+	}{
+		{"Толстой", 5, "a"},
+		{"  толстой ", 5, "b"},
+		{"ТОЛСТОЙ", 5, "b"},
+		{"Ёжик", 0, "a"},
+		{"ежик", 0, "b"},
+		{"гоголь", 0, "a"},
+		{"гоголь", 3, "b"},
+		{"секрет", 0, "a"},
+		{"", 0, "a"},
+	} {
+		if err := repo.LogSearch(s.query, s.results, s.client); err != nil {
+			t.Fatalf("LogSearch failed: %v", err)
+		}
+	}
+
+	since := time.Now().Add(-time.Hour)
+	stats, err := repo.GetSearchStats(since, 10, false)
+	if err != nil {
+		t.Fatalf("GetSearchStats failed: %v", err)
+	}
+	if stats.Searches != 8 {
+		t.Errorf("expected 8 searches, got %d", stats.Searches)
+	}
+	want := []SearchQueryCount{
+		{Query: "толстой", Searches: 3, Searchers: 2, Results: 5},
+		{Query: "гоголь", Searches: 2, Searchers: 2, Results: 3},
+		{Query: "ежик", Searches: 2, Searchers: 2, Results: 0},
+	}
+	if len(stats.Top) != len(want) {
+		t.Fatalf("expected top %v, got %v", want, stats.Top)
+	}
+	for i := range want {
+		if stats.Top[i] != want[i] {
+			t.Errorf("top %d: expected %+v, got %+v", i, want[i], stats.Top[i])
+		}
+	}
+	if len(stats.ZeroResults) != 1 || stats.ZeroResults[0].Query != "ежик" {
+		t.Errorf("expected only ежик without results, got %v", stats.ZeroResults)
+	}
+
+	stats, err = repo.GetSearchStats(since, 10, true)
+	if err != nil {
+		t.Fatalf("GetSearchStats failed: %v", err)
+	}
+	if len(stats.Top) != 4 || len(stats.ZeroResults) != 2 {
+		t.Errorf("expected admins to see every query, got %v and %v", stats.Top, stats.ZeroResults)
+	}
+
+	// Hashes of the same searcher differ once the salt changes, so searches
+	// of a new window are not counted as more people
+	sl := repo.searchLog.Load()
+	if err := repo.LogSearch("пушкин", 4, "a"); err != nil {
+		t.Fatalf("LogSearch failed: %v", err)
+	}
+	sl.day = ""
+	if err := repo.LogSearch("пушкин", 4, "a"); err != nil {
+		t.Fatalf("LogSearch failed: %v", err)
+	}
+	stats, _ = repo.GetSearchStats(since, 10, true)
+	for _, qc := range stats.Top {
+		if qc.Query == "пушкин" && qc != (SearchQueryCount{Query: "пушкин", Searches: 2, Searchers: 1, Results: 4}) {
+			t.Errorf("expected one searcher in two windows, got %+v", qc)
+		}
+	}
+	if stats, _ = repo.GetSearchStats(since, 10, false); len(stats.Top) != 3 {
+		t.Errorf("expected the query of one searcher hidden, got %v", stats.Top)
+	}
+
+	if n, err := repo.PruneSearchLog(time.Now().Add(time.Hour)); err != nil || n != 10 {
+		t.Errorf("expected 10 searches pruned, got %d, %v", n, err)
+	}
+}
@@ -1,16 +1,33 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
+// ErrInvalidQuery is returned for search queries that cannot be searched at all.
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// maxQueryLength limits the length of a search query in characters.
+const maxQueryLength = 500
+
+// A field prefix starts the query or follows a character that is not part of
+// a word. \b would do for Latin names only: in Go it is ASCII-bound.
 var (
-	searchFieldRegex     = regexp.MustCompile(`(?i)\b(author|authors|автор|авторы|series|серия|серии|title|название|annotation|описание|description):("([^"\\]|\\.)*"|\S+)`)
+	searchFieldRegex     = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(author|authors|автор|авторы|series|серия|серии|title|название|annotation|описание|description):("([^"\\]|\\.)*"|\S+)`)
+	searchPrefixRegex    = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])(\p{L}+):(\S?)`)
 	ftsSearchableColumns = []string{"title", "annotation", "authors", "series"}
 )
 
+// searchFieldNames are the field prefixes named in query warnings
+const searchFieldNames = "author:, title:, series:, annotation:"
+
 type structuredQuery struct {
 	Remainder       string
 	GeneralTerms    []string
@@ -20,6 +37,86 @@ type structuredQuery struct {
 	AnnotationTerms []string
 }
 
+// validateSearchQuery rejects queries without any searchable characters and
+// overly long ones. Anything else is searchable via FTS or the LIKE fallback.
+func validateSearchQuery(input string) error {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return nil
+	}
+	if utf8.RuneCountInString(trimmed) > maxQueryLength {
+		return fmt.Errorf("%w: query is longer than %d characters", ErrInvalidQuery, maxQueryLength)
+	}
+	if strings.IndexFunc(trimmed, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		return fmt.Errorf("%w: query must contain at least one letter or digit", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// isFTSQueryError reports whether err is an FTS5 MATCH expression error
+// rather than a database failure.
+func isFTSQueryError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrError {
+		return false
+	}
+	msg := strings.ToLower(sqliteErr.Error())
+	return strings.Contains(msg, "fts5") ||
+		strings.Contains(msg, "syntax error") ||
+		strings.Contains(msg, "unterminated string") ||
+		strings.Contains(msg, "no such column")
+}
+
+// buildLikeConditions turns a parsed query into LIKE conditions over the
+// search SQL aliases (b = books, a = authors, s = series). Used when the FTS
+// expression cannot be evaluated.
+func buildLikeConditions(q structuredQuery) ([]string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	add := func(columns []string, tokens []string) {
+		for _, token := range uniqueTokens(tokens) {
+			var parts []string
+			for _, variant := range caseVariants(token) {
+				like := "%" + variant + "%"
+				for _, column := range columns {
+					parts = append(parts, column+" LIKE ?")
+					args = append(args, like)
+				}
+			}
+			conditions = append(conditions, "("+strings.Join(parts, " OR ")+")")
+		}
+	}
+
+	add([]string{"b.title", annotationExpr, "a.name", "s.name"}, q.GeneralTerms)
+	add([]string{"b.title"}, q.TitleTerms)
+	add([]string{"a.name"}, q.AuthorTerms)
+	add([]string{"s.name"}, q.SeriesTerms)
+	add([]string{annotationExpr}, q.AnnotationTerms)
+
+	return conditions, args
+}
+
+// caseVariants returns the lower-case, capitalized and upper-case forms of
+// a token. SQLite's LIKE only folds ASCII, so Cyrillic words have to be
+// matched in each spelling they commonly appear in.
+func caseVariants(token string) []string {
+	lower := strings.ToLower(token)
+	variants := []string{lower}
+	if r, size := utf8.DecodeRuneInString(lower); size > 0 {
+		title := string(unicode.ToUpper(r)) + lower[size:]
+		if title != lower {
+			variants = append(variants, title)
+		}
+	}
+	if upper := strings.ToUpper(lower); upper != variants[len(variants)-1] {
+		variants = append(variants, upper)
+	}
+	return variants
+}
+
 func prepareFTSSearch(input string) (string, string) {
 	parsed := parseSearchQuery(input)
 	ftsExpr := buildFTSExpression(parsed)
@@ -49,7 +146,7 @@ func parseSearchQuery(input string) structuredQuery {
 	last := 0
 
 	for _, idx := range matches {
-		start := idx[0]
+		start := idx[2]
 		end := idx[1]
 		fieldStart := idx[2]
 		fieldEnd := idx[3]
@@ -92,6 +189,76 @@ func parseSearchQuery(input string) structuredQuery {
 	return result
 }
 
+// ParsedQuery is how a search query is interpreted: the words searched in
+// every field, those a prefix such as author: limits to one field and the
+// resulting FTS5 expression. Warnings name the parts of the query that were
+// searched as plain text although they look like a field prefix.
+type ParsedQuery struct {
+	Query      string   `json:"query"`
+	Terms      []string `json:"terms"`
+	Title      []string `json:"title"`
+	Authors    []string `json:"authors"`
+	Series     []string `json:"series"`
+	Annotation []string `json:"annotation"`
+	FTS        string   `json:"fts"`
+	Warnings   []string `json:"warnings"`
+}
+
+// ParseSearchQuery interprets a query the way SearchBooks does. It returns
+// ErrInvalidQuery for a query SearchBooks would reject.
+func ParseSearchQuery(input string) (*ParsedQuery, error) {
+	if err := validateSearchQuery(input); err != nil {
+		return nil, err
+	}
+
+	parsed := parseSearchQuery(input)
+	orEmpty := func(tokens []string) []string {
+		if unique := uniqueTokens(tokens); unique != nil {
+			return unique
+		}
+		return []string{}
+	}
+	warnings := searchQueryWarnings(input)
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return &ParsedQuery{
+		Query:      input,
+		Terms:      orEmpty(parsed.GeneralTerms),
+		Title:      orEmpty(parsed.TitleTerms),
+		Authors:    orEmpty(parsed.AuthorTerms),
+		Series:     orEmpty(parsed.SeriesTerms),
+		Annotation: orEmpty(parsed.AnnotationTerms),
+		FTS:        buildFTSExpression(parsed),
+		Warnings:   warnings,
+	}, nil
+}
+
+// searchQueryWarnings lists the words of a query that look like a field
+// prefix but were searched as plain text: unknown field names and known
+// ones without a value, as in "author: Толстой".
+func searchQueryWarnings(input string) []string {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, m := range searchPrefixRegex.FindAllStringSubmatch(input, -1) {
+		field, next := m[1], m[2]
+		var warning string
+		switch {
+		case normalizeSearchField(field) != "":
+			if next == "" {
+				warning = fmt.Sprintf("field %s: has no value and was searched as text; write %s:word or %s:\"several words\"", field, field, field)
+			}
+		case next != "" && (next == `"` || strings.IndexFunc(next, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) == 0):
+			warning = fmt.Sprintf("unknown field %s: was searched as text; known fields are %s", field, searchFieldNames)
+		}
+		if warning != "" && !seen[warning] {
+			seen[warning] = true
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
 func buildFTSExpression(q structuredQuery) string {
 	var clauses []string
 
@@ -250,14 +417,34 @@ func uniqueTokens(tokens []string) []string {
 	return result
 }
 
+// formatFTSToken renders a token as a quoted FTS5 prefix query, so that
+// keywords and special characters in user input are matched literally.
+// A token with "ё" also matches its folded form and the other way round
+// only where the index was built with folding.
 func formatFTSToken(token string) string {
+	token = strings.TrimSuffix(token, "*")
 	if token == "" {
 		return ""
 	}
-	if strings.HasSuffix(token, "*") {
-		return token
+	quoted := `"` + strings.ReplaceAll(token, `"`, `""`) + `"*`
+	if folded := foldSearchText(token); folded != token {
+		return "(" + quoted + ` OR "` + strings.ReplaceAll(folded, `"`, `""`) + `"*)`
 	}
-	return token + "*"
+	return quoted
+}
+
+// yoFolder replaces "ё" by "е": unicode61 removes Latin diacritics only
+var yoFolder = strings.NewReplacer("ё", "е", "Ё", "Е")
+
+// foldSearchText folds "ё" to "е" in text stored in a full-text index, so
+// that searches for either spelling find it
+func foldSearchText(s string) string {
+	return yoFolder.Replace(s)
+}
+
+// foldSearchSQL is foldSearchText for an SQL expression
+func foldSearchSQL(expr string) string {
+	return "replace(replace(" + expr + ", 'ё', 'е'), 'Ё', 'Е')"
 }
 
 func normalizeWhitespace(input string) string {
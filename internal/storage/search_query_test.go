@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func newSearchTestRepo(t *testing.T) *Repository {
+	t.Helper()
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	book := inpx.Book{
+		ID:          "q-1",
+		Title:       "Война и мир",
+		Authors:     []string{"Лев Толстой"},
+		Series:      "Романы",
+		Language:    "ru",
+		ArchivePath: "books",
+		FileNum:     "001",
+		Format:      "fb2",
+		Date:        time.Now(),
+		Annotation:  "Роман-эпопея",
+	}
+	if err := repo.InsertBooks([]inpx.Book{book}); err != nil {
+		t.Fatalf("failed to insert book: %v", err)
+	}
+	return repo
+}
+
+// TestSearchBooks_FTSSpecialCharacters verifies FTS operators and stray
+// quotes in user input are searched as plain words instead of failing.
+func TestSearchBooks_FTSSpecialCharacters(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{`"Война`, 1},
+		{`Война"`, 1},
+		{`Война (мир`, 1},
+		{`мир*`, 1},
+		{`^Война`, 1},
+		{`Толстой:`, 1},
+		{`title:"Война`, 1},
+		// Operator keywords are ordinary words that no book contains
+		{`Война AND`, 0},
+		{`NOT мир`, 0},
+		{`Война NEAR(`, 0},
+	}
+	for _, tc := range cases {
+		result, err := repo.SearchBooks(BookFilter{Query: tc.query})
+		if err != nil {
+			t.Errorf("query %q: unexpected error: %v", tc.query, err)
+			continue
+		}
+		if result.Total != tc.want {
+			t.Errorf("query %q: expected %d results, got %d", tc.query, tc.want, result.Total)
+		}
+	}
+}
+
+// TestSearchBooks_InvalidQuery verifies unusable queries return ErrInvalidQuery.
+func TestSearchBooks_InvalidQuery(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	for _, q := range []string{`"""`, `*`, `() -`, strings.Repeat("a", maxQueryLength+1)} {
+		_, err := repo.SearchBooks(BookFilter{Query: q})
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("query %q: expected ErrInvalidQuery, got %v", q, err)
+		}
+	}
+
+	if _, err := repo.SearchBooks(BookFilter{Query: "   "}); err != nil {
+		t.Errorf("blank query should list all books, got %v", err)
+	}
+}
+
+// TestSearchBooks_LikeFallback verifies the LIKE search used when FTS
+// rejects an expression finds the same books.
+func TestSearchBooks_LikeFallback(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{"война", 1},
+		{"Толстой", 1},
+		{"author:Толстой мир", 1},
+		{"series:Романы", 1},
+		{"description:эпопея", 1},
+		{"ВОЙНА", 1},
+		{"author:Пушкин", 0},
+	}
+	for _, tc := range cases {
+		result, err := repo.searchBooks(BookFilter{Query: tc.query, Limit: 10}, false)
+		if err != nil {
+			t.Errorf("query %q: unexpected error: %v", tc.query, err)
+			continue
+		}
+		if result.Total != tc.want {
+			t.Errorf("query %q: expected %d results, got %d", tc.query, tc.want, result.Total)
+		}
+	}
+}
+
+// TestParseSearchQuery verifies field prefixes in either language and the
+// warnings about prefixes searched as text.
+func TestParseSearchQuery(t *testing.T) {
+	parsed, err := ParseSearchQuery(`автор:Толстой title:"война и мир" genre:роман series: Романы`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery failed: %v", err)
+	}
+	if strings.Join(parsed.Authors, " ") != "толстой" || strings.Join(parsed.Title, " ") != "война и мир" {
+		t.Errorf("unexpected fields: %+v", parsed)
+	}
+	if strings.Join(parsed.Terms, " ") != "genre роман series романы" || len(parsed.Series) != 0 {
+		t.Errorf("unexpected terms: %+v", parsed)
+	}
+	if len(parsed.Warnings) != 2 ||
+		!strings.Contains(parsed.Warnings[0], "unknown field genre:") ||
+		!strings.Contains(parsed.Warnings[1], "field series: has no value") {
+		t.Errorf("unexpected warnings: %q", parsed.Warnings)
+	}
+
+	for _, q := range []string{"война и мир", "см. http://example.com", "в 10:30"} {
+		if parsed, err := ParseSearchQuery(q); err != nil || len(parsed.Warnings) != 0 {
+			t.Errorf("query %q: expected no warnings, got %+v, %v", q, parsed, err)
+		}
+	}
+	if _, err := ParseSearchQuery("*"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+
+	repo := newSearchTestRepo(t)
+	list, err := repo.SearchBooks(BookFilter{Query: "автор:Толстой жанр:роман"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(list.Warnings) != 1 || !strings.Contains(list.Warnings[0], "жанр:") {
+		t.Errorf("unexpected search warnings: %q", list.Warnings)
+	}
+}
+
+// TestCountBookFacet verifies facet counts follow the search filter but
+// ignore the facet's own selection.
+func TestCountBookFacet(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	more := []inpx.Book{
+		{ID: "q-2", Title: "Анна Каренина", Authors: []string{"Лев Толстой"}, Language: "ru", Format: "epub", ArchivePath: "books", FileNum: "002", Date: time.Now()},
+		{ID: "q-3", Title: "War and Peace", Authors: []string{"Leo Tolstoy"}, Language: "en", Format: "fb2", ArchivePath: "books", FileNum: "003", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(more); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	formats, err := repo.CountBookFacet(BookFilter{Formats: []string{"epub"}}, "format")
+	if err != nil {
+		t.Fatalf("CountBookFacet failed: %v", err)
+	}
+	want := []FacetCount{{Value: "fb2", Count: 2}, {Value: "epub", Count: 1}}
+	if len(formats) != len(want) || formats[0] != want[0] || formats[1] != want[1] {
+		t.Errorf("format facet: expected %v, got %v", want, formats)
+	}
+
+	languages, err := repo.CountBookFacet(BookFilter{Query: "Толстой", Formats: []string{"fb2"}}, "language")
+	if err != nil {
+		t.Fatalf("CountBookFacet failed: %v", err)
+	}
+	if len(languages) != 1 || languages[0] != (FacetCount{Value: "ru", Count: 1}) {
+		t.Errorf("language facet: expected [{ru 1}], got %v", languages)
+	}
+
+	if _, err := repo.CountBookFacet(BookFilter{}, "title"); err == nil {
+		t.Error("expected error for unknown facet field")
+	}
+}
+
+// TestCountBookFacets verifies all facets are counted at once, each
+// ignoring only its own selection.
+func TestCountBookFacets(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	more := []inpx.Book{
+		{ID: "q-2", Title: "Анна Каренина", Authors: []string{"Лев Толстой"}, Genre: "prose", Year: 1877, Language: "ru", Format: "epub", ArchivePath: "books", FileNum: "002", Date: time.Now()},
+		{ID: "q-3", Title: "War and Peace", Authors: []string{"Leo Tolstoy"}, Genre: "prose", Year: 1869, Language: "en", Format: "fb2", ArchivePath: "books", FileNum: "003", Date: time.Now()},
+		{ID: "q-4", Title: "Воскресение", Authors: []string{"Лев Толстой"}, Genre: "novel", Year: 1899, Language: "ru", Format: "fb2", ArchivePath: "books", FileNum: "004", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(more); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	facets, err := repo.CountBookFacets(BookFilter{Query: "Толстой", Formats: []string{"fb2"}, Genres: []string{"novel"}})
+	if err != nil {
+		t.Fatalf("CountBookFacets failed: %v", err)
+	}
+
+	// Formats ignore the format selection: Воскресение (fb2) and nothing else is a novel
+	if len(facets.Formats) != 1 || facets.Formats[0] != (FacetCount{Value: "fb2", Count: 1}) {
+		t.Errorf("formats: got %v", facets.Formats)
+	}
+	// Genres ignore the genre selection: Воскресение is the only fb2 novel, q-1 has no genre
+	if len(facets.Genres) != 1 || facets.Genres[0] != (FacetCount{Value: "novel", Count: 1}) {
+		t.Errorf("genres: got %v", facets.Genres)
+	}
+	if len(facets.Languages) != 1 || facets.Languages[0] != (FacetCount{Value: "ru", Count: 1}) {
+		t.Errorf("languages: got %v", facets.Languages)
+	}
+	if len(facets.Years) != 1 || facets.Years[0] != (YearCount{Year: 1890, Count: 1}) {
+		t.Errorf("years: got %v", facets.Years)
+	}
+
+	all, err := repo.CountBookFacets(BookFilter{})
+	if err != nil {
+		t.Fatalf("CountBookFacets failed: %v", err)
+	}
+	wantYears := []YearCount{{Year: 1860, Count: 1}, {Year: 1870, Count: 1}, {Year: 1890, Count: 1}}
+	if len(all.Years) != len(wantYears) || all.Years[0] != wantYears[0] || all.Years[2] != wantYears[2] {
+		t.Errorf("years: expected %v, got %v", wantYears, all.Years)
+	}
+	if len(all.Genres) != 2 || all.Genres[0] != (FacetCount{Value: "prose", Count: 2}) {
+		t.Errorf("genres: got %v", all.Genres)
+	}
+}
@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrShelfNotFound is returned for operations on a shelf that does not exist
+var ErrShelfNotFound = errors.New("shelf not found")
+
+// ShelfExportFormat identifies shelf export files
+const ShelfExportFormat = "pushkinlib-shelf"
+
+// ShelfExport is the portable form of a shelf. Books carry their title and
+// authors besides the ID, so that another library can find them.
+type ShelfExport struct {
+	Format     string            `json:"format"`
+	Version    int               `json:"version"`
+	Name       string            `json:"name"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Books      []ShelfExportBook `json:"books"`
+}
+
+// ShelfExportBook is a book of an exported shelf
+type ShelfExportBook struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title,omitempty"`
+	Authors   []string `json:"authors,omitempty"`
+	Series    string   `json:"series,omitempty"`
+	SeriesNum int      `json:"series_num,omitempty"`
+	Language  string   `json:"language,omitempty"`
+}
+
+// ShelfImport is the outcome of ImportShelf: the new shelf and the books
+// this library does not have
+type ShelfImport struct {
+	Shelf   *Shelf            `json:"shelf"`
+	Added   int               `json:"added"`
+	Missing []ShelfExportBook `json:"missing"`
+}
+
+// ShelfToken returns the token that grants read access to a shelf,
+// creating a random one when the shelf is not shared yet
+func (r *Repository) ShelfToken(shelfID string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("generate shelf token: %w", err)
+	}
+	if _, err := r.db.db.Exec(
+		"UPDATE shelves SET share_token = ? WHERE id = ? AND COALESCE(share_token, '') = ''", token, shelfID,
+	); err != nil {
+		return "", fmt.Errorf("failed to set shelf token: %w", err)
+	}
+	return r.SharedShelfToken(shelfID)
+}
+
+// SharedShelfToken returns the token of a shared shelf, or "" when the
+// shelf is not shared
+func (r *Repository) SharedShelfToken(shelfID string) (string, error) {
+	var current sql.NullString
+	err := r.db.db.QueryRow("SELECT share_token FROM shelves WHERE id = ?", shelfID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", ErrShelfNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get shelf token: %w", err)
+	}
+	return current.String, nil
+}
+
+// RevokeShelfToken stops sharing a shelf: its token no longer grants
+// access, and the next ShelfToken creates a new one
+func (r *Repository) RevokeShelfToken(shelfID string) error {
+	if _, err := r.db.db.Exec("UPDATE shelves SET share_token = NULL WHERE id = ?", shelfID); err != nil {
+		return fmt.Errorf("failed to revoke shelf token: %w", err)
+	}
+	return nil
+}
+
+// ValidShelfToken reports whether token grants read access to a shelf
+func (r *Repository) ValidShelfToken(shelfID, token string) bool {
+	if token == "" {
+		return false
+	}
+	var current sql.NullString
+	if err := r.db.db.QueryRow("SELECT share_token FROM shelves WHERE id = ?", shelfID).Scan(&current); err != nil {
+		return false
+	}
+	return current.String != "" && subtle.ConstantTimeCompare([]byte(token), []byte(current.String)) == 1
+}
+
+// CreateShelf creates an empty shelf of a user
+func (r *Repository) CreateShelf(userID, name string) (*Shelf, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, fmt.Errorf("generate shelf id: %w", err)
+	}
+	now := time.Now()
+	shelf := &Shelf{ID: id, UserID: userID, Name: name, CreatedAt: now, UpdatedAt: now}
+	if _, err := r.db.db.Exec(
+		"INSERT INTO shelves (id, user_id, name, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		shelf.ID, shelf.UserID, shelf.Name, shelf.CreatedAt, shelf.UpdatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to create shelf: %w", err)
+	}
+	return shelf, nil
+}
+
+// shelfColumns selects a shelf with the number of its books
+const shelfColumns = `sh.id, sh.user_id, sh.name, sh.created_at, sh.updated_at,
+	(SELECT COUNT(*) FROM shelf_books sb WHERE sb.shelf_id = sh.id)`
+
+// ListShelves returns the shelves of a user by name
+func (r *Repository) ListShelves(userID string) ([]Shelf, error) {
+	rows, err := r.db.db.Query(
+		"SELECT "+shelfColumns+" FROM shelves sh WHERE sh.user_id = ? ORDER BY LOWER(sh.name), sh.id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shelves: %w", err)
+	}
+	defer rows.Close()
+
+	shelves := []Shelf{}
+	for rows.Next() {
+		var shelf Shelf
+		if err := rows.Scan(&shelf.ID, &shelf.UserID, &shelf.Name, &shelf.CreatedAt, &shelf.UpdatedAt, &shelf.BookCount); err != nil {
+			return nil, fmt.Errorf("failed to scan shelf: %w", err)
+		}
+		shelves = append(shelves, shelf)
+	}
+	return shelves, rows.Err()
+}
+
+// GetShelf returns a shelf by ID, or nil if not found
+func (r *Repository) GetShelf(id string) (*Shelf, error) {
+	var shelf Shelf
+	err := r.db.db.QueryRow("SELECT "+shelfColumns+" FROM shelves sh WHERE sh.id = ?", id).
+		Scan(&shelf.ID, &shelf.UserID, &shelf.Name, &shelf.CreatedAt, &shelf.UpdatedAt, &shelf.BookCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shelf: %w", err)
+	}
+	return &shelf, nil
+}
+
+// DeleteShelf deletes a shelf with its list of books; it reports false if
+// the shelf did not exist.
+func (r *Repository) DeleteShelf(id string) (bool, error) {
+	result, err := r.db.db.Exec("DELETE FROM shelves WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete shelf: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		r.InvalidateQueryCache()
+	}
+	return n > 0, nil
+}
+
+// AddShelfBook puts a book last on a shelf; a book already there stays
+// where it is. An unknown book fails with ErrBookNotFound.
+func (r *Repository) AddShelfBook(shelfID, bookID string) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkBookExists(tx, bookID); err != nil {
+		return err
+	}
+	if err := addShelfBookTx(tx, shelfID, bookID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit shelf: %w", err)
+	}
+	r.InvalidateQueryCache()
+	return nil
+}
+
+// addShelfBookTx appends a book to a shelf and touches the shelf
+func addShelfBookTx(tx *sql.Tx, shelfID, bookID string) error {
+	result, err := tx.Exec("UPDATE shelves SET updated_at = ? WHERE id = ?", time.Now(), shelfID)
+	if err != nil {
+		return fmt.Errorf("failed to update shelf: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrShelfNotFound
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO shelf_books (shelf_id, book_id, position)
+		 SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM shelf_books WHERE shelf_id = ?
+		 ON CONFLICT(shelf_id, book_id) DO NOTHING`,
+		shelfID, bookID, shelfID,
+	); err != nil {
+		return fmt.Errorf("failed to add shelf book: %w", err)
+	}
+	return nil
+}
+
+// RemoveShelfBook takes a book off a shelf; it reports false if the book
+// was not on it.
+func (r *Repository) RemoveShelfBook(shelfID, bookID string) (bool, error) {
+	result, err := r.db.db.Exec("DELETE FROM shelf_books WHERE shelf_id = ? AND book_id = ?", shelfID, bookID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove shelf book: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+	if _, err := r.db.db.Exec("UPDATE shelves SET updated_at = ? WHERE id = ?", time.Now(), shelfID); err != nil {
+		return false, fmt.Errorf("failed to update shelf: %w", err)
+	}
+	r.InvalidateQueryCache()
+	return true, nil
+}
+
+// ExportShelf returns a shelf in its portable form, or ErrShelfNotFound.
+// Books no longer in the library are exported with their ID only.
+func (r *Repository) ExportShelf(id string) (*ShelfExport, error) {
+	shelf, err := r.GetShelf(id)
+	if err != nil {
+		return nil, err
+	}
+	if shelf == nil {
+		return nil, ErrShelfNotFound
+	}
+
+	rows, err := r.db.db.Query(`
+		SELECT sb.book_id, b.title, s.name, b.series_num, b.language
+		FROM shelf_books sb
+		LEFT JOIN books b ON b.id = sb.book_id
+		LEFT JOIN series s ON s.id = b.series_id
+		WHERE sb.shelf_id = ?
+		ORDER BY sb.position, sb.book_id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shelf books: %w", err)
+	}
+	defer rows.Close()
+
+	export := &ShelfExport{
+		Format:     ShelfExportFormat,
+		Version:    1,
+		Name:       shelf.Name,
+		ExportedAt: time.Now().UTC(),
+		Books:      []ShelfExportBook{},
+	}
+	for rows.Next() {
+		var book ShelfExportBook
+		var title, series, language sql.NullString
+		var seriesNum sql.NullInt64
+		if err := rows.Scan(&book.ID, &title, &series, &seriesNum, &language); err != nil {
+			return nil, fmt.Errorf("failed to scan shelf book: %w", err)
+		}
+		book.Title, book.Series, book.Language = title.String, series.String, language.String
+		book.SeriesNum = int(seriesNum.Int64)
+		export.Books = append(export.Books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range export.Books {
+		authors, err := r.getBookAuthors(export.Books[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load authors for book %s: %w", export.Books[i].ID, err)
+		}
+		for _, author := range authors {
+			export.Books[i].Authors = append(export.Books[i].Authors, author.Name)
+		}
+	}
+	return export, nil
+}
+
+// ImportShelf creates a shelf of a user from an exported one. Books are
+// looked up by ID, then by title and author, since IDs differ between
+// libraries built from different collections.
+func (r *Repository) ImportShelf(userID string, export *ShelfExport) (*ShelfImport, error) {
+	shelf, err := r.CreateShelf(userID, export.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &ShelfImport{Shelf: shelf, Missing: []ShelfExportBook{}}
+	for _, book := range export.Books {
+		id, err := findShelfBook(tx, book)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			result.Missing = append(result.Missing, book)
+			continue
+		}
+		if err := addShelfBookTx(tx, shelf.ID, id); err != nil {
+			return nil, err
+		}
+		result.Added++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit shelf: %w", err)
+	}
+	r.InvalidateQueryCache()
+
+	shelf.BookCount = result.Added
+	return result, nil
+}
+
+// findShelfBook returns the ID of the library book matching an exported
+// one, or "" if there is none
+func findShelfBook(tx *sql.Tx, book ShelfExportBook) (string, error) {
+	if book.ID != "" {
+		if err := checkBookExists(tx, book.ID); err == nil {
+			return book.ID, nil
+		} else if !errors.Is(err, ErrBookNotFound) {
+			return "", err
+		}
+	}
+	if book.Title == "" {
+		return "", nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT b.id, a.name FROM books b
+		LEFT JOIN book_authors ba ON ba.book_id = b.id
+		LEFT JOIN authors a ON a.id = ba.author_id
+		WHERE b.title = ?
+		ORDER BY b.id`, book.Title)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up shelf book: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var author sql.NullString
+		if err := rows.Scan(&id, &author); err != nil {
+			return "", fmt.Errorf("failed to scan shelf book: %w", err)
+		}
+		if len(book.Authors) == 0 {
+			return id, nil
+		}
+		for _, name := range book.Authors {
+			if strings.EqualFold(name, author.String) {
+				return id, nil
+			}
+		}
+	}
+	return "", rows.Err()
+}
@@ -0,0 +1,129 @@
+package storage_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestShelves(t *testing.T) {
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	repo := storage.NewRepository(db)
+
+	var books []inpx.Book
+	for _, id := range []string{"s-1", "s-2", "s-3"} {
+		books = append(books, inpx.Book{ID: id, Title: "Книга " + id, Authors: []string{"Автор"}, Format: "fb2", Date: time.Now()})
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	shelf, err := repo.CreateShelf("user-1", "Отпуск")
+	if err != nil {
+		t.Fatalf("CreateShelf failed: %v", err)
+	}
+	for _, id := range []string{"s-3", "s-1", "s-3"} {
+		if err := repo.AddShelfBook(shelf.ID, id); err != nil {
+			t.Fatalf("AddShelfBook(%s) failed: %v", id, err)
+		}
+	}
+	if err := repo.AddShelfBook(shelf.ID, "nope"); err != storage.ErrBookNotFound {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+
+	onShelf := func(id string) []string {
+		t.Helper()
+		result, err := repo.SearchBooks(storage.BookFilter{Shelf: id, SortBy: "shelf"})
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		var ids []string
+		for _, book := range result.Books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+	if got := onShelf(shelf.ID); !reflect.DeepEqual(got, []string{"s-3", "s-1"}) {
+		t.Errorf("shelf books = %v, want [s-3 s-1]", got)
+	}
+
+	shelves, err := repo.ListShelves("user-1")
+	if err != nil || len(shelves) != 1 || shelves[0].BookCount != 2 {
+		t.Errorf("ListShelves = %+v, %v; want one shelf with 2 books", shelves, err)
+	}
+	if shelves, _ := repo.ListShelves("user-2"); len(shelves) != 0 {
+		t.Errorf("expected no shelves of another user, got %+v", shelves)
+	}
+
+	export, err := repo.ExportShelf(shelf.ID)
+	if err != nil {
+		t.Fatalf("ExportShelf failed: %v", err)
+	}
+	if export.Name != "Отпуск" || len(export.Books) != 2 || export.Books[0].Title != "Книга s-3" ||
+		!reflect.DeepEqual(export.Books[0].Authors, []string{"Автор"}) {
+		t.Errorf("unexpected export %+v", export)
+	}
+
+	// A book unknown by ID is found by title and author
+	export.Books[0].ID = "elsewhere"
+	export.Books = append(export.Books, storage.ShelfExportBook{ID: "gone", Title: "Пропавшая"})
+	imported, err := repo.ImportShelf("user-2", export)
+	if err != nil {
+		t.Fatalf("ImportShelf failed: %v", err)
+	}
+	if imported.Added != 2 || len(imported.Missing) != 1 || imported.Missing[0].ID != "gone" {
+		t.Errorf("unexpected import %+v", imported)
+	}
+	if got := onShelf(imported.Shelf.ID); !reflect.DeepEqual(got, []string{"s-3", "s-1"}) {
+		t.Errorf("imported shelf books = %v, want [s-3 s-1]", got)
+	}
+
+	if removed, err := repo.RemoveShelfBook(shelf.ID, "s-3"); err != nil || !removed {
+		t.Errorf("RemoveShelfBook = %v, %v", removed, err)
+	}
+	if got := onShelf(shelf.ID); !reflect.DeepEqual(got, []string{"s-1"}) {
+		t.Errorf("shelf books = %v, want [s-1]", got)
+	}
+
+	if repo.ValidShelfToken(shelf.ID, "") {
+		t.Error("empty token accepted for a shelf that is not shared")
+	}
+	token, err := repo.ShelfToken(shelf.ID)
+	if err != nil {
+		t.Fatalf("ShelfToken failed: %v", err)
+	}
+	if again, _ := repo.ShelfToken(shelf.ID); again != token {
+		t.Errorf("expected the shelf token kept, got %q then %q", token, again)
+	}
+	if !repo.ValidShelfToken(shelf.ID, token) || repo.ValidShelfToken(imported.Shelf.ID, token) || repo.ValidShelfToken(shelf.ID, "") {
+		t.Error("shelf token accepted for the wrong shelf or rejected for its own")
+	}
+	if err := repo.RevokeShelfToken(shelf.ID); err != nil {
+		t.Fatalf("RevokeShelfToken failed: %v", err)
+	}
+	if repo.ValidShelfToken(shelf.ID, token) {
+		t.Error("revoked shelf token accepted")
+	}
+	if renewed, _ := repo.ShelfToken(shelf.ID); renewed == "" || renewed == token {
+		t.Errorf("expected a new shelf token after revoking, got %q", renewed)
+	}
+	if _, err := repo.ShelfToken("missing"); !errors.Is(err, storage.ErrShelfNotFound) {
+		t.Errorf("expected ErrShelfNotFound for an unknown shelf, got %v", err)
+	}
+
+	if deleted, err := repo.DeleteShelf(shelf.ID); err != nil || !deleted {
+		t.Errorf("DeleteShelf = %v, %v", deleted, err)
+	}
+	if got, _ := repo.GetShelf(shelf.ID); got != nil {
+		t.Errorf("expected shelf to be gone, got %+v", got)
+	}
+}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxSuggestions is the number of corrected queries offered per search
+	maxSuggestions = 3
+	// minTermLength is the shortest word (in runes) indexed and corrected
+	minTermLength = 3
+	// maxTermCandidates bounds the trigram matches compared per word
+	maxTermCandidates = 50
+)
+
+// SetSearchSuggestionsEnabled toggles "did you mean" suggestions for
+// searches that find nothing. The trigram index is only maintained while
+// suggestions are enabled.
+func (r *Repository) SetSearchSuggestionsEnabled(enabled bool) {
+	r.suggestionsEnabled.Store(enabled)
+}
+
+// SearchSuggestionsEnabled reports whether search suggestions are enabled.
+func (r *Repository) SearchSuggestionsEnabled() bool {
+	return r.suggestionsEnabled.Load()
+}
+
+// CountSearchTerms returns the number of words in the suggestion index.
+func (r *Repository) CountSearchTerms() (int, error) {
+	var count int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM search_terms").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count search terms: %w", err)
+	}
+	return count, nil
+}
+
+// RebuildSearchTerms replaces the suggestion index with the words of all
+// book titles, author names and series names. It returns the number of
+// distinct words indexed.
+func (r *Repository) RebuildSearchTerms() (int, error) {
+	weights := make(map[string]int)
+	for _, query := range []string{
+		"SELECT title FROM books",
+		"SELECT name FROM authors",
+		"SELECT name FROM series",
+	} {
+		if err := r.collectSearchTerms(query, weights); err != nil {
+			return 0, err
+		}
+	}
+
+	terms := make([]string, 0, len(weights))
+	for term := range weights {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM search_trigrams"); err != nil {
+		return 0, fmt.Errorf("failed to clear search trigrams: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM search_terms"); err != nil {
+		return 0, fmt.Errorf("failed to clear search terms: %w", err)
+	}
+
+	termRows := newMultiRowInsert(tx, "INSERT INTO search_terms (id, term, length, weight) VALUES ", 4)
+	defer termRows.close()
+	trigramRows := newMultiRowInsert(tx, "INSERT OR IGNORE INTO search_trigrams (trigram, term_id) VALUES ", 2)
+	defer trigramRows.close()
+
+	for i, term := range terms {
+		id := i + 1
+		termRows.add(id, term, utf8.RuneCountInString(term), weights[term])
+		for _, trigram := range trigrams(term) {
+			trigramRows.add(trigram, id)
+		}
+		if id%insertBatchSize == 0 {
+			if err := termRows.flush(); err != nil {
+				return 0, fmt.Errorf("failed to insert search terms: %w", err)
+			}
+			if err := trigramRows.flush(); err != nil {
+				return 0, fmt.Errorf("failed to insert search trigrams: %w", err)
+			}
+		}
+	}
+	if err := termRows.flush(); err != nil {
+		return 0, fmt.Errorf("failed to insert search terms: %w", err)
+	}
+	if err := trigramRows.flush(); err != nil {
+		return 0, fmt.Errorf("failed to insert search trigrams: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit search terms: %w", err)
+	}
+	return len(terms), nil
+}
+
+func (r *Repository) collectSearchTerms(query string, weights map[string]int) error {
+	rows, err := r.db.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to read search terms: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var text sql.NullString
+		if err := rows.Scan(&text); err != nil {
+			return fmt.Errorf("failed to scan search term source: %w", err)
+		}
+		for _, token := range tokenizeText(text.String) {
+			if isSuggestableToken(token) {
+				weights[token]++
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// SuggestQueries returns up to limit variants of query with misspelled
+// words replaced by similar words from the library. Words that exist in the
+// index, field prefixes such as "author:" and short words are kept as is.
+func (r *Repository) SuggestQueries(query string, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = maxSuggestions
+	}
+
+	type correction struct {
+		start, end int
+		candidates []string
+	}
+	var corrections []correction
+
+	for _, span := range wordSpans(query) {
+		if span[1] < len(query) && query[span[1]] == ':' {
+			continue
+		}
+		word := query[span[0]:span[1]]
+		token := strings.ToLower(word)
+		if !isSuggestableToken(token) {
+			continue
+		}
+
+		candidates, err := r.termCandidates(token, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		for i := range candidates {
+			candidates[i] = matchCase(word, candidates[i])
+		}
+		corrections = append(corrections, correction{start: span[0], end: span[1], candidates: candidates})
+	}
+	if len(corrections) == 0 {
+		return nil, nil
+	}
+
+	var suggestions []string
+	seen := make(map[string]bool)
+	for k := 0; k < limit; k++ {
+		var sb strings.Builder
+		last, more := 0, false
+		for _, c := range corrections {
+			i := k
+			if i >= len(c.candidates) {
+				i = len(c.candidates) - 1
+			} else {
+				more = true
+			}
+			sb.WriteString(query[last:c.start])
+			sb.WriteString(c.candidates[i])
+			last = c.end
+		}
+		if !more {
+			break
+		}
+		sb.WriteString(query[last:])
+
+		suggestion := normalizeWhitespace(sb.String())
+		if !seen[suggestion] {
+			seen[suggestion] = true
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions, nil
+}
+
+// termCandidates returns indexed words within a small edit distance of
+// token, closest and most frequent first. A token that is itself indexed
+// has no candidates.
+func (r *Repository) termCandidates(token string, limit int) ([]string, error) {
+	grams := trigrams(token)
+	length := utf8.RuneCountInString(token)
+
+	args := make([]interface{}, 0, len(grams)+2)
+	for _, g := range grams {
+		args = append(args, g)
+	}
+	args = append(args, length-2, length+2)
+
+	rows, err := r.db.db.Query(`
+		SELECT t.term, t.weight, COUNT(*) AS shared
+		FROM search_trigrams g
+		JOIN search_terms t ON t.id = g.term_id
+		WHERE g.trigram IN (`+createPlaceholders(len(grams))+`)
+		  AND t.length BETWEEN ? AND ?
+		GROUP BY t.id
+		ORDER BY shared DESC, t.weight DESC
+		LIMIT `+fmt.Sprint(maxTermCandidates), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query search trigrams: %w", err)
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		term     string
+		weight   int
+		distance int
+	}
+	var candidates []candidate
+	maxDistance := 1
+	if length > 5 {
+		maxDistance = 2
+	}
+	for rows.Next() {
+		var c candidate
+		var shared int
+		if err := rows.Scan(&c.term, &c.weight, &shared); err != nil {
+			return nil, fmt.Errorf("failed to scan search term: %w", err)
+		}
+		if c.term == token {
+			return nil, nil
+		}
+		if c.distance = editDistance(token, c.term); c.distance <= maxDistance {
+			candidates = append(candidates, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search terms: %w", err)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].weight > candidates[j].weight
+	})
+
+	var terms []string
+	for _, c := range candidates {
+		if len(terms) == limit {
+			break
+		}
+		terms = append(terms, c.term)
+	}
+	return terms, nil
+}
+
+// isSuggestableToken reports whether a lower-case token is long enough to
+// be indexed and is not a number.
+func isSuggestableToken(token string) bool {
+	if utf8.RuneCountInString(token) < minTermLength {
+		return false
+	}
+	return strings.IndexFunc(token, unicode.IsLetter) >= 0
+}
+
+// trigrams returns the distinct trigrams of a word padded with spaces, so
+// that word beginnings and endings weigh in the match.
+func trigrams(word string) []string {
+	runes := []rune(" " + word + " ")
+	seen := make(map[string]bool, len(runes))
+	var grams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		g := string(runes[i : i+3])
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// wordSpans returns the byte offsets of letter and digit runs in s.
+func wordSpans(s string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
+
+// matchCase renders a lower-case term in the letter case of original.
+func matchCase(original, term string) string {
+	if utf8.RuneCountInString(original) > 1 && original == strings.ToUpper(original) {
+		return strings.ToUpper(term)
+	}
+	if first, _ := utf8.DecodeRuneInString(original); unicode.IsUpper(first) {
+		r, size := utf8.DecodeRuneInString(term)
+		return string(unicode.ToUpper(r)) + term[size:]
+	}
+	return term
+}
+
+// editDistance is the Levenshtein distance between two strings in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
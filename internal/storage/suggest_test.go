@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func newSuggestTestRepo(t *testing.T) *Repository {
+	t.Helper()
+	repo := newSearchTestRepo(t)
+	books := []inpx.Book{
+		{ID: "q-2", Title: "Преступление и наказание", Authors: []string{"Фёдор Достоевский"}, Language: "ru", Format: "fb2", Date: time.Now()},
+		{ID: "q-3", Title: "Идиот", Authors: []string{"Фёдор Достоевский"}, Language: "ru", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	repo.SetSearchSuggestionsEnabled(true)
+	if _, err := repo.RebuildSearchTerms(); err != nil {
+		t.Fatalf("RebuildSearchTerms failed: %v", err)
+	}
+	return repo
+}
+
+// TestSuggestQueries checks typo correction keeps case, field prefixes and
+// correctly spelled words.
+func TestSuggestQueries(t *testing.T) {
+	repo := newSuggestTestRepo(t)
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"Достоевскй", []string{"Достоевский"}},
+		{"достоевскии идиот", []string{"достоевский идиот"}},
+		{"author:Толстй", []string{"author:Толстой"}},
+		{"ВАЙНА", []string{"ВОЙНА"}},
+		{"Достоевский", nil},
+		{"xyzzy", nil},
+	}
+	for _, tc := range cases {
+		got, err := repo.SuggestQueries(tc.query, maxSuggestions)
+		if err != nil {
+			t.Fatalf("SuggestQueries(%q) failed: %v", tc.query, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SuggestQueries(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+// TestSearchBooks_Suggestions verifies suggestions are only attached to
+// empty results and only while enabled.
+func TestSearchBooks_Suggestions(t *testing.T) {
+	repo := newSuggestTestRepo(t)
+
+	result, err := repo.SearchBooks(BookFilter{Query: "Достоевскй"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 0 || !reflect.DeepEqual(result.Suggestions, []string{"Достоевский"}) {
+		t.Errorf("expected no books and a suggestion, got %d books, suggestions %q", result.Total, result.Suggestions)
+	}
+
+	result, err = repo.SearchBooks(BookFilter{Query: "Достоевский"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 2 || len(result.Suggestions) != 0 {
+		t.Errorf("expected 2 books without suggestions, got %d books, suggestions %q", result.Total, result.Suggestions)
+	}
+
+	repo.SetSearchSuggestionsEnabled(false)
+	result, err = repo.SearchBooks(BookFilter{Query: "Достоевскй"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(result.Suggestions) != 0 {
+		t.Errorf("expected no suggestions while disabled, got %q", result.Suggestions)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"достоевский", "достоевскй", 1},
+		{"толстой", "толстый", 1},
+		{"мир", "мир", 0},
+		{"", "abc", 3},
+		{"война", "вайна", 1},
+	}
+	for _, tc := range cases {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
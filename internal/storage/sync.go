@@ -0,0 +1,475 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// Sync change operations
+const (
+	SyncOpUpsert = "upsert"
+	SyncOpDelete = "delete"
+)
+
+// SyncChange is one entry of the change log served to mirrors. Book is set
+// for upserts only.
+type SyncChange struct {
+	Seq    int64      `json:"seq"`
+	Op     string     `json:"op"`
+	BookID string     `json:"book_id"`
+	SHA256 string     `json:"sha256,omitempty"`
+	Book   *inpx.Book `json:"book,omitempty"`
+}
+
+// SetSyncEnabled toggles the change log used by mirrors. While disabled no
+// changes are recorded.
+func (r *Repository) SetSyncEnabled(enabled bool) {
+	r.syncEnabled.Store(enabled)
+}
+
+// SyncEnabled reports whether the change log is maintained.
+func (r *Repository) SyncEnabled() bool {
+	return r.syncEnabled.Load()
+}
+
+// syncFingerprintQuery selects every column a mirror receives, with authors
+// in a stable order, so that a hash of a row changes with any of them.
+const syncFingerprintQuery = `
+	SELECT b.id, b.title, s.name, b.series_num, ` + bookGenresExpr + `, b.year, b.language,
+	       b.file_size, b.archive_path, b.file_num, b.format, b.date_added,
+	       b.rating, ` + annotationExpr + `,
+	       (SELECT GROUP_CONCAT(name, char(31)) FROM (
+	           SELECT a.name FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+	           WHERE ba.book_id = b.id ORDER BY a.name)),
+	       ` + otherSeriesExpr + `
+	FROM books b
+	LEFT JOIN series s ON b.series_id = s.id`
+
+// RecordSyncChanges compares all books with the change log and logs an
+// upsert for every new or modified book and a delete for every book that
+// disappeared. It returns the number of changes recorded.
+func (r *Repository) RecordSyncChanges() (int, error) {
+	return r.recordSyncChanges(nil)
+}
+
+// RecordBookSyncChanges is RecordSyncChanges limited to the given books.
+func (r *Repository) RecordBookSyncChanges(ids ...string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return r.recordSyncChanges(ids)
+}
+
+func (r *Repository) recordSyncChanges(ids []string) (int, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM sync_state").Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to read sync cursor: %w", err)
+	}
+
+	type state struct {
+		fingerprint string
+		deleted     bool
+	}
+	known := make(map[string]state)
+	stateQuery := "SELECT book_id, fingerprint, deleted FROM sync_state"
+	bookQuery := syncFingerprintQuery
+	args := make([]interface{}, 0, len(ids))
+	if ids != nil {
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		stateQuery += " WHERE book_id IN (" + createPlaceholders(len(ids)) + ")"
+		bookQuery += " WHERE b.id IN (" + createPlaceholders(len(ids)) + ")"
+	} else {
+		stateQuery += " WHERE deleted = 0"
+	}
+
+	rows, err := tx.Query(stateQuery, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load sync state: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var s state
+		if err := rows.Scan(&id, &s.fingerprint, &s.deleted); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan sync state: %w", err)
+		}
+		known[id] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating sync state: %w", err)
+	}
+
+	rows, err = tx.Query(bookQuery, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fingerprint books: %w", err)
+	}
+	seen := make(map[string]bool)
+	changed := make(map[string]string)
+	columns := make([]sql.RawBytes, 16)
+	dest := make([]interface{}, len(columns))
+	for i := range columns {
+		dest[i] = &columns[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan book: %w", err)
+		}
+		h := sha1.New()
+		for _, col := range columns {
+			if col == nil {
+				h.Write([]byte{0})
+			} else {
+				h.Write(col)
+			}
+			h.Write([]byte{31})
+		}
+		id := string(columns[0])
+		fingerprint := hex.EncodeToString(h.Sum(nil))
+		seen[id] = true
+		if s, ok := known[id]; !ok || s.deleted || s.fingerprint != fingerprint {
+			changed[id] = fingerprint
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating books: %w", err)
+	}
+
+	upsert, err := tx.Prepare(
+		`INSERT INTO sync_state (book_id, fingerprint, deleted, seq, changed_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(book_id) DO UPDATE SET fingerprint = excluded.fingerprint, deleted = excluded.deleted,
+		   seq = excluded.seq, changed_at = excluded.changed_at`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare sync state update: %w", err)
+	}
+	defer upsert.Close()
+
+	count := 0
+	for id, fingerprint := range changed {
+		seq++
+		if _, err := upsert.Exec(id, fingerprint, false, seq); err != nil {
+			return 0, fmt.Errorf("failed to record change for %s: %w", id, err)
+		}
+		count++
+	}
+	for id, s := range known {
+		if seen[id] || s.deleted {
+			continue
+		}
+		seq++
+		if _, err := upsert.Exec(id, "", true, seq); err != nil {
+			return 0, fmt.Errorf("failed to record deletion of %s: %w", id, err)
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit sync changes: %w", err)
+	}
+	return count, nil
+}
+
+// ListSyncChanges returns up to limit changes after the since cursor, in
+// order. Only the latest change of each book is kept in the log.
+func (r *Repository) ListSyncChanges(since int64, limit int) ([]SyncChange, error) {
+	if limit <= 0 {
+		limit = 500
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT book_id, deleted, seq FROM sync_state WHERE seq > ? ORDER BY seq LIMIT ?`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync changes: %w", err)
+	}
+	var changes []SyncChange
+	for rows.Next() {
+		var change SyncChange
+		var deleted bool
+		if err := rows.Scan(&change.BookID, &deleted, &change.Seq); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan sync change: %w", err)
+		}
+		change.Op = SyncOpUpsert
+		if deleted {
+			change.Op = SyncOpDelete
+		}
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sync changes: %w", err)
+	}
+
+	for i := range changes {
+		if changes[i].Op != SyncOpUpsert {
+			continue
+		}
+		book, err := r.GetBookByID(changes[i].BookID)
+		if err != nil {
+			return nil, err
+		}
+		if book == nil {
+			// Deleted after the change was logged; the next scan records it
+			changes[i].Op = SyncOpDelete
+			continue
+		}
+		changes[i].SHA256 = book.SHA256
+		changes[i].Book = syncBook(book)
+	}
+	return changes, nil
+}
+
+// syncBook converts a stored book to the INPX record a mirror imports
+func syncBook(book *Book) *inpx.Book {
+	record := &inpx.Book{
+		ID:          book.ID,
+		Title:       book.Title,
+		SeriesNum:   book.SeriesNum,
+		Year:        book.Year,
+		Language:    book.Language,
+		FileSize:    book.FileSize,
+		ArchivePath: book.ArchivePath,
+		FileNum:     book.FileNum,
+		Format:      book.Format,
+		Date:        book.DateAdded,
+		Rating:      book.Rating,
+		Annotation:  book.Annotation,
+	}
+	for _, author := range book.Authors {
+		record.Authors = append(record.Authors, author.Name)
+	}
+	if book.Series != nil {
+		record.Series = book.Series.Name
+	}
+	if book.Genre != nil {
+		record.Genre = book.Genre.Name
+	}
+	for _, series := range book.OtherSeries {
+		record.OtherSeries = append(record.OtherSeries, inpx.SeriesRef{Name: series.Name, Num: series.Num})
+	}
+	return record
+}
+
+// UpsertBooks inserts or updates books in place. Unlike InsertBooks it
+// keeps rows that reference existing books, such as reading positions.
+func (r *Repository) UpsertBooks(books []inpx.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	authorCache := make(map[string]int)
+	seriesCache := make(map[string]int)
+	genreCache := make(map[string]int)
+	now := time.Now()
+
+	for _, book := range books {
+		var seriesID sql.NullInt64
+		if book.Series != "" {
+			id, err := r.getOrCreateSeriesTx(tx, book.Series, seriesCache)
+			if err != nil {
+				return fmt.Errorf("failed to upsert book %s: %w", book.ID, err)
+			}
+			seriesID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+		genreIDs, err := r.bookGenresTx(tx, book.Genre, genreCache)
+		if err != nil {
+			return fmt.Errorf("failed to upsert book %s: %w", book.ID, err)
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO books (id, title, series_id, series_num, genre_id, year, language,
+			   file_size, archive_path, file_num, format, date_added, rating, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			 ON CONFLICT(id) DO UPDATE SET title = excluded.title, series_id = excluded.series_id,
+			   series_num = excluded.series_num, genre_id = excluded.genre_id, year = excluded.year,
+			   language = excluded.language, file_size = excluded.file_size,
+			   archive_path = excluded.archive_path, file_num = excluded.file_num,
+			   format = excluded.format, date_added = excluded.date_added, rating = excluded.rating,
+			   updated_at = excluded.updated_at`,
+			book.ID, book.Title, seriesID, book.SeriesNum, mainGenreID(genreIDs), book.Year, book.Language,
+			book.FileSize, book.ArchivePath, book.FileNum, book.Format, book.Date, book.Rating,
+			now,
+		); err != nil {
+			return fmt.Errorf("failed to upsert book %s: %w", book.ID, err)
+		}
+		if err := setBookAnnotationTx(tx, book.ID, book.Annotation); err != nil {
+			return fmt.Errorf("failed to upsert annotation of %s: %w", book.ID, err)
+		}
+
+		if _, err := tx.Exec("DELETE FROM book_authors WHERE book_id = ?", book.ID); err != nil {
+			return fmt.Errorf("failed to clear authors of %s: %w", book.ID, err)
+		}
+		for _, name := range book.Authors {
+			if name == "" {
+				continue
+			}
+			authorID, err := r.getOrCreateAuthorTx(tx, name, authorCache)
+			if err != nil {
+				return fmt.Errorf("failed to upsert book %s: %w", book.ID, err)
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO book_authors (book_id, author_id) VALUES (?, ?)", book.ID, authorID); err != nil {
+				return fmt.Errorf("failed to link author of %s: %w", book.ID, err)
+			}
+		}
+
+		otherSeries, err := r.otherSeriesLinksTx(tx, book, seriesCache)
+		if err != nil {
+			return fmt.Errorf("failed to upsert book %s: %w", book.ID, err)
+		}
+		if err := setOtherSeriesTx(tx, book.ID, otherSeries); err != nil {
+			return fmt.Errorf("failed to link series of %s: %w", book.ID, err)
+		}
+		if err := setBookGenresTx(tx, book.ID, genreIDs); err != nil {
+			return fmt.Errorf("failed to link genres of %s: %w", book.ID, err)
+		}
+		if err := setBookDateTx(tx, book, now); err != nil {
+			return fmt.Errorf("failed to date book %s: %w", book.ID, err)
+		}
+
+		if err := refreshBookFTSTx(tx, book.ID); err != nil {
+			return fmt.Errorf("failed to index book %s: %w", book.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit books: %w", err)
+	}
+	return nil
+}
+
+// existingIDsBatch bounds the number of placeholders of one lookup query
+const existingIDsBatch = 500
+
+// ExistingBookIDs returns which of ids belong to books in the catalog.
+func (r *Repository) ExistingBookIDs(ids []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for start := 0; start < len(ids); start += existingIDsBatch {
+		batch := ids[start:min(start+existingIDsBatch, len(ids))]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		rows, err := r.db.db.Query("SELECT id FROM books WHERE id IN ("+createPlaceholders(len(batch))+")", args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up books: %w", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan book id: %w", err)
+			}
+			existing[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating books: %w", err)
+		}
+	}
+	return existing, nil
+}
+
+// AllBookIDs returns the IDs of all books in the catalog.
+func (r *Repository) AllBookIDs() ([]string, error) {
+	rows, err := r.db.db.Query("SELECT id FROM books")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list books: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan book id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteBooks removes books with their author links and search entries.
+func (r *Repository) DeleteBooks(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	in := " IN (" + createPlaceholders(len(ids)) + ")"
+	for _, query := range []string{
+		"DELETE FROM book_authors WHERE book_id" + in,
+		"DELETE FROM book_series WHERE book_id" + in,
+		"DELETE FROM book_genres WHERE book_id" + in,
+		"DELETE FROM books_fts WHERE rowid IN (SELECT id FROM books_fts_ids WHERE book_id" + in + ")",
+		"DELETE FROM books_fts_ids WHERE book_id" + in,
+		"DELETE FROM book_annotations WHERE book_id" + in,
+		"DELETE FROM book_dates WHERE book_id" + in,
+		"DELETE FROM delta_books WHERE book_id" + in,
+		"DELETE FROM books WHERE id" + in,
+	} {
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete books: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit book deletion: %w", err)
+	}
+	return nil
+}
+
+// GetSyncCursor returns the last change applied from a mirror source, or 0.
+func (r *Repository) GetSyncCursor(source string) (int64, error) {
+	var cursor int64
+	err := r.db.db.QueryRow("SELECT cursor FROM sync_cursors WHERE source = ?", source).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sync cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SaveSyncCursor stores the last change applied from a mirror source.
+func (r *Repository) SaveSyncCursor(source string, cursor int64) error {
+	if _, err := r.db.db.Exec(
+		`INSERT INTO sync_cursors (source, cursor, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(source) DO UPDATE SET cursor = excluded.cursor, updated_at = excluded.updated_at`,
+		source, cursor,
+	); err != nil {
+		return fmt.Errorf("failed to save sync cursor: %w", err)
+	}
+	return nil
+}
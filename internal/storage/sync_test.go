@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+// TestRecordSyncChanges checks that only new, modified and removed books
+// enter the change log, each with a higher sequence number.
+func TestRecordSyncChanges(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	if n, err := repo.RecordSyncChanges(); err != nil || n != 1 {
+		t.Fatalf("expected 1 initial change, got %d (%v)", n, err)
+	}
+	if n, err := repo.RecordSyncChanges(); err != nil || n != 0 {
+		t.Fatalf("expected no changes on rescan, got %d (%v)", n, err)
+	}
+
+	title := "Война и мир (т. 1)"
+	if _, err := repo.UpdateBook("q-1", BookUpdate{Title: &title}); err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	if n, err := repo.RecordBookSyncChanges("q-1"); err != nil || n != 1 {
+		t.Fatalf("expected 1 change after edit, got %d (%v)", n, err)
+	}
+
+	changes, err := repo.ListSyncChanges(1, 10)
+	if err != nil {
+		t.Fatalf("ListSyncChanges failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Seq != 2 || changes[0].Op != SyncOpUpsert || changes[0].Book == nil {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if book := changes[0].Book; book.Title != title || len(book.Authors) != 1 || book.Series != "Романы" {
+		t.Errorf("unexpected book payload: %+v", book)
+	}
+
+	if err := repo.DeleteBooks([]string{"q-1"}); err != nil {
+		t.Fatalf("DeleteBooks failed: %v", err)
+	}
+	if n, err := repo.RecordSyncChanges(); err != nil || n != 1 {
+		t.Fatalf("expected 1 deletion, got %d (%v)", n, err)
+	}
+	changes, err = repo.ListSyncChanges(2, 10)
+	if err != nil {
+		t.Fatalf("ListSyncChanges failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Op != SyncOpDelete || changes[0].Book != nil {
+		t.Fatalf("expected a delete, got %+v", changes)
+	}
+}
+
+// TestUpsertBooks verifies books are updated in place, keeping reading
+// positions, and that the search index follows.
+func TestUpsertBooks(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	if err := repo.SaveReadingPosition(&ReadingPosition{BookID: "q-1", Section: 3, Progress: 0.5}); err != nil {
+		t.Fatalf("SaveReadingPosition failed: %v", err)
+	}
+
+	books := []inpx.Book{
+		{ID: "q-1", Title: "Анна Каренина", Authors: []string{"Лев Толстой", "Редактор"}, Language: "ru", Format: "fb2", Date: time.Now()},
+		{ID: "q-2", Title: "Воскресение", Authors: []string{"Лев Толстой"}, Language: "ru", Format: "fb2", Date: time.Now()},
+	}
+	if err := repo.UpsertBooks(books); err != nil {
+		t.Fatalf("UpsertBooks failed: %v", err)
+	}
+
+	book, err := repo.GetBookByID("q-1")
+	if err != nil || book == nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+	if book.Title != "Анна Каренина" || len(book.Authors) != 2 || book.Series != nil {
+		t.Errorf("book not updated: %+v", book)
+	}
+	if pos, err := repo.GetReadingPosition("", "q-1"); err != nil || pos == nil || pos.Section != 3 {
+		t.Errorf("expected reading position to survive, got %+v (%v)", pos, err)
+	}
+
+	result, err := repo.SearchBooks(BookFilter{Query: "Каренина"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 1 {
+		t.Errorf("expected the new title to be searchable, got %d results", result.Total)
+	}
+	result, err = repo.SearchBooks(BookFilter{Query: "Война"})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 0 {
+		t.Errorf("expected the old title to be gone from the index, got %d results", result.Total)
+	}
+}
+
+func TestSyncCursor(t *testing.T) {
+	repo := newSearchTestRepo(t)
+
+	if cursor, err := repo.GetSyncCursor("http://a"); err != nil || cursor != 0 {
+		t.Fatalf("expected 0 for unknown source, got %d (%v)", cursor, err)
+	}
+	for _, want := range []int64{5, 9} {
+		if err := repo.SaveSyncCursor("http://a", want); err != nil {
+			t.Fatalf("SaveSyncCursor failed: %v", err)
+		}
+		if cursor, err := repo.GetSyncCursor("http://a"); err != nil || cursor != want {
+			t.Errorf("expected %d, got %d (%v)", want, cursor, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrTagNotFound is returned when a tag does not exist.
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrTagExists is returned when a tag with the same name already exists.
+	ErrTagExists = errors.New("tag already exists")
+)
+
+// ListTags returns a paginated list of tags with the number of tagged books.
+func (r *Repository) ListTags(limit, offset int) ([]Tag, int, error) {
+	return r.ListVisibleTags(limit, offset, nil)
+}
+
+// ListVisibleTags returns a paginated list of the tags not hidden by
+// restrictions, with the number of tagged books.
+func (r *Repository) ListVisibleTags(limit, offset int, hidden *Restrictions) ([]Tag, int, error) {
+	return r.ListTagsInLanguage("", limit, offset, hidden)
+}
+
+// ListTagsInLanguage returns a paginated list of the tags of books in
+// language with the number of those books, or of all tags if language is
+// empty. Tags hidden by restrictions are left out.
+func (r *Repository) ListTagsInLanguage(language string, limit, offset int, hidden *Restrictions) ([]Tag, int, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if hidden != nil && len(hidden.Tags) > 0 {
+		conditions = append(conditions, "name NOT IN ("+createPlaceholders(len(hidden.Tags))+")")
+		for _, tag := range hidden.Tags {
+			args = append(args, tag)
+		}
+	}
+	if language != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM book_tags bt JOIN books b ON b.id = bt.book_id
+			WHERE bt.tag_id = tags.id AND b.language = ?)`)
+		args = append(args, language)
+	}
+	where, bookJoin := "", ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	joinArgs := []interface{}{}
+	if language != "" {
+		bookJoin = " AND b.language = ?"
+		joinArgs = append(joinArgs, language)
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT t.id, t.name, COUNT(b.id)
+		 FROM tags t
+		 LEFT JOIN book_tags bt ON bt.tag_id = t.id
+		 LEFT JOIN books b ON b.id = bt.book_id`+bookJoin+
+			strings.NewReplacer("name NOT IN", "t.name NOT IN", "tags.id", "t.id").Replace(where)+`
+		 GROUP BY t.id
+		 ORDER BY LOWER(t.name)
+		 LIMIT ? OFFSET ?`,
+		append(append(joinArgs, args...), limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.BookCount); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM tags"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count tags: %w", err)
+	}
+
+	return tags, total, nil
+}
+
+// GetTagByID returns a tag by ID, or nil if not found.
+func (r *Repository) GetTagByID(tagID int) (*Tag, error) {
+	var tag Tag
+	err := r.db.db.QueryRow(
+		`SELECT t.id, t.name,
+		        (SELECT COUNT(*) FROM book_tags bt JOIN books b ON b.id = bt.book_id WHERE bt.tag_id = t.id)
+		 FROM tags t WHERE t.id = ?`, tagID,
+	).Scan(&tag.ID, &tag.Name, &tag.BookCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tag %d: %w", tagID, err)
+	}
+	return &tag, nil
+}
+
+// CreateTag creates a new tag.
+func (r *Repository) CreateTag(name string) (*Tag, error) {
+	name = strings.TrimSpace(name)
+	result, err := r.db.db.Exec("INSERT INTO tags (name) VALUES (?)", name)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return nil, ErrTagExists
+		}
+		return nil, fmt.Errorf("insert tag: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("insert tag: %w", err)
+	}
+
+	return &Tag{ID: int(id), Name: name}, nil
+}
+
+// RenameTag changes the name of a tag.
+func (r *Repository) RenameTag(tagID int, name string) error {
+	result, err := r.db.db.Exec("UPDATE tags SET name = ? WHERE id = ?", strings.TrimSpace(name), tagID)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return ErrTagExists
+		}
+		return fmt.Errorf("rename tag: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// DeleteTag deletes a tag and removes it from all books.
+func (r *Repository) DeleteTag(tagID int) error {
+	if _, err := r.db.db.Exec("DELETE FROM book_tags WHERE tag_id = ?", tagID); err != nil {
+		return fmt.Errorf("delete book tags: %w", err)
+	}
+	result, err := r.db.db.Exec("DELETE FROM tags WHERE id = ?", tagID)
+	if err != nil {
+		return fmt.Errorf("delete tag: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// AddBookTag attaches a tag to a book. Adding an existing tag is a no-op.
+func (r *Repository) AddBookTag(bookID string, tagID int) error {
+	var exists int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		return fmt.Errorf("check book: %w", err)
+	}
+	if exists == 0 {
+		return ErrBookNotFound
+	}
+
+	tag, err := r.GetTagByID(tagID)
+	if err != nil {
+		return err
+	}
+	if tag == nil {
+		return ErrTagNotFound
+	}
+
+	if _, err := r.db.db.Exec(
+		"INSERT OR IGNORE INTO book_tags (book_id, tag_id) VALUES (?, ?)", bookID, tagID,
+	); err != nil {
+		return fmt.Errorf("add book tag: %w", err)
+	}
+	return nil
+}
+
+// RemoveBookTag detaches a tag from a book.
+func (r *Repository) RemoveBookTag(bookID string, tagID int) error {
+	if _, err := r.db.db.Exec(
+		"DELETE FROM book_tags WHERE book_id = ? AND tag_id = ?", bookID, tagID,
+	); err != nil {
+		return fmt.Errorf("remove book tag: %w", err)
+	}
+	return nil
+}
+
+// getBookTags gets all tags for a book
+func (r *Repository) getBookTags(bookID string) ([]Tag, error) {
+	rows, err := r.db.db.Query(`
+		SELECT t.id, t.name
+		FROM tags t
+		JOIN book_tags bt ON t.id = bt.tag_id
+		WHERE bt.book_id = ?
+		ORDER BY LOWER(t.name)`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
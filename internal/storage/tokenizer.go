@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultFTSTokenizer folds case and Latin diacritics, so "café" matches
+// "cafe". "ё" is folded to "е" before indexing whatever the tokenizer.
+const DefaultFTSTokenizer = "unicode61 remove_diacritics 2"
+
+// ErrInvalidTokenizer is returned for a tokenizer SetFTSTokenizer does not
+// accept.
+var ErrInvalidTokenizer = errors.New("invalid FTS tokenizer")
+
+// ftsTokenizers are the tokenizers SetFTSTokenizer accepts. icu needs an
+// SQLite build with the ICU tokenizer registered for FTS5.
+var ftsTokenizers = map[string]bool{
+	"unicode61": true,
+	"ascii":     true,
+	"porter":    true,
+	"trigram":   true,
+	"icu":       true,
+}
+
+// tokenizerArg matches one tokenizer argument: a bare word or a double
+// quoted string such as the tokenchars of unicode61
+var tokenizerArg = regexp.MustCompile(`^([A-Za-z0-9_]+|"[^"']*")$`)
+
+// tokenizeClause extracts the tokenize option from CREATE VIRTUAL TABLE
+var tokenizeClause = regexp.MustCompile(`tokenize\s*=\s*'([^']*)'`)
+
+// booksFTSIDs gives books an entry in books_fts_ids, whose id is their
+// rowid in books_fts. books_fts keeps no copy of the text it indexes, so
+// the book of a match is looked up there.
+const booksFTSIDs = "INSERT OR IGNORE INTO books_fts_ids (book_id) SELECT b.id FROM books b"
+
+// booksFTSPopulate fills books_fts from the catalog for books that have an
+// entry in books_fts_ids; add a WHERE clause on b to index some books only
+var booksFTSPopulate = `
+	INSERT INTO books_fts (rowid, title, annotation, authors, series)
+	SELECT fi.id, ` + foldSearchSQL("b.title") + `, ` + foldSearchSQL("COALESCE("+annotationExpr+", '')") + `,
+	       ` + foldSearchSQL(`COALESCE((SELECT group_concat(a.name, ' ')
+	                 FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+	                 WHERE ba.book_id = b.id), '')`) + `,
+	       ` + foldSearchSQL("COALESCE(s.name, '')") + `
+	FROM books b
+	JOIN books_fts_ids fi ON fi.book_id = b.id
+	LEFT JOIN series s ON s.id = b.series_id`
+
+// booksFTSColumns are the columns and options of books_fts. It is
+// contentless: the indexed text already is in books, book_annotations,
+// authors and series, and storing it again would about double the size of
+// the database. contentless_delete lets entries be deleted by rowid alone.
+const booksFTSColumns = "title, annotation, authors, series, content='', contentless_delete=1"
+
+// indexBooksTx adds the books matching where, a WHERE clause on b, to
+// books_fts. Their old entries must have been deleted.
+func indexBooksTx(tx *sql.Tx, where string, args ...interface{}) error {
+	if _, err := tx.Exec(booksFTSIDs+where, args...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(booksFTSPopulate+where, args...)
+	return err
+}
+
+// deleteBooksFTSTx removes the books with the given IDs from books_fts
+func deleteBooksFTSTx(tx *sql.Tx, ids []interface{}) error {
+	_, err := tx.Exec("DELETE FROM books_fts WHERE rowid IN (SELECT id FROM books_fts_ids WHERE book_id IN ("+
+		createPlaceholders(len(ids))+"))", ids...)
+	return err
+}
+
+// clearBooksFTSTx empties books_fts and books_fts_ids
+func clearBooksFTSTx(tx *sql.Tx) error {
+	if _, err := tx.Exec("INSERT INTO books_fts (books_fts) VALUES ('delete-all')"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM books_fts_ids")
+	return err
+}
+
+// ftsTables are the full-text tables with their columns and the query that
+// fills them from the tables they index
+var ftsTables = []struct {
+	name     string
+	columns  string
+	populate string
+}{
+	{
+		name:     "books_fts",
+		columns:  booksFTSColumns,
+		populate: booksFTSIDs + ";\n" + booksFTSPopulate,
+	},
+	{
+		name:    "upstream_entries_fts",
+		columns: "source_id UNINDEXED, key UNINDEXED, title, annotation, authors, series",
+		populate: `
+			INSERT INTO upstream_entries_fts (source_id, key, title, annotation, authors, series)
+			SELECT e.source_id, e.key, ` + foldSearchSQL("e.title") + `, ` + foldSearchSQL("COALESCE(e.summary, '')") + `,
+			       ` + foldSearchSQL("COALESCE((SELECT group_concat(value, ' ') FROM json_each(e.authors)), '')") + `, ''
+			FROM upstream_entries e`,
+	},
+}
+
+// normalizeTokenizer validates a tokenizer such as
+// "unicode61 remove_diacritics 2" and collapses its whitespace
+func normalizeTokenizer(tokenizer string) (string, error) {
+	fields := strings.Fields(tokenizer)
+	if len(fields) == 0 {
+		return DefaultFTSTokenizer, nil
+	}
+	if !ftsTokenizers[strings.ToLower(fields[0])] {
+		return "", fmt.Errorf("%w: unknown tokenizer %q", ErrInvalidTokenizer, fields[0])
+	}
+	for _, arg := range fields[1:] {
+		if !tokenizerArg.MatchString(arg) {
+			return "", fmt.Errorf("%w: bad argument %q", ErrInvalidTokenizer, arg)
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// FTSTokenizer returns the tokenizer the book search index was built with
+func (d *Database) FTSTokenizer() (string, error) {
+	var ddl string
+	if err := d.db.QueryRow(
+		"SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'books_fts'",
+	).Scan(&ddl); err != nil {
+		return "", fmt.Errorf("failed to read books_fts definition: %w", err)
+	}
+	if m := tokenizeClause.FindStringSubmatch(ddl); m != nil {
+		return strings.Join(strings.Fields(m[1]), " "), nil
+	}
+	// FTS5 defaults to unicode61 when no tokenizer is given
+	return "unicode61", nil
+}
+
+// SetFTSTokenizer makes the full-text indexes use tokenizer, an FTS5
+// tokenizer with its arguments, or DefaultFTSTokenizer if it is empty.
+// Indexes built with another tokenizer are dropped and rebuilt from the
+// catalog, which takes a while on large libraries; it reports whether they
+// were.
+func (d *Database) SetFTSTokenizer(tokenizer string) (bool, error) {
+	tokenizer, err := normalizeTokenizer(tokenizer)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := d.FTSTokenizer()
+	if err != nil {
+		return false, err
+	}
+	if current == tokenizer {
+		return false, nil
+	}
+
+	if err := d.RebuildFTS(tokenizer); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RebuildFTS drops the full-text indexes and builds them again from the
+// catalog with tokenizer, or DefaultFTSTokenizer if it is empty. Indexes
+// of older releases are rebuilt in the current layout. Run VACUUM
+// afterwards to return the freed pages to the file system.
+func (d *Database) RebuildFTS(tokenizer string) error {
+	tokenizer, err := normalizeTokenizer(tokenizer)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range ftsTables {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table.name); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", table.name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, tokenize='%s')",
+			table.name, table.columns, tokenizer)); err != nil {
+			return fmt.Errorf("failed to create %s with tokenizer %q: %w", table.name, tokenizer, err)
+		}
+		if _, err := tx.Exec(table.populate); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %w", table.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit full-text index rebuild: %w", err)
+	}
+	return nil
+}
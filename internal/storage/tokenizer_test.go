@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/inpx"
+)
+
+func TestSetFTSTokenizer(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := NewRepository(db)
+	books := []inpx.Book{
+		{ID: "b-1", Title: "Королёв", Authors: []string{"Ярослав Голованов"}},
+		{ID: "b-2", Title: "Café society", Authors: []string{"Émile Zola"}},
+	}
+	for i := range books {
+		books[i].Genre, books[i].Language, books[i].Format, books[i].Date = "prose", "ru", "fb2", time.Now()
+	}
+	if err := repo.InsertBooks(books); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+
+	count := func(query string) int {
+		t.Helper()
+		result, err := repo.SearchBooks(BookFilter{Query: query, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks(%q): %v", query, err)
+		}
+		return result.Total
+	}
+
+	if tokenizer, err := db.FTSTokenizer(); err != nil || tokenizer != DefaultFTSTokenizer {
+		t.Fatalf("FTSTokenizer = %q, %v", tokenizer, err)
+	}
+	if rebuilt, err := db.SetFTSTokenizer(""); err != nil || rebuilt {
+		t.Fatalf("SetFTSTokenizer(default) = %v, %v; want no rebuild", rebuilt, err)
+	}
+	if count("Королев") != 1 || count("Королёв") != 1 || count("cafe") != 1 || count("emile") != 1 {
+		t.Error("expected ё and Latin diacritics to be folded with the default tokenizer")
+	}
+
+	rebuilt, err := db.SetFTSTokenizer("unicode61  remove_diacritics 0")
+	if err != nil || !rebuilt {
+		t.Fatalf("SetFTSTokenizer(remove_diacritics 0) = %v, %v; want rebuild", rebuilt, err)
+	}
+	if tokenizer, _ := db.FTSTokenizer(); tokenizer != "unicode61 remove_diacritics 0" {
+		t.Errorf("FTSTokenizer = %q after rebuild", tokenizer)
+	}
+	repo.InvalidateQueryCache()
+	if count("cafe") != 0 || count("café") != 1 {
+		t.Error("expected diacritics to matter after rebuilding with remove_diacritics 0")
+	}
+	if count("Королев") != 1 || count("Королёв") != 1 || count("Голованов") != 1 {
+		t.Error("expected ё to be folded after rebuilding with remove_diacritics 0")
+	}
+
+	for _, bad := range []string{"unknown", "unicode61 x'); DROP TABLE books; --"} {
+		if _, err := db.SetFTSTokenizer(bad); !errors.Is(err, ErrInvalidTokenizer) {
+			t.Errorf("SetFTSTokenizer(%q) = %v, want ErrInvalidTokenizer", bad, err)
+		}
+	}
+}
+
+func TestBooksFTSLayoutMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	repo := NewRepository(db)
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "f-1", Title: "Café society", Authors: []string{"Автор"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+		{ID: "f-2", Title: "Мёртвые души", Authors: []string{"Гоголь"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to insert books: %v", err)
+	}
+	db.Close()
+
+	// Bring back the layout of older releases, which stored the indexed
+	// text in books_fts next to the book ID
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		"DROP TABLE books_fts",
+		"DROP TABLE books_fts_ids",
+		`CREATE VIRTUAL TABLE books_fts USING fts5(book_id UNINDEXED, title, annotation, authors, series,
+			tokenize='unicode61 remove_diacritics 0')`,
+		"INSERT INTO books_fts (book_id, title, annotation, authors, series) VALUES ('f-1', 'café society', '', 'автор', '')",
+	} {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	raw.Close()
+
+	db, err = NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo = NewRepository(db)
+
+	if db.columnExists("books_fts", "book_id") {
+		t.Fatal("expected books_fts to be rebuilt without a copy of the text")
+	}
+	if tokenizer, _ := db.FTSTokenizer(); tokenizer != "unicode61 remove_diacritics 0" {
+		t.Errorf("FTSTokenizer = %q after migration, want the tokenizer of the old index", tokenizer)
+	}
+
+	count := func(query string) int {
+		t.Helper()
+		repo.InvalidateQueryCache()
+		result, err := repo.SearchBooks(BookFilter{Query: query, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchBooks(%q): %v", query, err)
+		}
+		return result.Total
+	}
+	if count("café") != 1 || count("души") != 1 || count("гоголь") != 1 {
+		t.Error("expected every book to be found after migration")
+	}
+
+	if err := repo.InsertBooks([]inpx.Book{
+		{ID: "f-2", Title: "Тарас Бульба", Authors: []string{"Гоголь"}, Genre: "prose", Format: "fb2", Date: time.Now()},
+	}); err != nil {
+		t.Fatalf("failed to update book: %v", err)
+	}
+	if count("души") != 0 || count("бульба") != 1 || count("гоголь") != 1 {
+		t.Error("expected a reimported book to be found by its new title only")
+	}
+
+	if err := repo.DeleteBooks([]string{"f-1"}); err != nil {
+		t.Fatalf("DeleteBooks failed: %v", err)
+	}
+	if count("café") != 0 {
+		t.Error("expected a deleted book to leave the index")
+	}
+
+	if err := db.RebuildFTS(""); err != nil {
+		t.Fatalf("RebuildFTS failed: %v", err)
+	}
+	if count("бульба") != 1 || count("cafe") != 0 {
+		t.Error("expected RebuildFTS to index the catalog with the default tokenizer")
+	}
+}
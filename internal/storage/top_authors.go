@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// TopAuthorsLimit is the number of authors the list of the most prolific
+// authors holds, in all and in each language
+const TopAuthorsLimit = 500
+
+// topAuthorsQuery ranks the authors by their visible books, in all
+// languages ("") and in each, keeping the first TopAuthorsLimit of each
+var topAuthorsQuery = `
+	SELECT language, author_id, books FROM (
+		SELECT language, author_id, books,
+		       ROW_NUMBER() OVER (PARTITION BY language ORDER BY books DESC, author_id) AS position
+		FROM (
+			SELECT '' AS language, ba.author_id, COUNT(*) AS books
+			FROM book_authors ba JOIN books b ON b.id = ba.book_id
+			WHERE ` + visibleCondition + `
+			GROUP BY ba.author_id
+			UNION ALL
+			SELECT b.language, ba.author_id, COUNT(*)
+			FROM book_authors ba JOIN books b ON b.id = ba.book_id
+			WHERE ` + visibleCondition + ` AND COALESCE(b.language, '') != ''
+			GROUP BY b.language, ba.author_id
+		)
+	) WHERE position <= ?`
+
+// topAuthorKey is the nav_stats key of an author in the list of a language
+func topAuthorKey(language string, authorID int) string {
+	return language + ":" + strconv.Itoa(authorID)
+}
+
+// rankTopAuthors returns the nav_stats keys and book counts of the most
+// prolific authors
+func (r *Repository) rankTopAuthors() (map[string]int, error) {
+	rows, err := r.db.db.Query(topAuthorsQuery, TopAuthorsLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var language string
+		var authorID, books int
+		if err := rows.Scan(&language, &authorID, &books); err != nil {
+			return nil, err
+		}
+		counts[topAuthorKey(language, authorID)] = books
+	}
+	return counts, rows.Err()
+}
+
+// ListTopAuthors returns a page of the authors with the most books in
+// language, or in all languages if it is empty, most books first, and the
+// number of authors in the list, at most TopAuthorsLimit. The list is read
+// from the counts of the last import, or counted while they are missing.
+func (r *Repository) ListTopAuthors(language string, limit, offset int) ([]Author, int, error) {
+	page, err := cachedQuery(r, func() (listPage[Author], error) {
+		authors, total, err := r.listTopAuthors(language, limit, offset)
+		return listPage[Author]{authors, total}, err
+	}, "top_authors", language, limit, offset)
+	return page.items, page.total, err
+}
+
+func (r *Repository) listTopAuthors(language string, limit, offset int) ([]Author, int, error) {
+	if limit <= 0 {
+		limit = 30
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= TopAuthorsLimit {
+		return []Author{}, 0, nil
+	}
+	limit = min(limit, TopAuthorsLimit-offset)
+
+	if _, ok := r.navigationCount(navAuthors, ""); ok {
+		return r.precomputedTopAuthors(language, limit, offset)
+	}
+
+	bookJoin, bookArgs := bookCountJoin(language)
+	from := ` FROM book_authors ba JOIN books b ON b.id = ba.book_id` + bookJoin
+	rows, err := r.db.db.Query(
+		`SELECT a.id, a.name, COUNT(*) AS books`+from+`
+		 JOIN authors a ON a.id = ba.author_id
+		 GROUP BY a.id
+		 ORDER BY books DESC, LOWER(a.name)
+		 LIMIT ? OFFSET ?`,
+		append(append([]interface{}{}, bookArgs...), limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query top authors: %w", err)
+	}
+	authors, err := scanAuthorCounts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(DISTINCT ba.author_id)"+from, bookArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count top authors: %w", err)
+	}
+	return authors, min(total, TopAuthorsLimit), nil
+}
+
+// precomputedTopAuthors reads a page of the list of language from nav_stats
+func (r *Repository) precomputedTopAuthors(language string, limit, offset int) ([]Author, int, error) {
+	// Keys are the language, a colon and the author ID
+	prefix := language + ":"
+	length := utf8.RuneCountInString(prefix)
+
+	rows, err := r.db.db.Query(
+		`SELECT a.id, a.name, s.count
+		 FROM nav_stats s JOIN authors a ON a.id = CAST(SUBSTR(s.key, ?) AS INTEGER)
+		 WHERE s.kind = ? AND SUBSTR(s.key, 1, ?) = ?
+		 ORDER BY s.count DESC, LOWER(a.name)
+		 LIMIT ? OFFSET ?`,
+		length+1, navTopAuthors, length, prefix, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query top authors: %w", err)
+	}
+	authors, err := scanAuthorCounts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.db.db.QueryRow(
+		"SELECT COUNT(*) FROM nav_stats WHERE kind = ? AND SUBSTR(key, 1, ?) = ?",
+		navTopAuthors, length, prefix,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count top authors: %w", err)
+	}
+	return authors, total, nil
+}
+
+// scanAuthorCounts reads rows of author IDs, names and book counts
+func scanAuthorCounts(rows *sql.Rows) ([]Author, error) {
+	defer rows.Close()
+	authors := []Author{}
+	for rows.Next() {
+		var author Author
+		if err := rows.Scan(&author.ID, &author.Name, &author.BookCount); err != nil {
+			return nil, fmt.Errorf("failed to scan author: %w", err)
+		}
+		authors = append(authors, author)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating authors: %w", err)
+	}
+	return authors, nil
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const upstreamEntryColumns = `e.source_id, e.key, e.entry_id, e.title, e.authors, e.summary, e.language, e.updated, e.links`
+
+// SetUpstreamSources registers the configured external catalogs. Sources no
+// longer configured are removed together with their entries.
+func (r *Repository) SetUpstreamSources(sources []UpstreamSource) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keep := make([]interface{}, 0, len(sources))
+	for _, src := range sources {
+		if _, err := tx.Exec(
+			`INSERT INTO upstream_sources (id, title, url) VALUES (?, ?, ?)
+			 ON CONFLICT(id) DO UPDATE SET title = excluded.title, url = excluded.url`,
+			src.ID, src.Title, src.URL,
+		); err != nil {
+			return fmt.Errorf("failed to save upstream source %s: %w", src.ID, err)
+		}
+		keep = append(keep, src.ID)
+	}
+
+	where := ""
+	if len(keep) > 0 {
+		where = " WHERE source_id NOT IN (" + createPlaceholders(len(keep)) + ")"
+	}
+	for _, query := range []string{
+		"DELETE FROM upstream_entries" + where,
+		"DELETE FROM upstream_entries_fts" + where,
+		"DELETE FROM upstream_sources" + strings.Replace(where, "source_id", "id", 1),
+	} {
+		if _, err := tx.Exec(query, keep...); err != nil {
+			return fmt.Errorf("failed to remove upstream sources: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upstream sources: %w", err)
+	}
+	return nil
+}
+
+// ListUpstreamSources returns the registered external catalogs with their
+// crawl status.
+func (r *Repository) ListUpstreamSources() ([]UpstreamSource, error) {
+	rows, err := r.db.db.Query(`SELECT id, title, url, entries, crawled_at, last_error FROM upstream_sources ORDER BY title`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upstream sources: %w", err)
+	}
+	defer rows.Close()
+
+	var sources []UpstreamSource
+	for rows.Next() {
+		src, err := scanUpstreamSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, *src)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating upstream sources: %w", err)
+	}
+	return sources, nil
+}
+
+// GetUpstreamSource returns an external catalog, or nil if it is unknown.
+func (r *Repository) GetUpstreamSource(id string) (*UpstreamSource, error) {
+	row := r.db.db.QueryRow(`SELECT id, title, url, entries, crawled_at, last_error FROM upstream_sources WHERE id = ?`, id)
+	src, err := scanUpstreamSource(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return src, err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUpstreamSource(row rowScanner) (*UpstreamSource, error) {
+	var (
+		src       UpstreamSource
+		crawledAt sql.NullTime
+		lastError sql.NullString
+	)
+	if err := row.Scan(&src.ID, &src.Title, &src.URL, &src.Entries, &crawledAt, &lastError); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan upstream source: %w", err)
+	}
+	if crawledAt.Valid {
+		src.CrawledAt = &crawledAt.Time
+	}
+	src.LastError = lastError.String
+	return &src, nil
+}
+
+// ReplaceUpstreamEntries stores the result of a crawl, replacing all
+// previous entries of the source.
+func (r *Repository) ReplaceUpstreamEntries(sourceID string, entries []UpstreamEntry) error {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM upstream_entries WHERE source_id = ?", sourceID); err != nil {
+		return fmt.Errorf("failed to clear upstream entries: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM upstream_entries_fts WHERE source_id = ?", sourceID); err != nil {
+		return fmt.Errorf("failed to clear upstream search index: %w", err)
+	}
+
+	insert := newMultiRowInsert(tx,
+		"INSERT INTO upstream_entries (source_id, key, entry_id, title, authors, summary, language, updated, links) VALUES ", 9)
+	fts := newMultiRowInsert(tx,
+		"INSERT INTO upstream_entries_fts (source_id, key, title, annotation, authors, series) VALUES ", 6)
+	defer insert.close()
+	defer fts.close()
+	for _, entry := range entries {
+		authors, err := json.Marshal(entry.Authors)
+		if err != nil {
+			return err
+		}
+		links, err := json.Marshal(entry.Links)
+		if err != nil {
+			return err
+		}
+		insert.add(sourceID, entry.Key, entry.EntryID, entry.Title, string(authors), entry.Summary, entry.Language, entry.Updated, string(links))
+		fts.add(sourceID, entry.Key, foldSearchText(entry.Title), foldSearchText(entry.Summary),
+			foldSearchText(strings.Join(entry.Authors, " ")), "")
+	}
+	if err := insert.flush(); err != nil {
+		return fmt.Errorf("failed to insert upstream entries: %w", err)
+	}
+	if err := fts.flush(); err != nil {
+		return fmt.Errorf("failed to index upstream entries: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE upstream_sources SET entries = (SELECT COUNT(*) FROM upstream_entries WHERE source_id = ?),
+		   crawled_at = ?, last_error = NULL WHERE id = ?`,
+		sourceID, time.Now(), sourceID,
+	); err != nil {
+		return fmt.Errorf("failed to update upstream source: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upstream entries: %w", err)
+	}
+	return nil
+}
+
+// SetUpstreamError records a failed crawl; previously crawled entries stay.
+func (r *Repository) SetUpstreamError(sourceID, message string) error {
+	if _, err := r.db.db.Exec("UPDATE upstream_sources SET last_error = ? WHERE id = ?", message, sourceID); err != nil {
+		return fmt.Errorf("failed to save upstream error: %w", err)
+	}
+	return nil
+}
+
+// ListUpstreamEntries returns a page of a source's entries, newest first,
+// and the total number of entries.
+func (r *Repository) ListUpstreamEntries(sourceID string, limit, offset int) ([]UpstreamEntry, int, error) {
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*) FROM upstream_entries WHERE source_id = ?", sourceID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count upstream entries: %w", err)
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT `+upstreamEntryColumns+` FROM upstream_entries e WHERE e.source_id = ?
+		 ORDER BY e.updated DESC, e.title LIMIT ? OFFSET ?`,
+		sourceID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list upstream entries: %w", err)
+	}
+	entries, err := scanUpstreamEntries(rows)
+	return entries, total, err
+}
+
+// SearchUpstreamEntries runs a full-text search over crawled entries of all
+// sources, or of one source when sourceID is set. The query syntax is the
+// one of SearchBooks; series: terms never match.
+func (r *Repository) SearchUpstreamEntries(query, sourceID string, limit, offset int) ([]UpstreamEntry, int, error) {
+	ftsQuery, _ := prepareFTSSearch(query)
+	if ftsQuery == "" {
+		return nil, 0, nil
+	}
+
+	where := "upstream_entries_fts MATCH ?"
+	args := []interface{}{ftsQuery}
+	if sourceID != "" {
+		where += " AND f.source_id = ?"
+		args = append(args, sourceID)
+	}
+	from := ` FROM upstream_entries_fts f JOIN upstream_entries e ON e.source_id = f.source_id AND e.key = f.key WHERE ` + where
+
+	var total int
+	if err := r.db.db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		if isFTSQueryError(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to count upstream matches: %w", err)
+	}
+
+	rows, err := r.db.db.Query(
+		"SELECT "+upstreamEntryColumns+from+" ORDER BY bm25(upstream_entries_fts), e.title LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search upstream entries: %w", err)
+	}
+	entries, err := scanUpstreamEntries(rows)
+	return entries, total, err
+}
+
+// CountUpstreamMatches returns the number of entries matching query per source.
+func (r *Repository) CountUpstreamMatches(query string) (map[string]int, error) {
+	counts := make(map[string]int)
+	ftsQuery, _ := prepareFTSSearch(query)
+	if ftsQuery == "" {
+		return counts, nil
+	}
+
+	rows, err := r.db.db.Query(
+		`SELECT source_id, COUNT(*) FROM upstream_entries_fts WHERE upstream_entries_fts MATCH ? GROUP BY source_id`, ftsQuery)
+	if err != nil {
+		if isFTSQueryError(err) {
+			return counts, nil
+		}
+		return nil, fmt.Errorf("failed to count upstream matches: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			sourceID string
+			count    int
+		)
+		if err := rows.Scan(&sourceID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan upstream match count: %w", err)
+		}
+		counts[sourceID] = count
+	}
+	return counts, rows.Err()
+}
+
+// GetUpstreamEntry returns a crawled entry, or nil if it does not exist.
+func (r *Repository) GetUpstreamEntry(sourceID, key string) (*UpstreamEntry, error) {
+	rows, err := r.db.db.Query(
+		`SELECT `+upstreamEntryColumns+` FROM upstream_entries e WHERE e.source_id = ? AND e.key = ?`, sourceID, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upstream entry: %w", err)
+	}
+	entries, err := scanUpstreamEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// scanUpstreamEntries reads and closes rows selected with upstreamEntryColumns
+func scanUpstreamEntries(rows *sql.Rows) ([]UpstreamEntry, error) {
+	defer rows.Close()
+
+	var entries []UpstreamEntry
+	for rows.Next() {
+		var (
+			entry                      UpstreamEntry
+			authors, summary, language sql.NullString
+			updated                    sql.NullTime
+			links                      string
+		)
+		if err := rows.Scan(&entry.SourceID, &entry.Key, &entry.EntryID, &entry.Title, &authors,
+			&summary, &language, &updated, &links); err != nil {
+			return nil, fmt.Errorf("failed to scan upstream entry: %w", err)
+		}
+		if authors.String != "" {
+			if err := json.Unmarshal([]byte(authors.String), &entry.Authors); err != nil {
+				return nil, fmt.Errorf("failed to decode authors of upstream entry %s: %w", entry.Key, err)
+			}
+		}
+		if err := json.Unmarshal([]byte(links), &entry.Links); err != nil {
+			return nil, fmt.Errorf("failed to decode links of upstream entry %s: %w", entry.Key, err)
+		}
+		entry.Summary = summary.String
+		entry.Language = language.String
+		entry.Updated = updated.Time
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating upstream entries: %w", err)
+	}
+	return entries, nil
+}
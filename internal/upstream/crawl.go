@@ -0,0 +1,225 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+// OPDS link relations the crawler cares about
+const (
+	relAcquisition = "http://opds-spec.org/acquisition"
+	relImage       = "http://opds-spec.org/image"
+	relFacet       = "http://opds-spec.org/facet"
+)
+
+// maxFeedSize bounds a single feed download
+const maxFeedSize = 16 << 20
+
+// Crawl targets: the subset of Atom and OPDS needed to index entries
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Links   []atomLink  `xml:"http://www.w3.org/2005/Atom link"`
+	Entries []atomEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type atomEntry struct {
+	ID       string `xml:"http://www.w3.org/2005/Atom id"`
+	Title    string `xml:"http://www.w3.org/2005/Atom title"`
+	Updated  string `xml:"http://www.w3.org/2005/Atom updated"`
+	Summary  string `xml:"http://www.w3.org/2005/Atom summary"`
+	Content  string `xml:"http://www.w3.org/2005/Atom content"`
+	Language string `xml:"http://purl.org/dc/terms/ language"`
+	Authors  []struct {
+		Name string `xml:"http://www.w3.org/2005/Atom name"`
+	} `xml:"http://www.w3.org/2005/Atom author"`
+	Links []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr"`
+	Type  string `xml:"type,attr"`
+	Href  string `xml:"href,attr"`
+	Title string `xml:"title,attr"`
+}
+
+// Crawl walks a source's catalog breadth-first, following navigation,
+// subsection and pagination links on the source host, and replaces the
+// stored entries of the source with every distinct book entry found. It
+// returns the number of entries stored.
+func (s *Service) Crawl(ctx context.Context, src Source) (int, error) {
+	start, err := url.Parse(src.URL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid source URL: %w", err)
+	}
+
+	queue := []string{start.String()}
+	queued := map[string]bool{start.String(): true}
+	var entries []storage.UpstreamEntry
+	seen := make(map[string]bool)
+	pages := 0
+
+	for len(queue) > 0 && pages < s.cfg.MaxPages {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		pageURL := queue[0]
+		queue = queue[1:]
+		pages++
+
+		feed, err := s.fetchFeed(ctx, src, pageURL)
+		if err != nil {
+			// The start page must load; broken subsections are skipped
+			if pages == 1 {
+				return 0, err
+			}
+			continue
+		}
+
+		links := append([]atomLink{}, feed.Links...)
+		for _, entry := range feed.Entries {
+			if e, ok := toEntry(pageURL, entry); ok {
+				// Catalogs list the same book in several sections
+				if !seen[e.Key] {
+					seen[e.Key] = true
+					entries = append(entries, e)
+				}
+				continue
+			}
+			links = append(links, entry.Links...)
+		}
+
+		for _, link := range links {
+			target, ok := followable(pageURL, start.Host, link)
+			if ok && !queued[target] {
+				queued[target] = true
+				queue = append(queue, target)
+			}
+		}
+	}
+
+	if err := s.repo.ReplaceUpstreamEntries(src.ID, entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+func (s *Service) fetchFeed(ctx context.Context, src Source, pageURL string) (*atomFeed, error) {
+	resp, err := s.get(ctx, src, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP %d", pageURL, resp.StatusCode)
+	}
+
+	var feed atomFeed
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("%s is not an Atom feed: %w", pageURL, err)
+	}
+	return &feed, nil
+}
+
+// followable reports whether a link leads to another catalog feed of the
+// source, and returns its absolute URL.
+func followable(pageURL, host string, link atomLink) (string, bool) {
+	switch link.Rel {
+	case "self", "start", "up", "prev", "previous", "first", "last", "search", relFacet:
+		return "", false
+	}
+	if link.Href == "" || strings.Contains(link.Href, "{") || !isCatalogType(link.Type) {
+		return "", false
+	}
+	target := resolve(pageURL, link.Href)
+	u, err := url.Parse(target)
+	if err != nil || u.Host != host {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+func isCatalogType(mediaType string) bool {
+	mt, params, err := mime.ParseMediaType(mediaType)
+	return err == nil && mt == "application/atom+xml" && params["profile"] == "opds-catalog"
+}
+
+// toEntry converts a feed entry with at least one acquisition link; other
+// entries are navigation.
+func toEntry(pageURL string, e atomEntry) (storage.UpstreamEntry, bool) {
+	entry := storage.UpstreamEntry{
+		EntryID:  strings.TrimSpace(e.ID),
+		Title:    strings.TrimSpace(e.Title),
+		Summary:  strings.TrimSpace(e.Summary),
+		Language: strings.TrimSpace(e.Language),
+	}
+	if entry.Summary == "" {
+		entry.Summary = strings.TrimSpace(e.Content)
+	}
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(e.Updated)); err == nil {
+		entry.Updated = t
+	} else {
+		entry.Updated = time.Now()
+	}
+	for _, author := range e.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			entry.Authors = append(entry.Authors, name)
+		}
+	}
+
+	acquisitions := 0
+	for _, link := range e.Links {
+		if link.Href == "" {
+			continue
+		}
+		isAcquisition := strings.HasPrefix(link.Rel, relAcquisition)
+		if !isAcquisition && !strings.HasPrefix(link.Rel, relImage) {
+			continue
+		}
+		if isAcquisition {
+			acquisitions++
+		}
+		entry.Links = append(entry.Links, storage.UpstreamLink{
+			Rel:   link.Rel,
+			Type:  link.Type,
+			Href:  resolve(pageURL, link.Href),
+			Title: link.Title,
+		})
+	}
+	if acquisitions == 0 || entry.Title == "" {
+		return storage.UpstreamEntry{}, false
+	}
+
+	id := entry.EntryID
+	if id == "" {
+		id = entry.Links[0].Href
+		entry.EntryID = id
+	}
+	sum := sha1.Sum([]byte(id))
+	entry.Key = hex.EncodeToString(sum[:8])
+	return entry, true
+}
+
+// resolve makes href absolute relative to the page it appeared on
+func resolve(pageURL, href string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return href
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,170 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/piligrim/pushkinlib/internal/storage"
+)
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources("Флибуста=https://flibusta.is/opds, https://u:p@books.example.org/opds/, http://books.example.org/other,https://search/opds", false)
+	if err != nil {
+		t.Fatalf("ParseSources failed: %v", err)
+	}
+
+	want := []struct {
+		id, title, url string
+		proxy          bool
+	}{
+		{"flibusta-is", "Флибуста", "https://flibusta.is/opds", false},
+		{"books-example-org", "books.example.org", "https://books.example.org/opds/", true},
+		{"books-example-org-2", "books.example.org", "http://books.example.org/other", false},
+		{"search-2", "search", "https://search/opds", false},
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("expected %d sources, got %+v", len(want), sources)
+	}
+	for i, w := range want {
+		got := sources[i]
+		if got.ID != w.id || got.Title != w.title || got.URL != w.url || got.Proxy != w.proxy {
+			t.Errorf("source %d: got %+v, want %+v", i, got, w)
+		}
+	}
+	if sources[1].user != "u" || sources[1].password != "p" {
+		t.Errorf("expected credentials to be kept, got %q/%q", sources[1].user, sources[1].password)
+	}
+
+	if _, err := ParseSources("ftp://example.org", false); err == nil {
+		t.Error("expected an error for a non-HTTP URL")
+	}
+}
+
+const (
+	navType = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	acqType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+// newTestCatalog serves a root navigation feed, a paginated acquisition
+// feed and a file, all behind Basic Auth.
+func newTestCatalog(t *testing.T) *httptest.Server {
+	t.Helper()
+	feed := func(links, entries string) string {
+		return `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/">` +
+			`<id>urn:test</id><title>Test</title><updated>2024-01-01T00:00:00Z</updated>` + links + entries + `</feed>`
+	}
+	book := func(id, title, author string) string {
+		return fmt.Sprintf(`<entry><id>%s</id><title>%s</title><updated>2024-03-01T10:00:00Z</updated>`+
+			`<author><name>%s</name></author><dc:language>ru</dc:language><summary>Описание</summary>`+
+			`<link rel="http://opds-spec.org/acquisition" type="application/epub+zip" href="/files/%s.epub"/>`+
+			`<link rel="http://opds-spec.org/image" type="image/jpeg" href="https://cdn.example.org/%s.jpg"/></entry>`,
+			id, title, author, id, id)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/opds", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feed(
+			`<link rel="search" type="`+acqType+`" href="/opds/search?q={searchTerms}"/>`,
+			`<entry><id>urn:new</id><title>Новое</title><updated>2024-01-01T00:00:00Z</updated>`+
+				`<link rel="subsection" type="`+acqType+`" href="/opds/new"/></entry>`+
+				`<entry><id>urn:ext</id><title>Elsewhere</title><updated>2024-01-01T00:00:00Z</updated>`+
+				`<link rel="subsection" type="`+navType+`" href="https://elsewhere.example.org/opds"/></entry>`)))
+	})
+	mux.HandleFunc("/opds/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(feed(`<link rel="prev" type="`+acqType+`" href="/opds/new"/>`, book("urn:b2", "Пиковая дама", "Александр Пушкин"))))
+			return
+		}
+		w.Write([]byte(feed(`<link rel="next" type="`+acqType+`" href="/opds/new?page=2"/>`,
+			book("urn:b1", "Евгений Онегин", "Александр Пушкин")+book("urn:b1", "Евгений Онегин", "Александр Пушкин"))))
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Write([]byte("epub"))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "reader" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestRepo(t *testing.T) *storage.Repository {
+	t.Helper()
+	db, err := storage.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return storage.NewRepository(db)
+}
+
+// TestCrawl checks that book entries are collected across pagination with
+// absolute links, duplicates dropped and other hosts not followed.
+func TestCrawl(t *testing.T) {
+	server := newTestCatalog(t)
+	sources, err := ParseSources("Тест="+server.URL[:7]+"reader:secret@"+server.URL[7:]+"/opds", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newTestRepo(t)
+	svc := NewService(repo, sources, Config{})
+	if err := svc.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	n, err := svc.Crawl(context.Background(), sources[0])
+	if err != nil {
+		t.Fatalf("Crawl failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 distinct entries, got %d", n)
+	}
+
+	entries, total, err := repo.SearchUpstreamEntries("пушкин онегин", "", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchUpstreamEntries failed: %v", err)
+	}
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("expected one match, got %d", total)
+	}
+	entry := entries[0]
+	if entry.Title != "Евгений Онегин" || entry.Language != "ru" || len(entry.Links) != 2 ||
+		entry.Links[0].Href != server.URL+"/files/urn:b1.epub" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+
+	source, err := repo.GetUpstreamSource(sources[0].ID)
+	if err != nil || source == nil || source.Entries != 2 || source.CrawledAt == nil {
+		t.Errorf("expected crawl status to be recorded, got %+v (%v)", source, err)
+	}
+
+	resp, err := svc.Fetch(context.Background(), sources[0].ID, entry.Links[0].Href)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected authenticated fetch, got %d", resp.StatusCode)
+	}
+}
+
+// TestCrawl_Unauthorized verifies a failing start page is an error and
+// leaves stored entries alone.
+func TestCrawl_Unauthorized(t *testing.T) {
+	server := newTestCatalog(t)
+	sources, _ := ParseSources(server.URL+"/opds", false)
+	svc := NewService(newTestRepo(t), sources, Config{})
+	if _, err := svc.Crawl(context.Background(), sources[0]); err == nil {
+		t.Fatal("expected an error without credentials")
+	}
+}